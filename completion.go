@@ -0,0 +1,173 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+type flagArgument int
+
+const (
+	argNone flagArgument = iota
+	argFile
+	argRoom
+)
+
+// cliFlag describes a command-line flag. The list of flags is used for both --help and shell completions.
+type cliFlag struct {
+	Long        string
+	Short       string
+	Arg         flagArgument
+	ArgName     string
+	Description string
+}
+
+var cliFlags = []cliFlag{
+	{Long: "--help", Short: "-h", Description: "Show this help page and exit."},
+	{Long: "--version", Short: "-v", Description: "Show the gomuks version and exit."},
+	{Long: "--room", Arg: argRoom, ArgName: "<room>",
+		Description: "Room ID or alias to open on startup, or first, last or notification."},
+	{Long: "--import-session", Arg: argFile, ArgName: "<file>",
+		Description: "Import a session bundle created with /export-session."},
+}
+
+// Values other than room IDs and aliases accepted by --room.
+var startupRoomKeywords = []string{"first", "last", "notification"}
+
+var completionShells = map[string]func(w io.Writer){
+	"bash": writeBashCompletion,
+	"zsh":  writeZshCompletion,
+	"fish": writeFishCompletion,
+}
+
+func printUsage() {
+	fmt.Println("Usage: gomuks [flags]")
+	fmt.Println("       gomuks completion <bash|zsh|fish>")
+	fmt.Println()
+	fmt.Println("Flags:")
+	for _, flag := range cliFlags {
+		name := flag.Long
+		if len(flag.Short) > 0 {
+			name = flag.Short + ", " + name
+		}
+		if len(flag.ArgName) > 0 {
+			name += " " + flag.ArgName
+		}
+		fmt.Printf("  %-25s %s\n", name, flag.Description)
+	}
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Printf("  %-25s %s\n", "completion <shell>", "Print a completion script for bash, zsh or fish.")
+}
+
+func generateCompletion(args []string) {
+	if len(args) != 1 || completionShells[args[0]] == nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Usage: gomuks completion <bash|zsh|fish>")
+		os.Exit(1)
+	}
+	completionShells[args[0]](os.Stdout)
+	os.Exit(0)
+}
+
+func flagNames() []string {
+	names := make([]string, 0, len(cliFlags)*2)
+	for _, flag := range cliFlags {
+		if len(flag.Short) > 0 {
+			names = append(names, flag.Short)
+		}
+		names = append(names, flag.Long)
+	}
+	return names
+}
+
+func writeBashCompletion(w io.Writer) {
+	_, _ = fmt.Fprintln(w, "_gomuks() {")
+	_, _ = fmt.Fprintln(w, `	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+	_, _ = fmt.Fprintln(w, `	case "$prev" in`)
+	for _, flag := range cliFlags {
+		switch flag.Arg {
+		case argFile:
+			_, _ = fmt.Fprintf(w, "\t%s)\n\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n\t\treturn;;\n", flag.Long)
+		case argRoom:
+			_, _ = fmt.Fprintf(w, "\t%s)\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\treturn;;\n",
+				flag.Long, strings.Join(startupRoomKeywords, " "))
+		}
+	}
+	_, _ = fmt.Fprintf(w, "\tcompletion)\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\treturn;;\n", "bash zsh fish")
+	_, _ = fmt.Fprintln(w, "\tesac")
+	_, _ = fmt.Fprintf(w, "\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(append(flagNames(), "completion"), " "))
+	_, _ = fmt.Fprintln(w, "}")
+	_, _ = fmt.Fprintln(w, "complete -F _gomuks gomuks")
+}
+
+// zshEscape escapes text for use inside brackets in a single-quoted _arguments spec.
+func zshEscape(text string) string {
+	return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(text)
+}
+
+func writeZshCompletion(w io.Writer) {
+	_, _ = fmt.Fprintln(w, "#compdef gomuks")
+	_, _ = fmt.Fprintln(w)
+	_, _ = fmt.Fprintln(w, "if [[ $words[2] == completion ]]; then")
+	_, _ = fmt.Fprintln(w, "\t_values 'shell' bash zsh fish")
+	_, _ = fmt.Fprintln(w, "\treturn")
+	_, _ = fmt.Fprintln(w, "fi")
+	_, _ = fmt.Fprintln(w, `_arguments \`)
+	for _, flag := range cliFlags {
+		var action string
+		switch flag.Arg {
+		case argFile:
+			action = fmt.Sprintf(":%s:_files", strings.Trim(flag.ArgName, "<>"))
+		case argRoom:
+			action = fmt.Sprintf(":%s:(%s)", strings.Trim(flag.ArgName, "<>"), strings.Join(startupRoomKeywords, " "))
+		}
+		desc := zshEscape(flag.Description)
+		if len(flag.Short) > 0 {
+			_, _ = fmt.Fprintf(w, "\t'(%s %s)'{%s,%s}'[%s]%s' \\\n", flag.Short, flag.Long, flag.Short, flag.Long, desc, action)
+		} else {
+			_, _ = fmt.Fprintf(w, "\t'%s[%s]%s' \\\n", flag.Long, desc, action)
+		}
+	}
+	_, _ = fmt.Fprintln(w, "\t'1::command:((completion\\:\"Print a completion script\"))'")
+}
+
+func writeFishCompletion(w io.Writer) {
+	quote := func(text string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(text) + "'"
+	}
+	_, _ = fmt.Fprintln(w, "complete -c gomuks -f")
+	for _, flag := range cliFlags {
+		line := "complete -c gomuks -n '__fish_use_subcommand'"
+		if len(flag.Short) > 0 {
+			line += " -s " + strings.TrimPrefix(flag.Short, "-")
+		}
+		line += " -l " + strings.TrimPrefix(flag.Long, "--")
+		switch flag.Arg {
+		case argFile:
+			line += " -r -F"
+		case argRoom:
+			line += " -x -a " + quote(strings.Join(startupRoomKeywords, " "))
+		}
+		_, _ = fmt.Fprintln(w, line+" -d "+quote(flag.Description))
+	}
+	_, _ = fmt.Fprintln(w, "complete -c gomuks -n '__fish_use_subcommand' -a completion -d 'Print a completion script'")
+	_, _ = fmt.Fprintln(w, "complete -c gomuks -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'")
+}
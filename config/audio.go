@@ -0,0 +1,73 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// Audio configures the external programs used for playing audio messages and recording voice messages.
+type Audio struct {
+	// Command for playing audio. {file} is replaced with the path of the file and {start} with the position
+	// to start playing from in seconds. Defaults to mpv, or ffplay if mpv isn't installed.
+	Player []string `yaml:"player"`
+	// Command for recording voice messages with /voice. {file} is replaced with the path of the output file,
+	// which should be Ogg Opus. The recorder is stopped by writing q to its stdin like ffmpeg expects,
+	// or by interrupting it. Defaults to recording the default input device with ffmpeg.
+	Recorder []string `yaml:"recorder"`
+	// How many seconds the seek keys skip. Defaults to 5.
+	SeekSeconds int `yaml:"seek_seconds"`
+}
+
+// GetPlayer returns the command for playing audio, or nil if no player is configured or installed.
+func (audio *Audio) GetPlayer() []string {
+	if audio != nil && len(audio.Player) > 0 {
+		return audio.Player
+	} else if _, err := exec.LookPath("mpv"); err == nil {
+		return []string{"mpv", "--no-video", "--really-quiet", "--start={start}", "{file}"}
+	} else if _, err = exec.LookPath("ffplay"); err == nil {
+		return []string{"ffplay", "-nodisp", "-autoexit", "-loglevel", "quiet", "-ss", "{start}", "{file}"}
+	}
+	return nil
+}
+
+// GetRecorder returns the command for recording voice messages, or nil if there's no default for this platform.
+func (audio *Audio) GetRecorder() []string {
+	if audio != nil && len(audio.Recorder) > 0 {
+		return audio.Recorder
+	}
+	var input []string
+	switch runtime.GOOS {
+	case "linux":
+		input = []string{"-f", "pulse", "-i", "default"}
+	case "darwin":
+		input = []string{"-f", "avfoundation", "-i", ":default"}
+	default:
+		return nil
+	}
+	command := append([]string{"ffmpeg", "-loglevel", "quiet", "-y"}, input...)
+	return append(command, "-ac", "1", "-c:a", "libopus", "-b:a", "32k", "{file}")
+}
+
+func (audio *Audio) GetSeekDuration() time.Duration {
+	if audio == nil || audio.SeekSeconds <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(audio.SeekSeconds) * time.Second
+}
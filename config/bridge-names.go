@@ -0,0 +1,50 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"regexp"
+	"strings"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+func (config *Config) compileBridgeNamePatterns() {
+	for _, pattern := range config.BridgeNamePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			debug.Printf("Invalid bridge name pattern %q: %v", pattern, err)
+			continue
+		}
+		config.bridgeNameRegexes = append(config.bridgeNameRegexes, re)
+	}
+}
+
+// StripBridgeName removes the parts of the given display name that match the bridge name patterns.
+// The name is returned unchanged if nothing would be left of it.
+func (config *Config) StripBridgeName(name string) string {
+	config.bridgeNameRegexesOnce.Do(config.compileBridgeNamePatterns)
+	stripped := name
+	for _, re := range config.bridgeNameRegexes {
+		stripped = re.ReplaceAllString(stripped, "")
+	}
+	stripped = strings.TrimSpace(stripped)
+	if len(stripped) == 0 {
+		return name
+	}
+	return stripped
+}
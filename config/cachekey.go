@@ -0,0 +1,181 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"maunium.net/go/mautrix/crypto/utils"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/lib/cachecrypt"
+	"maunium.net/go/gomuks/lib/keyring"
+)
+
+const (
+	CacheEncryptionPassphrase = "passphrase"
+	CacheEncryptionKeyring    = "keyring"
+)
+
+const cacheKeyInfoFile = "cache-key.json"
+const cacheKeyIterations = 100000
+const keyringService = "gomuks"
+
+var ErrIncorrectCacheKey = errors.New("incorrect cache passphrase or key")
+
+type cacheKeyInfo struct {
+	Mode  string `json:"mode"`
+	Salt  []byte `json:"salt,omitempty"`
+	Check []byte `json:"check"`
+}
+
+func cacheKeyCheck(key []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte("gomuks cache key check"))
+	return h.Sum(nil)
+}
+
+// ReadPassphrase reads a passphrase from the given environment variable, or from stdin with echo disabled.
+func ReadPassphrase(prompt, envVar string) (string, error) {
+	passphrase := os.Getenv(envVar)
+	if len(passphrase) > 0 {
+		return passphrase, nil
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	echoOff := exec.Command("stty", "-echo")
+	echoOff.Stdin = os.Stdin
+	if echoOff.Run() == nil {
+		defer func() {
+			echoOn := exec.Command("stty", "echo")
+			echoOn.Stdin = os.Stdin
+			_ = echoOn.Run()
+			_, _ = fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (config *Config) readKeyringCacheKey() (key []byte, isNew bool, err error) {
+	var encodedKey string
+	encodedKey, err = keyring.Get(keyringService, config.CacheDir)
+	if err == nil {
+		key, err = base64.StdEncoding.DecodeString(encodedKey)
+		return
+	} else if !errors.Is(err, keyring.ErrNotFound) {
+		return
+	}
+	key = make([]byte, cachecrypt.KeySize)
+	if _, err = rand.Read(key); err != nil {
+		return
+	}
+	err = keyring.Set(keyringService, config.CacheDir, base64.StdEncoding.EncodeToString(key))
+	isNew = true
+	return
+}
+
+// LoadCacheKey sets up the cipher used for encrypting the local history and room state cache.
+//
+// If the encryption mode or key changes, the existing cache is unreadable, so it's cleared.
+func (config *Config) LoadCacheKey() error {
+	var info cacheKeyInfo
+	config.load("cache key info", config.CacheDir, cacheKeyInfoFile, &info)
+	if len(config.CacheEncryption) == 0 {
+		config.CacheCipher = nil
+		if len(info.Mode) > 0 {
+			debug.Print("Cache encryption was disabled, clearing encrypted cache")
+			config.clearCacheData()
+			_ = os.Remove(filepath.Join(config.CacheDir, cacheKeyInfoFile))
+		}
+		return nil
+	}
+
+	isNew := info.Mode != config.CacheEncryption
+	var key []byte
+	switch config.CacheEncryption {
+	case CacheEncryptionPassphrase:
+		if isNew || len(info.Salt) == 0 {
+			isNew = true
+			info.Salt = make([]byte, 16)
+			if _, err := rand.Read(info.Salt); err != nil {
+				return err
+			}
+		}
+		passphrase, err := ReadPassphrase("Cache passphrase", "GOMUKS_CACHE_PASSPHRASE")
+		if err != nil {
+			return err
+		}
+		key = utils.PBKDF2SHA512([]byte(passphrase), info.Salt, cacheKeyIterations, cachecrypt.KeySize*8)
+	case CacheEncryptionKeyring:
+		var keyringNew bool
+		var err error
+		key, keyringNew, err = config.readKeyringCacheKey()
+		if err != nil {
+			return fmt.Errorf("failed to get cache key from keyring: %w", err)
+		}
+		isNew = isNew || keyringNew
+		info.Salt = nil
+	default:
+		return fmt.Errorf("unknown cache encryption mode %q", config.CacheEncryption)
+	}
+
+	check := cacheKeyCheck(key)
+	if isNew {
+		debug.Print("Cache encryption key changed, clearing cache")
+		config.clearCacheData()
+		info.Mode = config.CacheEncryption
+		info.Check = check
+	} else if !hmac.Equal(check, info.Check) {
+		return ErrIncorrectCacheKey
+	}
+	var err error
+	config.CacheCipher, err = cachecrypt.New(key)
+	if err != nil {
+		return err
+	}
+	config.cacheKeyInfo = info
+	config.saveCacheKeyInfo()
+	return nil
+}
+
+func (config *Config) saveCacheKeyInfo() {
+	if config.CacheCipher != nil {
+		config.save("cache key info", config.CacheDir, cacheKeyInfoFile, &config.cacheKeyInfo)
+	}
+}
+
+// clearCacheData removes the history, room state and sync token, but keeps other cached data.
+func (config *Config) clearCacheData() {
+	_ = os.Remove(config.HistoryPath)
+	_ = os.Remove(config.RoomListPath)
+	_ = os.RemoveAll(config.StateDir)
+	_ = os.Remove(filepath.Join(config.CacheDir, "auth-cache.yaml"))
+	config.CreateCacheDirs()
+}
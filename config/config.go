@@ -18,10 +18,14 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
@@ -30,6 +34,7 @@ import (
 	"maunium.net/go/mautrix/pushrules"
 
 	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/lib/cachecrypt"
 	"maunium.net/go/gomuks/matrix/rooms"
 )
 
@@ -38,6 +43,11 @@ type AuthCache struct {
 	FilterID        string `yaml:"filter_id"`
 	FilterVersion   int    `yaml:"filter_version"`
 	InitialSyncDone bool   `yaml:"initial_sync_done"`
+
+	// The room that was open when gomuks was last closed.
+	LastRoom id.RoomID `yaml:"last_room"`
+	// The room of the most recent notification that was opened by clicking it.
+	LastNotificationRoom id.RoomID `yaml:"last_notification_room"`
 }
 
 type UserPreferences struct {
@@ -52,8 +62,90 @@ type UserPreferences struct {
 	DisableDownloads     bool `yaml:"disable_downloads"`
 	DisableNotifications bool `yaml:"disable_notifications"`
 	DisableShowURLs      bool `yaml:"disable_show_urls"`
+	DisablePastePreview  bool `yaml:"disable_paste_preview"`
+	DisableAnimations    bool `yaml:"disable_animations"`
+	DisableHighlighting  bool `yaml:"disable_highlighting"`
+	ShowRoomPreview      bool `yaml:"show_room_preview"`
+	ShowRoomSummary      bool `yaml:"show_room_summary"`
+	ShowLocationMaps     bool `yaml:"show_location_maps"`
+	ShowURLPreviews      bool `yaml:"show_url_previews"`
+	ColorMessageBodies   bool `yaml:"color_message_bodies"`
+
+	// Maximum number of times per second animated images are redrawn. Zero means the default of 10.
+	MaxAnimationFPS int `yaml:"max_animation_fps"`
+	// Name of the chroma style used for syntax highlighting in code blocks. Defaults to solarized-dark.
+	HighlightTheme string `yaml:"highlight_theme"`
+	// Don't make links clickable with OSC 8 escape sequences.
+	DisableHyperlinks bool `yaml:"disable_hyperlinks"`
+	// Use vim-style modal input with separate normal and insert modes.
+	VimMode bool `yaml:"vim_mode"`
+	// How messages are laid out in the timeline: DisplayModeCozy (default), DisplayModeCompact or
+	// DisplayModeGrouped. Can be overridden per room.
+	DisplayMode string `yaml:"display_mode"`
+	// In the grouped display mode, messages sent within this many minutes of the previous message from
+	// the same sender don't repeat the sender name. Zero means the default of 5 minutes.
+	GroupMinutes int `yaml:"group_minutes"`
+	// How rooms are ordered in the room list: RoomSortActivity (default), RoomSortUnread,
+	// RoomSortAlphabetical or RoomSortManual. Can be overridden per section with RoomSortOverrides.
+	RoomSort string `yaml:"room_sort"`
+}
+
+// GetGroupInterval returns how close together messages have to be to be grouped in the grouped display mode.
+func (up *UserPreferences) GetGroupInterval() time.Duration {
+	if up.GroupMinutes <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(up.GroupMinutes) * time.Minute
+}
+
+// GetRoomSort returns the default sort mode of the room list.
+func (up *UserPreferences) GetRoomSort() string {
+	if !IsRoomSortMode(up.RoomSort) {
+		return RoomSortActivity
+	}
+	return up.RoomSort
+}
+
+// GetAnimationInterval returns how often animated images are redrawn at most.
+func (up *UserPreferences) GetAnimationInterval() time.Duration {
+	if up.MaxAnimationFPS <= 0 {
+		return time.Second / 10
+	}
+	return time.Second / time.Duration(up.MaxAnimationFPS)
+}
+
+const (
+	DisplayModeCozy    = "cozy"
+	DisplayModeCompact = "compact"
+	DisplayModeGrouped = "grouped"
+)
+
+// RoomSortModes lists the room list sort modes in the order they're cycled through.
+var RoomSortModes = []string{RoomSortActivity, RoomSortUnread, RoomSortAlphabetical, RoomSortManual}
+
+const (
+	RoomSortActivity     = "activity"
+	RoomSortUnread       = "unread"
+	RoomSortAlphabetical = "alphabetical"
+	RoomSortManual       = "manual"
+)
+
+// IsRoomSortMode returns whether the given string is a valid room list sort mode.
+func IsRoomSortMode(mode string) bool {
+	for _, validMode := range RoomSortModes {
+		if mode == validMode {
+			return true
+		}
+	}
+	return false
 }
 
+const (
+	TrustShieldsIcon   = "icon"
+	TrustShieldsColor  = "color"
+	TrustShieldsHidden = "hidden"
+)
+
 // Config contains the main config of gomuks.
 type Config struct {
 	UserID      id.UserID   `yaml:"mxid"`
@@ -66,6 +158,80 @@ type Config struct {
 
 	NotifySound        bool `yaml:"notify_sound"`
 	SendToVerifiedOnly bool `yaml:"send_to_verified_only"`
+	UndoSendSeconds    int  `yaml:"undo_send_seconds"`
+	AutoForwardKeys    bool `yaml:"auto_forward_keys"`
+
+	// Don't send typing notifications in public rooms with more members than this, unless
+	// enabled for the room with /typing. Zero means no limit.
+	TypingMaxMembers int `yaml:"typing_max_members"`
+
+	// Restart the sync loop if no sync request has finished in this many minutes even though the
+	// server is reachable. Zero means the default of 5 minutes, a negative value disables the watchdog.
+	SyncWatchdogMinutes int `yaml:"sync_watchdog_minutes"`
+
+	// How to show the trust level of encrypted messages. One of "icon" (default), "color" or "hidden".
+	TrustShields string `yaml:"trust_shields"`
+
+	CacheEncryption string `yaml:"cache_encryption"`
+
+	Webhooks []Webhook `yaml:"webhooks"`
+
+	// Opt-in status message updates from an external command, see NowPlaying.
+	NowPlaying *NowPlaying `yaml:"now_playing"`
+
+	// Reverse geocoding and map tile services for location messages, see Location.
+	Location *Location `yaml:"location"`
+
+	// Limits for the local history cache, see Retention.
+	Retention *Retention `yaml:"retention"`
+	// Size limit for the downloaded media cache, see MediaCache.
+	MediaCache *MediaCache `yaml:"media_cache"`
+
+	// Event types to drop entirely, see EventBlocklist.
+	EventBlocklist *EventBlocklist `yaml:"event_blocklist"`
+
+	// IP version preference for connecting to the homeserver, see Connection.
+	Connection *Connection `yaml:"connection"`
+
+	// External command for translating outgoing messages, see Translation.
+	Translation *Translation `yaml:"translation"`
+
+	// External command for rendering complex LaTeX formulas into images, see Maths.
+	Maths *Maths `yaml:"maths"`
+
+	// Palette and per-user overrides for the colors of user names, see SenderColors.
+	SenderColors *SenderColors `yaml:"sender_colors"`
+
+	// External programs for opening media by MIME type, see MediaViewers.
+	MediaViewers MediaViewers `yaml:"media_viewers"`
+	// External programs for playing audio messages and recording voice messages, see Audio.
+	Audio *Audio `yaml:"audio"`
+
+	// Named message templates for /template.
+	Templates map[string]string `yaml:"templates"`
+
+	// Text shown as the placeholder of the empty message composer in specific rooms, e.g. posting guidelines.
+	// The special value "topic" shows the first line of the room topic, and an empty value hides the guidance
+	// set by the room in the net.maunium.gomuks.composer_guidance state event.
+	ComposerGuidance map[id.RoomID]string `yaml:"composer_guidance"`
+
+	// Per-room encryption overrides, see RoomEncryption.
+	RoomEncryption map[id.RoomID]RoomEncryption `yaml:"room_encryption"`
+
+	// Per-section overrides of the room list sort mode, keyed by tag. The empty tag is the
+	// section of rooms without tags.
+	RoomSortOverrides map[string]string `yaml:"room_sort_overrides"`
+
+	// Regular expressions whose matches are removed from display names in the timeline
+	// and member list, e.g. `\s*\(Telegram\)$` for puppets of a Telegram bridge.
+	BridgeNamePatterns []string `yaml:"bridge_name_patterns"`
+
+	// The room to open on startup: "first" (default) for the first room in the room list, "last" for
+	// the room that was open when gomuks was closed, "notification" for the room of the last clicked
+	// notification, or a room ID or alias.
+	StartupRoom string `yaml:"startup_room"`
+	// Overrides StartupRoom for this session, set with the --room command-line flag.
+	StartupRoomOverride string `yaml:"-"`
 
 	Dir          string `yaml:"-"`
 	DataDir      string `yaml:"data_dir"`
@@ -80,8 +246,39 @@ type Config struct {
 	AuthCache   AuthCache              `yaml:"-"`
 	Rooms       *rooms.RoomCache       `yaml:"-"`
 	PushRules   *pushrules.PushRuleset `yaml:"-"`
+	CacheCipher *cachecrypt.Cipher     `yaml:"-"`
 
-	nosave bool
+	cacheKeyInfo cacheKeyInfo
+	nosave       bool
+
+	bridgeNameRegexes     []*regexp.Regexp
+	bridgeNameRegexesOnce sync.Once
+}
+
+// GetSyncWatchdogTimeout returns how long the sync loop may be stalled before it's restarted, or zero if the watchdog is disabled.
+func (config *Config) GetSyncWatchdogTimeout() time.Duration {
+	if config.SyncWatchdogMinutes < 0 {
+		return 0
+	} else if config.SyncWatchdogMinutes == 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(config.SyncWatchdogMinutes) * time.Minute
+}
+
+// GetRoomSort returns the sort mode of the room list section of the given tag.
+func (config *Config) GetRoomSort(tag string) string {
+	if mode, ok := config.RoomSortOverrides[tag]; ok && IsRoomSortMode(mode) {
+		return mode
+	}
+	return config.Preferences.GetRoomSort()
+}
+
+// GetStartupRoom returns the startup room setting, taking the command-line override into account.
+func (config *Config) GetStartupRoom() string {
+	if len(config.StartupRoomOverride) > 0 {
+		return config.StartupRoomOverride
+	}
+	return config.StartupRoom
 }
 
 // NewConfig creates a config that loads data from the given directory.
@@ -131,18 +328,23 @@ func (config *Config) DeleteSession() {
 	config.AuthCache.InitialSyncDone = false
 	config.AccessToken = ""
 	config.DeviceID = ""
-	config.Rooms = rooms.NewRoomCache(config.RoomListPath, config.StateDir, config.RoomCacheSize, config.RoomCacheAge, config.GetUserID)
+	config.Rooms = rooms.NewRoomCache(config.RoomListPath, config.StateDir, config.RoomCacheSize, config.RoomCacheAge, config.GetUserID, config.CacheCipher)
 	config.PushRules = nil
 
 	config.ClearData()
 	config.Clear()
 	config.nosave = false
 	config.CreateCacheDirs()
+	config.saveCacheKeyInfo()
 }
 
 func (config *Config) LoadAll() {
 	config.Load()
-	config.Rooms = rooms.NewRoomCache(config.RoomListPath, config.StateDir, config.RoomCacheSize, config.RoomCacheAge, config.GetUserID)
+	if err := config.LoadCacheKey(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to load cache encryption key:", err)
+		os.Exit(4)
+	}
+	config.Rooms = rooms.NewRoomCache(config.RoomListPath, config.StateDir, config.RoomCacheSize, config.RoomCacheAge, config.GetUserID, config.CacheCipher)
 	config.LoadAuthCache()
 	config.LoadPushRules()
 	config.LoadPreferences()
@@ -263,7 +465,8 @@ func (config *Config) GetUserID() id.UserID {
 	return config.UserID
 }
 
-const FilterVersion = 1
+// FilterVersion must be bumped whenever the sync filter changes, so that the new filter gets uploaded.
+const FilterVersion = 6
 
 func (config *Config) SaveFilterID(_ id.UserID, filterID string) {
 	config.AuthCache.FilterID = filterID
@@ -0,0 +1,60 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"time"
+)
+
+// Values for Connection.IPVersion.
+const (
+	IPVersionAuto       = "auto"
+	IPVersionPreferIPv4 = "prefer-ipv4"
+	IPVersionPreferIPv6 = "prefer-ipv6"
+	IPVersionIPv4       = "ipv4"
+	IPVersionIPv6       = "ipv6"
+)
+
+// Connection configures how gomuks connects to the homeserver.
+type Connection struct {
+	// Which IP version to use: "auto" (default) or "prefer-ipv6" try IPv6 first and fall back to IPv4,
+	// "prefer-ipv4" tries IPv4 first, and "ipv4" or "ipv6" only use one version, e.g. on networks
+	// with broken IPv6.
+	IPVersion string `yaml:"ip_version"`
+	// How long to wait for the preferred IP version before also trying the other one, in milliseconds.
+	// Defaults to 300.
+	FallbackDelay int `yaml:"fallback_delay"`
+}
+
+func (conn *Connection) GetIPVersion() string {
+	if conn == nil {
+		return IPVersionAuto
+	}
+	switch conn.IPVersion {
+	case IPVersionPreferIPv4, IPVersionPreferIPv6, IPVersionIPv4, IPVersionIPv6:
+		return conn.IPVersion
+	default:
+		return IPVersionAuto
+	}
+}
+
+func (conn *Connection) GetFallbackDelay() time.Duration {
+	if conn == nil || conn.FallbackDelay <= 0 {
+		return 300 * time.Millisecond
+	}
+	return time.Duration(conn.FallbackDelay) * time.Millisecond
+}
@@ -0,0 +1,55 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// RoomEncryption contains local encryption overrides for a single room.
+//
+// The rotation overrides can only make the outbound session rotate more often than the room's
+// m.room.encryption event says, never less often.
+type RoomEncryption struct {
+	RotationPeriod   time.Duration `yaml:"rotation_period"`
+	RotationMessages int           `yaml:"rotation_messages"`
+	VerifiedOnly     bool          `yaml:"verified_only"`
+}
+
+// GetRoomEncryption returns the encryption overrides for the given room, or nil if there are none.
+func (config *Config) GetRoomEncryption(roomID id.RoomID) *RoomEncryption {
+	override, ok := config.RoomEncryption[roomID]
+	if !ok {
+		return nil
+	}
+	return &override
+}
+
+// Apply returns a copy of the given encryption event content with the rotation overrides applied.
+func (override *RoomEncryption) Apply(content event.EncryptionEventContent) *event.EncryptionEventContent {
+	periodMillis := override.RotationPeriod.Milliseconds()
+	if periodMillis > 0 && (content.RotationPeriodMillis == 0 || periodMillis < content.RotationPeriodMillis) {
+		content.RotationPeriodMillis = periodMillis
+	}
+	if override.RotationMessages > 0 && (content.RotationPeriodMessages == 0 || override.RotationMessages < content.RotationPeriodMessages) {
+		content.RotationPeriodMessages = override.RotationMessages
+	}
+	return &content
+}
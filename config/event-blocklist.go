@@ -0,0 +1,61 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// EventBlocklist lists event types that are dropped as soon as they're received, so they're never
+// parsed, cached or rendered. Types ending with * match any type with that prefix, e.g. `fi.mau.dummy.*`.
+type EventBlocklist struct {
+	Types []string `yaml:"types"`
+	// Per-room overrides, which take precedence over the global list.
+	Rooms map[id.RoomID]RoomEventBlocklist `yaml:"rooms"`
+}
+
+// RoomEventBlocklist adds event types to the blocklist of a single room, or lets through globally blocked types.
+type RoomEventBlocklist struct {
+	Block []string `yaml:"block"`
+	Allow []string `yaml:"allow"`
+}
+
+func matchEventType(patterns []string, evtType string) bool {
+	for _, pattern := range patterns {
+		if pattern == evtType || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(evtType, pattern[:len(pattern)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocks returns true if events of the given type should be dropped in the given room.
+func (bl *EventBlocklist) Blocks(roomID id.RoomID, evtType string) bool {
+	if bl == nil {
+		return false
+	}
+	if override, ok := bl.Rooms[roomID]; ok {
+		if matchEventType(override.Allow, evtType) {
+			return false
+		} else if matchEventType(override.Block, evtType) {
+			return true
+		}
+	}
+	return matchEventType(bl.Types, evtType)
+}
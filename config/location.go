@@ -0,0 +1,66 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+const DefaultTileURL = "https://tile.openstreetmap.org/{z}/{x}/{y}.png"
+
+// Location configures the external services used when rendering location messages.
+type Location struct {
+	// URL template for reverse geocoding coordinates into an address, with {lat} and {lon} placeholders,
+	// e.g. `https://nominatim.openstreetmap.org/reverse?format=jsonv2&lat={lat}&lon={lon}`. The response
+	// must be a JSON object with a display_name field. Reverse geocoding is disabled if empty.
+	GeocodeURL string `yaml:"geocode_url"`
+	// URL template for the map tiles of the minimap, with {z}, {x} and {y} placeholders.
+	// Defaults to the OpenStreetMap tile server.
+	TileURL string `yaml:"tile_url"`
+	// The zoom level of the minimap. Defaults to 15.
+	Zoom int `yaml:"zoom"`
+}
+
+func (loc *Location) GetGeocodeURL(lat, lon float64) string {
+	if loc == nil || len(loc.GeocodeURL) == 0 {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{lat}", strconv.FormatFloat(lat, 'f', -1, 64),
+		"{lon}", strconv.FormatFloat(lon, 'f', -1, 64),
+	).Replace(loc.GeocodeURL)
+}
+
+func (loc *Location) GetZoom() int {
+	if loc == nil || loc.Zoom <= 0 || loc.Zoom > 19 {
+		return 15
+	}
+	return loc.Zoom
+}
+
+func (loc *Location) GetTileURL(x, y, zoom int) string {
+	template := DefaultTileURL
+	if loc != nil && len(loc.TileURL) > 0 {
+		template = loc.TileURL
+	}
+	return strings.NewReplacer(
+		"{z}", strconv.Itoa(zoom),
+		"{x}", strconv.Itoa(x),
+		"{y}", strconv.Itoa(y),
+	).Replace(template)
+}
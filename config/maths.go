@@ -0,0 +1,46 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"time"
+)
+
+// Maths configures rendering LaTeX formulas (data-mx-maths) that are too complex to show as plain text,
+// e.g. `sh -c 'tex2png -'` or any other command that turns a formula into a PNG image.
+type Maths struct {
+	// The command and its arguments. The formula is written to the standard input and the PNG image is
+	// read from the output. Rendered images are cached, so each formula is only rendered once.
+	RenderCommand []string `yaml:"render_command"`
+	// How long to wait for the command in seconds. Defaults to 10.
+	Timeout int `yaml:"timeout"`
+}
+
+// GetRenderCommand returns the render command, or nil if none is configured.
+func (m *Maths) GetRenderCommand() []string {
+	if m == nil {
+		return nil
+	}
+	return m.RenderCommand
+}
+
+func (m *Maths) GetTimeout() time.Duration {
+	if m == nil || m.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(m.Timeout) * time.Second
+}
@@ -0,0 +1,34 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+// MediaCache limits the size of the downloaded media cache. When the cache grows beyond the limit,
+// the least recently used files are deleted.
+type MediaCache struct {
+	// The maximum size of the media cache in megabytes. Defaults to 1024, negative values disable the limit.
+	MaxSizeMB int `yaml:"max_size_mb"`
+}
+
+// GetMaxSize returns the maximum size of the media cache in bytes, or zero if it's unlimited.
+func (mc *MediaCache) GetMaxSize() int64 {
+	if mc == nil || mc.MaxSizeMB == 0 {
+		return 1024 * 1024 * 1024
+	} else if mc.MaxSizeMB < 0 {
+		return 0
+	}
+	return int64(mc.MaxSizeMB) * 1024 * 1024
+}
@@ -0,0 +1,47 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"strings"
+)
+
+// MediaViewers maps MIME types to the external programs used to open media with /open, e.g.
+// `image/*: [feh, --scale-down]` or `video/mp4: [mpv]`. Keys are either exact MIME types, wildcards
+// for all subtypes like `video/*`, or `*` for everything. {file} in the arguments is replaced with
+// the path of the downloaded file, and if there is no {file}, the path is added as the last argument.
+// Media without a matching program is opened with the system default (xdg-open).
+type MediaViewers map[string][]string
+
+// GetCommand returns the program for opening files of the given MIME type, or nil to use the system default.
+func (mv MediaViewers) GetCommand(mimetype string) []string {
+	mimetype = strings.ToLower(mimetype)
+	if semicolon := strings.IndexRune(mimetype, ';'); semicolon >= 0 {
+		mimetype = strings.TrimSpace(mimetype[:semicolon])
+	}
+	if command, ok := mv[mimetype]; ok && len(command) > 0 {
+		return command
+	} else if slash := strings.IndexRune(mimetype, '/'); slash > 0 {
+		if command, ok = mv[mimetype[:slash]+"/*"]; ok && len(command) > 0 {
+			return command
+		}
+	}
+	if command, ok := mv["*"]; ok && len(command) > 0 {
+		return command
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"time"
+)
+
+// NowPlaying configures setting the status message from the output of an external command,
+// e.g. `playerctl metadata --format "🎵 {{artist}} - {{title}}"` for the currently playing song.
+type NowPlaying struct {
+	// Whether the integration is enabled. Toggled at runtime with /nowplaying on|off.
+	Enabled bool `yaml:"enabled"`
+	// The command and its arguments. The first line of its output is used as the status message,
+	// empty output or a non-zero exit status clears the status message.
+	Command []string `yaml:"command"`
+	// How often to run the command in seconds. Defaults to 15.
+	Interval int `yaml:"interval"`
+	// The minimum time between status message updates in seconds. Defaults to 60.
+	MinUpdateInterval int `yaml:"min_update_interval"`
+}
+
+func (np *NowPlaying) IsEnabled() bool {
+	return np != nil && np.Enabled && len(np.Command) > 0
+}
+
+func (np *NowPlaying) GetInterval() time.Duration {
+	if np.Interval <= 0 {
+		return 15 * time.Second
+	}
+	return time.Duration(np.Interval) * time.Second
+}
+
+func (np *NowPlaying) GetMinUpdateInterval() time.Duration {
+	if np.MinUpdateInterval <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(np.MinUpdateInterval) * time.Second
+}
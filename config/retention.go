@@ -0,0 +1,54 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"time"
+)
+
+// Retention limits how much history is kept in the local history cache. Older events are pruned
+// periodically in the background and with /prune. Zero values mean no limit.
+type Retention struct {
+	// Events older than this many days are pruned.
+	MaxAgeDays int `yaml:"max_age_days"`
+	// The maximum number of events to keep per room.
+	MaxEvents int `yaml:"max_events"`
+	// The maximum total size of the stored events in megabytes. The oldest events across all rooms
+	// are pruned first. The database file itself doesn't shrink, but the freed space is reused.
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// How often to prune in minutes. Defaults to 60.
+	Interval int `yaml:"interval"`
+}
+
+func (ret *Retention) IsEnabled() bool {
+	return ret != nil && (ret.MaxAgeDays > 0 || ret.MaxEvents > 0 || ret.MaxSizeMB > 0)
+}
+
+func (ret *Retention) GetMaxAge() time.Duration {
+	return time.Duration(ret.MaxAgeDays) * 24 * time.Hour
+}
+
+func (ret *Retention) GetMaxSize() int64 {
+	return int64(ret.MaxSizeMB) * 1024 * 1024
+}
+
+func (ret *Retention) GetInterval() time.Duration {
+	if ret == nil || ret.Interval <= 0 {
+		return 60 * time.Minute
+	}
+	return time.Duration(ret.Interval) * time.Minute
+}
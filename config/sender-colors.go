@@ -0,0 +1,41 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"maunium.net/go/mautrix/id"
+)
+
+// SenderColors configures the colors of user names, which are normally picked from a palette based on
+// a hash of the user ID.
+type SenderColors struct {
+	// The palette to pick colors from: "default" for all named colors, "terminal" for the basic colors
+	// defined by the color theme of the terminal, or "custom" for the colors in Custom.
+	Palette string `yaml:"palette"`
+	// Color names or #rrggbb hex codes for the custom palette.
+	Custom []string `yaml:"custom"`
+	// Fixed colors for specific users, overriding the palette.
+	Overrides map[id.UserID]string `yaml:"overrides"`
+}
+
+// GetPalette returns the name of the palette to use, defaulting to "default".
+func (sc *SenderColors) GetPalette() string {
+	if sc == nil || len(sc.Palette) == 0 {
+		return "default"
+	}
+	return sc.Palette
+}
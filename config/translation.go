@@ -0,0 +1,52 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"time"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// Translation configures translating outgoing messages with an external command in rooms where
+// a target language has been set with /translate, e.g. `trans -brief :{lang}` from translate-shell.
+type Translation struct {
+	// The command and its arguments. {lang} in the arguments is replaced with the target language.
+	// The message is written to the standard input and the translation is read from the output.
+	Command []string `yaml:"command"`
+	// Commands to use instead of the default one in specific rooms.
+	Rooms map[id.RoomID][]string `yaml:"rooms"`
+	// How long to wait for the command in seconds. Defaults to 10.
+	Timeout int `yaml:"timeout"`
+}
+
+// GetCommand returns the translation command for the given room, or nil if none is configured.
+func (tr *Translation) GetCommand(roomID id.RoomID) []string {
+	if tr == nil {
+		return nil
+	} else if command, ok := tr.Rooms[roomID]; ok && len(command) > 0 {
+		return command
+	}
+	return tr.Command
+}
+
+func (tr *Translation) GetTimeout() time.Duration {
+	if tr == nil || tr.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(tr.Timeout) * time.Second
+}
@@ -0,0 +1,53 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+// Webhook is an external URL that matching events are POSTed to as JSON.
+//
+// An event matches if it's in one of the listed rooms, mentions the user, or contains one of the keywords.
+type Webhook struct {
+	URL      string      `yaml:"url"`
+	Mentions bool        `yaml:"mentions"`
+	Keywords []string    `yaml:"keywords"`
+	Rooms    []id.RoomID `yaml:"rooms"`
+}
+
+// Match checks if an event with the given properties should be forwarded to this webhook.
+// The returned string describes why the event matched.
+func (hook *Webhook) Match(roomID id.RoomID, body string, highlight bool) (string, bool) {
+	for _, hookRoomID := range hook.Rooms {
+		if hookRoomID == roomID {
+			return "room", true
+		}
+	}
+	if hook.Mentions && highlight {
+		return "mention", true
+	}
+	lowerBody := strings.ToLower(body)
+	for _, keyword := range hook.Keywords {
+		if len(keyword) > 0 && strings.Contains(lowerBody, strings.ToLower(keyword)) {
+			return "keyword", true
+		}
+	}
+	return "", false
+}
@@ -17,8 +17,15 @@
 package ifc
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"time"
+
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/crypto/ssss"
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
@@ -30,16 +37,135 @@ import (
 type Relation struct {
 	Type  event.RelationType
 	Event *muksevt.Event
+	// For thread messages, the event in the thread that the message replies to. If FallbackReply is set,
+	// the reply is only there for clients that don't support threads and isn't shown as a reply.
+	InReplyTo     *muksevt.Event
+	FallbackReply bool
+}
+
+// Download is a file saved to disk with MatrixContainer.DownloadToDisk.
+type Download struct {
+	ID   int
+	URI  id.ContentURI
+	File *attachment.EncryptedFile
+	Name string
+	Path string
+	// The size of the file, or -1 if the server didn't say.
+	Size     int64
+	Received int64
+	// Whether the download continued from a previous interrupted attempt.
+	Resumed  bool
+	Started  time.Time
+	Finished time.Time
+	Err      error
+}
+
+// URLPreview is the OpenGraph metadata of a web page linked in a message.
+type URLPreview struct {
+	URL         string
+	Domain      string
+	Title       string
+	Description string
+	SiteName    string
+}
+
+// Emote is a custom emoticon or sticker from an MSC2545 image pack.
+type Emote struct {
+	Shortcode string
+	URL       id.ContentURI
+	Body      string
+	Info      *event.FileInfo
+	Emoticon  bool
+	Sticker   bool
 }
 
+// EmotePack is a named set of custom emotes from a room or the user's account data.
+type EmotePack struct {
+	DisplayName string
+	Emotes      []Emote
+}
+
+// MediaCacheStats describes the contents of the downloaded media cache.
+type MediaCacheStats struct {
+	Files int
+	Size  int64
+	// The configured size limit, or zero if the cache is unlimited.
+	MaxSize int64
+	// When the least recently used file was last used.
+	LeastRecentlyUsed time.Time
+}
+
+// ConnectionInfo describes the latest connection to the homeserver.
+type ConnectionInfo struct {
+	Homeserver string
+	// The configured IP version preference.
+	IPVersion  string
+	RemoteAddr string
+	LocalAddr  string
+	Connected  time.Time
+	TLS        *tls.ConnectionState
+}
+
+// UploadProgressFunc is called during uploads with the number of bytes sent so far and the size of the file.
+type UploadProgressFunc func(sent, total int64)
+
 type UploadedMediaInfo struct {
 	*mautrix.RespMediaUpload
 	EncryptionInfo *attachment.EncryptedFile
 	MsgType        event.MessageType
 	Name           string
 	Info           *event.FileInfo
+	// Placeholder for images (MSC2448), empty for other files.
+	BlurHash string
 }
 
+// SentEvent is an entry in the log of events sent by gomuks.
+type SentEvent struct {
+	Time          time.Time  `json:"time"`
+	TransactionID string     `json:"txn_id"`
+	EventID       id.EventID `json:"event_id,omitempty"`
+	RoomID        id.RoomID  `json:"room_id"`
+	Type          event.Type `json:"type"`
+	// One of "pending", "queued", "sent", "failed" or "discarded".
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// SearchResult is a message that matched a server-side search, along with the messages around it.
+type SearchResult struct {
+	Event  *muksevt.Event
+	Before []*muksevt.Event
+	After  []*muksevt.Event
+}
+
+// SearchResults is a page of server-side search results.
+type SearchResults struct {
+	Results []SearchResult
+	// The approximate total number of results.
+	Count int
+	// The token for fetching the next page, or an empty string if there are no more results.
+	NextBatch string
+}
+
+// ScheduledEvent is an outgoing message waiting to be sent at a later time.
+type ScheduledEvent struct {
+	TransactionID string
+	SendAt        time.Time
+	Body          string
+}
+
+// QueuedEvent is an outgoing message that couldn't be sent yet and is waiting to be retried.
+type QueuedEvent struct {
+	TransactionID string
+	Body          string
+	Attempts      int
+	LastError     string
+}
+
+// ErrEventQueued is returned by MatrixContainer.SendEvent when the event couldn't be sent right away
+// and was queued to be retried later. The result is reported with RoomView.QueuedEventDone.
+var ErrEventQueued = errors.New("event queued for sending")
+
 type MatrixContainer interface {
 	Client() *mautrix.Client
 	Preferences() *config.UserPreferences
@@ -55,28 +181,76 @@ type MatrixContainer interface {
 
 	SendPreferencesToMatrix()
 	PrepareMarkdownMessage(roomID id.RoomID, msgtype event.MessageType, text, html string, relation *Relation) *muksevt.Event
-	PrepareMediaMessage(room *rooms.Room, path string, relation *Relation) (*muksevt.Event, error)
+	PrepareTranslatedMessage(room *rooms.Room, msgtype event.MessageType, text string, relation *Relation) (*muksevt.Event, error)
+	PrepareMediaMessage(ctx context.Context, room *rooms.Room, path string, relation *Relation, progress UploadProgressFunc) (*muksevt.Event, error)
+	PrepareLocationMessage(roomID id.RoomID, geoURI, description string, relation *Relation) *muksevt.Event
+	PrepareSticker(roomID id.RoomID, sticker Emote, relation *Relation) *muksevt.Event
 	SendEvent(evt *muksevt.Event) (id.EventID, error)
+	QueuedEvents(roomID id.RoomID) int
+	QueuedEventList(roomID id.RoomID) []QueuedEvent
+	DiscardQueuedEvent(txnID string) bool
+	ScheduleEvent(evt *muksevt.Event, sendAt time.Time, echoed bool)
+	CancelScheduledEvent(txnID string) bool
+	ScheduledEvents(roomID id.RoomID) []ScheduledEvent
+	RemoteServerUnreachable(roomID id.RoomID) bool
 	Redact(roomID id.RoomID, eventID id.EventID, reason string) error
+	RedactMany(roomID id.RoomID, eventIDs []id.EventID, reason string, progress func(done int)) (int, []error)
+	RedactReaction(roomID id.RoomID, target id.EventID, key string) error
 	SendTyping(roomID id.RoomID, typing bool)
 	MarkRead(roomID id.RoomID, eventID id.EventID)
 	JoinRoom(roomID id.RoomID, server string) (*rooms.Room, error)
 	LeaveRoom(roomID id.RoomID) error
 	CreateRoom(req *mautrix.ReqCreateRoom) (*rooms.Room, error)
+	EnableEncryption(room *rooms.Room) error
 
 	FetchMembers(room *rooms.Room) error
 	GetHistory(room *rooms.Room, limit int, dbPointer uint64) ([]*muksevt.Event, uint64, error)
 	GetEvent(room *rooms.Room, eventID id.EventID) (*muksevt.Event, error)
+	GetEventContext(room *rooms.Room, eventID id.EventID, limit int) ([]*muksevt.Event, error)
+	GetThreadReplies(room *rooms.Room, rootID id.EventID) ([]*muksevt.Event, error)
+	TimestampToEvent(roomID id.RoomID, ts time.Time, forward bool) (id.EventID, error)
+	GetAccountData(roomID id.RoomID, eventType string) (json.RawMessage, error)
+	SetAccountData(roomID id.RoomID, eventType string, content json.RawMessage) error
+	Search(term string, roomID id.RoomID, nextBatch string) (*SearchResults, error)
+	GetEditHistory(room *rooms.Room, eventID id.EventID) (*muksevt.Event, []*muksevt.Event, error)
 	GetRoom(roomID id.RoomID) *rooms.Room
+	GetPresence(userID id.UserID) (event.Presence, time.Time)
 	GetOrCreateRoom(roomID id.RoomID) *rooms.Room
 
-	UploadMedia(path string, encrypt bool) (*UploadedMediaInfo, error)
+	UploadMedia(ctx context.Context, path string, encrypt bool, progress UploadProgressFunc) (*UploadedMediaInfo, error)
 	Download(uri id.ContentURI, file *attachment.EncryptedFile) ([]byte, error)
+	DownloadLimited(uri id.ContentURI, file *attachment.EncryptedFile, maxSize int) ([]byte, error)
 	DownloadToDisk(uri id.ContentURI, file *attachment.EncryptedFile, target string) (string, error)
+	Downloads() []Download
+	ConnectionInfo() ConnectionInfo
+	CancelDownload(downloadID int) bool
 	GetDownloadURL(uri id.ContentURI) string
 	GetCachePath(uri id.ContentURI) string
+	DownloadThumbnail(uri id.ContentURI, width, height int) ([]byte, error)
+	GetThumbnailCachePath(uri id.ContentURI, width, height int) string
+	MediaCacheStats() (MediaCacheStats, error)
+	PurgeMediaCache(roomID id.RoomID, olderThan time.Duration) (files int, size int64, err error)
+	PruneHistory(maxAge time.Duration) (prunedRooms, prunedEvents, skippedRooms int, err error)
+	ReverseGeocode(lat, lon float64) (string, error)
+	RenderMaths(formula string) ([]byte, error)
+	LocationMinimap(lat, lon float64) ([]byte, error)
+	GetURLPreview(url string) (*URLPreview, error)
+	CachedURLPreview(url string) *URLPreview
+	GetEmotePacks(roomID id.RoomID) []*EmotePack
 
 	Crypto() Crypto
+	RetryDecryption()
+	GetDehydratedDevice() (id.DeviceID, error)
+	DehydrateDevice(key *ssss.Key) (id.DeviceID, error)
+	RehydrateDevice(key *ssss.Key) (id.DeviceID, error)
+	ExportSession(path, passphrase string) error
+	ImportSession(data []byte, passphrase string) error
+
+	NowPlaying() string
+	SetNowPlayingEnabled(enabled bool)
+
+	SentEvents(roomID id.RoomID, limit int) ([]SentEvent, error)
+	ClearSentEvents() error
 }
 
 type Crypto interface {
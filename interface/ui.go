@@ -58,9 +58,11 @@ type MainView interface {
 	UpdateTags(room *rooms.Room)
 
 	SetTyping(roomID id.RoomID, users []id.UserID)
+	UpdatePresence(userID id.UserID, lastActive time.Time)
 	OpenSyncingModal() SyncingModal
 
 	NotifyMessage(room *rooms.Room, message Message, should pushrules.PushActionArrayShould)
+	ShowServiceMessage(message string)
 }
 
 type RoomView interface {
@@ -76,6 +78,9 @@ type RoomView interface {
 	AddReaction(evt *muksevt.Event, key string)
 	GetEvent(eventID id.EventID) Message
 	AddServiceMessage(message string)
+	PinsChanged(sender id.UserID, added, removed int)
+	QueuedEventDone(txnID string, eventID id.EventID, err error)
+	QueuedEventDelayed(txnID string)
 }
 
 type Message interface {
@@ -0,0 +1,224 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package animation decodes animated GIF and APNG images into fully composited frames.
+package animation
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"time"
+)
+
+// MaxFrames is the maximum number of frames that are decoded. Longer animations are cut off.
+const MaxFrames = 200
+
+// MaxCanvasSize is the maximum width and height of animations. Larger images aren't animated.
+const MaxCanvasSize = 2048
+
+// MaxTotalPixels limits the combined size of all decoded frames of an animation, as each frame
+// is kept as a full RGBA copy of the canvas. Animations are cut off at the frame that would exceed it.
+const MaxTotalPixels = 16 * 1024 * 1024
+
+// Frames shorter than this are shown for DefaultDelay instead, like browsers do.
+const minDelay = 20 * time.Millisecond
+
+// DefaultDelay is used for frames that don't specify a valid delay.
+const DefaultDelay = 100 * time.Millisecond
+
+// Animation is a decoded animated image.
+type Animation struct {
+	Frames []image.Image
+	Delays []time.Duration
+}
+
+// Duration returns the total length of one loop of the animation.
+func (anim *Animation) Duration() (total time.Duration) {
+	for _, delay := range anim.Delays {
+		total += delay
+	}
+	return
+}
+
+// FrameAt returns the index of the frame that should be shown the given time after the animation started.
+func (anim *Animation) FrameAt(elapsed time.Duration) int {
+	total := anim.Duration()
+	if total <= 0 {
+		return 0
+	}
+	elapsed %= total
+	for i, delay := range anim.Delays {
+		if elapsed < delay {
+			return i
+		}
+		elapsed -= delay
+	}
+	return len(anim.Delays) - 1
+}
+
+var ErrNotAnimated = errors.New("image is not animated")
+var ErrTooLarge = errors.New("image is too large to animate")
+
+// frameLimit returns how many frames of the given canvas size can be decoded.
+func frameLimit(width, height int) (int, error) {
+	if width <= 0 || height <= 0 || width > MaxCanvasSize || height > MaxCanvasSize {
+		return 0, ErrTooLarge
+	}
+	limit := MaxTotalPixels / (width * height)
+	if limit > MaxFrames {
+		limit = MaxFrames
+	} else if limit < 2 {
+		return 0, ErrTooLarge
+	}
+	return limit, nil
+}
+
+// CheckSize returns ErrTooLarge if the given image is too large to be animated, without decoding the image data.
+func CheckSize(data []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	_, err = frameLimit(config.Width, config.Height)
+	return err
+}
+
+func frameDelay(delay time.Duration) time.Duration {
+	if delay < minDelay {
+		return DefaultDelay
+	}
+	return delay
+}
+
+// Decode decodes an animated GIF or APNG. ErrNotAnimated is returned for
+// other formats and images that only have one frame, and ErrTooLarge for
+// images whose canvas is larger than MaxCanvasSize.
+func Decode(data []byte) (*Animation, error) {
+	var anim *Animation
+	var err error
+	if bytes.HasPrefix(data, []byte("GIF8")) {
+		anim, err = decodeGIF(data)
+	} else if bytes.HasPrefix(data, pngSignature) {
+		anim, err = decodeAPNG(data)
+	} else {
+		return nil, ErrNotAnimated
+	}
+	if err != nil {
+		return nil, err
+	} else if len(anim.Frames) < 2 {
+		return nil, ErrNotAnimated
+	}
+	return anim, nil
+}
+
+func decodeGIF(data []byte) (*Animation, error) {
+	config, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	limit, err := frameLimit(config.Width, config.Height)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := gif.DecodeAll(bytes.NewReader(limitGIFFrames(data, limit)))
+	if err != nil {
+		return nil, err
+	}
+	bounds := image.Rect(0, 0, decoded.Config.Width, decoded.Config.Height)
+	canvas := image.NewRGBA(bounds)
+	anim := &Animation{}
+	for i, frame := range decoded.Image {
+		if i >= limit {
+			break
+		}
+		var previous *image.RGBA
+		if decoded.Disposal != nil && decoded.Disposal[i] == gif.DisposalPrevious {
+			previous = copyRGBA(canvas)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		anim.Frames = append(anim.Frames, copyRGBA(canvas))
+		anim.Delays = append(anim.Delays, frameDelay(time.Duration(decoded.Delay[i])*10*time.Millisecond))
+		if decoded.Disposal != nil {
+			switch decoded.Disposal[i] {
+			case gif.DisposalBackground:
+				draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+			case gif.DisposalPrevious:
+				canvas = previous
+			}
+		}
+	}
+	return anim, nil
+}
+
+// limitGIFFrames cuts the GIF data off after the given number of frames,
+// so that the frames after the limit aren't decoded at all.
+func limitGIFFrames(data []byte, limit int) []byte {
+	if len(data) < 13 {
+		return data
+	}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		// Global color table
+		pos += 3 << (flags&7 + 1)
+	}
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21:
+			// Extension: label byte and data sub-blocks
+			pos = skipGIFSubBlocks(data, pos+2)
+		case 0x2C:
+			if frames >= limit {
+				return append(data[:pos:pos], 0x3B)
+			} else if pos+10 > len(data) {
+				return data
+			}
+			frames++
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				// Local color table
+				pos += 3 << (flags&7 + 1)
+			}
+			// LZW minimum code size and image data sub-blocks
+			pos = skipGIFSubBlocks(data, pos+1)
+		default:
+			// Trailer or something invalid that the decoder will complain about
+			return data
+		}
+	}
+	return data
+}
+
+func skipGIFSubBlocks(data []byte, pos int) int {
+	for pos < len(data) {
+		size := int(data[pos])
+		pos += 1 + size
+		if size == 0 {
+			break
+		}
+	}
+	return pos
+}
+
+func copyRGBA(img *image.RGBA) *image.RGBA {
+	clone := image.NewRGBA(img.Bounds())
+	copy(clone.Pix, img.Pix)
+	return clone
+}
@@ -0,0 +1,196 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package animation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+	"time"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// APNG frame control operations, see https://wiki.mozilla.org/APNG_Specification
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+
+	apngBlendSource = 0
+)
+
+type pngChunk struct {
+	Type string
+	Data []byte
+}
+
+type apngFrame struct {
+	control []byte
+	data    [][]byte
+}
+
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	data = data[len(pngSignature):]
+	var chunks []pngChunk
+	for len(data) >= 12 {
+		length := int(binary.BigEndian.Uint32(data[:4]))
+		if length < 0 || len(data) < 12+length {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		chunks = append(chunks, pngChunk{Type: string(data[4:8]), Data: data[8 : 8+length]})
+		data = data[12+length:]
+	}
+	return chunks, nil
+}
+
+func writePNGChunk(buf *bytes.Buffer, chunkType string, data []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], chunkType)
+	buf.Write(header[:])
+	buf.Write(data)
+	crc := crc32.NewIEEE()
+	_, _ = crc.Write(header[4:])
+	_, _ = crc.Write(data)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	buf.Write(sum[:])
+}
+
+// decodeAPNGFrame builds a standalone PNG out of a single APNG frame and decodes it.
+func decodeAPNGFrame(header []byte, shared []pngChunk, frame *apngFrame) (image.Image, error) {
+	var buf bytes.Buffer
+	buf.Write(pngSignature)
+	ihdr := make([]byte, len(header))
+	copy(ihdr, header)
+	// Frame width and height from the fcTL chunk replace the size in the IHDR chunk.
+	copy(ihdr[0:8], frame.control[4:12])
+	writePNGChunk(&buf, "IHDR", ihdr)
+	for _, chunk := range shared {
+		writePNGChunk(&buf, chunk.Type, chunk.Data)
+	}
+	for _, data := range frame.data {
+		writePNGChunk(&buf, "IDAT", data)
+	}
+	writePNGChunk(&buf, "IEND", nil)
+	return png.Decode(&buf)
+}
+
+func decodeAPNG(data []byte) (*Animation, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	var header []byte
+	var shared []pngChunk
+	var frames []*apngFrame
+	var current *apngFrame
+	animated := false
+	for _, chunk := range chunks {
+		switch chunk.Type {
+		case "IHDR":
+			header = chunk.Data
+		case "acTL":
+			animated = true
+		case "fcTL":
+			if len(chunk.Data) != 26 {
+				return nil, errors.New("invalid fcTL chunk")
+			}
+			current = &apngFrame{control: chunk.Data}
+			frames = append(frames, current)
+		case "IDAT":
+			// The default image is only a part of the animation if it has a fcTL chunk before it.
+			if current != nil {
+				current.data = append(current.data, chunk.Data)
+			}
+		case "fdAT":
+			if current != nil && len(chunk.Data) > 4 {
+				// fdAT chunks are IDAT chunks prefixed with a sequence number.
+				current.data = append(current.data, chunk.Data[4:])
+			}
+		case "IEND":
+		default:
+			if current == nil {
+				shared = append(shared, chunk)
+			}
+		}
+	}
+	if !animated || len(header) != 13 || len(frames) < 2 {
+		return nil, ErrNotAnimated
+	}
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	limit, err := frameLimit(config.Width, config.Height)
+	if err != nil {
+		return nil, err
+	}
+	canvasBounds := image.Rect(0, 0, config.Width, config.Height)
+	canvas := image.NewRGBA(canvasBounds)
+	anim := &Animation{}
+	for i, frame := range frames {
+		if i >= limit {
+			break
+		}
+		ctl := frame.control
+		frameWidth := int(binary.BigEndian.Uint32(ctl[4:8]))
+		frameHeight := int(binary.BigEndian.Uint32(ctl[8:12]))
+		xOffset := int(binary.BigEndian.Uint32(ctl[12:16]))
+		yOffset := int(binary.BigEndian.Uint32(ctl[16:20]))
+		// Check the frame size before decoding it, as it could be much larger than the canvas.
+		if frameWidth <= 0 || frameHeight <= 0 || frameWidth > config.Width || frameHeight > config.Height ||
+			!image.Rect(xOffset, yOffset, xOffset+frameWidth, yOffset+frameHeight).In(canvasBounds) {
+			return nil, errors.New("APNG frame is outside the canvas")
+		}
+		img, err := decodeAPNGFrame(header, shared, frame)
+		if err != nil {
+			return nil, err
+		}
+		delayNum := time.Duration(binary.BigEndian.Uint16(ctl[20:22]))
+		delayDen := time.Duration(binary.BigEndian.Uint16(ctl[22:24]))
+		disposeOp, blendOp := ctl[24], ctl[25]
+		if delayDen == 0 {
+			delayDen = 100
+		}
+		area := img.Bounds().Sub(img.Bounds().Min).Add(image.Pt(xOffset, yOffset))
+
+		var previous *image.RGBA
+		if disposeOp == apngDisposePrevious {
+			previous = copyRGBA(canvas)
+		}
+		op := draw.Over
+		if blendOp == apngBlendSource {
+			op = draw.Src
+		}
+		draw.Draw(canvas, area, img, img.Bounds().Min, op)
+		anim.Frames = append(anim.Frames, copyRGBA(canvas))
+		anim.Delays = append(anim.Delays, frameDelay(delayNum*time.Second/delayDen))
+		switch disposeOp {
+		case apngDisposeBackground:
+			draw.Draw(canvas, area, image.Transparent, image.Point{}, draw.Src)
+		case apngDisposePrevious:
+			canvas = previous
+		}
+	}
+	return anim, nil
+}
@@ -0,0 +1,246 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package appendlog contains the line-based log files used for the notification and sent event logs.
+package appendlog
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/lib/cachecrypt"
+)
+
+// PruneInterval is how often old entries are removed from logs in the background.
+const PruneInterval = time.Hour
+
+const maxQueuedEntries = 64
+
+// Log is a file of JSON lines that entries are appended to. Every entry must have a "time" field,
+// which is used to remove old entries when the log is pruned.
+//
+// If a cipher is set, each line is encrypted separately, so that appending doesn't require rewriting
+// the whole file. Unencrypted lines from before cache encryption was enabled are still read.
+type Log struct {
+	path       string
+	cipher     *cachecrypt.Cipher
+	maxAge     time.Duration
+	maxEntries int
+
+	lock  sync.Mutex
+	lines int
+	queue chan interface{}
+}
+
+// New creates a log in the given file and starts the goroutine that writes to it. The log is pruned
+// when it's opened, every PruneInterval and whenever it grows past maxEntries lines.
+func New(path string, cipher *cachecrypt.Cipher, maxAge time.Duration, maxEntries int) *Log {
+	log := &Log{
+		path:       path,
+		cipher:     cipher,
+		maxAge:     maxAge,
+		maxEntries: maxEntries,
+		queue:      make(chan interface{}, maxQueuedEntries),
+	}
+	go log.run()
+	return log
+}
+
+func (log *Log) run() {
+	defer debug.Recover()
+	if err := log.Prune(); err != nil {
+		debug.Printf("Failed to prune %s: %v", log.path, err)
+	}
+	ticker := time.NewTicker(PruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry := <-log.queue:
+			if err := log.append(entry); err != nil {
+				debug.Printf("Failed to write to %s: %v", log.path, err)
+			}
+		case <-ticker.C:
+			if err := log.Prune(); err != nil {
+				debug.Printf("Failed to prune %s: %v", log.path, err)
+			}
+		}
+	}
+}
+
+func (log *Log) encodeLine(data []byte) ([]byte, error) {
+	if log.cipher == nil {
+		return data, nil
+	}
+	data, err := log.cipher.Encrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(data)), nil
+}
+
+func (log *Log) decodeLine(line []byte) (data []byte, err error) {
+	if len(line) == 0 || line[0] == '{' {
+		return line, nil
+	}
+	data, err = base64.StdEncoding.DecodeString(string(line))
+	if err == nil {
+		data, err = log.cipher.Decrypt(data)
+	}
+	return
+}
+
+// Append queues an entry to be added to the end of the log. Entries are written by a single goroutine
+// in the order they were queued, so callers don't have to wait for the disk.
+func (log *Log) Append(entry interface{}) {
+	log.queue <- entry
+}
+
+func (log *Log) append(entry interface{}) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line, err := log.encodeLine(data)
+	if err != nil {
+		return err
+	}
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	file, err := os.OpenFile(log.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	} else if closeErr != nil {
+		return closeErr
+	}
+	log.lines++
+	if log.lines > log.maxEntries {
+		return log.prune()
+	}
+	return nil
+}
+
+// Read returns the JSON of every entry in the log, oldest first. Lines that can't be decrypted are skipped.
+func (log *Log) Read() ([]json.RawMessage, error) {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	return log.read()
+}
+
+func (log *Log) read() ([]json.RawMessage, error) {
+	file, err := os.Open(log.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var entries []json.RawMessage
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		data, err := log.decodeLine(scanner.Bytes())
+		if err == nil && json.Valid(data) {
+			entries = append(entries, append(json.RawMessage{}, data...))
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Prune removes entries that are older than the maximum age. If there are still more entries than
+// the limit, the oldest ones are removed so that the log is only half full.
+func (log *Log) Prune() error {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	return log.prune()
+}
+
+func (log *Log) prune() error {
+	entries, err := log.read()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-log.maxAge)
+	kept := entries[:0]
+	for _, entry := range entries {
+		var meta struct {
+			Time time.Time `json:"time"`
+		}
+		if json.Unmarshal(entry, &meta) == nil && !meta.Time.Before(cutoff) {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) > log.maxEntries {
+		kept = kept[len(kept)-log.maxEntries/2:]
+	}
+	log.lines = len(kept)
+	if len(kept) == len(entries) {
+		return nil
+	}
+	return log.rewrite(kept)
+}
+
+// rewrite replaces the log with the given entries. The new log is written next to the old one and then
+// renamed over it, so that a failed write doesn't lose the log.
+func (log *Log) rewrite(entries []json.RawMessage) error {
+	tempPath := log.path + ".tmp"
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, entry := range entries {
+		var line []byte
+		line, err = log.encodeLine(entry)
+		if err == nil {
+			_, err = writer.Write(append(line, '\n'))
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, log.path)
+}
+
+// Clear deletes the log file.
+func (log *Log) Clear() error {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+	log.lines = 0
+	err := os.Remove(log.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,203 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package blurhash implements encoding and decoding of BlurHash image placeholders (https://blurha.sh).
+package blurhash
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// InfoKey is the key of the blurhash in the info object of image message content (MSC2448).
+const InfoKey = "xyz.amorgan.blurhash"
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+var ErrInvalidHash = errors.New("invalid blurhash")
+
+func decode83(str string) (int, error) {
+	value := 0
+	for _, char := range str {
+		digit := strings.IndexRune(base83Chars, char)
+		if digit < 0 {
+			return 0, ErrInvalidHash
+		}
+		value = value*83 + digit
+	}
+	return value, nil
+}
+
+func encode83(value, length int) string {
+	result := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		result[i] = base83Chars[value%83]
+		value /= 83
+	}
+	return string(result)
+}
+
+func sRGBToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) uint8 {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return uint8(v*12.92*255 + 0.5)
+	}
+	return uint8((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
+
+type linearColor struct {
+	r, g, b float64
+}
+
+// Decode renders the blurhash into an image of the given size. Punch adjusts the contrast, 1 is the default.
+func Decode(hash string, width, height int, punch float64) (image.Image, error) {
+	if len(hash) < 6 {
+		return nil, ErrInvalidHash
+	}
+	sizeFlag, err := decode83(hash[0:1])
+	if err != nil {
+		return nil, err
+	}
+	numX, numY := sizeFlag%9+1, sizeFlag/9+1
+	if len(hash) != 4+2*numX*numY {
+		return nil, ErrInvalidHash
+	}
+	quantisedMaxValue, err := decode83(hash[1:2])
+	if err != nil {
+		return nil, err
+	}
+	maxValue := float64(quantisedMaxValue+1) / 166 * punch
+
+	colors := make([]linearColor, numX*numY)
+	for i := range colors {
+		if i == 0 {
+			value, err := decode83(hash[2:6])
+			if err != nil {
+				return nil, err
+			}
+			colors[i] = linearColor{sRGBToLinear(uint8(value >> 16)), sRGBToLinear(uint8(value >> 8)), sRGBToLinear(uint8(value))}
+		} else {
+			value, err := decode83(hash[4+i*2 : 6+i*2])
+			if err != nil {
+				return nil, err
+			}
+			colors[i] = linearColor{
+				signPow((float64(value/(19*19))-9)/9, 2) * maxValue,
+				signPow((float64(value/19%19)-9)/9, 2) * maxValue,
+				signPow((float64(value%19)-9)/9, 2) * maxValue,
+			}
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var pixel linearColor
+			for j := 0; j < numY; j++ {
+				for i := 0; i < numX; i++ {
+					basis := math.Cos(math.Pi*float64(x*i)/float64(width)) * math.Cos(math.Pi*float64(y*j)/float64(height))
+					component := colors[i+j*numX]
+					pixel.r += component.r * basis
+					pixel.g += component.g * basis
+					pixel.b += component.b * basis
+				}
+			}
+			img.SetNRGBA(x, y, color.NRGBA{R: linearToSRGB(pixel.r), G: linearToSRGB(pixel.g), B: linearToSRGB(pixel.b), A: 255})
+		}
+	}
+	return img, nil
+}
+
+// Encode calculates the blurhash of the image with the given number of components (1-9) on each axis.
+// The image should be scaled down first, as every pixel is visited once per component.
+func Encode(numX, numY int, img image.Image) (string, error) {
+	if numX < 1 || numX > 9 || numY < 1 || numY > 9 {
+		return "", errors.New("blurhash component counts must be between 1 and 9")
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return "", errors.New("can't calculate blurhash of empty image")
+	}
+	pixels := make([]linearColor, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			pixels[x+y*width] = linearColor{sRGBToLinear(c.R), sRGBToLinear(c.G), sRGBToLinear(c.B)}
+		}
+	}
+
+	factors := make([]linearColor, numX*numY)
+	for j := 0; j < numY; j++ {
+		for i := 0; i < numX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var factor linearColor
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalisation * math.Cos(math.Pi*float64(i*x)/float64(width)) * math.Cos(math.Pi*float64(j*y)/float64(height))
+					pixel := pixels[x+y*width]
+					factor.r += basis * pixel.r
+					factor.g += basis * pixel.g
+					factor.b += basis * pixel.b
+				}
+			}
+			scale := 1 / float64(width*height)
+			factors[i+j*numX] = linearColor{factor.r * scale, factor.g * scale, factor.b * scale}
+		}
+	}
+
+	var buf strings.Builder
+	buf.WriteString(encode83((numX-1)+(numY-1)*9, 1))
+	maxValue := 1.0
+	if len(factors) > 1 {
+		actualMax := 0.0
+		for _, factor := range factors[1:] {
+			actualMax = math.Max(actualMax, math.Max(math.Abs(factor.r), math.Max(math.Abs(factor.g), math.Abs(factor.b))))
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		buf.WriteString(encode83(quantisedMax, 1))
+	} else {
+		buf.WriteString(encode83(0, 1))
+	}
+	dc := factors[0]
+	buf.WriteString(encode83(int(linearToSRGB(dc.r))<<16|int(linearToSRGB(dc.g))<<8|int(linearToSRGB(dc.b)), 4))
+	quantise := func(value float64) int {
+		return int(math.Max(0, math.Min(18, math.Floor(signPow(value/maxValue, 0.5)*9+9.5))))
+	}
+	for _, factor := range factors[1:] {
+		buf.WriteString(encode83(quantise(factor.r)*19*19+quantise(factor.g)*19+quantise(factor.b), 2))
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,130 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package cachecrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// KeySize is the length of the keys accepted by New.
+const KeySize = 32
+
+var ErrTooShort = errors.New("encrypted data is too short")
+
+// Cipher encrypts and decrypts cache data with AES-256-GCM.
+//
+// All methods can be called on a nil Cipher, in which case data is passed through unmodified.
+// This allows callers to use the same code path whether or not cache encryption is enabled.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// New creates a new Cipher with the given 32-byte key.
+func New(key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead}, nil
+}
+
+// Encrypt encrypts the given data. The random nonce is prepended to the returned ciphertext.
+func (c *Cipher) Encrypt(data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+	nonceSize := c.aead.NonceSize()
+	nonce := make([]byte, nonceSize, nonceSize+len(data)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// Decrypt decrypts data previously encrypted with Encrypt.
+func (c *Cipher) Decrypt(data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+	nonceSize := c.aead.NonceSize()
+	if len(data) < nonceSize+c.aead.Overhead() {
+		return nil, ErrTooShort
+	}
+	return c.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+}
+
+// NewReader reads and decrypts everything from the given reader and returns a reader for the plaintext.
+func (c *Cipher) NewReader(r io.Reader) (io.Reader, error) {
+	if c == nil {
+		return r, nil
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err = c.Decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type encryptingWriter struct {
+	cipher *Cipher
+	out    io.Writer
+	buf    bytes.Buffer
+}
+
+func (w *encryptingWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *encryptingWriter) Close() error {
+	data, err := w.cipher.Encrypt(w.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.out.Write(data)
+	return err
+}
+
+// NewWriter returns a writer that buffers everything written to it,
+// then encrypts the data and writes it to the given writer when closed.
+func (c *Cipher) NewWriter(w io.Writer) io.WriteCloser {
+	if c == nil {
+		return nopWriteCloser{w}
+	}
+	return &encryptingWriter{cipher: c, out: w}
+}
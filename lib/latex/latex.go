@@ -0,0 +1,381 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package latex converts simple LaTeX math into plain Unicode text for displaying it in the terminal.
+package latex
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var symbols = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε", "zeta": "ζ",
+	"eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ", "lambda": "λ", "mu": "μ", "nu": "ν",
+	"xi": "ξ", "pi": "π", "varpi": "ϖ", "rho": "ρ", "varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ",
+	"upsilon": "υ", "phi": "ϕ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π", "Sigma": "Σ",
+	"Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+
+	"times": "×", "cdot": "⋅", "div": "÷", "pm": "±", "mp": "∓", "ast": "∗", "star": "⋆", "circ": "∘",
+	"bullet": "∙", "oplus": "⊕", "otimes": "⊗", "setminus": "∖",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "ll": "≪", "gg": "≫",
+	"approx": "≈", "equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "subseteq": "⊆", "supset": "⊃", "supseteq": "⊇",
+	"cup": "∪", "cap": "∩", "emptyset": "∅", "varnothing": "∅",
+	"forall": "∀", "exists": "∃", "nexists": "∄", "neg": "¬", "lnot": "¬", "land": "∧", "wedge": "∧",
+	"lor": "∨", "vee": "∨", "top": "⊤", "bot": "⊥", "perp": "⊥", "parallel": "∥", "mid": "∣", "angle": "∠",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←", "leftrightarrow": "↔", "mapsto": "↦",
+	"Rightarrow": "⇒", "implies": "⇒", "Leftarrow": "⇐", "Leftrightarrow": "⇔", "iff": "⇔",
+	"uparrow": "↑", "downarrow": "↓",
+	"infty": "∞", "partial": "∂", "nabla": "∇", "hbar": "ℏ", "ell": "ℓ", "aleph": "ℵ", "Re": "ℜ", "Im": "ℑ",
+	"prime": "′", "degree": "°",
+	"sum": "∑", "prod": "∏", "coprod": "∐", "int": "∫", "iint": "∬", "iiint": "∭", "oint": "∮",
+	"bigcup": "⋃", "bigcap": "⋂",
+	"ldots": "…", "dots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱",
+	"langle": "⟨", "rangle": "⟩", "lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉",
+	"lbrace": "{", "rbrace": "}", "vert": "|", "Vert": "‖",
+	"quad": "  ", "qquad": "    ",
+}
+
+// Commands that only affect sizing or spacing in LaTeX and can be dropped.
+var ignored = map[string]bool{
+	"left": true, "right": true, "big": true, "Big": true, "bigg": true, "Bigg": true,
+	"bigl": true, "bigr": true, "Bigl": true, "Bigr": true, "displaystyle": true, "textstyle": true,
+	"limits": true, "nolimits": true,
+}
+
+var functions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true, "arcsin": true,
+	"arccos": true, "arctan": true, "sinh": true, "cosh": true, "tanh": true, "log": true, "ln": true,
+	"lg": true, "exp": true, "lim": true, "liminf": true, "limsup": true, "max": true, "min": true,
+	"sup": true, "inf": true, "det": true, "dim": true, "gcd": true, "deg": true, "arg": true, "ker": true,
+	"Pr": true, "mod": true, "bmod": true,
+}
+
+// Commands that take one argument and are shown as just the argument.
+var styleCommands = map[string]bool{
+	"mathrm": true, "mathit": true, "mathbf": true, "mathsf": true, "mathtt": true, "boldsymbol": true,
+	"operatorname": true, "mathcal": true, "mathscr": true, "mathfrak": true,
+}
+
+// Commands that take one argument in text mode, where spaces are kept as-is.
+var textCommands = map[string]bool{
+	"text": true, "textrm": true, "textit": true, "textbf": true, "textsf": true, "texttt": true, "mbox": true,
+}
+
+var accents = map[string]rune{
+	"vec": '⃗', "hat": '̂', "widehat": '̂', "bar": '̄', "overline": '̅',
+	"underline": '̲', "dot": '̇', "ddot": '̈', "tilde": '̃', "widetilde": '̃',
+}
+
+var superscripts = map[rune]rune{
+	'0': '⁰', '1': '¹', '2': '²', '3': '³', '4': '⁴', '5': '⁵', '6': '⁶', '7': '⁷', '8': '⁸', '9': '⁹',
+	'+': '⁺', '-': '⁻', '−': '⁻', '=': '⁼', '(': '⁽', ')': '⁾',
+	'a': 'ᵃ', 'b': 'ᵇ', 'c': 'ᶜ', 'd': 'ᵈ', 'e': 'ᵉ', 'f': 'ᶠ', 'g': 'ᵍ', 'h': 'ʰ', 'i': 'ⁱ', 'j': 'ʲ',
+	'k': 'ᵏ', 'l': 'ˡ', 'm': 'ᵐ', 'n': 'ⁿ', 'o': 'ᵒ', 'p': 'ᵖ', 'r': 'ʳ', 's': 'ˢ', 't': 'ᵗ', 'u': 'ᵘ',
+	'v': 'ᵛ', 'w': 'ʷ', 'x': 'ˣ', 'y': 'ʸ', 'z': 'ᶻ', 'T': 'ᵀ',
+	'′': '′', '∘': '°',
+}
+
+var subscripts = map[rune]rune{
+	'0': '₀', '1': '₁', '2': '₂', '3': '₃', '4': '₄', '5': '₅', '6': '₆', '7': '₇', '8': '₈', '9': '₉',
+	'+': '₊', '-': '₋', '−': '₋', '=': '₌', '(': '₍', ')': '₎',
+	'a': 'ₐ', 'e': 'ₑ', 'h': 'ₕ', 'i': 'ᵢ', 'j': 'ⱼ', 'k': 'ₖ', 'l': 'ₗ', 'm': 'ₘ', 'n': 'ₙ', 'o': 'ₒ',
+	'p': 'ₚ', 'r': 'ᵣ', 's': 'ₛ', 't': 'ₜ', 'u': 'ᵤ', 'v': 'ᵥ', 'x': 'ₓ',
+	'β': 'ᵦ', 'γ': 'ᵧ', 'ρ': 'ᵨ', 'φ': 'ᵩ', 'χ': 'ᵪ',
+}
+
+var doubleStruck = map[rune]rune{
+	'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ',
+}
+
+var vulgarFractions = map[string]string{
+	"1/2": "½", "1/3": "⅓", "2/3": "⅔", "1/4": "¼", "3/4": "¾", "1/5": "⅕", "1/6": "⅙", "1/8": "⅛",
+}
+
+var roots = map[string]string{
+	"": "√", "2": "√", "3": "∛", "4": "∜",
+}
+
+type converter struct {
+	input []rune
+	pos   int
+	// Set when something couldn't be represented in plain text.
+	complex bool
+}
+
+// ToUnicode converts a LaTeX math formula into an approximation using Unicode symbols, e.g. `x^2 \leq \alpha`
+// into "x² ≤ α". The second return value is false if the formula uses things that can't be represented in
+// plain text, like matrices or unknown commands, in which case the output is only a rough approximation.
+func ToUnicode(formula string) (string, bool) {
+	conv := &converter{input: []rune(formula)}
+	text := strings.TrimSpace(conv.parseUntil(0))
+	return text, !conv.complex
+}
+
+func (conv *converter) skipSpaces() {
+	for conv.pos < len(conv.input) && unicode.IsSpace(conv.input[conv.pos]) {
+		conv.pos++
+	}
+}
+
+// parseUntil converts the input until the given end rune (which is consumed) or the end of the input.
+func (conv *converter) parseUntil(end rune) string {
+	var buf strings.Builder
+	for conv.pos < len(conv.input) {
+		char := conv.input[conv.pos]
+		if end != 0 && char == end {
+			conv.pos++
+			break
+		}
+		switch {
+		case char == '{':
+			conv.pos++
+			buf.WriteString(conv.parseUntil('}'))
+		case char == '\\':
+			buf.WriteString(conv.command())
+		case char == '^' || char == '_':
+			conv.pos++
+			buf.WriteString(conv.script(conv.argument(), char == '^'))
+		case unicode.IsSpace(char):
+			conv.skipSpaces()
+			if buf.Len() > 0 {
+				buf.WriteRune(' ')
+			}
+		case char == '\'':
+			conv.pos++
+			buf.WriteRune('′')
+		case char == '~':
+			conv.pos++
+			buf.WriteRune(' ')
+		case char == '&':
+			// Alignment points only appear in environments, which can't be shown properly anyway
+			conv.complex = true
+			conv.pos++
+			buf.WriteRune(' ')
+		default:
+			conv.pos++
+			buf.WriteRune(char)
+		}
+	}
+	return buf.String()
+}
+
+// argument converts the next argument of a command, which is either a group in braces or a single token.
+func (conv *converter) argument() string {
+	conv.skipSpaces()
+	if conv.pos >= len(conv.input) {
+		return ""
+	}
+	char := conv.input[conv.pos]
+	switch char {
+	case '{':
+		conv.pos++
+		return conv.parseUntil('}')
+	case '\\':
+		return conv.command()
+	default:
+		conv.pos++
+		return string(char)
+	}
+}
+
+// rawArgument returns the next argument of a command without converting it.
+func (conv *converter) rawArgument() string {
+	conv.skipSpaces()
+	if conv.pos >= len(conv.input) {
+		return ""
+	} else if conv.input[conv.pos] != '{' {
+		conv.pos++
+		return string(conv.input[conv.pos-1])
+	}
+	depth := 0
+	start := conv.pos + 1
+	for ; conv.pos < len(conv.input); conv.pos++ {
+		switch conv.input[conv.pos] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				conv.pos++
+				return string(conv.input[start : conv.pos-1])
+			}
+		}
+	}
+	return string(conv.input[start:])
+}
+
+// optionalArgument converts an optional argument in square brackets, if there is one.
+func (conv *converter) optionalArgument() (string, bool) {
+	conv.skipSpaces()
+	if conv.pos >= len(conv.input) || conv.input[conv.pos] != '[' {
+		return "", false
+	}
+	conv.pos++
+	return conv.parseUntil(']'), true
+}
+
+func (conv *converter) command() string {
+	// Skip the backslash
+	conv.pos++
+	if conv.pos >= len(conv.input) {
+		return "\\"
+	}
+	start := conv.pos
+	for conv.pos < len(conv.input) && unicode.IsLetter(conv.input[conv.pos]) {
+		conv.pos++
+	}
+	if conv.pos == start {
+		char := conv.input[conv.pos]
+		conv.pos++
+		switch char {
+		case ',', ':', ';', ' ':
+			return " "
+		case '!':
+			return ""
+		case '|':
+			return "‖"
+		case '\\':
+			// Line breaks only appear in environments
+			conv.complex = true
+			return "; "
+		default:
+			return string(char)
+		}
+	}
+	name := string(conv.input[start:conv.pos])
+	if symbol, ok := symbols[name]; ok {
+		return symbol
+	} else if functions[name] {
+		return name
+	} else if styleCommands[name] {
+		return conv.argument()
+	} else if textCommands[name] {
+		return conv.rawArgument()
+	} else if accent, ok := accents[name]; ok {
+		return combine(conv.argument(), accent)
+	} else if ignored[name] {
+		if (name == "left" || name == "right") && conv.pos < len(conv.input) && conv.input[conv.pos] == '.' {
+			// \left. and \right. are invisible delimiters
+			conv.pos++
+		}
+		return ""
+	}
+	switch name {
+	case "frac", "dfrac", "tfrac":
+		numerator := conv.argument()
+		denominator := conv.argument()
+		return conv.fraction(numerator, denominator)
+	case "sqrt":
+		degree, _ := conv.optionalArgument()
+		root, ok := roots[strings.TrimSpace(degree)]
+		if !ok {
+			root = conv.script(degree, true) + "√"
+		}
+		return root + parenthesize(conv.argument())
+	case "mathbb":
+		return mapRunes(conv.argument(), doubleStruck)
+	case "begin":
+		env := conv.rawArgument()
+		if env == "array" {
+			// Skip the column specification
+			conv.rawArgument()
+		}
+		conv.complex = true
+		return ""
+	case "end":
+		conv.rawArgument()
+		return ""
+	default:
+		conv.complex = true
+		return "\\" + name
+	}
+}
+
+// script converts the given text into superscript or subscript characters.
+// If some characters don't have superscript or subscript versions, the text is prefixed with ^ or _ instead.
+func (conv *converter) script(text string, super bool) string {
+	table := subscripts
+	marker := "_"
+	if super {
+		table = superscripts
+		marker = "^"
+	}
+	if len(text) == 0 {
+		return ""
+	}
+	var buf strings.Builder
+	for _, char := range text {
+		replacement, ok := table[char]
+		if !ok && utf8.RuneCountInString(text) > 1 {
+			return marker + "(" + text + ")"
+		} else if !ok {
+			return marker + text
+		}
+		buf.WriteRune(replacement)
+	}
+	return buf.String()
+}
+
+func (conv *converter) fraction(numerator, denominator string) string {
+	numerator = strings.TrimSpace(numerator)
+	denominator = strings.TrimSpace(denominator)
+	if vulgar, ok := vulgarFractions[numerator+"/"+denominator]; ok {
+		return vulgar
+	}
+	return parenthesize(numerator) + "/" + parenthesize(denominator)
+}
+
+// parenthesize wraps the text in parentheses if it consists of more than a single word or number.
+func parenthesize(text string) string {
+	text = strings.TrimSpace(text)
+	if utf8.RuneCountInString(text) <= 1 {
+		return text
+	}
+	for _, char := range text {
+		if !unicode.IsLetter(char) && !unicode.IsDigit(char) && char != '.' {
+			return "(" + text + ")"
+		}
+	}
+	return text
+}
+
+// combine adds the given combining character after each character of the text.
+func combine(text string, mark rune) string {
+	var buf strings.Builder
+	for _, char := range text {
+		buf.WriteRune(char)
+		if !unicode.IsSpace(char) {
+			buf.WriteRune(mark)
+		}
+	}
+	return buf.String()
+}
+
+func mapRunes(text string, table map[rune]rune) string {
+	var buf strings.Builder
+	for _, char := range text {
+		if replacement, ok := table[char]; ok {
+			buf.WriteRune(replacement)
+		} else {
+			buf.WriteRune(char)
+		}
+	}
+	return buf.String()
+}
@@ -52,3 +52,7 @@ func Send(title, text string, critical, sound bool) error {
 	notification := fmt.Sprintf("display notification \"%s\" with title \"gomuks\" subtitle \"%s\"", text, title)
 	return exec.Command("osascript", "-e", notification).Run()
 }
+
+func SendWithActions(title, text string, critical, sound bool, actions ...Action) (string, error) {
+	return "", Send(title, text, critical, sound)
+}
@@ -16,9 +16,12 @@
 
 package notification
 
-import "os/exec"
+import (
+	"os/exec"
+	"strings"
+)
 
-func Send(title, text string, critical, sound bool) error {
+func notifySendArgs(title, text string, critical, sound bool) []string {
 	args := []string{"-a", "gomuks"}
 	if !critical {
 		args = append(args, "-u", "low")
@@ -34,5 +37,23 @@ func Send(title, text string, critical, sound bool) error {
 		}
 		exec.Command("paplay", "/usr/share/sounds/freedesktop/stereo/"+soundName+".oga").Run()
 	}
-	return exec.Command("notify-send", args...).Run()
+	return args
+}
+
+func Send(title, text string, critical, sound bool) error {
+	return exec.Command("notify-send", notifySendArgs(title, text, critical, sound)...).Run()
+}
+
+func SendWithActions(title, text string, critical, sound bool, actions ...Action) (string, error) {
+	var args []string
+	for _, action := range actions {
+		args = append(args, "-A", action.Key+"="+action.Label)
+	}
+	args = append(args, notifySendArgs(title, text, critical, sound)...)
+	output, err := exec.Command("notify-send", args...).Output()
+	if err != nil {
+		// Old versions of notify-send don't support actions.
+		return "", exec.Command("notify-send", notifySendArgs(title, text, critical, false)...).Run()
+	}
+	return strings.TrimSpace(string(output)), nil
 }
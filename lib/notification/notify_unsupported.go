@@ -21,3 +21,7 @@ package notification
 func Send(title, text string, critical, sound bool) error {
 	return nil
 }
+
+func SendWithActions(title, text string, critical, sound bool, actions ...Action) (string, error) {
+	return "", Send(title, text, critical, sound)
+}
@@ -36,3 +36,7 @@ func Send(title, text string, critical, sound bool) error {
 	}
 	return notification.Push()
 }
+
+func SendWithActions(title, text string, critical, sound bool, actions ...Action) (string, error) {
+	return "", Send(title, text, critical, sound)
+}
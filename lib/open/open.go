@@ -18,20 +18,46 @@ package open
 
 import (
 	"os/exec"
+	"strings"
 
 	"maunium.net/go/gomuks/debug"
 )
 
 func Open(input string) error {
-	cmd := exec.Command(Command, append(Args, input)...)
+	return start(Command, append(Args, input))
+}
+
+// OpenWith opens the input with the given program. {file} in the arguments is replaced with the input,
+// and if there is no {file} argument, the input is added as the last argument.
+func OpenWith(command []string, input string) error {
+	if len(command) == 0 {
+		return Open(input)
+	}
+	args := make([]string, 0, len(command))
+	replaced := false
+	for _, arg := range command[1:] {
+		if strings.Contains(arg, "{file}") {
+			arg = strings.ReplaceAll(arg, "{file}", input)
+			replaced = true
+		}
+		args = append(args, arg)
+	}
+	if !replaced {
+		args = append(args, input)
+	}
+	return start(command[0], args)
+}
+
+func start(command string, args []string) error {
+	cmd := exec.Command(command, args...)
 	err := cmd.Start()
 	if err != nil {
-		debug.Printf("Failed to start %s: %v", Command, err)
+		debug.Printf("Failed to start %s: %v", command, err)
 	} else {
 		go func() {
 			waitErr := cmd.Wait()
 			if waitErr != nil {
-				debug.Printf("Failed to run %s: %v", Command, err)
+				debug.Printf("Failed to run %s: %v", command, waitErr)
 			}
 		}()
 	}
@@ -0,0 +1,169 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package qrcode
+
+// addErrorCorrection splits the data codewords into blocks, appends Reed-Solomon error
+// correction codewords to each block and interleaves the blocks into the final sequence.
+func addErrorCorrection(version int, data []byte) []byte {
+	numBlocks := numErrorCorrectionBlocks[version]
+	blockEccLen := eccCodewordsPerBlock[version]
+	rawCodewords := numRawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := reedSolomonDivisor(blockEccLen)
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		datLen := shortBlockLen - blockEccLen
+		if i >= numShortBlocks {
+			datLen++
+		}
+		block := make([]byte, 0, shortBlockLen+1)
+		block = append(block, data[k:k+datLen]...)
+		k += datLen
+		ecc := reedSolomonRemainder(block, divisor)
+		if i < numShortBlocks {
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			// Skip the padding byte in short blocks.
+			if i != shortBlockLen-blockEccLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = reedSolomonMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = reedSolomonMultiply(root, 0x02)
+	}
+	return result
+}
+
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range divisor {
+			result[i] ^= reedSolomonMultiply(coef, factor)
+		}
+	}
+	return result
+}
+
+// reedSolomonMultiply multiplies two elements of GF(2^8/0x11D).
+func reedSolomonMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+// penaltyScore calculates how hard the code is to scan, used to choose the best mask.
+func (code *Code) penaltyScore() int {
+	penalty := 0
+	for i := 0; i < code.Size; i++ {
+		penalty += code.linePenalty(func(j int) bool { return code.modules[i][j] })
+		penalty += code.linePenalty(func(j int) bool { return code.modules[j][i] })
+	}
+
+	dark := 0
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			color := code.modules[y][x]
+			if color {
+				dark++
+			}
+			if x < code.Size-1 && y < code.Size-1 &&
+				color == code.modules[y][x+1] && color == code.modules[y+1][x] && color == code.modules[y+1][x+1] {
+				penalty += 3
+			}
+		}
+	}
+
+	total := code.Size * code.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	if k > 0 {
+		penalty += k * 10
+	}
+	return penalty
+}
+
+// linePenalty calculates the penalty for runs of same-colored modules and
+// finder-like patterns in a single row or column.
+func (code *Code) linePenalty(get func(int) bool) int {
+	penalty := 0
+	runLength := 0
+	var runColor bool
+	for j := 0; j < code.Size; j++ {
+		if j > 0 && get(j) == runColor {
+			runLength++
+			if runLength == 5 {
+				penalty += 3
+			} else if runLength > 5 {
+				penalty++
+			}
+		} else {
+			runColor = get(j)
+			runLength = 1
+		}
+	}
+
+	pattern := []bool{true, false, true, true, true, false, true}
+	for j := 0; j+len(pattern) <= code.Size; j++ {
+		matches := true
+		for k, dark := range pattern {
+			if get(j+k) != dark {
+				matches = false
+				break
+			}
+		}
+		if matches && (code.lightRun(get, j-4, j) || code.lightRun(get, j+len(pattern), j+len(pattern)+4)) {
+			penalty += 40
+		}
+	}
+	return penalty
+}
+
+func (code *Code) lightRun(get func(int) bool, from, to int) bool {
+	for j := from; j < to; j++ {
+		if j >= 0 && j < code.Size && get(j) {
+			return false
+		}
+	}
+	return true
+}
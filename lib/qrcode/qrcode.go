@@ -0,0 +1,347 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package qrcode is a minimal QR code encoder for showing binary data in the terminal.
+//
+// Only byte mode and error correction level M are supported, which is all that's needed
+// for things like verification codes. The implementation follows the QR code specification
+// (ISO/IEC 18004) and is loosely based on Project Nayuki's QR code generator.
+package qrcode
+
+import (
+	"errors"
+)
+
+// ErrDataTooLong is returned by Encode if the data doesn't fit in the largest QR code version.
+var ErrDataTooLong = errors.New("data too long for a QR code")
+
+const (
+	minVersion = 1
+	maxVersion = 40
+
+	// Format bits for error correction level M.
+	eccFormatBits = 0
+)
+
+// Error correction codewords per block and number of blocks for level M, indexed by version.
+var eccCodewordsPerBlock = [maxVersion + 1]int{-1,
+	10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26,
+	26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28}
+var numErrorCorrectionBlocks = [maxVersion + 1]int{-1,
+	1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16,
+	17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49}
+
+// Code is an encoded QR code.
+type Code struct {
+	// Version is the QR code version (1-40).
+	Version int
+	// Size is the width and height of the code in modules, not including a quiet zone.
+	Size int
+
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// Get returns whether the module at the given coordinates is dark.
+// Coordinates outside the code are light, which means the quiet zone can be drawn by
+// simply iterating over a larger area.
+func (code *Code) Get(x, y int) bool {
+	return x >= 0 && y >= 0 && x < code.Size && y < code.Size && code.modules[y][x]
+}
+
+// Encode encodes the given data into a QR code using the smallest version it fits in.
+func Encode(data []byte) (*Code, error) {
+	version := minVersion
+	for ; ; version++ {
+		if version > maxVersion {
+			return nil, ErrDataTooLong
+		}
+		if 4+charCountBits(version)+len(data)*8 <= numDataCodewords(version)*8 {
+			break
+		}
+	}
+
+	var bits bitBuffer
+	bits.append(0x4, 4)
+	bits.append(len(data), charCountBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := numDataCodewords(version) * 8
+	terminator := capacity - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i>>3] |= 1 << (7 - uint(i&7))
+		}
+	}
+
+	code := newCode(version)
+	code.drawFunctionPatterns()
+	code.drawCodewords(addErrorCorrection(version, codewords))
+	code.applyBestMask()
+	return code, nil
+}
+
+func newCode(version int) *Code {
+	size := version*4 + 17
+	code := &Code{
+		Version:    version,
+		Size:       size,
+		modules:    make([][]bool, size),
+		isFunction: make([][]bool, size),
+	}
+	for i := 0; i < size; i++ {
+		code.modules[i] = make([]bool, size)
+		code.isFunction[i] = make([]bool, size)
+	}
+	return code
+}
+
+type bitBuffer []bool
+
+func (bb *bitBuffer) append(val, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*bb = append(*bb, (val>>uint(i))&1 != 0)
+	}
+}
+
+func charCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+func numRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+func numDataCodewords(version int) int {
+	return numRawDataModules(version)/8 - eccCodewordsPerBlock[version]*numErrorCorrectionBlocks[version]
+}
+
+func alignmentPatternPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	result := make([]int, numAlign)
+	result[0] = 6
+	for i, pos := numAlign-1, version*4+17-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+func (code *Code) setFunctionModule(x, y int, dark bool) {
+	code.modules[y][x] = dark
+	code.isFunction[y][x] = true
+}
+
+func (code *Code) drawFunctionPatterns() {
+	for i := 0; i < code.Size; i++ {
+		code.setFunctionModule(6, i, i%2 == 0)
+		code.setFunctionModule(i, 6, i%2 == 0)
+	}
+	code.drawFinderPattern(3, 3)
+	code.drawFinderPattern(code.Size-4, 3)
+	code.drawFinderPattern(3, code.Size-4)
+
+	positions := alignmentPatternPositions(code.Version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			code.drawAlignmentPattern(x, y)
+		}
+	}
+
+	// Reserve the format bit areas, they're drawn for real after choosing a mask.
+	code.drawFormatBits(0)
+	code.drawVersion()
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func (code *Code) drawFinderPattern(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			dist := max(abs(dx), abs(dy))
+			xx, yy := x+dx, y+dy
+			if xx >= 0 && xx < code.Size && yy >= 0 && yy < code.Size {
+				code.setFunctionModule(xx, yy, dist != 2 && dist != 4)
+			}
+		}
+	}
+}
+
+func (code *Code) drawAlignmentPattern(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			code.setFunctionModule(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+func getBit(x, i int) bool {
+	return (x>>uint(i))&1 != 0
+}
+
+func (code *Code) drawFormatBits(mask int) {
+	data := eccFormatBits<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+
+	for i := 0; i <= 5; i++ {
+		code.setFunctionModule(8, i, getBit(bits, i))
+	}
+	code.setFunctionModule(8, 7, getBit(bits, 6))
+	code.setFunctionModule(8, 8, getBit(bits, 7))
+	code.setFunctionModule(7, 8, getBit(bits, 8))
+	for i := 9; i < 15; i++ {
+		code.setFunctionModule(14-i, 8, getBit(bits, i))
+	}
+
+	for i := 0; i < 8; i++ {
+		code.setFunctionModule(code.Size-1-i, 8, getBit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		code.setFunctionModule(8, code.Size-15+i, getBit(bits, i))
+	}
+	code.setFunctionModule(8, code.Size-8, true)
+}
+
+func (code *Code) drawVersion() {
+	if code.Version < 7 {
+		return
+	}
+	rem := code.Version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := code.Version<<12 | rem
+	for i := 0; i < 18; i++ {
+		a := code.Size - 11 + i%3
+		b := i / 3
+		code.setFunctionModule(a, b, getBit(bits, i))
+		code.setFunctionModule(b, a, getBit(bits, i))
+	}
+}
+
+func (code *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := code.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < code.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = code.Size - 1 - vert
+				}
+				if !code.isFunction[y][x] && i < len(data)*8 {
+					code.modules[y][x] = getBit(int(data[i>>3]), 7-(i&7))
+					i++
+				}
+			}
+		}
+	}
+}
+
+func maskApplies(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+func (code *Code) applyMask(mask int) {
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if !code.isFunction[y][x] && maskApplies(mask, x, y) {
+				code.modules[y][x] = !code.modules[y][x]
+			}
+		}
+	}
+}
+
+func (code *Code) applyBestMask() {
+	bestMask := 0
+	minPenalty := -1
+	for mask := 0; mask < 8; mask++ {
+		code.applyMask(mask)
+		code.drawFormatBits(mask)
+		if penalty := code.penaltyScore(); minPenalty < 0 || penalty < minPenalty {
+			bestMask = mask
+			minPenalty = penalty
+		}
+		// Applying the same mask again undoes it.
+		code.applyMask(mask)
+	}
+	code.applyMask(bestMask)
+	code.drawFormatBits(bestMask)
+}
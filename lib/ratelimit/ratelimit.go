@@ -0,0 +1,61 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package ratelimit contains helpers for waiting when the homeserver rate limits requests.
+package ratelimit
+
+import (
+	"errors"
+	"time"
+
+	"maunium.net/go/mautrix"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+const (
+	// DefaultRetryAfter is used when the server rate limits a request without saying how long to wait.
+	DefaultRetryAfter = 5 * time.Second
+	// MaxRetries is how many times Retry repeats a request that keeps getting rate limited.
+	MaxRetries = 5
+)
+
+// Delay returns how long the server asked to wait if the error is M_LIMIT_EXCEEDED.
+func Delay(err error) (time.Duration, bool) {
+	var httpErr mautrix.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.RespError == nil || httpErr.RespError.ErrCode != mautrix.MLimitExceeded.ErrCode {
+		return 0, false
+	}
+	if retryAfter, ok := httpErr.RespError.ExtraData["retry_after_ms"].(float64); ok && retryAfter > 0 {
+		return time.Duration(retryAfter) * time.Millisecond, true
+	}
+	return DefaultRetryAfter, true
+}
+
+// Retry calls the given function until it returns something other than a rate limit error, waiting as long
+// as the server asks between attempts. The description is only used for logging.
+func Retry(description string, fn func() error) (err error) {
+	for attempt := 0; attempt <= MaxRetries; attempt++ {
+		err = fn()
+		delay, limited := Delay(err)
+		if !limited {
+			return
+		}
+		debug.Printf("Rate limited while %s, waiting %s", description, delay)
+		time.Sleep(delay)
+	}
+	return
+}
@@ -19,12 +19,14 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
 
+	"maunium.net/go/gomuks/config"
 	"maunium.net/go/gomuks/debug"
 	"maunium.net/go/gomuks/interface"
 	"maunium.net/go/gomuks/ui"
@@ -33,6 +35,16 @@ import (
 var MainUIProvider ifc.UIProvider = ui.NewGomuksUI
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "completion":
+			generateCompletion(os.Args[2:])
+		case "--help", "-h":
+			printUsage()
+			os.Exit(0)
+		}
+	}
+
 	debugDir := os.Getenv("DEBUG_DIR")
 	if len(debugDir) > 0 {
 		debug.LogDirectory = debugDir
@@ -80,6 +92,19 @@ func main() {
 		os.Exit(0)
 	}
 
+	for i := 1; i < len(os.Args); i++ {
+		switch arg := os.Args[i]; {
+		case arg == "--room" && i+1 < len(os.Args):
+			i++
+			gmx.Config().StartupRoomOverride = os.Args[i]
+		case strings.HasPrefix(arg, "--room="):
+			gmx.Config().StartupRoomOverride = strings.TrimPrefix(arg, "--room=")
+		case arg == "--import-session" && i+1 < len(os.Args):
+			i++
+			importSession(gmx, os.Args[i])
+		}
+	}
+
 	gmx.Start()
 
 	// We use os.Exit() everywhere, so exiting by returning from Start() shouldn't happen.
@@ -88,6 +113,25 @@ func main() {
 	os.Exit(2)
 }
 
+func importSession(gmx *Gomuks, path string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to read session bundle:", err)
+		os.Exit(5)
+	}
+	passphrase, err := config.ReadPassphrase("Session bundle passphrase", "GOMUKS_SESSION_PASSPHRASE")
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to read passphrase:", err)
+		os.Exit(5)
+	}
+	err = gmx.Matrix().ImportSession(data, passphrase)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Failed to import session:", err)
+		os.Exit(5)
+	}
+	fmt.Println("Imported session of", gmx.Config().UserID)
+}
+
 func getRootDir(subdir string) string {
 	rootDir := os.Getenv("GOMUKS_ROOT")
 	if rootDir == "" {
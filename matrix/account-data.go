@@ -0,0 +1,48 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"encoding/json"
+
+	"maunium.net/go/mautrix/id"
+)
+
+func (c *Container) accountDataURL(roomID id.RoomID, eventType string) string {
+	if len(roomID) > 0 {
+		return c.client.BuildURL("user", c.config.UserID, "rooms", roomID, "account_data", eventType)
+	}
+	return c.client.BuildURL("user", c.config.UserID, "account_data", eventType)
+}
+
+// GetAccountData fetches the raw content of a global account data event from the server,
+// or a room account data event if roomID is not empty.
+func (c *Container) GetAccountData(roomID id.RoomID, eventType string) (json.RawMessage, error) {
+	var content json.RawMessage
+	_, err := c.client.MakeRequest("GET", c.accountDataURL(roomID, eventType), nil, &content)
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// SetAccountData replaces the content of a global account data event on the server,
+// or a room account data event if roomID is not empty.
+func (c *Container) SetAccountData(roomID id.RoomID, eventType string, content json.RawMessage) error {
+	_, err := c.client.MakeRequest("PUT", c.accountDataURL(roomID, eventType), content, nil)
+	return err
+}
@@ -0,0 +1,269 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	sync "github.com/sasha-s/go-deadlock"
+
+	"maunium.net/go/gomuks/config"
+	"maunium.net/go/gomuks/interface"
+)
+
+// trackingTransport gives sync requests a context of their own, so that a hung long-poll can be
+// canceled without affecting other requests.
+// It also remembers the TLS details of the latest response for /connection.
+type trackingTransport struct {
+	base http.RoundTripper
+	sync *syncRequestTracker
+	info *connectionTracker
+}
+
+func (t *trackingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	isSync := req.Method == http.MethodGet && strings.HasSuffix(req.URL.Path, "/sync")
+	if isSync {
+		req = t.sync.start(req)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		if isSync {
+			t.sync.finish()
+		}
+		return resp, err
+	}
+	if resp.TLS != nil {
+		t.info.setTLS(resp.TLS)
+	}
+	if isSync {
+		// The request is only done once the response body has been read.
+		resp.Body = &syncResponseBody{ReadCloser: resp.Body, tracker: t.sync}
+	}
+	return resp, err
+}
+
+// syncRequestTracker keeps track of the sync request that is in flight.
+type syncRequestTracker struct {
+	lock    sync.Mutex
+	cancel  context.CancelFunc
+	started time.Time
+}
+
+func (st *syncRequestTracker) start(req *http.Request) *http.Request {
+	ctx, cancel := context.WithCancel(req.Context())
+	st.lock.Lock()
+	st.cancel = cancel
+	st.started = time.Now()
+	st.lock.Unlock()
+	return req.WithContext(ctx)
+}
+
+func (st *syncRequestTracker) finish() {
+	st.lock.Lock()
+	st.cancel = nil
+	st.started = time.Time{}
+	st.lock.Unlock()
+}
+
+// InFlight returns how long the current sync request has been running, or zero if there's no sync request in flight.
+func (st *syncRequestTracker) InFlight() time.Duration {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if st.started.IsZero() {
+		return 0
+	}
+	return time.Since(st.started)
+}
+
+// Cancel aborts the sync request that is in flight, if any.
+func (st *syncRequestTracker) Cancel() {
+	st.lock.Lock()
+	cancel := st.cancel
+	st.lock.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+type syncResponseBody struct {
+	io.ReadCloser
+	tracker *syncRequestTracker
+}
+
+func (body *syncResponseBody) Close() error {
+	body.tracker.finish()
+	return body.ReadCloser.Close()
+}
+
+// connectionTracker remembers the latest connection made to the homeserver.
+type connectionTracker struct {
+	lock sync.Mutex
+	info ifc.ConnectionInfo
+}
+
+func (ct *connectionTracker) setConn(conn net.Conn) {
+	ct.lock.Lock()
+	ct.info.RemoteAddr = conn.RemoteAddr().String()
+	ct.info.LocalAddr = conn.LocalAddr().String()
+	ct.info.Connected = time.Now()
+	ct.lock.Unlock()
+}
+
+func (ct *connectionTracker) setTLS(state *tls.ConnectionState) {
+	ct.lock.Lock()
+	ct.info.TLS = state
+	ct.lock.Unlock()
+}
+
+// ConnectionInfo returns the addresses and TLS details of the latest connection to the homeserver.
+func (c *Container) ConnectionInfo() ifc.ConnectionInfo {
+	c.connection.lock.Lock()
+	defer c.connection.lock.Unlock()
+	info := c.connection.info
+	info.IPVersion = c.config.Connection.GetIPVersion()
+	if c.client != nil {
+		info.Homeserver = c.client.HomeserverURL.String()
+	}
+	return info
+}
+
+// splitByIPVersion sorts the resolved addresses into the preferred and fallback IP version.
+func splitByIPVersion(addrs []net.IPAddr, version string) (primary, fallback []net.IP) {
+	for _, addr := range addrs {
+		isIPv4 := addr.IP.To4() != nil
+		switch {
+		case version == config.IPVersionIPv4 && !isIPv4, version == config.IPVersionIPv6 && isIPv4:
+			continue
+		case isIPv4 == (version == config.IPVersionPreferIPv4 || version == config.IPVersionIPv4):
+			primary = append(primary, addr.IP)
+		default:
+			fallback = append(fallback, addr.IP)
+		}
+	}
+	if len(primary) == 0 {
+		primary, fallback = fallback, nil
+	}
+	return
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// dialSerial tries the addresses one by one until one of them works.
+func dialSerial(ctx context.Context, dialer *net.Dialer, ips []net.IP, port string) (conn net.Conn, err error) {
+	err = errors.New("no addresses to dial")
+	for _, ip := range ips {
+		conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+	}
+	return
+}
+
+// dialContext connects to the given address using the configured IP version preference. If both IP versions
+// are allowed, the fallback version is tried in parallel if the preferred one hasn't connected after the
+// fallback delay (happy eyeballs, RFC 6555).
+func (c *Container) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	primary, fallback := splitByIPVersion(addrs, c.config.Connection.GetIPVersion())
+	if len(primary) == 0 {
+		return nil, &net.DNSError{Err: "no addresses of the allowed IP version", Name: host, IsNotFound: true}
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, 2)
+	go func() {
+		conn, err := dialSerial(ctx, dialer, primary, port)
+		results <- dialResult{conn, err}
+	}()
+	pending := 1
+	var fallbackTimer <-chan time.Time
+	if len(fallback) > 0 {
+		timer := time.NewTimer(c.config.Connection.GetFallbackDelay())
+		defer timer.Stop()
+		fallbackTimer = timer.C
+	}
+	startFallback := func() {
+		fallbackTimer = nil
+		pending++
+		go func() {
+			conn, err := dialSerial(ctx, dialer, fallback, port)
+			results <- dialResult{conn, err}
+		}()
+	}
+	var firstErr error
+	for {
+		select {
+		case <-fallbackTimer:
+			startFallback()
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				c.connection.setConn(result.conn)
+				if pending > 0 {
+					// Close the connection of the slower IP version if it still succeeds.
+					go func() {
+						if late := <-results; late.conn != nil {
+							_ = late.conn.Close()
+						}
+					}()
+				}
+				return result.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			if fallbackTimer != nil {
+				// The preferred version failed before the delay, so try the fallback right away.
+				startFallback()
+			} else if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// newHTTPClient creates a HTTP client with a fresh connection pool for the Matrix client.
+// The sync requests made with the client can be aborted with c.syncRequest.
+func (c *Container) newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = c.dialContext
+	if len(os.Getenv("GOMUKS_ALLOW_INSECURE_CONNECTIONS")) > 0 {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &http.Client{Transport: &trackingTransport{base: transport, sync: &c.syncRequest, info: &c.connection}}
+}
@@ -0,0 +1,77 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/matrix/rooms"
+)
+
+type respContext struct {
+	Start        string         `json:"start"`
+	End          string         `json:"end"`
+	EventsBefore []*event.Event `json:"events_before"`
+	Event        *event.Event   `json:"event"`
+	EventsAfter  []*event.Event `json:"events_after"`
+}
+
+// GetEventContext fetches the given event and up to limit events around it from the server using
+// the /context API. The events are returned in chronological order.
+func (c *Container) GetEventContext(room *rooms.Room, eventID id.EventID, limit int) ([]*muksevt.Event, error) {
+	u := c.client.BuildURL("rooms", room.ID, "context", eventID)
+	u += "?" + url.Values{"limit": {strconv.Itoa(limit)}}.Encode()
+	var resp respContext
+	_, err := c.client.MakeRequest("GET", u, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*muksevt.Event, 0, len(resp.EventsBefore)+1+len(resp.EventsAfter))
+	// events_before is in reverse chronological order.
+	for i := len(resp.EventsBefore) - 1; i >= 0; i-- {
+		events = append(events, c.parseFetchedEvent(resp.EventsBefore[i]))
+	}
+	if resp.Event != nil {
+		events = append(events, c.parseFetchedEvent(resp.Event))
+	}
+	for _, evt := range resp.EventsAfter {
+		events = append(events, c.parseFetchedEvent(evt))
+	}
+	debug.Printf("Fetched %d events around %s in %s", len(events), eventID, room.ID)
+	return events, nil
+}
+
+// paginationTokenBefore returns a token for paginating backwards in the room from right before the given event.
+func (c *Container) paginationTokenBefore(roomID id.RoomID, eventID id.EventID) (string, error) {
+	u := c.client.BuildURL("rooms", roomID, "context", eventID)
+	u += "?" + url.Values{"limit": {"0"}}.Encode()
+	var resp respContext
+	_, err := c.client.MakeRequest("GET", u, nil, &resp)
+	if err != nil {
+		return "", err
+	} else if len(resp.Start) == 0 {
+		return "", fmt.Errorf("server didn't return a pagination token for %s", eventID)
+	}
+	return resp.Start, nil
+}
@@ -20,28 +20,42 @@ package matrix
 
 import (
 	"fmt"
-	"path/filepath"
+	"sync/atomic"
 
+	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 
+	"maunium.net/go/gomuks/config"
 	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/rooms"
 )
 
-type cryptoLogger struct{}
+// olmDecryptFailedMessage is logged by the crypto module when it fails to decrypt an olm-encrypted to-device event.
+const olmDecryptFailedMessage = "Failed to decrypt to-device event: %v"
 
-func (c cryptoLogger) Error(message string, args ...interface{}) {
+type cryptoLogger struct {
+	// The number of to-device events that the crypto module failed to decrypt, which it only logs.
+	olmFailures uint32
+}
+
+func (c *cryptoLogger) Error(message string, args ...interface{}) {
+	if message == olmDecryptFailedMessage {
+		atomic.AddUint32(&c.olmFailures, 1)
+	}
 	debug.Printf("[Crypto/Error] "+message, args...)
 }
 
-func (c cryptoLogger) Warn(message string, args ...interface{}) {
+func (c *cryptoLogger) Warn(message string, args ...interface{}) {
 	debug.Printf("[Crypto/Warn] "+message, args...)
 }
 
-func (c cryptoLogger) Debug(message string, args ...interface{}) {
+func (c *cryptoLogger) Debug(message string, args ...interface{}) {
 	debug.Printf("[Crypto/Debug] "+message, args...)
 }
 
-func (c cryptoLogger) Trace(message string, args ...interface{}) {
+func (c *cryptoLogger) Trace(message string, args ...interface{}) {
 	debug.Printf("[Crypto/Trace] "+message, args...)
 }
 
@@ -49,13 +63,218 @@ func isBadEncryptError(err error) bool {
 	return err != crypto.SessionExpired && err != crypto.SessionNotShared && err != crypto.NoGroupSession
 }
 
+// verificationUI is implemented by UIs that can ask the user about incoming interactive verification requests.
+type verificationUI interface {
+	AcceptVerificationFrom(transactionID string, device *crypto.DeviceIdentity, roomID id.RoomID) (crypto.VerificationRequestResponse, crypto.VerificationHooks)
+}
+
+// qrVerificationUI is implemented by UIs that handle QR code verification, which the crypto module doesn't support.
+type qrVerificationUI interface {
+	HandleQRVerificationEvent(evt *event.Event) bool
+}
+
+// Maximum number of incoming verification requests that can wait for the previous ones to be answered.
+const maxQueuedVerifications = 16
+
+// queueVerification runs the crypto module's handling of an incoming verification request or start event on a
+// separate goroutine, as the crypto module blocks until the user decides whether to accept the verification.
+func (c *Container) queueVerification(fn func()) {
+	select {
+	case c.verificationQueue <- fn:
+	default:
+		debug.Print("Too many pending verification requests, ignoring new one")
+	}
+}
+
+func (c *Container) processVerifications(queue chan func()) {
+	for fn := range queue {
+		func() {
+			defer debug.Recover()
+			fn()
+		}()
+	}
+}
+
+// processSyncResponse passes a sync response to the crypto module after letting the UI
+// take the to-device events that belong to QR code verifications. Verification requests
+// are handled separately, as answering them requires asking the user, and olm-encrypted
+// events that fail to decrypt start unwedging the olm session with the sender.
+func (c *Container) processSyncResponse(resp *mautrix.RespSync, since string) {
+	for _, evt := range resp.ToDevice.Events {
+		if isWithheldEvent(evt) {
+			c.handleWithheld(evt)
+		}
+	}
+	qui, ok := c.ui.MainView().(qrVerificationUI)
+	if ok && len(resp.ToDevice.Events) > 0 {
+		filtered := *resp
+		filtered.ToDevice.Events = make([]*event.Event, 0, len(resp.ToDevice.Events))
+		for _, evt := range resp.ToDevice.Events {
+			if !qui.HandleQRVerificationEvent(evt) {
+				filtered.ToDevice.Events = append(filtered.ToDevice.Events, evt)
+			}
+		}
+		resp = &filtered
+	}
+	if mach, ok := c.crypto.(*crypto.OlmMachine); ok && len(resp.ToDevice.Events) > 0 {
+		// Handle the to-device events here in the same way the crypto module would, and only let it process the rest.
+		logger, _ := mach.Log.(*cryptoLogger)
+		for _, evt := range resp.ToDevice.Events {
+			evt := evt
+			evt.Type.Class = event.ToDeviceEventType
+			if err := evt.Content.ParseRaw(evt.Type); err != nil {
+				debug.Printf("Failed to parse %s to-device event: %v", evt.Type.Type, err)
+				continue
+			}
+			switch evt.Type.Type {
+			case event.ToDeviceVerificationRequest.Type, event.ToDeviceVerificationStart.Type:
+				c.queueVerification(func() {
+					mach.HandleToDeviceEvent(evt)
+				})
+			case event.ToDeviceEncrypted.Type:
+				var failures uint32
+				if logger != nil {
+					failures = atomic.LoadUint32(&logger.olmFailures)
+				}
+				mach.HandleToDeviceEvent(evt)
+				if logger != nil && atomic.LoadUint32(&logger.olmFailures) != failures {
+					c.trackOlmFailure(evt)
+				}
+			default:
+				mach.HandleToDeviceEvent(evt)
+			}
+		}
+		c.retryReceivedSessions(mach)
+		filtered := *resp
+		filtered.ToDevice.Events = nil
+		resp = &filtered
+	}
+	c.crypto.ProcessSyncResponse(resp, since)
+}
+
+// keyShareUI is implemented by UIs that can ask the user whether to share keys with another device.
+// QueueKeyShare must not block, and the UI forwards the keys itself if the user accepts.
+type keyShareUI interface {
+	QueueKeyShare(device *crypto.DeviceIdentity, info event.RequestedKeyInfo) bool
+}
+
+// allowKeyShare decides whether room keys requested by another device should be shared with it.
+// Keys are only shared with our own devices, automatically if the device is trusted and
+// auto_forward_keys is enabled, otherwise after asking the user. As the crypto module calls this
+// on the sync goroutine, the question is queued and the request is left unanswered for now.
+func (c *Container) allowKeyShare(device *crypto.DeviceIdentity, info event.RequestedKeyInfo) *crypto.KeyShareRejection {
+	mach, ok := c.crypto.(*crypto.OlmMachine)
+	if device.UserID != c.config.UserID {
+		return &crypto.KeyShareRejectOtherUser
+	} else if device.DeviceID == c.config.DeviceID || !ok {
+		return &crypto.KeyShareRejectNoResponse
+	} else if device.Trust == crypto.TrustStateBlacklisted {
+		return &crypto.KeyShareRejectBlacklisted
+	} else if mach.IsDeviceTrusted(device) && c.config.AutoForwardKeys {
+		debug.Printf("Automatically sharing keys for %s with trusted device %s", info.SessionID, device.DeviceID)
+		return nil
+	}
+	if kui, ok := c.ui.MainView().(keyShareUI); !ok || !kui.QueueKeyShare(device, info) {
+		debug.Printf("Not asking about key request for %s from %s", info.SessionID, device.DeviceID)
+	}
+	return &crypto.KeyShareRejectNoResponse
+}
+
+// encryptionStateStore applies the per-room encryption overrides from the config
+// on top of the m.room.encryption events in the room cache.
+type encryptionStateStore struct {
+	*rooms.RoomCache
+	config *config.Config
+}
+
+func (store *encryptionStateStore) GetEncryptionEvent(roomID id.RoomID) *event.EncryptionEventContent {
+	content := store.RoomCache.GetEncryptionEvent(roomID)
+	override := store.config.GetRoomEncryption(roomID)
+	if content == nil || override == nil {
+		return content
+	}
+	return override.Apply(*content)
+}
+
+// sendToVerifiedOnly returns whether keys for the given room should only be shared with verified devices.
+func (c *Container) sendToVerifiedOnly(roomID id.RoomID) bool {
+	if override := c.config.GetRoomEncryption(roomID); override != nil && override.VerifiedOnly {
+		return true
+	}
+	return c.config.SendToVerifiedOnly
+}
+
+// invalidateStaleGroupSession discards the outbound group session of the given room if it was shared
+// under a different verified-only policy than the current one, so that the next message gets a new session.
+func (c *Container) invalidateStaleGroupSession(room *rooms.Room) {
+	mach, ok := c.crypto.(*crypto.OlmMachine)
+	if !ok {
+		return
+	}
+	verifiedOnly := c.sendToVerifiedOnly(room.ID)
+	c.shareLock.Lock()
+	defer c.shareLock.Unlock()
+	sharedVerifiedOnly, known := c.sharePolicy[room.ID]
+	// If the policy of the session isn't known (e.g. it was shared before a restart),
+	// it may have been shared with unverified devices.
+	if (known && sharedVerifiedOnly == verifiedOnly) || (!known && !verifiedOnly) {
+		return
+	}
+	debug.Printf("Verified-only policy of %s changed, discarding outbound group session", room.ID)
+	if err := mach.CryptoStore.RemoveOutboundGroupSession(room.ID); err != nil {
+		debug.Printf("[Crypto/Warn] Failed to remove outbound group session of %s: %v", room.ID, err)
+		return
+	}
+	delete(c.sharePolicy, room.ID)
+}
+
+// shareGroupSession shares the outbound group session of the given room with its members.
+// If the room or the global config only allows sending to verified devices, unverified devices won't get the keys.
+func (c *Container) shareGroupSession(room *rooms.Room) error {
+	mach, ok := c.crypto.(*crypto.OlmMachine)
+	if !ok {
+		return c.crypto.ShareGroupSession(room.ID, room.GetMemberList())
+	}
+	verifiedOnly := c.sendToVerifiedOnly(room.ID)
+	c.shareLock.Lock()
+	defer c.shareLock.Unlock()
+	err := shareGroupSessionWithPolicy(mach, room.ID, room.GetMemberList(), !verifiedOnly)
+	if err == nil {
+		if c.sharePolicy == nil {
+			c.sharePolicy = make(map[id.RoomID]bool)
+		}
+		c.sharePolicy[room.ID] = verifiedOnly
+	}
+	return err
+}
+
+// shareGroupSessionWithPolicy shares the outbound group session of a room using the given unverified device policy.
+// The machine only reads AllowUnverifiedDevices while sharing, so the policy is applied for the duration of the
+// share and the previous value is restored afterwards. The caller must hold the share lock.
+func shareGroupSessionWithPolicy(mach *crypto.OlmMachine, roomID id.RoomID, members []id.UserID, allowUnverified bool) error {
+	prevAllowUnverified := mach.AllowUnverifiedDevices
+	mach.AllowUnverifiedDevices = allowUnverified
+	defer func() {
+		mach.AllowUnverifiedDevices = prevAllowUnverified
+	}()
+	return mach.ShareGroupSession(roomID, members)
+}
+
 func (c *Container) initCrypto() error {
-	cryptoStore, err := crypto.NewGobStore(filepath.Join(c.config.DataDir, "crypto.gob"))
+	cryptoStore, err := crypto.NewGobStore(c.cryptoStorePath())
 	if err != nil {
 		return fmt.Errorf("failed to open crypto store: %w", err)
 	}
-	crypt := crypto.NewOlmMachine(c.client, cryptoLogger{}, cryptoStore, c.config.Rooms)
+	crypt := crypto.NewOlmMachine(c.client, &cryptoLogger{}, cryptoStore, &encryptionStateStore{c.config.Rooms, c.config})
 	crypt.AllowUnverifiedDevices = !c.config.SendToVerifiedOnly
+	if vui, ok := c.ui.MainView().(verificationUI); ok {
+		crypt.AcceptVerificationFrom = vui.AcceptVerificationFrom
+	}
+	crypt.AllowKeyShare = c.allowKeyShare
+	if c.verificationQueue == nil {
+		c.verificationQueue = make(chan func(), maxQueuedVerifications)
+		go c.processVerifications(c.verificationQueue)
+	}
 	c.crypto = crypt
 	err = c.crypto.Load()
 	if err != nil {
@@ -0,0 +1,258 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// +build cgo
+
+package matrix
+
+import (
+	"errors"
+	"fmt"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/olm"
+	"maunium.net/go/mautrix/crypto/ssss"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+// Device dehydration (MSC2697) stores a pickled olm account on the server, so that keys can be sent
+// to the user while they have no devices logged in. A new login can then claim the dehydrated device
+// and receive those keys. Like other clients, the account is pickled with the default SSSS key.
+const dehydrationAlgorithm = "org.matrix.msc2697.v1.olm.libolm_pickle"
+
+var errNoDehydratedDevice = errors.New("no dehydrated device found")
+
+type dehydratedDeviceData struct {
+	Algorithm string `json:"algorithm"`
+	Account   string `json:"account"`
+}
+
+type reqPutDehydratedDevice struct {
+	DeviceData  dehydratedDeviceData `json:"device_data"`
+	DisplayName string               `json:"initial_device_display_name,omitempty"`
+}
+
+type respDehydratedDevice struct {
+	DeviceID   id.DeviceID          `json:"device_id"`
+	DeviceData dehydratedDeviceData `json:"device_data"`
+}
+
+type reqClaimDehydratedDevice struct {
+	DeviceID id.DeviceID `json:"device_id"`
+}
+
+type respClaimDehydratedDevice struct {
+	Success bool `json:"success"`
+}
+
+// rehydrationUI is implemented by UIs that can ask the user whether to rehydrate a dehydrated device.
+// OfferRehydration blocks until the user has answered and the device has been rehydrated if they accepted.
+type rehydrationUI interface {
+	OfferRehydration(deviceID id.DeviceID)
+}
+
+func (c *Container) dehydrationURL(path ...string) string {
+	urlPath := []interface{}{"_matrix", "client", "unstable", "org.matrix.msc2697.v2", "dehydrated_device"}
+	for _, part := range path {
+		urlPath = append(urlPath, part)
+	}
+	return c.client.BuildBaseURL(urlPath...)
+}
+
+// GetDehydratedDevice returns the ID of the current dehydrated device of the user,
+// or an empty string if there is no dehydrated device.
+func (c *Container) GetDehydratedDevice() (id.DeviceID, error) {
+	resp, err := c.getDehydratedDevice()
+	if errors.Is(err, errNoDehydratedDevice) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return resp.DeviceID, nil
+}
+
+func (c *Container) getDehydratedDevice() (*respDehydratedDevice, error) {
+	var resp respDehydratedDevice
+	_, err := c.client.MakeRequest("GET", c.dehydrationURL(), nil, &resp)
+	if errors.Is(err, mautrix.MNotFound) {
+		return nil, errNoDehydratedDevice
+	} else if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DehydrateDevice creates a new dehydrated device whose olm account is encrypted with the given SSSS key,
+// and cross-signs it so that other users trust it. Any previous dehydrated device is replaced.
+func (c *Container) DehydrateDevice(key *ssss.Key) (id.DeviceID, error) {
+	mach, ok := c.crypto.(*crypto.OlmMachine)
+	if !ok {
+		return "", fmt.Errorf("encryption is not enabled")
+	}
+	account := olm.NewAccount()
+	account.GenOneTimeKeys(account.MaxNumberOfOneTimeKeys() / 2)
+	oneTimeKeys := account.OneTimeKeys()
+	account.MarkKeysAsPublished()
+
+	var resp respDehydratedDevice
+	_, err := c.client.MakeRequest("PUT", c.dehydrationURL(), &reqPutDehydratedDevice{
+		DeviceData: dehydratedDeviceData{
+			Algorithm: dehydrationAlgorithm,
+			Account:   string(account.Pickle(key.Key)),
+		},
+		DisplayName: "gomuks (dehydrated)",
+	}, &resp)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload dehydrated device: %w", err)
+	}
+	deviceID := resp.DeviceID
+	signingKeyID := id.NewKeyID(id.KeyAlgorithmEd25519, string(deviceID))
+
+	ed25519, curve25519 := account.IdentityKeys()
+	deviceKeys := &mautrix.DeviceKeys{
+		UserID:     c.config.UserID,
+		DeviceID:   deviceID,
+		Algorithms: []id.Algorithm{id.AlgorithmMegolmV1, id.AlgorithmOlmV1},
+		Keys: mautrix.KeyMap{
+			id.NewDeviceKeyID(id.KeyAlgorithmCurve25519, deviceID): string(curve25519),
+			id.NewDeviceKeyID(id.KeyAlgorithmEd25519, deviceID):    string(ed25519),
+		},
+	}
+	signature, err := account.SignJSON(deviceKeys)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign device keys: %w", err)
+	}
+	deviceKeys.Signatures = mautrix.Signatures{c.config.UserID: {signingKeyID: signature}}
+
+	signedOneTimeKeys := make(map[id.KeyID]mautrix.OneTimeKey, len(oneTimeKeys))
+	for keyID, key := range oneTimeKeys {
+		otk := mautrix.OneTimeKey{Key: key}
+		signature, _ = account.SignJSON(otk)
+		otk.Signatures = mautrix.Signatures{c.config.UserID: {signingKeyID: signature}}
+		otk.IsSigned = true
+		signedOneTimeKeys[id.NewKeyID(id.KeyAlgorithmSignedCurve25519, keyID)] = otk
+	}
+
+	_, err = c.client.MakeRequest("POST", c.client.BuildURL("keys", "upload", string(deviceID)), &mautrix.ReqUploadKeys{
+		DeviceKeys:  deviceKeys,
+		OneTimeKeys: signedOneTimeKeys,
+	}, &mautrix.RespUploadKeys{})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload keys of dehydrated device: %w", err)
+	}
+	debug.Print("Created dehydrated device", deviceID)
+	err = c.crossSignOwnDevice(mach, key, &crypto.DeviceIdentity{
+		UserID:      c.config.UserID,
+		DeviceID:    deviceID,
+		IdentityKey: curve25519,
+		SigningKey:  ed25519,
+	})
+	if err != nil {
+		return deviceID, fmt.Errorf("created dehydrated device %s, but failed to cross-sign it: %w", deviceID, err)
+	}
+	return deviceID, nil
+}
+
+// crossSignOwnDevice signs one of our devices with the self-signing key,
+// which is fetched from SSSS with the given key if it isn't cached.
+func (c *Container) crossSignOwnDevice(mach *crypto.OlmMachine, key *ssss.Key, device *crypto.DeviceIdentity) error {
+	if mach.CrossSigningKeys == nil {
+		if err := mach.FetchCrossSigningKeysFromSSSS(key); err != nil {
+			return fmt.Errorf("failed to fetch cross-signing keys: %w", err)
+		}
+	}
+	return mach.SignOwnDevice(device)
+}
+
+// RehydrateDevice claims the dehydrated device of the user and takes over its olm account, which means
+// the current session will use the device ID of the dehydrated device from now on. This is only possible
+// right after logging in, before the crypto module has uploaded keys for the device of the new login.
+// The rehydrated device is cross-signed and a new dehydrated device is created to replace it.
+func (c *Container) RehydrateDevice(key *ssss.Key) (id.DeviceID, error) {
+	mach, ok := c.crypto.(*crypto.OlmMachine)
+	if !ok {
+		return "", fmt.Errorf("encryption is not enabled")
+	}
+	if current, err := mach.CryptoStore.GetAccount(); err != nil {
+		return "", fmt.Errorf("failed to get current olm account: %w", err)
+	} else if current != nil && current.Shared {
+		return "", fmt.Errorf("this device has already uploaded its keys, log in again to rehydrate")
+	}
+	resp, err := c.getDehydratedDevice()
+	if err != nil {
+		return "", err
+	} else if resp.DeviceData.Algorithm != dehydrationAlgorithm {
+		return "", fmt.Errorf("unsupported dehydration algorithm %s", resp.DeviceData.Algorithm)
+	}
+	account, err := olm.AccountFromPickled([]byte(resp.DeviceData.Account), key.Key)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt dehydrated device (was it created with another SSSS key?): %w", err)
+	}
+
+	var claimResp respClaimDehydratedDevice
+	_, err = c.client.MakeRequest("POST", c.dehydrationURL("claim"), &reqClaimDehydratedDevice{DeviceID: resp.DeviceID}, &claimResp)
+	if err != nil {
+		return "", fmt.Errorf("failed to claim dehydrated device: %w", err)
+	} else if !claimResp.Success {
+		return "", fmt.Errorf("server refused to let us claim the dehydrated device")
+	}
+
+	err = mach.CryptoStore.PutAccount(&crypto.OlmAccount{Internal: *account, Shared: true})
+	if err != nil {
+		return "", fmt.Errorf("failed to store rehydrated account: %w", err)
+	}
+	c.client.DeviceID = resp.DeviceID
+	c.config.DeviceID = resp.DeviceID
+	c.config.Save()
+	err = mach.Load()
+	if err != nil {
+		return "", fmt.Errorf("failed to reload olm machine: %w", err)
+	}
+	debug.Print("Rehydrated device", resp.DeviceID)
+
+	if err = c.crossSignOwnDevice(mach, key, mach.OwnIdentity()); err != nil {
+		debug.Printf("Failed to cross-sign rehydrated device %s: %v", resp.DeviceID, err)
+	}
+	if _, err = c.DehydrateDevice(key); err != nil {
+		debug.Print("Failed to create new dehydrated device after rehydrating:", err)
+	}
+	return resp.DeviceID, nil
+}
+
+// offerRehydration asks the user whether to rehydrate the dehydrated device after a fresh login.
+// It must be called before the first sync, as the crypto module uploads the keys of the new device then.
+func (c *Container) offerRehydration() {
+	defer debug.Recover()
+	mach, ok := c.crypto.(*crypto.OlmMachine)
+	if !ok {
+		return
+	} else if account, err := mach.CryptoStore.GetAccount(); err != nil || (account != nil && account.Shared) {
+		return
+	}
+	deviceID, err := c.GetDehydratedDevice()
+	if err != nil {
+		debug.Print("Failed to check for dehydrated device:", err)
+		return
+	} else if len(deviceID) == 0 {
+		return
+	}
+	if rui, ok := c.ui.MainView().(rehydrationUI); ok {
+		rui.OfferRehydration(deviceID)
+	}
+}
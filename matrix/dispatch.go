@@ -0,0 +1,196 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+// The number of events of a single room that can be waiting for the listeners before the sync processing goroutines block.
+const listenerQueueSize = 128
+
+// DispatchStats contains backpressure metrics of the listener dispatcher.
+type DispatchStats struct {
+	// The total number of events passed to listeners.
+	Dispatched uint64
+	// The highest number of events that have been waiting in a single room's queue at once.
+	MaxDepth int
+	// How many times a queue was full, and how long the sync processing was blocked in total because of it.
+	Blocked     uint64
+	BlockedTime time.Duration
+}
+
+type dispatchItem struct {
+	source   mautrix.EventSource
+	evt      *event.Event
+	handlers []EventHandler
+	// If set, the item isn't an event and the function is called instead of listeners.
+	fn func()
+}
+
+type roomQueue struct {
+	items chan dispatchItem
+	// The number of items that have been or are about to be queued and haven't been handled yet.
+	// Protected by the queuesLock of the dispatcher.
+	pending int
+}
+
+// listenerDispatcher calls event listeners on a goroutine per room, so that the goroutines processing
+// a sync response only have to parse events and update room state, and a room with slow listeners doesn't
+// hold up other rooms. Events of each room are passed to listeners in the order they were dispatched.
+// Events that don't belong to a room (like presence and global account data) share a queue of their own.
+//
+// The queues are bounded: when the listeners of a room fall behind by more than listenerQueueSize events,
+// dispatching events of that room blocks until there's space again. A room's goroutine exits when its queue
+// is empty, and a new one is started for the next event.
+type listenerDispatcher struct {
+	queuesLock sync.Mutex
+	queues     map[id.RoomID]*roomQueue
+	closed     bool
+
+	statsLock sync.Mutex
+	stats     DispatchStats
+}
+
+func newListenerDispatcher() *listenerDispatcher {
+	return &listenerDispatcher{queues: make(map[id.RoomID]*roomQueue)}
+}
+
+func (d *listenerDispatcher) run(roomID id.RoomID, queue *roomQueue) {
+	defer debug.Recover()
+	for item := range queue.items {
+		if item.fn != nil {
+			item.fn()
+		} else {
+			for _, fn := range item.handlers {
+				fn(item.source, item.evt)
+			}
+		}
+		d.queuesLock.Lock()
+		queue.pending--
+		if queue.pending == 0 {
+			delete(d.queues, roomID)
+			d.queuesLock.Unlock()
+			return
+		}
+		d.queuesLock.Unlock()
+	}
+}
+
+func (d *listenerDispatcher) enqueue(roomID id.RoomID, item dispatchItem) {
+	d.queuesLock.Lock()
+	if d.closed && item.fn == nil {
+		d.queuesLock.Unlock()
+		return
+	}
+	queue, ok := d.queues[roomID]
+	if !ok {
+		queue = &roomQueue{items: make(chan dispatchItem, listenerQueueSize)}
+		d.queues[roomID] = queue
+		go d.run(roomID, queue)
+	}
+	queue.pending++
+	d.queuesLock.Unlock()
+
+	var blockedFor time.Duration
+	select {
+	case queue.items <- item:
+	default:
+		start := time.Now()
+		queue.items <- item
+		blockedFor = time.Since(start)
+	}
+	depth := len(queue.items)
+	d.statsLock.Lock()
+	if item.fn == nil {
+		d.stats.Dispatched++
+	}
+	if depth > d.stats.MaxDepth {
+		d.stats.MaxDepth = depth
+	}
+	if blockedFor > 0 {
+		d.stats.Blocked++
+		d.stats.BlockedTime += blockedFor
+	}
+	d.statsLock.Unlock()
+}
+
+// Dispatch queues the event to be passed to the given listeners.
+func (d *listenerDispatcher) Dispatch(handlers []EventHandler, source mautrix.EventSource, evt *event.Event) {
+	d.enqueue(evt.RoomID, dispatchItem{source: source, evt: evt, handlers: handlers})
+}
+
+// Call queues a function to be called after the listeners have handled all events of the given room dispatched before it.
+func (d *listenerDispatcher) Call(roomID id.RoomID, fn func()) {
+	d.enqueue(roomID, dispatchItem{fn: fn})
+}
+
+// AfterAll calls the given function once the listeners have handled all events dispatched before the call,
+// without waiting for it. If there are no queued events, the function is called immediately.
+func (d *listenerDispatcher) AfterAll(fn func()) {
+	d.queuesLock.Lock()
+	roomIDs := make([]id.RoomID, 0, len(d.queues))
+	for roomID := range d.queues {
+		roomIDs = append(roomIDs, roomID)
+	}
+	d.queuesLock.Unlock()
+	if len(roomIDs) == 0 {
+		fn()
+		return
+	}
+	var remaining sync.WaitGroup
+	remaining.Add(len(roomIDs))
+	for _, roomID := range roomIDs {
+		d.Call(roomID, remaining.Done)
+	}
+	go func() {
+		defer debug.Recover()
+		remaining.Wait()
+		fn()
+	}()
+}
+
+// Flush waits until the listeners have handled all events dispatched before the call.
+func (d *listenerDispatcher) Flush() {
+	flushed := make(chan struct{})
+	d.AfterAll(func() {
+		close(flushed)
+	})
+	<-flushed
+}
+
+// Stats returns a snapshot of the backpressure metrics.
+func (d *listenerDispatcher) Stats() DispatchStats {
+	d.statsLock.Lock()
+	defer d.statsLock.Unlock()
+	return d.stats
+}
+
+// Close makes the dispatcher drop further events. Events that have already been queued
+// and functions passed to Call are still handled.
+func (d *listenerDispatcher) Close() {
+	d.queuesLock.Lock()
+	d.closed = true
+	d.queuesLock.Unlock()
+}
@@ -0,0 +1,339 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	sync "github.com/sasha-s/go-deadlock"
+
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/interface"
+)
+
+const (
+	maxRecentDownloads       = 50
+	downloadProgressInterval = 500 * time.Millisecond
+	// Suffix of partially downloaded files in the media cache, which are resumed on the next download attempt.
+	partialDownloadSuffix = ".part"
+)
+
+// downloadManager keeps track of the files downloaded with DownloadToDisk.
+type downloadManager struct {
+	lock   sync.Mutex
+	list   []*ifc.Download
+	cancel map[int]context.CancelFunc
+	nextID int
+}
+
+func (dm *downloadManager) start(uri id.ContentURI, file *attachment.EncryptedFile, path string) (*ifc.Download, context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	if dm.cancel == nil {
+		dm.cancel = make(map[int]context.CancelFunc)
+	}
+	dm.nextID++
+	dl := &ifc.Download{
+		ID:      dm.nextID,
+		URI:     uri,
+		File:    file,
+		Name:    filepath.Base(path),
+		Path:    path,
+		Size:    -1,
+		Started: time.Now(),
+	}
+	dm.cancel[dl.ID] = cancel
+	dm.list = append(dm.list, dl)
+	if len(dm.list) > maxRecentDownloads {
+		dm.list = dm.list[len(dm.list)-maxRecentDownloads:]
+	}
+	return dl, ctx
+}
+
+func (dm *downloadManager) update(fn func()) {
+	dm.lock.Lock()
+	fn()
+	dm.lock.Unlock()
+}
+
+func (dm *downloadManager) finish(dl *ifc.Download, err error) {
+	dm.lock.Lock()
+	dl.Finished = time.Now()
+	dl.Err = err
+	if cancel, ok := dm.cancel[dl.ID]; ok {
+		cancel()
+		delete(dm.cancel, dl.ID)
+	}
+	dm.lock.Unlock()
+}
+
+// Downloads returns the recent downloads, newest first.
+func (c *Container) Downloads() []ifc.Download {
+	c.downloads.lock.Lock()
+	defer c.downloads.lock.Unlock()
+	list := make([]ifc.Download, len(c.downloads.list))
+	for i, dl := range c.downloads.list {
+		list[len(list)-1-i] = *dl
+	}
+	return list
+}
+
+// CancelDownload cancels the download with the given ID. The partially downloaded file is kept, so the
+// download can be resumed later. Returns false if the download doesn't exist or has already finished.
+func (c *Container) CancelDownload(downloadID int) bool {
+	c.downloads.lock.Lock()
+	defer c.downloads.lock.Unlock()
+	cancel, ok := c.downloads.cancel[downloadID]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// uniqueDownloadPath adds a number to the file name if a file with the same name already exists.
+func uniqueDownloadPath(path string) string {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return path
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// DownloadToDisk saves the given file to disk and returns the path it was saved to. If target is empty,
+// the file is only saved in the media cache. Relative targets are saved in the download directory with
+// a number added to the name if the file already exists, while absolute targets are overwritten.
+// Targets that aren't chosen by the user, like the file name in the message, must be sanitized by the caller.
+//
+// The file is downloaded into the media cache first, so interrupted downloads can be resumed.
+func (c *Container) DownloadToDisk(uri id.ContentURI, file *attachment.EncryptedFile, target string) (fullPath string, err error) {
+	cachePath := c.GetCachePath(uri)
+	if target == "" {
+		fullPath = cachePath
+	} else if !filepath.IsAbs(target) {
+		fullPath = uniqueDownloadPath(filepath.Join(c.config.DownloadDir, target))
+	} else {
+		fullPath = target
+	}
+
+	dl, ctx := c.downloads.start(uri, file, fullPath)
+	defer func() {
+		c.downloads.finish(dl, err)
+		c.ui.Render()
+	}()
+
+	if stat, statErr := os.Stat(cachePath); os.IsNotExist(statErr) {
+		err = c.fetchMedia(ctx, dl, file, cachePath)
+		if err != nil {
+			return
+		}
+	} else if statErr == nil {
+		c.touchCachedMedia(cachePath)
+		c.downloads.update(func() {
+			dl.Size = stat.Size()
+			dl.Received = stat.Size()
+		})
+	}
+
+	if fullPath != cachePath {
+		err = os.MkdirAll(filepath.Dir(fullPath), 0700)
+		if err != nil {
+			return
+		}
+		err = cp(cachePath, fullPath)
+	}
+	return
+}
+
+// fetchMedia downloads a file into the media cache, continuing from a previous partial download with a
+// Range request if the server supports it, and decrypts it if necessary.
+func (c *Container) fetchMedia(ctx context.Context, dl *ifc.Download, file *attachment.EncryptedFile, cachePath string) error {
+	partPath := cachePath + partialDownloadSuffix
+	var offset int64
+	if stat, err := os.Stat(partPath); err == nil {
+		offset = stat.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.client.GetDownloadURL(dl.URI), nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.client.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if start, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || start != offset {
+			if offset == 0 {
+				return fmt.Errorf("server returned unexpected range %q", resp.Header.Get("Content-Range"))
+			}
+			debug.Printf("Server returned range %q for %s when resuming from byte %d, restarting download",
+				resp.Header.Get("Content-Range"), dl.URI, offset)
+			return c.restartFetchMedia(ctx, dl, file, cachePath, resp)
+		}
+		debug.Printf("Resuming download of %s from byte %d", dl.URI, offset)
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		if offset == 0 {
+			return fmt.Errorf("server returned HTTP %d", resp.StatusCode)
+		} else if _, total, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || total != offset {
+			debug.Printf("Server rejected range for %s when resuming from byte %d, restarting download", dl.URI, offset)
+			return c.restartFetchMedia(ctx, dl, file, cachePath, resp)
+		}
+		// The previous attempt already received the whole file, it just wasn't finished.
+		c.downloads.update(func() {
+			dl.Resumed = true
+			dl.Received = offset
+			dl.Size = offset
+		})
+		return c.finishFetchMedia(file, cachePath)
+	case http.StatusOK:
+		// The server doesn't support ranges or there was no partial download, so start from the beginning.
+		offset = 0
+		flags |= os.O_TRUNC
+	default:
+		return fmt.Errorf("server returned HTTP %d", resp.StatusCode)
+	}
+	size := int64(-1)
+	if resp.ContentLength >= 0 {
+		size = offset + resp.ContentLength
+	}
+	c.downloads.update(func() {
+		dl.Resumed = offset > 0
+		dl.Received = offset
+		dl.Size = size
+	})
+
+	out, err := os.OpenFile(partPath, flags, 0600)
+	if err != nil {
+		return err
+	}
+	received := offset
+	lastRender := time.Now()
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err = out.Write(buf[:n]); err != nil {
+				_ = out.Close()
+				return err
+			}
+			received += int64(n)
+			c.downloads.update(func() {
+				dl.Received = received
+			})
+			if time.Since(lastRender) >= downloadProgressInterval {
+				lastRender = time.Now()
+				c.ui.Render()
+			}
+		}
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			_ = out.Close()
+			return readErr
+		}
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return c.finishFetchMedia(file, cachePath)
+}
+
+// restartFetchMedia discards the partial download of a file and downloads it again from the beginning.
+func (c *Container) restartFetchMedia(ctx context.Context, dl *ifc.Download, file *attachment.EncryptedFile, cachePath string, resp *http.Response) error {
+	_ = resp.Body.Close()
+	if err := os.Remove(cachePath + partialDownloadSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return c.fetchMedia(ctx, dl, file, cachePath)
+}
+
+// finishFetchMedia decrypts a completed download if necessary and moves it into the media cache.
+func (c *Container) finishFetchMedia(file *attachment.EncryptedFile, cachePath string) error {
+	partPath := cachePath + partialDownloadSuffix
+	data, err := ioutil.ReadFile(partPath)
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		data, err = file.Decrypt(data)
+		if err != nil {
+			// The partial file is probably corrupted, so don't try to resume it next time.
+			_ = os.Remove(partPath)
+			return err
+		}
+	}
+	if err = ioutil.WriteFile(cachePath, data, 0600); err != nil {
+		return err
+	}
+	c.cachedMediaWritten(int64(len(data)))
+	return os.Remove(partPath)
+}
+
+// parseContentRange parses a Content-Range header in the form "bytes <start>-<end>/<total>" or "bytes */<total>".
+// start is -1 for the unsatisfied range form and total is -1 if the server doesn't know the full size.
+func parseContentRange(header string) (start, total int64, ok bool) {
+	if !strings.HasPrefix(header, "bytes ") {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(header, "bytes "), "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	var err error
+	if parts[1] == "*" {
+		total = -1
+	} else if total, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return 0, 0, false
+	}
+	if parts[0] == "*" {
+		return -1, total, true
+	}
+	dash := strings.IndexByte(parts[0], '-')
+	if dash <= 0 {
+		return 0, 0, false
+	} else if start, err = strconv.ParseInt(parts[0][:dash], 10, 64); err != nil {
+		return 0, 0, false
+	}
+	return start, total, true
+}
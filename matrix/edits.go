@@ -0,0 +1,120 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"net/url"
+	"sort"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/matrix/rooms"
+)
+
+// The maximum number of relation pages to fetch when loading the edit history of an event.
+const maxEditHistoryPages = 10
+
+type respRelations struct {
+	Chunk     []*event.Event `json:"chunk"`
+	NextBatch string         `json:"next_batch"`
+}
+
+// GetEditHistory returns the given event and its edits, oldest first.
+//
+// If no edits of the event are cached locally, they're fetched from the server
+// using the relations API and stored in the local cache.
+func (c *Container) GetEditHistory(room *rooms.Room, eventID id.EventID) (*muksevt.Event, []*muksevt.Event, error) {
+	evt, err := c.GetEvent(room, eventID)
+	if err != nil {
+		return nil, nil, err
+	} else if len(evt.Gomuks.Edits) > 0 {
+		return evt, evt.Gomuks.Edits, nil
+	}
+
+	edits, err := c.fetchEdits(room, evt)
+	if err != nil {
+		return evt, nil, err
+	} else if len(edits) == 0 {
+		return evt, nil, nil
+	}
+	err = c.history.Update(room, eventID, func(stored *muksevt.Event) error {
+		if len(stored.Gomuks.Edits) == 0 {
+			stored.Gomuks.Edits = edits
+		}
+		return nil
+	})
+	if err != nil && err != EventNotFoundError {
+		debug.Printf("Failed to store fetched edits of %s: %v", eventID, err)
+	}
+	return evt, edits, nil
+}
+
+func (c *Container) fetchEdits(room *rooms.Room, orig *muksevt.Event) ([]*muksevt.Event, error) {
+	var edits []*muksevt.Event
+	var from string
+	for page := 0; page < maxEditHistoryPages; page++ {
+		query := url.Values{"limit": {"50"}}
+		if len(from) > 0 {
+			query.Set("from", from)
+		}
+		u := c.client.BuildBaseURL("_matrix", "client", "unstable", "rooms", room.ID, "relations", orig.ID, event.RelReplace)
+		var resp respRelations
+		_, err := c.client.MakeRequest("GET", u+"?"+query.Encode(), nil, &resp)
+		if err != nil {
+			return nil, err
+		}
+		for _, evt := range resp.Chunk {
+			if edit := c.parseFetchedEdit(evt); edit != nil && edit.Sender == orig.Sender {
+				edits = append(edits, edit)
+			}
+		}
+		if len(resp.NextBatch) == 0 || len(resp.Chunk) == 0 {
+			break
+		}
+		from = resp.NextBatch
+	}
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].Timestamp < edits[j].Timestamp
+	})
+	debug.Printf("Fetched %d edits of %s from server", len(edits), orig.ID)
+	return edits, nil
+}
+
+func (c *Container) parseFetchedEdit(evt *event.Event) *muksevt.Event {
+	err := evt.Content.ParseRaw(evt.Type)
+	if err != nil {
+		debug.Printf("Failed to parse fetched edit %s: %v", evt.ID, err)
+		return nil
+	}
+	if evt.Type == event.EventEncrypted {
+		if c.crypto == nil {
+			return nil
+		}
+		decrypted, err := c.crypto.DecryptMegolmEvent(evt)
+		if err != nil {
+			debug.Printf("Failed to decrypt fetched edit %s: %v", evt.ID, err)
+			return nil
+		}
+		return muksevt.WrapDecrypted(decrypted, evt)
+	} else if evt.Type != event.EventMessage {
+		return nil
+	}
+	return muksevt.Wrap(evt)
+}
@@ -0,0 +1,273 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	sync "github.com/sasha-s/go-deadlock"
+	"golang.org/x/net/html"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/matrix/muksevt"
+)
+
+// emoteState holds the account data parts of the user's MSC2545 image packs.
+// Room packs are read from the room state when needed.
+type emoteState struct {
+	lock       sync.Mutex
+	loaded     bool
+	userPack   *muksevt.EmotePackContent
+	emoteRooms *muksevt.EmoteRoomsContent
+}
+
+func (c *Container) HandleEmotes(source mautrix.EventSource, evt *event.Event) {
+	if source&mautrix.EventSourceAccountData == 0 {
+		return
+	}
+	c.emotes.lock.Lock()
+	defer c.emotes.lock.Unlock()
+	switch content := evt.Content.Parsed.(type) {
+	case *muksevt.EmotePackContent:
+		c.emotes.userPack = content
+	case *muksevt.EmoteRoomsContent:
+		c.emotes.emoteRooms = content
+	}
+}
+
+func (c *Container) fetchEmoteAccountData(eventType event.Type, into interface{}) bool {
+	data, err := c.GetAccountData("", eventType.Type)
+	if errors.Is(err, mautrix.MNotFound) {
+		return false
+	} else if err != nil {
+		debug.Printf("Failed to fetch %s: %v", eventType.Type, err)
+		return false
+	} else if err = json.Unmarshal(data, into); err != nil {
+		debug.Printf("Failed to parse %s: %v", eventType.Type, err)
+		return false
+	}
+	return true
+}
+
+// emoteAccountData returns the user's personal pack and the list of globally enabled room packs.
+// The account data is only sent in /sync when it changes, so it's fetched from the server on first use.
+func (c *Container) emoteAccountData() (*muksevt.EmotePackContent, *muksevt.EmoteRoomsContent) {
+	c.emotes.lock.Lock()
+	defer c.emotes.lock.Unlock()
+	if !c.emotes.loaded && c.client != nil {
+		c.emotes.loaded = true
+		var userPack muksevt.EmotePackContent
+		if c.emotes.userPack == nil && c.fetchEmoteAccountData(muksevt.AccountDataUserEmotes, &userPack) {
+			c.emotes.userPack = &userPack
+		}
+		var emoteRooms muksevt.EmoteRoomsContent
+		if c.emotes.emoteRooms == nil && c.fetchEmoteAccountData(muksevt.AccountDataEmoteRooms, &emoteRooms) {
+			c.emotes.emoteRooms = &emoteRooms
+		}
+	}
+	return c.emotes.userPack, c.emotes.emoteRooms
+}
+
+func hasEmoteUsage(usages []muksevt.EmoteUsage, usage muksevt.EmoteUsage) bool {
+	for _, item := range usages {
+		if item == usage {
+			return true
+		}
+	}
+	return false
+}
+
+func makeEmotePack(content *muksevt.EmotePackContent, fallbackName string) *ifc.EmotePack {
+	pack := &ifc.EmotePack{DisplayName: content.Pack.DisplayName}
+	if len(pack.DisplayName) == 0 {
+		pack.DisplayName = fallbackName
+	}
+	for shortcode, image := range content.Images {
+		if image == nil {
+			continue
+		}
+		uri, err := image.URL.Parse()
+		if err != nil {
+			continue
+		}
+		usage := image.Usage
+		if len(usage) == 0 {
+			usage = content.Pack.Usage
+		}
+		emote := ifc.Emote{
+			Shortcode: shortcode,
+			URL:       uri,
+			Body:      image.Body,
+			Info:      image.Info,
+			Emoticon:  len(usage) == 0 || hasEmoteUsage(usage, muksevt.EmoteUsageEmoticon),
+			Sticker:   len(usage) == 0 || hasEmoteUsage(usage, muksevt.EmoteUsageSticker),
+		}
+		if len(emote.Body) == 0 {
+			emote.Body = shortcode
+		}
+		pack.Emotes = append(pack.Emotes, emote)
+	}
+	sort.Slice(pack.Emotes, func(i, j int) bool {
+		return pack.Emotes[i].Shortcode < pack.Emotes[j].Shortcode
+	})
+	return pack
+}
+
+// roomEmotePacks returns the image packs defined in the state of the given room.
+// If stateKeys is not nil, only the packs with those state keys are returned.
+func (c *Container) roomEmotePacks(roomID id.RoomID, stateKeys map[string]interface{}) (packs []*ifc.EmotePack) {
+	room := c.GetRoom(roomID)
+	if room == nil {
+		return
+	}
+	events := room.GetStateEvents(muksevt.StateRoomEmotes)
+	keys := make([]string, 0, len(events))
+	for stateKey := range events {
+		if _, ok := stateKeys[stateKey]; ok || stateKeys == nil {
+			keys = append(keys, stateKey)
+		}
+	}
+	sort.Strings(keys)
+	for _, stateKey := range keys {
+		content, ok := events[stateKey].Content.Parsed.(*muksevt.EmotePackContent)
+		if !ok || len(content.Images) == 0 {
+			continue
+		}
+		name := room.GetTitle()
+		if len(stateKey) > 0 {
+			name = fmt.Sprintf("%s (%s)", name, stateKey)
+		}
+		packs = append(packs, makeEmotePack(content, name))
+	}
+	return
+}
+
+// GetEmotePacks returns the image packs that can be used in the given room: the packs of the room itself,
+// the user's personal pack and the room packs the user has enabled globally.
+func (c *Container) GetEmotePacks(roomID id.RoomID) []*ifc.EmotePack {
+	packs := c.roomEmotePacks(roomID, nil)
+	userPack, emoteRooms := c.emoteAccountData()
+	if userPack != nil && len(userPack.Images) > 0 {
+		packs = append(packs, makeEmotePack(userPack, "Personal"))
+	}
+	if emoteRooms != nil {
+		otherRooms := make([]id.RoomID, 0, len(emoteRooms.Rooms))
+		for otherRoomID := range emoteRooms.Rooms {
+			if otherRoomID != roomID {
+				otherRooms = append(otherRooms, otherRoomID)
+			}
+		}
+		sort.Slice(otherRooms, func(i, j int) bool {
+			return otherRooms[i] < otherRooms[j]
+		})
+		for _, otherRoomID := range otherRooms {
+			stateKeys := emoteRooms.Rooms[otherRoomID]
+			if stateKeys == nil {
+				stateKeys = map[string]interface{}{}
+			}
+			packs = append(packs, c.roomEmotePacks(otherRoomID, stateKeys)...)
+		}
+	}
+	return packs
+}
+
+var (
+	emoteShortcodeRegex = regexp.MustCompile(`:([a-zA-Z0-9_.+-]+):`)
+)
+
+// insertEmotes replaces the :shortcodes: of custom emoticons in the formatted body with inline images.
+// Only text is replaced, so shortcodes in tag attributes and code blocks are left as-is.
+func (c *Container) insertEmotes(roomID id.RoomID, content *event.MessageEventContent) {
+	if !emoteShortcodeRegex.MatchString(content.Body) {
+		return
+	}
+	formatted := content.FormattedBody
+	if content.Format != event.FormatHTML {
+		formatted = strings.Replace(html.EscapeString(content.Body), "\n", "<br/>", -1)
+	}
+	emotes := make(map[string]ifc.Emote)
+	packs := c.GetEmotePacks(roomID)
+	for i := len(packs) - 1; i >= 0; i-- {
+		for _, emote := range packs[i].Emotes {
+			if emote.Emoticon {
+				emotes[emote.Shortcode] = emote
+			}
+		}
+	}
+	replaced := false
+	replace := func(text string) string {
+		return emoteShortcodeRegex.ReplaceAllStringFunc(text, func(match string) string {
+			emote, ok := emotes[match[1:len(match)-1]]
+			if !ok {
+				return match
+			}
+			replaced = true
+			return fmt.Sprintf(`<img data-mx-emoticon src="%s" alt="%[2]s" title="%[2]s" height="32"/>`,
+				html.EscapeString(emote.URL.String()), html.EscapeString(match))
+		})
+	}
+	var buf strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(formatted))
+	codeDepth := 0
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		raw := string(tokenizer.Raw())
+		switch tokenType {
+		case html.StartTagToken, html.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "pre" || string(name) == "code" {
+				if tokenType == html.StartTagToken {
+					codeDepth++
+				} else if codeDepth > 0 {
+					codeDepth--
+				}
+			}
+		case html.TextToken:
+			if codeDepth == 0 {
+				raw = replace(raw)
+			}
+		}
+		buf.WriteString(raw)
+	}
+	if replaced {
+		content.Format = event.FormatHTML
+		content.FormattedBody = buf.String()
+	}
+}
+
+// PrepareSticker creates an m.sticker event for the given image pack entry.
+func (c *Container) PrepareSticker(roomID id.RoomID, sticker ifc.Emote, rel *ifc.Relation) *muksevt.Event {
+	evt := c.prepareEvent(roomID, &event.MessageEventContent{
+		Body: sticker.Body,
+		URL:  sticker.URL.CUString(),
+		Info: sticker.Info,
+	}, rel)
+	evt.Type = event.EventSticker
+	return evt
+}
@@ -0,0 +1,146 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// federationTracker keeps track of when events were last seen from each remote server and
+// which DMs have had messages fail to send.
+type federationTracker struct {
+	lock sync.Mutex
+	// Server name -> when something from a user on that server was last seen.
+	lastSeen map[string]time.Time
+	// Room ID -> when sending a message to the room last failed.
+	failed map[id.RoomID]time.Time
+}
+
+func serverOf(userID id.UserID) string {
+	_, server, _ := userID.Parse()
+	return server
+}
+
+// trackServerActivity is a global sync listener that records activity from remote servers.
+// Events in the initial sync are old, so they don't say anything about whether a server is reachable now.
+func (c *Container) trackServerActivity(resp *mautrix.RespSync, since string) {
+	if since == "" {
+		return
+	}
+	now := time.Now()
+	ft := &c.federation
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+	if ft.lastSeen == nil {
+		ft.lastSeen = make(map[string]time.Time)
+	}
+	seen := func(userID id.UserID) {
+		if server := serverOf(userID); len(server) > 0 {
+			ft.lastSeen[server] = now
+		}
+	}
+	for _, room := range resp.Rooms.Join {
+		for _, evt := range room.Timeline.Events {
+			seen(evt.Sender)
+		}
+		for _, evt := range room.Ephemeral.Events {
+			if evt.Type != event.EphemeralEventReceipt {
+				continue
+			}
+			// The content is parsed later when the room is processed, so decode the raw JSON here.
+			var content map[id.EventID]struct {
+				Read map[id.UserID]json.RawMessage `json:"m.read"`
+			}
+			if err := json.Unmarshal(evt.Content.VeryRaw, &content); err != nil {
+				continue
+			}
+			for _, receipts := range content {
+				for userID := range receipts.Read {
+					seen(userID)
+				}
+			}
+		}
+	}
+}
+
+// remoteDMServer returns the server of the other user in the given DM, or an empty string if
+// the room isn't a DM with a user on another server.
+func (c *Container) remoteDMServer(roomID id.RoomID) string {
+	room := c.GetRoom(roomID)
+	if room == nil || !room.IsDirect || len(room.OtherUser) == 0 {
+		return ""
+	}
+	server := serverOf(room.OtherUser)
+	if server == serverOf(c.config.UserID) {
+		return ""
+	}
+	return server
+}
+
+// isRemoteSendError checks if sending failed even though the homeserver responded, which in a DM with
+// a user on another server usually means that the homeserver couldn't reach the other server.
+func isRemoteSendError(err error) bool {
+	var httpErr mautrix.HTTPError
+	return errors.As(err, &httpErr) && httpErr.Response != nil && httpErr.Response.StatusCode >= 500
+}
+
+// noteSendResult records whether sending a message to the given room worked, so that messages
+// stuck in DMs can be marked when the other user's server seems to be the reason.
+func (c *Container) noteSendResult(roomID id.RoomID, err error) {
+	if len(c.remoteDMServer(roomID)) == 0 {
+		return
+	}
+	ft := &c.federation
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+	if err == nil || !isRemoteSendError(err) {
+		delete(ft.failed, roomID)
+		return
+	}
+	if ft.failed == nil {
+		ft.failed = make(map[id.RoomID]time.Time)
+	}
+	ft.failed[roomID] = time.Now()
+}
+
+// RemoteServerUnreachable returns true if the given room is a DM with a user on another server,
+// the last message sent to the room failed with an error from the homeserver, and nothing has been
+// heard from the other server since.
+func (c *Container) RemoteServerUnreachable(roomID id.RoomID) bool {
+	server := c.remoteDMServer(roomID)
+	if len(server) == 0 {
+		return false
+	}
+	ft := &c.federation
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+	failedAt, ok := ft.failed[roomID]
+	if !ok {
+		return false
+	} else if ft.lastSeen[server].After(failedAt) {
+		delete(ft.failed, roomID)
+		return false
+	}
+	return true
+}
@@ -29,6 +29,7 @@ import (
 	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 
+	"maunium.net/go/gomuks/lib/cachecrypt"
 	"maunium.net/go/gomuks/matrix/muksevt"
 	"maunium.net/go/gomuks/matrix/rooms"
 )
@@ -36,7 +37,8 @@ import (
 type HistoryManager struct {
 	sync.Mutex
 
-	db *bolt.DB
+	db     *bolt.DB
+	cipher *cachecrypt.Cipher
 
 	historyEndPtr map[*rooms.Room]uint64
 }
@@ -47,8 +49,11 @@ var bucketStreamPointers = []byte("room_stream_pointers")
 
 const halfUint64 = ^uint64(0) >> 1
 
-func NewHistoryManager(dbPath string) (*HistoryManager, error) {
+// NewHistoryManager opens the history database at the given path. If the cipher is not nil,
+// event data is encrypted before storing. Room and event IDs are stored as plaintext keys.
+func NewHistoryManager(dbPath string, cipher *cachecrypt.Cipher) (*HistoryManager, error) {
 	hm := &HistoryManager{
+		cipher:        cipher,
 		historyEndPtr: make(map[*rooms.Room]uint64),
 	}
 	db, err := bolt.Open(dbPath, 0600, &bolt.Options{
@@ -108,7 +113,7 @@ func (hm *HistoryManager) getEvent(tx *bolt.Tx, stream *bolt.Bucket, index []byt
 	if eventData == nil || len(eventData) == 0 {
 		return nil, EventNotFoundError
 	}
-	return unmarshalEvent(eventData)
+	return hm.unmarshalEvent(eventData)
 }
 
 func (hm *HistoryManager) Get(room *rooms.Room, eventID id.EventID) (evt *muksevt.Event, err error) {
@@ -131,7 +136,7 @@ func (hm *HistoryManager) Update(room *rooms.Room, eventID id.EventID, update fu
 			return err
 		} else if err = update(evt); err != nil {
 			return err
-		} else if eventData, err := marshalEvent(evt); err != nil {
+		} else if eventData, err := hm.marshalEvent(evt); err != nil {
 			return err
 		} else if err := stream.Put(index, eventData); err != nil {
 			return err
@@ -140,16 +145,16 @@ func (hm *HistoryManager) Update(room *rooms.Room, eventID id.EventID, update fu
 	})
 }
 
-func (hm *HistoryManager) Append(room *rooms.Room, events []*event.Event) ([]*muksevt.Event, error) {
+func (hm *HistoryManager) Append(room *rooms.Room, events []*muksevt.Event) ([]*muksevt.Event, error) {
 	muksEvts, _, err := hm.store(room, events, true)
 	return muksEvts, err
 }
 
-func (hm *HistoryManager) Prepend(room *rooms.Room, events []*event.Event) ([]*muksevt.Event, uint64, error) {
+func (hm *HistoryManager) Prepend(room *rooms.Room, events []*muksevt.Event) ([]*muksevt.Event, uint64, error) {
 	return hm.store(room, events, false)
 }
 
-func (hm *HistoryManager) store(room *rooms.Room, events []*event.Event, append bool) (newEvents []*muksevt.Event, newPtrStart uint64, err error) {
+func (hm *HistoryManager) store(room *rooms.Room, events []*muksevt.Event, append bool) (newEvents []*muksevt.Event, newPtrStart uint64, err error) {
 	hm.Lock()
 	defer hm.Unlock()
 	newEvents = make([]*muksevt.Event, len(events))
@@ -178,8 +183,8 @@ func (hm *HistoryManager) store(room *rooms.Room, events []*event.Event, append
 				return err
 			}
 			for i, evt := range events {
-				newEvents[i] = muksevt.Wrap(evt)
-				if err := put(stream, eventIDs, newEvents[i], ptrStart+uint64(i)); err != nil {
+				newEvents[i] = evt
+				if err := hm.put(stream, eventIDs, newEvents[i], ptrStart+uint64(i)); err != nil {
 					return err
 				}
 			}
@@ -199,8 +204,8 @@ func (hm *HistoryManager) store(room *rooms.Room, events []*event.Event, append
 			}
 			eventCount := uint64(len(events))
 			for i, evt := range events {
-				newEvents[i] = muksevt.Wrap(evt)
-				if err := put(stream, eventIDs, newEvents[i], -ptrStart-uint64(i)); err != nil {
+				newEvents[i] = evt
+				if err := hm.put(stream, eventIDs, newEvents[i], -ptrStart-uint64(i)); err != nil {
 					return err
 				}
 			}
@@ -237,7 +242,7 @@ func (hm *HistoryManager) Load(room *rooms.Room, num int, ptrStart uint64) (even
 		}
 		newPtrStart = ptrStartFound
 		for ; k != nil && btoi(k) < ptrStart; k, v = c.Next() {
-			evt, parseError := unmarshalEvent(v)
+			evt, parseError := hm.unmarshalEvent(v)
 			if parseError != nil {
 				return parseError
 			}
@@ -256,6 +261,81 @@ func (hm *HistoryManager) Load(room *rooms.Room, num int, ptrStart uint64) (even
 	return
 }
 
+// ForEach calls the given function for every stored event in the given room, oldest first.
+func (hm *HistoryManager) ForEach(room *rooms.Room, fn func(evt *muksevt.Event) error) error {
+	hm.Lock()
+	defer hm.Unlock()
+	return hm.db.View(func(tx *bolt.Tx) error {
+		stream := tx.Bucket(bucketRoomStreams).Bucket([]byte(room.ID))
+		if stream == nil {
+			return nil
+		}
+		return stream.ForEach(func(_, v []byte) error {
+			evt, err := hm.unmarshalEvent(v)
+			if err != nil {
+				return err
+			}
+			return fn(evt)
+		})
+	})
+}
+
+// storedEvent is the location and size of an event in the history database, used when pruning.
+type storedEvent struct {
+	key       []byte
+	eventID   id.EventID
+	timestamp int64
+	size      int
+}
+
+// listEvents returns the stored events of the given room, oldest first.
+func (hm *HistoryManager) listEvents(room *rooms.Room) (events []storedEvent, err error) {
+	hm.Lock()
+	defer hm.Unlock()
+	err = hm.db.View(func(tx *bolt.Tx) error {
+		stream := tx.Bucket(bucketRoomStreams).Bucket([]byte(room.ID))
+		if stream == nil {
+			return nil
+		}
+		return stream.ForEach(func(k, v []byte) error {
+			evt, err := hm.unmarshalEvent(v)
+			if err != nil {
+				return err
+			}
+			key := make([]byte, len(k))
+			copy(key, k)
+			events = append(events, storedEvent{key, evt.ID, evt.Timestamp, len(k) + len(v)})
+			return nil
+		})
+	})
+	return
+}
+
+// Delete removes the given events from the history of the room.
+func (hm *HistoryManager) Delete(room *rooms.Room, events []storedEvent) error {
+	hm.Lock()
+	defer hm.Unlock()
+	return hm.db.Update(func(tx *bolt.Tx) error {
+		rid := []byte(room.ID)
+		stream := tx.Bucket(bucketRoomStreams).Bucket(rid)
+		eventIDs := tx.Bucket(bucketRoomEventIDs).Bucket(rid)
+		if stream == nil || eventIDs == nil {
+			return RoomNotFoundError
+		}
+		for _, evt := range events {
+			if err := stream.Delete(evt.key); err != nil {
+				return err
+			} else if !bytes.Equal(eventIDs.Get([]byte(evt.eventID)), evt.key) {
+				// The event ID index points at another copy of the event.
+				continue
+			} else if err = eventIDs.Delete([]byte(evt.eventID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func itob(v uint64) []byte {
 	b := make([]byte, 8)
 	binary.BigEndian.PutUint64(b, v)
@@ -274,7 +354,7 @@ func stripRaw(evt *muksevt.Event) {
 	evt.Event = &evtCopy
 }
 
-func marshalEvent(evt *muksevt.Event) ([]byte, error) {
+func (hm *HistoryManager) marshalEvent(evt *muksevt.Event) ([]byte, error) {
 	stripRaw(evt)
 	var buf bytes.Buffer
 	enc, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
@@ -284,12 +364,14 @@ func marshalEvent(evt *muksevt.Event) ([]byte, error) {
 	} else if err := enc.Close(); err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	return hm.cipher.Encrypt(buf.Bytes())
 }
 
-func unmarshalEvent(data []byte) (*muksevt.Event, error) {
+func (hm *HistoryManager) unmarshalEvent(data []byte) (*muksevt.Event, error) {
 	evt := &muksevt.Event{}
-	if cmpReader, err := gzip.NewReader(bytes.NewReader(data)); err != nil {
+	if data, err := hm.cipher.Decrypt(data); err != nil {
+		return nil, err
+	} else if cmpReader, err := gzip.NewReader(bytes.NewReader(data)); err != nil {
 		return nil, err
 	} else if err := gob.NewDecoder(cmpReader).Decode(evt); err != nil {
 		_ = cmpReader.Close()
@@ -300,8 +382,8 @@ func unmarshalEvent(data []byte) (*muksevt.Event, error) {
 	return evt, nil
 }
 
-func put(streams, eventIDs *bolt.Bucket, evt *muksevt.Event, key uint64) error {
-	data, err := marshalEvent(evt)
+func (hm *HistoryManager) put(streams, eventIDs *bolt.Bucket, evt *muksevt.Event, key uint64) error {
+	data, err := hm.marshalEvent(evt)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,164 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/matrix/muksevt"
+)
+
+const tileSize = 256
+
+var locationClient = &http.Client{Timeout: 5 * time.Second}
+
+// locationCache remembers reverse geocoding results and minimaps so that each location is only looked up once.
+type locationCache struct {
+	lock      sync.Mutex
+	addresses map[string]string
+	minimaps  map[string][]byte
+}
+
+func locationKey(lat, lon float64, extra int) string {
+	return fmt.Sprintf("%.6f,%.6f,%d", lat, lon, extra)
+}
+
+func (c *Container) getLocationURL(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Both Nominatim and the OpenStreetMap tile server require an identifying user agent.
+	req.Header.Set("User-Agent", c.client.UserAgent)
+	resp, err := locationClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("server returned HTTP %d", resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// ReverseGeocode looks up a human-readable description of the given coordinates with the configured
+// geocoding service. It returns an empty string if reverse geocoding isn't configured.
+func (c *Container) ReverseGeocode(lat, lon float64) (string, error) {
+	url := c.config.Location.GetGeocodeURL(lat, lon)
+	if len(url) == 0 {
+		return "", nil
+	}
+	key := locationKey(lat, lon, 0)
+	c.location.lock.Lock()
+	defer c.location.lock.Unlock()
+	if address, ok := c.location.addresses[key]; ok {
+		return address, nil
+	}
+	data, err := c.getLocationURL(url)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		DisplayName string `json:"display_name"`
+	}
+	if err = json.Unmarshal(data, &resp); err != nil {
+		return "", err
+	}
+	if c.location.addresses == nil {
+		c.location.addresses = make(map[string]string)
+	}
+	c.location.addresses[key] = resp.DisplayName
+	return resp.DisplayName, nil
+}
+
+// tileCoordinates returns the map tile that contains the given coordinates and the pixel position of the
+// coordinates within the tile.
+func tileCoordinates(lat, lon float64, zoom int) (x, y, px, py int) {
+	n := math.Exp2(float64(zoom))
+	latRad := lat * math.Pi / 180
+	fx := (lon + 180) / 360 * n
+	fy := (1 - math.Log(math.Tan(latRad)+1/math.Cos(latRad))/math.Pi) / 2 * n
+	fx = math.Max(0, math.Min(fx, n-1e-9))
+	fy = math.Max(0, math.Min(fy, n-1e-9))
+	x, y = int(fx), int(fy)
+	px = int((fx - float64(x)) * tileSize)
+	py = int((fy - float64(y)) * tileSize)
+	return
+}
+
+// LocationMinimap downloads the map tile of the given coordinates and marks the location on it.
+// The returned data is a PNG image.
+func (c *Container) LocationMinimap(lat, lon float64) ([]byte, error) {
+	zoom := c.config.Location.GetZoom()
+	key := locationKey(lat, lon, zoom)
+	c.location.lock.Lock()
+	defer c.location.lock.Unlock()
+	if minimap, ok := c.location.minimaps[key]; ok {
+		return minimap, nil
+	}
+	x, y, px, py := tileCoordinates(lat, lon, zoom)
+	data, err := c.getLocationURL(c.config.Location.GetTileURL(x, y, zoom))
+	if err != nil {
+		return nil, err
+	}
+	tile, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewRGBA(tile.Bounds())
+	draw.Draw(img, img.Bounds(), tile, tile.Bounds().Min, draw.Src)
+	// The minimap is scaled down a lot when rendered, so the marker has to be fairly large.
+	marker := image.Rect(px-12, py-12, px+12, py+12).Add(img.Bounds().Min)
+	draw.Draw(img, marker, &image.Uniform{C: color.RGBA{R: 0xff, A: 0xff}}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	if c.location.minimaps == nil {
+		c.location.minimaps = make(map[string][]byte)
+	}
+	c.location.minimaps[key] = buf.Bytes()
+	return buf.Bytes(), nil
+}
+
+// PrepareLocationMessage creates a static m.location message for the given geo URI.
+func (c *Container) PrepareLocationMessage(roomID id.RoomID, geoURI, description string, rel *ifc.Relation) *muksevt.Event {
+	body := fmt.Sprintf("Location: %s", geoURI)
+	if len(description) > 0 {
+		body = fmt.Sprintf("%s (%s)", description, geoURI)
+	}
+	return c.prepareEvent(roomID, &event.MessageEventContent{
+		MsgType: event.MsgLocation,
+		Body:    body,
+		GeoURI:  geoURI,
+	}, rel)
+}
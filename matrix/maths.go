@@ -0,0 +1,76 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+func (c *Container) mathsCacheDir() string {
+	return filepath.Join(c.config.CacheDir, "maths")
+}
+
+// RenderMaths renders the given LaTeX formula into a PNG image with the external command configured in the
+// maths section of the config. It returns nil without an error if there's no command configured.
+// Rendered images are cached on disk, so each formula only needs to be rendered once.
+func (c *Container) RenderMaths(formula string) ([]byte, error) {
+	command := c.config.Maths.GetRenderCommand()
+	if len(command) == 0 {
+		return nil, nil
+	}
+	hash := sha256.Sum256([]byte(formula))
+	cachePath := filepath.Join(c.mathsCacheDir(), hex.EncodeToString(hash[:])+".png")
+	if data, err := ioutil.ReadFile(cachePath); err == nil {
+		return data, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Maths.GetTimeout())
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = strings.NewReader(formula)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errOutput := strings.TrimSpace(stderr.String()); len(errOutput) > 0 {
+			return nil, fmt.Errorf("%w: %s", err, errOutput)
+		}
+		return nil, err
+	} else if stdout.Len() == 0 {
+		return nil, errors.New("maths render command returned nothing")
+	}
+
+	data := stdout.Bytes()
+	if err := os.MkdirAll(c.mathsCacheDir(), 0700); err != nil {
+		debug.Print("Failed to create maths cache directory:", err)
+	} else if err = ioutil.WriteFile(cachePath, data, 0600); err != nil {
+		debug.Print("Failed to cache rendered formula:", err)
+	}
+	return data, nil
+}
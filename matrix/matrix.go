@@ -18,7 +18,6 @@ package matrix
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
@@ -26,11 +25,11 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
-	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	dbg "runtime/debug"
+	"sync"
 	"time"
 	"errors"
 
@@ -44,6 +43,8 @@ import (
 	"maunium.net/go/gomuks/config"
 	"maunium.net/go/gomuks/debug"
 	ifc "maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/lib/appendlog"
+	"maunium.net/go/gomuks/lib/blurhash"
 	"maunium.net/go/gomuks/lib/open"
 	"maunium.net/go/gomuks/matrix/muksevt"
 	"maunium.net/go/gomuks/matrix/rooms"
@@ -64,6 +65,50 @@ type Container struct {
 	stop    chan bool
 
 	typing int64
+
+	utd     map[id.SessionID][]*muksevt.Event
+	utdLock sync.Mutex
+
+	wedged    map[string]*wedgeState
+	wedgeLock sync.Mutex
+
+	shareLock   sync.Mutex
+	sharePolicy map[id.RoomID]bool
+
+	sentLog *appendlog.Log
+
+	reactions     map[id.EventID]reactionRef
+	reactionsLock sync.Mutex
+
+	presence     map[id.UserID]presenceInfo
+	presenceLock sync.Mutex
+
+	initialSyncPending []id.RoomID
+	initialSyncLock    sync.Mutex
+
+	sendQueue     map[id.RoomID][]*queuedEvent
+	sendQueueLock sync.Mutex
+	sendQueueWake chan struct{}
+	// Whether the send queue has been loaded from disk. Until then, it isn't saved
+	// so that the stored events don't get overwritten.
+	sendQueueLoaded bool
+	scheduled       []*scheduledEvent
+	// Whether the scheduled events have been loaded from disk. Until then, they aren't saved
+	// so that the stored events don't get overwritten.
+	scheduledLoaded bool
+
+	// Incoming verification requests, handled outside the sync goroutine as the user is asked about them.
+	verificationQueue chan func()
+
+	federation  federationTracker
+	nowPlaying  nowPlayingState
+	location    locationCache
+	urlPreviews urlPreviewCache
+	mediaCache  mediaCacheTracker
+	emotes      emoteState
+	downloads   downloadManager
+	connection  connectionTracker
+	syncRequest syncRequestTracker
 }
 
 // NewContainer creates a new Container for the given Gomuks instance.
@@ -94,6 +139,10 @@ func (c *Container) Crypto() ifc.Crypto {
 
 // InitClient initializes the mautrix client and connects to the homeserver specified in the config.
 func (c *Container) InitClient() error {
+	if c.sentLog == nil {
+		c.sentLog = appendlog.New(filepath.Join(c.config.CacheDir, "sent.log"), c.config.CacheCipher, SentLogMaxAge, SentLogMaxEntries)
+	}
+
 	if len(c.config.HS) == 0 {
 		return fmt.Errorf("no homeserver entered")
 	}
@@ -126,20 +175,17 @@ func (c *Container) InitClient() error {
 	}
 
 	if c.history == nil {
-		c.history, err = NewHistoryManager(c.config.HistoryPath)
+		c.history, err = NewHistoryManager(c.config.HistoryPath, c.config.CacheCipher)
 		if err != nil {
 			return fmt.Errorf("failed to initialize history: %w", err)
 		}
 	}
 
-	allowInsecure := len(os.Getenv("GOMUKS_ALLOW_INSECURE_CONNECTIONS")) > 0
-	if allowInsecure {
-		c.client.Client = &http.Client{
-			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
-		}
-	}
+	c.client.Client = c.newHTTPClient()
 
 	c.stop = make(chan bool, 1)
+	c.sendQueueWake = make(chan struct{}, 1)
+	c.nowPlaying.wake = make(chan struct{}, 1)
 
 	if len(accessToken) > 0 {
 		go c.Start()
@@ -280,6 +326,9 @@ func (c *Container) Stop() {
 		default:
 		}
 		c.client.StopSync()
+		c.wakeSendQueue()
+		c.wakeNowPlaying()
+		c.clearNowPlaying()
 		debug.Print("Closing history manager...")
 		err := c.history.Close()
 		if err != nil {
@@ -338,13 +387,17 @@ func (s StubSyncingModal) Close()               {}
 // OnLogin initializes the syncer and updates the room list.
 func (c *Container) OnLogin() {
 	c.ui.OnLogin()
-
-	c.client.Store = c.config
+	c.offerRehydration()
 
 	debug.Print("Initializing syncer")
+	if c.syncer != nil {
+		c.syncer.Close()
+	}
 	c.syncer = NewGomuksSyncer(c.config.Rooms)
+	c.client.Store = &dispatchedStore{Storer: c.config, syncer: c.syncer}
+	c.syncer.OnSync(c.trackServerActivity)
 	if c.crypto != nil {
-		c.syncer.OnSync(c.crypto.ProcessSyncResponse)
+		c.syncer.OnSync(c.processSyncResponse)
 		c.syncer.OnEventType(event.StateMember, func(source mautrix.EventSource, evt *event.Event) {
 			// Don't spam the crypto module with member events of an initial sync
 			// TODO invalidate all group sessions when clearing cache?
@@ -359,18 +412,26 @@ func (c *Container) OnLogin() {
 	c.syncer.OnEventType(event.EventMessage, c.HandleMessage)
 	c.syncer.OnEventType(event.EventSticker, c.HandleMessage)
 	c.syncer.OnEventType(event.EventReaction, c.HandleMessage)
+	c.syncer.OnEventType(muksevt.EventPollStart, c.HandleMessage)
+	c.syncer.OnEventType(muksevt.EventPollResponse, c.HandleMessage)
+	c.syncer.OnEventType(muksevt.EventPollEnd, c.HandleMessage)
 	c.syncer.OnEventType(event.EventRedaction, c.HandleRedaction)
 	c.syncer.OnEventType(event.StateAliases, c.HandleMessage)
 	c.syncer.OnEventType(event.StateCanonicalAlias, c.HandleMessage)
 	c.syncer.OnEventType(event.StateTopic, c.HandleMessage)
 	c.syncer.OnEventType(event.StateRoomName, c.HandleMessage)
+	c.syncer.OnEventType(muksevt.StatePinnedEvents, c.HandlePinnedEvents)
 	c.syncer.OnEventType(event.StateMember, c.HandleMembership)
 	c.syncer.OnEventType(event.EphemeralEventReceipt, c.HandleReadReceipt)
 	c.syncer.OnEventType(event.EphemeralEventTyping, c.HandleTyping)
+	c.syncer.OnEventType(event.EphemeralEventPresence, c.HandlePresence)
 	c.syncer.OnEventType(event.AccountDataDirectChats, c.HandleDirectChatInfo)
 	c.syncer.OnEventType(event.AccountDataPushRules, c.HandlePushRules)
 	c.syncer.OnEventType(event.AccountDataRoomTags, c.HandleTag)
+	c.syncer.OnEventType(muksevt.AccountDataFullyRead, c.HandleFullyRead)
 	c.syncer.OnEventType(AccountDataGomuksPreferences, c.HandlePreferences)
+	c.syncer.OnEventType(muksevt.AccountDataUserEmotes, c.HandleEmotes)
+	c.syncer.OnEventType(muksevt.AccountDataEmoteRooms, c.HandleEmotes)
 	if len(c.config.AuthCache.NextBatch) == 0 {
 		c.syncer.Progress = c.ui.MainView().OpenSyncingModal()
 		c.syncer.Progress.SetMessage("Waiting for /sync response from server")
@@ -381,6 +442,10 @@ func (c *Container) OnLogin() {
 			c.syncer.FirstDoneCallback = nil
 		}
 	}
+	c.syncer.BlockEvent = c.isEventBlocked
+	c.syncer.InitialSyncStarted = c.saveInitialSync
+	c.syncer.InitialSyncRoomDone = c.initialSyncRoomDone
+	c.syncer.InitialSyncGlobalDone = c.initialSyncGlobalDone
 	c.syncer.InitDoneCallback = func() {
 		debug.Print("Initial sync done")
 		c.config.AuthCache.InitialSyncDone = true
@@ -392,6 +457,7 @@ func (c *Container) OnLogin() {
 		c.config.Rooms.ForceClean()
 		debug.Print("Saving all data")
 		c.config.SaveAll()
+		c.finishInitialSync()
 		debug.Print("Adding rooms to UI")
 		c.ui.MainView().SetRooms(c.config.Rooms)
 		c.ui.Render()
@@ -419,8 +485,17 @@ func (c *Container) Start() {
 		return
 	}
 
+	if len(c.config.AuthCache.NextBatch) == 0 {
+		c.resumeInitialSync()
+	}
+
 	debug.Print("Starting sync...")
 	c.running = true
+	go c.runSendQueue()
+	go c.runNowPlaying()
+	go c.runRetention()
+	go c.runSyncWatchdog()
+	go c.emoteAccountData()
 	for {
 		select {
 		case <-c.stop:
@@ -475,6 +550,10 @@ func (c *Container) SendPreferencesToMatrix() {
 
 func (c *Container) HandleRedaction(source mautrix.EventSource, evt *event.Event) {
 	room := c.GetOrCreateRoom(evt.RoomID)
+	if ref, ok := c.popReaction(evt.Redacts); ok {
+		c.removeReaction(room, evt.Redacts, ref)
+		return
+	}
 	var redactedEvt *muksevt.Event
 	err := c.history.Update(room, evt.Redacts, func(redacted *muksevt.Event) error {
 		redacted.Unsigned.RedactedBecause = evt
@@ -543,13 +622,21 @@ func (c *Container) HandleReaction(room *rooms.Room, reactsTo id.EventID, reactE
 		}
 		val, _ := evt.Unsigned.Relations.Annotations.Map[rel.Key]
 		evt.Unsigned.Relations.Annotations.Map[rel.Key] = val + 1
+		if reactEvent.Sender == c.config.UserID {
+			if evt.Gomuks.OwnReactions == nil {
+				evt.Gomuks.OwnReactions = make(map[string]id.EventID)
+			}
+			evt.Gomuks.OwnReactions[rel.Key] = reactEvent.ID
+		}
 		origEvt = evt
 		return nil
 	})
 	if err != nil {
 		debug.Print("Failed to store reaction in history db:", err)
 		return
-	} else if !c.config.AuthCache.InitialSyncDone || !room.Loaded() {
+	}
+	c.trackReaction(reactEvent.ID, reactsTo, rel.Key)
+	if !c.config.AuthCache.InitialSyncDone || !room.Loaded() {
 		return
 	}
 
@@ -576,29 +663,46 @@ func (c *Container) HandleEncrypted(source mautrix.EventSource, mxEvent *event.E
 	evt, err := c.crypto.DecryptMegolmEvent(mxEvent)
 	if err != nil {
 		debug.Printf("Failed to decrypt event %s: %v", mxEvent.ID, err)
+		c.trackDecryptionFailure(mxEvent, err)
 		mxEvent.Type = muksevt.EventBadEncrypted
 		origContent, _ := mxEvent.Content.Parsed.(*event.EncryptedEventContent)
 		mxEvent.Content.Parsed = &muksevt.BadEncryptedContent{
 			Original: origContent,
-			Reason:   err.Error(),
+			Reason:   c.decryptionFailureReason(mxEvent, origContent, err),
 		}
-		c.HandleMessage(source, mxEvent)
+		wrapped := muksevt.Wrap(mxEvent)
+		c.trackUndecryptable(wrapped, err)
+		c.handleMessage(source, wrapped)
 		return
 	}
-	if evt.Type.IsInRoomVerification() {
-		err := c.crypto.ProcessInRoomVerification(evt)
-		if err != nil {
-			debug.Printf("[Crypto/Error] Failed to process in-room verification event %s of type %s: %v", evt.ID, evt.Type.String(), err)
+	if c.isEventBlocked(evt.RoomID, evt.Type) {
+		return
+	} else if evt.Type.IsInRoomVerification() {
+		process := func() {
+			err := c.crypto.ProcessInRoomVerification(evt)
+			if err != nil {
+				debug.Printf("[Crypto/Error] Failed to process in-room verification event %s of type %s: %v", evt.ID, evt.Type.String(), err)
+			} else {
+				debug.Printf("[Crypto/Debug] Processed in-room verification event %s of type %s", evt.ID, evt.Type.String())
+			}
+		}
+		if evt.Type == event.InRoomVerificationStart {
+			c.queueVerification(process)
 		} else {
-			debug.Printf("[Crypto/Debug] Processed in-room verification event %s of type %s", evt.ID, evt.Type.String())
+			process()
 		}
 	} else {
-		c.HandleMessage(source, evt)
+		c.handleMessage(source, muksevt.WrapDecrypted(evt, mxEvent))
 	}
 }
 
 // HandleMessage is the event handler for the m.room.message timeline event.
 func (c *Container) HandleMessage(source mautrix.EventSource, mxEvent *event.Event) {
+	c.handleMessage(source, muksevt.Wrap(mxEvent))
+}
+
+func (c *Container) handleMessage(source mautrix.EventSource, wrappedEvent *muksevt.Event) {
+	mxEvent := wrappedEvent.Event
 	room := c.GetOrCreateRoom(mxEvent.RoomID)
 	if source&mautrix.EventSourceLeave != 0 {
 		room.HasLeft = true
@@ -610,16 +714,19 @@ func (c *Container) HandleMessage(source mautrix.EventSource, mxEvent *event.Eve
 	relatable, ok := mxEvent.Content.Parsed.(event.Relatable)
 	if ok {
 		rel := relatable.GetRelatesTo()
-		if editID := rel.GetReplaceID(); len(editID) > 0 {
-			c.HandleEdit(room, editID, muksevt.Wrap(mxEvent))
+		if pollID := rel.GetReferenceID(); len(pollID) > 0 && (mxEvent.Type == muksevt.EventPollResponse || mxEvent.Type == muksevt.EventPollEnd) {
+			c.HandlePollUpdate(room, pollID, wrappedEvent)
+			return
+		} else if editID := rel.GetReplaceID(); len(editID) > 0 {
+			c.HandleEdit(room, editID, wrappedEvent)
 			return
 		} else if reactionID := rel.GetAnnotationID(); mxEvent.Type == event.EventReaction && len(reactionID) > 0 {
-			c.HandleReaction(room, reactionID, muksevt.Wrap(mxEvent))
+			c.HandleReaction(room, reactionID, wrappedEvent)
 			return
 		}
 	}
 
-	events, err := c.history.Append(room, []*event.Event{mxEvent})
+	events, err := c.history.Append(room, []*muksevt.Event{wrappedEvent})
 	if err != nil {
 		debug.Printf("Failed to add event %s to history: %v", mxEvent.ID, err)
 	}
@@ -630,6 +737,11 @@ func (c *Container) HandleMessage(source mautrix.EventSource, mxEvent *event.Eve
 		return
 	}
 
+	if len(c.config.Webhooks) > 0 && c.syncer.FirstSyncDone && evt.Sender != c.config.UserID {
+		highlight := c.PushRules().GetActions(room, evt.Event).Should().Highlight
+		go c.forwardToWebhooks(room, evt, highlight)
+	}
+
 	mainView := c.ui.MainView()
 
 	roomView := mainView.GetRoom(evt.RoomID)
@@ -748,6 +860,7 @@ func (c *Container) HandleReadReceipt(source mautrix.EventSource, evt *event.Eve
 	if room != nil {
 		room.MarkRead(lastReadEvent)
 		if c.config.AuthCache.InitialSyncDone {
+			c.ui.MainView().Bump(room)
 			c.ui.Render()
 		}
 	}
@@ -831,15 +944,24 @@ func (c *Container) HandleTyping(_ mautrix.EventSource, evt *event.Event) {
 func (c *Container) MarkRead(roomID id.RoomID, eventID id.EventID) {
 	go func() {
 		defer debug.Recover()
-		err := c.client.MarkRead(roomID, eventID)
+		var err error
+		if room := c.GetRoom(roomID); room != nil && room.PrivateReceipts {
+			err = c.markReadPrivately(roomID, eventID)
+		} else {
+			err = c.client.MarkRead(roomID, eventID)
+		}
 		if err != nil {
 			debug.Print("Failed to mark %s in %s as read: %v", eventID, roomID, err)
 		}
+		err = c.setFullyRead(roomID, eventID)
+		if err != nil {
+			debug.Printf("Failed to move fully read marker in %s to %s: %v", roomID, eventID, err)
+		}
 	}()
 }
 
-func (c *Container) PrepareMediaMessage(room *rooms.Room, path string, rel *ifc.Relation) (*muksevt.Event, error) {
-	resp, err := c.UploadMedia(path, room.Encrypted)
+func (c *Container) PrepareMediaMessage(ctx context.Context, room *rooms.Room, path string, rel *ifc.Relation, progress ifc.UploadProgressFunc) (*muksevt.Event, error) {
+	resp, err := c.UploadMedia(ctx, path, room.Encrypted, progress)
 	if err != nil {
 		return nil, err
 	}
@@ -857,7 +979,13 @@ func (c *Container) PrepareMediaMessage(room *rooms.Room, path string, rel *ifc.
 		content.URL = resp.ContentURI.CUString()
 	}
 
-	return c.prepareEvent(room.ID, &content, rel), nil
+	evt := c.prepareEvent(room.ID, &content, rel)
+	if len(resp.BlurHash) > 0 {
+		evt.SetRawContent("info", map[string]interface{}{
+			blurhash.InfoKey: resp.BlurHash,
+		})
+	}
+	return evt, nil
 }
 
 func (c *Container) PrepareMarkdownMessage(roomID id.RoomID, msgtype event.MessageType, text, html string, rel *ifc.Relation) *muksevt.Event {
@@ -873,6 +1001,7 @@ func (c *Container) PrepareMarkdownMessage(roomID id.RoomID, msgtype event.Messa
 		content = format.RenderMarkdown(text, !c.config.Preferences.DisableMarkdown, !c.config.Preferences.DisableHTML)
 		content.MsgType = msgtype
 	}
+	c.insertEmotes(roomID, &content)
 
 	return c.prepareEvent(roomID, &content, rel)
 }
@@ -891,6 +1020,11 @@ func (c *Container) prepareEvent(roomID id.RoomID, content *event.MessageEventCo
 		}
 	} else if rel != nil && rel.Type == event.RelReply {
 		content.SetReply(rel.Event.Event)
+	} else if rel != nil && rel.Type == muksevt.RelThread {
+		content.RelatesTo = &event.RelatesTo{
+			Type:    muksevt.RelThread,
+			EventID: rel.Event.ID,
+		}
 	}
 
 	txnID := c.client.TxnID()
@@ -904,6 +1038,16 @@ func (c *Container) prepareEvent(roomID id.RoomID, content *event.MessageEventCo
 		Unsigned:  event.Unsigned{TransactionID: txnID},
 	})
 	localEcho.Gomuks.OutgoingState = muksevt.StateLocalEcho
+	if rel != nil && rel.Type == muksevt.RelThread && rel.InReplyTo != nil {
+		// mautrix only serializes m.in_reply_to for plain replies, so the reply fallback of thread
+		// messages is added to the raw content, which is merged with the m.relates_to of the parsed content.
+		localEcho.SetRawContent("m.relates_to", map[string]interface{}{
+			"is_falling_back": rel.FallbackReply,
+			"m.in_reply_to": map[string]interface{}{
+				"event_id": rel.InReplyTo.ID,
+			},
+		})
+	}
 	if rel != nil && rel.Type == event.RelReplace {
 		localEcho.ID = rel.Event.ID
 		localEcho.Gomuks.Edits = []*muksevt.Event{localEcho}
@@ -917,21 +1061,60 @@ func (c *Container) Redact(roomID id.RoomID, eventID id.EventID, reason string)
 	return err
 }
 
-// SendMessage sends the given event.
+// SendEvent sends the given event and records it in the sent event log.
+//
+// If sending fails because of a network or server problem, or if there are older events in the same
+// room that haven't been sent yet, the event is queued for retrying and ifc.ErrEventQueued is returned.
 func (c *Container) SendEvent(evt *muksevt.Event) (id.EventID, error) {
+	entry := ifc.SentEvent{
+		TransactionID: evt.Unsigned.TransactionID,
+		RoomID:        evt.RoomID,
+		Type:          evt.Type,
+		Status:        "pending",
+	}
+	// sendEvent replaces the content when encrypting, so keep a copy of the plaintext event for the queue.
+	plaintext := *evt.Event
+	canQueue := len(entry.TransactionID) > 0
+	if canQueue && c.queueIfBusy(&plaintext) {
+		entry.Status = "queued"
+		c.logSent(entry)
+		return "", ifc.ErrEventQueued
+	}
+	c.logSent(entry)
+	eventID, err := c.sendEvent(evt)
+	entry.EventID = eventID
+	c.noteSendResult(evt.RoomID, err)
+	if err != nil && canQueue && isRetryableSendError(err) {
+		debug.Printf("Failed to send %s, queuing for retry: %v", entry.TransactionID, err)
+		c.enqueue(&plaintext, err)
+		entry.Status = "queued"
+		entry.Error = err.Error()
+		err = ifc.ErrEventQueued
+	} else if err != nil {
+		entry.Status = "failed"
+		entry.Error = err.Error()
+	} else {
+		entry.Status = "sent"
+	}
+	c.logSent(entry)
+	return eventID, err
+}
+
+func (c *Container) sendEvent(evt *muksevt.Event) (id.EventID, error) {
 	defer debug.Recover()
 
 	_, _ = c.client.UserTyping(evt.RoomID, false, 0)
 	c.typing = 0
 	room := c.GetRoom(evt.RoomID)
 	if room != nil && room.Encrypted && c.crypto != nil && evt.Type != event.EventReaction {
+		c.invalidateStaleGroupSession(room)
 		encrypted, err := c.crypto.EncryptMegolmEvent(evt.RoomID, evt.Type, &evt.Content)
 		if err != nil {
 			if isBadEncryptError(err) {
 				return "", err
 			}
 			debug.Print("Got", err, "while trying to encrypt message, sharing group session and trying again...")
-			err = c.crypto.ShareGroupSession(room.ID, room.GetMemberList())
+			err = c.shareGroupSession(room)
 			if err != nil {
 				return "", err
 			}
@@ -950,7 +1133,9 @@ func (c *Container) SendEvent(evt *muksevt.Event) (id.EventID, error) {
 	return resp.EventID, nil
 }
 
-func (c *Container) UploadMedia(path string, encrypt bool) (*ifc.UploadedMediaInfo, error) {
+// UploadMedia uploads the file at the given path, optionally encrypting it. The progress function is called
+// periodically during the upload, and the upload is aborted with a context.Canceled error if ctx is cancelled.
+func (c *Container) UploadMedia(ctx context.Context, path string, encrypt bool, progress ifc.UploadProgressFunc) (*ifc.UploadedMediaInfo, error) {
 	var err error
 	path, err = filepath.Abs(path)
 	if err != nil {
@@ -966,11 +1151,21 @@ func (c *Container) UploadMedia(path string, encrypt bool) (*ifc.UploadedMediaIn
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
+	defer file.Close()
 
 	stat, err := file.Stat()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
+	reader := &progressReader{ctx: ctx, reader: file, total: stat.Size(), progress: progress}
+
+	var blurHash string
+	if msgtype == event.MsgImage {
+		blurHash, err = getBlurHash(path)
+		if err != nil {
+			debug.Printf("Failed to calculate blurhash of %s: %v", path, err)
+		}
+	}
 
 	uploadFileName := stat.Name()
 	uploadMimeType := info.MimeType
@@ -981,9 +1176,9 @@ func (c *Container) UploadMedia(path string, encrypt bool) (*ifc.UploadedMediaIn
 		uploadMimeType = "application/octet-stream"
 		uploadFileName = ""
 		encryptionInfo = attachment.NewEncryptedFile()
-		content = encryptionInfo.EncryptStream(file)
+		content = encryptionInfo.EncryptStream(reader)
 	} else {
-		content = file
+		content = reader
 	}
 
 	resp, err := c.client.UploadMedia(mautrix.ReqUploadMedia{
@@ -1003,6 +1198,7 @@ func (c *Container) UploadMedia(path string, encrypt bool) (*ifc.UploadedMediaIn
 		Name:            stat.Name(),
 		MsgType:         msgtype,
 		Info:            &info,
+		BlurHash:        blurHash,
 	}, nil
 }
 
@@ -1062,6 +1258,39 @@ func (c *Container) LeaveRoom(roomID id.RoomID) error {
 	return nil
 }
 
+// EnableEncryption sends a m.room.encryption event to the given room and immediately shares a new
+// outbound group session with the members, so that the first encrypted message can be sent right away.
+func (c *Container) EnableEncryption(room *rooms.Room) error {
+	content := event.EncryptionEventContent{Algorithm: id.AlgorithmMegolmV1}
+	resp, err := c.client.SendStateEvent(room.ID, event.StateEncryption, "", &content)
+	if err != nil {
+		return err
+	}
+	// Apply the event locally instead of waiting for it to come down the sync,
+	// so that messages sent before the next sync are already encrypted.
+	stateKey := ""
+	room.UpdateState(&event.Event{
+		ID:        resp.EventID,
+		RoomID:    room.ID,
+		Sender:    c.config.UserID,
+		Type:      event.StateEncryption,
+		StateKey:  &stateKey,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Content:   event.Content{Parsed: &content},
+	})
+	if c.crypto == nil {
+		return nil
+	} else if !room.MembersFetched {
+		if err = c.FetchMembers(room); err != nil {
+			return fmt.Errorf("failed to fetch members for sharing keys: %w", err)
+		}
+	}
+	if err = c.shareGroupSession(room); err != nil {
+		return fmt.Errorf("failed to share keys: %w", err)
+	}
+	return nil
+}
+
 func (c *Container) FetchMembers(room *rooms.Room) error {
 	debug.Print("Fetching member list for", room.ID)
 	members, err := c.client.Members(room.ID, mautrix.ReqMembers{At: room.LastPrevBatch})
@@ -1089,38 +1318,26 @@ func (c *Container) GetHistory(room *rooms.Room, limit int, dbPointer uint64) ([
 	}
 	if len(events) > 0 {
 		debug.Printf("Loaded %d events for %s from local cache", len(events), room.ID)
+		c.retryCachedUndecryptable(events)
 		return events, newDBPointer, nil
 	}
+	if room.HasLeft {
+		// Rooms that have been left are only browsable from the local cache.
+		return nil, dbPointer, nil
+	}
 	resp, err := c.client.Messages(room.ID, room.PrevBatch, "", 'b', limit)
 	if err != nil {
 		return nil, dbPointer, err
 	}
 	debug.Printf("Loaded %d events for %s from server from %s to %s", len(resp.Chunk), room.ID, resp.Start, resp.End)
-	for i, evt := range resp.Chunk {
-		err := evt.Content.ParseRaw(evt.Type)
-		if err != nil {
-			debug.Printf("Failed to unmarshal content of event %s (type %s) by %s in %s: %v\n%s", evt.ID, evt.Type.Repr(), evt.Sender, evt.RoomID, err, string(evt.Content.VeryRaw))
+	chunk := make([]*muksevt.Event, 0, len(resp.Chunk))
+	for _, evt := range resp.Chunk {
+		if c.isEventBlocked(room.ID, evt.Type) {
+			continue
 		}
-
-		if evt.Type == event.EventEncrypted {
-			if c.crypto == nil {
-				evt.Type = muksevt.EventEncryptionUnsupported
-				origContent, _ := evt.Content.Parsed.(*event.EncryptedEventContent)
-				evt.Content.Parsed = muksevt.EncryptionUnsupportedContent{Original: origContent}
-			} else {
-				decrypted, err := c.crypto.DecryptMegolmEvent(evt)
-				if err != nil {
-					debug.Printf("Failed to decrypt event %s: %v", evt.ID, err)
-					evt.Type = muksevt.EventBadEncrypted
-					origContent, _ := evt.Content.Parsed.(*event.EncryptedEventContent)
-					evt.Content.Parsed = &muksevt.BadEncryptedContent{
-						Original: origContent,
-						Reason:   err.Error(),
-					}
-				} else {
-					resp.Chunk[i] = decrypted
-				}
-			}
+		// Check the type again in case the event was encrypted.
+		if parsed := c.parseFetchedEvent(evt); !c.isEventBlocked(room.ID, parsed.Type) {
+			chunk = append(chunk, parsed)
 		}
 	}
 	for _, evt := range resp.State {
@@ -1128,17 +1345,56 @@ func (c *Container) GetHistory(room *rooms.Room, limit int, dbPointer uint64) ([
 	}
 	room.PrevBatch = resp.End
 	c.config.Rooms.Put(room)
-	if len(resp.Chunk) == 0 {
+	if len(chunk) == 0 {
 		return []*muksevt.Event{}, dbPointer, nil
 	}
 	// TODO newDBPointer isn't accurate in this case yet, fix later
-	events, newDBPointer, err = c.history.Prepend(room, resp.Chunk)
+	events, newDBPointer, err = c.history.Prepend(room, chunk)
 	if err != nil {
 		return nil, dbPointer, err
 	}
 	return events, dbPointer, nil
 }
 
+// isEventBlocked checks if events of the given type are in the configured event blocklist of the room.
+func (c *Container) isEventBlocked(roomID id.RoomID, evtType event.Type) bool {
+	return c.config.EventBlocklist.Blocks(roomID, evtType.Type)
+}
+
+// parseFetchedEvent parses the content of an event that was fetched from the server outside of a sync,
+// and decrypts it if it's encrypted.
+func (c *Container) parseFetchedEvent(evt *event.Event) *muksevt.Event {
+	wrapped := muksevt.Wrap(evt)
+	err := evt.Content.ParseRaw(evt.Type)
+	if err != nil {
+		debug.Printf("Failed to unmarshal content of event %s (type %s) by %s in %s: %v\n%s", evt.ID, evt.Type.Repr(), evt.Sender, evt.RoomID, err, string(evt.Content.VeryRaw))
+	}
+
+	if evt.Type == event.EventEncrypted {
+		if c.crypto == nil {
+			evt.Type = muksevt.EventEncryptionUnsupported
+			origContent, _ := evt.Content.Parsed.(*event.EncryptedEventContent)
+			evt.Content.Parsed = muksevt.EncryptionUnsupportedContent{Original: origContent}
+		} else {
+			decrypted, err := c.crypto.DecryptMegolmEvent(evt)
+			if err != nil {
+				debug.Printf("Failed to decrypt event %s: %v", evt.ID, err)
+				c.trackDecryptionFailure(evt, err)
+				evt.Type = muksevt.EventBadEncrypted
+				origContent, _ := evt.Content.Parsed.(*event.EncryptedEventContent)
+				evt.Content.Parsed = &muksevt.BadEncryptedContent{
+					Original: origContent,
+					Reason:   c.decryptionFailureReason(evt, origContent, err),
+				}
+				c.trackUndecryptable(wrapped, err)
+			} else {
+				wrapped = muksevt.WrapDecrypted(decrypted, evt)
+			}
+		}
+	}
+	return wrapped
+}
+
 func (c *Container) GetEvent(room *rooms.Room, eventID id.EventID) (*muksevt.Event, error) {
 	evt, err := c.history.Get(room, eventID)
 	if err != nil && err != EventNotFoundError {
@@ -1189,54 +1445,6 @@ func cp(src, dst string) error {
 	return out.Close()
 }
 
-func (c *Container) DownloadToDisk(uri id.ContentURI, file *attachment.EncryptedFile, target string) (fullPath string, err error) {
-	cachePath := c.GetCachePath(uri)
-	if target == "" {
-		fullPath = cachePath
-	} else if !path.IsAbs(target) {
-		fullPath = path.Join(c.config.DownloadDir, target)
-	} else {
-		fullPath = target
-	}
-
-	if _, statErr := os.Stat(cachePath); os.IsNotExist(statErr) {
-		var body io.ReadCloser
-		body, err = c.client.Download(uri)
-		if err != nil {
-			return
-		}
-
-		var data []byte
-		data, err = ioutil.ReadAll(body)
-		_ = body.Close()
-		if err != nil {
-			return
-		}
-
-		if file != nil {
-			data, err = file.Decrypt(data)
-			if err != nil {
-				return
-			}
-		}
-
-		err = ioutil.WriteFile(cachePath, data, 0600)
-		if err != nil {
-			return
-		}
-	}
-
-	if fullPath != cachePath {
-		err = os.MkdirAll(path.Dir(fullPath), 0700)
-		if err != nil {
-			return
-		}
-		err = cp(cachePath, fullPath)
-	}
-
-	return
-}
-
 // Download fetches the given Matrix content (mxc) URL and returns the data, homeserver, file ID and potential errors.
 //
 // The file will be either read from the media cache (if found) or downloaded from the server.
@@ -1246,29 +1454,53 @@ func (c *Container) Download(uri id.ContentURI, file *attachment.EncryptedFile)
 	if info, err = os.Stat(cacheFile); err == nil && !info.IsDir() {
 		data, err = ioutil.ReadFile(cacheFile)
 		if err == nil {
+			c.touchCachedMedia(cacheFile)
 			return
 		}
 	}
 
-	data, err = c.download(uri, file, cacheFile)
+	data, err = c.download(uri, file, cacheFile, 0)
 	return
 }
 
+// ErrMediaTooLarge is returned by DownloadLimited if the file is larger than the given limit.
+var ErrMediaTooLarge = errors.New("file is larger than the size limit")
+
+// DownloadLimited is like Download, but stops downloading and returns ErrMediaTooLarge if the file is larger than maxSize bytes.
+func (c *Container) DownloadLimited(uri id.ContentURI, file *attachment.EncryptedFile, maxSize int) ([]byte, error) {
+	cacheFile := c.GetCachePath(uri)
+	if info, err := os.Stat(cacheFile); err == nil && !info.IsDir() {
+		if info.Size() > int64(maxSize) {
+			return nil, ErrMediaTooLarge
+		} else if data, err := ioutil.ReadFile(cacheFile); err == nil {
+			c.touchCachedMedia(cacheFile)
+			return data, nil
+		}
+	}
+	return c.download(uri, file, cacheFile, maxSize)
+}
+
 func (c *Container) GetDownloadURL(uri id.ContentURI) string {
 	return c.client.GetDownloadURL(uri)
 }
 
-func (c *Container) download(uri id.ContentURI, file *attachment.EncryptedFile, cacheFile string) (data []byte, err error) {
+func (c *Container) download(uri id.ContentURI, file *attachment.EncryptedFile, cacheFile string, maxSize int) (data []byte, err error) {
 	var body io.ReadCloser
 	body, err = c.client.Download(uri)
 	if err != nil {
 		return
 	}
 
-	data, err = ioutil.ReadAll(body)
+	var reader io.Reader = body
+	if maxSize > 0 {
+		reader = io.LimitReader(body, int64(maxSize)+1)
+	}
+	data, err = ioutil.ReadAll(reader)
 	_ = body.Close()
 	if err != nil {
 		return
+	} else if maxSize > 0 && len(data) > maxSize {
+		return nil, ErrMediaTooLarge
 	}
 
 	if file != nil {
@@ -1279,6 +1511,9 @@ func (c *Container) download(uri id.ContentURI, file *attachment.EncryptedFile,
 	}
 
 	err = ioutil.WriteFile(cacheFile, data, 0600)
+	if err == nil {
+		c.cachedMediaWritten(int64(len(data)))
+	}
 	return
 }
 
@@ -0,0 +1,144 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	sync "github.com/sasha-s/go-deadlock"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/interface"
+)
+
+// mediaCacheTracker keeps a running total of the media cache size, so that the cache directory only
+// has to be scanned when files need to be evicted.
+type mediaCacheTracker struct {
+	lock     sync.Mutex
+	scanned  bool
+	size     int64
+	trimming bool
+}
+
+type cachedMediaFile struct {
+	path     string
+	size     int64
+	lastUsed time.Time
+}
+
+// scanMediaCache lists the files in the media cache. Partially downloaded files are skipped.
+func (c *Container) scanMediaCache() (files []cachedMediaFile, total int64, err error) {
+	err = filepath.Walk(c.config.MediaDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && !strings.HasSuffix(path, ".part") {
+			files = append(files, cachedMediaFile{path, info.Size(), info.ModTime()})
+			total += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// touchCachedMedia marks a cached file as used, so that it's evicted after files that haven't been used recently.
+func (c *Container) touchCachedMedia(path string) {
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
+
+// cachedMediaWritten records a new file in the media cache and starts evicting old files if the cache is too big.
+func (c *Container) cachedMediaWritten(size int64) {
+	maxSize := c.config.MediaCache.GetMaxSize()
+	c.mediaCache.lock.Lock()
+	defer c.mediaCache.lock.Unlock()
+	c.mediaCache.size += size
+	if maxSize > 0 && (!c.mediaCache.scanned || c.mediaCache.size > maxSize) && !c.mediaCache.trimming {
+		c.mediaCache.trimming = true
+		go c.trimMediaCache(maxSize)
+	}
+}
+
+// cachedMediaRemoved records files that were deleted from the media cache outside trimMediaCache.
+func (c *Container) cachedMediaRemoved(size int64) {
+	c.mediaCache.lock.Lock()
+	c.mediaCache.size -= size
+	if c.mediaCache.size < 0 {
+		c.mediaCache.size = 0
+	}
+	c.mediaCache.lock.Unlock()
+}
+
+// trimMediaCache deletes the least recently used files until the media cache is below 90% of the given size.
+func (c *Container) trimMediaCache(maxSize int64) {
+	defer debug.Recover()
+	files, total, err := c.scanMediaCache()
+	defer func() {
+		c.mediaCache.lock.Lock()
+		c.mediaCache.scanned = err == nil
+		c.mediaCache.size = total
+		c.mediaCache.trimming = false
+		c.mediaCache.lock.Unlock()
+	}()
+	if err != nil {
+		debug.Print("Failed to scan media cache:", err)
+		return
+	} else if total <= maxSize {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].lastUsed.Before(files[j].lastUsed)
+	})
+	target := maxSize / 10 * 9
+	evicted := 0
+	for _, file := range files {
+		if total <= target {
+			break
+		}
+		if removeErr := os.Remove(file.path); removeErr != nil {
+			debug.Printf("Failed to evict cached media %s: %v", file.path, removeErr)
+			continue
+		}
+		total -= file.size
+		evicted++
+	}
+	debug.Printf("Evicted %d files from the media cache, %d bytes left", evicted, total)
+}
+
+// MediaCacheStats returns the number of files in the media cache, their total size and the configured limit.
+func (c *Container) MediaCacheStats() (stats ifc.MediaCacheStats, err error) {
+	files, total, err := c.scanMediaCache()
+	if err != nil {
+		return
+	}
+	c.mediaCache.lock.Lock()
+	c.mediaCache.scanned = true
+	c.mediaCache.size = total
+	c.mediaCache.lock.Unlock()
+	stats = ifc.MediaCacheStats{
+		Files:   len(files),
+		Size:    total,
+		MaxSize: c.config.MediaCache.GetMaxSize(),
+	}
+	for _, file := range files {
+		if stats.LeastRecentlyUsed.IsZero() || file.lastUsed.Before(stats.LeastRecentlyUsed) {
+			stats.LeastRecentlyUsed = file.lastUsed
+		}
+	}
+	return
+}
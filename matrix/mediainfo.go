@@ -24,12 +24,14 @@ import (
 	"strings"
 	"time"
 
+	"github.com/disintegration/imaging"
 	"github.com/gabriel-vasile/mimetype"
 	"gopkg.in/vansante/go-ffprobe.v2"
 
 	"maunium.net/go/mautrix/event"
 
 	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/lib/blurhash"
 )
 
 func getImageInfo(path string) (event.FileInfo, error) {
@@ -47,6 +49,16 @@ func getImageInfo(path string) (event.FileInfo, error) {
 	return info, nil
 }
 
+// getBlurHash calculates the blurhash placeholder of the image at the given path.
+func getBlurHash(path string) (string, error) {
+	img, err := imaging.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open image to calculate blurhash: %w", err)
+	}
+	// Calculating the hash visits every pixel for every component, so do it on a thumbnail.
+	return blurhash.Encode(4, 3, imaging.Fit(img, 64, 64, imaging.Box))
+}
+
 func getFFProbeInfo(mimeClass, path string) (msgtype event.MessageType, info event.FileInfo, err error) {
 	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelFn()
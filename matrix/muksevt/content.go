@@ -21,11 +21,21 @@ import (
 	"reflect"
 
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
 var EventBadEncrypted = event.Type{Type: "net.maunium.gomuks.bad_encrypted", Class: event.MessageEventType}
 var EventEncryptionUnsupported = event.Type{Type: "net.maunium.gomuks.encryption_unsupported", Class: event.MessageEventType}
 
+// StatePinnedEvents is the type of the state event that lists the pinned messages of a room.
+var StatePinnedEvents = event.Type{Type: "m.room.pinned_events", Class: event.StateEventType}
+
+// AccountDataFullyRead is the type of the room account data event that stores the fully read marker.
+var AccountDataFullyRead = event.Type{Type: "m.fully_read", Class: event.AccountDataEventType}
+
+// RelThread is the relation type of messages sent in a thread. The relation points at the thread root.
+const RelThread event.RelationType = "m.thread"
+
 type BadEncryptedContent struct {
 	Original *event.EncryptedEventContent `json:"-"`
 
@@ -36,9 +46,26 @@ type EncryptionUnsupportedContent struct {
 	Original *event.EncryptedEventContent `json:"-"`
 }
 
+type FullyReadContent struct {
+	EventID id.EventID `json:"event_id"`
+}
+
+type PinnedEventsContent struct {
+	Pinned []id.EventID `json:"pinned"`
+}
+
 func init() {
 	gob.Register(&BadEncryptedContent{})
 	gob.Register(&EncryptionUnsupportedContent{})
+	gob.Register(&PinnedEventsContent{})
+	gob.Register(&PollStartContent{})
+	gob.Register(&PollResponseContent{})
+	gob.Register(&PollEndContent{})
 	event.TypeMap[EventBadEncrypted] = reflect.TypeOf(&BadEncryptedContent{})
 	event.TypeMap[EventEncryptionUnsupported] = reflect.TypeOf(&EncryptionUnsupportedContent{})
+	event.TypeMap[StatePinnedEvents] = reflect.TypeOf(PinnedEventsContent{})
+	event.TypeMap[AccountDataFullyRead] = reflect.TypeOf(FullyReadContent{})
+	event.TypeMap[EventPollStart] = reflect.TypeOf(PollStartContent{})
+	event.TypeMap[EventPollResponse] = reflect.TypeOf(PollResponseContent{})
+	event.TypeMap[EventPollEnd] = reflect.TypeOf(PollEndContent{})
 }
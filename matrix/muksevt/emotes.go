@@ -0,0 +1,71 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package muksevt
+
+import (
+	"encoding/gob"
+	"reflect"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Event types of MSC2545 image packs. Room packs are state events where the state key identifies the pack,
+// the user's personal pack is global account data, and EmoteRooms lists room packs that should be usable everywhere.
+var (
+	StateRoomEmotes       = event.Type{Type: "im.ponies.room_emotes", Class: event.StateEventType}
+	AccountDataUserEmotes = event.Type{Type: "im.ponies.user_emotes", Class: event.AccountDataEventType}
+	AccountDataEmoteRooms = event.Type{Type: "im.ponies.emote_rooms", Class: event.AccountDataEventType}
+)
+
+type EmoteUsage string
+
+const (
+	EmoteUsageEmoticon EmoteUsage = "emoticon"
+	EmoteUsageSticker  EmoteUsage = "sticker"
+)
+
+type EmotePackImage struct {
+	URL   id.ContentURIString `json:"url"`
+	Body  string              `json:"body,omitempty"`
+	Info  *event.FileInfo     `json:"info,omitempty"`
+	Usage []EmoteUsage        `json:"usage,omitempty"`
+}
+
+type EmotePackInfo struct {
+	DisplayName string              `json:"display_name,omitempty"`
+	AvatarURL   id.ContentURIString `json:"avatar_url,omitempty"`
+	Usage       []EmoteUsage        `json:"usage,omitempty"`
+	Attribution string              `json:"attribution,omitempty"`
+}
+
+type EmotePackContent struct {
+	Images map[string]*EmotePackImage `json:"images"`
+	Pack   EmotePackInfo              `json:"pack"`
+}
+
+// EmoteRoomsContent maps room IDs to the state keys of the room packs that are enabled globally.
+type EmoteRoomsContent struct {
+	Rooms map[id.RoomID]map[string]interface{} `json:"rooms"`
+}
+
+func init() {
+	gob.Register(&EmotePackContent{})
+	event.TypeMap[StateRoomEmotes] = reflect.TypeOf(EmotePackContent{})
+	event.TypeMap[AccountDataUserEmotes] = reflect.TypeOf(EmotePackContent{})
+	event.TypeMap[AccountDataEmoteRooms] = reflect.TypeOf(EmoteRoomsContent{})
+}
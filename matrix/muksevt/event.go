@@ -17,7 +17,10 @@
 package muksevt
 
 import (
+	"encoding/json"
+
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
 type Event struct {
@@ -35,10 +38,36 @@ func (evt *Event) SomewhatDangerousCopy() *Event {
 	}
 }
 
+// SetRawContent sets a content field that isn't part of the parsed content struct.
+// The field is merged with the parsed content when the event is sent.
+func (evt *Event) SetRawContent(key string, value interface{}) {
+	if evt.Content.Raw == nil {
+		evt.Content.Raw = make(map[string]interface{})
+	}
+	evt.Content.Raw[key] = value
+}
+
 func Wrap(event *event.Event) *Event {
 	return &Event{Event: event}
 }
 
+// WrapDecrypted wraps a decrypted event and stores info about the encrypted event it was decrypted from.
+func WrapDecrypted(decrypted, encrypted *event.Event) *Event {
+	evt := Wrap(decrypted)
+	if content, ok := encrypted.Content.Parsed.(*event.EncryptedEventContent); ok {
+		evt.Gomuks.Encryption = &EncryptionInfo{
+			Algorithm: content.Algorithm,
+			SenderKey: content.SenderKey,
+			DeviceID:  content.DeviceID,
+			SessionID: content.SessionID,
+		}
+		if data, err := json.Marshal(encrypted); err == nil {
+			evt.Gomuks.Encryption.Source = data
+		}
+	}
+	return evt
+}
+
 type OutgoingState int
 
 const (
@@ -47,7 +76,22 @@ const (
 	StateSendFail
 )
 
+// EncryptionInfo contains the metadata of the encrypted event that an event was decrypted from.
+type EncryptionInfo struct {
+	Algorithm id.Algorithm
+	SenderKey id.SenderKey
+	DeviceID  id.DeviceID
+	SessionID id.SessionID
+	// Source is the raw JSON of the encrypted event.
+	Source json.RawMessage
+}
+
 type GomuksContent struct {
 	OutgoingState OutgoingState
 	Edits         []*Event
+	Encryption    *EncryptionInfo
+	// OwnReactions maps reaction keys to the IDs of the user's own reaction events.
+	OwnReactions map[string]id.EventID
+	// Poll contains the aggregated responses if the event is a poll start event.
+	Poll *PollState
 }
@@ -0,0 +1,191 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package muksevt
+
+import (
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Event types of MSC3381 polls. Responses and ends refer to the start event with an m.reference relation.
+var (
+	EventPollStart    = event.Type{Type: "org.matrix.msc3381.poll.start", Class: event.MessageEventType}
+	EventPollResponse = event.Type{Type: "org.matrix.msc3381.poll.response", Class: event.MessageEventType}
+	EventPollEnd      = event.Type{Type: "org.matrix.msc3381.poll.end", Class: event.MessageEventType}
+)
+
+type PollKind string
+
+const (
+	PollKindDisclosed   PollKind = "org.matrix.msc3381.poll.disclosed"
+	PollKindUndisclosed PollKind = "org.matrix.msc3381.poll.undisclosed"
+)
+
+type PollAnswer struct {
+	ID   string `json:"id"`
+	Text string `json:"org.matrix.msc1767.text"`
+}
+
+type PollQuestion struct {
+	Text string `json:"org.matrix.msc1767.text"`
+}
+
+type PollStart struct {
+	Question      PollQuestion `json:"question"`
+	Kind          PollKind     `json:"kind"`
+	MaxSelections int          `json:"max_selections"`
+	Answers       []PollAnswer `json:"answers"`
+}
+
+type PollStartContent struct {
+	Poll PollStart `json:"org.matrix.msc3381.poll.start"`
+	Text string    `json:"org.matrix.msc1767.text,omitempty"`
+	Body string    `json:"body,omitempty"`
+}
+
+// GetMaxSelections returns the number of answers a single vote may choose, which is always at least one.
+func (content *PollStartContent) GetMaxSelections() int {
+	if content.Poll.MaxSelections < 1 {
+		return 1
+	}
+	return content.Poll.MaxSelections
+}
+
+// Disclosed returns true if votes should be visible before the poll has ended.
+func (content *PollStartContent) Disclosed() bool {
+	return content.Poll.Kind != PollKindUndisclosed
+}
+
+type PollResponse struct {
+	Answers []string `json:"answers"`
+}
+
+type PollResponseContent struct {
+	RelatesTo event.RelatesTo `json:"m.relates_to"`
+	Response  PollResponse    `json:"org.matrix.msc3381.poll.response"`
+}
+
+func (content *PollResponseContent) GetRelatesTo() *event.RelatesTo {
+	return &content.RelatesTo
+}
+
+func (content *PollResponseContent) OptionalGetRelatesTo() *event.RelatesTo {
+	if len(content.RelatesTo.EventID) == 0 {
+		return nil
+	}
+	return &content.RelatesTo
+}
+
+func (content *PollResponseContent) SetRelatesTo(rel *event.RelatesTo) {
+	content.RelatesTo = *rel
+}
+
+type PollEndContent struct {
+	RelatesTo event.RelatesTo `json:"m.relates_to"`
+	End       struct{}        `json:"org.matrix.msc3381.poll.end"`
+	Text      string          `json:"org.matrix.msc1767.text,omitempty"`
+	Body      string          `json:"body,omitempty"`
+}
+
+func (content *PollEndContent) GetRelatesTo() *event.RelatesTo {
+	return &content.RelatesTo
+}
+
+func (content *PollEndContent) OptionalGetRelatesTo() *event.RelatesTo {
+	if len(content.RelatesTo.EventID) == 0 {
+		return nil
+	}
+	return &content.RelatesTo
+}
+
+func (content *PollEndContent) SetRelatesTo(rel *event.RelatesTo) {
+	content.RelatesTo = *rel
+}
+
+// PollVote is the latest response of a single user to a poll.
+type PollVote struct {
+	EventID   id.EventID
+	Answers   []string
+	Timestamp int64
+}
+
+// PollState is the aggregated state of the responses to a poll start event.
+type PollState struct {
+	Votes   map[id.UserID]PollVote
+	Ended   bool
+	EndedAt int64
+}
+
+// AddVote stores the vote if it's newer than the previous vote of the same user and the poll hadn't ended yet.
+func (state *PollState) AddVote(sender id.UserID, vote PollVote) bool {
+	if state.Ended && vote.Timestamp > state.EndedAt {
+		return false
+	} else if prev, ok := state.Votes[sender]; ok && prev.Timestamp > vote.Timestamp {
+		return false
+	}
+	if state.Votes == nil {
+		state.Votes = make(map[id.UserID]PollVote)
+	}
+	state.Votes[sender] = vote
+	return true
+}
+
+// End marks the poll as ended and drops any votes that were cast after the end.
+func (state *PollState) End(timestamp int64) {
+	if state.Ended && state.EndedAt <= timestamp {
+		return
+	}
+	state.Ended = true
+	state.EndedAt = timestamp
+	for sender, vote := range state.Votes {
+		if vote.Timestamp > timestamp {
+			delete(state.Votes, sender)
+		}
+	}
+}
+
+// Tally counts the valid votes for each answer of the poll. Invalid answer IDs are ignored and
+// votes with too many answers are truncated to the maximum number of selections.
+func (state *PollState) Tally(poll *PollStartContent) (counts map[string]int, voters int) {
+	counts = make(map[string]int, len(poll.Poll.Answers))
+	for _, answer := range poll.Poll.Answers {
+		counts[answer.ID] = 0
+	}
+	if state == nil {
+		return
+	}
+	maxSelections := poll.GetMaxSelections()
+	for _, vote := range state.Votes {
+		selected := make(map[string]struct{}, maxSelections)
+		for _, answerID := range vote.Answers {
+			if _, ok := counts[answerID]; !ok {
+				continue
+			} else if _, ok = selected[answerID]; ok {
+				continue
+			}
+			counts[answerID]++
+			selected[answerID] = struct{}{}
+			if len(selected) >= maxSelections {
+				break
+			}
+		}
+		if len(selected) > 0 {
+			voters++
+		}
+	}
+	return
+}
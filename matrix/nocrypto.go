@@ -4,6 +4,18 @@
 
 package matrix
 
+import (
+	"errors"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/ssss"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/matrix/rooms"
+)
+
 func isBadEncryptError(err error) bool {
 	return false
 }
@@ -11,3 +23,45 @@ func isBadEncryptError(err error) bool {
 func (c *Container) initCrypto() error {
 	return nil
 }
+
+func (c *Container) trackUndecryptable(evt *muksevt.Event, err error) {}
+
+func (c *Container) retryCachedUndecryptable(events []*muksevt.Event) {}
+
+func (c *Container) RetryDecryption() {}
+
+func (c *Container) processSyncResponse(resp *mautrix.RespSync, since string) {}
+
+func (c *Container) queueVerification(fn func()) {
+	fn()
+}
+
+type wedgeState struct{}
+
+func (c *Container) trackDecryptionFailure(evt *event.Event, err error) {}
+
+func (c *Container) decryptionFailureReason(evt *event.Event, content *event.EncryptedEventContent, err error) string {
+	return err.Error()
+}
+
+func (c *Container) invalidateStaleGroupSession(room *rooms.Room) {}
+
+func (c *Container) shareGroupSession(room *rooms.Room) error {
+	return nil
+}
+
+var errNoCrypto = errors.New("encryption is not enabled")
+
+func (c *Container) GetDehydratedDevice() (id.DeviceID, error) {
+	return "", errNoCrypto
+}
+
+func (c *Container) DehydrateDevice(key *ssss.Key) (id.DeviceID, error) {
+	return "", errNoCrypto
+}
+
+func (c *Container) RehydrateDevice(key *ssss.Key) (id.DeviceID, error) {
+	return "", errNoCrypto
+}
+
+func (c *Container) offerRehydration() {}
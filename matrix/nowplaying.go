@@ -0,0 +1,205 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+const nowPlayingCommandTimeout = 5 * time.Second
+const maxStatusMessageLength = 256
+
+// nowPlayingState keeps track of the status message set by the now playing integration.
+type nowPlayingState struct {
+	lock sync.Mutex
+	// The status message that was last set and when it was set.
+	current string
+	setAt   time.Time
+	// The status message the user had before the integration replaced it, restored when it's cleared.
+	previous string
+	wake     chan struct{}
+}
+
+type reqSetPresence struct {
+	Presence  event.Presence `json:"presence"`
+	StatusMsg string         `json:"status_msg"`
+}
+
+type respGetPresence struct {
+	Presence  event.Presence `json:"presence"`
+	StatusMsg string         `json:"status_msg"`
+}
+
+func (c *Container) getPresence() (*respGetPresence, error) {
+	var resp respGetPresence
+	u := c.client.BuildURL("presence", string(c.config.UserID), "status")
+	_, err := c.client.MakeRequest("GET", u, nil, &resp)
+	return &resp, err
+}
+
+// setStatusMessage changes the status message without changing the current presence, e.g. unavailable.
+func (c *Container) setStatusMessage(status string) error {
+	var presence event.Presence = event.PresenceOnline
+	if current, err := c.getPresence(); err != nil {
+		debug.Print("Failed to get current presence:", err)
+	} else if len(current.Presence) > 0 {
+		presence = current.Presence
+	}
+	u := c.client.BuildURL("presence", string(c.config.UserID), "status")
+	_, err := c.client.MakeRequest("PUT", u, &reqSetPresence{
+		Presence:  presence,
+		StatusMsg: status,
+	}, nil)
+	return err
+}
+
+// runNowPlayingCommand runs the configured command and returns the first line of its output.
+func runNowPlayingCommand(command []string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), nowPlayingCommandTimeout)
+	defer cancel()
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	status := strings.TrimSpace(stdout.String())
+	if idx := strings.IndexByte(status, '\n'); idx >= 0 {
+		status = strings.TrimSpace(status[:idx])
+	}
+	if len(status) > maxStatusMessageLength {
+		status = strings.ToValidUTF8(status[:maxStatusMessageLength], "")
+	}
+	return status, nil
+}
+
+// runNowPlaying periodically updates the status message from the now playing command while it's enabled.
+func (c *Container) runNowPlaying() {
+	defer debug.Recover()
+	for c.running {
+		np := c.config.NowPlaying
+		if np == nil {
+			return
+		}
+		if np.IsEnabled() {
+			c.updateNowPlaying()
+		} else {
+			c.clearNowPlaying()
+		}
+		select {
+		case <-c.nowPlaying.wake:
+		case <-time.After(np.GetInterval()):
+		}
+	}
+}
+
+// updateNowPlaying runs the now playing command and sets the output as the status message if it changed.
+//
+// Updates are rate limited to one per the configured minimum interval. A change within that time is
+// picked up on a later run, so only the latest status is sent rather than every intermediate one.
+func (c *Container) updateNowPlaying() {
+	np := c.config.NowPlaying
+	status, err := runNowPlayingCommand(np.Command)
+	if err != nil {
+		debug.Print("Now playing command failed:", err)
+		status = ""
+	}
+	state := &c.nowPlaying
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if status == state.current || time.Since(state.setAt) < np.GetMinUpdateInterval() {
+		return
+	}
+	if len(state.current) == 0 {
+		if resp, err := c.getPresence(); err != nil {
+			debug.Print("Failed to get status message to restore later:", err)
+		} else {
+			state.previous = resp.StatusMsg
+		}
+	}
+	statusMsg := status
+	if len(statusMsg) == 0 {
+		// Nothing is playing, so show the user's own status message until something is.
+		statusMsg = state.previous
+	}
+	err = c.setStatusMessage(statusMsg)
+	if err != nil {
+		debug.Print("Failed to set now playing status message:", err)
+		// Don't retry immediately if the server is rate limiting us.
+		state.setAt = time.Now()
+		return
+	}
+	state.current = status
+	state.setAt = time.Now()
+}
+
+// clearNowPlaying restores the status message the user had before the now playing integration set one, if it did.
+// It ignores the rate limit, as it's used when the integration is turned off.
+func (c *Container) clearNowPlaying() {
+	state := &c.nowPlaying
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if len(state.current) == 0 {
+		return
+	}
+	err := c.setStatusMessage(state.previous)
+	if err != nil {
+		debug.Print("Failed to clear now playing status message:", err)
+		return
+	}
+	state.current = ""
+	state.previous = ""
+	state.setAt = time.Now()
+}
+
+func (c *Container) wakeNowPlaying() {
+	select {
+	case c.nowPlaying.wake <- struct{}{}:
+	default:
+	}
+}
+
+// NowPlaying returns the status message currently set by the now playing integration.
+func (c *Container) NowPlaying() string {
+	c.nowPlaying.lock.Lock()
+	defer c.nowPlaying.lock.Unlock()
+	return c.nowPlaying.current
+}
+
+// SetNowPlayingEnabled turns the now playing integration on or off and saves the choice in the config.
+// Turning it off clears the status message immediately.
+func (c *Container) SetNowPlayingEnabled(enabled bool) {
+	np := c.config.NowPlaying
+	if np == nil || np.Enabled == enabled {
+		return
+	}
+	np.Enabled = enabled
+	c.config.Save()
+	if !enabled {
+		c.clearNowPlaying()
+	}
+	c.wakeNowPlaying()
+}
@@ -0,0 +1,73 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+)
+
+// HandlePinnedEvents tells the room view when the set of pinned messages in a room changes.
+func (c *Container) HandlePinnedEvents(source mautrix.EventSource, evt *event.Event) {
+	if !c.config.AuthCache.InitialSyncDone || source&mautrix.EventSourceTimeline == 0 {
+		return
+	}
+	content, ok := evt.Content.Parsed.(*muksevt.PinnedEventsContent)
+	if !ok {
+		return
+	}
+	var prevPinned []id.EventID
+	if evt.Unsigned.PrevContent != nil {
+		_ = evt.Unsigned.PrevContent.ParseRaw(evt.Type)
+		if prevContent, ok := evt.Unsigned.PrevContent.Parsed.(*muksevt.PinnedEventsContent); ok {
+			prevPinned = prevContent.Pinned
+		}
+	}
+	added, removed := diffPinned(prevPinned, content.Pinned)
+	if added == 0 && removed == 0 {
+		return
+	}
+
+	roomView := c.ui.MainView().GetRoom(evt.RoomID)
+	if roomView == nil {
+		debug.Printf("Failed to handle pinned events %s: No room view found.", evt.ID)
+		return
+	}
+	roomView.PinsChanged(evt.Sender, added, removed)
+	if c.syncer.FirstSyncDone {
+		c.ui.Render()
+	}
+}
+
+func diffPinned(prev, cur []id.EventID) (added, removed int) {
+	prevSet := make(map[id.EventID]struct{}, len(prev))
+	for _, evtID := range prev {
+		prevSet[evtID] = struct{}{}
+	}
+	for _, evtID := range cur {
+		if _, ok := prevSet[evtID]; ok {
+			delete(prevSet, evtID)
+		} else {
+			added++
+		}
+	}
+	return added, len(prevSet)
+}
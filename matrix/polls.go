@@ -0,0 +1,78 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"errors"
+
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/matrix/rooms"
+)
+
+var ErrNotAPoll = errors.New("event is not a poll")
+var ErrCantEndOthersPoll = errors.New("can't end poll started by someone else")
+
+// HandlePollUpdate aggregates a poll response or end event into the poll start event it refers to.
+func (c *Container) HandlePollUpdate(room *rooms.Room, pollID id.EventID, updateEvt *muksevt.Event) {
+	var origEvt *muksevt.Event
+	err := c.history.Update(room, pollID, func(evt *muksevt.Event) error {
+		if _, ok := evt.Content.Parsed.(*muksevt.PollStartContent); !ok {
+			return ErrNotAPoll
+		}
+		if evt.Gomuks.Poll == nil {
+			evt.Gomuks.Poll = &muksevt.PollState{}
+		}
+		switch content := updateEvt.Content.Parsed.(type) {
+		case *muksevt.PollResponseContent:
+			evt.Gomuks.Poll.AddVote(updateEvt.Sender, muksevt.PollVote{
+				EventID:   updateEvt.ID,
+				Answers:   content.Response.Answers,
+				Timestamp: updateEvt.Timestamp,
+			})
+		case *muksevt.PollEndContent:
+			if updateEvt.Sender != evt.Sender {
+				return ErrCantEndOthersPoll
+			}
+			evt.Gomuks.Poll.End(updateEvt.Timestamp)
+		}
+		origEvt = evt
+		return nil
+	})
+	if err == ErrNotAPoll || err == ErrCantEndOthersPoll {
+		debug.Printf("Ignoring poll update %s to %s by %s in %s: %v", updateEvt.ID, pollID, updateEvt.Sender, updateEvt.RoomID, err)
+		return
+	} else if err != nil {
+		debug.Print("Failed to store poll update in history db:", err)
+		return
+	} else if !c.config.AuthCache.InitialSyncDone || !room.Loaded() {
+		return
+	}
+
+	roomView := c.ui.MainView().GetRoom(updateEvt.RoomID)
+	if roomView == nil {
+		debug.Printf("Failed to handle poll update %v: No room view found.", updateEvt)
+		return
+	}
+
+	roomView.AddEdit(origEvt)
+	if c.syncer.FirstSyncDone {
+		c.ui.Render()
+	}
+}
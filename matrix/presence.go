@@ -0,0 +1,61 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type presenceInfo struct {
+	presence   event.Presence
+	lastActive time.Time
+}
+
+// HandlePresence is the event handler for the m.presence ephemeral event.
+func (c *Container) HandlePresence(_ mautrix.EventSource, evt *event.Event) {
+	content := evt.Content.AsPresence()
+	var lastActive time.Time
+	if content.CurrentlyActive {
+		lastActive = time.Now()
+	} else if content.LastActiveAgo > 0 {
+		lastActive = time.Now().Add(-time.Duration(content.LastActiveAgo) * time.Millisecond)
+	}
+
+	c.presenceLock.Lock()
+	if c.presence == nil {
+		c.presence = make(map[id.UserID]presenceInfo)
+	}
+	c.presence[evt.Sender] = presenceInfo{content.Presence, lastActive}
+	c.presenceLock.Unlock()
+
+	if c.config.AuthCache.InitialSyncDone {
+		c.ui.MainView().UpdatePresence(evt.Sender, lastActive)
+	}
+}
+
+// GetPresence returns the last known presence of the given user and when they were last active.
+// The presence is empty if the server hasn't told us anything about the user.
+func (c *Container) GetPresence(userID id.UserID) (event.Presence, time.Time) {
+	c.presenceLock.Lock()
+	info := c.presence[userID]
+	c.presenceLock.Unlock()
+	return info.presence, info.lastActive
+}
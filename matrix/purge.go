@@ -0,0 +1,107 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+)
+
+// mediaURIs returns the content URIs of the file and thumbnail in the given event, if it has any.
+func mediaURIs(evt *muksevt.Event) (uris []id.ContentURI) {
+	content, ok := evt.Content.Parsed.(*event.MessageEventContent)
+	if !ok {
+		return
+	}
+	add := func(uri id.ContentURIString) {
+		if parsed := uri.ParseOrIgnore(); !parsed.IsEmpty() {
+			uris = append(uris, parsed)
+		}
+	}
+	add(content.URL)
+	if content.File != nil {
+		add(content.File.URL)
+	}
+	if info := content.Info; info != nil {
+		add(info.ThumbnailURL)
+		if info.ThumbnailFile != nil {
+			add(info.ThumbnailFile.URL)
+		}
+	}
+	return
+}
+
+// PurgeMediaCache deletes downloaded media and thumbnails from the cache.
+//
+// If roomID is set, only media from the stored history of that room is deleted. If olderThan is not zero,
+// only files that were last used longer than that ago are deleted. Returns the number of deleted files
+// and how many bytes they took.
+func (c *Container) PurgeMediaCache(roomID id.RoomID, olderThan time.Duration) (files int, size int64, err error) {
+	var paths []string
+	if len(roomID) > 0 {
+		room := c.GetRoom(roomID)
+		if room == nil {
+			return 0, 0, nil
+		}
+		err = c.history.ForEach(room, func(evt *muksevt.Event) error {
+			for _, uri := range mediaURIs(evt) {
+				paths = append(paths, filepath.Join(c.config.MediaDir, uri.Homeserver, uri.FileID))
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	} else {
+		err = filepath.Walk(c.config.MediaDir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	cutoff := time.Now().Add(-olderThan)
+	deleted := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		if _, alreadyDeleted := deleted[path]; alreadyDeleted {
+			continue
+		}
+		info, statErr := os.Stat(path)
+		if statErr != nil || info.IsDir() || (olderThan > 0 && info.ModTime().After(cutoff)) {
+			continue
+		}
+		if removeErr := os.Remove(path); removeErr != nil {
+			debug.Printf("Failed to remove cached media %s: %v", path, removeErr)
+			continue
+		}
+		deleted[path] = struct{}{}
+		files++
+		size += info.Size()
+	}
+	c.cachedMediaRemoved(size)
+	return
+}
@@ -0,0 +1,107 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"fmt"
+
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/matrix/rooms"
+)
+
+// reactionRef points from a reaction event to the message and key it annotates.
+//
+// Reaction events aren't stored in the history database, so this index is the
+// only way to find what a redacted reaction was attached to. It only covers
+// reactions seen during this session.
+type reactionRef struct {
+	target id.EventID
+	key    string
+}
+
+func (c *Container) trackReaction(reactionID, target id.EventID, key string) {
+	c.reactionsLock.Lock()
+	if c.reactions == nil {
+		c.reactions = make(map[id.EventID]reactionRef)
+	}
+	c.reactions[reactionID] = reactionRef{target, key}
+	c.reactionsLock.Unlock()
+}
+
+func (c *Container) popReaction(reactionID id.EventID) (ref reactionRef, ok bool) {
+	c.reactionsLock.Lock()
+	ref, ok = c.reactions[reactionID]
+	delete(c.reactions, reactionID)
+	c.reactionsLock.Unlock()
+	return
+}
+
+// removeReaction undoes the aggregation of a redacted reaction event.
+func (c *Container) removeReaction(room *rooms.Room, reactionID id.EventID, ref reactionRef) {
+	var origEvt *muksevt.Event
+	err := c.history.Update(room, ref.target, func(evt *muksevt.Event) error {
+		counts := evt.Unsigned.Relations.Annotations.Map
+		if counts[ref.key] > 1 {
+			counts[ref.key]--
+		} else {
+			delete(counts, ref.key)
+		}
+		if evt.Gomuks.OwnReactions[ref.key] == reactionID {
+			delete(evt.Gomuks.OwnReactions, ref.key)
+		}
+		origEvt = evt
+		return nil
+	})
+	if err != nil {
+		debug.Print("Failed to remove reaction from history db:", err)
+		return
+	} else if !c.config.AuthCache.InitialSyncDone || !room.Loaded() {
+		return
+	}
+
+	roomView := c.ui.MainView().GetRoom(room.ID)
+	if roomView == nil {
+		debug.Printf("Failed to handle reaction redaction %s: No room view found.", reactionID)
+		return
+	}
+
+	roomView.AddEdit(origEvt)
+	if c.syncer.FirstSyncDone {
+		c.ui.Render()
+	}
+}
+
+// RedactReaction redacts the reaction with the given key that the user has
+// sent to the given event.
+func (c *Container) RedactReaction(roomID id.RoomID, target id.EventID, key string) error {
+	room := c.GetOrCreateRoom(roomID)
+	evt, err := c.history.Get(room, target)
+	if err != nil {
+		return err
+	} else if evt == nil {
+		return fmt.Errorf("event %s not found", target)
+	}
+	reactionID, ok := evt.Gomuks.OwnReactions[key]
+	if !ok {
+		return fmt.Errorf("no %s reaction to %s found", key, target)
+	}
+	c.trackReaction(reactionID, target, key)
+	return c.Redact(roomID, reactionID, "")
+}
@@ -0,0 +1,65 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/matrix/muksevt"
+)
+
+const (
+	receiptReadPrivate         = "m.read.private"
+	receiptReadPrivateUnstable = "org.matrix.msc2285.read.private"
+)
+
+// markReadPrivately sends a private read receipt that is only visible to the user's own devices.
+// Servers that don't support the stable receipt type yet are sent the unstable MSC2285 type.
+func (c *Container) markReadPrivately(roomID id.RoomID, eventID id.EventID) error {
+	err := c.sendReceipt(roomID, eventID, receiptReadPrivate)
+	if httpErr, ok := err.(mautrix.HTTPError); ok && httpErr.RespError != nil &&
+		(httpErr.RespError.ErrCode == "M_INVALID_PARAM" || httpErr.RespError.ErrCode == "M_UNRECOGNIZED") {
+		err = c.sendReceipt(roomID, eventID, receiptReadPrivateUnstable)
+	}
+	return err
+}
+
+func (c *Container) sendReceipt(roomID id.RoomID, eventID id.EventID, receiptType string) error {
+	u := c.client.BuildURL("rooms", roomID, "receipt", receiptType, eventID)
+	_, err := c.client.MakeRequest("POST", u, struct{}{}, nil)
+	return err
+}
+
+func (c *Container) setFullyRead(roomID id.RoomID, eventID id.EventID) error {
+	if room := c.GetRoom(roomID); room != nil {
+		room.FullyRead = eventID
+	}
+	u := c.client.BuildURL("rooms", roomID, "read_markers")
+	_, err := c.client.MakeRequest("POST", u, map[string]id.EventID{"m.fully_read": eventID}, nil)
+	return err
+}
+
+// HandleFullyRead stores the fully read marker of a room, which the UI uses to show where unread messages start.
+func (c *Container) HandleFullyRead(_ mautrix.EventSource, evt *event.Event) {
+	content, ok := evt.Content.Parsed.(*muksevt.FullyReadContent)
+	if !ok || len(evt.RoomID) == 0 {
+		return
+	}
+	c.GetOrCreateRoom(evt.RoomID).FullyRead = content.EventID
+}
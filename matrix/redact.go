@@ -0,0 +1,57 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/lib/ratelimit"
+)
+
+// bulkRedactInterval is the pause between redactions when redacting many events, so that
+// cleaning up spam doesn't immediately run into the server's rate limits.
+const bulkRedactInterval = 500 * time.Millisecond
+
+// RedactMany redacts the given events one at a time, pausing between redactions and waiting as long
+// as the server asks when it rate limits. progress is called after each event with the number of
+// events handled so far.
+func (c *Container) RedactMany(roomID id.RoomID, eventIDs []id.EventID, reason string, progress func(done int)) (redacted int, errs []error) {
+	defer debug.Recover()
+	for i, eventID := range eventIDs {
+		if i > 0 {
+			time.Sleep(bulkRedactInterval)
+		}
+		err := ratelimit.Retry(fmt.Sprintf("redacting %s", eventID), func() error {
+			_, err := c.client.RedactEvent(roomID, eventID, mautrix.ReqRedact{Reason: reason})
+			return err
+		})
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			redacted++
+		}
+		if progress != nil {
+			progress(i + 1)
+		}
+	}
+	return
+}
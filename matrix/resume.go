@@ -0,0 +1,224 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+// initialSyncFlushInterval is how many processed rooms are batched before the room list is saved
+// and the rooms are marked as done in the progress file.
+const initialSyncFlushInterval = 50
+
+// initialSyncGlobalMarker is written to the progress file once the to-device events and global account data
+// of the initial sync response have been handled. Room IDs always start with "!", so it can't clash with them.
+const initialSyncGlobalMarker = "*global"
+
+func (c *Container) initialSyncPath() string {
+	return filepath.Join(c.config.CacheDir, "initial-sync.json.gz")
+}
+
+func (c *Container) initialSyncProgressPath() string {
+	return filepath.Join(c.config.CacheDir, "initial-sync-progress.txt")
+}
+
+// saveInitialSync stores an initial sync response before it's processed, so that processing can be resumed
+// if gomuks is killed before it finishes.
+func (c *Container) saveInitialSync(res *mautrix.RespSync) {
+	err := c.writeInitialSync(res)
+	if err != nil {
+		debug.Print("Failed to save initial sync response:", err)
+		_ = os.Remove(c.initialSyncPath())
+	}
+	_ = os.Remove(c.initialSyncProgressPath())
+	c.initialSyncLock.Lock()
+	c.initialSyncPending = nil
+	c.initialSyncLock.Unlock()
+}
+
+func (c *Container) writeInitialSync(res *mautrix.RespSync) error {
+	tmpPath := c.initialSyncPath() + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	encWriter := c.config.CacheCipher.NewWriter(file)
+	cmpWriter := gzip.NewWriter(encWriter)
+	err = json.NewEncoder(cmpWriter).Encode(res)
+	if err == nil {
+		err = cmpWriter.Close()
+	}
+	if err == nil {
+		err = encWriter.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, c.initialSyncPath())
+}
+
+func (c *Container) readInitialSync() (*mautrix.RespSync, error) {
+	file, err := os.Open(c.initialSyncPath())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	decReader, err := c.config.CacheCipher.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	cmpReader, err := gzip.NewReader(decReader)
+	if err != nil {
+		return nil, err
+	}
+	var res mautrix.RespSync
+	err = json.NewDecoder(cmpReader).Decode(&res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *Container) readInitialSyncProgress() map[id.RoomID]struct{} {
+	done := make(map[id.RoomID]struct{})
+	file, err := os.Open(c.initialSyncProgressPath())
+	if err != nil {
+		return done
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := scanner.Text(); len(line) > 0 {
+			done[id.RoomID(line)] = struct{}{}
+		}
+	}
+	return done
+}
+
+// initialSyncRoomDone marks a room as processed. The rooms are saved and written to the progress file in batches.
+func (c *Container) initialSyncRoomDone(roomID id.RoomID) {
+	c.initialSyncLock.Lock()
+	defer c.initialSyncLock.Unlock()
+	c.initialSyncPending = append(c.initialSyncPending, roomID)
+	if len(c.initialSyncPending) >= initialSyncFlushInterval {
+		c.flushInitialSyncProgress()
+	}
+}
+
+func (c *Container) flushInitialSyncProgress() {
+	if len(c.initialSyncPending) == 0 {
+		return
+	}
+	for _, roomID := range c.initialSyncPending {
+		if room := c.config.Rooms.Get(roomID); room != nil && room.Loaded() {
+			room.Save()
+		}
+	}
+	if err := c.config.Rooms.SaveList(); err != nil {
+		debug.Print("Failed to save room list during initial sync:", err)
+		return
+	}
+	lines := make([]string, len(c.initialSyncPending))
+	for i, roomID := range c.initialSyncPending {
+		lines[i] = string(roomID)
+	}
+	if c.appendInitialSyncProgress(lines...) {
+		c.initialSyncPending = nil
+	}
+}
+
+// initialSyncGlobalDone marks the parts of the initial sync response that don't belong to a room as processed,
+// so that to-device events and account data aren't handled again if the initial sync is resumed.
+func (c *Container) initialSyncGlobalDone() {
+	c.config.SavePreferences()
+	c.initialSyncLock.Lock()
+	c.appendInitialSyncProgress(initialSyncGlobalMarker)
+	c.initialSyncLock.Unlock()
+}
+
+func (c *Container) appendInitialSyncProgress(lines ...string) bool {
+	file, err := os.OpenFile(c.initialSyncProgressPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		debug.Print("Failed to open initial sync progress file:", err)
+		return false
+	}
+	for _, line := range lines {
+		_, _ = fmt.Fprintln(file, line)
+	}
+	_ = file.Close()
+	return true
+}
+
+// finishInitialSync removes the saved initial sync response after it has been fully processed.
+func (c *Container) finishInitialSync() {
+	c.initialSyncLock.Lock()
+	c.initialSyncPending = nil
+	c.initialSyncLock.Unlock()
+	_ = os.Remove(c.initialSyncPath())
+	_ = os.Remove(c.initialSyncProgressPath())
+}
+
+// resumeInitialSync continues processing an initial sync response that was interrupted by a restart,
+// skipping the rooms that were already saved. It returns false if there was nothing to resume.
+func (c *Container) resumeInitialSync() bool {
+	res, err := c.readInitialSync()
+	if os.IsNotExist(err) {
+		return false
+	} else if err != nil {
+		debug.Print("Failed to read interrupted initial sync, starting over:", err)
+		c.finishInitialSync()
+		return false
+	}
+	done := c.readInitialSyncProgress()
+	if _, ok := done[initialSyncGlobalMarker]; ok {
+		delete(done, initialSyncGlobalMarker)
+		// Replaying to-device events would fail to decrypt them again, as their olm sessions have moved on.
+		res.ToDevice.Events = nil
+		res.AccountData.Events = nil
+		res.Presence.Events = nil
+		debug.Print("To-device events and account data of the interrupted initial sync were already processed")
+	}
+	debug.Printf("Resuming interrupted initial sync (%d rooms already processed)", len(done))
+
+	c.syncer.SkipRooms = done
+	started := c.syncer.InitialSyncStarted
+	c.syncer.InitialSyncStarted = nil
+	err = c.syncer.ProcessResponse(res, "")
+	c.syncer.InitialSyncStarted = started
+	c.syncer.SkipRooms = nil
+	if err != nil {
+		debug.Print("Failed to process interrupted initial sync, starting over:", err)
+		c.finishInitialSync()
+		return false
+	}
+	c.config.SaveNextBatch(c.config.UserID, res.NextBatch)
+	return true
+}
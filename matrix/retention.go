@@ -0,0 +1,177 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/rooms"
+)
+
+var ErrNoRetentionLimits = errors.New("no retention limits configured")
+
+// retentionLimits are the limits used for one run of history pruning. Zero values mean no limit.
+type retentionLimits struct {
+	maxAge    time.Duration
+	maxEvents int
+	maxSize   int64
+}
+
+func (limits retentionLimits) empty() bool {
+	return limits.maxAge <= 0 && limits.maxEvents <= 0 && limits.maxSize <= 0
+}
+
+// runRetention periodically prunes the local history cache according to the configured retention.
+func (c *Container) runRetention() {
+	defer debug.Recover()
+	for c.running {
+		ret := c.config.Retention
+		if !ret.IsEnabled() {
+			return
+		}
+		time.Sleep(ret.GetInterval())
+		if !c.running || !c.config.AuthCache.InitialSyncDone {
+			continue
+		}
+		limits := retentionLimits{ret.GetMaxAge(), ret.MaxEvents, ret.GetMaxSize()}
+		pruned, events, _ := c.pruneHistory(limits, false)
+		debug.Printf("Pruned %d events in %d rooms from the history cache", events, pruned)
+	}
+}
+
+// PruneHistory prunes the local history cache with the configured retention limits. If maxAge is
+// not zero, it replaces the configured maximum age. Rooms that are still loaded are unloaded first,
+// but the room that is currently open can't be pruned and is skipped.
+func (c *Container) PruneHistory(maxAge time.Duration) (prunedRooms, prunedEvents, skippedRooms int, err error) {
+	var limits retentionLimits
+	if ret := c.config.Retention; ret != nil {
+		limits = retentionLimits{ret.GetMaxAge(), ret.MaxEvents, ret.GetMaxSize()}
+	}
+	if maxAge > 0 {
+		limits.maxAge = maxAge
+	}
+	if limits.empty() {
+		return 0, 0, 0, ErrNoRetentionLimits
+	}
+	prunedRooms, prunedEvents, skippedRooms = c.pruneHistory(limits, true)
+	return
+}
+
+// sizeCutoff returns the timestamp before which events have to be pruned to fit the stored events
+// of all rooms in the given size.
+func sizeCutoff(stored map[*rooms.Room][]storedEvent, maxSize int64) int64 {
+	var all []storedEvent
+	var total int64
+	for _, events := range stored {
+		all = append(all, events...)
+		for _, evt := range events {
+			total += int64(evt.size)
+		}
+	}
+	if total <= maxSize {
+		return 0
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].timestamp > all[j].timestamp
+	})
+	var kept int64
+	for _, evt := range all {
+		kept += int64(evt.size)
+		if kept > maxSize {
+			return evt.timestamp + 1
+		}
+	}
+	return 0
+}
+
+// prunableCount returns how many of the oldest events should be pruned. Only the oldest events are
+// pruned so that the remaining history stays contiguous, and the newest event is always kept so
+// that there's something to paginate backwards from.
+func prunableCount(events []storedEvent, limits retentionLimits, cutoff int64) (n int) {
+	for n < len(events)-1 {
+		tooMany := limits.maxEvents > 0 && len(events)-n > limits.maxEvents
+		if !tooMany && events[n].timestamp >= cutoff {
+			break
+		}
+		n++
+	}
+	return
+}
+
+func (c *Container) pruneHistory(limits retentionLimits, unload bool) (prunedRooms, prunedEvents, skippedRooms int) {
+	c.config.Rooms.Lock()
+	roomList := make([]*rooms.Room, 0, len(c.config.Rooms.Map))
+	for _, room := range c.config.Rooms.Map {
+		roomList = append(roomList, room)
+	}
+	c.config.Rooms.Unlock()
+
+	stored := make(map[*rooms.Room][]storedEvent, len(roomList))
+	for _, room := range roomList {
+		events, err := c.history.listEvents(room)
+		if err != nil {
+			debug.Printf("Failed to list stored events of %s for pruning: %v", room.ID, err)
+			continue
+		}
+		stored[room] = events
+	}
+
+	var cutoff int64
+	if limits.maxAge > 0 {
+		cutoff = time.Now().Add(-limits.maxAge).UnixNano() / 1e6
+	}
+	if limits.maxSize > 0 {
+		if sizeCut := sizeCutoff(stored, limits.maxSize); sizeCut > cutoff {
+			cutoff = sizeCut
+		}
+	}
+
+	for room, events := range stored {
+		n := prunableCount(events, limits, cutoff)
+		if n == 0 {
+			continue
+		}
+		// The timeline of a loaded room may be showing the events, so only unloaded rooms are pruned.
+		if room.Loaded() && unload {
+			c.config.Rooms.Unload(room)
+		}
+		if room.Loaded() {
+			skippedRooms++
+			continue
+		}
+		// The pagination token has to point at the new oldest event, otherwise loading more history
+		// from the server would skip over the pruned events.
+		token, err := c.paginationTokenBefore(room.ID, events[n].eventID)
+		if err != nil {
+			debug.Printf("Failed to get pagination token for pruning %s: %v", room.ID, err)
+			skippedRooms++
+			continue
+		}
+		if err = c.history.Delete(room, events[:n]); err != nil {
+			debug.Printf("Failed to prune history of %s: %v", room.ID, err)
+			skippedRooms++
+			continue
+		}
+		room.PrevBatch = token
+		prunedRooms++
+		prunedEvents += n
+	}
+	return
+}
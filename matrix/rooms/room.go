@@ -22,6 +22,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"time"
 
 	sync "github.com/sasha-s/go-deadlock"
@@ -31,6 +32,7 @@ import (
 	"maunium.net/go/mautrix/id"
 
 	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
 )
 
 func init() {
@@ -68,6 +70,18 @@ type Member struct {
 	Sender id.UserID `json:"-"`
 }
 
+// Values for Room.TypingNotifs.
+const (
+	TypingNotifsOn  = "on"
+	TypingNotifsOff = "off"
+)
+
+// Values for Room.URLPreviews.
+const (
+	URLPreviewsOn  = "on"
+	URLPreviewsOff = "off"
+)
+
 // Room represents a single Matrix room.
 type Room struct {
 	// The room ID.
@@ -86,6 +100,8 @@ type Room struct {
 	// The MXID of the user whose session this room was created for.
 	SessionUserID id.UserID
 	SessionMember *Member
+	// When the session user was invited to the room, if the current membership is an invite.
+	InvitedAt time.Time
 
 	// The number of unread messages that were notified about.
 	UnreadMessages   []UnreadMessage
@@ -105,6 +121,34 @@ type Room struct {
 	Summary mautrix.LazyLoadSummary
 	// Whether or not the members for this room have been fetched from the server.
 	MembersFetched bool
+
+	// Local display settings for inline images in this room.
+	// Zero values mean the defaults are used.
+	ImageScale   float64
+	MaxImageRows int
+	// Per-room override for the display mode preference, or empty to follow the global preference.
+	DisplayMode string
+	// Whether read receipts in this room should only be sent privately (m.read.private).
+	PrivateReceipts bool
+	// Per-room override for sending typing notifications: TypingNotifsOn, TypingNotifsOff
+	// or empty to follow the global preference.
+	TypingNotifs string
+	// Per-room override for showing link previews: URLPreviewsOn, URLPreviewsOff or empty to follow
+	// the global preference, which only applies to unencrypted rooms.
+	URLPreviews string
+	// The language outgoing messages are translated into with the configured translation
+	// command, or empty if messages are sent as typed.
+	TranslateTo string
+	// Whether the original text is included in the formatted body of translated messages.
+	TranslateIncludeOriginal bool
+	// The event the fully read marker of the user points at.
+	FullyRead id.EventID
+	// The unsent contents of the input area, and the event that was being replied to or edited.
+	Draft        string
+	DraftReplyTo id.EventID
+	DraftEditing id.EventID
+	// Timestamp of the newest thread reply the user has seen, keyed by thread root event ID.
+	ThreadRead map[id.EventID]time.Time
 	// Room state cache.
 	state map[event.Type]map[string]*event.Event
 	// MXID -> Member cache calculated from membership events.
@@ -114,6 +158,9 @@ type Room struct {
 	// the same time as memberCache.
 	firstMemberCache  *Member
 	secondMemberCache *Member
+	// Displayname -> number of joined or invited members with that name.
+	// Calculated lazily from memberCache and cleared whenever it changes.
+	displaynameCountCache map[string]int
 	// The name of the room. Calculated from the state event name,
 	// canonical_alias or alias or the member cache.
 	NameCache string
@@ -190,7 +237,12 @@ func (room *Room) load() {
 		return
 	}
 	defer debugPrintError(file.Close, "Failed to close room state file after reading")
-	cmpReader, err := gzip.NewReader(file)
+	reader, err := room.cache.cipher.NewReader(file)
+	if err != nil {
+		debug.Print("Failed to decrypt room state:", err)
+		return
+	}
+	cmpReader, err := gzip.NewReader(reader)
 	if err != nil {
 		debug.Print("Failed to open room state gzip reader:", err)
 		return
@@ -218,6 +270,7 @@ func (room *Room) Unload() bool {
 	room.exMemberCache = nil
 	room.firstMemberCache = nil
 	room.secondMemberCache = nil
+	room.displaynameCountCache = nil
 	if room.postUnload != nil {
 		room.postUnload()
 	}
@@ -250,13 +303,15 @@ func (room *Room) Save() {
 		return
 	}
 	debug.Print("Saving state for room", room.ID, "to disk")
-	file, err := os.OpenFile(room.path, os.O_WRONLY|os.O_CREATE, 0600)
+	file, err := os.OpenFile(room.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		debug.Print("Failed to open room state file for writing:", err)
 		return
 	}
 	defer debugPrintError(file.Close, "Failed to close room state file after writing")
-	cmpWriter := gzip.NewWriter(file)
+	encWriter := room.cache.cipher.NewWriter(file)
+	defer debugPrintError(encWriter.Close, "Failed to close room state encrypting writer")
+	cmpWriter := gzip.NewWriter(encWriter)
 	defer debugPrintError(cmpWriter.Close, "Failed to close room state gzip writer")
 	enc := gob.NewEncoder(cmpWriter)
 	room.lock.RLock()
@@ -266,6 +321,16 @@ func (room *Room) Save() {
 	}
 }
 
+// LatestUnread returns the ID of the latest unread message in this room, or an empty string if there are no unread messages.
+func (room *Room) LatestUnread() id.EventID {
+	room.lock.RLock()
+	defer room.lock.RUnlock()
+	if len(room.UnreadMessages) == 0 {
+		return ""
+	}
+	return room.UnreadMessages[len(room.UnreadMessages)-1].EventID
+}
+
 // MarkRead clears the new message statuses on this room.
 func (room *Room) MarkRead(eventID id.EventID) bool {
 	room.lock.Lock()
@@ -350,6 +415,25 @@ var (
 	tagLeave   = RoomTag{"net.maunium.gomuks.fake.leave", "0.5"}
 )
 
+// ThreadReadTime returns the timestamp of the newest reply the user has seen in the given thread.
+func (room *Room) ThreadReadTime(rootID id.EventID) time.Time {
+	room.lock.RLock()
+	defer room.lock.RUnlock()
+	return room.ThreadRead[rootID]
+}
+
+// MarkThreadRead marks all replies in the given thread up to the given timestamp as read.
+func (room *Room) MarkThreadRead(rootID id.EventID, upTo time.Time) {
+	room.lock.Lock()
+	defer room.lock.Unlock()
+	if room.ThreadRead == nil {
+		room.ThreadRead = make(map[id.EventID]time.Time)
+	}
+	if upTo.After(room.ThreadRead[rootID]) {
+		room.ThreadRead[rootID] = upTo
+	}
+}
+
 func (room *Room) Tags() []RoomTag {
 	room.lock.RLock()
 	defer room.lock.RUnlock()
@@ -409,6 +493,9 @@ func (room *Room) UpdateState(evt *event.Event) {
 		if room.nameCacheSource <= MemberRoomName {
 			room.NameCache = ""
 		}
+		if id.UserID(evt.GetStateKey()) == room.SessionUserID {
+			room.updateInvitedAt(evt, content)
+		}
 		room.updateMemberState(id.UserID(evt.GetStateKey()), evt.Sender, content)
 	case *event.TopicEventContent:
 		room.topicCache = content.Topic
@@ -425,11 +512,33 @@ func (room *Room) UpdateState(evt *event.Event) {
 	room.state[evt.Type][*evt.StateKey] = evt
 }
 
+func (room *Room) updateInvitedAt(evt *event.Event, content *event.MemberEventContent) {
+	if content.Membership != event.MembershipInvite {
+		room.InvitedAt = time.Time{}
+	} else if room.SessionMember == nil || room.SessionMember.Membership != event.MembershipInvite || room.InvitedAt.IsZero() {
+		// Stripped invite state usually doesn't include a timestamp, so fall back to when the invite was received.
+		if evt.Timestamp > 0 {
+			room.InvitedAt = time.Unix(evt.Timestamp/1000, evt.Timestamp%1000*int64(time.Millisecond))
+		} else {
+			room.InvitedAt = time.Now()
+		}
+	}
+}
+
+// Inviter returns the user who invited the session user to this room, or an empty string if the room isn't an invite.
+func (room *Room) Inviter() id.UserID {
+	if room.SessionMember == nil || room.SessionMember.Membership != event.MembershipInvite {
+		return ""
+	}
+	return room.SessionMember.Sender
+}
+
 func (room *Room) updateMemberState(userID, sender id.UserID, content *event.MemberEventContent) {
 	if userID == room.SessionUserID {
 		debug.Print("Updating session user state:", content)
 		room.SessionMember = room.eventToMember(userID, sender, content)
 	}
+	room.displaynameCountCache = nil
 	if room.memberCache != nil {
 		member := room.eventToMember(userID, sender, content)
 		if member.Membership.IsInviteOrJoin() {
@@ -463,6 +572,39 @@ func (room *Room) GetStateEvent(eventType event.Type, stateKey string) *event.Ev
 	return evt
 }
 
+// GetStateEvents returns a copy of the state events for the given type, keyed by state key.
+func (room *Room) GetStateEvents(eventType event.Type) map[string]*event.Event {
+	room.Load()
+	room.lock.RLock()
+	defer room.lock.RUnlock()
+	stateEventMap, _ := room.state[eventType]
+	copied := make(map[string]*event.Event, len(stateEventMap))
+	for stateKey, evt := range stateEventMap {
+		copied[stateKey] = evt
+	}
+	return copied
+}
+
+// GetAllStateEvents returns all cached state events of the room sorted by type and state key.
+func (room *Room) GetAllStateEvents() []*event.Event {
+	room.Load()
+	room.lock.RLock()
+	defer room.lock.RUnlock()
+	var events []*event.Event
+	for _, stateEventMap := range room.state {
+		for _, evt := range stateEventMap {
+			events = append(events, evt)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Type.Type != events[j].Type.Type {
+			return events[i].Type.Type < events[j].Type.Type
+		}
+		return events[i].GetStateKey() < events[j].GetStateKey()
+	})
+	return events
+}
+
 // getStateEvents returns the state events for the given type.
 func (room *Room) getStateEvents(eventType event.Type) map[string]*event.Event {
 	stateEventMap, _ := room.state[eventType]
@@ -581,6 +723,33 @@ func (room *Room) ReplacedBy() id.RoomID {
 	return *room.replacedByCache
 }
 
+// Predecessor returns the ID of the room that this room was upgraded from,
+// or an empty string if this room isn't the result of an upgrade.
+func (room *Room) Predecessor() id.RoomID {
+	evt := room.GetStateEvent(event.StateCreate, "")
+	if evt == nil {
+		return ""
+	}
+	content, ok := evt.Content.Parsed.(*event.CreateEventContent)
+	if !ok {
+		return ""
+	}
+	return content.Predecessor.RoomID
+}
+
+// PinnedEvents returns the IDs of the pinned messages in this room.
+func (room *Room) PinnedEvents() []id.EventID {
+	evt := room.GetStateEvent(muksevt.StatePinnedEvents, "")
+	if evt == nil {
+		return nil
+	}
+	content, ok := evt.Content.Parsed.(*muksevt.PinnedEventsContent)
+	if !ok {
+		return nil
+	}
+	return content.Pinned
+}
+
 func (room *Room) eventToMember(userID, sender id.UserID, member *event.MemberEventContent) *Member {
 	if len(member.Displayname) == 0 {
 		member.Displayname = string(userID)
@@ -637,6 +806,7 @@ func (room *Room) createMemberCache() map[id.UserID]*Member {
 	room.lock.Lock()
 	room.memberCache = cache
 	room.exMemberCache = exCache
+	room.displaynameCountCache = nil
 	room.lock.Unlock()
 	return cache
 }
@@ -685,6 +855,33 @@ func (room *Room) GetMember(userID id.UserID) *Member {
 	return nil
 }
 
+// IsAmbiguousDisplayname returns true if more than one joined or invited member uses the given displayname.
+func (room *Room) IsAmbiguousDisplayname(displayname string) bool {
+	room.Load()
+	room.createMemberCache()
+	room.lock.Lock()
+	defer room.lock.Unlock()
+	if room.displaynameCountCache == nil {
+		room.displaynameCountCache = make(map[string]int, len(room.memberCache))
+		for _, member := range room.memberCache {
+			room.displaynameCountCache[member.Displayname]++
+		}
+	}
+	return room.displaynameCountCache[displayname] > 1
+}
+
+// GetDisambiguatedDisplayname returns the displayname of the given user.
+// If another member of the room has the same displayname, the user ID is appended to it.
+func (room *Room) GetDisambiguatedDisplayname(userID id.UserID) string {
+	member := room.GetMember(userID)
+	if member == nil {
+		return string(userID)
+	} else if member.Displayname != string(userID) && room.IsAmbiguousDisplayname(member.Displayname) {
+		return fmt.Sprintf("%s (%s)", member.Displayname, userID)
+	}
+	return member.Displayname
+}
+
 func (room *Room) GetMemberCount() int {
 	if room.memberCache == nil && room.Summary.JoinedMemberCount != nil {
 		return *room.Summary.JoinedMemberCount
@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	sync "github.com/sasha-s/go-deadlock"
@@ -30,6 +31,7 @@ import (
 	"maunium.net/go/mautrix/id"
 
 	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/lib/cachecrypt"
 )
 
 // RoomCache contains room state info in a hashmap and linked list.
@@ -42,6 +44,7 @@ type RoomCache struct {
 	maxAge    int64
 	getOwner  func() id.UserID
 	noUnload  bool
+	cipher    *cachecrypt.Cipher
 
 	Map  map[id.RoomID]*Room
 	head *Room
@@ -49,13 +52,14 @@ type RoomCache struct {
 	size int
 }
 
-func NewRoomCache(listPath, directory string, maxSize int, maxAge int64, getOwner func() id.UserID) *RoomCache {
+func NewRoomCache(listPath, directory string, maxSize int, maxAge int64, getOwner func() id.UserID, cipher *cachecrypt.Cipher) *RoomCache {
 	return &RoomCache{
 		listPath:  listPath,
 		directory: directory,
 		maxSize:   maxSize,
 		maxAge:    maxAge,
 		getOwner:  getOwner,
+		cipher:    cipher,
 
 		Map: make(map[id.RoomID]*Room),
 	}
@@ -88,11 +92,31 @@ func (cache *RoomCache) GetEncryptionEvent(roomID id.RoomID) *event.EncryptionEv
 }
 
 func (cache *RoomCache) FindSharedRooms(userID id.UserID) (shared []id.RoomID) {
+	return cache.findJoinedRooms(userID, true)
+}
+
+// FindCommonRooms finds all rooms, encrypted or not, that the given user has joined.
+func (cache *RoomCache) FindCommonRooms(userID id.UserID) (shared []id.RoomID) {
+	return cache.findJoinedRooms(userID, false)
+}
+
+// Rooms returns a snapshot of all the rooms in the cache, so that they can be iterated without holding the lock.
+func (cache *RoomCache) Rooms() []*Room {
+	cache.Lock()
+	defer cache.Unlock()
+	list := make([]*Room, 0, len(cache.Map))
+	for _, room := range cache.Map {
+		list = append(list, room)
+	}
+	return list
+}
+
+func (cache *RoomCache) findJoinedRooms(userID id.UserID, encryptedOnly bool) (shared []id.RoomID) {
 	// FIXME this disables unloading so TouchNode wouldn't try to double-lock
 	cache.DisableUnloading()
 	cache.Lock()
 	for _, room := range cache.Map {
-		if !room.Encrypted {
+		if encryptedOnly && !room.Encrypted {
 			continue
 		}
 		member, ok := room.GetMembers()[userID]
@@ -105,6 +129,31 @@ func (cache *RoomCache) FindSharedRooms(userID id.UserID) (shared []id.RoomID) {
 	return
 }
 
+// FindMembersByNick finds the joined members of all rooms whose display name or user ID localpart matches
+// the given nick, e.g. the puppets of an IRC user on bridged networks (@libera_nick:libera.chat).
+func (cache *RoomCache) FindMembersByNick(nick string) (found []id.UserID) {
+	nick = strings.ToLower(nick)
+	seen := make(map[id.UserID]struct{})
+	cache.DisableUnloading()
+	cache.Lock()
+	for _, room := range cache.Map {
+		for userID, member := range room.GetMembers() {
+			if _, ok := seen[userID]; ok || member.Membership != event.MembershipJoin {
+				continue
+			}
+			localpart, _, _ := userID.Parse()
+			localpart = strings.ToLower(localpart)
+			if strings.ToLower(member.Displayname) == nick || localpart == nick || strings.HasSuffix(localpart, "_"+nick) {
+				seen[userID] = struct{}{}
+				found = append(found, userID)
+			}
+		}
+	}
+	cache.Unlock()
+	cache.EnableUnloading()
+	return
+}
+
 func (cache *RoomCache) LoadList() error {
 	cache.Lock()
 	defer cache.Unlock()
@@ -119,8 +168,14 @@ func (cache *RoomCache) LoadList() error {
 	}
 	defer debugPrintError(file.Close, "Failed to close room list file after reading")
 
+	// Decrypt room list file if cache encryption is enabled
+	reader, err := cache.cipher.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt room list: %w", err)
+	}
+
 	// Open gzip reader for room list file
-	cmpReader, err := gzip.NewReader(file)
+	cmpReader, err := gzip.NewReader(reader)
 	if err != nil {
 		return fmt.Errorf("failed to read gzip room list: %w", err)
 	}
@@ -166,14 +221,18 @@ func (cache *RoomCache) SaveList() error {
 
 	debug.Print("Saving room list...")
 	// Open room list file
-	file, err := os.OpenFile(cache.listPath, os.O_WRONLY|os.O_CREATE, 0600)
+	file, err := os.OpenFile(cache.listPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to open room list file for writing: %w", err)
 	}
 	defer debugPrintError(file.Close, "Failed to close room list file after writing")
 
+	// Open encrypting writer for room list file (no-op if cache encryption is disabled)
+	encWriter := cache.cipher.NewWriter(file)
+	defer debugPrintError(encWriter.Close, "Failed to close room list encrypting writer")
+
 	// Open gzip writer for room list file
-	cmpWriter := gzip.NewWriter(file)
+	cmpWriter := gzip.NewWriter(encWriter)
 	defer debugPrintError(cmpWriter.Close, "Failed to close room list gzip writer")
 
 	// Open gob encoder for gzip writer
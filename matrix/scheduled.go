@@ -0,0 +1,183 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"path/filepath"
+	"sort"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	ifc "maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/matrix/muksevt"
+)
+
+// scheduledEvent is an outgoing event that is moved into the send queue once SendAt passes.
+// It's used for /send-later and for the undo send grace period.
+type scheduledEvent struct {
+	Event  *event.Event `json:"event"`
+	SendAt time.Time    `json:"send_at"`
+	// Whether the UI is already showing a local echo of the event. Messages scheduled with /send-later
+	// are only shown in the timeline once they're sent. Local echoes don't survive restarts.
+	Echoed bool `json:"-"`
+}
+
+func (c *Container) scheduledPath() string {
+	return filepath.Join(c.config.CacheDir, "scheduled.json")
+}
+
+// ScheduleEvent stores the event to be sent at the given time.
+// If echoed is false, a local echo is added to the room when the event is sent.
+func (c *Container) ScheduleEvent(evt *muksevt.Event, sendAt time.Time, echoed bool) {
+	plaintext := *evt.Event
+	c.sendQueueLock.Lock()
+	c.scheduled = append(c.scheduled, &scheduledEvent{
+		Event:  &plaintext,
+		SendAt: sendAt,
+		Echoed: echoed,
+	})
+	sort.SliceStable(c.scheduled, func(i, j int) bool {
+		return c.scheduled[i].SendAt.Before(c.scheduled[j].SendAt)
+	})
+	c.saveScheduled()
+	c.sendQueueLock.Unlock()
+	c.wakeSendQueue()
+}
+
+// CancelScheduledEvent removes the scheduled event with the given transaction ID.
+// It returns false if there is no such event, e.g. because it was already sent.
+func (c *Container) CancelScheduledEvent(txnID string) bool {
+	c.sendQueueLock.Lock()
+	defer c.sendQueueLock.Unlock()
+	for i, item := range c.scheduled {
+		if item.Event.Unsigned.TransactionID == txnID {
+			c.scheduled = append(c.scheduled[:i], c.scheduled[i+1:]...)
+			c.saveScheduled()
+			return true
+		}
+	}
+	return false
+}
+
+// ScheduledEvents returns the events waiting to be sent later in the given room, soonest first.
+func (c *Container) ScheduledEvents(roomID id.RoomID) []ifc.ScheduledEvent {
+	c.sendQueueLock.Lock()
+	defer c.sendQueueLock.Unlock()
+	var events []ifc.ScheduledEvent
+	for _, item := range c.scheduled {
+		if item.Event.RoomID != roomID {
+			continue
+		}
+		scheduled := ifc.ScheduledEvent{
+			TransactionID: item.Event.Unsigned.TransactionID,
+			SendAt:        item.SendAt,
+		}
+		if content, ok := item.Event.Content.Parsed.(*event.MessageEventContent); ok {
+			scheduled.Body = content.Body
+		}
+		events = append(events, scheduled)
+	}
+	return events
+}
+
+// saveScheduled writes the scheduled events to disk. The caller must hold sendQueueLock.
+func (c *Container) saveScheduled() {
+	if !c.scheduledLoaded {
+		// loadScheduled saves the events scheduled before it ran along with the loaded ones.
+		return
+	}
+	if err := c.saveCacheJSON(c.scheduledPath(), c.scheduled, len(c.scheduled) == 0); err != nil {
+		debug.Print("Failed to save scheduled events:", err)
+	}
+}
+
+// loadScheduled loads the scheduled events from disk and merges them with the events
+// that were scheduled before the loader ran, e.g. during the undo send period.
+func (c *Container) loadScheduled() {
+	c.sendQueueLock.Lock()
+	defer c.sendQueueLock.Unlock()
+	var scheduled []*scheduledEvent
+	err := c.loadCacheJSON(c.scheduledPath(), &scheduled)
+	c.scheduledLoaded = true
+	if err != nil {
+		debug.Print("Failed to load scheduled events:", err)
+		return
+	}
+	inMemory := len(c.scheduled)
+	loaded := 0
+	for _, item := range scheduled {
+		if err = parseQueuedEvent(item.Event); err != nil {
+			debug.Printf("Failed to parse scheduled event %s: %v", item.Event.Unsigned.TransactionID, err)
+			continue
+		} else if c.isScheduled(item.Event.Unsigned.TransactionID) {
+			continue
+		}
+		c.scheduled = append(c.scheduled, item)
+		loaded++
+	}
+	sort.SliceStable(c.scheduled, func(i, j int) bool {
+		return c.scheduled[i].SendAt.Before(c.scheduled[j].SendAt)
+	})
+	if inMemory > 0 {
+		c.saveScheduled()
+	}
+	debug.Printf("Loaded %d scheduled events", loaded)
+}
+
+// isScheduled returns whether an event with the given transaction ID is scheduled.
+// The caller must hold sendQueueLock.
+func (c *Container) isScheduled(txnID string) bool {
+	for _, item := range c.scheduled {
+		if item.Event.Unsigned.TransactionID == txnID {
+			return true
+		}
+	}
+	return false
+}
+
+// processScheduled moves the scheduled events whose time has come into the send queue
+// and returns how long to wait until the next one.
+func (c *Container) processScheduled() time.Duration {
+	now := time.Now()
+	var due []*scheduledEvent
+	c.sendQueueLock.Lock()
+	for len(c.scheduled) > 0 && !c.scheduled[0].SendAt.After(now) {
+		item := c.scheduled[0]
+		c.scheduled = c.scheduled[1:]
+		item.Event.Timestamp = now.UnixNano() / int64(time.Millisecond)
+		c.sendQueue[item.Event.RoomID] = append(c.sendQueue[item.Event.RoomID], &queuedEvent{Event: item.Event})
+		due = append(due, item)
+	}
+	wait := sendQueueMaxWait
+	if len(c.scheduled) > 0 {
+		wait = time.Until(c.scheduled[0].SendAt)
+	}
+	if len(due) > 0 {
+		c.saveScheduled()
+		c.saveSendQueue()
+	}
+	c.sendQueueLock.Unlock()
+	for _, item := range due {
+		if !item.Echoed {
+			c.showLocalEcho(item.Event)
+		}
+	}
+	return wait
+}
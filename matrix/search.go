@@ -0,0 +1,134 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"net/url"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	ifc "maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/matrix/muksevt"
+)
+
+// The number of messages to include before and after each search result.
+const searchContextSize = 1
+
+type reqSearch struct {
+	SearchCategories struct {
+		RoomEvents reqSearchRoomEvents `json:"room_events"`
+	} `json:"search_categories"`
+}
+
+type reqSearchRoomEvents struct {
+	SearchTerm   string `json:"search_term"`
+	OrderBy      string `json:"order_by,omitempty"`
+	EventContext struct {
+		BeforeLimit int `json:"before_limit"`
+		AfterLimit  int `json:"after_limit"`
+	} `json:"event_context"`
+	Filter struct {
+		Rooms []id.RoomID `json:"rooms,omitempty"`
+	} `json:"filter"`
+}
+
+type respSearch struct {
+	SearchCategories struct {
+		RoomEvents struct {
+			Count     int    `json:"count"`
+			NextBatch string `json:"next_batch"`
+			Results   []struct {
+				Result  *event.Event `json:"result"`
+				Context struct {
+					EventsBefore []*event.Event `json:"events_before"`
+					EventsAfter  []*event.Event `json:"events_after"`
+				} `json:"context"`
+			} `json:"results"`
+		} `json:"room_events"`
+	} `json:"search_categories"`
+}
+
+// Search searches for messages containing the given term using the server-side search API.
+// If roomID is set, only that room is searched. Messages in encrypted rooms can't be searched
+// this way, as the server can't read them.
+func (c *Container) Search(term string, roomID id.RoomID, nextBatch string) (*ifc.SearchResults, error) {
+	var req reqSearch
+	roomEvents := &req.SearchCategories.RoomEvents
+	roomEvents.SearchTerm = term
+	roomEvents.OrderBy = "recent"
+	roomEvents.EventContext.BeforeLimit = searchContextSize
+	roomEvents.EventContext.AfterLimit = searchContextSize
+	if len(roomID) > 0 {
+		roomEvents.Filter.Rooms = []id.RoomID{roomID}
+	}
+
+	u := c.client.BuildURL("search")
+	if len(nextBatch) > 0 {
+		u += "?" + url.Values{"next_batch": {nextBatch}}.Encode()
+	}
+	var resp respSearch
+	_, err := c.client.MakeRequest("POST", u, &req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	respEvents := resp.SearchCategories.RoomEvents
+	results := &ifc.SearchResults{
+		Count:     respEvents.Count,
+		NextBatch: respEvents.NextBatch,
+	}
+	for _, item := range respEvents.Results {
+		evt := c.parseSearchEvent(item.Result)
+		if evt == nil {
+			continue
+		}
+		results.Results = append(results.Results, ifc.SearchResult{
+			Event:  evt,
+			Before: c.parseSearchEvents(item.Context.EventsBefore),
+			After:  c.parseSearchEvents(item.Context.EventsAfter),
+		})
+	}
+	return results, nil
+}
+
+func (c *Container) parseSearchEvents(evts []*event.Event) []*muksevt.Event {
+	parsed := make([]*muksevt.Event, 0, len(evts))
+	for _, evt := range evts {
+		if wrapped := c.parseSearchEvent(evt); wrapped != nil {
+			parsed = append(parsed, wrapped)
+		}
+	}
+	return parsed
+}
+
+func (c *Container) parseSearchEvent(evt *event.Event) *muksevt.Event {
+	if evt == nil {
+		return nil
+	}
+	evt.Type.Class = event.MessageEventType
+	if evt.StateKey != nil {
+		evt.Type.Class = event.StateEventType
+	}
+	err := evt.Content.ParseRaw(evt.Type)
+	if err != nil {
+		debug.Printf("Failed to parse search result %s: %v", evt.ID, err)
+		return nil
+	}
+	return muksevt.Wrap(evt)
+}
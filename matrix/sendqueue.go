@@ -0,0 +1,418 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	ifc "maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/lib/ratelimit"
+	"maunium.net/go/gomuks/matrix/muksevt"
+)
+
+const (
+	sendQueueMinBackoff = 2 * time.Second
+	sendQueueMaxBackoff = 5 * time.Minute
+	// sendQueueMaxAttempts is the number of attempts after which a queued event is marked as failed.
+	sendQueueMaxAttempts = 10
+	// sendQueueMaxWait is the longest the send queue worker sleeps before checking whether it should stop.
+	sendQueueMaxWait = 30 * time.Second
+)
+
+// queuedEvent is an outgoing event that couldn't be sent yet. The event is stored as it was before
+// encryption, so that it can be shown as a local echo after a restart.
+type queuedEvent struct {
+	Event    *event.Event `json:"event"`
+	Attempts int          `json:"attempts"`
+	NextTry  time.Time    `json:"next_try"`
+	LastErr  string       `json:"last_error,omitempty"`
+}
+
+func (c *Container) sendQueuePath() string {
+	return filepath.Join(c.config.CacheDir, "send-queue.json")
+}
+
+// isRetryableSendError returns true if sending failed because of a network error, a server error (5xx)
+// or rate limiting (429), as opposed to the server rejecting the event.
+func isRetryableSendError(err error) bool {
+	var httpErr mautrix.HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	} else if httpErr.Response == nil {
+		// The request couldn't be sent or no response was received. Errors from before
+		// the request was created (e.g. failing to marshal the content) won't go away on retry.
+		return httpErr.Request != nil
+	} else if httpErr.WrappedError != nil {
+		// The response body couldn't be read.
+		return true
+	}
+	status := httpErr.Response.StatusCode
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// sendRetryDelay returns how long to wait before retrying after the given number of failed attempts.
+// If the server is rate limiting and said how long to wait, its delay is used instead.
+func sendRetryDelay(err error, attempts int) time.Duration {
+	if delay, limited := ratelimit.Delay(err); limited {
+		return delay
+	}
+	return sendQueueBackoff(attempts)
+}
+
+func sendQueueBackoff(attempts int) time.Duration {
+	backoff := sendQueueMinBackoff
+	for i := 1; i < attempts && backoff < sendQueueMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > sendQueueMaxBackoff {
+		backoff = sendQueueMaxBackoff
+	}
+	return backoff
+}
+
+// QueuedEvents returns the number of events waiting to be sent to the given room.
+func (c *Container) QueuedEvents(roomID id.RoomID) int {
+	c.sendQueueLock.Lock()
+	defer c.sendQueueLock.Unlock()
+	return len(c.sendQueue[roomID])
+}
+
+// QueuedEventList returns the events waiting to be sent to the given room, oldest first.
+func (c *Container) QueuedEventList(roomID id.RoomID) []ifc.QueuedEvent {
+	c.sendQueueLock.Lock()
+	defer c.sendQueueLock.Unlock()
+	events := make([]ifc.QueuedEvent, 0, len(c.sendQueue[roomID]))
+	for _, item := range c.sendQueue[roomID] {
+		queued := ifc.QueuedEvent{
+			TransactionID: item.Event.Unsigned.TransactionID,
+			Attempts:      item.Attempts,
+			LastError:     item.LastErr,
+		}
+		if content, ok := item.Event.Content.Parsed.(*event.MessageEventContent); ok {
+			queued.Body = content.Body
+		}
+		events = append(events, queued)
+	}
+	return events
+}
+
+// DiscardQueuedEvent removes the queued event with the given transaction ID without sending it.
+// It returns false if there is no such event, e.g. because it was already sent.
+func (c *Container) DiscardQueuedEvent(txnID string) bool {
+	c.sendQueueLock.Lock()
+	var discarded *queuedEvent
+	for roomID, roomQueue := range c.sendQueue {
+		for _, item := range roomQueue {
+			if item.Event.Unsigned.TransactionID == txnID {
+				c.removeQueued(roomID, item)
+				c.saveSendQueue()
+				discarded = item
+				break
+			}
+		}
+	}
+	c.sendQueueLock.Unlock()
+	if discarded == nil {
+		return false
+	}
+	c.logSent(ifc.SentEvent{
+		TransactionID: txnID,
+		RoomID:        discarded.Event.RoomID,
+		Type:          discarded.Event.Type,
+		Status:        "discarded",
+	})
+	// Let the worker move on to the next event in the room if the discarded one was being retried.
+	c.wakeSendQueue()
+	return true
+}
+
+// removeQueued removes the given item from the send queue of the room. The caller must hold sendQueueLock.
+// It returns false if the item isn't in the queue anymore.
+func (c *Container) removeQueued(roomID id.RoomID, item *queuedEvent) bool {
+	for i, existing := range c.sendQueue[roomID] {
+		if existing == item {
+			c.sendQueue[roomID] = append(c.sendQueue[roomID][:i], c.sendQueue[roomID][i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// queueIfBusy queues the event if there are older events in the same room that haven't been sent yet,
+// so that messages are always sent in the order they were written.
+func (c *Container) queueIfBusy(evt *event.Event) bool {
+	c.sendQueueLock.Lock()
+	defer c.sendQueueLock.Unlock()
+	if len(c.sendQueue[evt.RoomID]) == 0 {
+		return false
+	}
+	c.sendQueue[evt.RoomID] = append(c.sendQueue[evt.RoomID], &queuedEvent{Event: evt})
+	c.saveSendQueue()
+	return true
+}
+
+func (c *Container) enqueue(evt *event.Event, err error) {
+	c.sendQueueLock.Lock()
+	if c.sendQueue == nil {
+		c.sendQueue = make(map[id.RoomID][]*queuedEvent)
+	}
+	c.sendQueue[evt.RoomID] = append(c.sendQueue[evt.RoomID], &queuedEvent{
+		Event:    evt,
+		Attempts: 1,
+		NextTry:  time.Now().Add(sendRetryDelay(err, 1)),
+		LastErr:  err.Error(),
+	})
+	c.saveSendQueue()
+	c.sendQueueLock.Unlock()
+	c.wakeSendQueue()
+}
+
+func (c *Container) wakeSendQueue() {
+	select {
+	case c.sendQueueWake <- struct{}{}:
+	default:
+	}
+}
+
+// saveSendQueue writes the send queue to disk. The caller must hold sendQueueLock.
+func (c *Container) saveSendQueue() {
+	if !c.sendQueueLoaded {
+		// loadSendQueue saves the events queued before it ran along with the loaded ones.
+		return
+	}
+	var queue []*queuedEvent
+	for _, roomQueue := range c.sendQueue {
+		queue = append(queue, roomQueue...)
+	}
+	if err := c.saveCacheJSON(c.sendQueuePath(), queue, len(queue) == 0); err != nil {
+		debug.Print("Failed to save send queue:", err)
+	}
+}
+
+// saveCacheJSON writes the given value as JSON into the cache directory, encrypted with the cache key if
+// cache encryption is enabled. If remove is true, the file is deleted instead.
+func (c *Container) saveCacheJSON(path string, value interface{}, remove bool) error {
+	if remove {
+		_ = os.Remove(path)
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err == nil {
+		data, err = c.config.CacheCipher.Encrypt(data)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(path, data, 0600)
+	}
+	return err
+}
+
+// loadCacheJSON reads a file written with saveCacheJSON. If the file doesn't exist, target isn't changed.
+func (c *Container) loadCacheJSON(path string, target interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err == nil {
+		data, err = c.config.CacheCipher.Decrypt(data)
+	}
+	if err == nil {
+		err = json.Unmarshal(data, target)
+	}
+	return err
+}
+
+// loadSendQueue loads the send queue from disk and puts the loaded events in front of the events that
+// were queued before the loader ran, as they're older. It returns the events that were loaded.
+func (c *Container) loadSendQueue() []*queuedEvent {
+	c.sendQueueLock.Lock()
+	defer c.sendQueueLock.Unlock()
+	if c.sendQueue == nil {
+		c.sendQueue = make(map[id.RoomID][]*queuedEvent)
+	}
+	var queue []*queuedEvent
+	err := c.loadCacheJSON(c.sendQueuePath(), &queue)
+	c.sendQueueLoaded = true
+	if err != nil {
+		debug.Print("Failed to load send queue:", err)
+		return nil
+	}
+	inMemory := make(map[string]struct{})
+	for _, roomQueue := range c.sendQueue {
+		for _, item := range roomQueue {
+			inMemory[item.Event.Unsigned.TransactionID] = struct{}{}
+		}
+	}
+	var loaded []*queuedEvent
+	loadedByRoom := make(map[id.RoomID][]*queuedEvent)
+	for _, item := range queue {
+		if err = parseQueuedEvent(item.Event); err != nil {
+			debug.Printf("Failed to parse queued event %s: %v", item.Event.Unsigned.TransactionID, err)
+			continue
+		} else if _, ok := inMemory[item.Event.Unsigned.TransactionID]; ok {
+			continue
+		}
+		// Retry immediately after a restart.
+		item.NextTry = time.Time{}
+		loadedByRoom[item.Event.RoomID] = append(loadedByRoom[item.Event.RoomID], item)
+		loaded = append(loaded, item)
+	}
+	for roomID, roomQueue := range loadedByRoom {
+		c.sendQueue[roomID] = append(roomQueue, c.sendQueue[roomID]...)
+	}
+	if len(inMemory) > 0 {
+		c.saveSendQueue()
+	}
+	debug.Printf("Loaded %d queued events", len(loaded))
+	return loaded
+}
+
+// parseQueuedEvent parses the content of an outgoing event that was loaded from disk.
+func parseQueuedEvent(evt *event.Event) error {
+	evt.Type.Class = event.MessageEventType
+	return evt.Content.ParseRaw(evt.Type)
+}
+
+// showQueuedEvents adds local echoes of the queued events loaded from disk to the UI after a restart.
+func (c *Container) showQueuedEvents(queue []*queuedEvent) {
+	for _, item := range queue {
+		c.showLocalEcho(item.Event)
+	}
+}
+
+// showLocalEcho adds a local echo of an outgoing event to its room.
+func (c *Container) showLocalEcho(evt *event.Event) {
+	roomView := c.ui.MainView().GetRoom(evt.RoomID)
+	if roomView == nil {
+		return
+	}
+	evtCopy := *evt
+	localEcho := muksevt.Wrap(&evtCopy)
+	localEcho.Gomuks.OutgoingState = muksevt.StateLocalEcho
+	roomView.AddEvent(localEcho)
+}
+
+// runSendQueue retries sending queued events until the container is stopped.
+func (c *Container) runSendQueue() {
+	defer debug.Recover()
+	loaded := c.loadSendQueue()
+	c.loadScheduled()
+	c.showQueuedEvents(loaded)
+	for c.running {
+		wait := c.processSendQueue()
+		if wait > sendQueueMaxWait {
+			wait = sendQueueMaxWait
+		}
+		select {
+		case <-c.sendQueueWake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// processSendQueue sends the queued events whose retry time has passed, oldest first in each room,
+// and returns how long to wait until the next retry or scheduled event.
+func (c *Container) processSendQueue() time.Duration {
+	wait := c.processScheduled()
+	c.sendQueueLock.Lock()
+	roomIDs := make([]id.RoomID, 0, len(c.sendQueue))
+	for roomID := range c.sendQueue {
+		roomIDs = append(roomIDs, roomID)
+	}
+	c.sendQueueLock.Unlock()
+	for _, roomID := range roomIDs {
+		if roomWait := c.processRoomSendQueue(roomID); roomWait < wait {
+			wait = roomWait
+		}
+	}
+	return wait
+}
+
+func (c *Container) processRoomSendQueue(roomID id.RoomID) time.Duration {
+	for c.running {
+		c.sendQueueLock.Lock()
+		if len(c.sendQueue[roomID]) == 0 {
+			delete(c.sendQueue, roomID)
+			c.sendQueueLock.Unlock()
+			return sendQueueMaxWait
+		}
+		head := c.sendQueue[roomID][0]
+		if wait := time.Until(head.NextTry); wait > 0 {
+			c.sendQueueLock.Unlock()
+			return wait
+		}
+		c.sendQueueLock.Unlock()
+
+		evtCopy := *head.Event
+		eventID, err := c.sendEvent(muksevt.Wrap(&evtCopy))
+		c.noteSendResult(roomID, err)
+		entry := ifc.SentEvent{
+			TransactionID: head.Event.Unsigned.TransactionID,
+			EventID:       eventID,
+			RoomID:        roomID,
+			Type:          head.Event.Type,
+			Status:        "sent",
+		}
+
+		c.sendQueueLock.Lock()
+		// The event may have been discarded while it was being sent.
+		queued := c.removeQueued(roomID, head)
+		if queued && err != nil && isRetryableSendError(err) && head.Attempts+1 < sendQueueMaxAttempts {
+			head.Attempts++
+			head.NextTry = time.Now().Add(sendRetryDelay(err, head.Attempts))
+			head.LastErr = err.Error()
+			c.sendQueue[roomID] = append([]*queuedEvent{head}, c.sendQueue[roomID]...)
+			c.saveSendQueue()
+			c.sendQueueLock.Unlock()
+			debug.Printf("Failed to send queued event %s (attempt %d): %v", entry.TransactionID, head.Attempts, err)
+			if roomView := c.ui.MainView().GetRoom(roomID); roomView != nil && c.RemoteServerUnreachable(roomID) {
+				roomView.QueuedEventDelayed(entry.TransactionID)
+				c.ui.Render()
+			}
+			return time.Until(head.NextTry)
+		}
+		if queued {
+			c.saveSendQueue()
+		}
+		c.sendQueueLock.Unlock()
+		if !queued && err != nil {
+			continue
+		} else if err != nil && isRetryableSendError(err) {
+			debug.Printf("Giving up on queued event %s after %d attempts: %v", entry.TransactionID, head.Attempts+1, err)
+		}
+
+		if err != nil {
+			entry.Status = "failed"
+			entry.Error = err.Error()
+		}
+		c.logSent(entry)
+		if roomView := c.ui.MainView().GetRoom(roomID); roomView != nil {
+			roomView.QueuedEventDone(entry.TransactionID, eventID, err)
+			c.ui.Render()
+		}
+	}
+	return sendQueueMaxWait
+}
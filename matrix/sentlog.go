@@ -0,0 +1,79 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"encoding/json"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	ifc "maunium.net/go/gomuks/interface"
+)
+
+const (
+	// SentLogMaxAge is how long entries are kept in the sent event log.
+	SentLogMaxAge = 30 * 24 * time.Hour
+	// SentLogMaxEntries is the maximum number of lines kept in the sent event log.
+	SentLogMaxEntries = 5000
+)
+
+// logSent appends a status change of an outgoing event to the sent event log.
+func (c *Container) logSent(entry ifc.SentEvent) {
+	entry.Time = time.Now()
+	c.sentLog.Append(entry)
+}
+
+// SentEvents returns the latest status of the most recent events sent to the given room,
+// or to any room if the room ID is empty. The newest event is last.
+func (c *Container) SentEvents(roomID id.RoomID, limit int) ([]ifc.SentEvent, error) {
+	lines, err := c.sentLog.Read()
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[string]int)
+	var collapsed []ifc.SentEvent
+	for _, line := range lines {
+		// A single transaction usually has multiple lines, as a new line is written every time its status changes.
+		var entry ifc.SentEvent
+		if json.Unmarshal(line, &entry) != nil {
+			continue
+		}
+		if len(roomID) > 0 && entry.RoomID != roomID {
+			continue
+		}
+		if index, ok := latest[entry.TransactionID]; ok {
+			collapsed[index].Status = entry.Status
+			collapsed[index].Error = entry.Error
+			if len(entry.EventID) > 0 {
+				collapsed[index].EventID = entry.EventID
+			}
+		} else {
+			latest[entry.TransactionID] = len(collapsed)
+			collapsed = append(collapsed, entry)
+		}
+	}
+	if limit > 0 && len(collapsed) > limit {
+		collapsed = collapsed[len(collapsed)-limit:]
+	}
+	return collapsed, nil
+}
+
+// ClearSentEvents deletes the sent event log.
+func (c *Container) ClearSentEvents() error {
+	return c.sentLog.Clear()
+}
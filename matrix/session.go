@@ -0,0 +1,224 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"maunium.net/go/mautrix/crypto/utils"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/config"
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/lib/cachecrypt"
+)
+
+const sessionBundleVersion = 1
+const sessionBundleIterations = 200000
+
+// Imported bundles must use an iteration count in this range, so that a crafted bundle can't make the key
+// derivation take forever or use a trivially weak key.
+const sessionBundleMinIterations = 10000
+const sessionBundleMaxIterations = 10 * sessionBundleIterations
+
+var ErrInvalidSessionBundle = errors.New("not a gomuks session bundle")
+var ErrIncorrectSessionPassphrase = errors.New("incorrect passphrase or corrupted session bundle")
+
+// sessionBundleEnvelope is the outer, unencrypted part of an exported session.
+type sessionBundleEnvelope struct {
+	Version    int    `json:"gomuks_session_version"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Data       []byte `json:"data"`
+}
+
+// sessionBundle contains everything needed to continue a session on another machine.
+// The sync token and room cache are intentionally left out, the importing client does a fresh initial sync.
+type sessionBundle struct {
+	Homeserver  string                 `json:"homeserver"`
+	UserID      id.UserID              `json:"user_id"`
+	DeviceID    id.DeviceID            `json:"device_id"`
+	AccessToken string                 `json:"access_token"`
+	Preferences config.UserPreferences `json:"preferences"`
+	CryptoStore []byte                 `json:"crypto_store,omitempty"`
+}
+
+func (c *Container) cryptoStorePath() string {
+	return filepath.Join(c.config.DataDir, "crypto.gob")
+}
+
+func sessionBundleCipher(passphrase string, salt []byte, iterations int) (*cachecrypt.Cipher, error) {
+	key := utils.PBKDF2SHA512([]byte(passphrase), salt, iterations, cachecrypt.KeySize*8)
+	return cachecrypt.New(key)
+}
+
+// ExportSession writes the current session, including the end-to-end encryption store, into a file
+// encrypted with the given passphrase, and then removes the session from this machine without logging
+// out, as the same olm account must not be used from two places at once. Syncing is stopped before
+// exporting, so that the encryption state doesn't change after it has been exported, and started
+// again if the export fails.
+func (c *Container) ExportSession(path, passphrase string) error {
+	if len(c.config.AccessToken) == 0 {
+		return errors.New("not logged in")
+	}
+	wasRunning := c.running
+	c.Stop()
+	data, err := c.exportSession(passphrase)
+	if err == nil {
+		err = writeFileAtomic(path, data, 0600)
+	}
+	if err != nil {
+		if !wasRunning {
+			return err
+		} else if resumeErr := c.resumeAfterStop(); resumeErr != nil {
+			debug.Print("Failed to resume syncing after failed session export:", resumeErr)
+		}
+		return err
+	}
+	debug.Printf("Exported session to %s, removing it from this machine", path)
+	c.config.DeleteSession()
+	c.client = nil
+	c.crypto = nil
+	c.ui.OnLogout()
+	return nil
+}
+
+// resumeAfterStop undoes Stop, e.g. when exporting the session failed.
+func (c *Container) resumeAfterStop() error {
+	if c.history == nil {
+		history, err := NewHistoryManager(c.config.HistoryPath, c.config.CacheCipher)
+		if err != nil {
+			return fmt.Errorf("failed to initialize history: %w", err)
+		}
+		c.history = history
+	}
+	select {
+	case <-c.stop:
+		// The sync loop hadn't noticed the stop request yet, so it just keeps running.
+		return nil
+	default:
+	}
+	go c.Start()
+	return nil
+}
+
+// writeFileAtomic writes the data into a temporary file next to the given path and then renames it into place,
+// so that an existing file isn't left half-overwritten if writing fails.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpPath := path + ".tmp"
+	err := ioutil.WriteFile(tmpPath, data, perm)
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+	}
+	return err
+}
+
+// exportSession serializes the current session into a bundle encrypted with the given passphrase.
+func (c *Container) exportSession(passphrase string) ([]byte, error) {
+	bundle := sessionBundle{
+		Homeserver:  c.config.HS,
+		UserID:      c.config.UserID,
+		DeviceID:    c.config.DeviceID,
+		AccessToken: c.config.AccessToken,
+		Preferences: c.config.Preferences,
+	}
+	if c.crypto != nil {
+		if err := c.crypto.FlushStore(); err != nil {
+			return nil, fmt.Errorf("failed to flush crypto store: %w", err)
+		}
+	}
+	var err error
+	bundle.CryptoStore, err = ioutil.ReadFile(c.cryptoStorePath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read crypto store: %w", err)
+	}
+	data, err := json.Marshal(&bundle)
+	if err != nil {
+		return nil, err
+	}
+	envelope := sessionBundleEnvelope{
+		Version:    sessionBundleVersion,
+		Iterations: sessionBundleIterations,
+		Salt:       make([]byte, 16),
+	}
+	if _, err = rand.Read(envelope.Salt); err != nil {
+		return nil, err
+	}
+	cipher, err := sessionBundleCipher(passphrase, envelope.Salt, envelope.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	envelope.Data, err = cipher.Encrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&envelope)
+}
+
+// ImportSession replaces the current session with one from a bundle created by ExportSession.
+// It must be called before InitClient, as the crypto store file is overwritten.
+func (c *Container) ImportSession(data []byte, passphrase string) error {
+	var envelope sessionBundleEnvelope
+	err := json.Unmarshal(data, &envelope)
+	if err != nil || envelope.Version == 0 || len(envelope.Data) == 0 {
+		return ErrInvalidSessionBundle
+	} else if envelope.Version > sessionBundleVersion {
+		return fmt.Errorf("unsupported session bundle version %d", envelope.Version)
+	} else if envelope.Iterations < sessionBundleMinIterations || envelope.Iterations > sessionBundleMaxIterations {
+		return fmt.Errorf("%w: unsupported iteration count %d", ErrInvalidSessionBundle, envelope.Iterations)
+	}
+	cipher, err := sessionBundleCipher(passphrase, envelope.Salt, envelope.Iterations)
+	if err != nil {
+		return err
+	}
+	decrypted, err := cipher.Decrypt(envelope.Data)
+	if err != nil {
+		return ErrIncorrectSessionPassphrase
+	}
+	var bundle sessionBundle
+	if err = json.Unmarshal(decrypted, &bundle); err != nil {
+		return ErrIncorrectSessionPassphrase
+	} else if len(bundle.AccessToken) == 0 || len(bundle.UserID) == 0 {
+		return ErrInvalidSessionBundle
+	}
+
+	debug.Printf("Importing session of %s (device %s)", bundle.UserID, bundle.DeviceID)
+	c.config.DeleteSession()
+	if len(bundle.CryptoStore) > 0 {
+		err = ioutil.WriteFile(c.cryptoStorePath(), bundle.CryptoStore, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to write crypto store: %w", err)
+		}
+	}
+	c.config.HS = bundle.Homeserver
+	c.config.UserID = bundle.UserID
+	c.config.DeviceID = bundle.DeviceID
+	c.config.AccessToken = bundle.AccessToken
+	c.config.Preferences = bundle.Preferences
+	c.config.Save()
+	c.config.SavePreferences()
+	return nil
+}
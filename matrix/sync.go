@@ -20,6 +20,7 @@ package matrix
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"maunium.net/go/mautrix"
@@ -29,6 +30,7 @@ import (
 	ifc "maunium.net/go/gomuks/interface"
 
 	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
 	"maunium.net/go/gomuks/matrix/rooms"
 )
 
@@ -39,10 +41,26 @@ type GomuksSyncer struct {
 	rooms             *rooms.RoomCache
 	globalListeners   []SyncHandler
 	listeners         map[event.Type][]EventHandler // event type to listeners array
+	dispatcher        *listenerDispatcher
 	FirstSyncDone     bool
 	InitDoneCallback  func()
 	FirstDoneCallback func()
 	Progress          ifc.SyncingModal
+
+	// Called before an initial sync response is processed and after each room in it has been processed.
+	InitialSyncStarted  func(res *mautrix.RespSync)
+	InitialSyncRoomDone func(roomID id.RoomID)
+	// Called once the to-device events and global account data of an initial sync response have been handled.
+	InitialSyncGlobalDone func()
+	// Rooms to skip when processing an initial sync response, because they were processed before a restart.
+	SkipRooms map[id.RoomID]struct{}
+	// Returns true if events of the given type should be dropped without parsing or passing them to listeners.
+	BlockEvent func(roomID id.RoomID, evtType event.Type) bool
+
+	// Unix nanoseconds of when a sync request last finished, successfully or not.
+	lastActivity int64
+	// Non-zero while a sync response is being processed.
+	processing int32
 }
 
 // NewGomuksSyncer returns an instantiated GomuksSyncer
@@ -51,17 +69,43 @@ func NewGomuksSyncer(rooms *rooms.RoomCache) *GomuksSyncer {
 		rooms:           rooms,
 		globalListeners: []SyncHandler{},
 		listeners:       make(map[event.Type][]EventHandler),
+		dispatcher:      newListenerDispatcher(),
 		FirstSyncDone:   false,
 		Progress:        StubSyncingModal{},
+		lastActivity:    time.Now().UnixNano(),
 	}
 }
 
+func (s *GomuksSyncer) markActivity() {
+	atomic.StoreInt64(&s.lastActivity, time.Now().UnixNano())
+}
+
+// LastActivity returns when a sync request last finished, successfully or not.
+func (s *GomuksSyncer) LastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.lastActivity))
+}
+
+// IsProcessing returns whether a sync response is currently being processed.
+func (s *GomuksSyncer) IsProcessing() bool {
+	return atomic.LoadInt32(&s.processing) != 0
+}
+
 // ProcessResponse processes a Matrix sync response.
 func (s *GomuksSyncer) ProcessResponse(res *mautrix.RespSync, since string) (err error) {
+	s.markActivity()
+	atomic.StoreInt32(&s.processing, 1)
+	defer func() {
+		atomic.StoreInt32(&s.processing, 0)
+		s.markActivity()
+	}()
 	if since == "" {
 		s.rooms.DisableUnloading()
+		if s.InitialSyncStarted != nil {
+			s.InitialSyncStarted(res)
+		}
 	}
 	debug.Print("Received sync response")
+	prevStats := s.dispatcher.Stats()
 	s.Progress.SetMessage("Processing sync response")
 	steps := len(res.Rooms.Join) + len(res.Rooms.Invite) + len(res.Rooms.Leave)
 	s.Progress.SetSteps(steps + 2 + len(s.globalListeners))
@@ -79,23 +123,57 @@ func (s *GomuksSyncer) ProcessResponse(res *mautrix.RespSync, since string) (err
 	s.Progress.Step()
 	s.processSyncEvents(nil, res.AccountData.Events, mautrix.EventSourceAccountData)
 	s.Progress.Step()
+	if since == "" && s.InitialSyncGlobalDone != nil {
+		// The global listeners have handled to-device events above, and account data is queued before this.
+		s.dispatcher.Call("", s.InitialSyncGlobalDone)
+	}
 
 	wait.Add(steps)
+	roomCallback := func(roomID id.RoomID) func() {
+		if since != "" || s.InitialSyncRoomDone == nil {
+			return callback
+		}
+		return func() {
+			// Only mark the room as done once its events have been handled by the listeners.
+			s.dispatcher.Call(roomID, func() {
+				s.InitialSyncRoomDone(roomID)
+			})
+			callback()
+		}
+	}
 
 	for roomID, roomData := range res.Rooms.Join {
-		go s.processJoinedRoom(roomID, roomData, callback)
+		if s.skipRoom(roomID, callback) {
+			continue
+		}
+		go s.processJoinedRoom(roomID, roomData, roomCallback(roomID))
 	}
 
 	for roomID, roomData := range res.Rooms.Invite {
-		go s.processInvitedRoom(roomID, roomData, callback)
+		if s.skipRoom(roomID, callback) {
+			continue
+		}
+		go s.processInvitedRoom(roomID, roomData, roomCallback(roomID))
 	}
 
 	for roomID, roomData := range res.Rooms.Leave {
-		go s.processLeftRoom(roomID, roomData, callback)
+		if s.skipRoom(roomID, callback) {
+			continue
+		}
+		go s.processLeftRoom(roomID, roomData, roomCallback(roomID))
 	}
 
 	wait.Wait()
 	s.Progress.SetMessage("Finishing sync")
+	if since == "" || !s.FirstSyncDone {
+		// The initial sync callbacks below expect every event in the response to have been handled.
+		// Later responses don't wait for the listeners, which handle the events of each room in order.
+		s.dispatcher.Flush()
+	}
+	if stats := s.dispatcher.Stats(); stats.Blocked > prevStats.Blocked {
+		debug.Printf("Sync listeners fell behind: dispatching was blocked %d times for %s in total, max queue depth %d",
+			stats.Blocked-prevStats.Blocked, stats.BlockedTime-prevStats.BlockedTime, stats.MaxDepth)
+	}
 
 	if since == "" && s.InitDoneCallback != nil {
 		s.InitDoneCallback()
@@ -117,6 +195,14 @@ func (s *GomuksSyncer) notifyGlobalListeners(res *mautrix.RespSync, since string
 	}
 }
 
+func (s *GomuksSyncer) skipRoom(roomID id.RoomID, callback func()) bool {
+	if _, ok := s.SkipRooms[roomID]; ok {
+		callback()
+		return true
+	}
+	return false
+}
+
 func (s *GomuksSyncer) processJoinedRoom(roomID id.RoomID, roomData mautrix.SyncJoinedRoom, callback func()) {
 	defer debug.Recover()
 	room := s.rooms.GetOrCreate(roomID)
@@ -166,6 +252,9 @@ func (s *GomuksSyncer) processSyncEvent(room *rooms.Room, evt *event.Event, sour
 	if room != nil {
 		evt.RoomID = room.ID
 	}
+	if s.BlockEvent != nil && s.BlockEvent(evt.RoomID, evt.Type) {
+		return
+	}
 	// Ensure the type class is correct. It's safe to mutate since it's not a pointer.
 	// Listeners are keyed by type structs, which means only the correct class will pass.
 	switch {
@@ -213,14 +302,55 @@ func (s *GomuksSyncer) notifyListeners(source mautrix.EventSource, evt *event.Ev
 	if !exists {
 		return
 	}
-	for _, fn := range listeners {
-		fn(source, evt)
-	}
+	s.dispatcher.Dispatch(listeners, source, evt)
+}
+
+// AfterDispatched calls the given function once the listeners have handled all events dispatched so far.
+func (s *GomuksSyncer) AfterDispatched(fn func()) {
+	s.dispatcher.AfterAll(fn)
+}
+
+// dispatchedStore saves the next batch token only once the listeners have handled the events
+// of the sync response, so that queued events aren't skipped if gomuks exits before they're handled.
+type dispatchedStore struct {
+	mautrix.Storer
+	syncer *GomuksSyncer
+
+	lock     sync.Mutex
+	seq      uint64
+	savedSeq uint64
+}
+
+func (store *dispatchedStore) SaveNextBatch(userID id.UserID, nextBatch string) {
+	store.lock.Lock()
+	store.seq++
+	seq := store.seq
+	store.lock.Unlock()
+	store.syncer.AfterDispatched(func() {
+		store.lock.Lock()
+		defer store.lock.Unlock()
+		// The events of a newer response may have been handled first.
+		if seq > store.savedSeq {
+			store.savedSeq = seq
+			store.Storer.SaveNextBatch(userID, nextBatch)
+		}
+	})
+}
+
+// DispatchStats returns the backpressure metrics of the event listener queue.
+func (s *GomuksSyncer) DispatchStats() DispatchStats {
+	return s.dispatcher.Stats()
+}
+
+// Close stops the goroutine that passes events to listeners.
+func (s *GomuksSyncer) Close() {
+	s.dispatcher.Close()
 }
 
 // OnFailedSync always returns a 10 second wait period between failed /syncs, never a fatal error.
 func (s *GomuksSyncer) OnFailedSync(res *mautrix.RespSync, err error) (time.Duration, error) {
 	debug.Printf("Sync failed: %v", err)
+	s.markActivity()
 	return 10 * time.Second, nil
 }
 
@@ -234,6 +364,9 @@ func (s *GomuksSyncer) GetFilterJSON(_ id.UserID) *mautrix.Filter {
 		event.StatePowerLevels,
 		event.StateTombstone,
 		event.StateEncryption,
+		event.StateCreate,
+		muksevt.StatePinnedEvents,
+		muksevt.StateRoomEmotes,
 	}
 	messageEvents := []event.Type{
 		event.EventMessage,
@@ -241,6 +374,9 @@ func (s *GomuksSyncer) GetFilterJSON(_ id.UserID) *mautrix.Filter {
 		event.EventEncrypted,
 		event.EventSticker,
 		event.EventReaction,
+		muksevt.EventPollStart,
+		muksevt.EventPollResponse,
+		muksevt.EventPollEnd,
 	}
 	return &mautrix.Filter{
 		Room: mautrix.RoomFilter{
@@ -262,10 +398,13 @@ func (s *GomuksSyncer) GetFilterJSON(_ id.UserID) *mautrix.Filter {
 			},
 		},
 		AccountData: mautrix.FilterPart{
-			Types: []event.Type{event.AccountDataPushRules, event.AccountDataDirectChats, AccountDataGomuksPreferences},
+			Types: []event.Type{
+				event.AccountDataPushRules, event.AccountDataDirectChats, AccountDataGomuksPreferences,
+				muksevt.AccountDataUserEmotes, muksevt.AccountDataEmoteRooms,
+			},
 		},
 		Presence: mautrix.FilterPart{
-			NotTypes: []event.Type{event.NewEventType("*")},
+			Types: []event.Type{event.EphemeralEventPresence},
 		},
 	}
 }
@@ -0,0 +1,63 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"net/url"
+	"sort"
+
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/matrix/rooms"
+)
+
+// The maximum number of relation pages to fetch when loading the replies in a thread.
+const maxThreadPages = 10
+
+// GetThreadReplies fetches the replies in the thread started by the given event from the server
+// using the relations API. The replies are returned oldest first.
+func (c *Container) GetThreadReplies(room *rooms.Room, rootID id.EventID) ([]*muksevt.Event, error) {
+	var replies []*muksevt.Event
+	var from string
+	for page := 0; page < maxThreadPages; page++ {
+		query := url.Values{"limit": {"50"}}
+		if len(from) > 0 {
+			query.Set("from", from)
+		}
+		u := c.client.BuildBaseURL("_matrix", "client", "unstable", "rooms", room.ID, "relations", rootID, muksevt.RelThread)
+		var resp respRelations
+		_, err := c.client.MakeRequest("GET", u+"?"+query.Encode(), nil, &resp)
+		if err != nil {
+			return nil, err
+		}
+		for _, evt := range resp.Chunk {
+			evt.RoomID = room.ID
+			replies = append(replies, c.parseFetchedEvent(evt))
+		}
+		if len(resp.NextBatch) == 0 || len(resp.Chunk) == 0 {
+			break
+		}
+		from = resp.NextBatch
+	}
+	sort.Slice(replies, func(i, j int) bool {
+		return replies[i].Timestamp < replies[j].Timestamp
+	})
+	debug.Printf("Fetched %d replies in thread %s from server", len(replies), rootID)
+	return replies, nil
+}
@@ -0,0 +1,72 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+// GetThumbnailCachePath returns the path where the server-side thumbnail of the given size is cached.
+func (c *Container) GetThumbnailCachePath(uri id.ContentURI, width, height int) string {
+	path := c.GetCachePath(uri)
+	if len(path) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s.thumb-%dx%d", path, width, height)
+}
+
+// DownloadThumbnail fetches a version of the given unencrypted media scaled to fit in the given size
+// from the media repository, or reads it from the cache if it has been fetched before.
+func (c *Container) DownloadThumbnail(uri id.ContentURI, width, height int) ([]byte, error) {
+	cacheFile := c.GetThumbnailCachePath(uri, width, height)
+	if data, err := ioutil.ReadFile(cacheFile); err == nil {
+		c.touchCachedMedia(cacheFile)
+		return data, nil
+	}
+	query := url.Values{
+		"width":  {strconv.Itoa(width)},
+		"height": {strconv.Itoa(height)},
+		"method": {"scale"},
+	}
+	reqURL := c.client.BuildBaseURL("_matrix", "media", "r0", "thumbnail", uri.Homeserver, uri.FileID) + "?" + query.Encode()
+	resp, err := c.client.Client.Get(reqURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err = ioutil.WriteFile(cacheFile, data, 0600); err != nil {
+		debug.Printf("Failed to cache thumbnail of %s: %v", uri, err)
+	} else {
+		c.cachedMediaWritten(int64(len(data)))
+	}
+	return data, nil
+}
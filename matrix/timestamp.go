@@ -0,0 +1,59 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+type respTimestampToEvent struct {
+	EventID   id.EventID `json:"event_id"`
+	Timestamp int64      `json:"origin_server_ts"`
+}
+
+// TimestampToEvent finds the event closest to the given time in a room using the timestamp_to_event
+// API (MSC3030). If forward is true, the closest event after the time is returned, otherwise the
+// closest event before it.
+func (c *Container) TimestampToEvent(roomID id.RoomID, ts time.Time, forward bool) (id.EventID, error) {
+	dir := "b"
+	if forward {
+		dir = "f"
+	}
+	query := url.Values{
+		"ts":  {strconv.FormatInt(ts.UnixNano()/int64(time.Millisecond), 10)},
+		"dir": {dir},
+	}.Encode()
+	var resp respTimestampToEvent
+	u := c.client.BuildBaseURL("_matrix", "client", "v1", "rooms", roomID, "timestamp_to_event")
+	_, err := c.client.MakeRequest("GET", u+"?"+query, nil, &resp)
+	var httpErr mautrix.HTTPError
+	if errors.As(err, &httpErr) && httpErr.RespError != nil && httpErr.RespError.ErrCode == "M_UNRECOGNIZED" {
+		// Servers that don't support the stable endpoint yet may still have the unstable one.
+		u = c.client.BuildBaseURL("_matrix", "client", "unstable", "org.matrix.msc3030", "rooms", roomID, "timestamp_to_event")
+		_, err = c.client.MakeRequest("GET", u+"?"+query, nil, &resp)
+	}
+	if err != nil {
+		return "", err
+	}
+	return resp.EventID, nil
+}
@@ -0,0 +1,98 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"os/exec"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+
+	"maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/matrix/rooms"
+)
+
+var ErrTranslationNotConfigured = errors.New("no translation command configured")
+
+// runTranslationCommand passes the text to the given command and returns its output.
+func runTranslationCommand(command []string, lang, text string, timeout time.Duration) (string, error) {
+	args := make([]string, len(command))
+	for i, arg := range command {
+		args[i] = strings.ReplaceAll(arg, "{lang}", lang)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errOutput := strings.TrimSpace(stderr.String()); len(errOutput) > 0 {
+			return "", fmt.Errorf("%w: %s", err, errOutput)
+		}
+		return "", err
+	}
+	translated := strings.TrimSpace(stdout.String())
+	if len(translated) == 0 {
+		return "", errors.New("translation command returned nothing")
+	}
+	return translated, nil
+}
+
+func textToHTML(text string) string {
+	return strings.ReplaceAll(html.EscapeString(text), "\n", "<br/>")
+}
+
+// appendOriginal adds the untranslated text to the end of the formatted body.
+func appendOriginal(content *event.MessageEventContent, original string) {
+	if content.Format != event.FormatHTML {
+		content.Format = event.FormatHTML
+		content.FormattedBody = textToHTML(content.Body)
+	}
+	content.FormattedBody += "<blockquote><em>Original:</em> " + textToHTML(original) + "</blockquote>"
+}
+
+// PrepareTranslatedMessage translates the text into the target language of the room and prepares the
+// translation like PrepareMarkdownMessage. The original text is appended to the formatted body if the
+// room is set to include it.
+func (c *Container) PrepareTranslatedMessage(room *rooms.Room, msgtype event.MessageType, text string, rel *ifc.Relation) (*muksevt.Event, error) {
+	command := c.config.Translation.GetCommand(room.ID)
+	if len(command) == 0 {
+		return nil, ErrTranslationNotConfigured
+	}
+	translated, err := runTranslationCommand(command, room.TranslateTo, text, c.config.Translation.GetTimeout())
+	if err != nil {
+		return nil, err
+	}
+	evt := c.PrepareMarkdownMessage(room.ID, msgtype, translated, "", rel)
+	if room.TranslateIncludeOriginal {
+		content := evt.Content.AsMessage()
+		appendOriginal(content, text)
+		if content.NewContent != nil {
+			appendOriginal(content.NewContent, text)
+		}
+	}
+	return evt, nil
+}
@@ -0,0 +1,244 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// +build cgo
+
+package matrix
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+// MinUnwedgeInterval is the minimum time between attempts to unwedge the olm session with a single device.
+const MinUnwedgeInterval = 1 * time.Hour
+
+// unwedgeThreshold is how many distinct megolm events from a device must fail to decrypt with an unknown
+// message index before the olm session with the device is considered wedged. A single olm decryption
+// failure is enough, as that means the olm session is definitely broken.
+const unwedgeThreshold = 3
+
+// maxTrackedFailures limits how many failed event IDs are remembered for each device.
+const maxTrackedFailures = 100
+
+var toDeviceDummy = event.Type{Type: "m.dummy", Class: event.ToDeviceEventType}
+
+type wedgeState struct {
+	// The megolm events that failed to decrypt in a way that suggests a wedged session.
+	failedEvents map[id.EventID]struct{}
+	lastUnwedged time.Time
+}
+
+// isWedgeError checks if a megolm decryption error suggests that the sender's olm session with us is broken,
+// i.e. they think they've shared the session with us, but the keys we have are wrong.
+func isWedgeError(err error) bool {
+	return strings.Contains(err.Error(), "UNKNOWN_MESSAGE_INDEX")
+}
+
+// getWedgeState returns the unwedging state of the device with the given identity key.
+// The caller must hold wedgeLock.
+func (c *Container) getWedgeState(userID id.UserID, senderKey id.SenderKey) *wedgeState {
+	if c.wedged == nil {
+		c.wedged = make(map[string]*wedgeState)
+	}
+	key := fmt.Sprintf("%s/%s", userID, senderKey)
+	state, ok := c.wedged[key]
+	if !ok {
+		state = &wedgeState{failedEvents: make(map[id.EventID]struct{})}
+		c.wedged[key] = state
+	}
+	return state
+}
+
+// startUnwedging marks the device as unwedged now, unless it was already unwedged recently.
+// The caller must hold wedgeLock.
+func (state *wedgeState) startUnwedging() bool {
+	if time.Since(state.lastUnwedged) < MinUnwedgeInterval {
+		return false
+	}
+	state.lastUnwedged = time.Now()
+	state.failedEvents = make(map[id.EventID]struct{})
+	return true
+}
+
+// trackOlmFailure starts unwedging the olm session with the device that sent
+// a to-device event which the crypto module couldn't decrypt.
+func (c *Container) trackOlmFailure(evt *event.Event) {
+	content, ok := evt.Content.Parsed.(*event.EncryptedEventContent)
+	if !ok || content.Algorithm != id.AlgorithmOlmV1 || len(content.SenderKey) == 0 {
+		return
+	}
+	c.wedgeLock.Lock()
+	shouldUnwedge := c.getWedgeState(evt.Sender, content.SenderKey).startUnwedging()
+	c.wedgeLock.Unlock()
+	if shouldUnwedge {
+		go c.unwedgeDevice(evt.Sender, content.DeviceID, content.SenderKey)
+	}
+}
+
+// trackDecryptionFailure counts megolm events from a device that failed to decrypt because our copy of
+// the session is wrong, and starts unwedging the olm session with the device if there are too many.
+// Failures are counted by event ID, as the same event may fail again when it's loaded from history.
+func (c *Container) trackDecryptionFailure(evt *event.Event, err error) {
+	content, ok := evt.Content.Parsed.(*event.EncryptedEventContent)
+	if !ok || content.Algorithm != id.AlgorithmMegolmV1 || len(content.SenderKey) == 0 || !isWedgeError(err) {
+		return
+	}
+	c.wedgeLock.Lock()
+	state := c.getWedgeState(evt.Sender, content.SenderKey)
+	if len(state.failedEvents) < maxTrackedFailures {
+		state.failedEvents[evt.ID] = struct{}{}
+	}
+	shouldUnwedge := len(state.failedEvents) >= unwedgeThreshold && state.startUnwedging()
+	c.wedgeLock.Unlock()
+	if shouldUnwedge {
+		go c.unwedgeDevice(evt.Sender, content.DeviceID, content.SenderKey)
+	}
+}
+
+// unwedgeDevice creates a new olm session with the given device and sends an m.dummy event
+// through it, so that the other side notices the new session and re-shares its room keys.
+// If the device ID isn't known, the device is found by its identity key.
+func (c *Container) unwedgeDevice(userID id.UserID, deviceID id.DeviceID, senderKey id.SenderKey) {
+	defer debug.Recover()
+	mach, ok := c.crypto.(*crypto.OlmMachine)
+	if !ok {
+		return
+	}
+	var device *crypto.DeviceIdentity
+	var err error
+	if len(deviceID) > 0 {
+		device, err = mach.GetOrFetchDevice(userID, deviceID)
+	} else {
+		device, err = findDeviceByKey(mach, userID, senderKey)
+	}
+	if err != nil {
+		debug.Printf("[Crypto/Error] Failed to get device %s/%s to unwedge: %v", userID, senderKey, err)
+		return
+	} else if device.IdentityKey != senderKey {
+		debug.Printf("[Crypto/Warn] Not unwedging %s/%s: identity key doesn't match %s", userID, device.DeviceID, senderKey)
+		return
+	}
+	deviceID = device.DeviceID
+	debug.Printf("[Crypto/Debug] Olm session with %s/%s seems wedged, creating a new one", userID, deviceID)
+	session, err := c.createOlmSession(mach, device)
+	if err != nil {
+		debug.Printf("[Crypto/Error] Failed to create new olm session with %s/%s: %v", userID, deviceID, err)
+		return
+	}
+	err = c.sendOlmDummy(mach, device, session)
+	if err != nil {
+		debug.Printf("[Crypto/Error] Failed to send m.dummy to %s/%s: %v", userID, deviceID, err)
+		return
+	}
+	debug.Printf("[Crypto/Debug] Sent m.dummy to %s/%s through new olm session %s", userID, deviceID, session.ID())
+}
+
+// findDeviceByKey finds the device of the user with the given identity key, fetching the device list if necessary.
+func findDeviceByKey(mach *crypto.OlmMachine, userID id.UserID, senderKey id.SenderKey) (*crypto.DeviceIdentity, error) {
+	devices, err := mach.CryptoStore.GetDevices(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, device := range devices {
+		if device.IdentityKey == senderKey {
+			return device, nil
+		}
+	}
+	for _, device := range mach.LoadDevices(userID) {
+		if device.IdentityKey == senderKey {
+			return device, nil
+		}
+	}
+	return nil, fmt.Errorf("no device with identity key %s", senderKey)
+}
+
+func (c *Container) createOlmSession(mach *crypto.OlmMachine, device *crypto.DeviceIdentity) (*crypto.OlmSession, error) {
+	resp, err := c.client.ClaimKeys(&mautrix.ReqClaimKeys{
+		OneTimeKeys: mautrix.OneTimeKeysRequest{
+			device.UserID: {device.DeviceID: id.KeyAlgorithmSignedCurve25519},
+		},
+		Timeout: 10 * 1000,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim one-time key: %w", err)
+	}
+	var oneTimeKey id.Curve25519
+	for _, otk := range resp.OneTimeKeys[device.UserID][device.DeviceID] {
+		oneTimeKey = otk.Key
+		break
+	}
+	if len(oneTimeKey) == 0 {
+		return nil, fmt.Errorf("device didn't have any one-time keys")
+	}
+	account, err := mach.CryptoStore.GetAccount()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get olm account: %w", err)
+	}
+	olmSession, err := account.Internal.NewOutboundSession(device.IdentityKey, oneTimeKey)
+	if err != nil {
+		return nil, err
+	}
+	session := &crypto.OlmSession{Internal: *olmSession}
+	err = mach.CryptoStore.AddSession(device.IdentityKey, session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store olm session: %w", err)
+	}
+	return session, nil
+}
+
+func (c *Container) sendOlmDummy(mach *crypto.OlmMachine, device *crypto.DeviceIdentity, session *crypto.OlmSession) error {
+	own := mach.OwnIdentity()
+	plaintext, err := json.Marshal(map[string]interface{}{
+		"sender":         c.config.UserID,
+		"sender_device":  c.config.DeviceID,
+		"keys":           map[string]id.Ed25519{"ed25519": own.SigningKey},
+		"recipient":      device.UserID,
+		"recipient_keys": map[string]id.Ed25519{"ed25519": device.SigningKey},
+		"type":           toDeviceDummy.Type,
+		"content":        map[string]interface{}{},
+	})
+	if err != nil {
+		return err
+	}
+	msgType, ciphertext := session.Internal.Encrypt(plaintext)
+	err = mach.CryptoStore.UpdateSession(device.IdentityKey, session)
+	if err != nil {
+		return fmt.Errorf("failed to update olm session: %w", err)
+	}
+	content := &event.EncryptedEventContent{
+		Algorithm: id.AlgorithmOlmV1,
+		SenderKey: own.IdentityKey,
+		OlmCiphertext: event.OlmCiphertexts{
+			device.IdentityKey: {Type: msgType, Body: string(ciphertext)},
+		},
+	}
+	_, err = c.client.SendToDevice(event.ToDeviceEncrypted, &mautrix.ReqSendToDevice{
+		Messages: map[id.UserID]map[id.DeviceID]*event.Content{
+			device.UserID: {device.DeviceID: {Parsed: content}},
+		},
+	})
+	return err
+}
@@ -0,0 +1,51 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"maunium.net/go/gomuks/interface"
+)
+
+const uploadProgressInterval = 250 * time.Millisecond
+
+// progressReader reports how much of the wrapped reader has been read and
+// makes the upload fail when the context is cancelled.
+type progressReader struct {
+	ctx      context.Context
+	reader   io.Reader
+	sent     int64
+	total    int64
+	progress ifc.UploadProgressFunc
+	reported time.Time
+}
+
+func (pr *progressReader) Read(p []byte) (n int, err error) {
+	if err = pr.ctx.Err(); err != nil {
+		return
+	}
+	n, err = pr.reader.Read(p)
+	pr.sent += int64(n)
+	if pr.progress != nil && (err == io.EOF || time.Since(pr.reported) >= uploadProgressInterval) {
+		pr.reported = time.Now()
+		pr.progress(pr.sent, pr.total)
+	}
+	return
+}
@@ -0,0 +1,155 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"net/url"
+	"time"
+
+	sync "github.com/sasha-s/go-deadlock"
+
+	"maunium.net/go/gomuks/interface"
+)
+
+const (
+	// urlPreviewCacheSize is the maximum number of links whose previews are kept in memory.
+	urlPreviewCacheSize = 500
+	// urlPreviewErrorTTL is how long a failure to fetch a preview is remembered before it's tried again.
+	urlPreviewErrorTTL = 30 * time.Minute
+	// urlPreviewConcurrency is the maximum number of preview_url requests made at the same time.
+	urlPreviewConcurrency = 4
+)
+
+// urlPreviewCache remembers link previews and failures to fetch them, so that each URL is only fetched
+// from the homeserver once even if several messages with the same link are rendered at the same time.
+type urlPreviewCache struct {
+	lock     sync.Mutex
+	previews map[string]*urlPreviewEntry
+	slots    chan struct{}
+}
+
+type urlPreviewEntry struct {
+	// Closed when the fetch has finished and the fields below are set.
+	done     chan struct{}
+	preview  *ifc.URLPreview
+	err      error
+	fetched  time.Time
+	lastUsed time.Time
+}
+
+type respPreviewURL struct {
+	Title       string `json:"og:title"`
+	Description string `json:"og:description"`
+	SiteName    string `json:"og:site_name"`
+}
+
+// GetURLPreview fetches the title and description of the given web page using the preview_url endpoint
+// of the homeserver media repository.
+func (c *Container) GetURLPreview(pageURL string) (*ifc.URLPreview, error) {
+	cache := &c.urlPreviews
+	cache.lock.Lock()
+	entry, ok := cache.previews[pageURL]
+	if ok {
+		select {
+		case <-entry.done:
+			if entry.err != nil && time.Since(entry.fetched) > urlPreviewErrorTTL {
+				ok = false
+			}
+		default:
+		}
+	}
+	if ok {
+		entry.lastUsed = time.Now()
+		cache.lock.Unlock()
+		<-entry.done
+		return entry.preview, entry.err
+	}
+	if cache.previews == nil {
+		cache.previews = make(map[string]*urlPreviewEntry)
+		cache.slots = make(chan struct{}, urlPreviewConcurrency)
+	}
+	entry = &urlPreviewEntry{done: make(chan struct{}), lastUsed: time.Now()}
+	cache.previews[pageURL] = entry
+	cache.evictOldest()
+	cache.lock.Unlock()
+
+	cache.slots <- struct{}{}
+	entry.preview, entry.err = c.fetchURLPreview(pageURL)
+	<-cache.slots
+	entry.fetched = time.Now()
+	close(entry.done)
+	return entry.preview, entry.err
+}
+
+// CachedURLPreview returns the preview of the given web page if it has already been fetched successfully.
+func (c *Container) CachedURLPreview(pageURL string) *ifc.URLPreview {
+	cache := &c.urlPreviews
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	entry, ok := cache.previews[pageURL]
+	if !ok {
+		return nil
+	}
+	select {
+	case <-entry.done:
+		entry.lastUsed = time.Now()
+		return entry.preview
+	default:
+		return nil
+	}
+}
+
+// evictOldest removes the least recently used finished previews until the cache fits in urlPreviewCacheSize.
+// The caller must hold the lock.
+func (cache *urlPreviewCache) evictOldest() {
+	for len(cache.previews) > urlPreviewCacheSize {
+		var oldestURL string
+		var oldest *urlPreviewEntry
+		for pageURL, entry := range cache.previews {
+			select {
+			case <-entry.done:
+			default:
+				continue
+			}
+			if oldest == nil || entry.lastUsed.Before(oldest.lastUsed) {
+				oldestURL, oldest = pageURL, entry
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(cache.previews, oldestURL)
+	}
+}
+
+func (c *Container) fetchURLPreview(pageURL string) (*ifc.URLPreview, error) {
+	var resp respPreviewURL
+	reqURL := c.client.BuildBaseURL("_matrix", "media", "r0", "preview_url") + "?" + url.Values{"url": {pageURL}}.Encode()
+	if _, err := c.client.MakeRequest("GET", reqURL, nil, &resp); err != nil {
+		return nil, err
+	}
+	preview := &ifc.URLPreview{
+		URL:         pageURL,
+		Title:       resp.Title,
+		Description: resp.Description,
+		SiteName:    resp.SiteName,
+	}
+	if parsed, err := url.Parse(pageURL); err == nil {
+		preview.Domain = parsed.Hostname()
+	}
+	return preview, nil
+}
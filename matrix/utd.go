@@ -0,0 +1,213 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// +build cgo
+
+package matrix
+
+import (
+	"errors"
+
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+)
+
+// trackUndecryptable remembers an event that failed to decrypt because the room key was missing,
+// so that it can be decrypted and updated in place when the key arrives later. Events are tracked
+// until their room is unloaded, after which they're tracked again when loaded from the local cache.
+func (c *Container) trackUndecryptable(evt *muksevt.Event, err error) {
+	content, ok := evt.Content.Parsed.(*muksevt.BadEncryptedContent)
+	if !errors.Is(err, crypto.NoSessionFound) || !ok || content.Original == nil {
+		return
+	}
+	sessionID := content.Original.SessionID
+	c.utdLock.Lock()
+	if c.utd == nil {
+		c.utd = make(map[id.SessionID][]*muksevt.Event)
+	}
+	existing := c.utd[sessionID]
+	for _, tracked := range existing {
+		if tracked.ID == evt.ID {
+			c.utdLock.Unlock()
+			return
+		}
+	}
+	c.utd[sessionID] = append(existing, evt)
+	c.utdLock.Unlock()
+	roomID := evt.RoomID
+	c.GetOrCreateRoom(roomID).SetPostUnload(func() {
+		c.forgetUndecryptable(roomID)
+	})
+}
+
+// forgetUndecryptable stops tracking the undecryptable events of a room after it has been unloaded.
+func (c *Container) forgetUndecryptable(roomID id.RoomID) {
+	c.utdLock.Lock()
+	defer c.utdLock.Unlock()
+	for sessionID, events := range c.utd {
+		// Build a new slice, as retryDecryption may be iterating over the old one.
+		var kept []*muksevt.Event
+		for _, evt := range events {
+			if evt.RoomID != roomID {
+				kept = append(kept, evt)
+			}
+		}
+		if len(kept) == 0 {
+			delete(c.utd, sessionID)
+		} else {
+			c.utd[sessionID] = kept
+		}
+	}
+}
+
+// retryCachedUndecryptable tries to decrypt the undecryptable events loaded from the local cache, as their
+// keys may have been received or imported while they weren't tracked. Events that still can't be decrypted
+// are tracked again.
+func (c *Container) retryCachedUndecryptable(events []*muksevt.Event) {
+	if c.crypto == nil {
+		return
+	}
+	for i, evt := range events {
+		content, ok := evt.Content.Parsed.(*muksevt.BadEncryptedContent)
+		if evt.Type != muksevt.EventBadEncrypted || !ok || content.Original == nil {
+			continue
+		}
+		encrypted := *evt.Event
+		encrypted.Type = event.EventEncrypted
+		encrypted.Content = event.Content{Parsed: content.Original}
+		decrypted, err := c.crypto.DecryptMegolmEvent(&encrypted)
+		if err != nil {
+			c.trackUndecryptable(evt, err)
+			continue
+		}
+		wrapped := muksevt.WrapDecrypted(decrypted, &encrypted)
+		c.updateDecryptedHistory(wrapped)
+		events[i] = wrapped
+	}
+}
+
+// retryReceivedSessions retries decrypting the events whose room keys have arrived since they were tracked.
+// It's called after to-device events have been handled, as room keys are delivered as to-device events.
+func (c *Container) retryReceivedSessions(mach *crypto.OlmMachine) {
+	c.utdLock.Lock()
+	var received []id.SessionID
+	for sessionID, events := range c.utd {
+		content := events[0].Content.Parsed.(*muksevt.BadEncryptedContent)
+		session, _ := mach.CryptoStore.GetGroupSession(events[0].RoomID, content.Original.SenderKey, sessionID)
+		if session != nil {
+			received = append(received, sessionID)
+		}
+	}
+	c.utdLock.Unlock()
+	for _, sessionID := range received {
+		debug.Printf("Received room key for %s, retrying decryption of waiting events", sessionID)
+		c.retryDecryption(sessionID)
+	}
+}
+
+// RetryDecryption tries to decrypt all tracked undecryptable events again,
+// e.g. after keys have been imported from a file or from key backup.
+func (c *Container) RetryDecryption() {
+	c.utdLock.Lock()
+	sessionIDs := make([]id.SessionID, 0, len(c.utd))
+	for sessionID := range c.utd {
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	c.utdLock.Unlock()
+	for _, sessionID := range sessionIDs {
+		c.retryDecryption(sessionID)
+	}
+}
+
+func (c *Container) retryDecryption(sessionID id.SessionID) {
+	c.utdLock.Lock()
+	events := c.utd[sessionID]
+	c.utdLock.Unlock()
+
+	var failed []*muksevt.Event
+	for _, evt := range events {
+		content := evt.Content.Parsed.(*muksevt.BadEncryptedContent)
+		encrypted := *evt.Event
+		encrypted.Type = event.EventEncrypted
+		encrypted.Content = event.Content{Parsed: content.Original}
+		decrypted, err := c.crypto.DecryptMegolmEvent(&encrypted)
+		if err != nil {
+			debug.Printf("Failed to decrypt event %s on retry: %v", evt.ID, err)
+			failed = append(failed, evt)
+			continue
+		}
+		c.replaceDecrypted(muksevt.WrapDecrypted(decrypted, &encrypted))
+	}
+
+	c.utdLock.Lock()
+	// Keep the events that were tracked while decrypting, and the ones that still failed
+	// unless their room was unloaded in the meantime.
+	current := c.utd[sessionID]
+	var kept []*muksevt.Event
+	for _, evt := range current {
+		if !containsEvent(events, evt) {
+			kept = append(kept, evt)
+		}
+	}
+	for _, evt := range failed {
+		if containsEvent(current, evt) {
+			kept = append(kept, evt)
+		}
+	}
+	if len(kept) > 0 {
+		c.utd[sessionID] = kept
+	} else {
+		delete(c.utd, sessionID)
+	}
+	c.utdLock.Unlock()
+	if len(events) > 0 {
+		c.ui.Render()
+	}
+}
+
+func containsEvent(events []*muksevt.Event, evt *muksevt.Event) bool {
+	for _, existing := range events {
+		if existing == evt {
+			return true
+		}
+	}
+	return false
+}
+
+// updateDecryptedHistory replaces a previously undecryptable event in the history.
+func (c *Container) updateDecryptedHistory(evt *muksevt.Event) {
+	err := c.history.Update(c.GetOrCreateRoom(evt.RoomID), evt.ID, func(stored *muksevt.Event) error {
+		stored.Event = evt.Event
+		stored.Gomuks.Encryption = evt.Gomuks.Encryption
+		return nil
+	})
+	if err != nil {
+		debug.Printf("Failed to update decrypted event %s in history: %v", evt.ID, err)
+	}
+}
+
+// replaceDecrypted replaces a previously undecryptable event in the history and the timeline.
+func (c *Container) replaceDecrypted(evt *muksevt.Event) {
+	c.updateDecryptedHistory(evt)
+	room := c.GetOrCreateRoom(evt.RoomID)
+	if roomView := c.ui.MainView().GetRoom(evt.RoomID); roomView != nil && room.Loaded() {
+		roomView.AddEdit(evt)
+	}
+}
@@ -0,0 +1,145 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"time"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+const syncWatchdogCheckInterval = 30 * time.Second
+const serverReachableTimeout = 10 * time.Second
+
+// checkServerReachable makes a quick request to the homeserver with a separate client,
+// to tell a hung sync request apart from a network outage.
+func (c *Container) checkServerReachable() error {
+	client := &http.Client{
+		Timeout:   serverReachableTimeout,
+		Transport: http.DefaultTransport.(*http.Transport).Clone(),
+	}
+	if len(os.Getenv("GOMUKS_ALLOW_INSECURE_CONNECTIONS")) > 0 {
+		client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	resp, err := client.Get(c.client.BuildBaseURL("_matrix", "client", "versions"))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("server returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// runSyncWatchdog restarts the sync request if it hasn't finished in the configured time even though
+// the server is reachable, e.g. because a long-poll connection silently died. If processing a sync
+// response takes that long instead, e.g. because it's waiting for the user to answer a prompt,
+// the user is told about it, as restarting the request wouldn't help.
+func (c *Container) runSyncWatchdog() {
+	defer debug.Recover()
+	timeout := c.config.GetSyncWatchdogTimeout()
+	if timeout <= 0 {
+		return
+	}
+	var reportedProcessing time.Time
+	for c.running {
+		time.Sleep(syncWatchdogCheckInterval)
+		syncer := c.syncer
+		if syncer == nil || !syncer.FirstSyncDone {
+			continue
+		}
+		if syncer.IsProcessing() {
+			// While processing, the last activity is when the response was received.
+			received := syncer.LastActivity()
+			if stalled := time.Since(received); stalled >= timeout && !received.Equal(reportedProcessing) {
+				reportedProcessing = received
+				c.reportProcessingStall(stalled)
+			}
+			continue
+		}
+		stalled := c.syncRequest.InFlight()
+		if stalled < timeout {
+			continue
+		} else if err := c.checkServerReachable(); err != nil {
+			debug.Printf("Sync request has been stalled for %s, but the server isn't reachable either: %v", stalled, err)
+			continue
+		}
+		c.restartSync(stalled)
+	}
+}
+
+// restartSync aborts the current sync request. Other requests are left alone, and the sync loop retries
+// with a new connection, as canceling the request closes the connection it was using.
+func (c *Container) restartSync(stalled time.Duration) {
+	debug.Printf("Sync request has been stalled for %s even though the server is reachable, restarting it", stalled)
+	diagnostics, err := c.dumpSyncDiagnostics(stalled)
+	if err != nil {
+		debug.Print("Failed to save sync diagnostics:", err)
+	}
+	c.syncRequest.Cancel()
+
+	message := fmt.Sprintf("No sync response was received in %s, so the sync request was restarted.", stalled.Round(time.Second))
+	if len(diagnostics) > 0 {
+		message += " Diagnostics were saved to " + diagnostics
+	}
+	c.ui.MainView().ShowServiceMessage(message)
+	c.ui.Render()
+}
+
+// reportProcessingStall tells the user that handling a sync response has been taking too long.
+func (c *Container) reportProcessingStall(stalled time.Duration) {
+	debug.Printf("Processing a sync response has been stalled for %s", stalled)
+	diagnostics, err := c.dumpSyncDiagnostics(stalled)
+	if err != nil {
+		debug.Print("Failed to save sync diagnostics:", err)
+	}
+	message := fmt.Sprintf("Processing the latest sync response has been taking %s. "+
+		"If a prompt is open, new messages will arrive after it's answered.", stalled.Round(time.Second))
+	if len(diagnostics) > 0 {
+		message += " Diagnostics were saved to " + diagnostics
+	}
+	c.ui.MainView().ShowServiceMessage(message)
+	c.ui.Render()
+}
+
+// dumpSyncDiagnostics writes the sync state and the stacks of all goroutines into a file in the log directory.
+func (c *Container) dumpSyncDiagnostics(stalled time.Duration) (string, error) {
+	var buf bytes.Buffer
+	_, _ = fmt.Fprintf(&buf, "Sync stalled for %s at %s\n", stalled, time.Now().Format(time.RFC3339))
+	_, _ = fmt.Fprintf(&buf, "Homeserver: %s\n", c.client.HomeserverURL)
+	_, _ = fmt.Fprintf(&buf, "Next batch: %s\n", c.config.AuthCache.NextBatch)
+	stats := c.syncer.DispatchStats()
+	_, _ = fmt.Fprintf(&buf, "Dispatched events: %d, max queue depth: %d, blocked %d times for %s\n\n",
+		stats.Dispatched, stats.MaxDepth, stats.Blocked, stats.BlockedTime)
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 2); err != nil {
+		_, _ = fmt.Fprintf(&buf, "Failed to dump goroutines: %v\n", err)
+	}
+	path := filepath.Join(debug.LogDirectory, fmt.Sprintf("sync-watchdog-%s.txt", time.Now().Format("2006-01-02--15-04-05")))
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0640); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,100 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package matrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/matrix/rooms"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+type webhookPayload struct {
+	Match      string          `json:"match"`
+	RoomID     id.RoomID       `json:"room_id"`
+	RoomName   string          `json:"room_name"`
+	EventID    id.EventID      `json:"event_id"`
+	Type       string          `json:"type"`
+	Sender     id.UserID       `json:"sender"`
+	SenderName string          `json:"sender_name"`
+	Timestamp  int64           `json:"timestamp"`
+	Body       string          `json:"body,omitempty"`
+	Highlight  bool            `json:"highlight"`
+	Content    json.RawMessage `json:"content"`
+}
+
+// forwardToWebhooks POSTs the given event to all configured webhooks that it matches.
+func (c *Container) forwardToWebhooks(room *rooms.Room, evt *muksevt.Event, highlight bool) {
+	defer debug.Recover()
+	var body string
+	if content, ok := evt.Content.Parsed.(*event.MessageEventContent); ok {
+		body = content.Body
+	}
+	for i := range c.config.Webhooks {
+		hook := &c.config.Webhooks[i]
+		match, ok := hook.Match(room.ID, body, highlight)
+		if !ok {
+			continue
+		}
+		senderName := string(evt.Sender)
+		if member := room.GetMember(evt.Sender); member != nil {
+			senderName = member.Displayname
+		}
+		err := sendWebhook(hook.URL, &webhookPayload{
+			Match:      match,
+			RoomID:     room.ID,
+			RoomName:   room.GetTitle(),
+			EventID:    evt.ID,
+			Type:       evt.Type.Type,
+			Sender:     evt.Sender,
+			SenderName: senderName,
+			Timestamp:  evt.Timestamp,
+			Body:       body,
+			Highlight:  highlight,
+			Content:    evt.Content.VeryRaw,
+		})
+		if err != nil {
+			debug.Printf("Failed to forward %s to webhook %s: %v", evt.ID, hook.URL, err)
+		}
+	}
+}
+
+func sendWebhook(url string, payload *webhookPayload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
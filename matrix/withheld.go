@@ -0,0 +1,110 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// +build cgo
+
+package matrix
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/event"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+)
+
+var withheldReasons = map[event.RoomKeyWithheldCode]string{
+	event.RoomKeyWithheldBlacklisted: "the sender has blacklisted this device",
+	event.RoomKeyWithheldUnverified:  "the sender only shares keys with verified devices",
+	// The spec spells the code with an s, mautrix with a z.
+	"m.unauthorised":                  "the key wasn't shared because you weren't in the room when the message was sent",
+	event.RoomKeyWithheldUnauthorized: "the key wasn't shared because you weren't in the room when the message was sent",
+	event.RoomKeyWithheldUnavailable:  "the sender doesn't have the key anymore",
+	event.RoomKeyWithheldNoOlmSession: "the sender couldn't establish an encrypted session with this device",
+}
+
+func withheldDescription(content *event.RoomKeyWithheldEventContent) string {
+	reason, ok := withheldReasons[content.Code]
+	if !ok {
+		reason = content.Reason
+		if len(reason) == 0 {
+			reason = string(content.Code)
+		}
+	}
+	return fmt.Sprintf("Unable to decrypt: %s", reason)
+}
+
+func isWithheldEvent(evt *event.Event) bool {
+	return evt.Type.Type == "m.room_key.withheld" || evt.Type.Type == "org.matrix.room_key.withheld"
+}
+
+// handleWithheld stores the reason a session was withheld in the crypto store and updates any
+// already rendered undecryptable events from that session.
+func (c *Container) handleWithheld(evt *event.Event) {
+	mach, ok := c.crypto.(*crypto.OlmMachine)
+	if !ok {
+		return
+	}
+	var content event.RoomKeyWithheldEventContent
+	if err := json.Unmarshal(evt.Content.VeryRaw, &content); err != nil {
+		debug.Printf("[Crypto/Warn] Failed to parse withheld event from %s: %v", evt.Sender, err)
+		return
+	} else if len(content.SessionID) == 0 || len(content.RoomID) == 0 {
+		// m.no_olm is sent without a session ID, it applies to all sessions we didn't get.
+		return
+	}
+	debug.Printf("[Crypto/Debug] %s withheld session %s/%s in %s: %s", evt.Sender, content.SenderKey, content.SessionID, content.RoomID, content.Code)
+	if err := mach.CryptoStore.PutWithheldGroupSession(content); err != nil {
+		debug.Printf("[Crypto/Warn] Failed to store withheld session %s: %v", content.SessionID, err)
+	}
+
+	reason := withheldDescription(&content)
+	c.utdLock.Lock()
+	events := c.utd[content.SessionID]
+	c.utdLock.Unlock()
+	updated := false
+	for _, utdEvt := range events {
+		badContent, ok := utdEvt.Content.Parsed.(*muksevt.BadEncryptedContent)
+		if !ok || badContent.Original == nil || utdEvt.RoomID != content.RoomID || badContent.Original.SenderKey != content.SenderKey {
+			continue
+		}
+		// The tracked event may be rendered at the same time, so the new reason is set on a copy.
+		evtCopy := *utdEvt.Event
+		evtCopy.Content.Parsed = &muksevt.BadEncryptedContent{
+			Original: badContent.Original,
+			Reason:   reason,
+		}
+		c.replaceDecrypted(&muksevt.Event{Event: &evtCopy, Gomuks: utdEvt.Gomuks})
+		updated = true
+	}
+	if updated {
+		c.ui.Render()
+	}
+}
+
+// decryptionFailureReason returns the text shown in the timeline for an event that couldn't be decrypted.
+func (c *Container) decryptionFailureReason(evt *event.Event, content *event.EncryptedEventContent, err error) string {
+	if mach, ok := c.crypto.(*crypto.OlmMachine); ok && content != nil {
+		withheld, _ := mach.CryptoStore.GetWithheldGroupSession(evt.RoomID, content.SenderKey, content.SessionID)
+		if withheld != nil {
+			return withheldDescription(withheld)
+		}
+	}
+	return err.Error()
+}
@@ -0,0 +1,217 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+const accountDataEditorHelp = "Ctrl+S: save, Ctrl+F: reformat, Esc: close without saving"
+
+// AccountDataEditor is a modal for editing the raw JSON content of an account data event.
+type AccountDataEditor struct {
+	mauview.FocusableComponent
+	parent *MainView
+
+	roomID    id.RoomID
+	eventType string
+
+	input  *mauview.InputArea
+	status *mauview.TextField
+	saving bool
+}
+
+func NewAccountDataEditor(parent *MainView, roomID id.RoomID, eventType string, content json.RawMessage) *AccountDataEditor {
+	ade := &AccountDataEditor{
+		parent:    parent,
+		roomID:    roomID,
+		eventType: eventType,
+		input:     mauview.NewInputArea(),
+		status:    mauview.NewTextField().SetText(accountDataEditorHelp),
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, content, "", "  "); err != nil {
+		ade.input.SetText(string(content))
+	} else {
+		ade.input.SetText(indented.String())
+	}
+	ade.input.Focus()
+
+	flex := mauview.NewFlex().
+		SetDirection(mauview.FlexRow).
+		AddProportionalComponent(ade.input, 1).
+		AddFixedComponent(ade.status, 1)
+
+	title := fmt.Sprintf("Account data: %s", eventType)
+	if len(roomID) > 0 {
+		title = fmt.Sprintf("Room account data: %s in %s", eventType, roomID)
+	}
+	box := mauview.NewBox(flex).
+		SetBorder(true).
+		SetTitle(title).
+		SetBlurCaptureFunc(func() bool {
+			ade.parent.HideModal()
+			return true
+		})
+	box.Focus()
+
+	ade.FocusableComponent = mauview.FractionalCenter(box, 60, 15, 0.75, 0.75)
+	return ade
+}
+
+func (ade *AccountDataEditor) setStatus(text string, color tcell.Color) {
+	ade.status.SetText(text).SetTextColor(color)
+	ade.parent.parent.Render()
+}
+
+// syntaxErrorPosition converts the byte offset of a JSON syntax error into a line and column.
+func syntaxErrorPosition(data string, offset int64) (line, column int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line = strings.Count(before, "\n") + 1
+	column = len(before) - strings.LastIndexByte(before, '\n')
+	return
+}
+
+// validateAccountData checks that the given text is a JSON object and, if the event type is known,
+// that the content matches the expected structure.
+func validateAccountData(eventType, text string) (json.RawMessage, error) {
+	var content map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &content); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line, column := syntaxErrorPosition(text, syntaxErr.Offset)
+			return nil, fmt.Errorf("invalid JSON at line %d, column %d: %v", line, column, err)
+		}
+		return nil, fmt.Errorf("content must be a JSON object: %v", err)
+	}
+	compact, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	evtType := event.Type{Type: eventType, Class: event.AccountDataEventType}
+	if contentType, ok := event.TypeMap[evtType]; ok {
+		if err = json.Unmarshal(compact, reflect.New(contentType).Interface()); err != nil {
+			return nil, fmt.Errorf("invalid %s content: %v", eventType, err)
+		}
+	}
+	return compact, nil
+}
+
+func (ade *AccountDataEditor) reformat() {
+	content, err := validateAccountData(ade.eventType, ade.input.GetText())
+	if err != nil {
+		ade.setStatus(err.Error(), tcell.ColorRed)
+		return
+	}
+	var indented bytes.Buffer
+	_ = json.Indent(&indented, content, "", "  ")
+	ade.input.SetText(indented.String())
+	ade.setStatus(accountDataEditorHelp, mauview.Styles.PrimaryTextColor)
+}
+
+func (ade *AccountDataEditor) save() {
+	if ade.saving {
+		return
+	}
+	content, err := validateAccountData(ade.eventType, ade.input.GetText())
+	if err != nil {
+		ade.setStatus(err.Error(), tcell.ColorRed)
+		return
+	}
+	ade.saving = true
+	ade.setStatus("Saving...", mauview.Styles.PrimaryTextColor)
+	go func() {
+		defer debug.Recover()
+		err := ade.parent.matrix.SetAccountData(ade.roomID, ade.eventType, content)
+		ade.saving = false
+		if err != nil {
+			ade.setStatus(fmt.Sprintf("Failed to save: %s", niceError(err)), tcell.ColorRed)
+			return
+		}
+		ade.parent.HideModal()
+		if ade.parent.currentRoom != nil {
+			ade.parent.currentRoom.AddServiceMessage(fmt.Sprintf("Saved %s account data", ade.eventType))
+		}
+		ade.parent.parent.Render()
+	}()
+}
+
+func (ade *AccountDataEditor) OnKeyEvent(event mauview.KeyEvent) bool {
+	switch event.Key() {
+	case tcell.KeyEscape:
+		ade.parent.HideModal()
+	case tcell.KeyCtrlS:
+		ade.save()
+	case tcell.KeyCtrlF:
+		ade.reformat()
+	default:
+		return ade.input.OnKeyEvent(event)
+	}
+	return true
+}
+
+func (ade *AccountDataEditor) OnPasteEvent(event mauview.PasteEvent) bool {
+	return ade.input.OnPasteEvent(event)
+}
+
+func cmdAccountData(cmd *Command) {
+	var roomID id.RoomID
+	args := cmd.Args
+	if len(args) > 0 && args[0] == "--room" {
+		roomID = cmd.Room.Room.ID
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		cmd.Reply("Usage: /%s [--room] <event type>, e.g. /%s m.direct or /%s --room m.tag",
+			cmd.OrigCommand, cmd.OrigCommand, cmd.OrigCommand)
+		return
+	}
+	eventType := args[0]
+	go func() {
+		defer debug.Recover()
+		content, err := cmd.Matrix.GetAccountData(roomID, eventType)
+		if errors.Is(err, mautrix.MNotFound) {
+			content = json.RawMessage("{}")
+		} else if err != nil {
+			cmd.Reply("Failed to fetch %s account data: %s", eventType, niceError(err))
+			return
+		}
+		editor := NewAccountDataEditor(cmd.MainView, roomID, eventType, content)
+		if err != nil {
+			editor.setStatus("No existing data, saving will create it. "+accountDataEditorHelp, mauview.Styles.PrimaryTextColor)
+		}
+		cmd.MainView.ShowModal(editor)
+		cmd.UI.Render()
+	}()
+}
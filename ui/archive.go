@@ -0,0 +1,76 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"maunium.net/go/gomuks/matrix/rooms"
+)
+
+// collectArchivedRooms finds all rooms in the local cache that the user has left.
+func collectArchivedRooms(cache *rooms.RoomCache) (archived []*rooms.Room) {
+	for _, room := range cache.Rooms() {
+		if room.HasLeft {
+			archived = append(archived, room)
+		}
+	}
+	sort.Slice(archived, func(i, j int) bool {
+		return archived[i].LastReceivedMessage.After(archived[j].LastReceivedMessage)
+	})
+	return
+}
+
+// OpenArchivedRoom shows a room that the user has left without adding it to the room list.
+func (view *MainView) OpenArchivedRoom(room *rooms.Room) {
+	view.roomsLock.Lock()
+	view.addRoomPage(room)
+	view.switchRoom("", room, false)
+	view.roomsLock.Unlock()
+}
+
+func cmdArchive(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		archived := collectArchivedRooms(cmd.Config.Rooms)
+		cmd.MainView.archive = archived
+		if len(archived) == 0 {
+			cmd.Reply("No archived rooms in the local cache")
+			return
+		}
+		var buf strings.Builder
+		buf.WriteString("Archived rooms (no longer synced):\n")
+		for i, room := range archived {
+			_, _ = fmt.Fprintf(&buf, "%d. %s (%s)", i+1, room.GetTitle(), room.ID)
+			if !room.LastReceivedMessage.IsZero() {
+				_, _ = fmt.Fprintf(&buf, " - last message %s", room.LastReceivedMessage.Format("2006-01-02"))
+			}
+			buf.WriteRune('\n')
+		}
+		_, _ = fmt.Fprintf(&buf, "Use /%s <number> to open one.", cmd.OrigCommand)
+		cmd.Reply("%s", buf.String())
+		return
+	}
+	index, err := strconv.Atoi(cmd.Args[0])
+	if err != nil || index < 1 || index > len(cmd.MainView.archive) {
+		cmd.Reply("Usage: /%s [number], use /%s without arguments to list archived rooms", cmd.OrigCommand, cmd.OrigCommand)
+		return
+	}
+	cmd.MainView.OpenArchivedRoom(cmd.MainView.archive[index-1])
+}
@@ -0,0 +1,415 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	sync "github.com/sasha-s/go-deadlock"
+
+	"maunium.net/go/mautrix/event"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/ui/messages"
+)
+
+const audioProgressWidth = 20
+
+// audioPlayer plays one audio message at a time with the configured external player. Pausing stops the player
+// process and resuming or seeking starts it again from the remembered position, so any player that can start
+// from an offset works.
+type audioPlayer struct {
+	lock   sync.Mutex
+	parent *MainView
+
+	name     string
+	path     string
+	duration time.Duration
+	// The position where the player process was started, or where playback was paused.
+	position time.Duration
+	// When the player process was started, zero while paused.
+	started time.Time
+	process *exec.Cmd
+}
+
+func formatAudioTime(d time.Duration) string {
+	seconds := int(d.Round(time.Second) / time.Second)
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+func (player *audioPlayer) currentPosition() time.Duration {
+	position := player.position
+	if !player.started.IsZero() {
+		position += time.Since(player.started)
+	}
+	if player.duration > 0 && position > player.duration {
+		position = player.duration
+	}
+	return position
+}
+
+func (player *audioPlayer) start() error {
+	command := player.parent.config.Audio.GetPlayer()
+	if len(command) == 0 {
+		return errors.New("no audio player configured and neither mpv nor ffplay is installed")
+	}
+	start := strconv.FormatFloat(player.position.Seconds(), 'f', 1, 64)
+	args := make([]string, len(command)-1)
+	for i, arg := range command[1:] {
+		args[i] = strings.NewReplacer("{file}", player.path, "{start}", start).Replace(arg)
+	}
+	process := exec.Command(command[0], args...)
+	if err := process.Start(); err != nil {
+		return err
+	}
+	player.process = process
+	player.started = time.Now()
+	go player.wait(process)
+	go player.refresh(process)
+	return nil
+}
+
+// wait clears the player when playback reaches the end, i.e. when the player exits without being stopped.
+func (player *audioPlayer) wait(process *exec.Cmd) {
+	err := process.Wait()
+	player.lock.Lock()
+	if player.process != process {
+		player.lock.Unlock()
+		return
+	}
+	if err != nil {
+		debug.Printf("Audio player for %s exited with error: %v", player.path, err)
+	}
+	player.process = nil
+	player.path = ""
+	player.lock.Unlock()
+	player.parent.parent.Render()
+}
+
+// refresh re-renders every second so the progress bar in the status bar stays up to date.
+func (player *audioPlayer) refresh(process *exec.Cmd) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		player.lock.Lock()
+		current := player.process == process
+		player.lock.Unlock()
+		if !current {
+			return
+		}
+		player.parent.parent.Render()
+	}
+}
+
+// kill stops the player process without clearing the playback state.
+func (player *audioPlayer) kill() {
+	if player.process == nil {
+		return
+	}
+	player.position = player.currentPosition()
+	player.started = time.Time{}
+	if err := player.process.Process.Kill(); err != nil {
+		debug.Printf("Failed to stop audio player: %v", err)
+	}
+	player.process = nil
+}
+
+// Play starts playing the file at the given path from the beginning, replacing whatever was playing before.
+func (player *audioPlayer) Play(name, path string, duration time.Duration) error {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+	player.kill()
+	player.name = name
+	player.path = path
+	player.duration = duration
+	player.position = 0
+	err := player.start()
+	if err != nil {
+		player.path = ""
+	}
+	return err
+}
+
+// TogglePause pauses or resumes playback. It returns false if nothing is playing.
+func (player *audioPlayer) TogglePause() bool {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+	if len(player.path) == 0 {
+		return false
+	} else if player.process != nil {
+		player.kill()
+	} else if err := player.start(); err != nil {
+		debug.Printf("Failed to resume audio player: %v", err)
+	}
+	return true
+}
+
+// Seek moves the playback position by the given amount. It returns false if nothing is playing.
+func (player *audioPlayer) Seek(delta time.Duration) bool {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+	if len(player.path) == 0 {
+		return false
+	}
+	playing := player.process != nil
+	player.kill()
+	player.position = player.currentPosition() + delta
+	if player.position < 0 {
+		player.position = 0
+	} else if player.duration > 0 && player.position > player.duration {
+		player.position = player.duration
+	}
+	if playing {
+		if err := player.start(); err != nil {
+			debug.Printf("Failed to seek audio player: %v", err)
+		}
+	}
+	return true
+}
+
+// Stop stops playback. It returns false if nothing was playing.
+func (player *audioPlayer) Stop() bool {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+	if len(player.path) == 0 {
+		return false
+	}
+	player.kill()
+	player.path = ""
+	return true
+}
+
+// Status returns the textual progress bar shown in the status bar, or an empty string if nothing is playing.
+func (player *audioPlayer) Status() string {
+	player.lock.Lock()
+	defer player.lock.Unlock()
+	if len(player.path) == 0 {
+		return ""
+	}
+	var buf strings.Builder
+	if player.process != nil {
+		buf.WriteString("Playing ")
+	} else {
+		buf.WriteString("Paused ")
+	}
+	buf.WriteString(player.name)
+	position := player.currentPosition()
+	if player.duration > 0 {
+		filled := int(position * audioProgressWidth / player.duration)
+		_, _ = fmt.Fprintf(&buf, " [%s%s] %s/%s", strings.Repeat("#", filled), strings.Repeat("-", audioProgressWidth-filled),
+			formatAudioTime(position), formatAudioTime(player.duration))
+	} else {
+		_, _ = fmt.Fprintf(&buf, " %s", formatAudioTime(position))
+	}
+	return buf.String()
+}
+
+// PlayAudio downloads the file in the given message and plays it with the configured audio player.
+func (view *RoomView) PlayAudio(msg *messages.FileMessage) {
+	defer debug.Recover()
+	path, err := view.parent.matrix.DownloadToDisk(msg.URL, msg.File, mediaTempPath(msg))
+	if err != nil {
+		view.AddServiceMessage(fmt.Sprintf("Failed to download audio: %v", err))
+		view.parent.parent.Render()
+		return
+	}
+	name := msg.Body
+	if msg.Voice {
+		name = "voice message"
+	}
+	err = view.parent.audio.Play(name, path, time.Duration(msg.Duration)*time.Millisecond)
+	if err != nil {
+		view.AddServiceMessage(fmt.Sprintf("Failed to play audio: %v", err))
+	}
+	view.parent.parent.Render()
+}
+
+// voiceRecorder records a voice message with the configured recorder command.
+type voiceRecorder struct {
+	room    *RoomView
+	path    string
+	started time.Time
+	process *exec.Cmd
+	stdin   io.WriteCloser
+	done    chan error
+}
+
+func (rec *voiceRecorder) Status() string {
+	return fmt.Sprintf("Recording voice message %s (/voice to send, /voice cancel to discard)",
+		formatAudioTime(time.Since(rec.started)))
+}
+
+// stop asks the recorder to finish writing the file and waits for it to exit.
+func (rec *voiceRecorder) stop() error {
+	_, _ = rec.stdin.Write([]byte("q\n"))
+	_ = rec.process.Process.Signal(os.Interrupt)
+	select {
+	case err := <-rec.done:
+		return err
+	case <-time.After(5 * time.Second):
+		_ = rec.process.Process.Kill()
+		return <-rec.done
+	}
+}
+
+// StartVoiceRecording starts recording a voice message that will be sent to the given room.
+func (view *MainView) StartVoiceRecording(room *RoomView) error {
+	view.recorderLock.Lock()
+	defer view.recorderLock.Unlock()
+	if view.recorder != nil {
+		return errors.New("already recording a voice message")
+	}
+	command := view.config.Audio.GetRecorder()
+	if len(command) == 0 {
+		return errors.New("no recorder configured for this platform")
+	}
+	dir, err := ioutil.TempDir("", "gomuks-voice")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "voice-message.ogg")
+	args := make([]string, len(command)-1)
+	for i, arg := range command[1:] {
+		args[i] = strings.ReplaceAll(arg, "{file}", path)
+	}
+	rec := &voiceRecorder{room: room, path: path, process: exec.Command(command[0], args...), done: make(chan error, 1)}
+	if rec.stdin, err = rec.process.StdinPipe(); err != nil {
+		return err
+	} else if err = rec.process.Start(); err != nil {
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	rec.started = time.Now()
+	go func() {
+		rec.done <- rec.process.Wait()
+	}()
+	view.recorder = rec
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			view.recorderLock.Lock()
+			current := view.recorder == rec
+			view.recorderLock.Unlock()
+			if !current {
+				return
+			}
+			view.parent.Render()
+		}
+	}()
+	return nil
+}
+
+// FinishVoiceRecording stops the current recording and sends it, or discards it if send is false.
+func (view *MainView) FinishVoiceRecording(send bool) error {
+	view.recorderLock.Lock()
+	rec := view.recorder
+	view.recorder = nil
+	view.recorderLock.Unlock()
+	if rec == nil {
+		return errors.New("not recording a voice message")
+	}
+	duration := time.Since(rec.started)
+	err := rec.stop()
+	defer os.RemoveAll(filepath.Dir(rec.path))
+	if !send {
+		view.parent.Render()
+		return nil
+	} else if stat, statErr := os.Stat(rec.path); statErr != nil || stat.Size() == 0 {
+		if err == nil {
+			err = errors.New("the recorder didn't write any audio")
+		}
+		return fmt.Errorf("failed to record voice message: %w", err)
+	}
+	rec.room.SendVoiceMessage(rec.path, duration)
+	return nil
+}
+
+// VoiceRecordingStatus returns the status of the voice message being recorded in the given room, if any.
+func (view *MainView) VoiceRecordingStatus(room *RoomView) string {
+	view.recorderLock.Lock()
+	defer view.recorderLock.Unlock()
+	if view.recorder == nil || view.recorder.room != room {
+		return ""
+	}
+	return view.recorder.Status()
+}
+
+// SendVoiceMessage uploads the recorded file and sends it as a voice message with the MSC3245 metadata.
+func (view *RoomView) SendVoiceMessage(path string, duration time.Duration) {
+	defer debug.Recover()
+	rel := view.getRelationForNewEvent()
+	upload, ctx := view.startUpload("voice message")
+	evt, err := view.parent.matrix.PrepareMediaMessage(ctx, view.Room, path, rel, func(sent, total int64) {
+		view.setUploadProgress(upload, sent, total)
+	})
+	view.finishUpload(upload)
+	if err != nil {
+		view.AddServiceMessage(fmt.Sprintf("Failed to upload voice message: %v", err))
+		view.parent.parent.Render()
+		return
+	}
+	content := evt.Content.AsMessage()
+	content.MsgType = event.MsgAudio
+	content.Body = "Voice message"
+	if content.Info == nil {
+		content.Info = &event.FileInfo{}
+	}
+	content.Info.Duration = int(duration / time.Millisecond)
+	if len(content.Info.MimeType) == 0 || content.Info.MimeType == "application/ogg" {
+		content.Info.MimeType = "audio/ogg"
+	}
+	evt.SetRawContent("org.matrix.msc1767.audio", map[string]interface{}{
+		"duration": content.Info.Duration,
+	})
+	evt.SetRawContent(messages.VoiceMessageKey, map[string]interface{}{})
+	view.addLocalEcho(evt, "")
+}
+
+func cmdPlay(cmd *Command) {
+	cmd.Room.StartSelecting(SelectPlay, "")
+}
+
+func cmdVoice(cmd *Command) {
+	recording := len(cmd.MainView.VoiceRecordingStatus(cmd.Room)) > 0
+	switch {
+	case cmd.RawArgs == "send" || cmd.RawArgs == "cancel" || (len(cmd.RawArgs) == 0 && recording):
+		// Stopping the recorder may take a moment, so don't block the UI while waiting for it.
+		go func() {
+			defer debug.Recover()
+			if err := cmd.MainView.FinishVoiceRecording(cmd.RawArgs != "cancel"); err != nil {
+				cmd.Reply("%v", err)
+				cmd.UI.Render()
+			}
+		}()
+	case len(cmd.RawArgs) == 0:
+		if err := cmd.MainView.StartVoiceRecording(cmd.Room); err != nil {
+			cmd.Reply("Failed to start recording: %v", err)
+		}
+	default:
+		cmd.Reply("Usage: /voice [send|cancel]")
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"strings"
+	"unicode"
 )
 
 func autocompleteFile(cmd *CommandAutocomplete) (completions []string, newText string) {
@@ -56,3 +57,23 @@ func autocompleteFile(cmd *CommandAutocomplete) (completions []string, newText s
 	}
 	return
 }
+
+func autocompleteDeviceUserID(cmd *CommandAutocomplete) (completions []string, newText string) {
+	userCompletions := cmd.Room.AutocompleteUser(cmd.Args[0])
+	if len(userCompletions) == 1 {
+		newText = fmt.Sprintf("/%s %s ", cmd.OrigCommand, userCompletions[0].id)
+	} else {
+		completions = make([]string, len(userCompletions))
+		for i, completion := range userCompletions {
+			completions[i] = completion.id
+		}
+	}
+	return
+}
+
+func autocompleteUser(cmd *CommandAutocomplete) ([]string, string) {
+	if len(cmd.Args) == 1 && !unicode.IsSpace(rune(cmd.RawArgs[len(cmd.RawArgs)-1])) {
+		return autocompleteDeviceUserID(cmd)
+	}
+	return []string{}, ""
+}
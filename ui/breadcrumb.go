@@ -0,0 +1,171 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/ui/widget"
+)
+
+// Breadcrumb is a clickable line in a room view that links to the previous or next room in a room upgrade chain.
+type Breadcrumb struct {
+	parent    *RoomView
+	successor bool
+}
+
+func NewBreadcrumb(parent *RoomView, successor bool) *Breadcrumb {
+	return &Breadcrumb{parent: parent, successor: successor}
+}
+
+// Target returns the ID of the room this breadcrumb links to, or an empty string if there's no such room.
+func (crumb *Breadcrumb) Target() id.RoomID {
+	if crumb.successor {
+		return crumb.parent.Room.ReplacedBy()
+	}
+	return crumb.parent.Room.Predecessor()
+}
+
+// server returns the server name of the user who upgraded the room, which is used when joining the target room.
+func (crumb *Breadcrumb) server() string {
+	evtType := event.StateCreate
+	if crumb.successor {
+		evtType = event.StateTombstone
+	}
+	evt := crumb.parent.Room.GetStateEvent(evtType, "")
+	if evt != nil && len(evt.Sender) > 0 {
+		_, server, _ := evt.Sender.Parse()
+		return server
+	}
+	// Fall back to the server in the ID of the current room, which is usually also in the linked room.
+	roomID := string(crumb.parent.Room.ID)
+	if index := strings.IndexRune(roomID, ':'); index >= 0 {
+		return roomID[index+1:]
+	}
+	return ""
+}
+
+func (crumb *Breadcrumb) Draw(screen mauview.Screen) {
+	target := crumb.Target()
+	if len(target) == 0 {
+		return
+	}
+	width, _ := screen.Size()
+	name := string(target)
+	if room := crumb.parent.parent.matrix.GetRoom(target); room != nil {
+		name = room.GetTitle()
+	}
+	var text string
+	if crumb.successor {
+		text = fmt.Sprintf("↓ This room has been replaced by %s. Click here or use /successor to go there.", name)
+	} else {
+		text = fmt.Sprintf("↑ This room continues %s. Click here or use /predecessor to read the older history.", name)
+	}
+	style := tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorDarkBlue)
+	widget.WriteLinePadded(screen, mauview.AlignLeft, text, 0, 0, width, style)
+}
+
+func (crumb *Breadcrumb) OnKeyEvent(event mauview.KeyEvent) bool {
+	return false
+}
+
+func (crumb *Breadcrumb) OnPasteEvent(event mauview.PasteEvent) bool {
+	return false
+}
+
+func (crumb *Breadcrumb) OnMouseEvent(event mauview.MouseEvent) bool {
+	if event.Buttons() != tcell.Button1 || event.HasMotion() {
+		return false
+	}
+	go crumb.Follow()
+	return true
+}
+
+// Follow switches to the room this breadcrumb links to, joining it first if it's not in the local cache.
+func (crumb *Breadcrumb) Follow() {
+	defer debug.Recover()
+	target := crumb.Target()
+	if len(target) == 0 {
+		return
+	}
+	mainView := crumb.parent.parent
+	room := mainView.matrix.GetRoom(target)
+	if room == nil {
+		var err error
+		room, err = mainView.matrix.JoinRoom(target, crumb.server())
+		if err != nil {
+			crumb.parent.AddServiceMessage(fmt.Sprintf("Failed to join %s: %v", target, err))
+			mainView.parent.Render()
+			return
+		}
+	}
+	if _, ok := mainView.getRoomView(room.ID, true); ok {
+		mainView.SwitchRoom("", room)
+	} else {
+		mainView.OpenArchivedRoom(room)
+	}
+	mainView.parent.Render()
+}
+
+// fetchCreateEvent fetches the m.room.create event of the room if it's not cached,
+// so that the predecessor breadcrumb can be shown in rooms that were synced before
+// the event was included in the sync filter.
+func (view *RoomView) fetchCreateEvent() {
+	defer debug.Recover()
+	var content event.CreateEventContent
+	err := view.parent.matrix.Client().StateEvent(view.Room.ID, event.StateCreate, "", &content)
+	if err != nil {
+		debug.Printf("Failed to fetch create event of %s: %v", view.Room.ID, err)
+		return
+	}
+	stateKey := ""
+	view.Room.UpdateState(&event.Event{
+		Type:     event.StateCreate,
+		RoomID:   view.Room.ID,
+		StateKey: &stateKey,
+		// The state endpoint only returns the content, but the creator is the sender of the create event.
+		Sender:  content.Creator,
+		Content: event.Content{Parsed: &content},
+	})
+	view.parent.parent.Render()
+}
+
+func cmdPredecessor(cmd *Command) {
+	crumb := cmd.Room.predecessor
+	if len(crumb.Target()) == 0 {
+		cmd.Reply("This room isn't the result of a room upgrade")
+		return
+	}
+	go crumb.Follow()
+}
+
+func cmdSuccessor(cmd *Command) {
+	crumb := cmd.Room.successor
+	if len(crumb.Target()) == 0 {
+		cmd.Reply("This room hasn't been upgraded")
+		return
+	}
+	go crumb.Follow()
+}
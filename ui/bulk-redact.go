@@ -0,0 +1,208 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/ui/messages"
+)
+
+const defaultBulkRedactLimit = 100
+const bulkRedactConfirmTimeout = 60 * time.Second
+
+// bulkRedactFilter selects the recent messages to redact with /redact --mine, --sender or --match.
+type bulkRedactFilter struct {
+	sender  id.UserID
+	pattern *regexp.Regexp
+	// The maximum number of matching messages to redact, or 0 for all of them.
+	count int
+	// How many of the most recent messages to look at.
+	limit int
+}
+
+func parseBulkRedactFilter(cmd *Command) (filter bulkRedactFilter, reason string, err error) {
+	filter.limit = defaultBulkRedactLimit
+	args := cmd.Args
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		flag := args[0]
+		if flag == "--" {
+			// Everything after -- is the reason, even if it looks like a flag.
+			args = args[1:]
+			break
+		}
+		if len(args) < 2 {
+			return filter, "", fmt.Errorf("%s requires a value", flag)
+		}
+		value := args[1]
+		args = args[2:]
+		switch flag {
+		case "--mine":
+			filter.sender = cmd.Config.UserID
+			filter.count, err = strconv.Atoi(value)
+			if err != nil || filter.count <= 0 {
+				return filter, "", fmt.Errorf("invalid message count %q", value)
+			}
+			if filter.count > filter.limit {
+				filter.limit = filter.count
+			}
+		case "--sender":
+			filter.sender = id.UserID(value)
+			if _, _, err = filter.sender.Parse(); err != nil {
+				return filter, "", fmt.Errorf("invalid user ID %q", value)
+			}
+		case "--match":
+			filter.pattern, err = regexp.Compile(value)
+			if err != nil {
+				return filter, "", fmt.Errorf("invalid regex: %w", err)
+			}
+		case "--limit":
+			filter.limit, err = strconv.Atoi(value)
+			if err != nil || filter.limit <= 0 {
+				return filter, "", fmt.Errorf("invalid limit %q", value)
+			}
+		default:
+			return filter, "", fmt.Errorf("unknown flag %s", flag)
+		}
+	}
+	if len(filter.sender) == 0 && filter.pattern == nil {
+		return filter, "", fmt.Errorf("no --mine, --sender or --match filter given")
+	}
+	return filter, strings.Join(args, " "), nil
+}
+
+// find returns the IDs of the matching messages among the most recent messages in the timeline, newest first.
+func (filter bulkRedactFilter) find(view *MessageView) []id.EventID {
+	view.messagesLock.RLock()
+	defer view.messagesLock.RUnlock()
+	var eventIDs []id.EventID
+	checked := 0
+	for i := len(view.messages) - 1; i >= 0 && checked < filter.limit; i-- {
+		msg := view.messages[i]
+		if msg.IsService || len(msg.EventID) == 0 || msg.Event == nil || msg.Event.StateKey != nil {
+			continue
+		}
+		checked++
+		if _, redacted := msg.Renderer.(*messages.RedactedMessage); redacted {
+			continue
+		} else if len(filter.sender) > 0 && msg.SenderID != filter.sender {
+			continue
+		} else if filter.pattern != nil && !filter.pattern.MatchString(msg.PlainText()) {
+			continue
+		}
+		eventIDs = append(eventIDs, msg.EventID)
+		if filter.count > 0 && len(eventIDs) >= filter.count {
+			break
+		}
+	}
+	return eventIDs
+}
+
+func (filter bulkRedactFilter) String() string {
+	var parts []string
+	if len(filter.sender) > 0 {
+		parts = append(parts, fmt.Sprintf("from %s", filter.sender))
+	}
+	if filter.pattern != nil {
+		parts = append(parts, fmt.Sprintf("matching /%s/", filter.pattern))
+	}
+	return strings.Join(parts, " ")
+}
+
+// startRedacting marks a bulk redaction of the given number of messages as started in the room.
+// It returns false if another bulk redaction is already in progress.
+func (view *RoomView) startRedacting(total int) bool {
+	view.redacting.lock.Lock()
+	defer view.redacting.lock.Unlock()
+	if view.redacting.total > 0 {
+		return false
+	}
+	view.redacting.done = 0
+	view.redacting.total = total
+	return true
+}
+
+// setRedactingProgress updates the number of messages handled by the bulk redaction in progress.
+func (view *RoomView) setRedactingProgress(done int) {
+	view.redacting.lock.Lock()
+	view.redacting.done = done
+	view.redacting.lock.Unlock()
+}
+
+func (view *RoomView) finishRedacting() {
+	view.redacting.lock.Lock()
+	view.redacting.total = 0
+	view.redacting.lock.Unlock()
+}
+
+// redactingProgress returns the progress of the bulk redaction in progress, or zeroes if there isn't one.
+func (view *RoomView) redactingProgress() (done, total int) {
+	view.redacting.lock.Lock()
+	defer view.redacting.lock.Unlock()
+	return view.redacting.done, view.redacting.total
+}
+
+func cmdBulkRedact(cmd *Command) {
+	filter, reason, err := parseBulkRedactFilter(cmd)
+	if err != nil {
+		cmd.Reply("%v. Usage: /%s <--mine <count> | --sender <user ID> | --match <regex>> [--limit <messages>] [--] [reason]",
+			err, cmd.OrigCommand)
+		return
+	}
+	view := cmd.Room
+	if _, total := view.redactingProgress(); total > 0 {
+		cmd.Reply("A bulk redaction is already in progress in this room")
+		return
+	}
+	eventIDs := filter.find(view.content)
+	if len(eventIDs) == 0 {
+		cmd.Reply("No messages %s found in the last %d messages", filter, filter.limit)
+		return
+	}
+	text := fmt.Sprintf("Redact %d messages %s in %s?", len(eventIDs), filter, view.Room.GetTitle())
+	if len(reason) > 0 {
+		text += fmt.Sprintf("\n\nReason: %s", reason)
+	}
+	if !cmd.MainView.AskConfirmation("Bulk redaction", text, bulkRedactConfirmTimeout) {
+		cmd.Reply("Bulk redaction cancelled")
+		return
+	}
+	if !view.startRedacting(len(eventIDs)) {
+		cmd.Reply("A bulk redaction is already in progress in this room")
+		return
+	}
+	view.status.SetText(view.GetStatus())
+	cmd.UI.Render()
+	redacted, errs := cmd.Matrix.RedactMany(view.Room.ID, eventIDs, reason, func(done int) {
+		view.setRedactingProgress(done)
+		view.status.SetText(view.GetStatus())
+		cmd.UI.Render()
+	})
+	view.finishRedacting()
+	view.status.SetText(view.GetStatus())
+	if len(errs) > 0 {
+		cmd.Reply("Redacted %d/%d messages, %d failed: %v", redacted, len(eventIDs), len(errs), errs[0])
+	} else {
+		cmd.Reply("Redacted %d messages", redacted)
+	}
+}
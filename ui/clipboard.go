@@ -0,0 +1,141 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/zyedidia/clipboard"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+// isRemoteSession checks whether gomuks is running over SSH, in which case the system clipboard
+// would be the one of the remote host rather than the one of the user's terminal.
+func isRemoteSession() bool {
+	return len(os.Getenv("SSH_TTY")) > 0 || len(os.Getenv("SSH_CONNECTION")) > 0
+}
+
+// writeOSC52 asks the terminal emulator to put the given text into the clipboard
+// using the OSC 52 escape sequence. Terminals that don't support it ignore the sequence.
+func writeOSC52(text, register string) error {
+	selection := "c"
+	if register == "primary" {
+		selection = "p"
+	}
+	seq := "\x1b]52;" + selection + ";" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	if len(os.Getenv("TMUX")) > 0 {
+		// tmux only forwards escape sequences to the outer terminal inside a passthrough sequence.
+		seq = "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	_, err = tty.WriteString(seq)
+	return err
+}
+
+// writeClipboard copies text into the given clipboard register, falling back to OSC 52
+// if there's no usable system clipboard.
+func writeClipboard(text, register string) error {
+	if isRemoteSession() {
+		return writeOSC52(text, register)
+	}
+	err := clipboard.WriteAll(text, register)
+	if err != nil {
+		debug.Print("Failed to write to system clipboard, falling back to OSC 52:", err)
+		return writeOSC52(text, register)
+	}
+	return nil
+}
+
+var errNoClipboardImage = errors.New("there's no image in the clipboard")
+
+// preferredImageTypes are the clipboard formats tried first when the clipboard offers several image types.
+var preferredImageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+func pickImageType(types string) string {
+	available := strings.Fields(types)
+	for _, preferred := range preferredImageTypes {
+		for _, mimetype := range available {
+			if mimetype == preferred {
+				return mimetype
+			}
+		}
+	}
+	for _, mimetype := range available {
+		if strings.HasPrefix(mimetype, "image/") {
+			return mimetype
+		}
+	}
+	return ""
+}
+
+var osascriptPNGRegex = regexp.MustCompile(`«data PNGf([0-9A-Fa-f]+)»`)
+
+// readClipboardImage reads an image from the system clipboard using wl-paste on Wayland, xclip on X11
+// and osascript on macOS (pbpaste only supports text). Returns errNoClipboardImage if the clipboard
+// doesn't contain an image.
+func readClipboardImage() ([]byte, string, error) {
+	switch {
+	case runtime.GOOS == "darwin":
+		out, err := exec.Command("osascript", "-e", "get the clipboard as «class PNGf»").Output()
+		if err != nil {
+			return nil, "", errNoClipboardImage
+		}
+		match := osascriptPNGRegex.FindSubmatch(bytes.TrimSpace(out))
+		if match == nil {
+			return nil, "", errNoClipboardImage
+		}
+		data, err := hex.DecodeString(string(match[1]))
+		return data, "image/png", err
+	case len(os.Getenv("WAYLAND_DISPLAY")) > 0:
+		types, err := exec.Command("wl-paste", "--list-types").Output()
+		if err != nil {
+			return nil, "", err
+		}
+		mimetype := pickImageType(string(types))
+		if len(mimetype) == 0 {
+			return nil, "", errNoClipboardImage
+		}
+		data, err := exec.Command("wl-paste", "--no-newline", "--type", mimetype).Output()
+		return data, mimetype, err
+	case len(os.Getenv("DISPLAY")) > 0:
+		types, err := exec.Command("xclip", "-selection", "clipboard", "-target", "TARGETS", "-out").Output()
+		if err != nil {
+			return nil, "", err
+		}
+		mimetype := pickImageType(string(types))
+		if len(mimetype) == 0 {
+			return nil, "", errNoClipboardImage
+		}
+		data, err := exec.Command("xclip", "-selection", "clipboard", "-target", mimetype, "-out").Output()
+		return data, mimetype, err
+	default:
+		return nil, "", errors.New("no supported clipboard found")
+	}
+}
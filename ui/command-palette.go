@@ -0,0 +1,311 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/lithammer/fuzzysearch/fuzzy"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/ui/widget"
+)
+
+type paletteEntry struct {
+	Title string
+	Help  string
+	// The text the search query is matched against.
+	search string
+	run    func()
+}
+
+// paletteKeyAction is a keybinding of the main view that can be triggered from the command palette.
+type paletteKeyAction struct {
+	Keys string
+	Help string
+	Key  tcell.Key
+	Rune rune
+}
+
+var paletteKeyActions = []paletteKeyAction{
+	{"Alt+K", "Quick room switcher", tcell.KeyRune, 'k'},
+	{"Alt+A", "Switch to the next room with activity", tcell.KeyRune, 'a'},
+	{"Alt+Down", "Switch to the next room", tcell.KeyDown, 0},
+	{"Alt+Up", "Switch to the previous room", tcell.KeyUp, 0},
+	{"Alt+R", "Move focus to the room list", tcell.KeyRune, 'r'},
+	{"Alt+Shift+S", "Cycle the sort mode of the room list", tcell.KeyRune, 'S'},
+	{"Alt+U", "Move focus to the member list", tcell.KeyRune, 'u'},
+	{"Alt+F", "Jump to the read marker", tcell.KeyRune, 'f'},
+	{"Alt+G", "Jump to the first unread message", tcell.KeyRune, 'g'},
+	{"Alt+T", "Jump to the first message of today", tcell.KeyRune, 't'},
+	{"Alt+P", "Jump to the previous mention", tcell.KeyRune, 'p'},
+	{"Alt+N", "Jump to the next mention", tcell.KeyRune, 'n'},
+	{"Alt+Home", "Scroll to the top of the loaded history", tcell.KeyHome, 0},
+	{"Alt+End", "Scroll to the bottom", tcell.KeyEnd, 0},
+	{"Alt+L", "Show the room as plain text for copying", tcell.KeyRune, 'l'},
+	{"Alt+M", "Preview how the message being composed will look", tcell.KeyRune, 'm'},
+	{"Alt+O", "Number the links on the screen for opening them", tcell.KeyRune, 'o'},
+	{"Alt+Z", "Undo sending the last message", tcell.KeyRune, 'z'},
+	{"Alt+V", "Split the pane side by side", tcell.KeyRune, 'v'},
+	{"Alt+B", "Split the pane into top and bottom", tcell.KeyRune, 'b'},
+	{"Alt+X", "Close the pane", tcell.KeyRune, 'x'},
+	{"Alt+W", "Move to the next pane", tcell.KeyRune, 'w'},
+	{"Alt+Space", "Pause or resume the audio player", tcell.KeyRune, ' '},
+	{"Alt+S", "Stop the audio player", tcell.KeyRune, 's'},
+}
+
+// parseHelpCommands extracts the slash commands and their descriptions from the help text.
+func parseHelpCommands(text string) (entries []*paletteEntry) {
+	var current *paletteEntry
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "/"):
+			current = &paletteEntry{Title: trimmed}
+			if sep := strings.Index(trimmed, " - "); sep != -1 {
+				current.Title = strings.TrimSpace(trimmed[:sep])
+				current.Help = strings.TrimSpace(trimmed[sep+3:])
+			}
+			entries = append(entries, current)
+		case current != nil && len(trimmed) > 0 && line != trimmed:
+			current.Help = strings.TrimSpace(current.Help + " " + strings.TrimPrefix(trimmed, "- "))
+		default:
+			current = nil
+		}
+	}
+	return
+}
+
+// CommandPalette is a modal for fuzzy searching and running commands, keybinding actions and rooms.
+type CommandPalette struct {
+	mauview.Component
+
+	container *mauview.Box
+	search    *mauview.InputArea
+
+	entries  []*paletteEntry
+	matches  []*paletteEntry
+	selected int
+	scroll   int
+
+	parent *MainView
+}
+
+func NewCommandPalette(mainView *MainView, width, height int) *CommandPalette {
+	cp := &CommandPalette{
+		parent: mainView,
+	}
+	cp.initEntries()
+
+	cp.search = mauview.NewInputArea().
+		SetChangedFunc(cp.changeHandler).
+		SetPlaceholder("Search commands, keybindings and rooms").
+		SetTextColor(tcell.ColorWhite).
+		SetBackgroundColor(tcell.ColorDarkCyan)
+	cp.search.Focus()
+
+	flex := mauview.NewFlex().
+		SetDirection(mauview.FlexRow).
+		AddFixedComponent(cp.search, 1).
+		AddProportionalComponent(&paletteList{palette: cp}, 1)
+
+	cp.container = mauview.NewBox(flex).
+		SetBorder(true).
+		SetTitle("Command Palette").
+		SetBlurCaptureFunc(func() bool {
+			cp.parent.HideModal()
+			return true
+		})
+
+	cp.Component = mauview.Center(cp.container, width, height).SetAlwaysFocusChild(true)
+	cp.changeHandler("")
+
+	return cp
+}
+
+func (cp *CommandPalette) initEntries() {
+	commands := parseHelpCommands(helpText)
+	documented := make(map[string]bool)
+	for _, entry := range commands {
+		name := strings.Fields(entry.Title)[0][1:]
+		documented[name] = true
+		cp.addCommand(entry)
+	}
+	var undocumented []string
+	for name := range cp.parent.cmdProcessor.commands {
+		if !documented[name] && name != "unknown-command" {
+			undocumented = append(undocumented, name)
+		}
+	}
+	sort.Strings(undocumented)
+	for _, name := range undocumented {
+		cp.addCommand(&paletteEntry{Title: "/" + name})
+	}
+
+	for _, action := range paletteKeyActions {
+		action := action
+		cp.entries = append(cp.entries, &paletteEntry{
+			Title: action.Keys,
+			Help:  action.Help,
+			run: func() {
+				cp.parent.OnKeyEvent(tcell.NewEventKey(action.Key, action.Rune, tcell.ModAlt, ""))
+			},
+		})
+	}
+
+	cp.parent.roomsLock.RLock()
+	for _, roomView := range cp.parent.rooms {
+		room := roomView.Room
+		if room.IsReplaced() {
+			continue
+		}
+		cp.entries = append(cp.entries, &paletteEntry{
+			Title: room.GetTitle(),
+			Help:  "Switch to room",
+			run: func() {
+				cp.parent.SwitchRoom("", room)
+			},
+		})
+	}
+	cp.parent.roomsLock.RUnlock()
+
+	for _, entry := range cp.entries {
+		entry.search = entry.Title + " " + entry.Help
+	}
+}
+
+// addCommand adds a slash command to the palette. Commands that don't need any arguments are run right away
+// without the optional arguments, while others are inserted into the input field of the current room up to
+// the first argument so that the arguments can be filled in.
+func (cp *CommandPalette) addCommand(entry *paletteEntry) {
+	text := entry.Title
+	if args := strings.IndexAny(text, "[<"); args != -1 {
+		text = strings.TrimSpace(text[:args])
+	}
+	needsArgs := strings.ContainsRune(entry.Title, '<')
+	entry.run = func() {
+		room := cp.parent.currentRoom
+		if room == nil {
+			return
+		} else if needsArgs {
+			room.input.SetTextAndMoveCursor(text + " ")
+			room.SetVimInsert(true)
+		} else if cmd := cp.parent.cmdProcessor.ParseCommand(room, text); cmd != nil {
+			go cp.parent.cmdProcessor.HandleCommand(cmd)
+		}
+	}
+	cp.entries = append(cp.entries, entry)
+}
+
+func (cp *CommandPalette) changeHandler(str string) {
+	cp.selected = 0
+	cp.scroll = 0
+	if len(str) == 0 {
+		cp.matches = cp.entries
+		return
+	}
+	targets := make([]string, len(cp.entries))
+	for i, entry := range cp.entries {
+		targets[i] = entry.search
+	}
+	ranks := fuzzy.RankFindFold(str, targets)
+	sort.Sort(ranks)
+	cp.matches = make([]*paletteEntry, len(ranks))
+	for i, rank := range ranks {
+		cp.matches[i] = cp.entries[rank.OriginalIndex]
+	}
+}
+
+func (cp *CommandPalette) Focus() {
+	cp.container.Focus()
+}
+
+func (cp *CommandPalette) Blur() {
+	cp.container.Blur()
+}
+
+func (cp *CommandPalette) moveSelection(diff int) {
+	if len(cp.matches) == 0 {
+		return
+	}
+	cp.selected = (cp.selected + diff) % len(cp.matches)
+	if cp.selected < 0 {
+		cp.selected += len(cp.matches)
+	}
+}
+
+func (cp *CommandPalette) OnKeyEvent(event mauview.KeyEvent) bool {
+	switch event.Key() {
+	case tcell.KeyEsc:
+		cp.parent.HideModal()
+	case tcell.KeyDown, tcell.KeyTab:
+		cp.moveSelection(1)
+	case tcell.KeyUp, tcell.KeyBacktab:
+		cp.moveSelection(-1)
+	case tcell.KeyEnter:
+		cp.parent.HideModal()
+		if cp.selected < len(cp.matches) {
+			cp.matches[cp.selected].run()
+		}
+	default:
+		return cp.search.OnKeyEvent(event)
+	}
+	return true
+}
+
+// paletteList draws the matching entries of the command palette with their help texts.
+type paletteList struct {
+	palette *CommandPalette
+}
+
+func (pl *paletteList) Draw(screen mauview.Screen) {
+	cp := pl.palette
+	width, height := screen.Size()
+	if cp.selected < cp.scroll {
+		cp.scroll = cp.selected
+	} else if cp.selected >= cp.scroll+height {
+		cp.scroll = cp.selected - height + 1
+	}
+	titleWidth := width / 3
+	for y := 0; y < height && cp.scroll+y < len(cp.matches); y++ {
+		entry := cp.matches[cp.scroll+y]
+		titleStyle := tcell.StyleDefault.Foreground(tcell.ColorWhite)
+		helpStyle := tcell.StyleDefault.Foreground(tcell.ColorGray)
+		if cp.scroll+y == cp.selected {
+			titleStyle = titleStyle.Background(tcell.ColorDarkCyan).Bold(true)
+			helpStyle = helpStyle.Background(tcell.ColorDarkCyan).Foreground(tcell.ColorWhite)
+			widget.WriteLinePadded(screen, mauview.AlignLeft, "", 0, y, width, helpStyle)
+		}
+		widget.WriteLine(screen, mauview.AlignLeft, entry.Title, 0, y, titleWidth-1, titleStyle)
+		widget.WriteLine(screen, mauview.AlignLeft, entry.Help, titleWidth, y, width-titleWidth, helpStyle)
+	}
+}
+
+func (pl *paletteList) OnKeyEvent(event mauview.KeyEvent) bool {
+	return false
+}
+
+func (pl *paletteList) OnPasteEvent(event mauview.PasteEvent) bool {
+	return false
+}
+
+func (pl *paletteList) OnMouseEvent(event mauview.MouseEvent) bool {
+	return false
+}
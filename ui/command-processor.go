@@ -85,33 +85,40 @@ func NewCommandProcessor(parent *MainView) *CommandProcessor {
 			Gomuks:   parent.gmx,
 		},
 		aliases: map[string]*Alias{
-			"part":       {"leave"},
-			"send":       {"sendevent"},
-			"msend":      {"msendevent"},
-			"state":      {"setstate"},
-			"mstate":     {"msetstate"},
-			"rb":         {"rainbow"},
-			"rbme":       {"rainbowme"},
-			"myroomnick": {"roomnick"},
-			"createroom": {"create"},
-			"dm":         {"pm"},
-			"query":      {"pm"},
-			"r":          {"reply"},
-			"delete":     {"redact"},
-			"remove":     {"redact"},
-			"rm":         {"redact"},
-			"del":        {"redact"},
-			"e":          {"edit"},
-			"dl":         {"download"},
-			"o":          {"open"},
-			"4s":         {"ssss"},
-			"s4":         {"ssss"},
-			"cs":         {"cross-signing"},
+			"part":        {"leave"},
+			"send":        {"sendevent"},
+			"msend":       {"msendevent"},
+			"state":       {"setstate"},
+			"mstate":      {"msetstate"},
+			"rb":          {"rainbow"},
+			"rbme":        {"rainbowme"},
+			"myroomnick":  {"roomnick"},
+			"createroom":  {"create"},
+			"dm":          {"pm"},
+			"query":       {"pm"},
+			"whois":       {"profile"},
+			"pl":          {"powerlevels"},
+			"r":           {"reply"},
+			"delete":      {"redact"},
+			"remove":      {"redact"},
+			"rm":          {"redact"},
+			"del":         {"redact"},
+			"e":           {"edit"},
+			"dl":          {"download"},
+			"o":           {"open"},
+			"4s":          {"ssss"},
+			"s4":          {"ssss"},
+			"cs":          {"cross-signing"},
+			"import-keys": {"import"},
+			"export-keys": {"export"},
 		},
 		autocompleters: map[string]CommandAutocompleter{
 			"devices":       autocompleteUser,
 			"device":        autocompleteDevice,
 			"verify":        autocompleteUser,
+			"profile":       autocompleteUser,
+			"op":            autocompleteUser,
+			"deop":          autocompleteUser,
 			"verify-device": autocompleteDevice,
 			"unverify":      autocompleteDevice,
 			"blacklist":     autocompleteDevice,
@@ -121,6 +128,8 @@ func NewCommandProcessor(parent *MainView) *CommandProcessor {
 			"import":        autocompleteFile,
 			"export":        autocompleteFile,
 			"export-room":   autocompleteFile,
+			"import-irc":    autocompleteFile,
+			"template":      autocompleteTemplate,
 		},
 		commands: map[string]CommandHandler{
 			"unknown-command": cmdUnknownCommand,
@@ -146,14 +155,26 @@ func NewCommandProcessor(parent *MainView) *CommandProcessor {
 			"react":      cmdReact,
 			"edit":       cmdEdit,
 			"download":   cmdDownload,
+			"downloads":  cmdDownloads,
+			"paste":      cmdPasteImage,
 			"upload":     cmdUpload,
 			"open":       cmdOpen,
+			"play":       cmdPlay,
+			"videoframe": cmdVideoFrame,
+			"voice":      cmdVoice,
 			"copy":       cmdCopy,
+			"inspect":    cmdInspect,
+			"roominfo":   cmdRoomInfo,
+			"profile":    cmdProfile,
+			"edits":      cmdEditHistory,
 			"sendevent":  cmdSendEvent,
 			"msendevent": cmdMSendEvent,
 			"setstate":   cmdSetState,
 			"msetstate":  cmdMSetState,
 			"roomnick":   cmdRoomNick,
+			"imagescale": cmdImageScale,
+			"codetheme":  cmdCodeTheme,
+			"receipts":   cmdReceipts,
 			"rainbow":    cmdRainbow,
 			"rainbowme":  cmdRainbowMe,
 			"notice":     cmdNotice,
@@ -162,14 +183,72 @@ func NewCommandProcessor(parent *MainView) *CommandProcessor {
 			"tag":        cmdTag,
 			"untag":      cmdUntag,
 			"invite":     cmdInvite,
+			"modqueue":   cmdModQueue,
+			"archive":    cmdArchive,
 			"hprof":      cmdHeapProfile,
 			"cprof":      cmdCPUProfile,
 			"trace":      cmdTrace,
 
+			"notifications": cmdNotifications,
+			"invite-many":   cmdInviteMany,
+			"import-irc":    cmdImportIRC,
+			"connection":    cmdConnection,
+			"predecessor":   cmdPredecessor,
+			"successor":     cmdSuccessor,
+			"sent":          cmdSent,
+			"thread":        cmdThread,
+			"template":      cmdTemplate,
+			"roomsettings":  cmdRoomSettings,
+			"pin":           cmdPin,
+			"unpin":         cmdUnpin,
+			"pins":          cmdPins,
+			"typing":        cmdTyping,
+			"display":       cmdDisplay,
+			"links":         cmdLinks,
+			"pane":          cmdPane,
+			"powerlevels":   cmdPowerLevels,
+			"op":            cmdOp,
+			"deop":          cmdDeop,
+			"roomsort":      cmdRoomSort,
+			"urlpreviews":   cmdURLPreviews,
+			"sendercolor":   cmdSenderColor,
+			"search":        cmdSearch,
+			"goto":          cmdGoto,
+			"permalink":     cmdPermalink,
+			"date":          cmdDate,
+
+			"export-session": cmdExportSession,
+			"nowplaying":     cmdNowPlaying,
+			"send-later":     cmdSendLater,
+			"send-queue":     cmdSendQueue,
+			"guidance":       cmdGuidance,
+			"purgecache":     cmdPurgeCache,
+			"cache":          cmdCache,
+			"prune":          cmdPrune,
+			"poll":           cmdPoll,
+			"vote":           cmdVote,
+			"unvote":         cmdUnvote,
+			"endpoll":        cmdEndPoll,
+			"location":       cmdLocation,
+			"sticker":        cmdSticker,
+			"sticky":         cmdSticky,
+			"unsticky":       cmdUnsticky,
+			"roomstate":      cmdRoomState,
+			"rawsend":        cmdRawSend,
+			"rawstate":       cmdRawState,
+			"accountdata":    cmdAccountData,
+			"yank":           cmdYank,
+			"spoiler":        cmdSpoiler,
+			"expand":         cmdExpand,
+			"translate":      cmdTranslate,
+
 			"fingerprint":   cmdFingerprint,
 			"devices":       cmdDevices,
+			"rename-device": cmdRenameDevice,
+			"delete-device": cmdDeleteDevice,
 			"verify-device": cmdVerifyDevice,
 			"verify":        cmdVerify,
+			"verify-qr":     cmdVerifyQR,
 			"device":        cmdDevice,
 			"unverify":      cmdUnverify,
 			"blacklist":     cmdBlacklist,
@@ -177,8 +256,12 @@ func NewCommandProcessor(parent *MainView) *CommandProcessor {
 			"import":        cmdImportKeys,
 			"export":        cmdExportKeys,
 			"export-room":   cmdExportRoomKeys,
+			"request-keys":  cmdRequestKeys,
 			"ssss":          cmdSSSS,
 			"cross-signing": cmdCrossSigning,
+
+			"enable-encryption": cmdEnableEncryption,
+			"dehydrate":         cmdDehydrate,
 		},
 	}
 }
@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"io"
 	"math"
 	"os"
@@ -42,7 +43,11 @@ import (
 	"maunium.net/go/mautrix/format"
 	"maunium.net/go/mautrix/id"
 
+	"maunium.net/go/gomuks/config"
 	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/matrix/rooms"
+	"maunium.net/go/gomuks/ui/messages"
 )
 
 func cmdMe(cmd *Command) {
@@ -113,10 +118,159 @@ func cmdRainbowMe(cmd *Command) {
 	makeRainbow(cmd, event.MsgEmote)
 }
 
+func cmdSpoiler(cmd *Command) {
+	text := strings.Join(cmd.Args, " ")
+	reason := ""
+	if parts := strings.SplitN(text, "|", 2); len(parts) == 2 {
+		reason = strings.TrimSpace(parts[0])
+		text = strings.TrimSpace(parts[1])
+	}
+	if len(text) == 0 {
+		cmd.Reply("Usage: /spoiler [reason |] <text>")
+		return
+	}
+	prefs := cmd.Config.Preferences
+	content := format.RenderMarkdown(text, !prefs.DisableMarkdown, !prefs.DisableHTML)
+	htmlBody := content.FormattedBody
+	if content.Format != event.FormatHTML {
+		htmlBody = html.EscapeString(content.Body)
+	}
+	body := fmt.Sprintf("[Spoiler] %s", content.Body)
+	if len(reason) > 0 {
+		body = fmt.Sprintf("[Spoiler: %s] %s", reason, content.Body)
+	}
+	htmlBody = fmt.Sprintf(`<span data-mx-spoiler="%s">%s</span>`, html.EscapeString(reason), htmlBody)
+	go cmd.Room.SendMessageHTML(event.MsgText, body, htmlBody)
+}
+
 func cmdNotice(cmd *Command) {
 	go cmd.Room.SendMessage(event.MsgNotice, strings.Join(cmd.Args, " "))
 }
 
+// parseLocationArgs parses a location given as a geo: URI, "lat,lon" or "lat lon", followed by an optional description.
+func parseLocationArgs(args []string) (geoURI, description string, ok bool) {
+	if len(args) == 0 {
+		return "", "", false
+	}
+	coords := args[0]
+	rest := args[1:]
+	if !strings.HasPrefix(coords, "geo:") {
+		if len(args) > 1 && !strings.Contains(coords, ",") {
+			coords = args[0] + "," + args[1]
+			rest = args[2:]
+		} else if len(args) > 1 && strings.HasSuffix(coords, ",") {
+			coords = args[0] + args[1]
+			rest = args[2:]
+		}
+		coords = "geo:" + coords
+	}
+	if _, _, err := messages.ParseGeoURI(coords); err != nil {
+		return "", "", false
+	}
+	return coords, strings.Join(rest, " "), true
+}
+
+func cmdLocation(cmd *Command) {
+	geoURI, description, ok := parseLocationArgs(cmd.Args)
+	if !ok {
+		cmd.Reply("Usage: /location <latitude>,<longitude> [description]")
+		return
+	}
+	go cmd.Room.SendLocation(geoURI, description)
+}
+
+// parseSendTime parses a delay like "10m" or "1h30m", a time of day like "18:30" (today, or tomorrow if it
+// has already passed) or a date and time like "2021-01-02T18:30".
+func parseSendTime(value string, now time.Time) (time.Time, bool) {
+	if delay, err := time.ParseDuration(value); err == nil && delay > 0 {
+		return now.Add(delay), true
+	}
+	if t, err := time.ParseInLocation("15:04", value, now.Location()); err == nil {
+		sendAt := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if !sendAt.After(now) {
+			sendAt = sendAt.AddDate(0, 0, 1)
+		}
+		return sendAt, true
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04", value, now.Location()); err == nil && t.After(now) {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+func cmdSendLater(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		scheduled := cmd.Matrix.ScheduledEvents(cmd.Room.Room.ID)
+		if len(scheduled) == 0 {
+			cmd.Reply("No messages scheduled in this room")
+			return
+		}
+		var buf strings.Builder
+		buf.WriteString("Scheduled messages:")
+		for i, evt := range scheduled {
+			_, _ = fmt.Fprintf(&buf, "\n%d. %s (in %s) - %s", i+1, evt.SendAt.Format("2006-01-02 15:04"),
+				time.Until(evt.SendAt).Round(time.Second), evt.Body)
+		}
+		cmd.Reply("%s", buf.String())
+		return
+	} else if cmd.Args[0] == "cancel" {
+		scheduled := cmd.Matrix.ScheduledEvents(cmd.Room.Room.ID)
+		index := -1
+		if len(cmd.Args) == 2 {
+			index, _ = strconv.Atoi(cmd.Args[1])
+			index--
+		}
+		if index < 0 || index >= len(scheduled) {
+			cmd.Reply("Usage: /%s cancel <number from /%s>", cmd.OrigCommand, cmd.OrigCommand)
+		} else if cmd.Matrix.CancelScheduledEvent(scheduled[index].TransactionID) {
+			cmd.Reply("Cancelled scheduled message %d", index+1)
+		} else {
+			cmd.Reply("That message has already been sent")
+		}
+		return
+	}
+	sendAt, ok := parseSendTime(cmd.Args[0], time.Now())
+	text := strings.TrimSpace(strings.TrimPrefix(cmd.RawArgs, cmd.Args[0]))
+	if !ok || len(text) == 0 {
+		cmd.Reply("Usage: /%s <duration|HH:MM|YYYY-MM-DDTHH:MM> <message>", cmd.OrigCommand)
+		return
+	}
+	cmd.Room.ScheduleMessage(text, sendAt)
+	cmd.Reply("Message scheduled for %s", sendAt.Format("2006-01-02 15:04"))
+}
+
+func cmdSendQueue(cmd *Command) {
+	queued := cmd.Matrix.QueuedEventList(cmd.Room.Room.ID)
+	if len(cmd.Args) == 0 {
+		if len(queued) == 0 {
+			cmd.Reply("No messages waiting to be sent in this room")
+			return
+		}
+		var buf strings.Builder
+		buf.WriteString("Messages waiting to be sent:")
+		for i, evt := range queued {
+			_, _ = fmt.Fprintf(&buf, "\n%d. %s", i+1, evt.Body)
+			if evt.Attempts > 0 {
+				_, _ = fmt.Fprintf(&buf, " (%d failed attempts, last error: %s)", evt.Attempts, evt.LastError)
+			}
+		}
+		cmd.Reply("%s", buf.String())
+		return
+	}
+	index := -1
+	if len(cmd.Args) == 2 && cmd.Args[0] == "discard" {
+		index, _ = strconv.Atoi(cmd.Args[1])
+		index--
+	}
+	if index < 0 || index >= len(queued) {
+		cmd.Reply("Usage: /%s [discard <number from /%s>]", cmd.OrigCommand, cmd.OrigCommand)
+	} else if cmd.Room.DiscardQueued(queued[index].TransactionID) {
+		cmd.Reply("Discarded queued message %d", index+1)
+	} else {
+		cmd.Reply("That message has already been sent")
+	}
+}
+
 func cmdAccept(cmd *Command) {
 	room := cmd.Room.MxRoom()
 	if room.SessionMember.Membership != "invite" {
@@ -156,13 +310,29 @@ func cmdID(cmd *Command) {
 type SelectReason string
 
 const (
-	SelectReply    SelectReason = "reply to"
-	SelectReact                 = "react to"
-	SelectRedact                = "redact"
-	SelectEdit                  = "edit"
-	SelectDownload              = "download"
-	SelectOpen                  = "open"
-	SelectCopy                  = "copy"
+	SelectReply       SelectReason = "reply to"
+	SelectReact                    = "react to"
+	SelectRedact                   = "redact"
+	SelectEdit                     = "edit"
+	SelectDownload                 = "download"
+	SelectOpen                     = "open"
+	SelectPlay                     = "play"
+	SelectVideoFrame               = "extract a preview frame of"
+	SelectCopy                     = "copy"
+	SelectInspect                  = "inspect"
+	SelectRequestKeys              = "request keys for"
+	SelectThread                   = "open thread of"
+	SelectEditHistory              = "view edit history of"
+	SelectPin                      = "pin"
+	SelectUnpin                    = "unpin"
+	SelectPermalink                = "copy link to"
+	SelectGoto                     = "follow link in"
+	SelectVote                     = "vote in"
+	SelectEndPoll                  = "end poll"
+	SelectSticky                   = "stick to the top"
+	SelectYank                     = "yank"
+	SelectExpand                   = "expand or collapse"
+	SelectProfile                  = "view the sender profile of"
 )
 
 func cmdReply(cmd *Command) {
@@ -174,7 +344,44 @@ func cmdEdit(cmd *Command) {
 }
 
 func cmdRedact(cmd *Command) {
-	cmd.Room.StartSelecting(SelectRedact, strings.Join(cmd.Args, " "))
+	args := cmd.Args
+	if len(args) > 0 && args[0] == "--" {
+		// A reason that starts with -- for redacting a single message.
+		args = args[1:]
+	} else if len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		cmdBulkRedact(cmd)
+		return
+	}
+	cmd.Room.StartSelecting(SelectRedact, strings.Join(args, " "))
+}
+
+func cmdProfile(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmd.Room.StartSelecting(SelectProfile, "")
+		return
+	}
+	userID := id.UserID(cmd.Args[0])
+	if _, _, err := userID.Parse(); err != nil {
+		cmd.Reply("%s isn't a valid user ID", userID)
+		return
+	}
+	cmd.MainView.ShowModal(NewProfileModal(cmd.MainView, cmd.Room.Room, userID))
+}
+
+func cmdInspect(cmd *Command) {
+	cmd.Room.StartSelecting(SelectInspect, "")
+}
+
+func cmdExpand(cmd *Command) {
+	cmd.Room.StartSelecting(SelectExpand, "")
+}
+
+func cmdEditHistory(cmd *Command) {
+	cmd.Room.StartSelecting(SelectEditHistory, "")
+}
+
+func cmdRequestKeys(cmd *Command) {
+	cmd.Room.StartSelecting(SelectRequestKeys, "")
 }
 
 func cmdDownload(cmd *Command) {
@@ -183,7 +390,12 @@ func cmdDownload(cmd *Command) {
 
 func cmdUpload(cmd *Command) {
 	if len(cmd.Args) == 0 {
-		cmd.Reply("Usage: /upload <file>")
+		cmd.Reply("Usage: /upload <file> or /upload --cancel")
+		return
+	} else if cmd.RawArgs == "--cancel" {
+		if cancelled := cmd.Room.CancelUploads(); cancelled == 0 {
+			cmd.Reply("No uploads in progress")
+		}
 		return
 	}
 
@@ -196,6 +408,10 @@ func cmdUpload(cmd *Command) {
 	go cmd.Room.SendMessageMedia(path)
 }
 
+func cmdVideoFrame(cmd *Command) {
+	cmd.Room.StartSelecting(SelectVideoFrame, "")
+}
+
 func cmdOpen(cmd *Command) {
 	cmd.Room.StartSelecting(SelectOpen, strings.Join(cmd.Args, " "))
 }
@@ -213,11 +429,6 @@ func cmdCopy(cmd *Command) {
 }
 
 func cmdReact(cmd *Command) {
-	if len(cmd.Args) == 0 {
-		cmd.Reply("Usage: /react <reaction>")
-		return
-	}
-
 	cmd.Room.StartSelecting(SelectReact, strings.Join(cmd.Args, " "))
 }
 
@@ -377,6 +588,299 @@ func cmdRoomNick(cmd *Command) {
 	}
 }
 
+const imageScaleHelp = `Usage: /%s <scale> [max rows] - Set the inline image scale and height limit for this room.
+       /%[1]s reset              - Use the default image size in this room.`
+
+func cmdImageScale(cmd *Command) {
+	room := cmd.Room.MxRoom()
+	if len(cmd.Args) == 0 {
+		if room.ImageScale == 0 && room.MaxImageRows == 0 {
+			cmd.Reply("This room uses the default image size")
+		} else {
+			cmd.Reply("Image scale: %.2f, max rows: %d", room.ImageScale, room.MaxImageRows)
+		}
+		return
+	} else if cmd.Args[0] == "reset" {
+		room.ImageScale = 0
+		room.MaxImageRows = 0
+		cmd.Room.applyImageSize()
+		cmd.Reply("Image size reset to default")
+		return
+	}
+	scale, err := strconv.ParseFloat(cmd.Args[0], 64)
+	if err != nil || scale <= 0 {
+		cmd.Reply(imageScaleHelp, cmd.OrigCommand)
+		return
+	}
+	maxRows := 0
+	if len(cmd.Args) > 1 {
+		maxRows, err = strconv.Atoi(cmd.Args[1])
+		if err != nil || maxRows < 0 {
+			cmd.Reply(imageScaleHelp, cmd.OrigCommand)
+			return
+		}
+	}
+	room.ImageScale = scale
+	room.MaxImageRows = maxRows
+	cmd.Room.applyImageSize()
+	cmd.Reply("Image scale set to %.2f, max rows %d", scale, maxRows)
+}
+
+func receiptMode(room *rooms.Room) string {
+	if room.PrivateReceipts {
+		return "private"
+	}
+	return "public"
+}
+
+func cmdReceipts(cmd *Command) {
+	room := cmd.Room.MxRoom()
+	if len(cmd.Args) == 0 {
+		cmd.Reply("Read receipts in this room are %s", receiptMode(room))
+		return
+	}
+	switch strings.ToLower(cmd.Args[0]) {
+	case "public":
+		room.PrivateReceipts = false
+	case "private":
+		room.PrivateReceipts = true
+	default:
+		cmd.Reply("Usage: /%s <public|private>", cmd.OrigCommand)
+		return
+	}
+	cmd.Reply("Read receipts in this room are now %s", receiptMode(room))
+}
+
+func typingMode(room *rooms.Room) string {
+	switch room.TypingNotifs {
+	case rooms.TypingNotifsOn, rooms.TypingNotifsOff:
+		return room.TypingNotifs
+	default:
+		return "default"
+	}
+}
+
+func cmdTyping(cmd *Command) {
+	room := cmd.Room.MxRoom()
+	if len(cmd.Args) == 0 {
+		current := "sent"
+		if !cmd.Room.sendsTyping() {
+			current = "not sent"
+		}
+		cmd.Reply("Typing notifications in this room: %s (currently %s)", typingMode(room), current)
+		return
+	}
+	switch strings.ToLower(cmd.Args[0]) {
+	case "on":
+		room.TypingNotifs = rooms.TypingNotifsOn
+	case "off":
+		room.TypingNotifs = rooms.TypingNotifsOff
+	case "default":
+		room.TypingNotifs = ""
+	default:
+		cmd.Reply("Usage: /%s <on|off|default>", cmd.OrigCommand)
+		return
+	}
+	if !cmd.Room.sendsTyping() {
+		cmd.Matrix.SendTyping(room.ID, false)
+	}
+	cmd.Reply("Typing notifications in this room are now %s", typingMode(room))
+}
+
+func cmdPane(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmd.Reply("Usage: /%s <side|below|close|next|prev>", cmd.OrigCommand)
+		return
+	}
+	switch strings.ToLower(cmd.Args[0]) {
+	case "side":
+		cmd.MainView.SplitPane(true)
+	case "below":
+		cmd.MainView.SplitPane(false)
+	case "close":
+		if !cmd.MainView.ClosePane() {
+			cmd.Reply("The last pane can't be closed")
+		}
+	case "next":
+		cmd.MainView.CyclePane(true)
+	case "prev":
+		cmd.MainView.CyclePane(false)
+	default:
+		cmd.Reply("Usage: /%s <side|below|close|next|prev>", cmd.OrigCommand)
+	}
+}
+
+func cmdLinks(cmd *Command) {
+	if len(cmd.Args) > 0 {
+		number, err := strconv.Atoi(cmd.Args[0])
+		if err != nil {
+			cmd.Reply("Usage: /%s [number]", cmd.OrigCommand)
+			return
+		}
+		cmd.Room.OpenLink(number)
+	} else if !cmd.Room.ShowLinkHints() {
+		cmd.Reply("There are no links on the screen.")
+	}
+}
+
+const displayHelp = `Usage: /%s <compact|cozy|grouped> [minutes] - Set the display mode, optionally with the grouping interval.
+       /%[1]s room <compact|cozy|grouped|default> - Override the display mode in this room.`
+
+func isDisplayMode(mode string) bool {
+	return mode == config.DisplayModeCompact || mode == config.DisplayModeCozy || mode == config.DisplayModeGrouped
+}
+
+func displayMode(mode string) string {
+	if len(mode) == 0 {
+		return config.DisplayModeCozy
+	}
+	return mode
+}
+
+func cmdDisplay(cmd *Command) {
+	room := cmd.Room.MxRoom()
+	prefs := &cmd.Config.Preferences
+	if len(cmd.Args) == 0 {
+		if len(room.DisplayMode) > 0 {
+			cmd.Reply("Display mode: %s in this room, %s elsewhere", room.DisplayMode, displayMode(prefs.DisplayMode))
+		} else {
+			cmd.Reply("Display mode: %s", displayMode(prefs.DisplayMode))
+		}
+		return
+	}
+	mode := strings.ToLower(cmd.Args[0])
+	if mode == "room" {
+		if len(cmd.Args) < 2 {
+			cmd.Reply(displayHelp, cmd.OrigCommand)
+			return
+		}
+		mode = strings.ToLower(cmd.Args[1])
+		if mode == "default" {
+			room.DisplayMode = ""
+			cmd.Reply("This room now uses the default display mode")
+		} else if isDisplayMode(mode) {
+			room.DisplayMode = mode
+			cmd.Reply("Display mode in this room set to %s", mode)
+		} else {
+			cmd.Reply(displayHelp, cmd.OrigCommand)
+		}
+		return
+	} else if !isDisplayMode(mode) {
+		cmd.Reply(displayHelp, cmd.OrigCommand)
+		return
+	}
+	if len(cmd.Args) > 1 {
+		minutes, err := strconv.Atoi(cmd.Args[1])
+		if err != nil || minutes <= 0 {
+			cmd.Reply(displayHelp, cmd.OrigCommand)
+			return
+		}
+		prefs.GroupMinutes = minutes
+	}
+	prefs.DisplayMode = mode
+	if mode == config.DisplayModeGrouped {
+		cmd.Reply("Display mode set to grouped (%d minutes)", int(prefs.GetGroupInterval().Minutes()))
+	} else {
+		cmd.Reply("Display mode set to %s", mode)
+	}
+	go cmd.Matrix.SendPreferencesToMatrix()
+}
+
+func cmdTranslate(cmd *Command) {
+	room := cmd.Room.MxRoom()
+	if len(cmd.Args) == 0 {
+		if len(room.TranslateTo) == 0 {
+			cmd.Reply("Outgoing messages in this room are not translated")
+		} else {
+			cmd.Reply("Outgoing messages in this room are translated into %s (original included: %t)",
+				room.TranslateTo, room.TranslateIncludeOriginal)
+		}
+		return
+	} else if strings.ToLower(cmd.Args[0]) == "off" {
+		room.TranslateTo = ""
+		room.TranslateIncludeOriginal = false
+		cmd.Reply("Outgoing messages in this room will no longer be translated")
+		return
+	} else if len(cmd.Config.Translation.GetCommand(room.ID)) == 0 {
+		cmd.Reply("No translation command is configured. Set translation.command in config.yaml to use this.")
+		return
+	}
+	lang := cmd.Args[0]
+	includeOriginal := false
+	for _, arg := range cmd.Args[1:] {
+		if arg == "--original" {
+			includeOriginal = true
+		} else {
+			cmd.Reply("Usage: /%s [<language> [--original] | off]", cmd.OrigCommand)
+			return
+		}
+	}
+	room.TranslateTo = lang
+	room.TranslateIncludeOriginal = includeOriginal
+	if includeOriginal {
+		cmd.Reply("Outgoing messages in this room will be translated into %s with the original included", lang)
+	} else {
+		cmd.Reply("Outgoing messages in this room will be translated into %s", lang)
+	}
+}
+
+func cmdNowPlaying(cmd *Command) {
+	np := cmd.Config.NowPlaying
+	if np == nil || len(np.Command) == 0 {
+		cmd.Reply("The now playing integration is not configured. Set now_playing.command in config.yaml to use it.")
+		return
+	}
+	if len(cmd.Args) == 0 {
+		state := "off"
+		if np.Enabled {
+			state = "on"
+		}
+		current := cmd.Matrix.NowPlaying()
+		if len(current) == 0 {
+			current = "none"
+		}
+		cmd.Reply("Now playing status updates are %s (current status: %s)", state, current)
+		return
+	}
+	switch strings.ToLower(cmd.Args[0]) {
+	case "on":
+		cmd.Matrix.SetNowPlayingEnabled(true)
+		cmd.Reply("Now playing status updates enabled")
+	case "off":
+		cmd.Matrix.SetNowPlayingEnabled(false)
+		cmd.Reply("Now playing status updates disabled and status message cleared")
+	default:
+		cmd.Reply("Usage: /%s [on|off]", cmd.OrigCommand)
+	}
+}
+
+func cmdRoomSettings(cmd *Command) {
+	room := cmd.Room.MxRoom()
+	imageSize := "default"
+	if room.ImageScale != 0 || room.MaxImageRows != 0 {
+		imageSize = fmt.Sprintf("scale %.2f, max rows %d", room.ImageScale, room.MaxImageRows)
+	}
+	guidance := cmd.Room.composerGuidance()
+	if len(guidance) == 0 {
+		guidance = "none"
+	}
+	translation := "off"
+	if len(room.TranslateTo) > 0 {
+		translation = room.TranslateTo
+		if room.TranslateIncludeOriginal {
+			translation += ", original included"
+		}
+	}
+	cmd.Reply("Local settings of %s:\n"+
+		"Read receipts: %s (/receipts)\n"+
+		"Typing notifications: %s (/typing)\n"+
+		"Link previews: %s (/urlpreviews)\n"+
+		"Image size: %s (/imagescale)\n"+
+		"Composer guidance: %s (/guidance)\n"+
+		"Outgoing translation: %s (/translate)",
+		room.GetTitle(), receiptMode(room), typingMode(room), urlPreviewMode(room), imageSize, guidance, translation)
+}
+
 func cmdFingerprint(cmd *Command) {
 	c := cmd.Matrix.Crypto()
 	if c == nil {
@@ -449,6 +953,116 @@ func cmdClearCache(cmd *Command) {
 	cmd.Gomuks.Stop(false)
 }
 
+// formatSize formats a number of bytes with a binary unit suffix.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func cmdPurgeCache(cmd *Command) {
+	var roomID id.RoomID
+	var olderThan time.Duration
+	usage := func() {
+		cmd.Reply("Usage: /%s <here|all|room ID|alias> [days]", cmd.OrigCommand)
+	}
+	if len(cmd.Args) == 0 || len(cmd.Args) > 2 {
+		usage()
+		return
+	}
+	switch target := cmd.Args[0]; {
+	case target == "here":
+		roomID = cmd.Room.Room.ID
+	case target == "all":
+	case strings.HasPrefix(target, "!"):
+		roomID = id.RoomID(target)
+	case strings.HasPrefix(target, "#"):
+		resp, err := cmd.Matrix.Client().ResolveAlias(id.RoomAlias(target))
+		if err != nil {
+			cmd.Reply("Failed to resolve %s: %v", target, err)
+			return
+		}
+		roomID = resp.RoomID
+	default:
+		usage()
+		return
+	}
+	if len(cmd.Args) == 2 {
+		days, err := strconv.Atoi(cmd.Args[1])
+		if err != nil || days < 0 {
+			usage()
+			return
+		}
+		olderThan = time.Duration(days) * 24 * time.Hour
+	}
+	files, size, err := cmd.Matrix.PurgeMediaCache(roomID, olderThan)
+	if err != nil {
+		cmd.Reply("Failed to purge media cache: %v", err)
+		return
+	}
+	cmd.Reply("Deleted %d cached media files, reclaimed %s", files, formatSize(size))
+}
+
+func cmdCache(cmd *Command) {
+	if len(cmd.Args) == 0 || cmd.Args[0] == "stats" {
+		stats, err := cmd.Matrix.MediaCacheStats()
+		if err != nil {
+			cmd.Reply("Failed to read media cache: %v", err)
+			return
+		}
+		limit := "unlimited"
+		if stats.MaxSize > 0 {
+			limit = fmt.Sprintf("%s (%.0f%% used)", formatSize(stats.MaxSize), float64(stats.Size)/float64(stats.MaxSize)*100)
+		}
+		msg := fmt.Sprintf("Media cache: %d files, %s\nLimit: %s", stats.Files, formatSize(stats.Size), limit)
+		if !stats.LeastRecentlyUsed.IsZero() {
+			msg += fmt.Sprintf("\nLeast recently used file last used %s", stats.LeastRecentlyUsed.Format("2006-01-02 15:04"))
+		}
+		cmd.Reply("%s", msg)
+	} else if cmd.Args[0] == "clear" {
+		files, size, err := cmd.Matrix.PurgeMediaCache("", 0)
+		if err != nil {
+			cmd.Reply("Failed to clear media cache: %v", err)
+			return
+		}
+		cmd.Reply("Deleted %d cached media files, reclaimed %s", files, formatSize(size))
+	} else {
+		cmd.Reply("Usage: /cache [stats|clear]")
+	}
+}
+
+func cmdPrune(cmd *Command) {
+	var maxAge time.Duration
+	if len(cmd.Args) > 1 {
+		cmd.Reply("Usage: /prune [days]")
+		return
+	} else if len(cmd.Args) == 1 {
+		days, err := strconv.Atoi(cmd.Args[0])
+		if err != nil || days <= 0 {
+			cmd.Reply("Usage: /prune [days]")
+			return
+		}
+		maxAge = time.Duration(days) * 24 * time.Hour
+	}
+	cmd.Reply("Pruning history cache...")
+	prunedRooms, prunedEvents, skippedRooms, err := cmd.Matrix.PruneHistory(maxAge)
+	if err != nil {
+		cmd.Reply("Failed to prune history cache: %v. Give a maximum age in days or configure retention limits.", err)
+		return
+	}
+	cmd.Reply("Pruned %d events in %d rooms", prunedEvents, prunedRooms)
+	if skippedRooms > 0 {
+		cmd.Reply("Skipped %d rooms that are open or couldn't be pruned", skippedRooms)
+	}
+}
+
 func cmdUnknownCommand(cmd *Command) {
 	cmd.Reply("Unknown command \"%s\". Try \"/help\" for help.", cmd.Command)
 }
@@ -652,6 +1266,93 @@ func cmdSetState(cmd *Command) {
 	}
 }
 
+// parseRawContent parses the given JSON object and returns it indented for showing to the user and compacted for sending.
+func parseRawContent(rawContent string) (indented string, compact json.RawMessage, err error) {
+	var content map[string]interface{}
+	if err = json.Unmarshal([]byte(rawContent), &content); err != nil {
+		return
+	}
+	var indentedBytes []byte
+	if indentedBytes, err = json.MarshalIndent(content, "", "  "); err != nil {
+		return
+	} else if compact, err = json.Marshal(content); err != nil {
+		return
+	}
+	return string(indentedBytes), compact, nil
+}
+
+func cmdRawSend(cmd *Command) {
+	if len(cmd.Args) < 2 {
+		cmd.Reply("Usage: /%s <event type> <json>", cmd.OrigCommand)
+		return
+	}
+	room := cmd.Room.MxRoom()
+	eventType := event.Type{Type: cmd.Args[0], Class: event.MessageEventType}
+	indented, content, err := parseRawContent(strings.Join(cmd.Args[1:], " "))
+	if err != nil {
+		cmd.Reply("Failed to parse content: %v", err)
+		return
+	}
+	text := fmt.Sprintf("Send %s event to %s?\n\n%s", eventType.Type, room.GetTitle(), indented)
+	if room.Encrypted {
+		text += "\n\nThe event will be encrypted."
+	}
+	go func() {
+		defer debug.Recover()
+		if !cmd.MainView.AskConfirmation("Send raw event", text, 0) {
+			cmd.Reply("Event was not sent")
+			return
+		}
+		debug.Print("Sending raw event to", room.ID, eventType.Type, string(content))
+		eventID, err := cmd.Matrix.SendEvent(muksevt.Wrap(&event.Event{
+			Type:    eventType,
+			RoomID:  room.ID,
+			Content: event.Content{VeryRaw: content},
+		}))
+		if err != nil {
+			cmd.Reply("Failed to send event: %s", niceError(err))
+		} else {
+			cmd.Reply("Event sent, ID: %s", eventID)
+		}
+	}()
+}
+
+func cmdRawState(cmd *Command) {
+	if len(cmd.Args) < 3 {
+		cmd.Reply("Usage: /%s <event type> <state key/`-`> <json>", cmd.OrigCommand)
+		return
+	}
+	room := cmd.Room.MxRoom()
+	eventType := event.Type{Type: cmd.Args[0], Class: event.StateEventType}
+	stateKey := cmd.Args[1]
+	if stateKey == "-" {
+		stateKey = ""
+	}
+	indented, content, err := parseRawContent(strings.Join(cmd.Args[2:], " "))
+	if err != nil {
+		cmd.Reply("Failed to parse content: %v", err)
+		return
+	}
+	text := fmt.Sprintf("Set %s state with key %q in %s?\n\n%s", eventType.Type, stateKey, room.GetTitle(), indented)
+	if room.GetStateEvent(eventType, stateKey) != nil {
+		text += "\n\nThis will replace the current state event."
+	}
+	go func() {
+		defer debug.Recover()
+		if !cmd.MainView.AskConfirmation("Send raw state event", text, 0) {
+			cmd.Reply("State event was not sent")
+			return
+		}
+		debug.Print("Sending raw state event to", room.ID, eventType.Type, stateKey, string(content))
+		resp, err := cmd.Matrix.Client().SendStateEvent(room.ID, eventType, stateKey, content)
+		if err != nil {
+			cmd.Reply("Failed to send state event: %s", niceError(err))
+		} else {
+			cmd.Reply("State event sent, ID: %s", resp.EventID)
+		}
+	}()
+}
+
 type ToggleMessage interface {
 	Name() string
 	Format(state bool) string
@@ -671,6 +1372,26 @@ func (hm HideMessage) Name() string {
 	return string(hm)
 }
 
+type ShowMessage string
+
+func (sm ShowMessage) Format(state bool) string {
+	return HideMessage(sm).Format(!state)
+}
+
+func (sm ShowMessage) Name() string {
+	return string(sm)
+}
+
+type EnableMessage string
+
+func (em EnableMessage) Format(state bool) string {
+	return SimpleToggleMessage(em).Format(!state)
+}
+
+func (em EnableMessage) Name() string {
+	return SimpleToggleMessage(em).Name()
+}
+
 type SimpleToggleMessage string
 
 func (stm SimpleToggleMessage) Format(state bool) string {
@@ -698,6 +1419,16 @@ var toggleMsg = map[string]ToggleMessage{
 	"notifications": SimpleToggleMessage("desktop notifications"),
 	"unverified":    SimpleToggleMessage("sending messages to unverified devices"),
 	"showurls":      SimpleToggleMessage("show URLs in text format"),
+	"pastepreview":  SimpleToggleMessage("preview of pasted images"),
+	"animations":    SimpleToggleMessage("animated images"),
+	"highlighting":  SimpleToggleMessage("syntax highlighting of code blocks"),
+	"hyperlinks":    SimpleToggleMessage("clickable terminal hyperlinks"),
+	"vim":           EnableMessage("vim-style modal input"),
+	"preview":       ShowMessage("Room preview pane"),
+	"maps":          ShowMessage("Location minimaps"),
+	"summary":       ShowMessage("Room summary tooltips"),
+	"urlpreviews":   ShowMessage("Link previews"),
+	"bodycolors":    EnableMessage("coloring message bodies in the sender's color"),
 }
 
 func makeUsage() string {
@@ -742,6 +1473,26 @@ func cmdToggle(cmd *Command) {
 			val = &cmd.Config.SendToVerifiedOnly
 		case "showurls":
 			val = &cmd.Config.Preferences.DisableShowURLs
+		case "pastepreview":
+			val = &cmd.Config.Preferences.DisablePastePreview
+		case "animations":
+			val = &cmd.Config.Preferences.DisableAnimations
+		case "highlighting":
+			val = &cmd.Config.Preferences.DisableHighlighting
+		case "hyperlinks":
+			val = &cmd.Config.Preferences.DisableHyperlinks
+		case "vim":
+			val = &cmd.Config.Preferences.VimMode
+		case "preview":
+			val = &cmd.Config.Preferences.ShowRoomPreview
+		case "maps":
+			val = &cmd.Config.Preferences.ShowLocationMaps
+		case "summary":
+			val = &cmd.Config.Preferences.ShowRoomSummary
+		case "urlpreviews":
+			val = &cmd.Config.Preferences.ShowURLPreviews
+		case "bodycolors":
+			val = &cmd.Config.Preferences.ColorMessageBodies
 		default:
 			cmd.Reply("Unknown toggle %s. Use /toggle without arguments for a list of togglable things.", thing)
 			return
@@ -757,3 +1508,31 @@ func cmdToggle(cmd *Command) {
 func cmdLogout(cmd *Command) {
 	cmd.Matrix.Logout()
 }
+
+func cmdExportSession(cmd *Command) {
+	if len(cmd.RawArgs) == 0 {
+		cmd.Reply("Usage: /%s <file>", cmd.OrigCommand)
+		return
+	}
+	path, err := filepath.Abs(cmd.RawArgs)
+	if err != nil {
+		cmd.Reply("Failed to get absolute path: %v", err)
+		return
+	}
+	text := fmt.Sprintf("The session will be moved to %s and removed from this machine, as the same device "+
+		"must not be used from two places at once. Import it with `gomuks --import-session %s` on the other machine.\n\n"+
+		"Continue?", path, filepath.Base(path))
+	if !cmd.MainView.AskConfirmation("Session export", text, 0) {
+		cmd.Reply("Session export cancelled")
+		return
+	}
+	passphrase, ok := cmd.MainView.AskPassword("Session export", "passphrase", "", true)
+	if !ok {
+		cmd.Reply("Passphrase entry cancelled")
+		return
+	}
+	err = cmd.Matrix.ExportSession(path, passphrase)
+	if err != nil {
+		cmd.Reply("Failed to export session to %s: %v. Syncing was resumed, so you can keep using the session here.", path, err)
+	}
+}
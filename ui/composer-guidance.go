@@ -0,0 +1,96 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// StateComposerGuidance is a state event that rooms can use to show posting guidelines in the empty composer.
+var StateComposerGuidance = event.Type{Type: "net.maunium.gomuks.composer_guidance", Class: event.StateEventType}
+
+// ComposerGuidanceTopic is the composer guidance config value for showing the room topic.
+const ComposerGuidanceTopic = "topic"
+
+// firstLine returns the first non-empty line of the given text.
+func firstLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			return line
+		}
+	}
+	return ""
+}
+
+// composerGuidance returns the guidance text to show in the empty composer of this room, if any.
+func (view *RoomView) composerGuidance() string {
+	if guidance, ok := view.config.ComposerGuidance[view.Room.ID]; ok {
+		if guidance == ComposerGuidanceTopic {
+			return firstLine(view.Room.GetTopic())
+		}
+		return firstLine(guidance)
+	}
+	if evt := view.Room.GetStateEvent(StateComposerGuidance, ""); evt != nil {
+		text, _ := evt.Content.Raw["text"].(string)
+		return firstLine(text)
+	}
+	return ""
+}
+
+func (view *RoomView) updatePlaceholder() {
+	if guidance := view.composerGuidance(); len(guidance) > 0 {
+		view.input.SetPlaceholder(guidance)
+	} else if view.Room.Encrypted {
+		view.input.SetPlaceholder("Send an encrypted message...")
+	} else {
+		view.input.SetPlaceholder("Send a message...")
+	}
+}
+
+func cmdGuidance(cmd *Command) {
+	roomID := cmd.Room.Room.ID
+	if len(cmd.Args) == 0 {
+		if guidance := cmd.Room.composerGuidance(); len(guidance) > 0 {
+			cmd.Reply("Composer guidance in this room: %s", guidance)
+		} else {
+			cmd.Reply("No composer guidance in this room. Usage: /%s <text|topic|off|default>", cmd.OrigCommand)
+		}
+		return
+	}
+	if cmd.Config.ComposerGuidance == nil {
+		cmd.Config.ComposerGuidance = make(map[id.RoomID]string)
+	}
+	switch cmd.RawArgs {
+	case "off":
+		cmd.Config.ComposerGuidance[roomID] = ""
+		cmd.Reply("Composer guidance is now hidden in this room")
+	case "default":
+		delete(cmd.Config.ComposerGuidance, roomID)
+		cmd.Reply("Removed the local composer guidance of this room")
+	case ComposerGuidanceTopic:
+		cmd.Config.ComposerGuidance[roomID] = ComposerGuidanceTopic
+		cmd.Reply("The composer in this room now shows the room topic")
+	default:
+		cmd.Config.ComposerGuidance[roomID] = cmd.RawArgs
+		cmd.Reply("Set the composer guidance of this room")
+	}
+	cmd.Config.Save()
+	cmd.Room.updatePlaceholder()
+}
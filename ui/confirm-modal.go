@@ -0,0 +1,135 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"strings"
+	"time"
+
+	"maunium.net/go/mauview"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+// Maximum number of prompts that can wait for the previous ones to be answered.
+const maxQueuedPrompts = 32
+
+type ConfirmModal struct {
+	mauview.Component
+
+	outputChan chan bool
+
+	form *mauview.Form
+	text *mauview.TextView
+
+	cancel *mauview.Button
+	submit *mauview.Button
+
+	parent *MainView
+}
+
+// AskConfirmation shows a yes/no question to the user and waits for the answer.
+// If timeout is non-zero and the user doesn't answer in time, the modal is closed and false is returned.
+func (view *MainView) AskConfirmation(title, text string, timeout time.Duration) bool {
+	cm := NewConfirmModal(view, title, text)
+	view.ShowModal(cm)
+	view.parent.Render()
+	return cm.Wait(timeout)
+}
+
+// queuePrompt runs a function that asks the user something once the previously queued prompts have been
+// answered. It's used for questions caused by incoming events, which must not block the sync goroutine.
+// Returns false if too many prompts are already waiting.
+func (view *MainView) queuePrompt(fn func()) bool {
+	select {
+	case view.prompts <- fn:
+		return true
+	default:
+		return false
+	}
+}
+
+func (view *MainView) processPrompts() {
+	for fn := range view.prompts {
+		func() {
+			defer debug.Recover()
+			fn()
+		}()
+	}
+}
+
+func NewConfirmModal(parent *MainView, title, text string) *ConfirmModal {
+	cm := &ConfirmModal{
+		parent:     parent,
+		form:       mauview.NewForm(),
+		outputChan: make(chan bool, 1),
+	}
+
+	width := 50
+	textHeight := 0
+	for _, line := range strings.Split(text, "\n") {
+		textHeight += 1 + mauview.StringWidth(line)/(width-4)
+	}
+	height := textHeight + 5
+
+	cm.form.
+		SetColumns([]int{1, 22, 2, 22, 1}).
+		SetRows([]int{1, textHeight, 1, 1, 1})
+
+	cm.text = mauview.NewTextView().SetText(text).SetWordWrap(true)
+	cm.form.AddComponent(cm.text, 1, 1, 3, 1)
+
+	cm.cancel = mauview.NewButton("No").SetOnClick(cm.ClickCancel)
+	cm.submit = mauview.NewButton("Yes").SetOnClick(cm.ClickSubmit)
+
+	cm.form.AddFormItem(cm.submit, 3, 3, 1, 1)
+	cm.form.AddFormItem(cm.cancel, 1, 3, 1, 1)
+
+	box := mauview.NewBox(cm.form).SetTitle(title)
+	center := mauview.Center(box, width, height).SetAlwaysFocusChild(true)
+	center.Focus()
+	cm.form.FocusNextItem()
+	cm.Component = center
+
+	return cm
+}
+
+func (cm *ConfirmModal) ClickCancel() {
+	cm.parent.HideModal()
+	cm.outputChan <- false
+}
+
+func (cm *ConfirmModal) ClickSubmit() {
+	cm.parent.HideModal()
+	cm.outputChan <- true
+}
+
+func (cm *ConfirmModal) Wait(timeout time.Duration) bool {
+	if timeout <= 0 {
+		return <-cm.outputChan
+	}
+	select {
+	case result := <-cm.outputChan:
+		return result
+	case <-time.After(timeout):
+		if cm.parent.modal == cm {
+			cm.parent.HideModal()
+			cm.parent.parent.Render()
+		}
+		return false
+	}
+}
@@ -0,0 +1,85 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+func tlsVersionName(version uint16) string {
+	name, ok := tlsVersionNames[version]
+	if !ok {
+		return fmt.Sprintf("0x%04x", version)
+	}
+	return name
+}
+
+// ipVersionOf returns "IPv4" or "IPv6" depending on the IP in the given host:port address.
+func ipVersionOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "unknown"
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "unknown"
+	} else if ip.To4() != nil {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+func cmdConnection(cmd *Command) {
+	info := cmd.Matrix.ConnectionInfo()
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "Homeserver: %s\n", info.Homeserver)
+	_, _ = fmt.Fprintf(&buf, "IP version preference: %s\n", info.IPVersion)
+	if len(info.RemoteAddr) == 0 {
+		buf.WriteString("No connection has been made yet")
+		cmd.Reply(buf.String())
+		return
+	}
+	_, _ = fmt.Fprintf(&buf, "Connected to %s (%s) from %s at %s\n", info.RemoteAddr, ipVersionOf(info.RemoteAddr),
+		info.LocalAddr, info.Connected.Format("2006-01-02 15:04:05"))
+	if info.TLS == nil {
+		buf.WriteString("TLS: not in use")
+		cmd.Reply(buf.String())
+		return
+	}
+	_, _ = fmt.Fprintf(&buf, "TLS: %s, %s\n", tlsVersionName(info.TLS.Version), tls.CipherSuiteName(info.TLS.CipherSuite))
+	if len(info.TLS.NegotiatedProtocol) > 0 {
+		_, _ = fmt.Fprintf(&buf, "ALPN protocol: %s\n", info.TLS.NegotiatedProtocol)
+	}
+	_, _ = fmt.Fprintf(&buf, "Server name: %s", info.TLS.ServerName)
+	if len(info.TLS.PeerCertificates) > 0 {
+		cert := info.TLS.PeerCertificates[0]
+		_, _ = fmt.Fprintf(&buf, "\nCertificate subject: %s\nCertificate issuer: %s\nCertificate valid until %s",
+			cert.Subject, cert.Issuer, cert.NotAfter.Format(time.RFC1123))
+	}
+	cmd.Reply(buf.String())
+}
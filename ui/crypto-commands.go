@@ -19,6 +19,7 @@
 package ui
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -27,26 +28,16 @@ import (
 	"time"
 	"unicode"
 
+	"maunium.net/go/gomuks/debug"
 	ifc "maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/matrix/muksevt"
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/crypto"
 	"maunium.net/go/mautrix/crypto/ssss"
+	"maunium.net/go/mautrix/event"
 	"maunium.net/go/mautrix/id"
 )
 
-func autocompleteDeviceUserID(cmd *CommandAutocomplete) (completions []string, newText string) {
-	userCompletions := cmd.Room.AutocompleteUser(cmd.Args[0])
-	if len(userCompletions) == 1 {
-		newText = fmt.Sprintf("/%s %s ", cmd.OrigCommand, userCompletions[0].id)
-	} else {
-		completions = make([]string, len(userCompletions))
-		for i, completion := range userCompletions {
-			completions[i] = completion.id
-		}
-	}
-	return
-}
-
 func autocompleteDeviceDeviceID(cmd *CommandAutocomplete) (completions []string, newText string) {
 	mach := cmd.Matrix.Crypto().(*crypto.OlmMachine)
 	devices, err := mach.CryptoStore.GetDevices(id.UserID(cmd.Args[0]))
@@ -81,13 +72,6 @@ func autocompleteDeviceDeviceID(cmd *CommandAutocomplete) (completions []string,
 	return
 }
 
-func autocompleteUser(cmd *CommandAutocomplete) ([]string, string) {
-	if len(cmd.Args) == 1 && !unicode.IsSpace(rune(cmd.RawArgs[len(cmd.RawArgs)-1])) {
-		return autocompleteDeviceUserID(cmd)
-	}
-	return []string{}, ""
-}
-
 func autocompleteDevice(cmd *CommandAutocomplete) ([]string, string) {
 	if len(cmd.Args) == 0 {
 		return []string{}, ""
@@ -122,9 +106,75 @@ func putDevice(cmd *Command, device *crypto.DeviceIdentity, action string) {
 	mach.OnDevicesChanged(device.UserID)
 }
 
+// describeOwnDevice returns the trust state of one of the user's own devices.
+func describeOwnDevice(container ifc.MatrixContainer, deviceID id.DeviceID) string {
+	mach, ok := container.Crypto().(*crypto.OlmMachine)
+	if !ok {
+		return ""
+	}
+	device, err := mach.CryptoStore.GetDevice(mach.Client.UserID, deviceID)
+	if err != nil || device == nil {
+		return "unknown"
+	}
+	trust := device.Trust.String()
+	if device.Trust == crypto.TrustStateUnset && mach.IsDeviceTrusted(device) {
+		trust = "verified (transitive)"
+	}
+	return trust
+}
+
+// describeUserDevices returns a summary of the devices of a user and how many of them are verified.
+func describeUserDevices(container ifc.MatrixContainer, userID id.UserID) string {
+	mach, ok := container.Crypto().(*crypto.OlmMachine)
+	if !ok {
+		return "unknown (encryption not enabled)"
+	}
+	devices, err := mach.CryptoStore.GetDevices(userID)
+	if err != nil {
+		return fmt.Sprintf("unknown (failed to get devices: %v)", err)
+	} else if len(devices) == 0 {
+		devices = mach.LoadDevices(userID)
+	}
+	if len(devices) == 0 {
+		return "none found"
+	}
+	verified := 0
+	for _, device := range devices {
+		if mach.IsDeviceTrusted(device) {
+			verified++
+		}
+	}
+	summary := fmt.Sprintf("%d, %d verified", len(devices), verified)
+	if mach.IsUserTrusted(userID) {
+		summary += " (user verified with cross-signing)"
+	}
+	return summary
+}
+
+// describeSenderDevice returns a description of the trust state of the device that sent a megolm event.
+func describeSenderDevice(container ifc.MatrixContainer, userID id.UserID, info *muksevt.EncryptionInfo) string {
+	mach, ok := container.Crypto().(*crypto.OlmMachine)
+	if !ok {
+		return "unknown (encryption not enabled)"
+	}
+	device, err := mach.CryptoStore.GetDevice(userID, info.DeviceID)
+	if err != nil {
+		return fmt.Sprintf("unknown (failed to get device: %v)", err)
+	} else if device == nil {
+		return "unknown device"
+	} else if string(device.IdentityKey) != string(info.SenderKey) {
+		return "sender key doesn't match device identity key"
+	}
+	trust := device.Trust.String()
+	if device.Trust == crypto.TrustStateUnset && mach.IsDeviceTrusted(device) {
+		trust = "verified (transitive)"
+	}
+	return fmt.Sprintf("%s (%s)", trust, device.Name)
+}
+
 func cmdDevices(cmd *Command) {
 	if len(cmd.Args) == 0 {
-		cmd.Reply("Usage: /devices <user id>")
+		cmdDeviceManager(cmd)
 		return
 	}
 	userID := id.UserID(cmd.Args[0])
@@ -299,6 +349,10 @@ func cmdResetSession(cmd *Command) {
 }
 
 func cmdImportKeys(cmd *Command) {
+	if len(cmd.RawArgs) == 0 {
+		cmd.Reply("Usage: /%s <file>", cmd.OrigCommand)
+		return
+	}
 	path, err := filepath.Abs(cmd.RawArgs)
 	if err != nil {
 		cmd.Reply("Failed to get absolute path: %v", err)
@@ -320,10 +374,15 @@ func cmdImportKeys(cmd *Command) {
 		cmd.Reply("Failed to import sessions: %v", err)
 	} else {
 		cmd.Reply("Successfully imported %d/%d sessions", imported, total)
+		go cmd.Matrix.RetryDecryption()
 	}
 }
 
 func exportKeys(cmd *Command, sessions []*crypto.InboundGroupSession) {
+	if len(cmd.RawArgs) == 0 {
+		cmd.Reply("Usage: /%s <file>", cmd.OrigCommand)
+		return
+	}
 	path, err := filepath.Abs(cmd.RawArgs)
 	if err != nil {
 		cmd.Reply("Failed to get absolute path: %v", err)
@@ -337,6 +396,7 @@ func exportKeys(cmd *Command, sessions []*crypto.InboundGroupSession) {
 	export, err := crypto.ExportKeys(passphrase, sessions)
 	if err != nil {
 		cmd.Reply("Failed to export sessions: %v", err)
+		return
 	}
 	err = ioutil.WriteFile(path, export, 0400)
 	if err != nil {
@@ -371,7 +431,9 @@ const ssssHelp = `Usage: /%s <subcommand> [...]
 Subcommands:
 * status [key ID] - Check the status of your SSSS.
 * generate [--set-default] - Generate a SSSS key and optionally set it as the default.
-* set-default <key ID> - Set a SSSS key as the default.`
+* set-default <key ID> - Set a SSSS key as the default.
+* unlock [--save-to-disk] - Unlock the cross-signing keys and the key backup key stored in SSSS.
+* bootstrap - Create new secret storage and store the cross-signing keys (generating them if necessary) in it.`
 
 func cmdSSSS(cmd *Command) {
 	if len(cmd.Args) == 0 {
@@ -397,6 +459,11 @@ func cmdSSSS(cmd *Command) {
 			return
 		}
 		cmdS4SetDefault(cmd, mach, cmd.Args[1])
+	case "unlock":
+		saveToDisk := len(cmd.Args) > 1 && strings.ToLower(cmd.Args[1]) == "--save-to-disk"
+		cmdS4Unlock(cmd, mach, saveToDisk)
+	case "bootstrap":
+		cmdS4Bootstrap(cmd, mach)
 	default:
 		cmd.Reply(ssssHelp, cmd.OrigCommand)
 	}
@@ -478,6 +545,121 @@ func cmdS4SetDefault(cmd *Command, mach *crypto.OlmMachine, keyID string) {
 	}
 }
 
+var AccountDataMegolmBackupKey = event.Type{
+	Type:  "m.megolm_backup.v1",
+	Class: event.AccountDataEventType,
+}
+
+const megolmBackupKeyFile = "megolm-backup.key"
+
+func cmdS4Unlock(cmd *Command, mach *crypto.OlmMachine, saveToDisk bool) {
+	key := getSSSS(cmd, mach)
+	if key == nil {
+		return
+	}
+
+	err := mach.FetchCrossSigningKeysFromSSSS(key)
+	if errors.Is(err, mautrix.MNotFound) {
+		cmd.Reply("No cross-signing keys found in SSSS")
+	} else if err != nil {
+		cmd.Reply("Error fetching cross-signing keys: %v", err)
+	} else {
+		cmd.Reply("Successfully unlocked cross-signing keys")
+	}
+
+	backupKey, err := mach.SSSS.GetDecryptedAccountData(AccountDataMegolmBackupKey, key)
+	if errors.Is(err, mautrix.MNotFound) {
+		cmd.Reply("No key backup key found in SSSS")
+		return
+	} else if err != nil {
+		cmd.Reply("Error fetching key backup key: %v", err)
+		return
+	}
+	cmd.Reply("Successfully unlocked key backup key")
+	if saveToDisk {
+		path := filepath.Join(cmd.Config.DataDir, megolmBackupKeyFile)
+		err = ioutil.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(backupKey)), 0600)
+		if err != nil {
+			cmd.Reply("Failed to save key backup key to disk: %v", err)
+		} else {
+			cmd.Reply("Saved key backup key to %s", path)
+		}
+	}
+}
+
+func cmdS4Bootstrap(cmd *Command, mach *crypto.OlmMachine) {
+	if _, _, err := mach.SSSS.GetDefaultKeyData(); err == nil {
+		cmd.Reply("SSSS is already set up. Use `/%s generate` if you want to create a new key manually.", cmd.OrigCommand)
+		return
+	} else if !errors.Is(err, ssss.ErrNoDefaultKeyAccountDataEvent) && !errors.Is(err, mautrix.MNotFound) {
+		cmd.Reply("Failed to check existing SSSS key: %v", err)
+		return
+	}
+
+	passphrase, ok := cmd.MainView.AskPassword("Passphrase", "", "", true)
+	if !ok {
+		return
+	}
+	key, err := ssss.NewKey(passphrase)
+	if err != nil {
+		cmd.Reply("Failed to generate new key: %v", err)
+		return
+	}
+	err = mach.SSSS.SetKeyData(key.ID, key.Metadata)
+	if err != nil {
+		cmd.Reply("Failed to upload key metadata: %v", err)
+		return
+	}
+	err = mach.SSSS.SetDefaultKeyID(key.ID)
+	if err != nil {
+		cmd.Reply("Failed to set key as default: %v", err)
+		return
+	}
+	// TODO if we start persisting command replies, the recovery key needs to be moved into a popup
+	cmd.Reply("Created secret storage with key %s\nRecovery key: %s", key.ID, key.RecoveryKey())
+
+	if mach.CrossSigningKeys == nil {
+		keys, err := mach.GenerateCrossSigningKeys()
+		if err != nil {
+			cmd.Reply("Failed to generate cross-signing keys: %v", err)
+			return
+		}
+		err = mach.PublishCrossSigningKeys(keys, uiaCallback(cmd))
+		if err != nil {
+			cmd.Reply("Failed to publish cross-signing keys: %v", err)
+			return
+		}
+		cmd.Reply("Generated and published new cross-signing keys")
+		err = mach.SignOwnMasterKey()
+		if err != nil {
+			cmd.Reply("Failed to sign master key with device key: %v", err)
+		}
+	}
+
+	err = mach.UploadCrossSigningKeysToSSSS(key, mach.CrossSigningKeys)
+	if err != nil {
+		cmd.Reply("Failed to upload cross-signing keys to SSSS: %v", err)
+		return
+	}
+	cmd.Reply("Stored cross-signing keys in SSSS")
+
+	backupKeyBase64, err := ioutil.ReadFile(filepath.Join(cmd.Config.DataDir, megolmBackupKeyFile))
+	if err != nil {
+		return
+	}
+	backupKey, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(backupKeyBase64)))
+	if err != nil {
+		cmd.Reply("Failed to decode saved key backup key: %v", err)
+		return
+	}
+	err = mach.SSSS.SetEncryptedAccountData(AccountDataMegolmBackupKey, backupKey, key)
+	if err != nil {
+		cmd.Reply("Failed to store key backup key in SSSS: %v", err)
+	} else {
+		cmd.Reply("Stored saved key backup key in SSSS")
+	}
+}
+
 const crossSigningHelp = `Usage: /%s <subcommand> [...]
 
 Subcommands:
@@ -574,39 +756,7 @@ func cmdCrossSigningGenerate(cmd *Command, container ifc.MatrixContainer, mach *
 		return
 	}
 
-	err = mach.PublishCrossSigningKeys(keys, func(uia *mautrix.RespUserInteractive) interface{} {
-		if !uia.HasSingleStageFlow(mautrix.AuthTypePassword) {
-			for _, flow := range uia.Flows {
-				if len(flow.Stages) != 1 {
-					return nil
-				}
-				cmd.Reply("Opening browser for authentication")
-				err := container.UIAFallback(flow.Stages[0], uia.Session)
-				if err != nil {
-					cmd.Reply("Authentication failed: %v", err)
-					return nil
-				}
-				return &mautrix.ReqUIAuthFallback{
-					Session: uia.Session,
-					User:    mach.Client.UserID.String(),
-				}
-			}
-			cmd.Reply("No supported authentication mechanisms found")
-			return nil
-		}
-		password, ok := cmd.MainView.AskPassword("Account password", "", "correct horse battery staple", false)
-		if !ok {
-			return nil
-		}
-		return &mautrix.ReqUIAuthLogin{
-			BaseAuthData: mautrix.BaseAuthData{
-				Type:    mautrix.AuthTypePassword,
-				Session: uia.Session,
-			},
-			User:     mach.Client.UserID.String(),
-			Password: password,
-		}
-	})
+	err = mach.PublishCrossSigningKeys(keys, uiaCallback(cmd))
 	if err != nil {
 		cmd.Reply("Failed to publish cross-signing keys: %v", err)
 		return
@@ -620,47 +770,51 @@ func cmdCrossSigningGenerate(cmd *Command, container ifc.MatrixContainer, mach *
 }
 
 func getSSSS(cmd *Command, mach *crypto.OlmMachine) *ssss.Key {
+	key, err := cmd.MainView.askSSSSKey(mach)
+	if err != nil {
+		cmd.Reply("%v", err)
+	}
+	return key
+}
+
+// askSSSSKey asks the user for the passphrase or recovery key of the default SSSS key.
+// Both return values are nil if the user cancelled.
+func (view *MainView) askSSSSKey(mach *crypto.OlmMachine) (*ssss.Key, error) {
 	_, keyData, err := mach.SSSS.GetDefaultKeyData()
 	if err != nil {
 		if errors.Is(err, mautrix.MNotFound) {
-			cmd.Reply("SSSS not set up, use `!ssss generate --set-default` first")
-		} else {
-			cmd.Reply("Failed to fetch default SSSS key data: %v", err)
+			return nil, errors.New("SSSS not set up, use `/ssss bootstrap` or `/ssss generate --set-default` first")
 		}
-		return nil
+		return nil, fmt.Errorf("Failed to fetch default SSSS key data: %v", err)
 	}
 
 	var key *ssss.Key
 	if keyData.Passphrase != nil && keyData.Passphrase.Algorithm == ssss.PassphraseAlgorithmPBKDF2 {
-		passphrase, ok := cmd.MainView.AskPassword("Passphrase", "", "correct horse battery staple", false)
+		passphrase, ok := view.AskPassword("Passphrase", "", "correct horse battery staple", false)
 		if !ok {
-			return nil
+			return nil, nil
 		}
 		key, err = keyData.VerifyPassphrase(passphrase)
 		if errors.Is(err, ssss.ErrIncorrectSSSSKey) {
-			cmd.Reply("Incorrect passphrase")
-			return nil
+			return nil, errors.New("Incorrect passphrase")
 		}
 	} else {
-		recoveryKey, ok := cmd.MainView.AskPassword("Recovery key", "", "tDAK LMRH PiYE bdzi maCe xLX5 wV6P Nmfd c5mC wLef 15Fs VVSc", false)
+		recoveryKey, ok := view.AskPassword("Recovery key", "", "tDAK LMRH PiYE bdzi maCe xLX5 wV6P Nmfd c5mC wLef 15Fs VVSc", false)
 		if !ok {
-			return nil
+			return nil, nil
 		}
 		key, err = keyData.VerifyRecoveryKey(recoveryKey)
 		if errors.Is(err, ssss.ErrInvalidRecoveryKey) {
-			cmd.Reply("Malformed recovery key")
-			return nil
+			return nil, errors.New("Malformed recovery key")
 		} else if errors.Is(err, ssss.ErrIncorrectSSSSKey) {
-			cmd.Reply("Incorrect recovery key")
-			return nil
+			return nil, errors.New("Incorrect recovery key")
 		}
 	}
 	// All the errors should already be handled above, this is just for backup
 	if err != nil {
-		cmd.Reply("Failed to get SSSS key: %v", err)
-		return nil
+		return nil, fmt.Errorf("Failed to get SSSS key: %v", err)
 	}
-	return key
+	return key, nil
 }
 
 func cmdCrossSigningUpload(cmd *Command, mach *crypto.OlmMachine) {
@@ -695,3 +849,30 @@ func cmdCrossSigningSelfSign(cmd *Command, mach *crypto.OlmMachine) {
 		cmd.Reply("Successfully self-signed. This device is now trusted by other devices")
 	}
 }
+
+const enableEncryptionWarning = `Enabling end-to-end encryption is permanent: it can't be disabled in this room afterwards.
+
+Bots, bridges and clients without encryption support may stop working in the room. Continue?`
+
+func cmdEnableEncryption(cmd *Command) {
+	room := cmd.Room.MxRoom()
+	if room.Encrypted {
+		cmd.Reply("Encryption is already enabled in this room")
+		return
+	}
+	go func() {
+		defer debug.Recover()
+		if !cmd.MainView.AskConfirmation("Enable encryption", enableEncryptionWarning, 0) {
+			cmd.Reply("Encryption was not enabled")
+			return
+		}
+		err := cmd.Matrix.EnableEncryption(room)
+		if err != nil && room.Encrypted {
+			cmd.Reply("Encryption enabled, but %v. Keys will be shared again when sending the first message.", err)
+		} else if err != nil {
+			cmd.Reply("Failed to enable encryption: %v", niceError(err))
+		} else {
+			cmd.Reply("Encryption enabled")
+		}
+	}()
+}
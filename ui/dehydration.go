@@ -0,0 +1,95 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// +build cgo
+
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+const dehydrateHelp = `Usage: /%s <subcommand>
+
+Subcommands:
+* status - Check if there's a dehydrated device on your account.
+* create - Create a dehydrated device that receives keys while you have no devices logged in.
+           It's encrypted with your default SSSS key and replaces any existing dehydrated device.
+
+After logging in, gomuks offers to take over the dehydrated device and the keys it has received.`
+
+func cmdDehydrate(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmd.Reply(dehydrateHelp, cmd.OrigCommand)
+		return
+	}
+	switch strings.ToLower(cmd.Args[0]) {
+	case "status":
+		deviceID, err := cmd.Matrix.GetDehydratedDevice()
+		if err != nil {
+			cmd.Reply("Failed to get dehydrated device: %v", err)
+		} else if len(deviceID) == 0 {
+			cmd.Reply("You don't have a dehydrated device")
+		} else {
+			cmd.Reply("Your dehydrated device is %s", deviceID)
+		}
+	case "create":
+		key := getSSSS(cmd, cmd.Matrix.Crypto().(*crypto.OlmMachine))
+		if key == nil {
+			return
+		}
+		deviceID, err := cmd.Matrix.DehydrateDevice(key)
+		if err != nil {
+			cmd.Reply("Failed to create dehydrated device: %v", err)
+		} else {
+			cmd.Reply("Created dehydrated device %s", deviceID)
+		}
+	default:
+		cmd.Reply(dehydrateHelp, cmd.OrigCommand)
+	}
+}
+
+// OfferRehydration asks the user whether to take over the given dehydrated device after logging in.
+func (view *MainView) OfferRehydration(deviceID id.DeviceID) {
+	text := fmt.Sprintf("Your account has a dehydrated device (%s), which may have received keys "+
+		"for messages sent while you had no devices logged in. Rehydrate it now with your SSSS key?", deviceID)
+	if !view.AskConfirmation("Dehydrated device", text, 0) {
+		return
+	}
+	mach := view.matrix.Crypto().(*crypto.OlmMachine)
+	for {
+		key, err := view.askSSSSKey(mach)
+		if key == nil && err == nil {
+			return
+		} else if err == nil {
+			_, err = view.matrix.RehydrateDevice(key)
+		}
+		if err == nil {
+			view.parent.Render()
+			return
+		}
+		debug.Print("Failed to rehydrate device:", err)
+		if !view.AskConfirmation("Dehydrated device", fmt.Sprintf("Failed to rehydrate device: %v\n\nTry again?", err), 0) {
+			return
+		}
+	}
+}
@@ -0,0 +1,267 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+type deviceInfo struct {
+	DeviceID    id.DeviceID `json:"device_id"`
+	DisplayName string      `json:"display_name"`
+	LastSeenIP  string      `json:"last_seen_ip"`
+	LastSeenTS  int64       `json:"last_seen_ts"`
+}
+
+type respDevicesInfo struct {
+	Devices []deviceInfo `json:"devices"`
+}
+
+type reqDeviceInfo struct {
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+// DeviceManager is a modal that lists the user's own devices and allows renaming, verifying,
+// blacklisting and deleting them.
+type DeviceManager struct {
+	mauview.FocusableComponent
+	parent *MainView
+	room   *RoomView
+
+	text *mauview.TextView
+
+	devices  []deviceInfo
+	selected int
+}
+
+func NewDeviceManager(parent *MainView, room *RoomView, devices []deviceInfo) *DeviceManager {
+	dm := &DeviceManager{
+		parent:  parent,
+		room:    room,
+		devices: devices,
+	}
+	sort.Slice(dm.devices, func(i, j int) bool {
+		return dm.devices[i].LastSeenTS > dm.devices[j].LastSeenTS
+	})
+
+	dm.text = mauview.NewTextView().
+		SetScrollable(true).
+		SetWrap(false)
+
+	box := mauview.NewBox(dm.text).
+		SetBorder(true).
+		SetTitle("Devices").
+		SetBlurCaptureFunc(func() bool {
+			dm.parent.HideModal()
+			return true
+		})
+	box.Focus()
+	dm.update()
+
+	dm.FocusableComponent = mauview.FractionalCenter(box, 70, 15, 0.75, 0.5)
+
+	return dm
+}
+
+func (dm *DeviceManager) update() {
+	var buf strings.Builder
+	buf.WriteString("Up/Down: select, r: rename, v: verify, b: blacklist, d: delete, q: close\n\n")
+	for i, device := range dm.devices {
+		prefix := "  "
+		if i == dm.selected {
+			prefix = "> "
+		}
+		name := device.DisplayName
+		if len(name) == 0 {
+			name = "(no name)"
+		}
+		lastSeen := "never"
+		if device.LastSeenTS > 0 {
+			lastSeen = time.Unix(device.LastSeenTS/1000, device.LastSeenTS%1000*int64(time.Millisecond)).Format("2006-01-02 15:04")
+			if len(device.LastSeenIP) > 0 {
+				lastSeen = fmt.Sprintf("%s from %s", lastSeen, device.LastSeenIP)
+			}
+		}
+		current := ""
+		if device.DeviceID == dm.parent.config.DeviceID {
+			current = " (this device)"
+		}
+		_, _ = fmt.Fprintf(&buf, "%s%s - %s%s\n", prefix, device.DeviceID, name, current)
+		_, _ = fmt.Fprintf(&buf, "    Last seen: %s\n", lastSeen)
+		if trust := describeOwnDevice(dm.parent.matrix, device.DeviceID); len(trust) > 0 {
+			_, _ = fmt.Fprintf(&buf, "    Trust: %s\n", trust)
+		}
+	}
+	dm.text.SetText(strings.TrimSuffix(buf.String(), "\n"))
+}
+
+func (dm *DeviceManager) runCommand(format string, args ...interface{}) {
+	dm.parent.HideModal()
+	cmd := dm.parent.cmdProcessor.ParseCommand(dm.room, fmt.Sprintf(format, args...))
+	if cmd != nil {
+		go dm.parent.cmdProcessor.HandleCommand(cmd)
+	}
+}
+
+func (dm *DeviceManager) OnKeyEvent(event mauview.KeyEvent) bool {
+	if len(dm.devices) == 0 {
+		dm.parent.HideModal()
+		return true
+	}
+	device := dm.devices[dm.selected]
+	switch {
+	case event.Key() == tcell.KeyEscape || event.Rune() == 'q':
+		dm.parent.HideModal()
+	case event.Key() == tcell.KeyUp || event.Rune() == 'k':
+		if dm.selected > 0 {
+			dm.selected--
+			dm.update()
+		}
+	case event.Key() == tcell.KeyDown || event.Rune() == 'j':
+		if dm.selected < len(dm.devices)-1 {
+			dm.selected++
+			dm.update()
+		}
+	case event.Rune() == 'r':
+		dm.parent.HideModal()
+		dm.room.input.SetTextAndMoveCursor(fmt.Sprintf("/rename-device %s ", device.DeviceID))
+	case event.Rune() == 'v':
+		dm.runCommand("/verify %s %s", dm.parent.config.UserID, device.DeviceID)
+	case event.Rune() == 'b':
+		dm.runCommand("/blacklist %s %s", dm.parent.config.UserID, device.DeviceID)
+	case event.Rune() == 'd':
+		dm.runCommand("/delete-device %s", device.DeviceID)
+	default:
+		return dm.FocusableComponent.OnKeyEvent(event)
+	}
+	return true
+}
+
+func cmdDeviceManager(cmd *Command) {
+	client := cmd.Matrix.Client()
+	var resp respDevicesInfo
+	_, err := client.MakeRequest("GET", client.BuildURL("devices"), nil, &resp)
+	if err != nil {
+		debug.Print("Error fetching device list:", err)
+		cmd.Reply("Failed to fetch device list: %v", err)
+		return
+	}
+	cmd.MainView.ShowModal(NewDeviceManager(cmd.MainView, cmd.Room, resp.Devices))
+	cmd.UI.Render()
+}
+
+func cmdRenameDevice(cmd *Command) {
+	if len(cmd.Args) < 2 {
+		cmd.Reply("Usage: /%s <device id> <name>", cmd.OrigCommand)
+		return
+	}
+	deviceID := id.DeviceID(cmd.Args[0])
+	name := strings.Join(cmd.Args[1:], " ")
+	client := cmd.Matrix.Client()
+	_, err := client.MakeRequest("PUT", client.BuildURL("devices", string(deviceID)), &reqDeviceInfo{DisplayName: name}, nil)
+	if err != nil {
+		cmd.Reply("Failed to rename device: %v", err)
+	} else {
+		cmd.Reply("Renamed %s to %s", deviceID, name)
+	}
+}
+
+type reqDeleteDevice struct {
+	Auth interface{} `json:"auth,omitempty"`
+}
+
+func cmdDeleteDevice(cmd *Command) {
+	if len(cmd.Args) != 1 {
+		cmd.Reply("Usage: /%s <device id>", cmd.OrigCommand)
+		return
+	}
+	deviceID := id.DeviceID(cmd.Args[0])
+	if deviceID == cmd.Config.DeviceID {
+		cmd.Reply("Use /logout to delete the current device")
+		return
+	}
+	client := cmd.Matrix.Client()
+	urlPath := client.BuildURL("devices", string(deviceID))
+	data, err := client.MakeRequest("DELETE", urlPath, &reqDeleteDevice{}, nil)
+	if err != nil {
+		var uia mautrix.RespUserInteractive
+		if jsonErr := json.Unmarshal(data, &uia); jsonErr == nil && len(uia.Flows) > 0 {
+			auth := uiaCallback(cmd)(&uia)
+			if auth == nil {
+				cmd.Reply("Device deletion cancelled")
+				return
+			}
+			_, err = client.MakeRequest("DELETE", urlPath, &reqDeleteDevice{Auth: auth}, nil)
+		}
+	}
+	if err != nil {
+		cmd.Reply("Failed to delete device: %v", err)
+	} else {
+		cmd.Reply("Deleted device %s", deviceID)
+	}
+}
+
+// uiaCallback returns a function that handles user-interactive authentication for the given command,
+// either by asking the user's password or by opening the fallback authentication page in a browser.
+func uiaCallback(cmd *Command) func(*mautrix.RespUserInteractive) interface{} {
+	userID := cmd.Matrix.Client().UserID.String()
+	return func(uia *mautrix.RespUserInteractive) interface{} {
+		if !uia.HasSingleStageFlow(mautrix.AuthTypePassword) {
+			for _, flow := range uia.Flows {
+				if len(flow.Stages) != 1 {
+					return nil
+				}
+				cmd.Reply("Opening browser for authentication")
+				err := cmd.Matrix.UIAFallback(flow.Stages[0], uia.Session)
+				if err != nil {
+					cmd.Reply("Authentication failed: %v", err)
+					return nil
+				}
+				return &mautrix.ReqUIAuthFallback{
+					Session: uia.Session,
+					User:    userID,
+				}
+			}
+			cmd.Reply("No supported authentication mechanisms found")
+			return nil
+		}
+		password, ok := cmd.MainView.AskPassword("Account password", "", "correct horse battery staple", false)
+		if !ok {
+			return nil
+		}
+		return &mautrix.ReqUIAuthLogin{
+			BaseAuthData: mautrix.BaseAuthData{
+				Type:    mautrix.AuthTypePassword,
+				Session: uia.Session,
+			},
+			User:     userID,
+			Password: password,
+		}
+	}
+}
@@ -0,0 +1,180 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/lib/open"
+)
+
+const downloadProgressWidth = 20
+
+// DownloadsPanel is a modal that lists the recent downloads and their progress.
+type DownloadsPanel struct {
+	mauview.FocusableComponent
+	parent *MainView
+
+	text *mauview.TextView
+
+	downloads []ifc.Download
+	selected  int
+}
+
+func NewDownloadsPanel(parent *MainView) *DownloadsPanel {
+	panel := &DownloadsPanel{parent: parent}
+	panel.text = mauview.NewTextView().
+		SetScrollable(true).
+		SetWrap(false)
+
+	box := mauview.NewBox(panel.text).
+		SetBorder(true).
+		SetTitle("Downloads").
+		SetBlurCaptureFunc(func() bool {
+			panel.parent.HideModal()
+			return true
+		})
+	box.Focus()
+	panel.update()
+
+	panel.FocusableComponent = mauview.FractionalCenter(box, 70, 15, 0.75, 0.5)
+	return panel
+}
+
+func formatDownloadStatus(dl ifc.Download) string {
+	switch {
+	case dl.Err != nil:
+		return fmt.Sprintf("failed: %v", dl.Err)
+	case !dl.Finished.IsZero():
+		return fmt.Sprintf("done, %s", formatSize(dl.Received))
+	case dl.Size <= 0:
+		return fmt.Sprintf("%s downloaded", formatSize(dl.Received))
+	}
+	filled := int(dl.Received * downloadProgressWidth / dl.Size)
+	status := fmt.Sprintf("[%s%s] %d%% of %s", strings.Repeat("#", filled), strings.Repeat("-", downloadProgressWidth-filled),
+		dl.Received*100/dl.Size, formatSize(dl.Size))
+	if dl.Resumed {
+		status += ", resumed"
+	}
+	return status
+}
+
+func (panel *DownloadsPanel) update() {
+	panel.downloads = panel.parent.matrix.Downloads()
+	if panel.selected >= len(panel.downloads) {
+		panel.selected = len(panel.downloads) - 1
+	}
+	if panel.selected < 0 {
+		panel.selected = 0
+	}
+	var buf strings.Builder
+	buf.WriteString("Up/Down: select, o: open, c: cancel, r: retry, q: close\n")
+	_, _ = fmt.Fprintf(&buf, "Download directory: %s\n\n", panel.parent.config.DownloadDir)
+	if len(panel.downloads) == 0 {
+		buf.WriteString("No downloads yet")
+	}
+	for i, dl := range panel.downloads {
+		prefix := "  "
+		if i == panel.selected {
+			prefix = "> "
+		}
+		_, _ = fmt.Fprintf(&buf, "%s%s - %s\n", prefix, dl.Name, formatDownloadStatus(dl))
+		_, _ = fmt.Fprintf(&buf, "    %s, started %s\n", dl.Path, dl.Started.Format("15:04:05"))
+	}
+	panel.text.SetText(strings.TrimSuffix(buf.String(), "\n"))
+}
+
+func (panel *DownloadsPanel) Draw(screen mauview.Screen) {
+	// Refresh the list on every render to show the progress of active downloads.
+	panel.update()
+	panel.FocusableComponent.Draw(screen)
+}
+
+func (panel *DownloadsPanel) OnKeyEvent(event mauview.KeyEvent) bool {
+	switch {
+	case event.Key() == tcell.KeyEscape || event.Rune() == 'q':
+		panel.parent.HideModal()
+		return true
+	case event.Key() == tcell.KeyUp || event.Rune() == 'k':
+		if panel.selected > 0 {
+			panel.selected--
+		}
+		return true
+	case event.Key() == tcell.KeyDown || event.Rune() == 'j':
+		if panel.selected < len(panel.downloads)-1 {
+			panel.selected++
+		}
+		return true
+	}
+	if len(panel.downloads) == 0 {
+		return panel.FocusableComponent.OnKeyEvent(event)
+	}
+	dl := panel.downloads[panel.selected]
+	switch event.Rune() {
+	case 'o':
+		if dl.Err == nil && !dl.Finished.IsZero() {
+			go open.Open(dl.Path)
+		}
+	case 'c':
+		panel.parent.matrix.CancelDownload(dl.ID)
+	case 'r':
+		if dl.Err != nil {
+			go panel.retry(dl)
+		}
+	default:
+		return panel.FocusableComponent.OnKeyEvent(event)
+	}
+	return true
+}
+
+// retry starts the download again. Interrupted downloads continue from where they stopped if the server supports it.
+func (panel *DownloadsPanel) retry(dl ifc.Download) {
+	defer debug.Recover()
+	_, err := panel.parent.matrix.DownloadToDisk(dl.URI, dl.File, dl.Path)
+	if err != nil {
+		debug.Printf("Failed to retry download of %s: %v", dl.URI, err)
+	}
+}
+
+func cmdDownloads(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmd.MainView.ShowModal(NewDownloadsPanel(cmd.MainView))
+		cmd.UI.Render()
+		return
+	} else if cmd.Args[0] != "dir" {
+		cmd.Reply("Usage: /downloads [dir [path]]")
+		return
+	} else if len(cmd.Args) == 1 {
+		cmd.Reply("Files are downloaded to %s", cmd.Config.DownloadDir)
+		return
+	}
+	dir, err := filepath.Abs(strings.Join(cmd.Args[1:], " "))
+	if err != nil {
+		cmd.Reply("Invalid path: %v", err)
+		return
+	}
+	cmd.Config.DownloadDir = dir
+	cmd.Config.Save()
+	cmd.Reply("Files will now be downloaded to %s", dir)
+}
@@ -0,0 +1,72 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"maunium.net/go/gomuks/debug"
+)
+
+// saveDraft stores the contents of the input area and the reply or edit context in the room,
+// so that they can be restored after switching rooms or restarting.
+func (view *RoomView) saveDraft() {
+	if !view.draftRestored {
+		// Don't overwrite a draft that hasn't been loaded into the input area yet.
+		return
+	}
+	view.Room.Draft = view.GetInputText()
+	view.Room.DraftReplyTo = ""
+	view.Room.DraftEditing = ""
+	if view.editing != nil {
+		view.Room.DraftEditing = view.editing.ID
+	} else if view.replying != nil {
+		view.Room.DraftReplyTo = view.replying.ID
+	}
+}
+
+// restoreDraft loads the draft saved by saveDraft into the input area.
+func (view *RoomView) restoreDraft() {
+	if view.draftRestored {
+		return
+	}
+	view.draftRestored = true
+	room := view.Room
+	if len(room.DraftEditing) > 0 {
+		if evt, err := view.parent.matrix.GetEvent(room, room.DraftEditing); err != nil {
+			debug.Printf("Failed to get event %s being edited in draft of %s: %v", room.DraftEditing, room.ID, err)
+		} else if evt != nil {
+			view.SetEditing(evt)
+		}
+	} else if len(room.DraftReplyTo) > 0 {
+		if evt, err := view.parent.matrix.GetEvent(room, room.DraftReplyTo); err != nil {
+			debug.Printf("Failed to get reply target %s in draft of %s: %v", room.DraftReplyTo, room.ID, err)
+		} else {
+			view.replying = evt
+		}
+	}
+	if len(room.Draft) > 0 {
+		view.SetInputText(room.Draft)
+	}
+}
+
+// saveDrafts saves the drafts of all open rooms. It's called before quitting.
+func (view *MainView) saveDrafts() {
+	view.roomsLock.RLock()
+	defer view.roomsLock.RUnlock()
+	for _, roomView := range view.rooms {
+		roomView.saveDraft()
+	}
+}
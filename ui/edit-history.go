@@ -0,0 +1,149 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/ui/messages"
+)
+
+// EditHistoryModal is a modal that shows every version of an edited message.
+type EditHistoryModal struct {
+	mauview.FocusableComponent
+	parent *MainView
+
+	box  *mauview.Box
+	text *mauview.TextView
+}
+
+func NewEditHistoryModal(parent *MainView, room *RoomView, msg *messages.UIMessage) *EditHistoryModal {
+	eh := &EditHistoryModal{parent: parent}
+
+	eh.text = mauview.NewTextView().
+		SetScrollable(true).
+		SetWrap(true)
+	eh.text.SetText("Loading edit history...")
+
+	eh.box = mauview.NewBox(eh.text).
+		SetBorder(true).
+		SetTitle("Edit history").
+		SetBlurCaptureFunc(func() bool {
+			eh.parent.HideModal()
+			return true
+		})
+	eh.box.Focus()
+
+	eh.FocusableComponent = mauview.FractionalCenter(eh.box, 60, 20, 0.75, 0.75)
+
+	go eh.load(room, msg)
+
+	return eh
+}
+
+func (eh *EditHistoryModal) load(room *RoomView, msg *messages.UIMessage) {
+	defer debug.Recover()
+	orig, edits, err := eh.parent.matrix.GetEditHistory(room.Room, msg.EventID)
+	if err != nil {
+		eh.text.SetText(fmt.Sprintf("Failed to load edit history: %v", err))
+	} else {
+		eh.text.SetText(formatEditHistory(orig, edits))
+	}
+	eh.parent.parent.Render()
+}
+
+func (eh *EditHistoryModal) OnKeyEvent(event mauview.KeyEvent) bool {
+	if event.Key() == tcell.KeyEscape || event.Rune() == 'q' {
+		eh.parent.HideModal()
+		return true
+	}
+	return eh.FocusableComponent.OnKeyEvent(event)
+}
+
+func formatEditHistory(orig *muksevt.Event, edits []*muksevt.Event) string {
+	var buf strings.Builder
+	prev := orig.Content.AsMessage().Body
+	_, _ = fmt.Fprintf(&buf, "Original, sent %s\n%s\n", formatEventTime(orig.Timestamp), prev)
+	if len(edits) == 0 {
+		buf.WriteString("\nThis message has not been edited.\n")
+		return buf.String()
+	}
+	for i, edit := range edits {
+		body := editBody(edit)
+		_, _ = fmt.Fprintf(&buf, "\nEdit %d, sent %s\n%s\n", i+1, formatEventTime(edit.Timestamp), wordDiff(prev, body))
+		prev = body
+	}
+	return buf.String()
+}
+
+func formatEventTime(ts int64) string {
+	return time.Unix(ts/1000, ts%1000*int64(time.Millisecond)).Format("2006-01-02 15:04:05")
+}
+
+func editBody(edit *muksevt.Event) string {
+	content := edit.Content.AsMessage()
+	if content.NewContent != nil {
+		return content.NewContent.Body
+	}
+	return strings.TrimPrefix(content.Body, "* ")
+}
+
+// wordDiff returns the new text with removed words marked as [-word-] and added words as {+word+},
+// like git diff --word-diff.
+func wordDiff(oldText, newText string) string {
+	a, b := strings.Fields(oldText), strings.Fields(newText)
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	words := make([]string, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			words = append(words, a[i])
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			words = append(words, "{+"+b[j]+"+}")
+			j++
+		default:
+			words = append(words, "[-"+a[i]+"-]")
+			i++
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,161 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kyokomi/emoji/v2"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+)
+
+// The reactions shown in the emoji picker before anything has been typed.
+var quickReactions = []string{":thumbsup:", ":thumbsdown:", ":heart:", ":joy:", ":tada:", ":eyes:", ":thinking:", ":fire:", ":rocket:", ":pray:"}
+
+const maxEmojiPickerResults = 100
+
+type EmojiPickerModal struct {
+	mauview.Component
+
+	container *mauview.Box
+
+	search  *mauview.InputArea
+	results *mauview.TextView
+
+	shortcodes []string
+	matches    []string
+	selected   int
+
+	onPick func(emoji string)
+	parent *MainView
+}
+
+func NewEmojiPickerModal(mainView *MainView, onPick func(emoji string)) *EmojiPickerModal {
+	ep := &EmojiPickerModal{
+		parent: mainView,
+		onPick: onPick,
+	}
+
+	for name := range emoji.CodeMap() {
+		ep.shortcodes = append(ep.shortcodes, name)
+	}
+	sort.Strings(ep.shortcodes)
+
+	ep.results = mauview.NewTextView().SetRegions(true)
+	ep.search = mauview.NewInputArea().
+		SetChangedFunc(ep.changeHandler).
+		SetPlaceholder("Search by shortcode...").
+		SetTextColor(tcell.ColorWhite).
+		SetBackgroundColor(tcell.ColorDarkCyan)
+	ep.search.Focus()
+
+	flex := mauview.NewFlex().
+		SetDirection(mauview.FlexRow).
+		AddFixedComponent(ep.search, 1).
+		AddProportionalComponent(ep.results, 1)
+
+	ep.container = mauview.NewBox(flex).
+		SetBorder(true).
+		SetTitle("Add reaction").
+		SetBlurCaptureFunc(func() bool {
+			ep.parent.HideModal()
+			return true
+		})
+
+	ep.Component = mauview.Center(ep.container, 42, 14).SetAlwaysFocusChild(true)
+	ep.changeHandler("")
+
+	return ep
+}
+
+func (ep *EmojiPickerModal) Focus() {
+	ep.container.Focus()
+}
+
+func (ep *EmojiPickerModal) Blur() {
+	ep.container.Blur()
+}
+
+func (ep *EmojiPickerModal) changeHandler(str string) {
+	str = strings.ToLower(strings.Trim(strings.TrimSpace(str), ":"))
+	if len(str) == 0 {
+		ep.matches = quickReactions
+	} else {
+		ep.matches = nil
+		for _, name := range ep.shortcodes {
+			if strings.Contains(name, str) {
+				ep.matches = append(ep.matches, name)
+				if len(ep.matches) >= maxEmojiPickerResults {
+					break
+				}
+			}
+		}
+	}
+	ep.selected = 0
+	ep.results.Clear()
+	for i, name := range ep.matches {
+		fmt.Fprintf(ep.results, `["%d"]%s %s[""]%s`, i, ep.emoji(name), name, "\n")
+	}
+	if len(ep.matches) > 0 {
+		ep.results.Highlight("0")
+	} else {
+		ep.results.Highlight()
+	}
+	ep.results.ScrollToBeginning()
+}
+
+func (ep *EmojiPickerModal) emoji(shortcode string) string {
+	return strings.TrimSpace(emoji.CodeMap()[shortcode])
+}
+
+func (ep *EmojiPickerModal) move(diff int) {
+	if len(ep.matches) == 0 {
+		return
+	}
+	ep.selected = (ep.selected + diff) % len(ep.matches)
+	if ep.selected < 0 {
+		ep.selected += len(ep.matches)
+	}
+	ep.results.Highlight(strconv.Itoa(ep.selected))
+	ep.results.ScrollToHighlight()
+}
+
+func (ep *EmojiPickerModal) OnKeyEvent(event mauview.KeyEvent) bool {
+	switch event.Key() {
+	case tcell.KeyEsc:
+		ep.parent.HideModal()
+		return true
+	case tcell.KeyTab, tcell.KeyDown:
+		ep.move(1)
+		return true
+	case tcell.KeyBacktab, tcell.KeyUp:
+		ep.move(-1)
+		return true
+	case tcell.KeyEnter:
+		ep.parent.HideModal()
+		if len(ep.matches) > 0 {
+			go ep.onPick(ep.emoji(ep.matches[ep.selected]))
+		}
+		return true
+	}
+	return ep.search.OnKeyEvent(event)
+}
@@ -0,0 +1,204 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/ui/messages"
+)
+
+type inspectorTab struct {
+	name string
+	text string
+}
+
+// EventInspector is a modal that shows the JSON source and debug info of a single event,
+// or other raw data split into tabs.
+type EventInspector struct {
+	mauview.FocusableComponent
+	parent *MainView
+
+	box  *mauview.Box
+	text *mauview.TextView
+
+	tabs       []inspectorTab
+	currentTab int
+}
+
+func NewEventInspector(parent *MainView, msg *messages.UIMessage) *EventInspector {
+	var tabs []inspectorTab
+	if encrypted, ok := encryptedEventSource(msg.Event); ok {
+		tabs = []inspectorTab{
+			{name: "Decrypted", text: eventSource(msg.Event)},
+			{name: "Encrypted", text: encrypted},
+		}
+	} else {
+		tabs = []inspectorTab{{name: "Source", text: eventSource(msg.Event)}}
+	}
+	tabs = append(tabs, inspectorTab{name: "Debug", text: escapeColorTags(eventDebugInfo(parent, msg), "-")})
+	return newInspectorModal(parent, tabs)
+}
+
+// newInspectorModal creates an inspector modal with the given tabs. The tab texts may contain color tags.
+func newInspectorModal(parent *MainView, tabs []inspectorTab) *EventInspector {
+	ei := &EventInspector{
+		parent: parent,
+		tabs:   tabs,
+	}
+
+	ei.text = mauview.NewTextView().
+		SetScrollable(true).
+		SetDynamicColors(true).
+		SetWrap(false)
+
+	ei.box = mauview.NewBox(ei.text).
+		SetBorder(true).
+		SetBlurCaptureFunc(func() bool {
+			ei.parent.HideModal()
+			return true
+		})
+	ei.box.Focus()
+	ei.switchTab(0)
+
+	ei.FocusableComponent = mauview.FractionalCenter(ei.box, 60, 20, 0.75, 0.75)
+
+	return ei
+}
+
+func (ei *EventInspector) switchTab(index int) {
+	ei.currentTab = index
+	ei.text.SetText(ei.tabs[index].text)
+	titles := make([]string, len(ei.tabs))
+	for i, tab := range ei.tabs {
+		if i == index {
+			titles[i] = "[" + tab.name + "]"
+		} else {
+			titles[i] = tab.name
+		}
+	}
+	ei.box.SetTitle(strings.Join(titles, " "))
+}
+
+func (ei *EventInspector) OnKeyEvent(event mauview.KeyEvent) bool {
+	switch {
+	case event.Key() == tcell.KeyEscape || event.Rune() == 'q':
+		ei.parent.HideModal()
+	case event.Key() == tcell.KeyTab:
+		ei.switchTab((ei.currentTab + 1) % len(ei.tabs))
+	case event.Key() == tcell.KeyBacktab:
+		ei.switchTab((ei.currentTab + len(ei.tabs) - 1) % len(ei.tabs))
+	default:
+		return ei.FocusableComponent.OnKeyEvent(event)
+	}
+	return true
+}
+
+func eventSource(evt *muksevt.Event) string {
+	if evt == nil {
+		return "No event source available"
+	}
+	data, err := json.MarshalIndent(evt.Event, "", "  ")
+	if err != nil {
+		return escapeColorTags(fmt.Sprintf("Failed to marshal event: %v", err), "-")
+	}
+	return highlightJSON(data)
+}
+
+func encryptedEventSource(evt *muksevt.Event) (string, bool) {
+	if evt == nil || evt.Gomuks.Encryption == nil || len(evt.Gomuks.Encryption.Source) == 0 {
+		return "", false
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, evt.Gomuks.Encryption.Source, "", "  "); err != nil {
+		return escapeColorTags(fmt.Sprintf("Failed to format encrypted event: %v", err), "-"), true
+	}
+	return highlightJSON(buf.Bytes()), true
+}
+
+func eventDebugInfo(parent *MainView, msg *messages.UIMessage) string {
+	evt := msg.Event
+	if evt == nil {
+		return "No debug info available"
+	}
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "Event ID: %s\n", evt.ID)
+	_, _ = fmt.Fprintf(&buf, "Type: %s\n", evt.Type.Repr())
+	_, _ = fmt.Fprintf(&buf, "Sender: %s\n", evt.Sender)
+	_, _ = fmt.Fprintf(&buf, "Origin server: %s\n", eventOriginServer(evt))
+	_, _ = fmt.Fprintf(&buf, "Timestamp: %s\n", time.Unix(evt.Timestamp/1000, evt.Timestamp%1000*int64(time.Millisecond)).Format(time.RFC3339))
+
+	switch {
+	case msg.State == muksevt.StateLocalEcho:
+		_, _ = fmt.Fprintf(&buf, "Transaction ID: %s (local echo, waiting for remote echo)\n", msg.TxnID)
+	case msg.State == muksevt.StateSendFail:
+		_, _ = fmt.Fprintf(&buf, "Transaction ID: %s (sending failed)\n", msg.TxnID)
+	case len(evt.Unsigned.TransactionID) > 0 && evt.Unsigned.TransactionID == msg.TxnID:
+		_, _ = fmt.Fprintf(&buf, "Transaction ID: %s (remote echo matched local echo)\n", evt.Unsigned.TransactionID)
+	case len(msg.TxnID) > 0:
+		_, _ = fmt.Fprintf(&buf, "Transaction ID: %s (remote echo has %q)\n", msg.TxnID, evt.Unsigned.TransactionID)
+	default:
+		buf.WriteString("Transaction ID: none (not sent from this device)\n")
+	}
+
+	unsigned, err := json.MarshalIndent(&evt.Unsigned, "", "  ")
+	if err != nil {
+		_, _ = fmt.Fprintf(&buf, "\nUnsigned data: failed to marshal: %v\n", err)
+	} else {
+		_, _ = fmt.Fprintf(&buf, "\nUnsigned data:\n%s\n", unsigned)
+	}
+
+	buf.WriteString("\nEncryption: ")
+	if enc := evt.Gomuks.Encryption; enc != nil {
+		buf.WriteString("decrypted\n")
+		_, _ = fmt.Fprintf(&buf, "Algorithm: %s\n", enc.Algorithm)
+		_, _ = fmt.Fprintf(&buf, "Session ID: %s\n", enc.SessionID)
+		_, _ = fmt.Fprintf(&buf, "Sender key: %s\n", enc.SenderKey)
+		_, _ = fmt.Fprintf(&buf, "Sender device: %s\n", enc.DeviceID)
+		_, _ = fmt.Fprintf(&buf, "Verification: %s\n", describeSenderDevice(parent.matrix, evt.Sender, enc))
+	} else if content, ok := evt.Content.Parsed.(*muksevt.BadEncryptedContent); ok {
+		buf.WriteString("failed to decrypt\n")
+		_, _ = fmt.Fprintf(&buf, "Reason: %s\n", content.Reason)
+		if content.Original != nil {
+			_, _ = fmt.Fprintf(&buf, "Session ID: %s\n", content.Original.SessionID)
+			_, _ = fmt.Fprintf(&buf, "Sender device: %s\n", content.Original.DeviceID)
+		}
+	} else {
+		buf.WriteString("not encrypted\n")
+	}
+	return buf.String()
+}
+
+func eventOriginServer(evt *muksevt.Event) string {
+	// Event IDs in old room versions contain the origin server.
+	if colon := strings.IndexRune(string(evt.ID), ':'); colon != -1 {
+		return string(evt.ID)[colon+1:]
+	}
+	_, server, err := evt.Sender.Parse()
+	if err != nil {
+		return "unknown"
+	}
+	return server
+}
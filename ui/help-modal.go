@@ -8,61 +8,256 @@ import (
 
 const helpText = `# General
 /help           - Show this help dialog.
+Alt+Shift+P, F1 - Open the command palette for searching commands,
+                  keybindings and rooms.
 /quit           - Quit gomuks.
 /clearcache     - Clear cache and quit gomuks.
+/purgecache <here|all|room> [days]
+                - Delete downloaded media of a room or all rooms, optionally
+                  only files older than the given number of days.
+/cache [stats]  - Show the size of the media cache and its configured limit.
+/cache clear    - Delete all downloaded media from the cache.
+/prune [days]   - Delete old events from the local history cache using the
+                  configured retention limits, or the given maximum age.
 /logout         - Log out of Matrix.
 /toggle <thing> - Temporary command to toggle various UI features.
+/codetheme [theme|reset]
+                - Show or change the syntax highlighting theme of code blocks.
+/sendercolor [palette <default|terminal|custom> [colors...]]
+                - Show or change the palette user name colors are picked from.
+                  The terminal palette follows the color theme of the terminal.
+/sendercolor <user ID> <color|reset>
+                - Always show the given user in the given color.
+                  Use /toggle bodycolors to color whole messages by sender.
+/pane <side|below|close|next|prev>
+                - Split the current pane to show another room next to it or
+                  below it, close the pane or move to another pane. Also
+                  Alt+V, Alt+B, Alt+X and Alt+W. Picking the current room
+                  in a new pane shows a second, independently scrolling
+                  timeline of it.
+Alt+U           - Focus the member list, which is sorted by power level and
+                  recent activity. Typing filters it, Enter shows the
+                  selected member's profile, Tab mentions them, Ctrl+D opens
+                  a direct chat and Ctrl+K and Ctrl+B kick and ban them.
+/toggle vim     - Use vim-style modal input. Esc enters normal mode, where
+                  j/k scroll, d/u scroll half a page, g/G go to the top or
+                  bottom, J/K switch rooms, h moves to the room list (l opens
+                  a room from there), v selects messages to yank, r and e
+                  reply and edit, o numbers links and i, a or : go back to
+                  insert mode.
+
+/notifications log [count] - Show recent notifications, including
+                             suppressed ones, and the push rules that
+                             decided them.
+/sent [all] [count]        - Show the events gomuks sent to this room
+                             (or all rooms) and whether they went through.
+/export-session <file>     - Move the whole session (login, encryption
+                             keys and preferences) into a passphrase-
+                             protected file for gomuks --import-session.
+                             The session is removed from this machine.
+/nowplaying [on|off]       - Toggle setting the status message from the
+                             now_playing command in config.yaml.
+/import-irc <file>         - Import ignored nicks and highlight words from
+                             a WeeChat or irssi config file.
+/connection                - Show the address and TLS details of the
+                             connection to the homeserver.
 
 # Media
 /download [path] - Downloads file from selected message.
-/open [path]     - Download file from selected message and open it with xdg-open.
+/downloads       - Show recent downloads and their progress.
+/downloads dir <path>
+                 - Change the directory files are downloaded to.
+/open [path]     - Download file from selected message and open it with the
+                   media_viewers program for its type, or xdg-open.
+/play           - Play the selected audio message with the configured player.
+                   Alt+Space pauses, Alt+, and Alt+. seek and Alt+S stops.
+/videoframe      - Download the selected video and show a preview frame of it
+                   extracted with ffmpeg, if it doesn't have a thumbnail.
+/voice           - Start recording a voice message, or send the recording.
+/voice cancel    - Discard the voice message being recorded.
 /upload <path>   - Upload the file at the given path to the current room.
+/upload --cancel - Cancel the uploads in progress in the current room.
+/paste           - Upload the image in the clipboard. Pasting in the input
+                   also uploads the image if the clipboard has no text.
+/links [number]  - Number the links on the screen (also Alt+O) so that they
+                   can be opened by typing the number, or open one directly.
+                   Links are clickable in terminals that support OSC 8,
+                   unless disabled with /toggle hyperlinks.
 
 # Sending special messages
+Alt+M                - Preview how the message being composed will look.
 /me <message>        - Send an emote message.
 /notice <message>    - Send a notice (generally used for bot messages).
 /rainbow <message>   - Send rainbow text.
 /rainbowme <message> - Send rainbow text in an emote.
+/spoiler [reason |] <message>
+                     - Send a message hidden behind a spoiler.
+/send-later <when> <message>
+                     - Send a message later. <when> is a delay (e.g. 1h30m),
+                       a time (18:30) or a date and time (2021-01-02T18:30).
+/send-later [cancel <number>]
+                     - List or cancel the messages scheduled in this room.
+/send-queue [discard <number>]
+                     - List the messages waiting to be sent in this room after
+                       a network or server error, or discard one of them.
+/template <subcommand> - Manage message templates, or use one with /template use <name>.
+/location <lat>,<lon> [description]
+                     - Send a static location.
+/sticker [shortcode]  - Send a sticker from the room or account image packs.
+                       Without a shortcode, opens a picker.
+/rawsend <event type> <json>
+                     - Send an arbitrary event to the current room after confirming.
+/rawstate <event type> <state key/-> <json>
+                     - Send an arbitrary state event after confirming.
 /reply [text]        - Reply to the selected message.
-/react <reaction>    - React to the selected message.
+/react [reaction]    - React to the selected message, or remove your
+                       own reaction. Without a reaction, opens a picker.
 /redact [reason]     - Redact the selected message.
+/redact <filters> [reason]
+                     - Redact recent messages in bulk. Filters are --mine <count>,
+                       --sender <user ID> and --match <regex>, optionally with
+                       --limit <n> for how many recent messages to look at (100).
+                       Use -- before a reason that starts with --.
 /edit                - Edit the selected message.
+/inspect             - View the source and debug info of the selected message.
+                       Can also be opened with v while selecting a message.
+/profile [user ID]   - View the profile of the given user or the sender of the
+                       selected message, with their devices, shared rooms and
+                       actions for starting a direct chat, mentioning, ignoring
+                       and verifying them.
+/roominfo            - Show the name, topic, avatar, addresses, encryption, join
+                       rule, members and power levels of the current room, and
+                       edit the settings you have permission to change. The
+                       avatar can be set to an mxc:// URI or a file to upload.
+/powerlevels         - Edit the user levels, event type requirements and default
+                       levels of the current room. Changes are checked against
+                       your own level and only sent when saving with s.
+/op <user> [level]   - Set the power level of a user (50 by default).
+/deop <user>         - Reset the power level of a user to the default.
+/roomstate           - Explore the raw state events of the current room.
+/accountdata [--room] <type>
+                     - Edit the JSON of a global account data event, or one of
+                       the current room with --room.
+/pin                 - Pin the selected message.
+/unpin [number]      - Unpin the selected message, or the given message from /pins.
+/pins                - View the pinned messages in this room.
+/sticky              - Keep the selected message visible above the timeline
+                       while scrolling. Only affects this client.
+/unsticky            - Remove the sticky message.
+/poll [--multiple <n>] [--undisclosed] <question> | <answer> | <answer> ...
+                     - Create a poll. --multiple allows choosing up to n answers,
+                       --undisclosed hides the results until the poll ends.
+/vote <number...>    - Vote for the given answers in the selected poll.
+/unvote              - Retract your vote in the selected poll.
+/endpoll             - End the selected poll you started.
+/permalink [register] - Copy a matrix.to link to the selected message.
+/goto [link]         - Open a matrix.to or matrix: link to a room or message,
+                       or the first such link in the selected message.
+/date [YYYY-MM-DD [HH:MM]]
+                     - Jump to the first message at the given date, or pick
+                       a date from a calendar.
+/yank [text|formatted|sender|id] [clipboard|primary]
+                     - Copy the selected message. Press V while selecting to
+                       select a range of messages, then y or Enter to copy.
+/expand              - Expand or collapse the <details> sections
+                       of the selected message. Can also be done with ctrl-click.
+                       Press s while selecting a message to reveal its spoilers.
+/edits               - View the edit history of the selected message. Can also
+                       be opened with e while selecting a message.
+/thread              - Open the thread of the selected message. Messages sent
+                       while a thread is open go into the thread. Esc closes it.
 
 # Encryption
 /fingerprint - View the fingerprint of your device.
 
+/devices                         - Manage your own devices.
 /devices <user id>               - View the device list of a user.
+/rename-device <device id> <name> - Rename one of your devices.
+/delete-device <device id>       - Delete (log out) one of your devices.
 /device <user id> <device id>    - Show info about a specific device.
 /unverify <user id> <device id>  - Un-verify a device.
 /blacklist <user id> <device id> - Blacklist a device.
 /verify <user id> <device id> [fingerprint]
     - Verify a device. If the fingerprint is not provided,
       interactive emoji verification will be started.
+/verify-qr <device id> [code]
+    - Verify one of your own devices with a QR code. If the
+      other device shows a code, enter its base64 contents.
 /reset-session - Reset the outbound Megolm session in the current room.
+/enable-encryption - Enable encryption in the current room. This can't be undone.
+/request-keys  - Request the keys for the selected undecryptable message
+                 from your other devices and the sender.
 
-/import <file> - Import encryption keys
-/export <file> - Export encryption keys
+/import <file> - Import encryption keys from a passphrase-protected
+                 key export file (e.g. one created by Element).
+/export <file> - Export encryption keys into a passphrase-protected
+                 file that can be imported into Element.
 /export-room <file> - Export encryption keys for the current room.
 
+/ssss <subcommand> [...]          - Manage secret storage. Use /ssss bootstrap
+                                    to set it up on a fresh account.
+/cross-signing <subcommand> [...] - Manage cross-signing keys.
+/dehydrate <subcommand>           - Manage the dehydrated device that receives keys
+                                    while you have no devices logged in.
+
 # Rooms
 /pm <user id> <...>   - Create a private chat with the given user(s).
 /create [room name]   - Create a room.
 
 /join <room> [server] - Join a room.
+/archive [number]     - Browse rooms you have left from the local cache.
+/predecessor          - Go to the room that the current room was upgraded from.
+/successor            - Go to the room that replaced the current room.
 /accept               - Accept the invite.
 /reject               - Reject the invite.
 
 /invite <user id>     - Invite the given user to the room.
+/invite-many <user ids|--file path>
+                      - Invite a comma-separated list or file of users.
 /roomnick <name>      - Change your per-room displayname.
 /tag <tag> <priority> - Add the room to <tag>.
 /untag <tag>          - Remove the room from <tag>.
 /tags                 - List the tags the room is in.
+/roomsort [mode] [section]
+                      - Sort the room list or one section of it by recent
+                        activity, unread first, alphabetically or manually
+                        by tag order. Use default to remove a section override.
+Alt+Shift+S           - Cycle the sort mode of the room list. In the focused
+                        room list, s cycles the mode of the selected section
+                        and Shift+Up/Down or K/J move rooms in manual sections.
 /alias <act> <name>   - Add or remove local addresses.
+/imagescale <scale> [max rows]
+                      - Change the size of inline images in this room.
+/receipts <public|private>
+                      - Choose whether read receipts in this room are public.
+/typing <on|off|default>
+                      - Override whether typing notifications are sent here.
+/urlpreviews <on|off|default>
+                      - Override whether link previews are shown here. They
+                        are only shown in unencrypted rooms by default.
+/display [room] <compact|cozy|grouped|default> [minutes]
+                      - Show messages on one line each, normally, or without
+                        repeating the sender of consecutive messages sent
+                        within the given minutes, globally or in this room.
+/translate [<language> [--original] | off]
+                      - Translate outgoing messages in this room with the
+                        translation command in config.yaml, optionally
+                        including the original text in the formatted body.
+/guidance <text|topic|off|default>
+                      - Set the text shown in the empty composer here, e.g.
+                        posting guidelines. Rooms can also provide it in a
+                        net.maunium.gomuks.composer_guidance state event.
+/roomsettings         - Show the local settings of this room.
+/search [--room] <text>
+                      - Search messages on the server, optionally only in the
+                        current room. Encrypted rooms can't be searched.
 
 /leave                     - Leave the current room.
 /kick   <user id> [reason] - Kick a user.
 /ban    <user id> [reason] - Ban a user.
-/unban  <user id>          - Unban a user.`
+/unban  <user id>          - Unban a user.
+/modqueue [list|approve|deny]
+                           - Review pending invites and knocks in rooms you moderate.`
 
 type HelpModal struct {
 	mauview.FocusableComponent
@@ -0,0 +1,199 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/lib/open"
+	"maunium.net/go/gomuks/ui/widget"
+)
+
+// hyperlinksQueued is set while writing the hyperlinks of the latest frame is waiting in the update queue.
+var hyperlinksQueued int32
+
+// queueHyperlinks schedules writing the hyperlinks of the frame being drawn after the frame has been
+// flushed to the terminal. Multiple frames drawn in a row only cause one write.
+func (ui *GomuksUI) queueHyperlinks() {
+	if atomic.CompareAndSwapInt32(&hyperlinksQueued, 0, 1) {
+		go ui.app.QueueUpdate(ui.writeHyperlinks)
+	}
+}
+
+// writeHyperlinks makes the links on the screen clickable in terminals that support OSC 8 hyperlinks.
+//
+// tcell doesn't know about hyperlinks, so the cells of each link are written again wrapped in OSC 8
+// sequences after tcell has drawn them. The cursor position and attributes are saved and restored around
+// the sequences so that tcell's idea of the terminal state stays correct.
+func (ui *GomuksUI) writeHyperlinks() {
+	atomic.StoreInt32(&hyperlinksQueued, 0)
+	screen := ui.app.Screen()
+	links := widget.Hyperlinks()
+	if screen == nil || len(links) == 0 {
+		return
+	}
+	var buf strings.Builder
+	buf.WriteString("\x1b7")
+	for _, link := range links {
+		_, _ = fmt.Fprintf(&buf, "\x1b[%d;%dH\x1b]8;;%s\x1b\\", link.Y+1, link.X+1, sanitizeHyperlink(link.URL))
+		for x := link.X; x < link.X+link.Width; {
+			mainc, combc, style, width := screen.GetContent(x, link.Y)
+			if mainc == 0 {
+				mainc = ' '
+			}
+			buf.WriteString(styleToSGR(style))
+			buf.WriteRune(mainc)
+			for _, r := range combc {
+				buf.WriteRune(r)
+			}
+			if width < 1 {
+				width = 1
+			}
+			x += width
+		}
+		buf.WriteString("\x1b]8;;\x1b\\")
+	}
+	buf.WriteString("\x1b8")
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		debug.Print("Failed to open terminal for writing hyperlinks:", err)
+		return
+	}
+	defer tty.Close()
+	_, _ = tty.WriteString(buf.String())
+}
+
+// sanitizeHyperlink percent-encodes everything except printable ASCII in the URL, so that it can't end the
+// escape sequence early or inject other escape sequences.
+func sanitizeHyperlink(url string) string {
+	var buf strings.Builder
+	for _, b := range []byte(url) {
+		if b <= ' ' || b >= 0x7f {
+			_, _ = fmt.Fprintf(&buf, "%%%02X", b)
+		} else {
+			buf.WriteByte(b)
+		}
+	}
+	return buf.String()
+}
+
+var sgrAttributes = []struct {
+	attr tcell.AttrMask
+	code string
+}{
+	{tcell.AttrBold, "1"},
+	{tcell.AttrDim, "2"},
+	{tcell.AttrItalic, "3"},
+	{tcell.AttrUnderline, "4"},
+	{tcell.AttrBlink, "5"},
+	{tcell.AttrReverse, "7"},
+	{tcell.AttrStrike, "9"},
+}
+
+// styleToSGR returns the SGR escape sequence that sets the terminal attributes to the given style.
+func styleToSGR(style tcell.Style) string {
+	fg, bg, attrs := style.Decompose()
+	codes := []string{"0"}
+	for _, attr := range sgrAttributes {
+		if attrs&attr.attr != 0 {
+			codes = append(codes, attr.code)
+		}
+	}
+	if fg != tcell.ColorDefault {
+		codes = append(codes, colorToSGR(fg, 38))
+	}
+	if bg != tcell.ColorDefault {
+		codes = append(codes, colorToSGR(bg, 48))
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m"
+}
+
+func colorToSGR(color tcell.Color, base int) string {
+	if color >= 0 && color < 256 {
+		return fmt.Sprintf("%d;5;%d", base, color)
+	}
+	r, g, b := color.RGB()
+	return fmt.Sprintf("%d;2;%d;%d;%d", base, r, g, b)
+}
+
+// ShowLinkHints numbers the links visible in the timeline so that they can be opened by typing the number.
+// Returns false if there are no visible links.
+func (view *RoomView) ShowLinkHints() bool {
+	msgView := view.MessageView()
+	if len(msgView.links) == 0 {
+		return false
+	}
+	msgView.LinkHints = true
+	view.linkHintInput = ""
+	return true
+}
+
+// HideLinkHints stops numbering the links in the timeline.
+func (view *RoomView) HideLinkHints() {
+	view.MessageView().LinkHints = false
+	view.linkHintInput = ""
+}
+
+// OpenLink opens the link with the given number in the link hints in the browser.
+func (view *RoomView) OpenLink(number int) {
+	view.HideLinkHints()
+	links := view.MessageView().links
+	if number < 1 || number > len(links) {
+		view.AddServiceMessage(fmt.Sprintf("There's no link number %d on the screen.", number))
+		return
+	}
+	url := links[number-1].URL
+	go func() {
+		if err := open.Open(url); err != nil {
+			view.AddServiceMessage(fmt.Sprintf("Failed to open %s: %v", url, err))
+			view.parent.parent.Render()
+		}
+	}()
+}
+
+func (view *RoomView) onLinkHintKey(event mauview.KeyEvent) bool {
+	k := event.Key()
+	c := event.Rune()
+	switch {
+	case c >= '0' && c <= '9':
+		view.linkHintInput += string(c)
+		number, _ := strconv.Atoi(view.linkHintInput)
+		// Open the link right away if typing more digits can't lead to another link.
+		if number*10 > len(view.MessageView().links) {
+			view.OpenLink(number)
+		}
+	case (k == tcell.KeyBackspace || k == tcell.KeyBackspace2) && len(view.linkHintInput) > 0:
+		view.linkHintInput = view.linkHintInput[:len(view.linkHintInput)-1]
+	case k == tcell.KeyEnter && len(view.linkHintInput) > 0:
+		number, _ := strconv.Atoi(view.linkHintInput)
+		view.OpenLink(number)
+	case k == tcell.KeyEscape:
+		view.HideLinkHints()
+	default:
+		view.HideLinkHints()
+		return false
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/lib/ratelimit"
+)
+
+const (
+	// InviteManyDelay is the pause between invites sent by /invite-many.
+	InviteManyDelay = 500 * time.Millisecond
+	// inviteManyProgressInterval is how often /invite-many reports progress.
+	inviteManyProgressInterval = 10
+)
+
+const inviteManyHelp = `Usage: /%s <user id>[,<user id>...]
+       /%[1]s --file <path>
+
+The file should contain one user ID per line. Empty lines and lines starting with # are ignored.`
+
+// readUserIDFile reads a list of user IDs from a file with one ID per line.
+func readUserIDFile(path string) ([]id.UserID, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var userIDs []id.UserID
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 && line[0] != '#' {
+			userIDs = append(userIDs, id.UserID(line))
+		}
+	}
+	return userIDs, scanner.Err()
+}
+
+func parseUserIDList(args []string) []id.UserID {
+	var userIDs []id.UserID
+	for _, part := range strings.Split(strings.Join(args, ","), ",") {
+		part = strings.TrimSpace(part)
+		if len(part) > 0 {
+			userIDs = append(userIDs, id.UserID(part))
+		}
+	}
+	return userIDs
+}
+
+func cmdInviteMany(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmd.Reply(inviteManyHelp, cmd.OrigCommand)
+		return
+	}
+	var userIDs []id.UserID
+	if cmd.Args[0] == "--file" {
+		if len(cmd.Args) < 2 {
+			cmd.Reply(inviteManyHelp, cmd.OrigCommand)
+			return
+		}
+		var err error
+		userIDs, err = readUserIDFile(strings.Join(cmd.Args[1:], " "))
+		if err != nil {
+			cmd.Reply("Failed to read user list: %v", err)
+			return
+		}
+	} else {
+		userIDs = parseUserIDList(cmd.Args)
+	}
+	if len(userIDs) == 0 {
+		cmd.Reply("No user IDs given")
+		return
+	}
+	go inviteMany(cmd, cmd.Room.MxRoom().ID, userIDs)
+}
+
+func inviteWithRetry(client *mautrix.Client, roomID id.RoomID, userID id.UserID) error {
+	return ratelimit.Retry(fmt.Sprintf("inviting %s to %s", userID, roomID), func() error {
+		_, err := client.InviteUser(roomID, &mautrix.ReqInviteUser{UserID: userID})
+		return err
+	})
+}
+
+// dedupeUserIDs removes repeated user IDs from the list, keeping the first occurrence of each.
+func dedupeUserIDs(userIDs []id.UserID) []id.UserID {
+	seen := make(map[id.UserID]struct{}, len(userIDs))
+	deduped := userIDs[:0]
+	for _, userID := range userIDs {
+		if _, ok := seen[userID]; !ok {
+			seen[userID] = struct{}{}
+			deduped = append(deduped, userID)
+		}
+	}
+	return deduped
+}
+
+func inviteMany(cmd *Command, roomID id.RoomID, userIDs []id.UserID) {
+	defer debug.Recover()
+	userIDs = dedupeUserIDs(userIDs)
+	cmd.Reply("Inviting %d users...", len(userIDs))
+	failures := make(map[id.UserID]string)
+	valid := make([]id.UserID, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if _, _, err := userID.Parse(); err != nil {
+			failures[userID] = "invalid user ID"
+		} else {
+			valid = append(valid, userID)
+		}
+	}
+	for i, userID := range valid {
+		if i > 0 {
+			time.Sleep(InviteManyDelay)
+		}
+		if err := inviteWithRetry(cmd.Matrix.Client(), roomID, userID); err != nil {
+			failures[userID] = niceError(err)
+		}
+		if (i+1)%inviteManyProgressInterval == 0 && i+1 < len(valid) {
+			cmd.Reply("Invited %d/%d users (%d failed)", i+1+len(userIDs)-len(valid)-len(failures), len(userIDs), len(failures))
+		}
+	}
+	if len(failures) == 0 {
+		cmd.Reply("Successfully invited all %d users", len(userIDs))
+		return
+	}
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "Invited %d/%d users. Failed invites:", len(userIDs)-len(failures), len(userIDs))
+	for _, userID := range userIDs {
+		if reason, failed := failures[userID]; failed {
+			_, _ = fmt.Fprintf(&buf, "\n* %s: %s", userID, reason)
+		}
+	}
+	cmd.Reply(buf.String())
+}
@@ -0,0 +1,286 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/pushrules"
+
+	"maunium.net/go/gomuks/debug"
+)
+
+const importIRCHelp = `Usage: /%s [weechat|irssi] <config file>
+
+Imports the ignore and highlight lists of an IRC client. Ignored nicks are added to the ignored users of
+your account by looking them up in the member lists of your rooms, and highlight words are added as
+keyword notification rules. For WeeChat, import both weechat.conf (highlights) and irc.conf (ignores).`
+
+// ircImport contains the ignores and highlights read from the config of an IRC client.
+type ircImport struct {
+	ignores    []string
+	highlights []string
+	skipped    []string
+}
+
+var weechatSectionRegex = regexp.MustCompile(`^\[([a-z_]+)\]$`)
+var weechatOptionRegex = regexp.MustCompile(`^([a-z_.]+)\s*=\s*"((?:[^"\\]|\\.)*)"$`)
+
+// parseWeechatConfig reads the highlight option of weechat.conf and the ignores of irc.conf.
+func parseWeechatConfig(path string) (*ircImport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	result := &ircImport{}
+	var section string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if match := weechatSectionRegex.FindStringSubmatch(line); match != nil {
+			section = match[1]
+			continue
+		}
+		match := weechatOptionRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		key, value := match[1], strings.ReplaceAll(match[2], `\"`, `"`)
+		switch {
+		case section == "look" && key == "highlight":
+			for _, word := range strings.Split(value, ",") {
+				if word = strings.TrimSpace(word); len(word) > 0 {
+					result.highlights = append(result.highlights, word)
+				}
+			}
+		case section == "look" && key == "highlight_regex" && len(value) > 0:
+			result.skipped = append(result.skipped, fmt.Sprintf("highlight regex %s", value))
+		case section == "ignore" && key == "ignore":
+			// The format is server;channel;nick regex
+			parts := strings.Split(value, ";")
+			nick := strings.TrimSuffix(strings.TrimPrefix(parts[len(parts)-1], "^"), "$")
+			if len(parts) != 3 || strings.ContainsAny(nick, `\.*+?()[]{}|`) {
+				result.skipped = append(result.skipped, fmt.Sprintf("ignore %s", value))
+			} else {
+				result.ignores = append(result.ignores, nick)
+			}
+		}
+	}
+	return result, scanner.Err()
+}
+
+var irssiBlockRegex = regexp.MustCompile(`(?s)\b(ignores|hilights)\s*=\s*\((.*?)\)\s*;`)
+var irssiEntryRegex = regexp.MustCompile(`(?s)\{(.*?)\}`)
+var irssiFieldRegex = regexp.MustCompile(`(\w+)\s*=\s*"((?:[^"\\]|\\.)*)"`)
+
+// parseIrssiConfig reads the ignores and hilights blocks of an irssi config file.
+func parseIrssiConfig(path string) (*ircImport, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	result := &ircImport{}
+	for _, block := range irssiBlockRegex.FindAllStringSubmatch(string(data), -1) {
+		for _, entry := range irssiEntryRegex.FindAllStringSubmatch(block[2], -1) {
+			fields := make(map[string]string)
+			for _, field := range irssiFieldRegex.FindAllStringSubmatch(entry[1], -1) {
+				fields[field[1]] = field[2]
+			}
+			switch {
+			case block[1] == "hilights" && fields["regexp"] == "yes":
+				result.skipped = append(result.skipped, fmt.Sprintf("hilight regex %s", fields["text"]))
+			case block[1] == "hilights" && len(fields["text"]) > 0:
+				result.highlights = append(result.highlights, fields["text"])
+			case block[1] == "ignores" && fields["except"] == "yes":
+				// Exceptions to other ignores don't need to be imported.
+			case block[1] == "ignores" && len(fields["mask"]) > 0:
+				// Only plain nicks can be mapped to Matrix users, not hostmasks.
+				nick := strings.TrimSuffix(fields["mask"], "!*@*")
+				if strings.ContainsAny(nick, "*?!@") {
+					result.skipped = append(result.skipped, fmt.Sprintf("ignore %s", fields["mask"]))
+				} else {
+					result.ignores = append(result.ignores, nick)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// detectIRCConfigFormat guesses whether the file is a WeeChat or irssi config.
+func detectIRCConfigFormat(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if irssiBlockRegex.Match(data) {
+		return "irssi", nil
+	}
+	return "weechat", nil
+}
+
+type reqPutKeywordRule struct {
+	Actions []interface{} `json:"actions"`
+	Pattern string        `json:"pattern"`
+}
+
+// addKeywordRule adds a content push rule that highlights messages containing the given keyword.
+func addKeywordRule(client *mautrix.Client, keyword string) error {
+	req := &reqPutKeywordRule{
+		Actions: []interface{}{
+			pushrules.ActionNotify,
+			map[string]interface{}{"set_tweak": pushrules.TweakSound, "value": "default"},
+			map[string]interface{}{"set_tweak": pushrules.TweakHighlight},
+		},
+		Pattern: keyword,
+	}
+	_, err := client.MakeRequest("PUT", client.BuildURL("pushrules", "global", "content", keyword), req, nil)
+	return err
+}
+
+// addIgnoredUsers adds the given users to the m.ignored_user_list account data and returns the number of new entries.
+func addIgnoredUsers(client *mautrix.Client, userIDs []id.UserID) (int, error) {
+	var content event.IgnoredUserListEventContent
+	err := client.GetAccountData(event.AccountDataIgnoredUserList.Type, &content)
+	if httpErr, ok := err.(mautrix.HTTPError); ok && httpErr.RespError != nil && httpErr.RespError.ErrCode == "M_NOT_FOUND" {
+		err = nil
+	} else if err != nil {
+		return 0, err
+	}
+	if content.IgnoredUsers == nil {
+		content.IgnoredUsers = make(map[id.UserID]event.IgnoredUser)
+	}
+	added := 0
+	for _, userID := range userIDs {
+		if _, ok := content.IgnoredUsers[userID]; !ok {
+			content.IgnoredUsers[userID] = event.IgnoredUser{}
+			added++
+		}
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, client.SetAccountData(event.AccountDataIgnoredUserList.Type, &content)
+}
+
+func cmdImportIRC(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmd.Reply(importIRCHelp, cmd.OrigCommand)
+		return
+	}
+	format := ""
+	args := cmd.Args
+	if args[0] == "weechat" || args[0] == "irssi" {
+		format, args = args[0], args[1:]
+	}
+	if len(args) == 0 {
+		cmd.Reply(importIRCHelp, cmd.OrigCommand)
+		return
+	}
+	path := strings.Join(args, " ")
+	go func() {
+		defer debug.Recover()
+		var err error
+		if len(format) == 0 {
+			if format, err = detectIRCConfigFormat(path); err != nil {
+				cmd.Reply("Failed to read %s: %v", path, err)
+				return
+			}
+		}
+		var imported *ircImport
+		if format == "irssi" {
+			imported, err = parseIrssiConfig(path)
+		} else {
+			imported, err = parseWeechatConfig(path)
+		}
+		if err != nil {
+			cmd.Reply("Failed to read %s: %v", path, err)
+			return
+		}
+		importIRC(cmd, format, imported)
+	}()
+}
+
+func importIRC(cmd *Command, format string, imported *ircImport) {
+	var userIDs []id.UserID
+	var notFound []string
+	for _, nick := range imported.ignores {
+		found := cmd.Config.Rooms.FindMembersByNick(nick)
+		if len(found) == 0 {
+			notFound = append(notFound, nick)
+		}
+		userIDs = append(userIDs, found...)
+	}
+
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "Found %d ignores and %d highlights in the %s config.\n", len(imported.ignores), len(imported.highlights), format)
+	if len(userIDs) > 0 {
+		buf.WriteString("\nUsers to ignore:\n")
+		for _, userID := range userIDs {
+			_, _ = fmt.Fprintf(&buf, "* %s\n", userID)
+		}
+	}
+	if len(imported.highlights) > 0 {
+		_, _ = fmt.Fprintf(&buf, "\nKeywords to highlight: %s\n", strings.Join(imported.highlights, ", "))
+	}
+	if len(notFound) > 0 {
+		_, _ = fmt.Fprintf(&buf, "\nNicks not found in any room: %s\n", strings.Join(notFound, ", "))
+	}
+	if len(imported.skipped) > 0 {
+		_, _ = fmt.Fprintf(&buf, "\nEntries that can't be converted:\n* %s\n", strings.Join(imported.skipped, "\n* "))
+	}
+	summary := strings.TrimSpace(buf.String())
+	if len(userIDs) == 0 && len(imported.highlights) == 0 {
+		cmd.Reply("%s\n\nNothing to import.", summary)
+		return
+	} else if !cmd.MainView.AskConfirmation("Import IRC config", summary+"\n\nImport these entries?", 0) {
+		cmd.Reply("Import cancelled")
+		return
+	}
+
+	client := cmd.Matrix.Client()
+	var failed []string
+	added := 0
+	for _, keyword := range imported.highlights {
+		if err := addKeywordRule(client, keyword); err != nil {
+			failed = append(failed, fmt.Sprintf("highlight %s: %s", keyword, niceError(err)))
+		} else {
+			added++
+		}
+	}
+	ignored, err := addIgnoredUsers(client, userIDs)
+	if err != nil {
+		failed = append(failed, fmt.Sprintf("ignored users: %s", niceError(err)))
+	}
+	reply := fmt.Sprintf("Added %d keyword highlights and %d ignored users.", added, ignored)
+	if len(notFound) > 0 {
+		reply += fmt.Sprintf(" %d nicks weren't found in any room, try again after joining the rooms they're in.", len(notFound))
+	}
+	if len(failed) > 0 {
+		reply += "\nFailures:\n* " + strings.Join(failed, "\n* ")
+	}
+	cmd.Reply(reply)
+}
@@ -0,0 +1,105 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"bytes"
+	"strings"
+
+	"maunium.net/go/mauview"
+)
+
+const (
+	jsonKeyColor     = "blue"
+	jsonStringColor  = "green"
+	jsonNumberColor  = "yellow"
+	jsonLiteralColor = "purple"
+)
+
+// escapeColorTags escapes text so that the text view doesn't interpret any part of it as a color tag.
+// mauview.Escape doesn't handle empty brackets, which would otherwise be parsed as a style reset,
+// so those are split with a tag that keeps the given color.
+func escapeColorTags(text, color string) string {
+	parts := strings.Split(text, "[]")
+	for i, part := range parts {
+		parts[i] = mauview.Escape(part)
+	}
+	return strings.Join(parts, "[["+color+"]]")
+}
+
+func writeJSONToken(buf *strings.Builder, color string, token []byte) {
+	buf.WriteString("[" + color + "]")
+	buf.WriteString(escapeColorTags(string(token), color))
+	buf.WriteString("[-]")
+}
+
+func jsonTokenEnd(data []byte, start int, allowed string) int {
+	end := start + 1
+	for end < len(data) && strings.IndexByte(allowed, data[end]) != -1 {
+		end++
+	}
+	return end
+}
+
+// highlightJSON adds color tags to the given (preferably indented) JSON for displaying in a text view.
+func highlightJSON(data []byte) string {
+	var buf strings.Builder
+	plainStart := 0
+	flushPlain := func(end int) {
+		if end > plainStart {
+			buf.WriteString(escapeColorTags(string(data[plainStart:end]), "-"))
+		}
+	}
+	for i := 0; i < len(data); {
+		var color string
+		var end int
+		switch c := data[i]; {
+		case c == '"':
+			end = i + 1
+			for end < len(data) && data[end] != '"' {
+				if data[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end < len(data) {
+				end++
+			} else {
+				end = len(data)
+			}
+			color = jsonStringColor
+			if rest := bytes.TrimLeft(data[end:], " \t"); len(rest) > 0 && rest[0] == ':' {
+				color = jsonKeyColor
+			}
+		case c == '-' || (c >= '0' && c <= '9'):
+			end = jsonTokenEnd(data, i, "0123456789.eE+-")
+			color = jsonNumberColor
+		case c >= 'a' && c <= 'z':
+			end = jsonTokenEnd(data, i, "abcdefghijklmnopqrstuvwxyz")
+			color = jsonLiteralColor
+		default:
+			i++
+			continue
+		}
+		flushPlain(i)
+		writeJSONToken(&buf, color, data[i:end])
+		i = end
+		plainStart = end
+	}
+	flushPlain(len(data))
+	return buf.String()
+}
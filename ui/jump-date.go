@@ -0,0 +1,178 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/ui/widget"
+)
+
+// Date formats accepted by /date.
+var jumpDateFormats = []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"}
+
+// JumpToDate scrolls the timeline to the first message sent at or after the given time,
+// fetching it from the server if it hasn't been loaded.
+func (view *RoomView) JumpToDate(date time.Time) {
+	defer debug.Recover()
+	eventID, err := view.parent.matrix.TimestampToEvent(view.Room.ID, date, true)
+	if err != nil || len(eventID) == 0 {
+		// There may be no messages after the date, so try the last one before it.
+		eventID, err = view.parent.matrix.TimestampToEvent(view.Room.ID, date, false)
+	}
+	if err != nil {
+		view.AddServiceMessage(fmt.Sprintf("Failed to find a message near %s: %v", date.Format("2006-01-02 15:04"), err))
+		view.parent.parent.Render()
+		return
+	}
+	view.GoToEvent(eventID)
+}
+
+// calendar is a month view with a selected day.
+type calendar struct {
+	mauview.NoopEventHandler
+	selected time.Time
+}
+
+func (cal *calendar) Draw(screen mauview.Screen) {
+	width, _ := screen.Size()
+	headerStyle := tcell.StyleDefault.Bold(true)
+	widget.WriteLine(screen, mauview.AlignCenter, cal.selected.Format("January 2006"), 0, 0, width, headerStyle)
+	widget.WriteLine(screen, mauview.AlignCenter, "Mo Tu We Th Fr Sa Su", 0, 1, width, tcell.StyleDefault.Foreground(tcell.ColorGray))
+
+	gridX := (width - 20) / 2
+	year, month, _ := cal.selected.Date()
+	first := time.Date(year, month, 1, 0, 0, 0, 0, cal.selected.Location())
+	// Weeks start on Monday.
+	column := (int(first.Weekday()) + 6) % 7
+	today := time.Now()
+	row := 2
+	for day := first; day.Month() == month; day = day.AddDate(0, 0, 1) {
+		style := tcell.StyleDefault
+		if day.Day() == cal.selected.Day() {
+			style = style.Reverse(true)
+		} else if day.YearDay() == today.YearDay() && day.Year() == today.Year() {
+			style = style.Bold(true).Underline(true)
+		}
+		widget.WriteLine(screen, mauview.AlignRight, fmt.Sprintf("%2d", day.Day()), gridX+column*3, row, 2, style)
+		column++
+		if column == 7 {
+			column = 0
+			row++
+		}
+	}
+	widget.WriteLine(screen, mauview.AlignCenter, "Arrows move, PgUp/PgDn month", 0, 9, width, tcell.StyleDefault)
+	widget.WriteLine(screen, mauview.AlignCenter, "Enter to jump, Esc to cancel", 0, 10, width, tcell.StyleDefault)
+}
+
+// DatePickerModal is a calendar dialog for choosing a date to jump to in the timeline.
+type DatePickerModal struct {
+	mauview.Component
+	parent *MainView
+	room   *RoomView
+
+	container *mauview.Box
+	cal       *calendar
+}
+
+func NewDatePickerModal(parent *MainView, room *RoomView) *DatePickerModal {
+	dp := &DatePickerModal{
+		parent: parent,
+		room:   room,
+		cal:    &calendar{selected: time.Now()},
+	}
+	dp.container = mauview.NewBox(dp.cal).
+		SetBorder(true).
+		SetTitle("Jump to date").
+		SetBlurCaptureFunc(func() bool {
+			dp.parent.HideModal()
+			return true
+		})
+	dp.Component = mauview.Center(dp.container, 32, 13).SetAlwaysFocusChild(true)
+	return dp
+}
+
+func (dp *DatePickerModal) Focus() {
+	dp.container.Focus()
+}
+
+func (dp *DatePickerModal) Blur() {
+	dp.container.Blur()
+}
+
+func (dp *DatePickerModal) OnKeyEvent(event mauview.KeyEvent) bool {
+	cal := dp.cal
+	switch event.Key() {
+	case tcell.KeyEscape:
+		dp.parent.HideModal()
+	case tcell.KeyLeft:
+		cal.selected = cal.selected.AddDate(0, 0, -1)
+	case tcell.KeyRight:
+		cal.selected = cal.selected.AddDate(0, 0, 1)
+	case tcell.KeyUp:
+		cal.selected = cal.selected.AddDate(0, 0, -7)
+	case tcell.KeyDown:
+		cal.selected = cal.selected.AddDate(0, 0, 7)
+	case tcell.KeyPgUp:
+		cal.selected = cal.selected.AddDate(0, -1, 0)
+	case tcell.KeyPgDn:
+		cal.selected = cal.selected.AddDate(0, 1, 0)
+	case tcell.KeyHome:
+		cal.selected = time.Now()
+	case tcell.KeyEnter:
+		dp.parent.HideModal()
+		year, month, day := cal.selected.Date()
+		go dp.room.JumpToDate(time.Date(year, month, day, 0, 0, 0, 0, time.Local))
+	default:
+		switch event.Rune() {
+		case 'q':
+			dp.parent.HideModal()
+		case 'h':
+			cal.selected = cal.selected.AddDate(0, 0, -1)
+		case 'l':
+			cal.selected = cal.selected.AddDate(0, 0, 1)
+		case 'k':
+			cal.selected = cal.selected.AddDate(0, 0, -7)
+		case 'j':
+			cal.selected = cal.selected.AddDate(0, 0, 7)
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func cmdDate(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmd.MainView.ShowModal(NewDatePickerModal(cmd.MainView, cmd.Room))
+		return
+	}
+	input := strings.Join(cmd.Args, " ")
+	for _, format := range jumpDateFormats {
+		if date, err := time.ParseInLocation(format, input, time.Local); err == nil {
+			go cmd.Room.JumpToDate(date)
+			return
+		}
+	}
+	cmd.Reply("Usage: /date [YYYY-MM-DD [HH:MM]]")
+}
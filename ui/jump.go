@@ -0,0 +1,146 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"time"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/ui/messages"
+)
+
+// firstMessageSince returns the first message sent at or after the given time, and whether
+// the loaded messages go back far enough to be sure there are no earlier ones.
+func (view *MessageView) firstMessageSince(since time.Time) (*messages.UIMessage, bool) {
+	view.messagesLock.RLock()
+	defer view.messagesLock.RUnlock()
+	olderLoaded := false
+	for _, msg := range view.messages {
+		if msg.IsService {
+			continue
+		} else if msg.Timestamp.Before(since) {
+			olderLoaded = true
+		} else {
+			return msg, olderLoaded
+		}
+	}
+	return nil, olderLoaded
+}
+
+// findMention returns the closest highlighted message before or after the given message.
+// If from is nil, the search starts from the end of the timeline.
+func (view *MessageView) findMention(from *messages.UIMessage, backwards bool) *messages.UIMessage {
+	view.messagesLock.RLock()
+	defer view.messagesLock.RUnlock()
+	index := len(view.messages)
+	for i, msg := range view.messages {
+		if msg == from {
+			index = i
+			break
+		}
+	}
+	if backwards {
+		for i := index - 1; i >= 0; i-- {
+			if view.messages[i].IsHighlight {
+				return view.messages[i]
+			}
+		}
+	} else {
+		for i := index + 1; i < len(view.messages); i++ {
+			if view.messages[i].IsHighlight {
+				return view.messages[i]
+			}
+		}
+	}
+	return nil
+}
+
+// isOnScreen checks whether any line of the given message is currently visible.
+func (view *MessageView) isOnScreen(msg *messages.UIMessage) bool {
+	view.msgBufferLock.RLock()
+	defer view.msgBufferLock.RUnlock()
+	end := len(view.msgBuffer) - view.ScrollOffset
+	start := end - view.Height()
+	for i := start; i < end; i++ {
+		if i >= 0 && i < len(view.msgBuffer) && view.msgBuffer[i] == msg {
+			return true
+		}
+	}
+	return false
+}
+
+// centerMessage returns the message in the middle of the screen, or nil if the view is scrolled to the bottom.
+func (view *MessageView) centerMessage() *messages.UIMessage {
+	if view.ScrollOffset == 0 {
+		return nil
+	}
+	view.msgBufferLock.RLock()
+	defer view.msgBufferLock.RUnlock()
+	index := len(view.msgBuffer) - view.ScrollOffset - 1 - view.Height()/2
+	if index < 0 || index >= len(view.msgBuffer) {
+		return nil
+	}
+	return view.msgBuffer[index]
+}
+
+func (view *RoomView) jumpFailed(message string) {
+	view.AddServiceMessage(message)
+	view.parent.parent.Render()
+}
+
+// JumpToToday scrolls to the first message sent today.
+func (view *RoomView) JumpToToday() {
+	defer debug.Recover()
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if !view.scrollToMatch(func() (*messages.UIMessage, bool) {
+		return view.content.firstMessageSince(midnight)
+	}) {
+		view.jumpFailed("There are no messages from today in this room")
+	}
+}
+
+// JumpToMention scrolls to the previous or next message that mentions the user, starting from
+// the last mention that was jumped to if it's still on screen, or the middle of the screen otherwise.
+// Older history is loaded when looking backwards.
+func (view *RoomView) JumpToMention(backwards bool) {
+	defer debug.Recover()
+	msgView := view.content
+	from := view.mentionCursor
+	if from == nil || !msgView.isOnScreen(from) {
+		from = msgView.centerMessage()
+	}
+	var mention *messages.UIMessage
+	if backwards {
+		view.scrollToMatch(func() (*messages.UIMessage, bool) {
+			mention = msgView.findMention(from, true)
+			return mention, mention != nil
+		})
+	} else if mention = msgView.findMention(from, false); mention != nil {
+		msgView.ScrollToMessage(mention)
+		view.parent.parent.Render()
+	}
+	if mention == nil {
+		if backwards {
+			view.jumpFailed("No earlier mentions found in the recent history")
+		} else {
+			view.jumpFailed("No newer mentions found")
+		}
+		return
+	}
+	view.mentionCursor = mention
+}
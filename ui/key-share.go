@@ -0,0 +1,128 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// +build cgo
+
+package ui
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+)
+
+const KeyShareRequestTimeout = 60 * time.Second
+
+// QueueKeyShare queues asking the user whether the keys requested by one of their other devices should be
+// shared with it, and forwards the keys if they agree. Returns false if the question couldn't be queued.
+func (view *MainView) QueueKeyShare(device *crypto.DeviceIdentity, info event.RequestedKeyInfo) bool {
+	return view.queuePrompt(func() {
+		mach, ok := view.matrix.Crypto().(*crypto.OlmMachine)
+		if !ok || !view.askKeyShare(mach, device, info) {
+			return
+		}
+		if err := forwardRoomKey(mach, device, info); err != nil {
+			debug.Printf("Failed to forward keys for %s to %s: %v", info.SessionID, device.DeviceID, err)
+			view.ShowServiceMessage(fmt.Sprintf("Failed to share the keys for session %s: %v", info.SessionID, err))
+		} else {
+			view.ShowServiceMessage(fmt.Sprintf("Shared the keys for session %s with %s", info.SessionID, device.DeviceID))
+		}
+	})
+}
+
+func (view *MainView) askKeyShare(mach *crypto.OlmMachine, device *crypto.DeviceIdentity, info event.RequestedKeyInfo) bool {
+	roomName := string(info.RoomID)
+	if roomView, ok := view.getRoomView(info.RoomID, true); ok {
+		roomName = roomView.Room.GetTitle()
+	}
+	deviceName := string(device.DeviceID)
+	if len(device.Name) > 0 {
+		deviceName = fmt.Sprintf("%s (%s)", device.Name, device.DeviceID)
+	}
+	trust := "unverified"
+	if mach.IsDeviceTrusted(device) {
+		trust = "verified"
+	}
+	text := fmt.Sprintf("Your %s device %s requested the keys for session %s in %s.\n\nDo you want to share the keys?",
+		trust, deviceName, info.SessionID, roomName)
+	return view.AskConfirmation("Key request", text, KeyShareRequestTimeout)
+}
+
+// forwardRoomKey sends the requested megolm session to the given device.
+func forwardRoomKey(mach *crypto.OlmMachine, device *crypto.DeviceIdentity, info event.RequestedKeyInfo) error {
+	igs, err := mach.CryptoStore.GetGroupSession(info.RoomID, info.SenderKey, info.SessionID)
+	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	} else if igs == nil {
+		return fmt.Errorf("session not found")
+	}
+	exportedKey, err := igs.Internal.Export(igs.Internal.FirstKnownIndex())
+	if err != nil {
+		return fmt.Errorf("failed to export session: %w", err)
+	}
+	return mach.SendEncryptedToDevice(device, event.Content{
+		Parsed: &event.ForwardedRoomKeyEventContent{
+			RoomKeyEventContent: event.RoomKeyEventContent{
+				Algorithm:  id.AlgorithmMegolmV1,
+				RoomID:     igs.RoomID,
+				SessionID:  igs.ID(),
+				SessionKey: exportedKey,
+			},
+			SenderKey:          info.SenderKey,
+			ForwardingKeyChain: igs.ForwardingChains,
+			SenderClaimedKey:   igs.SigningKey,
+		},
+	})
+}
+
+func requestRoomKeys(view *RoomView, evt *muksevt.Event) {
+	defer debug.Recover()
+	content, ok := evt.Content.Parsed.(*muksevt.BadEncryptedContent)
+	if !ok || content.Original == nil {
+		view.AddServiceMessage("That message is not an undecryptable encrypted message")
+		view.parent.parent.Render()
+		return
+	}
+	mach, ok := view.parent.matrix.Crypto().(*crypto.OlmMachine)
+	if !ok {
+		return
+	}
+	// Only one request can be pending per session, so the keys are requested from the user's own devices,
+	// which will have them if any of the user's other clients were able to decrypt the message.
+	ctx, cancel := context.WithTimeout(context.Background(), KeyShareRequestTimeout)
+	defer cancel()
+	received, err := mach.RequestRoomKey(ctx, view.config.UserID, "*", view.Room.ID, content.Original.SenderKey, content.Original.SessionID)
+	if err != nil {
+		view.AddServiceMessage(fmt.Sprintf("Failed to send key request: %v", err))
+		view.parent.parent.Render()
+		return
+	}
+	view.AddServiceMessage(fmt.Sprintf("Sent key request for session %s", content.Original.SessionID))
+	view.parent.parent.Render()
+	if <-received {
+		view.AddServiceMessage(fmt.Sprintf("Received keys for session %s", content.Original.SessionID))
+	} else {
+		view.AddServiceMessage(fmt.Sprintf("Didn't receive keys for session %s", content.Original.SessionID))
+	}
+	view.parent.parent.Render()
+}
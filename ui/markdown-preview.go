@@ -0,0 +1,127 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"strings"
+
+	"github.com/alecthomas/chroma/styles"
+	"github.com/kyokomi/emoji/v2"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/config"
+	"maunium.net/go/gomuks/ui/messages"
+	"maunium.net/go/gomuks/ui/messages/html"
+)
+
+// MarkdownPreviewModal shows how the message being composed will look after it's rendered and sent,
+// including syntax highlighted code blocks.
+type MarkdownPreviewModal struct {
+	mauview.FocusableComponent
+	parent *MainView
+
+	box *mauview.Box
+}
+
+func NewMarkdownPreviewModal(parent *MainView, room *RoomView) *MarkdownPreviewModal {
+	mp := &MarkdownPreviewModal{parent: parent}
+	text := room.input.GetText()
+	if !parent.config.Preferences.DisableEmojis {
+		text = emoji.Sprint(text)
+	}
+	evt := parent.matrix.PrepareMarkdownMessage(room.Room.ID, event.MsgText, text, "", nil)
+	preview := &markdownPreview{
+		prefs: parent.config.Preferences,
+		msg:   messages.ParseEvent(parent.matrix, parent, room.Room, evt),
+	}
+
+	mp.box = mauview.NewBox(preview).
+		SetBorder(true).
+		SetTitle("Message preview").
+		SetBlurCaptureFunc(func() bool {
+			mp.parent.HideModal()
+			return true
+		})
+	mp.box.Focus()
+
+	mp.FocusableComponent = mauview.FractionalCenter(mp.box, 60, 10, 0.75, 0.75)
+	return mp
+}
+
+func (mp *MarkdownPreviewModal) OnKeyEvent(event mauview.KeyEvent) bool {
+	if event.Key() == tcell.KeyEscape || event.Rune() == 'q' {
+		mp.parent.HideModal()
+		return true
+	}
+	return mp.FocusableComponent.OnKeyEvent(event)
+}
+
+// markdownPreview draws a single message without the sender and timestamp.
+type markdownPreview struct {
+	prefs     config.UserPreferences
+	msg       *messages.UIMessage
+	prevWidth int
+}
+
+func (preview *markdownPreview) Draw(screen mauview.Screen) {
+	width, _ := screen.Size()
+	if width != preview.prevWidth {
+		preview.msg.CalculateBuffer(preview.prefs, width)
+		preview.prevWidth = width
+	}
+	preview.msg.Draw(mauview.NewProxyScreen(screen, 0, 0, width, preview.msg.Height()))
+}
+
+func (preview *markdownPreview) OnKeyEvent(_ mauview.KeyEvent) bool {
+	return false
+}
+
+func (preview *markdownPreview) OnPasteEvent(_ mauview.PasteEvent) bool {
+	return false
+}
+
+func (preview *markdownPreview) OnMouseEvent(_ mauview.MouseEvent) bool {
+	return false
+}
+
+func cmdCodeTheme(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		theme := cmd.Config.Preferences.HighlightTheme
+		if len(theme) == 0 {
+			theme = html.DefaultHighlightTheme
+		}
+		cmd.Reply("Code blocks are highlighted with the %s theme. Available themes:\n%s",
+			theme, strings.Join(styles.Names(), ", "))
+		return
+	}
+	theme := strings.ToLower(cmd.Args[0])
+	if theme == "reset" {
+		theme = ""
+	} else if _, ok := styles.Registry[theme]; !ok {
+		cmd.Reply("Unknown theme %s. Use /%s without arguments for a list of themes.", theme, cmd.OrigCommand)
+		return
+	}
+	cmd.Config.Preferences.HighlightTheme = theme
+	if len(theme) == 0 {
+		theme = html.DefaultHighlightTheme
+	}
+	cmd.Reply("Code blocks in new messages will be highlighted with the %s theme", theme)
+	go cmd.Matrix.SendPreferencesToMatrix()
+}
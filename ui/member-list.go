@@ -17,9 +17,11 @@
 package ui
 
 import (
+	"fmt"
 	"math"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/mattn/go-runewidth"
 
@@ -28,16 +30,24 @@ import (
 	"maunium.net/go/mauview"
 	"maunium.net/go/tcell"
 
+	"maunium.net/go/gomuks/debug"
 	"maunium.net/go/gomuks/matrix/rooms"
 	"maunium.net/go/gomuks/ui/widget"
 )
 
 type MemberList struct {
-	list roomMemberList
+	parent *RoomView
+	list   roomMemberList
+
+	focused      bool
+	filter       string
+	filtered     roomMemberList
+	selected     int
+	scrollOffset int
 }
 
-func NewMemberList() *MemberList {
-	return &MemberList{}
+func NewMemberList(parent *RoomView) *MemberList {
+	return &MemberList{parent: parent}
 }
 
 type memberListItem struct {
@@ -46,6 +56,7 @@ type memberListItem struct {
 	Sigil      rune
 	UserID     id.UserID
 	Color      tcell.Color
+	LastActive time.Time
 }
 
 type roomMemberList []*memberListItem
@@ -57,6 +68,8 @@ func (rml roomMemberList) Len() int {
 func (rml roomMemberList) Less(i, j int) bool {
 	if rml[i].PowerLevel != rml[j].PowerLevel {
 		return rml[i].PowerLevel > rml[j].PowerLevel
+	} else if !rml[i].LastActive.Equal(rml[j].LastActive) {
+		return rml[i].LastActive.After(rml[j].LastActive)
 	}
 	return strings.Compare(strings.ToLower(rml[i].Displayname), strings.ToLower(rml[j].Displayname)) < 0
 }
@@ -78,6 +91,7 @@ func (ml *MemberList) Update(data map[id.UserID]*rooms.Member, levels *event.Pow
 			count++
 		}
 	}
+	activity := ml.parent.content.senderActivity()
 	for userID, member := range data {
 		level := levels.GetUserLevel(userID)
 		sigil := ' '
@@ -92,36 +106,238 @@ func (ml *MemberList) Update(data map[id.UserID]*rooms.Member, levels *event.Pow
 		} else if level > levels.UsersDefault {
 			sigil = '+'
 		}
+		stripped := *member
+		stripped.Displayname = ml.parent.config.StripBridgeName(member.Displayname)
+		lastActive := activity[userID]
+		if _, presenceActive := ml.parent.parent.matrix.GetPresence(userID); presenceActive.After(lastActive) {
+			lastActive = presenceActive
+		}
 		ml.list[i] = &memberListItem{
-			Member:     *member,
+			Member:     stripped,
 			UserID:     userID,
 			PowerLevel: level,
 			Sigil:      sigil,
 			Color:      widget.GetHashColor(userID),
+			LastActive: lastActive,
 		}
 		i++
 	}
-	sort.Sort(ml.list)
+	ml.sort()
 	return ml
 }
 
+// senderActivity returns the time of the latest loaded message from each sender.
+func (view *MessageView) senderActivity() map[id.UserID]time.Time {
+	activity := make(map[id.UserID]time.Time)
+	view.messagesLock.RLock()
+	for _, msg := range view.messages {
+		if len(msg.SenderID) > 0 && msg.Timestamp.After(activity[msg.SenderID]) {
+			activity[msg.SenderID] = msg.Timestamp
+		}
+	}
+	view.messagesLock.RUnlock()
+	return activity
+}
+
+// UpdateActivity moves the given member up in the list if they were active more recently than previously known.
+func (ml *MemberList) UpdateActivity(userID id.UserID, ts time.Time) {
+	for _, member := range ml.list {
+		if member.UserID == userID {
+			if ts.After(member.LastActive) {
+				member.LastActive = ts
+				ml.sort()
+			}
+			return
+		}
+	}
+}
+
+// sort sorts the list by power level, then by activity and keeps the same member selected.
+func (ml *MemberList) sort() {
+	selected := ml.Selected()
+	sort.Sort(ml.list)
+	ml.applyFilter()
+	if selected == nil {
+		return
+	}
+	for i, member := range ml.filtered {
+		if member == selected {
+			ml.selected = i
+			break
+		}
+	}
+}
+
+func (ml *MemberList) Focus() {
+	ml.focused = true
+}
+
+func (ml *MemberList) Blur() {
+	ml.focused = false
+	ml.SetFilter("")
+}
+
+func (ml *MemberList) IsFocused() bool {
+	return ml.focused
+}
+
+// SetFilter changes the filter string and resets the selection to the first match.
+func (ml *MemberList) SetFilter(filter string) {
+	ml.filter = filter
+	ml.selected = 0
+	ml.scrollOffset = 0
+	ml.applyFilter()
+}
+
+func (ml *MemberList) applyFilter() {
+	if len(ml.filter) == 0 {
+		ml.filtered = ml.list
+	} else {
+		filter := strings.ToLower(ml.filter)
+		ml.filtered = make(roomMemberList, 0)
+		for _, member := range ml.list {
+			if strings.Contains(strings.ToLower(member.Displayname), filter) ||
+				strings.Contains(strings.ToLower(string(member.UserID)), filter) {
+				ml.filtered = append(ml.filtered, member)
+			}
+		}
+	}
+	if ml.selected >= len(ml.filtered) {
+		ml.selected = len(ml.filtered) - 1
+	}
+	if ml.selected < 0 {
+		ml.selected = 0
+	}
+}
+
+func (ml *MemberList) Selected() *memberListItem {
+	if ml.selected < 0 || ml.selected >= len(ml.filtered) {
+		return nil
+	}
+	return ml.filtered[ml.selected]
+}
+
+func (ml *MemberList) OnKeyEvent(event mauview.KeyEvent) bool {
+	switch event.Key() {
+	case tcell.KeyEscape:
+		if len(ml.filter) > 0 {
+			ml.SetFilter("")
+		} else {
+			ml.parent.BlurMemberList()
+		}
+	case tcell.KeyUp:
+		if ml.selected > 0 {
+			ml.selected--
+		}
+	case tcell.KeyDown:
+		if ml.selected < len(ml.filtered)-1 {
+			ml.selected++
+		}
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if len(ml.filter) > 0 {
+			runes := []rune(ml.filter)
+			ml.SetFilter(string(runes[:len(runes)-1]))
+		}
+	case tcell.KeyEnter:
+		if member := ml.Selected(); member != nil {
+			ml.parent.parent.ShowModal(NewProfileModal(ml.parent.parent, ml.parent.Room, member.UserID))
+		}
+	case tcell.KeyTab:
+		if member := ml.Selected(); member != nil {
+			ml.parent.BlurMemberList()
+			ml.parent.InsertMention(member.UserID, member.Displayname)
+		}
+	case tcell.KeyCtrlD:
+		if member := ml.Selected(); member != nil {
+			ml.parent.BlurMemberList()
+			go ml.parent.parent.OpenDirectChat(member.UserID)
+		}
+	case tcell.KeyCtrlK:
+		if member := ml.Selected(); member != nil {
+			go ml.confirmCommand("Kick user", "kick", member)
+		}
+	case tcell.KeyCtrlB:
+		if member := ml.Selected(); member != nil {
+			go ml.confirmCommand("Ban user", "ban", member)
+		}
+	case tcell.KeyRune:
+		if event.Modifiers()&(tcell.ModCtrl|tcell.ModAlt) != 0 {
+			return false
+		}
+		ml.SetFilter(ml.filter + string(event.Rune()))
+	default:
+		return false
+	}
+	return true
+}
+
+// confirmCommand asks the user to confirm running a moderation command on the given member and runs it.
+func (ml *MemberList) confirmCommand(title, command string, member *memberListItem) {
+	defer debug.Recover()
+	text := fmt.Sprintf("Are you sure you want to %s %s (%s) from %s?",
+		command, member.Displayname, member.UserID, ml.parent.Room.GetTitle())
+	if !ml.parent.parent.AskConfirmation(title, text, 0) {
+		return
+	}
+	cmd := ml.parent.parent.cmdProcessor.ParseCommand(ml.parent, fmt.Sprintf("/%s %s", command, member.UserID))
+	if cmd != nil {
+		ml.parent.parent.cmdProcessor.HandleCommand(cmd)
+	}
+}
+
+func presenceIndicator(presence event.Presence) (rune, tcell.Color) {
+	switch presence {
+	case event.PresenceOnline:
+		return '●', tcell.ColorGreen
+	case event.PresenceUnavailable:
+		return '●', tcell.ColorYellow
+	case event.PresenceOffline:
+		return '○', tcell.ColorGray
+	default:
+		return ' ', tcell.ColorDefault
+	}
+}
+
 func (ml *MemberList) Draw(screen mauview.Screen) {
-	width, _ := screen.Size()
+	width, height := screen.Size()
 	sigilStyle := tcell.StyleDefault.Background(tcell.ColorGreen).Foreground(tcell.ColorWhite)
-	for y, member := range ml.list {
+	list := ml.list
+	offset := 0
+	if ml.focused {
+		widget.WriteLine(screen, mauview.AlignLeft, "/"+ml.filter, 0, 0, width, tcell.StyleDefault.Bold(true))
+		screen = &mauview.ProxyScreen{Parent: screen, OffsetY: 1, Width: width, Height: height - 1}
+		height--
+		list = ml.filtered
+		if ml.selected < ml.scrollOffset {
+			ml.scrollOffset = ml.selected
+		} else if ml.selected >= ml.scrollOffset+height {
+			ml.scrollOffset = ml.selected - height + 1
+		}
+		offset = ml.scrollOffset
+	}
+	nameWidth := width - 2
+	for y := 0; y+offset < len(list) && y < height; y++ {
+		member := list[y+offset]
 		if member.Sigil != ' ' {
 			screen.SetCell(0, y, sigilStyle, member.Sigil)
 		}
 		if member.Membership == "invite" {
-			widget.WriteLineSimpleColor(screen, member.Displayname, 2, y, member.Color)
+			widget.WriteLineColor(screen, mauview.AlignLeft, member.Displayname, 2, y, nameWidth-2, member.Color)
 			screen.SetCell(1, y, tcell.StyleDefault, '(')
-			if sw := runewidth.StringWidth(member.Displayname); sw+2 < width {
+			if sw := runewidth.StringWidth(member.Displayname); sw+2 < nameWidth {
 				screen.SetCell(sw+2, y, tcell.StyleDefault, ')')
 			} else {
-				screen.SetCell(width-1, y, tcell.StyleDefault, ')')
+				screen.SetCell(nameWidth, y, tcell.StyleDefault, ')')
 			}
+		} else if ml.focused && y+offset == ml.selected {
+			widget.WriteLine(screen, mauview.AlignLeft, member.Displayname, 1, y, nameWidth,
+				tcell.StyleDefault.Foreground(member.Color).Reverse(true))
 		} else {
-			widget.WriteLineSimpleColor(screen, member.Displayname, 1, y, member.Color)
+			widget.WriteLineColor(screen, mauview.AlignLeft, member.Displayname, 1, y, nameWidth, member.Color)
+		}
+		presence, _ := ml.parent.parent.matrix.GetPresence(member.UserID)
+		if char, color := presenceIndicator(presence); char != ' ' {
+			screen.SetCell(width-1, y, tcell.StyleDefault.Foreground(color), char)
 		}
 	}
 }
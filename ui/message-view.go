@@ -19,8 +19,10 @@ package ui
 import (
 	"fmt"
 	"math"
+	"sort"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/mattn/go-runewidth"
 	sync "github.com/sasha-s/go-deadlock"
@@ -34,7 +36,7 @@ import (
 	"maunium.net/go/gomuks/config"
 	"maunium.net/go/gomuks/debug"
 	"maunium.net/go/gomuks/interface"
-	"maunium.net/go/gomuks/lib/open"
+	"maunium.net/go/gomuks/matrix/muksevt"
 	"maunium.net/go/gomuks/ui/messages"
 	"maunium.net/go/gomuks/ui/widget"
 )
@@ -49,9 +51,9 @@ type MessageView struct {
 	TimestampFormat string
 	TimestampWidth  int
 
-	// Used for locking
-	loadingMessages int32
-	historyLoadPtr  uint64
+	// Holds a value while history is being loaded, so that only one load runs at a time.
+	historyLoad    chan struct{}
+	historyLoadPtr uint64
 
 	_widestSender     uint32
 	_prevWidestSender uint32
@@ -61,8 +63,12 @@ type MessageView struct {
 	_prevWidth  uint32
 	_prevHeight uint32
 
-	prevMsgCount int
-	prevPrefs    config.UserPreferences
+	// Set to 1 when the last draw included an animated image.
+	_animated uint32
+
+	prevMsgCount     int
+	prevPrefs        config.UserPreferences
+	prevSenderColors uint32
 
 	messageIDLock sync.RWMutex
 	messageIDs    map[id.EventID]*messages.UIMessage
@@ -71,6 +77,24 @@ type MessageView struct {
 	msgBufferLock sync.RWMutex
 	msgBuffer     []*messages.UIMessage
 	selected      *messages.UIMessage
+	// Messages highlighted by the visual selection in yank mode.
+	selectionRange []*messages.UIMessage
+	// Links visible in the last drawn frame, and whether they should be numbered for opening them by number.
+	links     []widget.Hyperlink
+	LinkHints bool
+
+	// Thread replies that are collapsed under their root instead of being shown in the timeline.
+	threads     map[id.EventID][]*messages.UIMessage
+	threadsLock sync.RWMutex
+	// If set, this view shows a single thread instead of the main timeline.
+	threadRoot id.EventID
+	// If set, this view shows the messages around a permalink target instead of the main timeline.
+	// Thread replies are shown inline in such views.
+	contextOf id.EventID
+	// A message to scroll to on the next draw, once the buffers have been calculated.
+	scrollTo *messages.UIMessage
+	// The message below which the unread line separator is drawn.
+	readMarker id.EventID
 
 	initialHistoryLoaded bool
 }
@@ -87,6 +111,9 @@ func NewMessageView(parent *RoomView) *MessageView {
 		messages:   make([]*messages.UIMessage, 0),
 		messageIDs: make(map[id.EventID]*messages.UIMessage),
 		msgBuffer:  make([]*messages.UIMessage, 0),
+		threads:    make(map[id.EventID][]*messages.UIMessage),
+
+		historyLoad: make(chan struct{}, 1),
 
 		_widestSender:     5,
 		_prevWidestSender: 0,
@@ -106,6 +133,9 @@ func (view *MessageView) Unload() {
 	view.messageIDs = make(map[id.EventID]*messages.UIMessage)
 	view.msgBuffer = make([]*messages.UIMessage, 0)
 	view.messages = make([]*messages.UIMessage, 0)
+	view.threadsLock.Lock()
+	view.threads = make(map[id.EventID][]*messages.UIMessage)
+	view.threadsLock.Unlock()
 	view.initialHistoryLoaded = false
 	view.ScrollOffset = 0
 	view._widestSender = 5
@@ -126,6 +156,10 @@ func (view *MessageView) updateWidestSender(sender string) {
 	}
 }
 
+// BackfillThreshold is how much older than the live message sent before it a message has to be
+// to be considered history that was backfilled into the room (e.g. by a bridge).
+const BackfillThreshold = 10 * time.Minute
+
 type MessageDirection int
 
 const (
@@ -145,6 +179,20 @@ func (view *MessageView) AddMessage(ifcMessage ifc.Message, direction MessageDir
 		return
 	}
 
+	// Thread views and permalink context views show thread replies inline.
+	isMainTimeline := len(view.threadRoot) == 0 && len(view.contextOf) == 0
+	if rootID := message.ThreadRoot(); len(rootID) > 0 && isMainTimeline {
+		view.addThreadReply(rootID, message, direction)
+		// Replies are also shown inline until their root has been loaded, so that they don't stay
+		// hidden when the root is far back in history.
+		if view.getMessageByID(rootID) != nil {
+			return
+		}
+	} else if isMainTimeline {
+		view.collapseThread(message)
+		view.updateThreadSummary(message)
+	}
+
 	var oldMsg *messages.UIMessage
 	if oldMsg = view.getMessageByID(message.EventID); oldMsg != nil {
 		view.replaceMessage(oldMsg, message)
@@ -154,6 +202,7 @@ func (view *MessageView) AddMessage(ifcMessage ifc.Message, direction MessageDir
 		view.deleteMessageID(id.EventID(message.TxnID))
 		direction = IgnoreMessage
 	}
+	view.updateReadMarker(message, oldMsg, direction)
 
 	view.updateWidestSender(message.Sender())
 
@@ -162,17 +211,20 @@ func (view *MessageView) AddMessage(ifcMessage ifc.Message, direction MessageDir
 	if !bare {
 		width -= view.TimestampWidth + TimestampSenderGap + view.widestSender() + SenderMessageGap
 	}
-	message.CalculateBuffer(view.config.Preferences, width)
+	message.CalculateBuffer(view.preferences(), width)
 
 	makeDateChange := func() *messages.UIMessage {
 		dateChange := messages.NewDateChangeMessage(
 			fmt.Sprintf("Date changed to %s", message.FormatDate()))
-		dateChange.CalculateBuffer(view.config.Preferences, width)
+		dateChange.CalculateBuffer(view.preferences(), width)
 		view.appendBuffer(dateChange)
 		return dateChange
 	}
 
-	if direction == AppendMessage {
+	if direction == AppendMessage && view.isBackfilled(message) {
+		message.Backfilled = true
+		view.insertSorted(message)
+	} else if direction == AppendMessage {
 		if view.ScrollOffset > 0 {
 			view.ScrollOffset += message.Height()
 		}
@@ -192,6 +244,8 @@ func (view *MessageView) AddMessage(ifcMessage ifc.Message, direction MessageDir
 			view.messages = append([]*messages.UIMessage{message}, view.messages...)
 		}
 		view.messagesLock.Unlock()
+		message.Backfilled = isHistorical(message)
+		view.sortBackfilled(message)
 	} else if oldMsg != nil {
 		view.replaceBuffer(oldMsg, message)
 	} else {
@@ -204,6 +258,199 @@ func (view *MessageView) AddMessage(ifcMessage ifc.Message, direction MessageDir
 	}
 }
 
+// addThreadReply stores a thread reply under its root, updates the reply count of the root
+// and passes the reply on to the thread view if the thread is open.
+func (view *MessageView) addThreadReply(rootID id.EventID, message *messages.UIMessage, direction MessageDirection) {
+	view.threadsLock.Lock()
+	replies := view.threads[rootID]
+	replaced := false
+	for i, reply := range replies {
+		if reply.ID() == message.ID() || (len(message.TxnID) > 0 && reply.TxnID == message.TxnID) {
+			replies[i] = message
+			replaced = true
+			break
+		}
+	}
+	if !replaced && direction == PrependMessage {
+		replies = append([]*messages.UIMessage{message}, replies...)
+	} else if !replaced {
+		replies = append(replies, message)
+	}
+	view.threads[rootID] = replies
+	view.threadsLock.Unlock()
+
+	if threadView := view.parent.threadView; view == view.parent.content && threadView != nil && threadView.threadRoot == rootID {
+		threadView.AddMessage(message, direction)
+		if view.parent.parent.currentRoom == view.parent {
+			view.parent.Room.MarkThreadRead(rootID, message.Timestamp)
+		}
+	}
+	if root := view.getMessageByID(rootID); root != nil {
+		view.refreshThreadSummary(root)
+	}
+}
+
+// mergeThreadReplies adds the replies fetched from the server that haven't been loaded yet to the given thread.
+func (view *MessageView) mergeThreadReplies(rootID id.EventID, fetched []*messages.UIMessage) {
+	view.threadsLock.Lock()
+	replies := view.threads[rootID]
+	loaded := make(map[id.EventID]struct{}, len(replies))
+	for _, reply := range replies {
+		loaded[reply.ID()] = struct{}{}
+	}
+	for _, reply := range fetched {
+		if _, ok := loaded[reply.ID()]; !ok {
+			replies = append(replies, reply)
+		}
+	}
+	sort.SliceStable(replies, func(i, j int) bool {
+		return replies[i].Timestamp.Before(replies[j].Timestamp)
+	})
+	view.threads[rootID] = replies
+	view.threadsLock.Unlock()
+
+	if root := view.getMessageByID(rootID); root != nil {
+		view.refreshThreadSummary(root)
+	}
+}
+
+// collapseThread removes the replies to the given thread root that were shown inline
+// because the root hadn't been loaded when they were added.
+func (view *MessageView) collapseThread(root *messages.UIMessage) {
+	if len(root.EventID) == 0 {
+		return
+	}
+	for _, reply := range view.threadReplies(root.EventID) {
+		if view.getMessageByID(reply.ID()) == reply {
+			view.removeMessage(reply)
+		}
+	}
+}
+
+// refreshThreadSummary updates the thread summary of a message that's already in the view
+// and recalculates its buffer if the summary appeared or disappeared.
+func (view *MessageView) refreshThreadSummary(root *messages.UIMessage) {
+	prevHeight := root.Height()
+	view.updateThreadSummary(root)
+	if root.Height() != prevHeight {
+		root.CalculateBuffer(view.prevPrefs, view.prevWidth())
+		view.replaceBuffer(root, root)
+	}
+}
+
+// updateThreadSummary updates the reply and unread counts of a message that may be a thread root.
+// If fewer replies have been loaded than the server reported in the bundled aggregations of the root,
+// the count from the server is shown instead.
+func (view *MessageView) updateThreadSummary(root *messages.UIMessage) {
+	view.threadsLock.RLock()
+	replies := view.threads[root.EventID]
+	view.threadsLock.RUnlock()
+	bundled := root.BundledThreadReplies()
+	if len(replies) == 0 {
+		root.ThreadReplies = bundled
+		root.ThreadUnread = 0
+		return
+	}
+	readUpTo := view.parent.Room.ThreadReadTime(root.EventID)
+	unread := 0
+	for _, reply := range replies {
+		if reply.SenderID != view.config.UserID && reply.Timestamp.After(readUpTo) {
+			unread++
+		}
+	}
+	root.ThreadReplies = len(replies)
+	if bundled > root.ThreadReplies {
+		root.ThreadReplies = bundled
+	}
+	root.ThreadUnread = unread
+}
+
+// threadReplies returns a copy of the replies in the given thread that have been loaded.
+func (view *MessageView) threadReplies(rootID id.EventID) []*messages.UIMessage {
+	view.threadsLock.RLock()
+	defer view.threadsLock.RUnlock()
+	replies := make([]*messages.UIMessage, len(view.threads[rootID]))
+	copy(replies, view.threads[rootID])
+	return replies
+}
+
+// isHistorical checks if the sender marked a message as imported history (MSC2716), which bridges do when backfilling.
+func isHistorical(message *messages.UIMessage) bool {
+	if message.Event == nil {
+		return false
+	}
+	historical, _ := message.Event.Content.Raw["org.matrix.msc2716.historical"].(bool)
+	return historical
+}
+
+// isBackfilled checks if a message that arrived through sync was backfilled into the room, either because
+// it's marked as historical or because it's far older than the newest live message before it.
+func (view *MessageView) isBackfilled(message *messages.UIMessage) bool {
+	if message.IsService || message.State != muksevt.StateDefault {
+		return false
+	} else if isHistorical(message) {
+		return true
+	}
+	view.messagesLock.RLock()
+	defer view.messagesLock.RUnlock()
+	for i := len(view.messages) - 1; i >= 0; i-- {
+		if msg := view.messages[i]; !msg.IsService && !msg.Backfilled {
+			return msg.Timestamp.Sub(message.Timestamp) > BackfillThreshold
+		}
+	}
+	return false
+}
+
+// sortBackfilled is called after a message has been loaded by paginating backwards. The messages after it
+// that are far older than it were backfilled into the room after it was sent, so they're marked as backfilled
+// and moved to their place by origin_server_ts. Older backfilled messages after it are moved too, so that
+// backfilled history stays sorted.
+func (view *MessageView) sortBackfilled(prepended *messages.UIMessage) {
+	if prepended.IsService || prepended.Backfilled || prepended.State != muksevt.StateDefault {
+		return
+	}
+	view.messagesLock.Lock()
+	kept := make([]*messages.UIMessage, 0, len(view.messages))
+	var moved []*messages.UIMessage
+	found, scanning := false, true
+	for _, msg := range view.messages {
+		if msg == prepended {
+			found = true
+		} else if found && scanning && !msg.IsService {
+			if msg.Timestamp.Before(prepended.Timestamp) &&
+				(msg.Backfilled || prepended.Timestamp.Sub(msg.Timestamp) > BackfillThreshold) {
+				msg.Backfilled = true
+				moved = append(moved, msg)
+				continue
+			}
+			scanning = false
+		}
+		kept = append(kept, msg)
+	}
+	view.messages = kept
+	view.messagesLock.Unlock()
+	for _, msg := range moved {
+		view.insertSorted(msg)
+	}
+}
+
+// insertSorted inserts a message into the timeline based on its origin_server_ts.
+// The message buffer is rebuilt on the next draw.
+func (view *MessageView) insertSorted(message *messages.UIMessage) {
+	view.messagesLock.Lock()
+	index := 0
+	for i := len(view.messages) - 1; i >= 0; i-- {
+		if !view.messages[i].IsService && !view.messages[i].Timestamp.After(message.Timestamp) {
+			index = i + 1
+			break
+		}
+	}
+	view.messages = append(view.messages, nil)
+	copy(view.messages[index+1:], view.messages[index:])
+	view.messages[index] = message
+	view.messagesLock.Unlock()
+}
+
 func (view *MessageView) replaceMessage(original *messages.UIMessage, new *messages.UIMessage) {
 	if len(new.ID()) > 0 {
 		view.setMessageID(new)
@@ -217,6 +464,28 @@ func (view *MessageView) replaceMessage(original *messages.UIMessage, new *messa
 	view.messagesLock.Unlock()
 }
 
+// removeMessage removes a message from the view, e.g. the local echo of a message whose sending was cancelled.
+func (view *MessageView) removeMessage(message *messages.UIMessage) {
+	view.deleteMessageID(message.ID())
+	view.messagesLock.Lock()
+	for index, msg := range view.messages {
+		if msg == message {
+			view.messages = append(view.messages[:index], view.messages[index+1:]...)
+			break
+		}
+	}
+	view.messagesLock.Unlock()
+	if view.selected == message {
+		view.selected = nil
+	}
+}
+
+func (view *MessageView) messageCount() int {
+	view.messagesLock.RLock()
+	defer view.messagesLock.RUnlock()
+	return len(view.messages)
+}
+
 func (view *MessageView) getMessageByID(id id.EventID) *messages.UIMessage {
 	if id == "" {
 		return nil
@@ -312,12 +581,24 @@ func (view *MessageView) replaceBuffer(original *messages.UIMessage, new *messag
 	view.msgBufferLock.Unlock()
 }
 
-func (view *MessageView) recalculateBuffers() {
+// preferences returns the user preferences with the per-room display settings of this room applied.
+func (view *MessageView) preferences() config.UserPreferences {
 	prefs := view.config.Preferences
+	if len(view.parent.Room.DisplayMode) > 0 {
+		prefs.DisplayMode = view.parent.Room.DisplayMode
+	}
+	return prefs
+}
+
+func (view *MessageView) recalculateBuffers() {
+	prefs := view.preferences()
 	recalculateMessageBuffers := view.width() != view.prevWidth() ||
 		view.widestSender() != view.prevWidestSender() ||
 		view.prevPrefs.BareMessageView != prefs.BareMessageView ||
-		view.prevPrefs.DisableImages != prefs.DisableImages
+		view.prevPrefs.DisableImages != prefs.DisableImages ||
+		view.prevPrefs.ColorMessageBodies != prefs.ColorMessageBodies ||
+		view.prevPrefs.DisplayMode != prefs.DisplayMode ||
+		view.prevSenderColors != widget.SenderColorsVersion()
 	view.messagesLock.RLock()
 	view.msgBufferLock.Lock()
 	if recalculateMessageBuffers || len(view.messages) != view.prevMsgCount {
@@ -342,6 +623,7 @@ func (view *MessageView) recalculateBuffers() {
 	view.messagesLock.RUnlock()
 	view.updatePrevSize()
 	view.prevPrefs = prefs
+	view.prevSenderColors = widget.SenderColorsVersion()
 }
 
 func (view *MessageView) SetSelected(message *messages.UIMessage) {
@@ -358,12 +640,44 @@ func (view *MessageView) SetSelected(message *messages.UIMessage) {
 	}
 }
 
+// ToggleDetails expands or collapses the <details> elements in the given message and re-renders it.
+func (view *MessageView) ToggleDetails(message *messages.UIMessage) bool {
+	msg, ok := message.Renderer.(*messages.HTMLMessage)
+	if !ok || !msg.ToggleDetails() {
+		return false
+	}
+	message.CalculateBuffer(view.prevPrefs, view.prevMessageWidth())
+	view.replaceBuffer(message, message)
+	return true
+}
+
+// updateMessage changes a message in the background and recalculates its buffer. The change is made while
+// holding the buffer lock, so that it doesn't race with drawing the message.
+func (view *MessageView) updateMessage(msg *messages.UIMessage, update func()) {
+	view.msgBufferLock.Lock()
+	update()
+	msg.CalculateBuffer(view.prevPrefs, view.prevMessageWidth())
+	view.msgBufferLock.Unlock()
+	view.replaceBuffer(msg, msg)
+}
+
+// ToggleSpoilers reveals or hides the spoilers in the given message.
+// Spoilers take the same space either way, so the message doesn't need to be re-rendered.
+func (view *MessageView) ToggleSpoilers(message *messages.UIMessage) bool {
+	msg, ok := message.Renderer.(*messages.HTMLMessage)
+	return ok && msg.ToggleSpoilers()
+}
+
 func (view *MessageView) handleMessageClick(message *messages.UIMessage, mod tcell.ModMask) bool {
-	if msg, ok := message.Renderer.(*messages.FileMessage); ok && mod > 0 && !msg.Thumbnail.IsEmpty() {
-		debug.Print("Opening thumbnail", msg.ThumbnailPath())
-		open.Open(msg.ThumbnailPath())
+	if msg, ok := message.Renderer.(*messages.FileMessage); ok && mod > 0 && !msg.URL.IsEmpty() {
+		go view.parent.OpenMedia(msg, "")
 		// No need to re-render
 		return false
+	} else if mod > 0 {
+		toggledSpoilers := view.ToggleSpoilers(message)
+		if view.ToggleDetails(message) || toggledSpoilers {
+			return true
+		}
 	}
 	view.SetSelected(message)
 	view.parent.OnSelect(view.selected)
@@ -485,6 +799,15 @@ func (view *MessageView) prevWidth() int {
 	return int(atomic.LoadUint32(&view._prevWidth))
 }
 
+// prevMessageWidth returns the width that was available for the message content in the previous render.
+func (view *MessageView) prevMessageWidth() int {
+	width := view.prevWidth()
+	if !view.prevPrefs.BareMessageView {
+		width -= view.TimestampWidth + TimestampSenderGap + view.prevWidestSender() + SenderMessageGap
+	}
+	return width
+}
+
 func (view *MessageView) prevWidestSender() int {
 	return int(atomic.LoadUint32(&view._prevWidestSender))
 }
@@ -556,7 +879,7 @@ func (view *MessageView) getIndexOffset(screen mauview.Screen, height, messageX
 	indexOffset = view.TotalHeight() - view.ScrollOffset - height
 	if indexOffset <= -PaddingAtTop {
 		message := "Scroll up to load more messages."
-		if atomic.LoadInt32(&view.loadingMessages) == 1 {
+		if len(view.historyLoad) > 0 {
 			message = "Loading more messages..."
 		}
 		widget.WriteLineSimpleColor(screen, message, messageX, 0, tcell.ColorGreen)
@@ -594,9 +917,14 @@ func (view *MessageView) CapturePlaintext(height int) string {
 func (view *MessageView) Draw(screen mauview.Screen) {
 	view.setSize(screen.Size())
 	view.recalculateBuffers()
+	if view.scrollTo != nil {
+		view.ScrollToMessage(view.scrollTo)
+		view.scrollTo = nil
+	}
 
 	height := view.Height()
 	if view.TotalHeight() == 0 {
+		view.links = nil
 		widget.WriteLineSimple(screen, "It's quite empty in here.", 0, height)
 		return
 	}
@@ -632,6 +960,8 @@ func (view *MessageView) Draw(screen mauview.Screen) {
 	}
 
 	var prevMsg *messages.UIMessage
+	animated := uint32(0)
+	firstLink := widget.HyperlinkCount()
 	view.msgBufferLock.RLock()
 	for line := viewStart; line < height && indexOffset+line < len(view.msgBuffer); {
 		index := indexOffset + line
@@ -644,15 +974,23 @@ func (view *MessageView) Draw(screen mauview.Screen) {
 		}
 
 		if len(msg.FormatTime()) > 0 {
-			widget.WriteLineSimpleColor(screen, msg.FormatTime(), 0, line, msg.TimestampColor())
-		}
-		// TODO hiding senders might not be that nice after all, maybe an option? (disabled for now)
-		//if !bareMode && (prevMsg == nil || meta.Sender() != prevMsg.Sender()) {
-		widget.WriteLineColor(
-			screen, mauview.AlignRight, msg.Sender(),
-			usernameX, line, view.widestSender(),
-			msg.SenderColor())
-		//}
+			timestampColor := msg.TimestampColor()
+			if msg.Trust != messages.TrustNone && view.config.TrustShields == config.TrustShieldsColor {
+				timestampColor = msg.Trust.Color()
+			}
+			widget.WriteLineSimpleColor(screen, msg.FormatTime(), 0, line, timestampColor)
+		}
+		if msg.Trust != messages.TrustNone && !bareMode && (view.config.TrustShields == config.TrustShieldsIcon || len(view.config.TrustShields) == 0) {
+			screen.SetCell(usernameX-TimestampSenderGap, line, tcell.StyleDefault.Foreground(msg.Trust.Color()), msg.Trust.Icon())
+		} else if msg.Backfilled && !bareMode {
+			screen.SetCell(usernameX-TimestampSenderGap, line, tcell.StyleDefault.Foreground(tcell.ColorDarkCyan), '↶')
+		}
+		if view.prevPrefs.DisplayMode != config.DisplayModeGrouped || !view.continuesGroup(msg, index) {
+			widget.WriteLineColor(
+				screen, mauview.AlignRight, msg.Sender(),
+				usernameX, line, view.widestSender(),
+				msg.SenderColor())
+		}
 		if msg.Edited {
 			// TODO add better indicator for edits
 			screen.SetCell(usernameX+view.widestSender(), line, tcell.StyleDefault.Foreground(tcell.ColorDarkRed), '*')
@@ -663,8 +1001,57 @@ func (view *MessageView) Draw(screen mauview.Screen) {
 		}
 		msg.Draw(mauview.NewProxyScreen(screen, messageX, line, view.width()-messageX, msg.Height()))
 		line += msg.Height()
+		if anim, ok := msg.Renderer.(interface{ Animated() bool }); ok && anim.Animated() {
+			animated = 1
+		}
 
 		prevMsg = msg
 	}
 	view.msgBufferLock.RUnlock()
+	atomic.StoreUint32(&view._animated, animated)
+	view.links = distinctLinks(widget.Hyperlinks()[firstLink:])
+	if view.LinkHints {
+		view.drawLinkHints(screen)
+	}
+}
+
+// distinctLinks drops the segments of wrapped links, leaving only the first row of each link.
+func distinctLinks(links []widget.Hyperlink) []widget.Hyperlink {
+	distinct := links[:0]
+	for i, link := range links {
+		if i == 0 || links[i-1].URL != link.URL || links[i-1].Y == link.Y {
+			distinct = append(distinct, link)
+		}
+	}
+	return distinct
+}
+
+// drawLinkHints draws the number of each visible link over the start of the link.
+func (view *MessageView) drawLinkHints(screen mauview.Screen) {
+	offsetX, offsetY := widget.ScreenOffset(screen)
+	style := tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorYellow).Bold(true)
+	for i, link := range view.links {
+		label := fmt.Sprintf("[%d]", i+1)
+		widget.WriteLine(screen, mauview.AlignLeft, label, link.X-offsetX, link.Y-offsetY, len(label), style)
+	}
+}
+
+// continuesGroup returns whether the given message was sent by the same sender soon after the message before it,
+// so that the sender name doesn't need to be repeated in the grouped display mode. The buffer must be locked.
+func (view *MessageView) continuesGroup(msg *messages.UIMessage, index int) bool {
+	for index > 0 && view.msgBuffer[index-1] == msg {
+		index--
+	}
+	if index == 0 {
+		return false
+	}
+	prev := view.msgBuffer[index-1]
+	return !prev.IsService && !msg.IsService &&
+		prev.SenderID == msg.SenderID && prev.Sender() == msg.Sender() &&
+		msg.Timestamp.Sub(prev.Timestamp) < view.prevPrefs.GetGroupInterval()
+}
+
+// HasVisibleAnimations returns whether an animated image was visible when the view was last drawn.
+func (view *MessageView) HasVisibleAnimations() bool {
+	return atomic.LoadUint32(&view._animated) == 1
 }
@@ -19,8 +19,11 @@ package messages
 import (
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
+	"github.com/mattn/go-runewidth"
+
 	"maunium.net/go/gomuks/config"
 	"maunium.net/go/gomuks/matrix/muksevt"
 	"maunium.net/go/mautrix/event"
@@ -41,9 +44,17 @@ type MessageRenderer interface {
 	String() string
 }
 
+// ImageSize contains the size limits of inline images in a room. Zero values mean the defaults are used.
+type ImageSize struct {
+	Scale   float64
+	MaxRows int
+}
+
 type ReactionItem struct {
 	Key   string
 	Count int
+	// Mine is true if the user has reacted with this key.
+	Mine bool
 }
 
 func (ri ReactionItem) String() string {
@@ -65,23 +76,40 @@ func (rs ReactionSlice) Swap(i, j int) {
 }
 
 type UIMessage struct {
-	EventID            id.EventID
-	TxnID              string
-	Relation           event.RelatesTo
-	Type               event.MessageType
-	SenderID           id.UserID
-	SenderName         string
-	DefaultSenderColor tcell.Color
-	Timestamp          time.Time
-	State              muksevt.OutgoingState
-	IsHighlight        bool
-	IsService          bool
-	IsSelected         bool
-	Edited             bool
-	Event              *muksevt.Event
-	ReplyTo            *UIMessage
-	Reactions          ReactionSlice
-	Renderer           MessageRenderer
+	EventID     id.EventID
+	TxnID       string
+	Relation    event.RelatesTo
+	Type        event.MessageType
+	SenderID    id.UserID
+	SenderName  string
+	Timestamp   time.Time
+	State       muksevt.OutgoingState
+	IsHighlight bool
+	IsService   bool
+	IsSelected  bool
+	Edited      bool
+	Trust       TrustLevel
+	Backfilled  bool
+	Event       *muksevt.Event
+	ReplyTo     *UIMessage
+	Reactions   ReactionSlice
+	Renderer    MessageRenderer
+	// Preview of the first link in the message, if URL previews are enabled in the room.
+	URLPreview *URLPreviewCard
+	// The number of replies and unread replies in the thread started by this message.
+	ThreadReplies int
+	ThreadUnread  int
+	// Whether this is the last message the user had read when they opened the room.
+	ReadMarker bool
+	// Size limits of inline images in the room, set by the room view when the message is parsed.
+	ImageSize ImageSize
+	// Whether a pending local echo is likely stuck because the other server of the DM seems to be unreachable.
+	DeliveryDelayed bool
+
+	// Whether the body should be shown in the color of the sender, set from the preferences when rendering.
+	colorBody bool
+	// Whether the message is drawn on a single line, set from the display mode preference when rendering.
+	compact bool
 }
 
 func (msg *UIMessage) GetEvent() *muksevt.Event {
@@ -103,37 +131,39 @@ func newUIMessage(evt *muksevt.Event, displayname string, renderer MessageRender
 
 	reactions := make(ReactionSlice, 0, len(evt.Unsigned.Relations.Annotations.Map))
 	for key, count := range evt.Unsigned.Relations.Annotations.Map {
+		_, mine := evt.Gomuks.OwnReactions[key]
 		reactions = append(reactions, ReactionItem{
 			Key:   key,
 			Count: count,
+			Mine:  mine,
 		})
 	}
 	sort.Sort(reactions)
 
 	return &UIMessage{
-		SenderID:           evt.Sender,
-		SenderName:         displayname,
-		Timestamp:          unixToTime(evt.Timestamp),
-		DefaultSenderColor: widget.GetHashColor(evt.Sender),
-		Type:               msgtype,
-		EventID:            evt.ID,
-		TxnID:              evt.Unsigned.TransactionID,
-		Relation:           *msgContent.GetRelatesTo(),
-		State:              evt.Gomuks.OutgoingState,
-		IsHighlight:        false,
-		IsService:          false,
-		Edited:             len(evt.Gomuks.Edits) > 0,
-		Reactions:          reactions,
-		Event:              evt,
-		Renderer:           renderer,
-	}
-}
-
-func (msg *UIMessage) AddReaction(key string) {
+		SenderID:    evt.Sender,
+		SenderName:  displayname,
+		Timestamp:   unixToTime(evt.Timestamp),
+		Type:        msgtype,
+		EventID:     evt.ID,
+		TxnID:       evt.Unsigned.TransactionID,
+		Relation:    *msgContent.GetRelatesTo(),
+		State:       evt.Gomuks.OutgoingState,
+		IsHighlight: false,
+		IsService:   false,
+		Edited:      len(evt.Gomuks.Edits) > 0,
+		Reactions:   reactions,
+		Event:       evt,
+		Renderer:    renderer,
+	}
+}
+
+func (msg *UIMessage) AddReaction(key string, mine bool) {
 	found := false
-	for _, rs := range msg.Reactions {
-		if rs.Key == key {
-			rs.Count++
+	for i := range msg.Reactions {
+		if msg.Reactions[i].Key == key {
+			msg.Reactions[i].Count++
+			msg.Reactions[i].Mine = msg.Reactions[i].Mine || mine
 			found = true
 			break
 		}
@@ -142,6 +172,7 @@ func (msg *UIMessage) AddReaction(key string) {
 		msg.Reactions = append(msg.Reactions, ReactionItem{
 			Key:   key,
 			Count: 1,
+			Mine:  mine,
 		})
 	}
 	sort.Sort(msg.Reactions)
@@ -215,7 +246,7 @@ func (msg *UIMessage) SenderColor() tcell.Color {
 	case msg.IsService:
 		return tcell.ColorGray
 	default:
-		return msg.DefaultSenderColor
+		return widget.GetHashColor(msg.SenderID)
 	}
 }
 
@@ -231,6 +262,8 @@ func (msg *UIMessage) TextColor() tcell.Color {
 		return tcell.ColorYellow
 	case msg.Type == "m.room.member":
 		return tcell.ColorGreen
+	case msg.colorBody:
+		return msg.SenderColor()
 	default:
 		return tcell.ColorDefault
 	}
@@ -242,9 +275,12 @@ func (msg *UIMessage) TextColor() tcell.Color {
 // gray and red respectively.
 //
 // However, other messages are the default color instead of a color stored in the struct.
+// Backfilled messages are dark cyan to set them apart from messages that arrived live.
 func (msg *UIMessage) TimestampColor() tcell.Color {
 	if msg.IsService {
 		return tcell.ColorGray
+	} else if msg.Backfilled {
+		return tcell.ColorDarkCyan
 	}
 	return msg.getStateSpecificColor()
 }
@@ -263,9 +299,54 @@ func (msg *UIMessage) ReactionHeight() int {
 	return 0
 }
 
+func (msg *UIMessage) URLPreviewHeight() int {
+	return msg.URLPreview.Height()
+}
+
+func (msg *UIMessage) ThreadHeight() int {
+	if msg.ThreadReplies > 0 {
+		return 1
+	}
+	return 0
+}
+
+func (msg *UIMessage) ReadMarkerHeight() int {
+	if msg.ReadMarker {
+		return 1
+	}
+	return 0
+}
+
+func (msg *UIMessage) DeliveryHintHeight() int {
+	if msg.DeliveryDelayed {
+		return 1
+	}
+	return 0
+}
+
+// ThreadRoot returns the ID of the thread root if this message was sent in a thread.
+func (msg *UIMessage) ThreadRoot() id.EventID {
+	if msg.Relation.Type == muksevt.RelThread {
+		return msg.Relation.EventID
+	}
+	return ""
+}
+
+// BundledThreadReplies returns the number of replies in the thread started by this message
+// according to the aggregations the server bundled with the event.
+func (msg *UIMessage) BundledThreadReplies() int {
+	if msg.Event == nil {
+		return 0
+	}
+	return msg.Event.Unsigned.Relations.Raw[muksevt.RelThread].Count
+}
+
 // Height returns the number of rows in the computed buffer (see Buffer()).
 func (msg *UIMessage) Height() int {
-	return msg.ReplyHeight() + msg.Renderer.Height() + msg.ReactionHeight()
+	if msg.compact {
+		return 1
+	}
+	return msg.ReplyHeight() + msg.Renderer.Height() + msg.URLPreviewHeight() + msg.ReactionHeight() + msg.ThreadHeight() + msg.ReadMarkerHeight() + msg.DeliveryHintHeight()
 }
 
 func (msg *UIMessage) Time() time.Time {
@@ -312,7 +393,11 @@ func (msg *UIMessage) DrawReactions(screen mauview.Screen) {
 
 	x := 0
 	for _, reaction := range msg.Reactions {
-		_, drawn := mauview.PrintWithStyle(screen, reaction.String(), x, 0, width-x, mauview.AlignLeft, tcell.StyleDefault.Foreground(mauview.Styles.PrimaryTextColor).Background(tcell.ColorDarkGreen))
+		style := tcell.StyleDefault.Foreground(mauview.Styles.PrimaryTextColor).Background(tcell.ColorDarkGreen)
+		if reaction.Mine {
+			style = style.Background(tcell.ColorDarkCyan).Bold(true)
+		}
+		_, drawn := mauview.PrintWithStyle(screen, reaction.String(), x, 0, width-x, mauview.AlignLeft, style)
 		x += drawn + 1
 		if x >= width {
 			break
@@ -320,10 +405,92 @@ func (msg *UIMessage) DrawReactions(screen mauview.Screen) {
 	}
 }
 
+// DrawURLPreview draws the link preview card between the message content and the reactions.
+func (msg *UIMessage) DrawURLPreview(screen mauview.Screen) {
+	cardHeight := msg.URLPreviewHeight()
+	if cardHeight == 0 {
+		return
+	}
+	width, height := screen.Size()
+	msg.URLPreview.Draw(mauview.NewProxyScreen(screen, 0, height-msg.ReactionHeight()-cardHeight, width, cardHeight))
+}
+
+// DrawThreadSummary draws the reply count of the thread on the last row of the screen
+// and returns a screen without that row.
+func (msg *UIMessage) DrawThreadSummary(screen mauview.Screen) mauview.Screen {
+	if msg.ThreadReplies == 0 {
+		return screen
+	}
+	width, height := screen.Size()
+	text := fmt.Sprintf("↳ %d replies in thread", msg.ThreadReplies)
+	if msg.ThreadReplies == 1 {
+		text = "↳ 1 reply in thread"
+	}
+	style := tcell.StyleDefault.Foreground(tcell.ColorTeal)
+	if msg.ThreadUnread > 0 {
+		text = fmt.Sprintf("%s (%d unread)", text, msg.ThreadUnread)
+		style = style.Bold(true)
+	}
+	widget.WriteLine(screen, mauview.AlignLeft, text, 0, height-1, width, style)
+	return mauview.NewProxyScreen(screen, 0, 0, width, height-1)
+}
+
+// DrawReadMarker draws the unread line separator on the last row of the screen
+// and returns a screen without that row.
+func (msg *UIMessage) DrawReadMarker(screen mauview.Screen) mauview.Screen {
+	if !msg.ReadMarker {
+		return screen
+	}
+	width, height := screen.Size()
+	style := tcell.StyleDefault.Foreground(tcell.ColorRed)
+	label := " new messages "
+	for x := 0; x < width; x++ {
+		screen.SetContent(x, height-1, '─', nil, style)
+	}
+	widget.WriteLine(screen, mauview.AlignCenter, label, 0, height-1, width, style)
+	return mauview.NewProxyScreen(screen, 0, 0, width, height-1)
+}
+
+// DrawDeliveryHint draws the warning about a delayed delivery on the last row of the screen
+// and returns a screen without that row.
+func (msg *UIMessage) DrawDeliveryHint(screen mauview.Screen) mauview.Screen {
+	if !msg.DeliveryDelayed {
+		return screen
+	}
+	width, height := screen.Size()
+	style := tcell.StyleDefault.Foreground(tcell.ColorYellow).Italic(true)
+	widget.WriteLine(screen, mauview.AlignLeft, "⚠ Delivery may be delayed: remote server unreachable", 0, height-1, width, style)
+	return mauview.NewProxyScreen(screen, 0, 0, width, height-1)
+}
+
+// DrawCompact draws the message on a single line for the compact display mode, cutting off whatever doesn't fit.
+func (msg *UIMessage) DrawCompact(screen mauview.Screen) {
+	width, _ := screen.Size()
+	text := strings.ReplaceAll(strings.TrimSpace(msg.Renderer.NotificationContent()), "\n", " ⏎ ")
+	if msg.Type == event.MsgEmote && !strings.HasPrefix(text, "* ") {
+		text = fmt.Sprintf("* %s %s", msg.SenderName, text)
+	}
+	if msg.ReplyTo != nil {
+		text = "↳ " + text
+	}
+	if runewidth.StringWidth(text) > width {
+		text = runewidth.Truncate(text, width, "…")
+	}
+	widget.WriteLineColor(screen, mauview.AlignLeft, text, 0, 0, width, msg.TextColor())
+}
+
 func (msg *UIMessage) Draw(screen mauview.Screen) {
-	proxyScreen := msg.DrawReply(screen)
-	msg.Renderer.Draw(proxyScreen)
-	msg.DrawReactions(proxyScreen)
+	if msg.compact {
+		msg.DrawCompact(screen)
+	} else {
+		proxyScreen := msg.DrawReply(screen)
+		proxyScreen = msg.DrawReadMarker(proxyScreen)
+		proxyScreen = msg.DrawThreadSummary(proxyScreen)
+		proxyScreen = msg.DrawDeliveryHint(proxyScreen)
+		msg.Renderer.Draw(proxyScreen)
+		msg.DrawURLPreview(proxyScreen)
+		msg.DrawReactions(proxyScreen)
+	}
 	if msg.IsSelected {
 		w, h := screen.Size()
 		for x := 0; x < w; x++ {
@@ -342,6 +509,9 @@ func (msg *UIMessage) Clone() *UIMessage {
 	clone := *msg
 	clone.ReplyTo = nil
 	clone.Reactions = nil
+	clone.URLPreview = nil
+	clone.ReadMarker = false
+	clone.DeliveryDelayed = false
 	clone.Renderer = clone.Renderer.Clone()
 	return &clone
 }
@@ -354,7 +524,10 @@ func (msg *UIMessage) CalculateReplyBuffer(preferences config.UserPreferences, w
 }
 
 func (msg *UIMessage) CalculateBuffer(preferences config.UserPreferences, width int) {
+	msg.colorBody = preferences.ColorMessageBodies
+	msg.compact = preferences.DisplayMode == config.DisplayModeCompact
 	msg.Renderer.CalculateBuffer(preferences, width, msg)
+	msg.URLPreview.CalculateBuffer(width)
 	msg.CalculateReplyBuffer(preferences, width)
 }
 
@@ -384,7 +557,7 @@ func (msg *UIMessage) String() string {
 }`,
 		msg.EventID, msg.TxnID,
 		msg.Type, msg.Timestamp.String(),
-		msg.SenderID, msg.SenderName, msg.DefaultSenderColor.Hex(),
+		msg.SenderID, msg.SenderName, msg.SenderColor().Hex(),
 		msg.IsService, msg.IsHighlight, msg.Renderer.String())
 }
 
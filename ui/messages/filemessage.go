@@ -18,9 +18,17 @@ package messages
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
 
 	"maunium.net/go/mautrix/crypto/attachment"
 	"maunium.net/go/mautrix/event"
@@ -31,14 +39,38 @@ import (
 	"maunium.net/go/gomuks/config"
 	"maunium.net/go/gomuks/debug"
 	"maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/lib/animation"
 	"maunium.net/go/gomuks/lib/ansimage"
+	"maunium.net/go/gomuks/lib/blurhash"
 	"maunium.net/go/gomuks/matrix/muksevt"
 	"maunium.net/go/gomuks/ui/messages/tstring"
+	"maunium.net/go/gomuks/ui/widget"
 )
 
+// Videos without a thumbnail are only downloaded for extracting a preview frame if they're at most this large.
+const maxFrameExtractSize = 25 * 1024 * 1024
+
+// Images with more pixels than this aren't decoded at all, as the declared size of an image can be far larger
+// than the file and decoding it could use up all memory.
+const maxImagePixels = 64 * 1024 * 1024
+
+// VoiceMessageKey is the content key that marks audio messages as voice messages (MSC3245).
+const VoiceMessageKey = "org.matrix.msc3245.voice"
+
 type FileMessage struct {
-	Type event.MessageType
-	Body string
+	Type     event.MessageType
+	Body     string
+	MimeType string
+	// Length of audio and video in milliseconds, if known.
+	Duration int
+	// Size of the file in bytes and dimensions of images and videos, if known.
+	Size        int
+	MediaWidth  int
+	MediaHeight int
+	// Whether the message is a recorded voice message (MSC3245) rather than an arbitrary audio file.
+	Voice bool
+	// Placeholder shown while the image is loading (MSC2448).
+	BlurHash string
 
 	URL           id.ContentURI
 	File          *attachment.EncryptedFile
@@ -47,6 +79,16 @@ type FileMessage struct {
 
 	imageData []byte
 	buffer    []tstring.TString
+	// Whether imageData contains the blurhash placeholder instead of the real image.
+	placeholder bool
+	// Whether the buffer contains the rendered image rather than the text description of the file.
+	imageShown bool
+
+	// Decoded frames of animated GIFs and APNGs, and the rendered buffer of each frame.
+	animation        *animation.Animation
+	animationChecked bool
+	animationStart   time.Time
+	frames           [][]tstring.TString
 
 	matrix ifc.MatrixContainer
 }
@@ -63,9 +105,20 @@ func NewFileMessage(matrix ifc.MatrixContainer, evt *muksevt.Event, displayname
 		thumbnailFile = &content.Info.ThumbnailFile.EncryptedFile
 		content.Info.ThumbnailURL = content.Info.ThumbnailFile.URL
 	}
+	var blurHash string
+	if info, ok := evt.Content.Raw["info"].(map[string]interface{}); ok {
+		blurHash, _ = info[blurhash.InfoKey].(string)
+	}
 	return newUIMessage(evt, displayname, &FileMessage{
 		Type:          content.MsgType,
 		Body:          content.Body,
+		MimeType:      content.GetInfo().MimeType,
+		Duration:      content.GetInfo().Duration,
+		Size:          content.GetInfo().Size,
+		MediaWidth:    content.GetInfo().Width,
+		MediaHeight:   content.GetInfo().Height,
+		Voice:         evt.Content.Raw[VoiceMessageKey] != nil,
+		BlurHash:      blurHash,
 		URL:           content.URL.ParseOrIgnore(),
 		File:          file,
 		Thumbnail:     content.GetInfo().ThumbnailURL.ParseOrIgnore(),
@@ -78,11 +131,19 @@ func (msg *FileMessage) Clone() MessageRenderer {
 	data := make([]byte, len(msg.imageData))
 	copy(data, msg.imageData)
 	return &FileMessage{
-		Body:      msg.Body,
-		URL:       msg.URL,
-		Thumbnail: msg.Thumbnail,
-		imageData: data,
-		matrix:    msg.matrix,
+		Type:        msg.Type,
+		Body:        msg.Body,
+		MimeType:    msg.MimeType,
+		Duration:    msg.Duration,
+		Size:        msg.Size,
+		MediaWidth:  msg.MediaWidth,
+		MediaHeight: msg.MediaHeight,
+		Voice:       msg.Voice,
+		BlurHash:    msg.BlurHash,
+		URL:         msg.URL,
+		Thumbnail:   msg.Thumbnail,
+		imageData:   data,
+		matrix:      msg.matrix,
 	}
 }
 
@@ -91,6 +152,9 @@ func (msg *FileMessage) NotificationContent() string {
 	case event.MsgImage:
 		return "Sent an image"
 	case event.MsgAudio:
+		if msg.Voice {
+			return "Sent a voice message"
+		}
 		return "Sent an audio file"
 	case event.MsgVideo:
 		return "Sent a video"
@@ -109,27 +173,226 @@ func (msg *FileMessage) String() string {
 	return fmt.Sprintf(`&messages.FileMessage{Body="%s", URL="%s", Thumbnail="%s"}`, msg.Body, msg.URL, msg.Thumbnail)
 }
 
-func (msg *FileMessage) DownloadPreview() {
-	var url id.ContentURI
-	var file *attachment.EncryptedFile
+// previewURL returns the media shown as the preview of the file, which is either the thumbnail or the image itself.
+func (msg *FileMessage) previewURL() (id.ContentURI, *attachment.EncryptedFile) {
 	if !msg.Thumbnail.IsEmpty() {
-		url = msg.Thumbnail
-		file = msg.ThumbnailFile
+		return msg.Thumbnail, msg.ThumbnailFile
 	} else if msg.Type == event.MsgImage && !msg.URL.IsEmpty() {
-		msg.Thumbnail = msg.URL
-		url = msg.URL
-		file = msg.File
-	} else {
+		return msg.URL, msg.File
+	}
+	return id.ContentURI{}, nil
+}
+
+// serverThumbnailSize returns the size of the thumbnail to request from the media repository
+// instead of downloading the full image, or zeros if the full image should be used.
+func (msg *FileMessage) serverThumbnailSize() (width, height int) {
+	if msg.Type != event.MsgImage || msg.File != nil || msg.URL.IsEmpty() ||
+		(!msg.Thumbnail.IsEmpty() && msg.Thumbnail != msg.URL) {
+		return 0, 0
+	} else if (msg.MimeType == "image/gif" || msg.MimeType == "image/apng") && !msg.matrix.Preferences().DisableAnimations {
+		// Thumbnails of animated images are usually not animated.
+		return 0, 0
+	}
+	width, height = thumbnailSize()
+	if msg.MediaWidth > 0 && msg.MediaHeight > 0 && msg.MediaWidth <= width && msg.MediaHeight <= height {
+		// The image is already small enough.
+		return 0, 0
+	}
+	return width, height
+}
+
+func (msg *FileMessage) DownloadPreview() {
+	url, file := msg.previewURL()
+	if url.IsEmpty() {
+		if msg.Type == event.MsgVideo && !msg.URL.IsEmpty() {
+			// Preview frames are only extracted on request with /videoframe, but ones extracted earlier are shown.
+			msg.imageData = msg.cachedVideoFrame()
+		}
 		return
 	}
+	thumbWidth, thumbHeight := msg.serverThumbnailSize()
+	msg.Thumbnail = url
+	msg.ThumbnailFile = file
 	debug.Print("Loading file:", url)
-	data, err := msg.matrix.Download(url, file)
+	var data []byte
+	var err error
+	if thumbWidth > 0 {
+		data, err = msg.matrix.DownloadThumbnail(url, thumbWidth, thumbHeight)
+		if err != nil {
+			debug.Printf("Failed to download thumbnail of %s, downloading full image: %v", url, err)
+			data = nil
+		}
+	}
+	if data == nil {
+		data, err = msg.matrix.Download(url, file)
+	}
 	if err != nil {
 		debug.Printf("Failed to download file %s: %v", url, err)
 		return
 	}
+	if thumbWidth == 0 && file == nil && msg.Type == event.MsgImage && animation.CheckSize(data) == animation.ErrTooLarge {
+		// Too large to animate, use a static thumbnail from the server instead.
+		thumbWidth, thumbHeight = thumbnailSize()
+		if thumb, err := msg.matrix.DownloadThumbnail(url, thumbWidth, thumbHeight); err != nil {
+			debug.Printf("Failed to download static thumbnail of %s: %v", url, err)
+		} else {
+			data = thumb
+		}
+	}
 	debug.Print("File", url, "loaded.")
 	msg.imageData = data
+	msg.placeholder = false
+}
+
+// LoadPlaceholder renders the blurhash of the image if there is one and the preview isn't in the cache yet.
+// DownloadPreview must be called afterwards to replace the placeholder with the real image.
+func (msg *FileMessage) LoadPlaceholder() bool {
+	url, _ := msg.previewURL()
+	if len(msg.BlurHash) == 0 || url.IsEmpty() {
+		return false
+	}
+	cachePath := msg.matrix.GetCachePath(url)
+	if thumbWidth, thumbHeight := msg.serverThumbnailSize(); thumbWidth > 0 {
+		cachePath = msg.matrix.GetThumbnailCachePath(url, thumbWidth, thumbHeight)
+	}
+	if _, err := os.Stat(cachePath); err == nil {
+		return false
+	}
+	// The placeholder is scaled to the size of the real image when rendering, so a small image is enough.
+	width, height := 32, 32
+	if msg.MediaWidth > 0 && msg.MediaHeight > 0 {
+		height = width * msg.MediaHeight / msg.MediaWidth
+		if height < 1 {
+			height = 1
+		} else if height > 128 {
+			height = 128
+		}
+	}
+	img, err := blurhash.Decode(msg.BlurHash, width, height, 1)
+	if err != nil {
+		debug.Printf("Failed to decode blurhash of %s: %v", url, err)
+		return false
+	}
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return false
+	}
+	msg.imageData = buf.Bytes()
+	msg.placeholder = true
+	return true
+}
+
+// IsPlaceholder returns whether the blurhash placeholder is shown instead of the real image.
+func (msg *FileMessage) IsPlaceholder() bool {
+	return msg.placeholder
+}
+
+// videoFramePath returns the path where the extracted preview frame of a video is cached, next to the downloaded media.
+func (msg *FileMessage) videoFramePath() string {
+	cachePath := msg.matrix.GetCachePath(msg.URL)
+	if len(cachePath) == 0 {
+		return ""
+	}
+	return cachePath + ".frame.png"
+}
+
+// cachedVideoFrame returns the preview frame of the video if it has been extracted before.
+func (msg *FileMessage) cachedVideoFrame() []byte {
+	framePath := msg.videoFramePath()
+	if len(framePath) == 0 {
+		return nil
+	}
+	data, _ := ioutil.ReadFile(framePath)
+	return data
+}
+
+// ffmpegDemuxers maps the video MIME types that preview frames can be extracted from to ffmpeg input formats.
+var ffmpegDemuxers = map[string]string{
+	"video/mp4":        "mov",
+	"video/quicktime":  "mov",
+	"video/3gpp":       "mov",
+	"video/webm":       "matroska",
+	"video/x-matroska": "matroska",
+	"video/ogg":        "ogg",
+	"video/x-msvideo":  "avi",
+	"video/mpeg":       "mpeg",
+}
+
+// ExtractVideoFrame uses ffmpeg to get a preview frame for a video that doesn't have a thumbnail.
+// The video is downloaded first, so that ffmpeg only ever reads a local file with the demuxer of the
+// declared type and can't be made to fetch other URLs or files. The frame is cached next to the video.
+func (msg *FileMessage) ExtractVideoFrame() error {
+	if msg.Type != event.MsgVideo || msg.URL.IsEmpty() {
+		return errors.New("not a video")
+	} else if data := msg.cachedVideoFrame(); data != nil {
+		msg.imageData = data
+		return nil
+	}
+	framePath := msg.videoFramePath()
+	format, ok := ffmpegDemuxers[msg.MimeType]
+	if len(framePath) == 0 {
+		return errors.New("media cache directory not available")
+	} else if !ok {
+		return fmt.Errorf("unsupported video type %q", msg.MimeType)
+	} else if msg.Size > maxFrameExtractSize {
+		return fmt.Errorf("video is larger than %s", formatFileSize(maxFrameExtractSize))
+	} else if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return errors.New("ffmpeg not found")
+	}
+	if _, err := msg.matrix.DownloadLimited(msg.URL, msg.File, maxFrameExtractSize); err != nil {
+		return fmt.Errorf("failed to download video: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	data, err := exec.CommandContext(ctx, "ffmpeg", "-loglevel", "error",
+		"-protocol_whitelist", "file", "-f", format, "-i", "file:"+msg.matrix.GetCachePath(msg.URL),
+		"-vf", "thumbnail", "-frames:v", "1", "-f", "image2pipe", "-vcodec", "png", "-").Output()
+	if err != nil {
+		return fmt.Errorf("ffmpeg failed: %w", err)
+	} else if len(data) == 0 {
+		return errors.New("ffmpeg didn't output a frame")
+	}
+	if err = ioutil.WriteFile(framePath, data, 0600); err != nil {
+		debug.Printf("Failed to cache preview frame of video %s: %v", msg.URL, err)
+	}
+	msg.imageData = data
+	return nil
+}
+
+func formatFileSize(size int) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := unit, 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// metadata returns the duration, size and resolution of videos to show below the preview.
+func (msg *FileMessage) metadata() string {
+	if msg.Type != event.MsgVideo {
+		return ""
+	}
+	parts := []string{"Video"}
+	if msg.Duration > 0 {
+		seconds := msg.Duration / 1000
+		if seconds >= 3600 {
+			parts = append(parts, fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d:%02d", seconds/60, seconds%60))
+		}
+	}
+	if msg.MediaWidth > 0 && msg.MediaHeight > 0 {
+		parts = append(parts, fmt.Sprintf("%dx%d", msg.MediaWidth, msg.MediaHeight))
+	}
+	if msg.Size > 0 {
+		parts = append(parts, formatFileSize(msg.Size))
+	}
+	return strings.Join(parts, " · ")
 }
 
 func (msg *FileMessage) ThumbnailPath() string {
@@ -140,22 +403,85 @@ func (msg *FileMessage) CalculateBuffer(prefs config.UserPreferences, width int,
 	if width < 2 {
 		return
 	}
+	msg.calculateBuffer(prefs, width, uiMsg)
+	if metadata := msg.metadata(); len(metadata) > 0 {
+		msg.buffer = append(msg.buffer, tstring.NewColorTString(metadata, tcell.ColorGray))
+	}
+}
 
+func (msg *FileMessage) calculateBuffer(prefs config.UserPreferences, width int, uiMsg *UIMessage) {
+	msg.imageShown = false
 	if prefs.BareMessageView || prefs.DisableImages || len(msg.imageData) == 0 {
 		msg.buffer = calculateBufferWithText(prefs, tstring.NewTString(msg.PlainText()), width, uiMsg)
 		return
 	}
+	msg.imageShown = true
 
 	img, _, err := image.DecodeConfig(bytes.NewReader(msg.imageData))
 	if err != nil {
 		debug.Print("File could not be decoded:", err)
+	} else if img.Width*img.Height > maxImagePixels {
+		debug.Printf("Not decoding %dx%d image %s", img.Width, img.Height, msg.URL)
+		msg.imageShown = false
+		msg.buffer = calculateBufferWithText(prefs, tstring.NewTString(msg.PlainText()), width, uiMsg)
+		return
+	}
+	if msg.placeholder && msg.MediaWidth > 0 && msg.MediaHeight > 0 {
+		// Use the size of the real image so that the message doesn't change size when the image loads.
+		img.Width, img.Height = msg.MediaWidth, msg.MediaHeight
+		if thumbWidth, thumbHeight := msg.serverThumbnailSize(); thumbWidth > 0 {
+			// The server scales the image down to fit in the thumbnail size.
+			if img.Width*thumbHeight > img.Height*thumbWidth {
+				img.Width, img.Height = thumbWidth, img.Height*thumbWidth/img.Width
+			} else {
+				img.Width, img.Height = img.Width*thumbHeight/img.Height, thumbHeight
+			}
+		}
 	}
 	imgWidth := img.Width
 	if img.Width > width {
 		imgWidth = width / 3
 	}
+	if uiMsg.ImageSize.Scale > 0 {
+		imgWidth = int(float64(imgWidth) * uiMsg.ImageSize.Scale)
+		if imgWidth > width {
+			imgWidth = width
+		} else if imgWidth < 1 {
+			imgWidth = 1
+		}
+	}
+	imgHeight := 0
+	// Each row of the rendered image contains two pixels.
+	if maxRows := uiMsg.ImageSize.MaxRows; maxRows > 0 && img.Width > 0 && imgWidth*img.Height/img.Width > maxRows*2 {
+		imgWidth = 0
+		imgHeight = maxRows * 2
+	}
+
+	msg.frames = nil
+	if anim := msg.getAnimation(prefs); anim != nil {
+		msg.frames = make([][]tstring.TString, len(anim.Frames))
+		for i, frame := range anim.Frames {
+			ansFrame, err := ansimage.NewScaledFromImage(frame, imgHeight, imgWidth, color.Black)
+			if err != nil {
+				debug.Print("Failed to render animation frame:", err)
+				msg.frames = nil
+				break
+			}
+			msg.frames[i] = ansFrame.Render()
+		}
+		if msg.frames != nil {
+			msg.buffer = msg.frames[0]
+			return
+		}
+	}
+
+	cacheable := !msg.Thumbnail.IsEmpty()
+	if cached, ok := getRenderedImage(msg.Thumbnail, imgWidth, imgHeight); cacheable && ok {
+		msg.buffer = cached
+		return
+	}
 
-	ansFile, err := ansimage.NewScaledFromReader(bytes.NewReader(msg.imageData), 0, imgWidth, color.Black)
+	ansFile, err := ansimage.NewScaledFromReader(bytes.NewReader(msg.imageData), imgHeight, imgWidth, color.Black)
 	if err != nil {
 		msg.buffer = []tstring.TString{tstring.NewColorTString("Failed to display image", tcell.ColorRed)}
 		debug.Print("Failed to display image:", err)
@@ -163,6 +489,30 @@ func (msg *FileMessage) CalculateBuffer(prefs config.UserPreferences, width int,
 	}
 
 	msg.buffer = ansFile.Render()
+	if cacheable && !msg.placeholder {
+		putRenderedImage(msg.Thumbnail, imgWidth, imgHeight, msg.buffer)
+	}
+}
+
+// getAnimation decodes the image data if it's an animated GIF or APNG and animations are enabled.
+func (msg *FileMessage) getAnimation(prefs config.UserPreferences) *animation.Animation {
+	if prefs.DisableAnimations || msg.Type != event.MsgImage || msg.placeholder {
+		return nil
+	} else if !msg.animationChecked && !msg.placeholder {
+		msg.animationChecked = true
+		anim, err := animation.Decode(msg.imageData)
+		if err != nil && err != animation.ErrNotAnimated {
+			debug.Printf("Failed to decode animation %s: %v", msg.URL, err)
+		}
+		msg.animation = anim
+		msg.animationStart = time.Now()
+	}
+	return msg.animation
+}
+
+// Animated returns whether the message is currently shown as an animation and needs to be redrawn regularly.
+func (msg *FileMessage) Animated() bool {
+	return len(msg.frames) > 1
 }
 
 func (msg *FileMessage) Height() int {
@@ -170,7 +520,20 @@ func (msg *FileMessage) Height() int {
 }
 
 func (msg *FileMessage) Draw(screen mauview.Screen) {
-	for y, line := range msg.buffer {
+	buffer := msg.buffer
+	if frames := msg.frames; len(frames) > 1 {
+		buffer = frames[msg.animation.FrameAt(time.Since(msg.animationStart))]
+	}
+	for y, line := range buffer {
 		line.Draw(screen, 0, y)
 	}
+	if !msg.imageShown {
+		addHyperlinks(screen, buffer)
+	} else if msg.File == nil && !msg.URL.IsEmpty() {
+		// Encrypted files can't be opened in a browser, so only unencrypted images link to the full size file.
+		url := msg.matrix.GetDownloadURL(msg.URL)
+		for y, line := range buffer {
+			widget.AddHyperlink(screen, 0, y, line.RuneWidth(), url)
+		}
+	}
 }
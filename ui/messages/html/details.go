@@ -0,0 +1,151 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package html
+
+import (
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mauview"
+)
+
+// DetailsEntity is a collapsible <details> element. Only the summary is shown until the entity is opened.
+type DetailsEntity struct {
+	*BaseEntity
+
+	// The contents of the <summary> element, or a placeholder if there was none.
+	Summary Entity
+	// The rest of the contents, which are only rendered when the entity is open.
+	Body *ContainerEntity
+	// Whether or not the body is currently visible.
+	Open bool
+}
+
+const (
+	DetailsClosedChar = '▶'
+	DetailsOpenChar   = '▼'
+)
+
+func NewDetailsEntity(summary Entity, children []Entity, open bool) *DetailsEntity {
+	if summary == nil {
+		summary = NewTextEntity("Details")
+	}
+	return &DetailsEntity{
+		BaseEntity: &BaseEntity{
+			Tag:   "details",
+			Block: true,
+		},
+		Summary: summary,
+		Body: &ContainerEntity{
+			BaseEntity: &BaseEntity{
+				Tag:   "details",
+				Block: true,
+			},
+			Children: children,
+		},
+		Open: open,
+	}
+}
+
+func (de *DetailsEntity) marker() rune {
+	if de.Open {
+		return DetailsOpenChar
+	}
+	return DetailsClosedChar
+}
+
+func (de *DetailsEntity) AdjustStyle(fn AdjustStyleFunc) Entity {
+	de.Summary.AdjustStyle(fn)
+	de.Body.AdjustStyle(fn)
+	de.Style = fn(de.Style)
+	return de
+}
+
+func (de *DetailsEntity) Clone() Entity {
+	return &DetailsEntity{
+		BaseEntity: de.BaseEntity.Clone().(*BaseEntity),
+		Summary:    de.Summary.Clone(),
+		Body:       de.Body.Clone().(*ContainerEntity),
+		Open:       de.Open,
+	}
+}
+
+func (de *DetailsEntity) String() string {
+	return fmt.Sprintf("&html.DetailsEntity{Open=%t, Base=%s,\n    Summary=%s\n    Body=%s},\n",
+		de.Open, de.BaseEntity, strings.TrimRight(de.Summary.String(), "\n"), strings.TrimRight(de.Body.String(), "\n"))
+}
+
+func (de *DetailsEntity) PlainText() string {
+	var buf strings.Builder
+	buf.WriteRune(de.marker())
+	buf.WriteRune(' ')
+	buf.WriteString(de.Summary.PlainText())
+	if de.Open {
+		for _, row := range strings.Split(de.Body.PlainText(), "\n") {
+			buf.WriteString("\n  ")
+			buf.WriteString(row)
+		}
+	}
+	return buf.String()
+}
+
+func (de *DetailsEntity) CalculateBuffer(width, startX int, bare bool) int {
+	de.BaseEntity.CalculateBuffer(width, startX, bare)
+	de.Summary.CalculateBuffer(width-2, 0, bare)
+	de.height = de.Summary.Height()
+	if de.height < 1 {
+		de.height = 1
+	}
+	if de.Open {
+		de.Body.CalculateBuffer(width-2, 0, bare)
+		de.height += de.Body.Height()
+	}
+	return de.startX
+}
+
+func (de *DetailsEntity) Draw(screen mauview.Screen) {
+	width, _ := screen.Size()
+	screen.SetContent(0, 0, de.marker(), nil, de.Style)
+	summaryHeight := de.Summary.Height()
+	if summaryHeight < 1 {
+		summaryHeight = 1
+	}
+	de.Summary.Draw(&mauview.ProxyScreen{Parent: screen, OffsetX: 2, Width: width - 2, Height: summaryHeight, Style: de.Style})
+	if de.Open {
+		de.Body.Draw(&mauview.ProxyScreen{
+			Parent:  screen,
+			OffsetX: 2,
+			OffsetY: summaryHeight,
+			Width:   width - 2,
+			Height:  de.Body.Height(),
+			Style:   de.Style,
+		})
+	}
+}
+
+// ToggleDetails opens or closes all the collapsible details elements inside the given entity.
+// It returns false if there weren't any.
+func ToggleDetails(entity Entity) bool {
+	found := false
+	walkEntities(entity, func(entity Entity) {
+		if details, ok := entity.(*DetailsEntity); ok {
+			details.Open = !details.Open
+			found = true
+		}
+	})
+	return found
+}
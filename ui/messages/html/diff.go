@@ -0,0 +1,77 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package html
+
+import (
+	"regexp"
+	"strings"
+
+	"maunium.net/go/tcell"
+)
+
+var diffHunkHeaderRegex = regexp.MustCompile(`^@@ -\d+(,\d+)? \+\d+(,\d+)? @@`)
+
+// isUnifiedDiff checks if the given text looks like a unified diff, i.e. it has a
+// hunk header or a pair of ---/+++ file headers.
+func isUnifiedDiff(text string) bool {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if diffHunkHeaderRegex.MatchString(line) {
+			return true
+		} else if strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") {
+			return true
+		}
+	}
+	return false
+}
+
+func diffLineStyle(line string) tcell.Style {
+	style := tcell.StyleDefault
+	switch {
+	case strings.HasPrefix(line, "diff "), strings.HasPrefix(line, "index "),
+		strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "+++ "):
+		return style.Bold(true)
+	case strings.HasPrefix(line, "@@"):
+		return style.Foreground(tcell.ColorTeal)
+	case strings.HasPrefix(line, "+"):
+		return style.Foreground(tcell.ColorGreen)
+	case strings.HasPrefix(line, "-"):
+		return style.Foreground(tcell.ColorRed)
+	default:
+		return style
+	}
+}
+
+// diffHighlight renders a unified diff with added lines in green, removed lines in red and hunk headers in teal.
+func (parser *htmlParser) diffHighlight(text string) Entity {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	children := make([]Entity, 0, len(lines)*2)
+	for i, line := range lines {
+		children = append(children, &TextEntity{
+			BaseEntity: &BaseEntity{
+				Tag:           "diff",
+				Style:         diffLineStyle(line),
+				DefaultHeight: 1,
+			},
+			Text: line,
+		})
+		if i < len(lines)-1 {
+			children = append(children, NewBreakEntity())
+		}
+	}
+	return NewCodeBlockEntity(children, tcell.StyleDefault)
+}
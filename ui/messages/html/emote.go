@@ -0,0 +1,100 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package html
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+
+	"maunium.net/go/mauview"
+
+	"maunium.net/go/gomuks/lib/ansimage"
+	"maunium.net/go/gomuks/ui/messages/tstring"
+)
+
+// EmoteWidth is the number of cells a custom emoticon takes in the text flow.
+const EmoteWidth = 2
+
+// EmoteEntity is a custom emoticon rendered inline as a tiny image that fits on one line.
+type EmoteEntity struct {
+	*BaseEntity
+	// The alt text of the emoticon, usually the :shortcode:.
+	Alt string
+
+	image tstring.TString
+}
+
+// NewEmoteEntity renders the given image data into an inline emoticon entity.
+func NewEmoteEntity(alt string, data []byte) (*EmoteEntity, error) {
+	// Each cell contains two vertical pixels, so this results in a square image.
+	img, err := ansimage.NewScaledFromReader(bytes.NewReader(data), 2, EmoteWidth, color.Black)
+	if err != nil {
+		return nil, err
+	}
+	rendered := img.Render()
+	if len(rendered) == 0 {
+		return nil, fmt.Errorf("empty image")
+	}
+	return &EmoteEntity{
+		BaseEntity: &BaseEntity{
+			Tag: "img",
+		},
+		Alt:   alt,
+		image: rendered[0],
+	}, nil
+}
+
+func (ee *EmoteEntity) AdjustStyle(fn AdjustStyleFunc) Entity {
+	ee.BaseEntity = ee.BaseEntity.AdjustStyle(fn).(*BaseEntity)
+	return ee
+}
+
+func (ee *EmoteEntity) Clone() Entity {
+	return &EmoteEntity{
+		BaseEntity: ee.BaseEntity.Clone().(*BaseEntity),
+		Alt:        ee.Alt,
+		image:      ee.image,
+	}
+}
+
+func (ee *EmoteEntity) PlainText() string {
+	return ee.Alt
+}
+
+func (ee *EmoteEntity) String() string {
+	return fmt.Sprintf("&html.EmoteEntity{Alt=%s, Base=%s},\n", ee.Alt, ee.BaseEntity)
+}
+
+func (ee *EmoteEntity) Draw(screen mauview.Screen) {
+	if ee.height > 1 {
+		// The emoticon didn't fit on the previous line
+		ee.image.Draw(screen, 0, ee.height-1)
+	} else {
+		ee.image.Draw(screen, ee.startX, 0)
+	}
+}
+
+func (ee *EmoteEntity) CalculateBuffer(width, startX int, bare bool) int {
+	ee.BaseEntity.CalculateBuffer(width, startX, bare)
+	ee.height = 1
+	if ee.startX > 0 && ee.startX+EmoteWidth > width {
+		ee.height = 2
+		return EmoteWidth
+	}
+	return ee.startX + EmoteWidth
+}
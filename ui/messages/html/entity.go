@@ -47,3 +47,31 @@ type Entity interface {
 
 	getStartX() int
 }
+
+// walkEntities calls the given function for the entity and all of its descendants, including the hidden parts.
+func walkEntities(entity Entity, fn func(Entity)) {
+	fn(entity)
+	switch typed := entity.(type) {
+	case *ContainerEntity:
+		for _, child := range typed.Children {
+			walkEntities(child, fn)
+		}
+	case *ListEntity:
+		walkEntities(typed.ContainerEntity, fn)
+	case *BlockquoteEntity:
+		walkEntities(typed.ContainerEntity, fn)
+	case *CodeBlockEntity:
+		walkEntities(typed.ContainerEntity, fn)
+	case *SpoilerEntity:
+		walkEntities(typed.ContainerEntity, fn)
+	case *DetailsEntity:
+		walkEntities(typed.Summary, fn)
+		walkEntities(typed.Body, fn)
+	case *TableEntity:
+		for _, row := range typed.Rows {
+			for _, cell := range row {
+				walkEntities(cell, fn)
+			}
+		}
+	}
+}
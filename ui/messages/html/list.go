@@ -29,6 +29,14 @@ type ListEntity struct {
 	*ContainerEntity
 	Ordered bool
 	Start   int
+	// Nesting level of the list, used to pick the bullet for unordered lists.
+	Depth int
+}
+
+var listBullets = []rune{'●', '○', '■'}
+
+func (le *ListEntity) bullet() rune {
+	return listBullets[le.Depth%len(listBullets)]
 }
 
 func digits(num int) int {
@@ -68,6 +76,7 @@ func (le *ListEntity) Clone() Entity {
 		ContainerEntity: le.ContainerEntity.Clone().(*ContainerEntity),
 		Ordered:    le.Ordered,
 		Start:      le.Start,
+		Depth:      le.Depth,
 	}
 }
 
@@ -82,7 +91,7 @@ func (le *ListEntity) Draw(screen mauview.Screen) {
 			line := fmt.Sprintf("%d. %s", number, strings.Repeat(" ", le.Indent-2-digits(number)))
 			widget.WriteLine(screen, mauview.AlignLeft, line, 0, proxyScreen.OffsetY, le.Indent, le.Style)
 		} else {
-			screen.SetContent(0, proxyScreen.OffsetY, '●', nil, le.Style)
+			screen.SetContent(0, proxyScreen.OffsetY, le.bullet(), nil, le.Style)
 		}
 		entity.Draw(proxyScreen)
 		proxyScreen.SetStyle(le.Style)
@@ -101,7 +110,8 @@ func (le *ListEntity) PlainText() string {
 			number := le.Start + i
 			_, _ = fmt.Fprintf(&buf, "%d. %s", number, strings.Repeat(" ", le.Indent-2-digits(number)))
 		} else {
-			buf.WriteString("● ")
+			buf.WriteRune(le.bullet())
+			buf.WriteRune(' ')
 		}
 		for j, row := range strings.Split(child.PlainText(), "\n") {
 			if j != 0 {
@@ -116,5 +126,5 @@ func (le *ListEntity) PlainText() string {
 }
 
 func (le *ListEntity) String() string {
-	return fmt.Sprintf("&html.ListEntity{Ordered=%t, Start=%d, Base=%s},\n", le.Ordered, le.Start, le.BaseEntity)
+	return fmt.Sprintf("&html.ListEntity{Ordered=%t, Start=%d, Depth=%d, Base=%s},\n", le.Ordered, le.Start, le.Depth, le.BaseEntity)
 }
@@ -0,0 +1,112 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package html
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/png"
+
+	"maunium.net/go/mauview"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/lib/ansimage"
+	"maunium.net/go/gomuks/ui/messages/tstring"
+)
+
+// MathsPixelsPerCell is the number of horizontal pixels of a rendered formula that are squeezed into one cell.
+const MathsPixelsPerCell = 6
+
+// MathsImageEntity is a LaTeX formula that was rendered into an image by an external command.
+type MathsImageEntity struct {
+	*BaseEntity
+	// The LaTeX source of the formula.
+	Formula string
+
+	image         image.Image
+	rendered      []tstring.TString
+	renderedWidth int
+}
+
+// NewMathsImageEntity decodes the given rendered formula image into an entity.
+func NewMathsImageEntity(formula string, data []byte) (*MathsImageEntity, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return &MathsImageEntity{
+		BaseEntity: &BaseEntity{
+			Tag:   "div",
+			Block: true,
+		},
+		Formula: formula,
+		image:   img,
+	}, nil
+}
+
+func (me *MathsImageEntity) AdjustStyle(fn AdjustStyleFunc) Entity {
+	me.BaseEntity = me.BaseEntity.AdjustStyle(fn).(*BaseEntity)
+	return me
+}
+
+func (me *MathsImageEntity) Clone() Entity {
+	return &MathsImageEntity{
+		BaseEntity: me.BaseEntity.Clone().(*BaseEntity),
+		Formula:    me.Formula,
+		image:      me.image,
+	}
+}
+
+func (me *MathsImageEntity) PlainText() string {
+	return me.Formula
+}
+
+func (me *MathsImageEntity) String() string {
+	return fmt.Sprintf("&html.MathsImageEntity{Formula=%q, Base=%s},\n", me.Formula, me.BaseEntity)
+}
+
+func (me *MathsImageEntity) CalculateBuffer(width, startX int, bare bool) int {
+	me.BaseEntity.CalculateBuffer(width, startX, bare)
+	imageWidth := me.image.Bounds().Dx() / MathsPixelsPerCell
+	if imageWidth > width {
+		imageWidth = width
+	}
+	if imageWidth < 1 {
+		imageWidth = 1
+	}
+	if imageWidth != me.renderedWidth {
+		// Formulas are usually black on a transparent background, so use a white background to keep them visible.
+		img, err := ansimage.NewScaledFromImage(me.image, 0, imageWidth, color.White)
+		if err != nil {
+			debug.Print("Failed to render formula image:", err)
+			me.rendered = []tstring.TString{tstring.NewTString(me.Formula)}
+		} else {
+			me.rendered = img.Render()
+		}
+		me.renderedWidth = imageWidth
+	}
+	me.height = len(me.rendered)
+	return me.startX
+}
+
+func (me *MathsImageEntity) Draw(screen mauview.Screen) {
+	for y, line := range me.rendered {
+		line.Draw(screen, 0, y)
+	}
+}
@@ -34,6 +34,9 @@ import (
 	"maunium.net/go/tcell"
 
 	"maunium.net/go/gomuks/config"
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/lib/latex"
 	"maunium.net/go/gomuks/matrix/rooms"
 	"maunium.net/go/gomuks/ui/widget"
 )
@@ -41,10 +44,12 @@ import (
 var matrixToURL = regexp.MustCompile("^(?:https?://)?(?:www\\.)?matrix\\.to/#/([#@!].*)")
 
 type htmlParser struct {
-	prefs *config.UserPreferences
-	room  *rooms.Room
+	matrix ifc.MatrixContainer
+	prefs  *config.UserPreferences
+	room   *rooms.Room
 
 	keepLinebreak bool
+	listDepth     int
 }
 
 func AdjustStyleBold(style tcell.Style) tcell.Style {
@@ -94,7 +99,10 @@ func (parser *htmlParser) hasAttribute(node *html.Node, attribute string) bool {
 }
 
 func (parser *htmlParser) listToEntity(node *html.Node) Entity {
+	depth := parser.listDepth
+	parser.listDepth++
 	children := parser.nodeToEntities(node.FirstChild)
+	parser.listDepth--
 	ordered := node.Data == "ol"
 	start := 1
 	if ordered {
@@ -112,7 +120,119 @@ func (parser *htmlParser) listToEntity(node *html.Node) Entity {
 			listItems = append(listItems, child)
 		}
 	}
-	return NewListEntity(ordered, start, listItems)
+	list := NewListEntity(ordered, start, listItems)
+	list.Depth = depth
+	return list
+}
+
+func (parser *htmlParser) tableToEntity(node *html.Node) Entity {
+	table := NewTableEntity()
+	var addRows func(node *html.Node, header bool)
+	addRows = func(node *html.Node, header bool) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			switch child.Data {
+			case "thead":
+				addRows(child, true)
+			case "tbody", "tfoot":
+				addRows(child, false)
+			case "tr":
+				var cells []Entity
+				onlyHeaderCells := true
+				for cellNode := child.FirstChild; cellNode != nil; cellNode = cellNode.NextSibling {
+					if cellNode.Type != html.ElementNode || (cellNode.Data != "td" && cellNode.Data != "th") {
+						continue
+					}
+					cell := NewTableCellEntity(cellNode.Data, parser.nodeToEntities(cellNode.FirstChild))
+					if cellNode.Data == "th" || header {
+						cell.AdjustStyle(AdjustStyleBold)
+					}
+					onlyHeaderCells = onlyHeaderCells && cellNode.Data == "th"
+					cells = append(cells, cell)
+				}
+				if len(cells) == 0 {
+					continue
+				}
+				// Only header rows at the very top of the table get separated from the rest.
+				if (header || onlyHeaderCells) && table.HeaderRows == len(table.Rows) {
+					table.HeaderRows++
+				}
+				table.Rows = append(table.Rows, cells)
+			}
+		}
+	}
+	addRows(node, false)
+	if len(table.Rows) == 0 {
+		return nil
+	}
+	return table
+}
+
+func (parser *htmlParser) detailsToEntity(node *html.Node) Entity {
+	var summary Entity
+	var children []Entity
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if summary == nil && child.Type == html.ElementNode && child.Data == "summary" {
+			summary = &ContainerEntity{
+				BaseEntity: &BaseEntity{
+					Tag: "summary",
+				},
+				Children: parser.nodeToEntities(child.FirstChild),
+			}
+		} else if entity := parser.singleNodeToEntity(child); entity != nil {
+			children = append(children, entity)
+		}
+	}
+	return NewDetailsEntity(summary, children, parser.hasAttribute(node, "open"))
+}
+
+func (parser *htmlParser) spoilerToEntity(node *html.Node) Entity {
+	reason := parser.getAttribute(node, "data-mx-spoiler")
+	spoiler := NewSpoilerEntity(reason, parser.nodeToEntities(node.FirstChild))
+	if len(reason) == 0 {
+		return spoiler
+	}
+	return &ContainerEntity{
+		BaseEntity: &BaseEntity{
+			Tag: "span",
+		},
+		Children: []Entity{
+			NewTextEntity(fmt.Sprintf("(%s) ", reason)).AdjustStyle(AdjustStyleItalic),
+			spoiler,
+		},
+	}
+}
+
+// mathsToEntity shows a data-mx-maths formula as Unicode text, or as an image rendered with the external
+// render command if it's too complex for plain text and images are enabled.
+func (parser *htmlParser) mathsToEntity(node *html.Node) Entity {
+	formula := parser.getAttribute(node, "data-mx-maths")
+	text, simple := latex.ToUnicode(formula)
+	if !simple && !parser.prefs.DisableImages {
+		data, err := parser.matrix.RenderMaths(formula)
+		if err != nil {
+			debug.Printf("Failed to render formula %q: %v", formula, err)
+		} else if data != nil {
+			entity, err := NewMathsImageEntity(formula, data)
+			if err == nil {
+				return entity
+			}
+			debug.Printf("Failed to decode rendered formula %q: %v", formula, err)
+		}
+	}
+	entity := &ContainerEntity{
+		BaseEntity: &BaseEntity{
+			Tag:   node.Data,
+			Block: node.Data == "div",
+		},
+		Children: []Entity{NewTextEntity(text)},
+	}
+	if entity.Block {
+		entity.Indent = 2
+	}
+	return entity
 }
 
 func (parser *htmlParser) basicFormatToEntity(node *html.Node) Entity {
@@ -225,6 +345,11 @@ func (parser *htmlParser) linkToEntity(node *html.Node) Entity {
 			entity.Children = []Entity{text}
 		}
 	}
+	walkEntities(entity, func(child Entity) {
+		if text, ok := child.(*TextEntity); ok && len(text.URL) == 0 {
+			text.URL = href
+		}
+	})
 	// TODO add click action and underline on hover for links
 	return entity
 }
@@ -237,6 +362,9 @@ func (parser *htmlParser) imageToEntity(node *html.Node) Entity {
 			alt = "[inline image]"
 		}
 	}
+	if emote := parser.emoteToEntity(node, alt); emote != nil {
+		return emote
+	}
 	entity := &TextEntity{
 		BaseEntity: &BaseEntity{
 			Tag: "img",
@@ -247,6 +375,29 @@ func (parser *htmlParser) imageToEntity(node *html.Node) Entity {
 	return entity
 }
 
+// emoteToEntity renders custom emoticons inline. It returns nil if the image isn't an emoticon or can't be shown,
+// in which case the alt text is used instead.
+func (parser *htmlParser) emoteToEntity(node *html.Node, alt string) Entity {
+	if parser.prefs.DisableImages || parser.prefs.DisableDownloads || !parser.hasAttribute(node, "data-mx-emoticon") {
+		return nil
+	}
+	uri, err := id.ParseContentURI(parser.getAttribute(node, "src"))
+	if err != nil {
+		return nil
+	}
+	data, err := parser.matrix.Download(uri, nil)
+	if err != nil {
+		debug.Printf("Failed to download emoticon %s: %v", uri, err)
+		return nil
+	}
+	entity, err := NewEmoteEntity(alt, data)
+	if err != nil {
+		debug.Printf("Failed to render emoticon %s: %v", uri, err)
+		return nil
+	}
+	return entity
+}
+
 func colourToColor(colour chroma.Colour) tcell.Color {
 	if !colour.IsSet() {
 		return tcell.ColorDefault
@@ -274,17 +425,27 @@ func tokenToTextEntity(style *chroma.Style, token *chroma.Token) *TextEntity {
 	}
 }
 
+// DefaultHighlightTheme is the chroma style used for code blocks if the user hasn't chosen a valid one.
+const DefaultHighlightTheme = "solarized-dark"
+
+func (parser *htmlParser) highlightStyle() *chroma.Style {
+	if style, ok := styles.Registry[parser.prefs.HighlightTheme]; ok {
+		return style
+	}
+	return styles.Get(DefaultHighlightTheme)
+}
+
 func (parser *htmlParser) syntaxHighlight(text, language string) Entity {
 	lexer := lexers.Get(strings.ToLower(language))
 	if lexer == nil {
-		return nil
+		// Unknown languages are shown as plain text in a code block.
+		lexer = lexers.Fallback
 	}
 	iter, err := lexer.Tokenise(nil, text)
 	if err != nil {
 		return nil
 	}
-	// TODO allow changing theme
-	style := styles.SolarizedDark
+	style := parser.highlightStyle()
 
 	tokens := iter.Tokens()
 
@@ -319,7 +480,6 @@ func (parser *htmlParser) syntaxHighlight(text, language string) Entity {
 
 func (parser *htmlParser) codeblockToEntity(node *html.Node) Entity {
 	lang := "plaintext"
-	// TODO allow disabling syntax highlighting
 	if node.FirstChild != nil && node.FirstChild.Type == html.ElementNode && node.FirstChild.Data == "code" {
 		node = node.FirstChild
 		attr := parser.getAttribute(node, "class")
@@ -335,10 +495,20 @@ func (parser *htmlParser) codeblockToEntity(node *html.Node) Entity {
 		Children: parser.nodeToEntities(node.FirstChild),
 	}).PlainText()
 	parser.keepLinebreak = false
+	if parser.prefs.DisableHighlighting {
+		return parser.syntaxHighlight(text, "plaintext")
+	} else if lang == "diff" || lang == "patch" || (lang == "plaintext" && isUnifiedDiff(text)) {
+		return parser.diffHighlight(text)
+	}
 	return parser.syntaxHighlight(text, lang)
 }
 
 func (parser *htmlParser) tagNodeToEntity(node *html.Node) Entity {
+	if parser.hasAttribute(node, "data-mx-spoiler") {
+		return parser.spoilerToEntity(node)
+	} else if len(parser.getAttribute(node, "data-mx-maths")) > 0 {
+		return parser.mathsToEntity(node)
+	}
 	switch node.Data {
 	case "blockquote":
 		return parser.blockquoteToEntity(node)
@@ -358,6 +528,27 @@ func (parser *htmlParser) tagNodeToEntity(node *html.Node) Entity {
 		return parser.codeblockToEntity(node)
 	case "hr":
 		return NewHorizontalLineEntity()
+	case "table":
+		return parser.tableToEntity(node)
+	case "details":
+		return parser.detailsToEntity(node)
+	case "dt":
+		return (&ContainerEntity{
+			BaseEntity: &BaseEntity{
+				Tag:   node.Data,
+				Block: true,
+			},
+			Children: parser.nodeToEntities(node.FirstChild),
+		}).AdjustStyle(AdjustStyleBold)
+	case "dd":
+		return &ContainerEntity{
+			BaseEntity: &BaseEntity{
+				Tag:   node.Data,
+				Block: true,
+			},
+			Children: parser.nodeToEntities(node.FirstChild),
+			Indent:   4,
+		}
 	case "mx-reply":
 		return nil
 	default:
@@ -408,7 +599,8 @@ func (parser *htmlParser) nodeToEntities(node *html.Node) (entities []Entity) {
 	return
 }
 
-var BlockTags = []string{"p", "h1", "h2", "h3", "h4", "h5", "h6", "ol", "ul", "li", "pre", "blockquote", "div", "hr", "table"}
+var BlockTags = []string{"p", "h1", "h2", "h3", "h4", "h5", "h6", "ol", "ul", "li", "pre", "blockquote", "div", "hr", "table",
+	"dl", "dt", "dd", "details", "summary"}
 
 func (parser *htmlParser) isBlockTag(tag string) bool {
 	for _, blockTag := range BlockTags {
@@ -435,7 +627,7 @@ func (parser *htmlParser) Parse(htmlData string) Entity {
 const TabLength = 4
 
 // Parse parses a HTML-formatted Matrix event into a UIMessage.
-func Parse(prefs *config.UserPreferences, room *rooms.Room, content *event.MessageEventContent, sender id.UserID, senderDisplayname string) Entity {
+func Parse(matrix ifc.MatrixContainer, room *rooms.Room, content *event.MessageEventContent, sender id.UserID, senderDisplayname string) Entity {
 	htmlData := content.FormattedBody
 
 	if content.Format != event.FormatHTML {
@@ -443,7 +635,7 @@ func Parse(prefs *config.UserPreferences, room *rooms.Room, content *event.Messa
 	}
 	htmlData = strings.Replace(htmlData, "\t", strings.Repeat(" ", TabLength), -1)
 
-	parser := htmlParser{room: room, prefs: prefs}
+	parser := htmlParser{matrix: matrix, room: room, prefs: matrix.Preferences()}
 	root := parser.Parse(htmlData)
 	beRoot := root.(*ContainerEntity)
 	beRoot.Block = false
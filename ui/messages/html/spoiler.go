@@ -0,0 +1,106 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package html
+
+import (
+	"fmt"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+)
+
+// SpoilerEntity is a data-mx-spoiler span. Its contents are blacked out until it's revealed.
+type SpoilerEntity struct {
+	*ContainerEntity
+
+	// The reason given for the spoiler, if any.
+	Reason string
+	// Whether or not the contents are currently visible.
+	Revealed bool
+}
+
+const SpoilerChar = '█'
+
+func NewSpoilerEntity(reason string, children []Entity) *SpoilerEntity {
+	return &SpoilerEntity{
+		ContainerEntity: &ContainerEntity{
+			BaseEntity: &BaseEntity{
+				Tag: "span",
+			},
+			Children: children,
+		},
+		Reason: reason,
+	}
+}
+
+func (se *SpoilerEntity) AdjustStyle(fn AdjustStyleFunc) Entity {
+	se.ContainerEntity.AdjustStyle(fn)
+	return se
+}
+
+func (se *SpoilerEntity) Clone() Entity {
+	return &SpoilerEntity{
+		ContainerEntity: se.ContainerEntity.Clone().(*ContainerEntity),
+		Reason:          se.Reason,
+		Revealed:        se.Revealed,
+	}
+}
+
+func (se *SpoilerEntity) PlainText() string {
+	if se.Revealed {
+		return se.ContainerEntity.PlainText()
+	}
+	return "[spoiler]"
+}
+
+func (se *SpoilerEntity) String() string {
+	return fmt.Sprintf("&html.SpoilerEntity{Reason=%q, Revealed=%t, Container=%s},\n", se.Reason, se.Revealed, se.ContainerEntity)
+}
+
+func (se *SpoilerEntity) Draw(screen mauview.Screen) {
+	if se.Revealed {
+		se.ContainerEntity.Draw(screen)
+	} else {
+		se.ContainerEntity.Draw(&spoilerScreen{screen})
+	}
+}
+
+// ToggleSpoilers reveals or hides all the spoilers inside the given entity.
+// It returns false if there weren't any.
+func ToggleSpoilers(entity Entity) bool {
+	found := false
+	walkEntities(entity, func(entity Entity) {
+		if spoiler, ok := entity.(*SpoilerEntity); ok {
+			spoiler.Revealed = !spoiler.Revealed
+			found = true
+		}
+	})
+	return found
+}
+
+// spoilerScreen replaces everything drawn through it with solid blocks in the same color.
+type spoilerScreen struct {
+	mauview.Screen
+}
+
+func (ss *spoilerScreen) SetContent(x, y int, mainc rune, combc []rune, style tcell.Style) {
+	ss.Screen.SetContent(x, y, SpoilerChar, nil, style)
+}
+
+func (ss *spoilerScreen) SetCell(x, y int, style tcell.Style, ch ...rune) {
+	ss.Screen.SetCell(x, y, style, SpoilerChar)
+}
@@ -0,0 +1,265 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package html
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+
+	"maunium.net/go/mauview"
+)
+
+type TableEntity struct {
+	*BaseEntity
+
+	// The cells of the table, row by row.
+	Rows [][]Entity
+	// Number of rows at the top of the table that form the header.
+	HeaderRows int
+
+	columnWidths []int
+	rowHeights   []int
+}
+
+func NewTableEntity() *TableEntity {
+	return &TableEntity{
+		BaseEntity: &BaseEntity{
+			Tag:   "table",
+			Block: true,
+		},
+	}
+}
+
+// NewTableCellEntity creates a container for the contents of a single td or th cell.
+func NewTableCellEntity(tag string, children []Entity) *ContainerEntity {
+	return &ContainerEntity{
+		BaseEntity: &BaseEntity{
+			Tag:           tag,
+			Block:         true,
+			DefaultHeight: 1,
+		},
+		Children: children,
+	}
+}
+
+func (te *TableEntity) columns() int {
+	columns := 0
+	for _, row := range te.Rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	return columns
+}
+
+func (te *TableEntity) AdjustStyle(fn AdjustStyleFunc) Entity {
+	for _, row := range te.Rows {
+		for _, cell := range row {
+			cell.AdjustStyle(fn)
+		}
+	}
+	te.Style = fn(te.Style)
+	return te
+}
+
+func (te *TableEntity) Clone() Entity {
+	rows := make([][]Entity, len(te.Rows))
+	for i, row := range te.Rows {
+		rows[i] = make([]Entity, len(row))
+		for j, cell := range row {
+			rows[i][j] = cell.Clone()
+		}
+	}
+	return &TableEntity{
+		BaseEntity: te.BaseEntity.Clone().(*BaseEntity),
+		Rows:       rows,
+		HeaderRows: te.HeaderRows,
+	}
+}
+
+func (te *TableEntity) String() string {
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "&html.TableEntity{Base=%s, HeaderRows=%d, Rows=[", te.BaseEntity, te.HeaderRows)
+	for _, row := range te.Rows {
+		buf.WriteString("\n    [")
+		for _, cell := range row {
+			buf.WriteString("\n        ")
+			buf.WriteString(strings.Join(strings.Split(strings.TrimRight(cell.String(), "\n"), "\n"), "\n        "))
+		}
+		buf.WriteString("\n    ],")
+	}
+	buf.WriteString("\n]},\n")
+	return buf.String()
+}
+
+func (te *TableEntity) PlainText() string {
+	var buf strings.Builder
+	for i, row := range te.Rows {
+		if i != 0 {
+			buf.WriteRune('\n')
+		}
+		for j, cell := range row {
+			if j != 0 {
+				buf.WriteString(" | ")
+			}
+			buf.WriteString(strings.Replace(cell.PlainText(), "\n", " ", -1))
+		}
+	}
+	return buf.String()
+}
+
+// naturalWidth returns the width the given cell would need to fit all of its lines without wrapping.
+func naturalWidth(cell Entity) int {
+	width := 1
+	for _, line := range strings.Split(cell.PlainText(), "\n") {
+		if lineWidth := runewidth.StringWidth(line); lineWidth > width {
+			width = lineWidth
+		}
+	}
+	return width
+}
+
+// calculateColumnWidths sizes each column to its widest cell. If the table doesn't fit in the available width,
+// narrow columns keep their natural width and the remaining space is split evenly between the wider columns.
+func (te *TableEntity) calculateColumnWidths(available int) {
+	columns := te.columns()
+	natural := make([]int, columns)
+	total := 0
+	for column := range natural {
+		natural[column] = 1
+		for _, row := range te.Rows {
+			if column < len(row) {
+				if width := naturalWidth(row[column]); width > natural[column] {
+					natural[column] = width
+				}
+			}
+		}
+		total += natural[column]
+	}
+	te.columnWidths = natural
+	if total <= available {
+		return
+	}
+
+	order := make([]int, columns)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return natural[order[i]] < natural[order[j]]
+	})
+	te.columnWidths = make([]int, columns)
+	remaining := available
+	for i, column := range order {
+		width := remaining / (columns - i)
+		if natural[column] < width {
+			width = natural[column]
+		}
+		if width < 1 {
+			width = 1
+		}
+		te.columnWidths[column] = width
+		remaining -= width
+	}
+}
+
+func (te *TableEntity) hasHeaderSeparator() bool {
+	return te.HeaderRows > 0 && te.HeaderRows < len(te.Rows)
+}
+
+func (te *TableEntity) CalculateBuffer(width, startX int, bare bool) int {
+	te.BaseEntity.CalculateBuffer(width, startX, bare)
+	columns := te.columns()
+	if columns == 0 {
+		te.height = 0
+		return te.startX
+	}
+	// Each column has a border on the left and one cell of padding on both sides, plus the final border.
+	te.calculateColumnWidths(width - columns*3 - 1)
+
+	te.rowHeights = make([]int, len(te.Rows))
+	te.height = 2
+	for i, row := range te.Rows {
+		te.rowHeights[i] = 1
+		for j, cell := range row {
+			cell.CalculateBuffer(te.columnWidths[j], 0, bare)
+			if cell.Height() > te.rowHeights[i] {
+				te.rowHeights[i] = cell.Height()
+			}
+		}
+		te.height += te.rowHeights[i]
+	}
+	if te.hasHeaderSeparator() {
+		te.height++
+	}
+	return te.startX
+}
+
+func (te *TableEntity) drawBorder(screen mauview.Screen, y int, left, middle, right rune) {
+	x := 0
+	for i, columnWidth := range te.columnWidths {
+		char := middle
+		if i == 0 {
+			char = left
+		}
+		screen.SetContent(x, y, char, nil, te.Style)
+		for j := 1; j <= columnWidth+2; j++ {
+			screen.SetContent(x+j, y, '─', nil, te.Style)
+		}
+		x += columnWidth + 3
+	}
+	screen.SetContent(x, y, right, nil, te.Style)
+}
+
+func (te *TableEntity) Draw(screen mauview.Screen) {
+	if len(te.columnWidths) == 0 {
+		return
+	}
+	te.drawBorder(screen, 0, '┌', '┬', '┐')
+	y := 1
+	for i, row := range te.Rows {
+		if i == te.HeaderRows && te.hasHeaderSeparator() {
+			te.drawBorder(screen, y, '├', '┼', '┤')
+			y++
+		}
+		x := 0
+		for j, columnWidth := range te.columnWidths {
+			for line := 0; line < te.rowHeights[i]; line++ {
+				screen.SetContent(x, y+line, '│', nil, te.Style)
+			}
+			if j < len(row) {
+				row[j].Draw(&mauview.ProxyScreen{
+					Parent:  screen,
+					OffsetX: x + 2,
+					OffsetY: y,
+					Width:   columnWidth,
+					Height:  te.rowHeights[i],
+					Style:   te.Style,
+				})
+			}
+			x += columnWidth + 3
+		}
+		for line := 0; line < te.rowHeights[i]; line++ {
+			screen.SetContent(x, y+line, '│', nil, te.Style)
+		}
+		y += te.rowHeights[i]
+	}
+	te.drawBorder(screen, y, '└', '┴', '┘')
+}
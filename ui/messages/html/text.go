@@ -19,6 +19,7 @@ package html
 import (
 	"fmt"
 	"regexp"
+	"strings"
 
 	"github.com/mattn/go-runewidth"
 
@@ -30,6 +31,8 @@ type TextEntity struct {
 	*BaseEntity
 	// Text in this entity.
 	Text string
+	// The URL the text links to, if it's part of a link.
+	URL string
 
 	buffer []string
 }
@@ -53,6 +56,7 @@ func (te *TextEntity) Clone() Entity {
 	return &TextEntity{
 		BaseEntity: te.BaseEntity.Clone().(*BaseEntity),
 		Text:       te.Text,
+		URL:        te.URL,
 	}
 }
 
@@ -69,6 +73,7 @@ func (te *TextEntity) Draw(screen mauview.Screen) {
 	x := te.startX
 	for y, line := range te.buffer {
 		widget.WriteLine(screen, mauview.AlignLeft, line, x, y, width, te.Style)
+		widget.AddHyperlink(screen, x, y, runewidth.StringWidth(strings.TrimRight(line, " ")), te.URL)
 		x = 0
 	}
 }
@@ -30,18 +30,23 @@ type HTMLMessage struct {
 	FocusedBg tcell.Color
 	TextColor tcell.Color
 	focused   bool
+
+	// The text color that has been applied to the entities that don't have a color of their own.
+	appliedTextColor tcell.Color
 }
 
 func NewHTMLMessage(evt *muksevt.Event, displayname string, root html.Entity) *UIMessage {
 	return newUIMessage(evt, displayname, &HTMLMessage{
-		Root: root,
+		Root:             root,
+		appliedTextColor: tcell.ColorDefault,
 	})
 }
 
 func (hw *HTMLMessage) Clone() MessageRenderer {
 	return &HTMLMessage{
-		Root:      hw.Root.Clone(),
-		FocusedBg: hw.FocusedBg,
+		Root:             hw.Root.Clone(),
+		FocusedBg:        hw.FocusedBg,
+		appliedTextColor: hw.appliedTextColor,
 	}
 }
 
@@ -49,14 +54,16 @@ func (hw *HTMLMessage) Draw(screen mauview.Screen) {
 	if hw.focused {
 		screen.SetStyle(tcell.StyleDefault.Background(hw.FocusedBg).Foreground(hw.TextColor))
 	}
-	if hw.TextColor != tcell.ColorDefault {
+	if hw.TextColor != hw.appliedTextColor {
+		prevColor := hw.appliedTextColor
 		hw.Root.AdjustStyle(func(style tcell.Style) tcell.Style {
 			fg, _, _ := style.Decompose()
-			if fg == tcell.ColorDefault {
+			if fg == tcell.ColorDefault || fg == prevColor {
 				return style.Foreground(hw.TextColor)
 			}
 			return style
 		})
+		hw.appliedTextColor = hw.TextColor
 	}
 	screen.Clear()
 	hw.Root.Draw(screen)
@@ -92,6 +99,18 @@ func (hw *HTMLMessage) CalculateBuffer(preferences config.UserPreferences, width
 	hw.Root.CalculateBuffer(width, startX, preferences.BareMessageView)
 }
 
+// ToggleDetails opens or closes the collapsible <details> elements in the message.
+// It returns false if the message doesn't have any.
+func (hw *HTMLMessage) ToggleDetails() bool {
+	return html.ToggleDetails(hw.Root)
+}
+
+// ToggleSpoilers reveals or hides the spoilers in the message.
+// It returns false if the message doesn't have any.
+func (hw *HTMLMessage) ToggleSpoilers() bool {
+	return html.ToggleSpoilers(hw.Root)
+}
+
 func (hw *HTMLMessage) Height() int {
 	return hw.Root.Height()
 }
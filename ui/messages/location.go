@@ -0,0 +1,183 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package messages
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/config"
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/lib/ansimage"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/ui/messages/tstring"
+)
+
+const minimapWidth = 32
+
+var ErrInvalidGeoURI = errors.New("invalid geo URI")
+
+// ParseGeoURI parses the latitude and longitude from a RFC 5870 geo URI like geo:60.17,24.94;u=35.
+func ParseGeoURI(uri string) (lat, lon float64, err error) {
+	if !strings.HasPrefix(uri, "geo:") {
+		return 0, 0, ErrInvalidGeoURI
+	}
+	coords := strings.SplitN(uri[len("geo:"):], ";", 2)[0]
+	parts := strings.Split(coords, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, 0, ErrInvalidGeoURI
+	}
+	lat, err = strconv.ParseFloat(parts[0], 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, ErrInvalidGeoURI
+	}
+	lon, err = strconv.ParseFloat(parts[1], 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return 0, 0, ErrInvalidGeoURI
+	}
+	return lat, lon, nil
+}
+
+// OpenStreetMapURL returns a link to the given coordinates on openstreetmap.org.
+func OpenStreetMapURL(lat, lon float64) string {
+	return fmt.Sprintf("https://www.openstreetmap.org/?mlat=%[1]f&mlon=%[2]f#map=16/%[1]f/%[2]f", lat, lon)
+}
+
+type LocationMessage struct {
+	Body    string
+	GeoURI  string
+	Lat     float64
+	Lon     float64
+	Valid   bool
+	Address string
+
+	mapData []byte
+	buffer  []tstring.TString
+
+	matrix ifc.MatrixContainer
+}
+
+// NewLocationMessage creates a new LocationMessage object from the given m.location message content.
+func NewLocationMessage(matrix ifc.MatrixContainer, evt *muksevt.Event, content *event.MessageEventContent, displayname string) *UIMessage {
+	lat, lon, err := ParseGeoURI(content.GeoURI)
+	return newUIMessage(evt, displayname, &LocationMessage{
+		Body:   content.Body,
+		GeoURI: content.GeoURI,
+		Lat:    lat,
+		Lon:    lon,
+		Valid:  err == nil,
+		matrix: matrix,
+	})
+}
+
+// Load looks up the address of the location and downloads the minimap if they're enabled.
+func (msg *LocationMessage) Load() {
+	if !msg.Valid {
+		return
+	}
+	var err error
+	msg.Address, err = msg.matrix.ReverseGeocode(msg.Lat, msg.Lon)
+	if err != nil {
+		debug.Printf("Failed to reverse geocode %s: %v", msg.GeoURI, err)
+	}
+	prefs := msg.matrix.Preferences()
+	if prefs.ShowLocationMaps && !prefs.DisableImages && !prefs.DisableDownloads {
+		msg.mapData, err = msg.matrix.LocationMinimap(msg.Lat, msg.Lon)
+		if err != nil {
+			debug.Printf("Failed to load minimap for %s: %v", msg.GeoURI, err)
+		}
+	}
+}
+
+func (msg *LocationMessage) Clone() MessageRenderer {
+	clone := *msg
+	clone.buffer = nil
+	return &clone
+}
+
+func (msg *LocationMessage) NotificationContent() string {
+	return "Sent a location"
+}
+
+func (msg *LocationMessage) PlainText() string {
+	if !msg.Valid {
+		return msg.Body
+	}
+	return fmt.Sprintf("%s: %s", msg.Body, OpenStreetMapURL(msg.Lat, msg.Lon))
+}
+
+func (msg *LocationMessage) String() string {
+	return fmt.Sprintf(`&messages.LocationMessage{Body="%s", GeoURI="%s"}`, msg.Body, msg.GeoURI)
+}
+
+func (msg *LocationMessage) text() tstring.TString {
+	text := tstring.NewTString("📍 " + msg.Body)
+	if !msg.Valid {
+		return text.AppendColor(fmt.Sprintf("\nInvalid location %q", msg.GeoURI), tcell.ColorRed)
+	}
+	if len(msg.Address) > 0 {
+		text = text.AppendStyle("\n"+msg.Address, tcell.StyleDefault.Italic(true))
+	}
+	return text.
+		Append(fmt.Sprintf("\n%.5f, %.5f ", msg.Lat, msg.Lon)).
+		AppendStyle(OpenStreetMapURL(msg.Lat, msg.Lon), tcell.StyleDefault.Underline(true))
+}
+
+func (msg *LocationMessage) CalculateBuffer(prefs config.UserPreferences, width int, uiMsg *UIMessage) {
+	if width < 2 {
+		return
+	}
+	msg.buffer = calculateBufferWithText(prefs, msg.text(), width, uiMsg)
+	if prefs.BareMessageView || prefs.DisableImages || !prefs.ShowLocationMaps || len(msg.mapData) == 0 {
+		return
+	}
+	mapWidth := minimapWidth
+	if mapWidth > width {
+		mapWidth = width
+	}
+	mapHeight := 0
+	// Each row of the rendered image contains two pixels.
+	if maxRows := uiMsg.ImageSize.MaxRows; maxRows > 0 && mapWidth > maxRows*2 {
+		mapWidth = 0
+		mapHeight = maxRows * 2
+	}
+	minimap, err := ansimage.NewScaledFromReader(bytes.NewReader(msg.mapData), mapHeight, mapWidth, color.Black)
+	if err != nil {
+		debug.Print("Failed to display minimap:", err)
+		return
+	}
+	msg.buffer = append(msg.buffer, minimap.Render()...)
+}
+
+func (msg *LocationMessage) Height() int {
+	return len(msg.buffer)
+}
+
+func (msg *LocationMessage) Draw(screen mauview.Screen) {
+	for y, line := range msg.buffer {
+		line.Draw(screen, 0, y)
+	}
+}
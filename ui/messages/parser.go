@@ -67,11 +67,7 @@ func ParseEvent(matrix ifc.MatrixContainer, mainView ifc.MainView, room *rooms.R
 }
 
 func directParseEvent(matrix ifc.MatrixContainer, room *rooms.Room, evt *muksevt.Event) *UIMessage {
-	displayname := string(evt.Sender)
-	member := room.GetMember(evt.Sender)
-	if member != nil {
-		displayname = member.Displayname
-	}
+	displayname := room.GetDisambiguatedDisplayname(evt.Sender)
 	if evt.Unsigned.RedactedBecause != nil || evt.Type == event.EventRedaction {
 		return NewRedactedMessage(evt, displayname)
 	}
@@ -81,6 +77,11 @@ func directParseEvent(matrix ifc.MatrixContainer, room *rooms.Room, evt *muksevt
 			content.MsgType = event.MsgImage
 		}
 		return ParseMessage(matrix, room, evt, displayname)
+	case *muksevt.PollStartContent:
+		return ParsePoll(matrix.Client().UserID, evt, displayname)
+	case *muksevt.PollResponseContent, *muksevt.PollEndContent:
+		// Poll responses are aggregated into the poll start event.
+		return nil
 	case *muksevt.BadEncryptedContent:
 		return NewExpandedTextMessage(evt, displayname, tstring.NewStyleTString(content.Reason, tcell.StyleDefault.Italic(true)))
 	case *muksevt.EncryptionUnsupportedContent:
@@ -204,7 +205,7 @@ func ParseMessage(matrix ifc.MatrixContainer, room *rooms.Room, evt *muksevt.Eve
 	switch content.MsgType {
 	case event.MsgText, event.MsgNotice, event.MsgEmote:
 		if content.Format == event.FormatHTML {
-			return NewHTMLMessage(evt, displayname, html.Parse(matrix.Preferences(), room, content, evt.Sender, displayname))
+			return NewHTMLMessage(evt, displayname, html.Parse(matrix, room, content, evt.Sender, displayname))
 		}
 		content.Body = strings.Replace(content.Body, "\t", "    ", -1)
 		return NewTextMessage(evt, displayname, content.Body)
@@ -212,9 +213,16 @@ func ParseMessage(matrix ifc.MatrixContainer, room *rooms.Room, evt *muksevt.Eve
 		msg := NewFileMessage(matrix, evt, displayname)
 		if !matrix.Preferences().DisableDownloads {
 			renderer := msg.Renderer.(*FileMessage)
-			renderer.DownloadPreview()
+			// If there's a blurhash, the UI shows it and downloads the real preview in the background.
+			if !renderer.LoadPlaceholder() {
+				renderer.DownloadPreview()
+			}
 		}
 		return msg
+	case event.MsgLocation:
+		msg := NewLocationMessage(matrix, evt, content, displayname)
+		msg.Renderer.(*LocationMessage).Load()
+		return msg
 	}
 	return nil
 }
@@ -265,11 +273,7 @@ func getMembershipChangeMessage(evt *muksevt.Event, content *event.MemberEventCo
 }
 
 func getMembershipEventContent(room *rooms.Room, evt *muksevt.Event) (sender string, text tstring.TString) {
-	member := room.GetMember(evt.Sender)
-	senderDisplayname := string(evt.Sender)
-	if member != nil {
-		senderDisplayname = member.Displayname
-	}
+	senderDisplayname := room.GetDisambiguatedDisplayname(evt.Sender)
 
 	content := evt.Content.AsMember()
 	displayname := content.Displayname
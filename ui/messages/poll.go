@@ -0,0 +1,81 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package messages
+
+import (
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/ui/messages/tstring"
+)
+
+const pollBarWidth = 10
+
+// ParsePoll renders a poll start event with the current tally of its responses.
+func ParsePoll(userID id.UserID, evt *muksevt.Event, displayname string) *UIMessage {
+	content := evt.Content.Parsed.(*muksevt.PollStartContent)
+	state := evt.Gomuks.Poll
+	counts, voters := state.Tally(content)
+	ended := state != nil && state.Ended
+	showResults := ended || content.Disclosed()
+	var ownAnswers []string
+	if state != nil {
+		ownAnswers = state.Votes[userID].Answers
+	}
+
+	text := tstring.NewStyleTString("📊 "+content.Poll.Question.Text, tcell.StyleDefault.Bold(true))
+	for i, answer := range content.Poll.Answers {
+		text = text.Append(fmt.Sprintf("\n%2d. %s", i+1, answer.Text))
+		if showResults {
+			filled := 0
+			if voters > 0 {
+				filled = counts[answer.ID] * pollBarWidth / voters
+			}
+			text = text.Append("  ").
+				AppendColor(strings.Repeat("█", filled), tcell.ColorGreen).
+				AppendColor(strings.Repeat("░", pollBarWidth-filled), tcell.ColorGray).
+				Append(fmt.Sprintf(" %d", counts[answer.ID]))
+		}
+		for _, answerID := range ownAnswers {
+			if answerID == answer.ID {
+				text = text.AppendColor(" ✓", tcell.ColorGreen)
+				break
+			}
+		}
+	}
+
+	var footer []string
+	if voters == 1 {
+		footer = append(footer, "1 vote")
+	} else {
+		footer = append(footer, fmt.Sprintf("%d votes", voters))
+	}
+	if maxSelections := content.GetMaxSelections(); maxSelections > 1 {
+		footer = append(footer, fmt.Sprintf("choose up to %d", maxSelections))
+	}
+	if ended {
+		footer = append(footer, "ended")
+	} else if !content.Disclosed() {
+		footer = append(footer, "results shown when the poll ends")
+	}
+	text = text.AppendStyle("\n"+strings.Join(footer, " · "), tcell.StyleDefault.Italic(true))
+	return NewExpandedTextMessage(evt, displayname, text)
+}
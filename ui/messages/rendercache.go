@@ -0,0 +1,96 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package messages
+
+import (
+	sync "github.com/sasha-s/go-deadlock"
+
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/ui/messages/tstring"
+)
+
+// Maximum number of rendered images kept in memory.
+const maxRenderCacheEntries = 256
+
+// Sizes of the thumbnails requested from the media repository, from smallest to largest.
+// These are the sizes servers are recommended to pre-generate.
+var thumbnailSizes = [][2]int{{320, 240}, {640, 480}, {800, 600}}
+
+type renderKey struct {
+	uri           id.ContentURI
+	width, height int
+}
+
+// renderCache stores the terminal rendering of images so that messages showing the same image at the same
+// size don't have to decode and scale it again, e.g. when switching rooms or recalculating buffers.
+var renderCache = struct {
+	lock    sync.Mutex
+	entries map[renderKey][]tstring.TString
+	order   []renderKey
+
+	terminalWidth int
+}{entries: make(map[renderKey][]tstring.TString)}
+
+func getRenderedImage(uri id.ContentURI, width, height int) ([]tstring.TString, bool) {
+	renderCache.lock.Lock()
+	defer renderCache.lock.Unlock()
+	buffer, ok := renderCache.entries[renderKey{uri, width, height}]
+	// Limit the capacity so that appending to the buffer doesn't modify the cached array.
+	return buffer[:len(buffer):len(buffer)], ok
+}
+
+func putRenderedImage(uri id.ContentURI, width, height int, buffer []tstring.TString) {
+	renderCache.lock.Lock()
+	defer renderCache.lock.Unlock()
+	key := renderKey{uri, width, height}
+	if _, ok := renderCache.entries[key]; !ok {
+		renderCache.order = append(renderCache.order, key)
+	}
+	renderCache.entries[key] = buffer
+	for len(renderCache.order) > maxRenderCacheEntries {
+		delete(renderCache.entries, renderCache.order[0])
+		renderCache.order = renderCache.order[1:]
+	}
+}
+
+// SetTerminalSize clears the rendered image cache if the terminal size has changed,
+// as the images will need to be rendered at different sizes anyway.
+func SetTerminalSize(width int) {
+	renderCache.lock.Lock()
+	defer renderCache.lock.Unlock()
+	if renderCache.terminalWidth != width {
+		renderCache.terminalWidth = width
+		renderCache.entries = make(map[renderKey][]tstring.TString)
+		renderCache.order = nil
+	}
+}
+
+// thumbnailSize returns the smallest thumbnail size that is at least as wide as the terminal.
+// Images are never rendered wider than the terminal and each cell shows one pixel horizontally.
+func thumbnailSize() (width, height int) {
+	renderCache.lock.Lock()
+	terminalWidth := renderCache.terminalWidth
+	renderCache.lock.Unlock()
+	for _, size := range thumbnailSizes {
+		if size[0] >= terminalWidth {
+			return size[0], size[1]
+		}
+	}
+	size := thumbnailSizes[len(thumbnailSizes)-1]
+	return size[0], size[1]
+}
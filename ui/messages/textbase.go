@@ -20,8 +20,11 @@ import (
 	"fmt"
 	"regexp"
 
+	"maunium.net/go/mauview"
+
 	"maunium.net/go/gomuks/config"
 	"maunium.net/go/gomuks/ui/messages/tstring"
+	"maunium.net/go/gomuks/ui/widget"
 )
 
 // Regular expressions used to split lines when calculating the buffer.
@@ -52,6 +55,15 @@ func matchBoundaryPattern(bare bool, extract tstring.TString) tstring.TString {
 // CalculateBuffer generates the internal buffer for this message that consists
 // of the text of this message split into lines at most as wide as the width
 // parameter.
+// addHyperlinks registers the URLs in the given text buffer drawn on the screen as hyperlinks.
+func addHyperlinks(screen mauview.Screen, buffer []tstring.TString) {
+	lines := make([]string, len(buffer))
+	for i, line := range buffer {
+		lines[i] = line.String()
+	}
+	widget.AddTextHyperlinks(screen, lines)
+}
+
 func calculateBufferWithText(prefs config.UserPreferences, text tstring.TString, width int, msg *UIMessage) []tstring.TString {
 	if width < 2 {
 		return nil
@@ -22,6 +22,7 @@ import (
 
 	"maunium.net/go/gomuks/matrix/muksevt"
 	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
 
 	"maunium.net/go/gomuks/config"
 	"maunium.net/go/gomuks/ui/messages/tstring"
@@ -29,8 +30,8 @@ import (
 
 type TextMessage struct {
 	cache       tstring.TString
+	cacheColors [2]tcell.Color
 	buffer      []tstring.TString
-	isHighlight bool
 	Text        string
 }
 
@@ -60,7 +61,10 @@ func (msg *TextMessage) Clone() MessageRenderer {
 }
 
 func (msg *TextMessage) getCache(uiMsg *UIMessage) tstring.TString {
-	if msg.cache == nil {
+	// The colors can change when the message is highlighted or the sender color preferences change
+	colors := [2]tcell.Color{uiMsg.TextColor(), uiMsg.SenderColor()}
+	if msg.cache == nil || colors != msg.cacheColors {
+		msg.cacheColors = colors
 		switch uiMsg.Type {
 		case "m.emote":
 			msg.cache = tstring.NewColorTString(fmt.Sprintf("* %s %s", uiMsg.SenderName, msg.Text), uiMsg.TextColor())
@@ -85,9 +89,6 @@ func (msg *TextMessage) String() string {
 }
 
 func (msg *TextMessage) CalculateBuffer(prefs config.UserPreferences, width int, uiMsg *UIMessage) {
-	if uiMsg.IsHighlight != msg.isHighlight {
-		msg.cache = nil
-	}
 	msg.buffer = calculateBufferWithText(prefs, msg.getCache(uiMsg), width, uiMsg)
 }
 
@@ -99,4 +100,5 @@ func (msg *TextMessage) Draw(screen mauview.Screen) {
 	for y, line := range msg.buffer {
 		line.Draw(screen, 0, y)
 	}
+	addHyperlinks(screen, msg.buffer)
 }
@@ -0,0 +1,85 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package messages
+
+import (
+	"maunium.net/go/tcell"
+)
+
+// TrustLevel describes how trustworthy the device and keys that an encrypted message was sent with are.
+type TrustLevel int
+
+const (
+	// TrustNone means the message wasn't encrypted.
+	TrustNone TrustLevel = iota
+	// TrustVerified means the message was sent from a verified device.
+	TrustVerified
+	// TrustUnverified means the message was sent from a device that isn't verified.
+	TrustUnverified
+	// TrustUnknownDevice means the sending device is unknown, deleted or doesn't match the sender key.
+	TrustUnknownDevice
+	// TrustForwardedKey means the message was decrypted with a key forwarded by another device,
+	// so the authenticity of the sender can't be guaranteed.
+	TrustForwardedKey
+)
+
+// Icon returns a single-width character that represents the trust level.
+func (tl TrustLevel) Icon() rune {
+	switch tl {
+	case TrustVerified:
+		return '✓'
+	case TrustUnverified:
+		return '!'
+	case TrustUnknownDevice:
+		return '?'
+	case TrustForwardedKey:
+		return '~'
+	default:
+		return ' '
+	}
+}
+
+// Color returns the color used to represent the trust level.
+func (tl TrustLevel) Color() tcell.Color {
+	switch tl {
+	case TrustVerified:
+		return tcell.ColorGreen
+	case TrustUnverified:
+		return tcell.ColorYellow
+	case TrustUnknownDevice:
+		return tcell.ColorRed
+	case TrustForwardedKey:
+		return tcell.ColorOrange
+	default:
+		return tcell.ColorDefault
+	}
+}
+
+func (tl TrustLevel) String() string {
+	switch tl {
+	case TrustVerified:
+		return "verified device"
+	case TrustUnverified:
+		return "unverified device"
+	case TrustUnknownDevice:
+		return "unknown or deleted device"
+	case TrustForwardedKey:
+		return "insecure forwarded key"
+	default:
+		return "not encrypted"
+	}
+}
@@ -0,0 +1,70 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package messages
+
+import (
+	"strings"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/ui/messages/tstring"
+)
+
+// URLPreviewCard is the title, description and domain of a linked web page shown under a message.
+type URLPreviewCard struct {
+	Preview *ifc.URLPreview
+	buffer  []tstring.TString
+}
+
+func (card *URLPreviewCard) CalculateBuffer(width int) {
+	if card == nil {
+		return
+	}
+	card.buffer = nil
+	if width < 3 {
+		return
+	}
+	bar := tstring.NewColorTString("▎ ", tcell.ColorDarkCyan)
+	addLine := func(text string, style tcell.Style) {
+		text = strings.Join(strings.Fields(text), " ")
+		if len(text) > 0 {
+			card.buffer = append(card.buffer, bar.AppendTString(tstring.NewStyleTString(text, style)).Truncate(width))
+		}
+	}
+	title := card.Preview.Title
+	if len(card.Preview.SiteName) > 0 && card.Preview.SiteName != title {
+		title = card.Preview.SiteName + ": " + title
+	}
+	addLine(title, tcell.StyleDefault.Bold(true))
+	addLine(card.Preview.Description, tcell.StyleDefault)
+	addLine(card.Preview.Domain, tcell.StyleDefault.Foreground(tcell.ColorGray))
+}
+
+func (card *URLPreviewCard) Height() int {
+	if card == nil {
+		return 0
+	}
+	return len(card.buffer)
+}
+
+func (card *URLPreviewCard) Draw(screen mauview.Screen) {
+	for y, line := range card.buffer {
+		line.Draw(screen, 0, y)
+	}
+}
@@ -0,0 +1,362 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/rooms"
+)
+
+const MembershipKnock event.Membership = "knock"
+
+// ModQueueEntry is a pending invite or knock in a room we moderate.
+type ModQueueEntry struct {
+	Room       *rooms.Room
+	UserID     id.UserID
+	Membership event.Membership
+	Sender     id.UserID
+	Reason     string
+}
+
+func (entry ModQueueEntry) String() string {
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "%s - %s ", entry.Room.GetTitle(), entry.UserID)
+	if entry.Membership == MembershipKnock {
+		buf.WriteString("knocked")
+	} else {
+		_, _ = fmt.Fprintf(&buf, "invited by %s", entry.Sender)
+	}
+	if len(entry.Reason) > 0 {
+		_, _ = fmt.Fprintf(&buf, ": %s", entry.Reason)
+	}
+	return buf.String()
+}
+
+type reqInviteWithReason struct {
+	UserID id.UserID `json:"user_id"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+func canModerate(room *rooms.Room, userID id.UserID) bool {
+	plEvent := room.GetStateEvent(event.StatePowerLevels, "")
+	if plEvent == nil {
+		return false
+	}
+	pls := plEvent.Content.AsPowerLevels()
+	ownLevel := pls.GetUserLevel(userID)
+	return ownLevel >= pls.Invite() || ownLevel >= pls.Kick()
+}
+
+// collectModQueue finds all pending invites and knocks in joined rooms where we have the power to invite or kick users.
+func (view *MainView) collectModQueue() (queue []ModQueueEntry) {
+	ownUserID := view.config.UserID
+	view.roomsLock.RLock()
+	roomList := make([]*rooms.Room, 0, len(view.rooms))
+	for _, roomView := range view.rooms {
+		if !roomView.Room.HasLeft {
+			roomList = append(roomList, roomView.Room)
+		}
+	}
+	view.roomsLock.RUnlock()
+
+	for _, room := range roomList {
+		if !canModerate(room, ownUserID) {
+			continue
+		}
+		for stateKey, evt := range room.GetStateEvents(event.StateMember) {
+			content := evt.Content.AsMember()
+			if content.Membership == event.MembershipInvite || content.Membership == MembershipKnock {
+				queue = append(queue, ModQueueEntry{room, id.UserID(stateKey), content.Membership, evt.Sender, content.Reason})
+			}
+		}
+	}
+	sort.Slice(queue, func(i, j int) bool {
+		if queue[i].Room != queue[j].Room {
+			return queue[i].Room.GetTitle() < queue[j].Room.GetTitle()
+		}
+		return queue[i].UserID < queue[j].UserID
+	})
+	return
+}
+
+// refreshModQueue rebuilds the moderation queue after entries have been handled. The handled entries are
+// left out even if the membership change hasn't come down through sync yet.
+func (view *MainView) refreshModQueue(handled []ModQueueEntry) []ModQueueEntry {
+	queue := view.collectModQueue()
+	filtered := queue[:0]
+	for _, entry := range queue {
+		done := false
+		for _, handledEntry := range handled {
+			if entry.Room == handledEntry.Room && entry.UserID == handledEntry.UserID {
+				done = true
+				break
+			}
+		}
+		if !done {
+			filtered = append(filtered, entry)
+		}
+	}
+	view.modQueue = filtered
+	if inbox, ok := view.modal.(*ModerationInbox); ok {
+		inbox.marked = make(map[int]bool)
+		inbox.update()
+	}
+	return filtered
+}
+
+// ModerationInbox is a modal that lists pending invites and knocks in rooms we moderate and
+// allows approving or denying several of them at once.
+type ModerationInbox struct {
+	mauview.FocusableComponent
+	parent *MainView
+	room   *RoomView
+
+	text *mauview.TextView
+
+	selected int
+	marked   map[int]bool
+}
+
+func NewModerationInbox(parent *MainView, room *RoomView) *ModerationInbox {
+	inbox := &ModerationInbox{
+		parent: parent,
+		room:   room,
+		marked: make(map[int]bool),
+	}
+
+	inbox.text = mauview.NewTextView().
+		SetScrollable(true).
+		SetWrap(false)
+
+	box := mauview.NewBox(inbox.text).
+		SetBorder(true).
+		SetTitle("Moderation inbox").
+		SetBlurCaptureFunc(func() bool {
+			inbox.parent.HideModal()
+			return true
+		})
+	box.Focus()
+	inbox.update()
+
+	inbox.FocusableComponent = mauview.FractionalCenter(box, 70, 15, 0.75, 0.5)
+
+	return inbox
+}
+
+func (inbox *ModerationInbox) update() {
+	queue := inbox.parent.modQueue
+	if inbox.selected >= len(queue) {
+		inbox.selected = len(queue) - 1
+	}
+	if inbox.selected < 0 {
+		inbox.selected = 0
+	}
+	var buf strings.Builder
+	buf.WriteString("Up/Down: select, Space: mark, a: approve, d: deny, q: close\n")
+	buf.WriteString("Approving or denying lets you type a reason before sending.\n\n")
+	if len(queue) == 0 {
+		buf.WriteString("No pending invites or knocks in rooms you moderate")
+	}
+	for i, entry := range queue {
+		prefix := "  "
+		if i == inbox.selected {
+			prefix = "> "
+		}
+		mark := "[ ]"
+		if inbox.marked[i] {
+			mark = "[x]"
+		}
+		_, _ = fmt.Fprintf(&buf, "%s%s %s\n", prefix, mark, entry)
+	}
+	inbox.text.SetText(strings.TrimSuffix(buf.String(), "\n"))
+}
+
+// selection returns the entry numbers that approve or deny would apply to: the marked entries,
+// or the selected entry if nothing is marked.
+func (inbox *ModerationInbox) selection() string {
+	var numbers []string
+	for i := range inbox.parent.modQueue {
+		if inbox.marked[i] {
+			numbers = append(numbers, strconv.Itoa(i+1))
+		}
+	}
+	if len(numbers) == 0 {
+		return strconv.Itoa(inbox.selected + 1)
+	}
+	return strings.Join(numbers, ",")
+}
+
+func (inbox *ModerationInbox) OnKeyEvent(event mauview.KeyEvent) bool {
+	queue := inbox.parent.modQueue
+	switch {
+	case event.Key() == tcell.KeyEscape || event.Rune() == 'q':
+		inbox.parent.HideModal()
+	case event.Key() == tcell.KeyUp || event.Rune() == 'k':
+		if inbox.selected > 0 {
+			inbox.selected--
+			inbox.update()
+		}
+	case event.Key() == tcell.KeyDown || event.Rune() == 'j':
+		if inbox.selected < len(queue)-1 {
+			inbox.selected++
+			inbox.update()
+		}
+	case event.Rune() == ' ':
+		if len(queue) > 0 {
+			inbox.marked[inbox.selected] = !inbox.marked[inbox.selected]
+			inbox.update()
+		}
+	case event.Rune() == 'a' || event.Rune() == 'd':
+		if len(queue) == 0 || inbox.room == nil {
+			return true
+		}
+		action := "approve"
+		if event.Rune() == 'd' {
+			action = "deny"
+		}
+		inbox.parent.HideModal()
+		inbox.room.input.SetTextAndMoveCursor(fmt.Sprintf("/modqueue %s %s ", action, inbox.selection()))
+	default:
+		return inbox.FocusableComponent.OnKeyEvent(event)
+	}
+	return true
+}
+
+const modQueueHelp = `Usage: /%s [subcommand] [...]
+
+Without a subcommand, the moderation inbox is opened for reviewing the queue interactively.
+
+Subcommands:
+* list - List pending invites and knocks in rooms you moderate.
+* approve <numbers|all> [reason] - Approve knocks by inviting the users.
+* deny <numbers|all> [reason] - Deny knocks and revoke invites.
+
+Numbers refer to the most recent list and can be separated with commas, e.g. 1,3,4.`
+
+func cmdModQueue(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmd.MainView.modQueue = cmd.MainView.collectModQueue()
+		cmd.MainView.ShowModal(NewModerationInbox(cmd.MainView, cmd.Room))
+		cmd.UI.Render()
+		return
+	}
+	subcommand := strings.ToLower(cmd.Args[0])
+	switch subcommand {
+	case "list":
+		cmdModQueueList(cmd)
+	case "approve", "deny":
+		if len(cmd.Args) < 2 {
+			cmd.Reply(modQueueHelp, cmd.OrigCommand)
+			return
+		}
+		entries, err := selectModQueueEntries(cmd.MainView.modQueue, cmd.Args[1])
+		if err != nil {
+			cmd.Reply("%v", err)
+			return
+		}
+		reason := strings.Join(cmd.Args[2:], " ")
+		var handled []ModQueueEntry
+		if subcommand == "approve" {
+			handled = cmdModQueueApprove(cmd, entries, reason)
+		} else {
+			handled = cmdModQueueDeny(cmd, entries, reason)
+		}
+		if queue := cmd.MainView.refreshModQueue(handled); len(queue) > 0 {
+			cmd.Reply("%d invites and knocks left, the numbers have been updated", len(queue))
+		}
+	default:
+		cmd.Reply(modQueueHelp, cmd.OrigCommand)
+	}
+}
+
+func cmdModQueueList(cmd *Command) {
+	queue := cmd.MainView.collectModQueue()
+	cmd.MainView.modQueue = queue
+	if len(queue) == 0 {
+		cmd.Reply("No pending invites or knocks in rooms you moderate")
+		return
+	}
+	var buf strings.Builder
+	buf.WriteString("Pending invites and knocks:\n")
+	for i, entry := range queue {
+		_, _ = fmt.Fprintf(&buf, "%d. %s\n", i+1, entry)
+	}
+	cmd.Reply("%s", strings.TrimSuffix(buf.String(), "\n"))
+}
+
+func selectModQueueEntries(queue []ModQueueEntry, selection string) ([]ModQueueEntry, error) {
+	if len(queue) == 0 {
+		return nil, fmt.Errorf("the moderation queue is empty, use /modqueue list to refresh it")
+	} else if strings.ToLower(selection) == "all" {
+		return queue, nil
+	}
+	var entries []ModQueueEntry
+	for _, part := range strings.Split(selection, ",") {
+		index, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || index < 1 || index > len(queue) {
+			return nil, fmt.Errorf("invalid entry number %q", part)
+		}
+		entries = append(entries, queue[index-1])
+	}
+	return entries, nil
+}
+
+func cmdModQueueApprove(cmd *Command, entries []ModQueueEntry, reason string) (approved []ModQueueEntry) {
+	client := cmd.Matrix.Client()
+	for _, entry := range entries {
+		if entry.Membership != MembershipKnock {
+			cmd.Reply("%s is already invited to %s", entry.UserID, entry.Room.GetTitle())
+			continue
+		}
+		req := &reqInviteWithReason{UserID: entry.UserID, Reason: reason}
+		_, err := client.MakeRequest("POST", client.BuildURL("rooms", entry.Room.ID, "invite"), req, nil)
+		if err != nil {
+			debug.Print("Error in invite call:", err)
+			cmd.Reply("Failed to invite %s to %s: %v", entry.UserID, entry.Room.GetTitle(), err)
+		} else {
+			approved = append(approved, entry)
+		}
+	}
+	cmd.Reply("Approved %d knocks", len(approved))
+	return
+}
+
+func cmdModQueueDeny(cmd *Command, entries []ModQueueEntry, reason string) (denied []ModQueueEntry) {
+	for _, entry := range entries {
+		_, err := cmd.Matrix.Client().KickUser(entry.Room.ID, &mautrix.ReqKickUser{Reason: reason, UserID: entry.UserID})
+		if err != nil {
+			debug.Print("Error in kick call:", err)
+			cmd.Reply("Failed to deny %s in %s: %v", entry.UserID, entry.Room.GetTitle(), err)
+		} else {
+			denied = append(denied, entry)
+		}
+	}
+	cmd.Reply("Denied %d invites and knocks", len(denied))
+	return
+}
@@ -18,16 +18,54 @@
 
 package ui
 
+import (
+	"maunium.net/go/mautrix/id"
+
+	ifc "maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/ui/messages"
+)
+
 func autocompleteDevice(cmd *CommandAutocomplete) ([]string, string) {
 	return []string{}, ""
 }
 
+func describeOwnDevice(_ ifc.MatrixContainer, _ id.DeviceID) string {
+	return ""
+}
+
+func describeUserDevices(_ ifc.MatrixContainer, _ id.UserID) string {
+	return "unknown (built without encryption support)"
+}
+
+func describeSenderDevice(_ ifc.MatrixContainer, _ id.UserID, _ *muksevt.EncryptionInfo) string {
+	return "unknown (built without encryption support)"
+}
+
+func requestRoomKeys(view *RoomView, _ *muksevt.Event) {
+	view.AddServiceMessage("This gomuks was built without encryption support")
+	view.parent.parent.Render()
+}
+
+func getTrustLevel(_ ifc.MatrixContainer, _ *muksevt.Event) messages.TrustLevel {
+	return messages.TrustNone
+}
+
+type QRVerificationModal struct{}
+
 func cmdNoCrypto(cmd *Command) {
 	cmd.Reply("This gomuks was built without encryption support")
 }
 
+func cmdDevices(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmdDeviceManager(cmd)
+	} else {
+		cmdNoCrypto(cmd)
+	}
+}
+
 var (
-	cmdDevices = cmdNoCrypto
 	cmdDevice = cmdNoCrypto
 	cmdVerifyDevice = cmdNoCrypto
 	cmdVerify = cmdNoCrypto
@@ -37,4 +75,7 @@ var (
 	cmdImportKeys = cmdNoCrypto
 	cmdExportKeys = cmdNoCrypto
 	cmdExportRoomKeys = cmdNoCrypto
+	cmdVerifyQR = cmdNoCrypto
+	cmdEnableEncryption = cmdNoCrypto
+	cmdDehydrate = cmdNoCrypto
 )
@@ -0,0 +1,163 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/pushrules"
+
+	"maunium.net/go/gomuks/matrix/rooms"
+)
+
+const (
+	// NotificationLogMaxAge is how long entries are kept in the notification log.
+	NotificationLogMaxAge = 7 * 24 * time.Hour
+	// NotificationLogMaxEntries is the maximum number of lines kept in the notification log.
+	NotificationLogMaxEntries = 1000
+)
+
+// NotificationLogEntry is the notification decision for a single message, including messages
+// that push rules said not to notify about.
+type NotificationLogEntry struct {
+	Time     time.Time  `json:"time"`
+	RoomID   id.RoomID  `json:"room_id"`
+	RoomName string     `json:"room_name"`
+	Sender   id.UserID  `json:"sender"`
+	EventID  id.EventID `json:"event_id"`
+	Rule     string     `json:"rule"`
+	// Whether a desktop notification was shown, and if not, why.
+	Notified bool   `json:"notified"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func (entry NotificationLogEntry) String() string {
+	status := "notified"
+	if !entry.Notified {
+		status = "not notified: " + entry.Reason
+	}
+	return fmt.Sprintf("%s %s <%s> rule %s (%s)",
+		entry.Time.Format("2006-01-02 15:04:05"), entry.RoomName, entry.Sender, entry.Rule, status)
+}
+
+// matchingPushRule finds the push rule that decided the actions for the given event.
+func matchingPushRule(rs *pushrules.PushRuleset, room *rooms.Room, evt *event.Event) string {
+	if rs == nil {
+		return "none"
+	}
+	for _, rules := range []pushrules.PushRuleArray{rs.Override, rs.Content} {
+		for _, rule := range rules {
+			if rule.Match(room, evt) {
+				return fmt.Sprintf("%s/%s", rule.Type, rule.RuleID)
+			}
+		}
+	}
+	if rule, ok := rs.Room.Map[string(room.ID)]; ok && rule.Match(room, evt) {
+		return fmt.Sprintf("%s/%s", rule.Type, rule.RuleID)
+	} else if rule, ok = rs.Sender.Map[string(evt.Sender)]; ok && rule.Match(room, evt) {
+		return fmt.Sprintf("%s/%s", rule.Type, rule.RuleID)
+	}
+	for _, rule := range rs.Underride {
+		if rule.Match(room, evt) {
+			return fmt.Sprintf("%s/%s", rule.Type, rule.RuleID)
+		}
+	}
+	return "none"
+}
+
+func (view *MainView) readNotificationLog() ([]NotificationLogEntry, error) {
+	lines, err := view.notificationLog.Read()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]NotificationLogEntry, 0, len(lines))
+	for _, line := range lines {
+		var entry NotificationLogEntry
+		if json.Unmarshal(line, &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// filterNotificationLog removes entries that are too old to be shown.
+func filterNotificationLog(entries []NotificationLogEntry) []NotificationLogEntry {
+	cutoff := time.Now().Add(-NotificationLogMaxAge)
+	start := 0
+	for start < len(entries) && entries[start].Time.Before(cutoff) {
+		start++
+	}
+	return entries[start:]
+}
+
+const notificationsHelp = `Usage: /%s <subcommand>
+
+Subcommands:
+* log [count] - Show the most recent notification decisions, including suppressed notifications,
+                and the push rules that decided them.
+* clear       - Delete the notification log.`
+
+func cmdNotifications(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmd.Reply(notificationsHelp, cmd.OrigCommand)
+		return
+	}
+	switch strings.ToLower(cmd.Args[0]) {
+	case "log":
+		count := 20
+		if len(cmd.Args) > 1 {
+			var err error
+			count, err = strconv.Atoi(cmd.Args[1])
+			if err != nil || count <= 0 {
+				cmd.Reply(notificationsHelp, cmd.OrigCommand)
+				return
+			}
+		}
+		entries, err := cmd.MainView.readNotificationLog()
+		if err != nil {
+			cmd.Reply("Failed to read notification log: %v", err)
+			return
+		}
+		entries = filterNotificationLog(entries)
+		if len(entries) == 0 {
+			cmd.Reply("The notification log is empty")
+			return
+		} else if len(entries) > count {
+			entries = entries[len(entries)-count:]
+		}
+		var buf strings.Builder
+		for _, entry := range entries {
+			buf.WriteString(entry.String())
+			buf.WriteRune('\n')
+		}
+		cmd.Reply(strings.TrimSuffix(buf.String(), "\n"))
+	case "clear":
+		if err := cmd.MainView.notificationLog.Clear(); err != nil {
+			cmd.Reply("Failed to delete notification log: %v", err)
+		} else {
+			cmd.Reply("Notification log cleared")
+		}
+	default:
+		cmd.Reply(notificationsHelp, cmd.OrigCommand)
+	}
+}
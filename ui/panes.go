@@ -0,0 +1,383 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/ui/widget"
+)
+
+// paneNode is a node in the tree of split panes in the main view. Leaf nodes show a single room,
+// while split nodes divide their area evenly between their children.
+type paneNode struct {
+	parent *paneNode
+
+	// The room shown in a leaf pane, or nil if no room has been picked for the pane yet.
+	room *RoomView
+	// If the room is also shown in another pane, this pane only shows a mirror of its timeline,
+	// which scrolls independently of the other pane.
+	mirror       *MessageView
+	mirrorScreen *mauview.ProxyScreen
+
+	// The children of a split node, and whether they're side by side rather than on top of each other.
+	children   []*paneNode
+	sideBySide bool
+
+	// The area the pane was last drawn in, used for routing mouse events.
+	screen *mauview.ProxyScreen
+}
+
+func (node *paneNode) isLeaf() bool {
+	return len(node.children) == 0
+}
+
+// leaves returns the leaf panes under this node from left to right and top to bottom.
+func (node *paneNode) leaves() []*paneNode {
+	if node.isLeaf() {
+		return []*paneNode{node}
+	}
+	var leaves []*paneNode
+	for _, child := range node.children {
+		leaves = append(leaves, child.leaves()...)
+	}
+	return leaves
+}
+
+// PaneLayout shows the rooms open in the split panes of the main view. Keyboard input goes to the
+// room in the focused pane, which is also the current room of the main view.
+type PaneLayout struct {
+	root     *paneNode
+	focused  *paneNode
+	hasFocus bool
+
+	parent *MainView
+}
+
+func NewPaneLayout(parent *MainView) *PaneLayout {
+	root := &paneNode{}
+	return &PaneLayout{
+		root:    root,
+		focused: root,
+		parent:  parent,
+	}
+}
+
+// Count returns the number of panes.
+func (pl *PaneLayout) Count() int {
+	return len(pl.root.leaves())
+}
+
+// Rooms returns the rooms shown in the panes.
+func (pl *PaneLayout) Rooms() []*RoomView {
+	var roomViews []*RoomView
+	for _, leaf := range pl.root.leaves() {
+		if leaf.room != nil && leaf.mirror == nil {
+			roomViews = append(roomViews, leaf.room)
+		}
+	}
+	return roomViews
+}
+
+// Timelines returns the message views visible in the panes.
+func (pl *PaneLayout) Timelines() []*MessageView {
+	var msgViews []*MessageView
+	for _, leaf := range pl.root.leaves() {
+		if leaf.mirror != nil {
+			msgViews = append(msgViews, leaf.mirror)
+		} else if leaf.room != nil {
+			msgViews = append(msgViews, leaf.room.MessageView())
+		}
+	}
+	return msgViews
+}
+
+// Contains returns whether the room is shown in one of the panes.
+func (pl *PaneLayout) Contains(roomView *RoomView) bool {
+	return pl.find(roomView) != nil
+}
+
+func (pl *PaneLayout) find(roomView *RoomView) *paneNode {
+	for _, leaf := range pl.root.leaves() {
+		if leaf.room == roomView {
+			return leaf
+		}
+	}
+	return nil
+}
+
+// Show shows the room in the focused pane. If the room is already shown in another pane, that pane is focused
+// instead, unless the focused pane is empty, in which case it gets a mirror of the room's timeline.
+func (pl *PaneLayout) Show(roomView *RoomView) {
+	if pl.focused.room == roomView {
+		// Already shown in the focused pane.
+	} else if leaf := pl.find(roomView); leaf == nil {
+		pl.setRoom(pl.focused, roomView, nil)
+	} else if pl.focused.room == nil {
+		pl.setRoom(pl.focused, roomView, roomView.openMirror())
+	} else {
+		pl.focused = leaf
+	}
+	pl.applyFocus()
+}
+
+// setRoom changes the room shown in a leaf pane. If the pane was showing the full view of a room
+// that is mirrored in another pane, the mirror pane takes over the full view.
+func (pl *PaneLayout) setRoom(leaf *paneNode, roomView *RoomView, mirror *MessageView) {
+	if leaf.mirror != nil {
+		leaf.room.closeMirror(leaf.mirror)
+	} else if leaf.room != nil {
+		for _, other := range pl.root.leaves() {
+			if other != leaf && other.room == leaf.room && other.mirror != nil {
+				other.room.closeMirror(other.mirror)
+				other.mirror = nil
+				break
+			}
+		}
+	}
+	leaf.room = roomView
+	leaf.mirror = mirror
+}
+
+// Remove removes the room from any pane it's shown in, leaving the panes empty.
+func (pl *PaneLayout) Remove(roomView *RoomView) {
+	for _, leaf := range pl.root.leaves() {
+		if leaf.room == roomView {
+			if leaf.mirror != nil {
+				roomView.closeMirror(leaf.mirror)
+			}
+			leaf.room = nil
+			leaf.mirror = nil
+		}
+	}
+}
+
+// Split splits the focused pane in two and focuses the new, empty pane.
+func (pl *PaneLayout) Split(sideBySide bool) {
+	leaf := pl.focused
+	newLeaf := &paneNode{}
+	if parent := leaf.parent; parent != nil && parent.sideBySide == sideBySide {
+		newLeaf.parent = parent
+		for i, child := range parent.children {
+			if child == leaf {
+				parent.children = append(parent.children[:i+1], append([]*paneNode{newLeaf}, parent.children[i+1:]...)...)
+				break
+			}
+		}
+	} else {
+		oldLeaf := &paneNode{parent: leaf, room: leaf.room, mirror: leaf.mirror}
+		newLeaf.parent = leaf
+		leaf.room = nil
+		leaf.mirror = nil
+		leaf.sideBySide = sideBySide
+		leaf.children = []*paneNode{oldLeaf, newLeaf}
+	}
+	pl.focused = newLeaf
+	pl.applyFocus()
+}
+
+// Close closes the focused pane and focuses the pane next to it. The last pane can't be closed.
+func (pl *PaneLayout) Close() bool {
+	leaf := pl.focused
+	parent := leaf.parent
+	if parent == nil {
+		return false
+	}
+	pl.setRoom(leaf, nil, nil)
+	index := 0
+	for i, child := range parent.children {
+		if child == leaf {
+			index = i
+			parent.children = append(parent.children[:i], parent.children[i+1:]...)
+			break
+		}
+	}
+	if index >= len(parent.children) {
+		index = len(parent.children) - 1
+	}
+	next := parent.children[index]
+	if len(parent.children) == 1 {
+		// Replace the split node with its only remaining child.
+		parent.room = next.room
+		parent.mirror = next.mirror
+		parent.sideBySide = next.sideBySide
+		parent.children = next.children
+		for _, child := range parent.children {
+			child.parent = parent
+		}
+		next = parent
+	}
+	pl.focused = next.leaves()[0]
+	pl.applyFocus()
+	return true
+}
+
+// Cycle focuses the next or previous pane and returns the room in it.
+func (pl *PaneLayout) Cycle(forward bool) *RoomView {
+	leaves := pl.root.leaves()
+	for i, leaf := range leaves {
+		if leaf == pl.focused {
+			if forward {
+				pl.focused = leaves[(i+1)%len(leaves)]
+			} else {
+				pl.focused = leaves[(i+len(leaves)-1)%len(leaves)]
+			}
+			break
+		}
+	}
+	pl.applyFocus()
+	return pl.focused.room
+}
+
+// FocusedRoom returns the room in the focused pane, or nil if the pane is empty.
+func (pl *PaneLayout) FocusedRoom() *RoomView {
+	return pl.focused.room
+}
+
+func (pl *PaneLayout) applyFocus() {
+	for _, leaf := range pl.root.leaves() {
+		if leaf.room == nil || leaf.mirror != nil {
+			continue
+		} else if pl.hasFocus && leaf.room == pl.focused.room {
+			leaf.room.Focus()
+		} else {
+			leaf.room.Blur()
+		}
+	}
+}
+
+func (pl *PaneLayout) Focus() {
+	pl.hasFocus = true
+	pl.applyFocus()
+}
+
+func (pl *PaneLayout) Blur() {
+	pl.hasFocus = false
+	pl.applyFocus()
+}
+
+func (pl *PaneLayout) Draw(screen mauview.Screen) {
+	width, height := screen.Size()
+	pl.draw(pl.root, screen, 0, 0, width, height)
+}
+
+func (pl *PaneLayout) draw(node *paneNode, screen mauview.Screen, x, y, width, height int) {
+	if node.isLeaf() {
+		node.screen = &mauview.ProxyScreen{Parent: screen, OffsetX: x, OffsetY: y, Width: width, Height: height}
+		if node.mirror != nil {
+			pl.drawMirror(node, width, height)
+		} else if node.room != nil {
+			node.room.Draw(node.screen)
+		} else {
+			style := tcell.StyleDefault.Foreground(tcell.ColorGray)
+			if node == pl.focused {
+				style = style.Bold(true)
+			}
+			widget.WriteLine(node.screen, mauview.AlignCenter, "Pick a room to show in this pane", 0, height/2, width, style)
+		}
+		return
+	}
+	size := height
+	if node.sideBySide {
+		size = width
+	}
+	// One cell between each pane is used for the border.
+	count := len(node.children)
+	childSize := (size - count + 1) / count
+	border := widget.NewBorder()
+	for i, child := range node.children {
+		if i == count-1 {
+			childSize = size - (childSize+1)*(count-1)
+		}
+		if node.sideBySide {
+			pl.draw(child, screen, x, y, childSize, height)
+			x += childSize
+			if i < count-1 {
+				border.Draw(&mauview.ProxyScreen{Parent: screen, OffsetX: x, OffsetY: y, Width: 1, Height: height})
+				x++
+			}
+		} else {
+			pl.draw(child, screen, x, y, width, childSize)
+			y += childSize
+			if i < count-1 {
+				border.Draw(&mauview.ProxyScreen{Parent: screen, OffsetX: x, OffsetY: y, Width: width, Height: 1})
+				y++
+			}
+		}
+	}
+}
+
+// drawMirror draws a pane that shows a mirror of the timeline of a room that is shown in another pane.
+func (pl *PaneLayout) drawMirror(node *paneNode, width, height int) {
+	titleStyle := tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorDarkGreen)
+	title := fmt.Sprintf("%s (second view, messages are sent from the other pane)", node.room.Room.GetTitle())
+	widget.WriteLinePadded(node.screen, mauview.AlignLeft, title, 0, 0, width, titleStyle)
+	node.mirrorScreen = &mauview.ProxyScreen{Parent: node.screen, OffsetY: TopicBarHeight, Width: width, Height: height - TopicBarHeight}
+	node.mirror.Draw(node.mirrorScreen)
+}
+
+func (pl *PaneLayout) OnKeyEvent(event mauview.KeyEvent) bool {
+	if mirror := pl.focused.mirror; mirror != nil {
+		switch event.Key() {
+		case tcell.KeyPgUp:
+			if mirror.IsAtTop() {
+				go pl.parent.LoadHistory(pl.focused.room.Room.ID)
+			}
+			mirror.AddScrollOffset(+mirror.Height() / 2)
+			return true
+		case tcell.KeyPgDn:
+			mirror.AddScrollOffset(-mirror.Height() / 2)
+			return true
+		}
+	}
+	if room := pl.focused.room; room != nil {
+		return room.OnKeyEvent(event)
+	}
+	return false
+}
+
+func (pl *PaneLayout) OnPasteEvent(event mauview.PasteEvent) bool {
+	if room := pl.focused.room; room != nil {
+		return room.OnPasteEvent(event)
+	}
+	return false
+}
+
+func (pl *PaneLayout) OnMouseEvent(event mauview.MouseEvent) bool {
+	for _, leaf := range pl.root.leaves() {
+		if leaf.screen == nil || !leaf.screen.IsInArea(event.Position()) {
+			continue
+		}
+		if event.Buttons()&(tcell.Button1|tcell.Button2|tcell.Button3) != 0 && leaf != pl.focused {
+			pl.parent.FocusPane(leaf)
+		}
+		if leaf.mirror != nil {
+			event = leaf.screen.OffsetMouseEvent(event)
+			if leaf.mirrorScreen != nil && leaf.mirrorScreen.IsInArea(event.Position()) {
+				return leaf.mirror.OnMouseEvent(leaf.mirrorScreen.OffsetMouseEvent(event))
+			}
+			return true
+		} else if leaf.room != nil {
+			return leaf.room.OnMouseEvent(leaf.screen.OffsetMouseEvent(event))
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/lib/ansimage"
+	"maunium.net/go/gomuks/ui/messages/tstring"
+	"maunium.net/go/gomuks/ui/widget"
+)
+
+const (
+	pastePreviewWidth  = 60
+	pastePreviewHeight = 20
+)
+
+// PasteClipboardImage uploads the image in the system clipboard to the room,
+// showing a preview with a caption prompt first unless it's been disabled.
+func (view *RoomView) PasteClipboardImage() {
+	defer debug.Recover()
+	data, mimetype, err := readClipboardImage()
+	if err != nil {
+		view.AddServiceMessage(fmt.Sprintf("Failed to paste image: %v", err))
+		view.parent.parent.Render()
+		return
+	}
+	ext := ".png"
+	if exts, _ := mime.ExtensionsByType(mimetype); len(exts) > 0 {
+		ext = exts[0]
+	}
+	dir := filepath.Join(os.TempDir(), "gomuks-paste")
+	path := filepath.Join(dir, fmt.Sprintf("clipboard-%s%s", time.Now().Format("2006-01-02-150405"), ext))
+	if err = os.MkdirAll(dir, 0700); err == nil {
+		err = ioutil.WriteFile(path, data, 0600)
+	}
+	if err != nil {
+		view.AddServiceMessage(fmt.Sprintf("Failed to save pasted image: %v", err))
+		view.parent.parent.Render()
+		return
+	}
+	if view.config.Preferences.DisablePastePreview {
+		view.sendPastedImage(path, "")
+		return
+	}
+	view.parent.ShowModal(NewPastePreviewModal(view.parent, view, path, data))
+	view.parent.parent.Render()
+}
+
+// sendPastedImage uploads the pasted image and sends the caption as a separate message after it.
+func (view *RoomView) sendPastedImage(path, caption string) {
+	defer debug.Recover()
+	defer os.Remove(path)
+	view.SendMessageMedia(path)
+	if len(caption) > 0 {
+		view.SendMessage(event.MsgText, caption)
+	}
+}
+
+// imagePreview draws a pre-rendered image in the middle of the screen.
+type imagePreview struct {
+	mauview.NoopEventHandler
+	lines []tstring.TString
+}
+
+func (preview *imagePreview) Draw(screen mauview.Screen) {
+	width, height := screen.Size()
+	y := (height - len(preview.lines)) / 2
+	for _, line := range preview.lines {
+		line.Draw(screen, (width-line.RuneWidth())/2, y)
+		y++
+	}
+}
+
+func renderPreviewImage(data []byte, width, rows int) ([]tstring.TString, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	// Each row contains two pixels, so a cell is roughly square in pixels.
+	imgWidth, imgHeight := width, 0
+	if cfg.Width > 0 && cfg.Height*width/cfg.Width > rows*2 {
+		imgWidth, imgHeight = 0, rows*2
+	}
+	img, err := ansimage.NewScaledFromReader(bytes.NewReader(data), imgHeight, imgWidth, color.Black)
+	if err != nil {
+		return nil, err
+	}
+	return img.Render(), nil
+}
+
+// PastePreviewModal shows an image pasted from the clipboard and asks for a caption before uploading it.
+type PastePreviewModal struct {
+	mauview.Component
+	parent *MainView
+	room   *RoomView
+	path   string
+
+	container *mauview.Box
+	caption   *mauview.InputField
+}
+
+func NewPastePreviewModal(parent *MainView, room *RoomView, path string, data []byte) *PastePreviewModal {
+	ppm := &PastePreviewModal{
+		parent:  parent,
+		room:    room,
+		path:    path,
+		caption: mauview.NewInputField().SetPlaceholder("Caption (optional)"),
+	}
+	preview := &imagePreview{}
+	lines, err := renderPreviewImage(data, pastePreviewWidth-2, pastePreviewHeight-5)
+	if err != nil {
+		debug.Print("Failed to render pasted image:", err)
+		preview.lines = []tstring.TString{tstring.NewColorTString("Failed to render image preview", tcell.ColorRed)}
+	} else {
+		preview.lines = lines
+	}
+	hint := mauview.NewTextField().SetText("Enter to upload, Esc to cancel")
+	flex := mauview.NewFlex().
+		SetDirection(mauview.FlexRow).
+		AddProportionalComponent(preview, 1).
+		AddFixedComponent(widget.NewBorder(), 1).
+		AddFixedComponent(ppm.caption, 1).
+		AddFixedComponent(hint, 1)
+	flex.SetFocused(ppm.caption)
+	ppm.container = mauview.NewBox(flex).
+		SetBorder(true).
+		SetTitle("Upload pasted image?").
+		SetBlurCaptureFunc(func() bool {
+			ppm.cancel()
+			return true
+		})
+	ppm.Component = mauview.Center(ppm.container, pastePreviewWidth, pastePreviewHeight).SetAlwaysFocusChild(true)
+	return ppm
+}
+
+func (ppm *PastePreviewModal) Focus() {
+	ppm.container.Focus()
+}
+
+func (ppm *PastePreviewModal) Blur() {
+	ppm.container.Blur()
+}
+
+func (ppm *PastePreviewModal) cancel() {
+	ppm.parent.HideModal()
+	_ = os.Remove(ppm.path)
+}
+
+func (ppm *PastePreviewModal) OnKeyEvent(event mauview.KeyEvent) bool {
+	switch event.Key() {
+	case tcell.KeyEscape:
+		ppm.cancel()
+	case tcell.KeyEnter:
+		ppm.parent.HideModal()
+		go ppm.room.sendPastedImage(ppm.path, ppm.caption.GetText())
+	default:
+		return ppm.caption.OnKeyEvent(event)
+	}
+	return true
+}
+
+func (ppm *PastePreviewModal) OnPasteEvent(event mauview.PasteEvent) bool {
+	return ppm.caption.OnPasteEvent(event)
+}
+
+func cmdPasteImage(cmd *Command) {
+	go cmd.Room.PasteClipboardImage()
+}
@@ -0,0 +1,292 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/ui/messages"
+)
+
+// The number of events to fetch around the target of a permalink that isn't in the loaded timeline.
+const permalinkContextSize = 30
+
+var permalinkRegex = regexp.MustCompile(`(?:https?://(?:www\.)?matrix\.to/#/|matrix:)[^\s"'<>]+`)
+
+// Permalink is a parsed matrix.to or matrix: URI pointing at a room or an event in a room.
+type Permalink struct {
+	RoomID  id.RoomID
+	Alias   id.RoomAlias
+	EventID id.EventID
+	Via     []string
+}
+
+var errNotPermalink = errors.New("not a matrix.to or matrix: link to a room or event")
+
+// ParsePermalink parses a matrix.to link or a matrix: URI that points at a room or an event.
+func ParsePermalink(link string) (*Permalink, error) {
+	var path, query string
+	if strings.HasPrefix(link, "matrix:") {
+		path = strings.TrimPrefix(link, "matrix:")
+	} else if match := permalinkRegex.FindString(link); match == link && strings.Contains(link, "matrix.to/#/") {
+		path = link[strings.Index(link, "#/")+2:]
+	} else {
+		return nil, errNotPermalink
+	}
+	if index := strings.IndexRune(path, '?'); index >= 0 {
+		path, query = path[:index], path[index+1:]
+	}
+	var parts []string
+	for _, part := range strings.Split(path, "/") {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, unescaped)
+	}
+
+	var pl Permalink
+	if strings.HasPrefix(link, "matrix:") {
+		// matrix:roomid/room:server/e/event or matrix:r/alias:server/e/event
+		if len(parts) < 2 {
+			return nil, errNotPermalink
+		}
+		switch parts[0] {
+		case "roomid":
+			pl.RoomID = id.RoomID("!" + parts[1])
+		case "r", "room":
+			pl.Alias = id.RoomAlias("#" + parts[1])
+		default:
+			return nil, errNotPermalink
+		}
+		if len(parts) >= 4 && parts[2] == "e" {
+			pl.EventID = id.EventID("$" + parts[3])
+		}
+	} else {
+		// https://matrix.to/#/!room:server/$event or https://matrix.to/#/#alias:server/$event
+		switch {
+		case strings.HasPrefix(parts[0], "!"):
+			pl.RoomID = id.RoomID(parts[0])
+		case strings.HasPrefix(parts[0], "#"):
+			pl.Alias = id.RoomAlias(parts[0])
+		default:
+			return nil, errNotPermalink
+		}
+		if len(parts) >= 2 && strings.HasPrefix(parts[1], "$") {
+			pl.EventID = id.EventID(parts[1])
+		}
+	}
+	if values, err := url.ParseQuery(query); err == nil {
+		pl.Via = values["via"]
+	}
+	return &pl, nil
+}
+
+// FindPermalink returns the first room or event permalink in the given text.
+func FindPermalink(text string) *Permalink {
+	for _, match := range permalinkRegex.FindAllString(text, -1) {
+		// Links in HTML and Markdown are often directly followed by punctuation.
+		match = strings.TrimRight(match, ").,;")
+		if pl, err := ParsePermalink(match); err == nil {
+			return pl
+		}
+	}
+	return nil
+}
+
+// FormatPermalink creates a matrix.to link to the given event.
+func FormatPermalink(roomID id.RoomID, eventID id.EventID, via ...string) string {
+	link := fmt.Sprintf("https://matrix.to/#/%s/%s", url.PathEscape(string(roomID)), url.PathEscape(string(eventID)))
+	if len(via) > 0 {
+		link += "?" + url.Values{"via": via}.Encode()
+	}
+	return link
+}
+
+// permalinkVia returns a few servers that are likely to be in the room, to help other servers find it.
+func (view *RoomView) permalinkVia(sender id.UserID) []string {
+	var via []string
+	add := func(server string) {
+		if len(server) == 0 || len(via) >= 3 {
+			return
+		}
+		for _, existing := range via {
+			if existing == server {
+				return
+			}
+		}
+		via = append(via, server)
+	}
+	_, ownServer, _ := view.config.UserID.Parse()
+	add(ownServer)
+	_, senderServer, _ := sender.Parse()
+	add(senderServer)
+	if index := strings.IndexRune(string(view.Room.ID), ':'); index >= 0 {
+		add(string(view.Room.ID)[index+1:])
+	}
+	return via
+}
+
+// CopyPermalink copies a link to the given message to the clipboard.
+func (view *RoomView) CopyPermalink(message *messages.UIMessage, register string) {
+	if len(message.EventID) == 0 {
+		view.AddServiceMessage("That message hasn't been sent yet")
+		view.parent.parent.Render()
+		return
+	}
+	link := FormatPermalink(view.Room.ID, message.EventID, view.permalinkVia(message.SenderID)...)
+	view.CopyToClipboard(link, register)
+}
+
+// FollowPermalink opens the first permalink in the given message.
+func (view *RoomView) FollowPermalink(message *messages.UIMessage) {
+	var pl *Permalink
+	if message.Event != nil {
+		content := message.Event.Content.AsMessage()
+		pl = FindPermalink(content.FormattedBody)
+		if pl == nil {
+			pl = FindPermalink(content.Body)
+		}
+	}
+	if pl == nil {
+		view.AddServiceMessage("That message doesn't contain a link to a room or message")
+		view.parent.parent.Render()
+		return
+	}
+	view.parent.OpenPermalink(view, pl)
+}
+
+// OpenPermalink switches to the room of the given permalink and jumps to the linked event.
+func (view *MainView) OpenPermalink(from *RoomView, pl *Permalink) {
+	defer debug.Recover()
+	roomID := pl.RoomID
+	if len(roomID) == 0 {
+		resp, err := view.matrix.Client().ResolveAlias(pl.Alias)
+		if err != nil {
+			from.AddServiceMessage(fmt.Sprintf("Failed to resolve %s: %v", pl.Alias, err))
+			view.parent.Render()
+			return
+		}
+		roomID = resp.RoomID
+	}
+	room := view.matrix.GetRoom(roomID)
+	if room == nil || room.HasLeft || (room.SessionMember != nil && room.SessionMember.Membership == "invite") {
+		target := string(roomID)
+		if len(pl.Alias) > 0 {
+			target = string(pl.Alias)
+		}
+		hint := fmt.Sprintf("You're not in %s. Join it with /join %s", target, target)
+		if len(pl.Via) > 0 {
+			hint += " " + pl.Via[0]
+		}
+		from.AddServiceMessage(hint)
+		view.parent.Render()
+		return
+	}
+	view.SwitchRoom("", room)
+	if len(pl.EventID) == 0 {
+		return
+	}
+	roomView, ok := view.getRoomView(room.ID, true)
+	if ok {
+		roomView.GoToEvent(pl.EventID)
+	}
+}
+
+// GoToEvent scrolls to and highlights the given event. If it isn't in the loaded timeline,
+// the events around it are fetched from the server and shown in place of the timeline.
+func (view *RoomView) GoToEvent(eventID id.EventID) {
+	defer debug.Recover()
+	if msg := view.content.getMessageByID(eventID); msg != nil {
+		view.CloseContext()
+		view.content.SetSelected(msg)
+		view.content.ScrollToMessage(msg)
+		view.parent.parent.Render()
+		return
+	}
+	events, err := view.parent.matrix.GetEventContext(view.Room, eventID, permalinkContextSize)
+	if err != nil {
+		view.AddServiceMessage(fmt.Sprintf("Failed to load the linked message: %v", err))
+		view.parent.parent.Render()
+		return
+	}
+	view.OpenContext(eventID, events)
+	view.parent.parent.Render()
+}
+
+// OpenContext replaces the timeline of the room view with the given events around the target event,
+// until the context view is closed with Esc or a message is sent.
+func (view *RoomView) OpenContext(target id.EventID, events []*muksevt.Event) {
+	view.CloseThread()
+	contextView := NewMessageView(view)
+	contextView.contextOf = target
+	for _, evt := range events {
+		if msg := view.parseEvent(evt); msg != nil {
+			contextView.AddMessage(msg, AppendMessage)
+		}
+	}
+	if msg := contextView.getMessageByID(target); msg != nil {
+		contextView.SetSelected(msg)
+		contextView.scrollTo = msg
+	}
+	view.StopSelecting()
+	view.contextView = contextView
+	view.Update()
+}
+
+// CloseContext returns from the permalink context view to the main timeline.
+func (view *RoomView) CloseContext() {
+	if view.contextView == nil {
+		return
+	}
+	view.StopSelecting()
+	view.contextView = nil
+	view.Update()
+}
+
+func cmdGoto(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmd.Room.StartSelecting(SelectGoto, "")
+		return
+	}
+	pl, err := ParsePermalink(cmd.Args[0])
+	if err != nil {
+		cmd.Reply("Usage: /goto [matrix.to link or matrix: URI]: %v", err)
+		return
+	}
+	go cmd.MainView.OpenPermalink(cmd.Room, pl)
+}
+
+func cmdPermalink(cmd *Command) {
+	register := strings.Join(cmd.Args, " ")
+	if len(register) == 0 {
+		register = "clipboard"
+	}
+	if register == "clipboard" || register == "primary" {
+		cmd.Room.StartSelecting(SelectPermalink, register)
+	} else {
+		cmd.Reply("Usage: /permalink [register], where register is either \"clipboard\" or \"primary\".")
+	}
+}
@@ -0,0 +1,225 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/ui/widget"
+)
+
+// PinBanner is a clickable line in a room view that tells the user that the pinned messages of the room have changed.
+type PinBanner struct {
+	parent *RoomView
+	text   string
+}
+
+func NewPinBanner(parent *RoomView) *PinBanner {
+	return &PinBanner{parent: parent}
+}
+
+// Visible returns true if there's an unseen change to the pinned messages.
+func (banner *PinBanner) Visible() bool {
+	return len(banner.text) > 0
+}
+
+func (banner *PinBanner) Dismiss() {
+	banner.text = ""
+}
+
+func (banner *PinBanner) Draw(screen mauview.Screen) {
+	if !banner.Visible() {
+		return
+	}
+	width, _ := screen.Size()
+	style := tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorYellow)
+	widget.WriteLinePadded(screen, mauview.AlignLeft, banner.text, 0, 0, width, style)
+}
+
+func (banner *PinBanner) OnKeyEvent(event mauview.KeyEvent) bool {
+	return false
+}
+
+func (banner *PinBanner) OnPasteEvent(event mauview.PasteEvent) bool {
+	return false
+}
+
+func (banner *PinBanner) OnMouseEvent(event mauview.MouseEvent) bool {
+	if event.Buttons() != tcell.Button1 || event.HasMotion() {
+		return false
+	}
+	banner.parent.ShowPins()
+	return true
+}
+
+// PinsChanged shows a banner about a change to the pinned messages of the room.
+func (view *RoomView) PinsChanged(sender id.UserID, added, removed int) {
+	name := view.config.StripBridgeName(view.Room.GetDisambiguatedDisplayname(sender))
+	var change string
+	switch {
+	case added > 0 && removed > 0:
+		change = "changed the pinned messages"
+	case added == 1:
+		change = "pinned a message"
+	case added > 1:
+		change = fmt.Sprintf("pinned %d messages", added)
+	case removed == 1:
+		change = "unpinned a message"
+	default:
+		change = fmt.Sprintf("unpinned %d messages", removed)
+	}
+	view.pinBanner.text = fmt.Sprintf("📌 %s %s. Click here or use /pins to view.", name, change)
+}
+
+// ShowPins opens the pinned messages panel of the room.
+func (view *RoomView) ShowPins() {
+	view.pinBanner.Dismiss()
+	view.parent.ShowModal(NewPinsModal(view.parent, view))
+}
+
+// SetPinned pins or unpins the given event in the room.
+func (view *RoomView) SetPinned(eventID id.EventID, pin bool) {
+	defer debug.Recover()
+	current := view.Room.PinnedEvents()
+	pinned := make([]id.EventID, 0, len(current)+1)
+	found := false
+	for _, evtID := range current {
+		if evtID == eventID {
+			found = true
+			if !pin {
+				continue
+			}
+		}
+		pinned = append(pinned, evtID)
+	}
+	if pin == found {
+		if pin {
+			view.AddServiceMessage("That message is already pinned")
+		} else {
+			view.AddServiceMessage("That message isn't pinned")
+		}
+		view.parent.parent.Render()
+		return
+	} else if pin {
+		pinned = append(pinned, eventID)
+	}
+	_, err := view.parent.matrix.Client().SendStateEvent(view.Room.ID, muksevt.StatePinnedEvents, "", &muksevt.PinnedEventsContent{Pinned: pinned})
+	if err != nil {
+		view.AddServiceMessage(fmt.Sprintf("Failed to update pinned messages: %v", err))
+		view.parent.parent.Render()
+	}
+}
+
+// PinsModal is a modal that lists the pinned messages of a room.
+type PinsModal struct {
+	mauview.FocusableComponent
+	parent *MainView
+
+	box  *mauview.Box
+	text *mauview.TextView
+}
+
+func NewPinsModal(parent *MainView, room *RoomView) *PinsModal {
+	pm := &PinsModal{parent: parent}
+
+	pm.text = mauview.NewTextView().
+		SetScrollable(true).
+		SetWrap(true)
+	pm.text.SetText("Loading pinned messages...")
+
+	pm.box = mauview.NewBox(pm.text).
+		SetBorder(true).
+		SetTitle(fmt.Sprintf("Pinned messages in %s", room.Room.GetTitle())).
+		SetBlurCaptureFunc(func() bool {
+			pm.parent.HideModal()
+			return true
+		})
+	pm.box.Focus()
+
+	pm.FocusableComponent = mauview.FractionalCenter(pm.box, 60, 20, 0.75, 0.75)
+
+	go pm.load(room)
+
+	return pm
+}
+
+func (pm *PinsModal) load(room *RoomView) {
+	defer debug.Recover()
+	pinned := room.Room.PinnedEvents()
+	if len(pinned) == 0 {
+		pm.text.SetText("There are no pinned messages in this room.")
+		pm.parent.parent.Render()
+		return
+	}
+	var buf strings.Builder
+	// Show the most recently pinned message first.
+	for i := len(pinned) - 1; i >= 0; i-- {
+		evt, err := pm.parent.matrix.GetEvent(room.Room, pinned[i])
+		if err != nil {
+			_, _ = fmt.Fprintf(&buf, "%d. Failed to load %s: %v\n\n", i+1, pinned[i], err)
+			continue
+		}
+		sender := pm.parent.config.StripBridgeName(room.Room.GetDisambiguatedDisplayname(evt.Sender))
+		body := evt.Content.AsMessage().Body
+		if len(body) == 0 {
+			body = fmt.Sprintf("(%s event)", evt.Type.Type)
+		}
+		_, _ = fmt.Fprintf(&buf, "%d. %s, %s\n%s\n\n", i+1, sender, formatEventTime(evt.Timestamp), body)
+	}
+	buf.WriteString("Use /unpin <number> to unpin a message.")
+	pm.text.SetText(buf.String())
+	pm.parent.parent.Render()
+}
+
+func (pm *PinsModal) OnKeyEvent(event mauview.KeyEvent) bool {
+	if event.Key() == tcell.KeyEscape || event.Rune() == 'q' {
+		pm.parent.HideModal()
+		return true
+	}
+	return pm.FocusableComponent.OnKeyEvent(event)
+}
+
+func cmdPin(cmd *Command) {
+	cmd.Room.StartSelecting(SelectPin, "")
+}
+
+func cmdUnpin(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmd.Room.StartSelecting(SelectUnpin, "")
+		return
+	}
+	pinned := cmd.Room.MxRoom().PinnedEvents()
+	index, err := strconv.Atoi(cmd.Args[0])
+	if err != nil || index < 1 || index > len(pinned) {
+		cmd.Reply("Usage: /unpin [number from /pins]")
+		return
+	}
+	cmd.Room.SetPinned(pinned[index-1], false)
+}
+
+func cmdPins(cmd *Command) {
+	cmd.Room.ShowPins()
+}
@@ -0,0 +1,186 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/ui/messages"
+)
+
+const maxPollAnswers = 20
+
+func parsePollCommand(args []string) (*muksevt.PollStartContent, error) {
+	content := &muksevt.PollStartContent{}
+	content.Poll.Kind = muksevt.PollKindDisclosed
+	content.Poll.MaxSelections = 1
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		switch args[0] {
+		case "--multiple":
+			if len(args) < 2 {
+				return nil, fmt.Errorf("--multiple requires a value")
+			}
+			var err error
+			content.Poll.MaxSelections, err = strconv.Atoi(args[1])
+			if err != nil || content.Poll.MaxSelections < 1 {
+				return nil, fmt.Errorf("invalid number of selections %q", args[1])
+			}
+			args = args[2:]
+		case "--undisclosed":
+			content.Poll.Kind = muksevt.PollKindUndisclosed
+			args = args[1:]
+		default:
+			return nil, fmt.Errorf("unknown flag %s", args[0])
+		}
+	}
+	parts := strings.Split(strings.Join(args, " "), "|")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	if len(parts) < 3 || len(parts[0]) == 0 {
+		return nil, fmt.Errorf("a poll needs a question and at least two answers")
+	} else if len(parts)-1 > maxPollAnswers {
+		return nil, fmt.Errorf("a poll can have at most %d answers", maxPollAnswers)
+	}
+	content.Poll.Question.Text = parts[0]
+	fallback := []string{parts[0]}
+	for i, answer := range parts[1:] {
+		if len(answer) == 0 {
+			return nil, fmt.Errorf("answer %d is empty", i+1)
+		}
+		content.Poll.Answers = append(content.Poll.Answers, muksevt.PollAnswer{
+			ID:   strconv.Itoa(i + 1),
+			Text: answer,
+		})
+		fallback = append(fallback, fmt.Sprintf("%d. %s", i+1, answer))
+	}
+	if content.Poll.MaxSelections > len(content.Poll.Answers) {
+		content.Poll.MaxSelections = len(content.Poll.Answers)
+	}
+	content.Text = strings.Join(fallback, "\n")
+	content.Body = content.Text
+	return content, nil
+}
+
+// sendPollEvent sends a poll event without a local echo, like reactions.
+func (view *RoomView) sendPollEvent(evtType event.Type, content interface{}) error {
+	_, err := view.parent.matrix.SendEvent(&muksevt.Event{
+		Event: &event.Event{
+			Type:    evtType,
+			RoomID:  view.Room.ID,
+			Content: event.Content{Parsed: content},
+		},
+	})
+	return err
+}
+
+// Vote sends a response to the given poll with the answers at the given 1-based indexes.
+func (view *RoomView) Vote(message *messages.UIMessage, choices string) {
+	defer debug.Recover()
+	poll, ok := message.Event.Content.Parsed.(*muksevt.PollStartContent)
+	if !ok {
+		view.AddServiceMessage("That message isn't a poll")
+		view.parent.parent.Render()
+		return
+	} else if state := message.Event.Gomuks.Poll; state != nil && state.Ended {
+		view.AddServiceMessage("That poll has already ended")
+		view.parent.parent.Render()
+		return
+	}
+	answers := []string{}
+	for _, choice := range strings.Fields(choices) {
+		index, err := strconv.Atoi(choice)
+		if err != nil || index < 1 || index > len(poll.Poll.Answers) {
+			view.AddServiceMessage(fmt.Sprintf("Invalid answer number %q", choice))
+			view.parent.parent.Render()
+			return
+		}
+		answers = append(answers, poll.Poll.Answers[index-1].ID)
+	}
+	if len(answers) > poll.GetMaxSelections() {
+		view.AddServiceMessage(fmt.Sprintf("That poll only allows choosing %d answer(s)", poll.GetMaxSelections()))
+		view.parent.parent.Render()
+		return
+	}
+	debug.Print("Voting for", answers, "in poll", message.EventID, "in", view.Room.ID)
+	err := view.sendPollEvent(muksevt.EventPollResponse, &muksevt.PollResponseContent{
+		RelatesTo: event.RelatesTo{Type: event.RelReference, EventID: message.EventID},
+		Response:  muksevt.PollResponse{Answers: answers},
+	})
+	if err != nil {
+		view.AddServiceMessage(fmt.Sprintf("Failed to send vote: %s", niceError(err)))
+		view.parent.parent.Render()
+	}
+}
+
+// EndPoll closes the given poll so that no more votes are counted.
+func (view *RoomView) EndPoll(message *messages.UIMessage) {
+	defer debug.Recover()
+	if _, ok := message.Event.Content.Parsed.(*muksevt.PollStartContent); !ok {
+		view.AddServiceMessage("That message isn't a poll")
+		view.parent.parent.Render()
+		return
+	} else if message.Event.Sender != view.config.UserID {
+		view.AddServiceMessage("You can only end polls you started")
+		view.parent.parent.Render()
+		return
+	}
+	err := view.sendPollEvent(muksevt.EventPollEnd, &muksevt.PollEndContent{
+		RelatesTo: event.RelatesTo{Type: event.RelReference, EventID: message.EventID},
+		Text:      "Ended poll",
+		Body:      "Ended poll",
+	})
+	if err != nil {
+		view.AddServiceMessage(fmt.Sprintf("Failed to end poll: %s", niceError(err)))
+		view.parent.parent.Render()
+	}
+}
+
+func cmdPoll(cmd *Command) {
+	content, err := parsePollCommand(cmd.Args)
+	if err != nil {
+		cmd.Reply("%v", err)
+		cmd.Reply("Usage: /poll [--multiple <n>] [--undisclosed] <question> | <answer> | <answer> [| ...]")
+		return
+	}
+	err = cmd.Room.sendPollEvent(muksevt.EventPollStart, content)
+	if err != nil {
+		cmd.Reply("Failed to create poll: %s", niceError(err))
+	}
+}
+
+func cmdVote(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmd.Reply("Usage: /vote <answer number> [answer number...]")
+		return
+	}
+	cmd.Room.StartSelecting(SelectVote, strings.Join(cmd.Args, " "))
+}
+
+func cmdUnvote(cmd *Command) {
+	cmd.Room.StartSelecting(SelectVote, "")
+}
+
+func cmdEndPoll(cmd *Command) {
+	cmd.Room.StartSelecting(SelectEndPoll, "")
+}
@@ -0,0 +1,661 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/rooms"
+	"maunium.net/go/gomuks/ui/widget"
+)
+
+// defaultOpLevel is the power level /op gives when no level is specified.
+const defaultOpLevel = 50
+
+var powerLevelDefaults = []struct {
+	key   string
+	label string
+}{
+	{"users_default", "Default user level"},
+	{"events_default", "Send messages"},
+	{"state_default", "Change settings"},
+	{"invite", "Invite users"},
+	{"kick", "Kick users"},
+	{"ban", "Ban users"},
+	{"redact", "Redact others' messages"},
+}
+
+func getDefaultLevel(pl *event.PowerLevelsEventContent, key string) int {
+	switch key {
+	case "users_default":
+		return pl.UsersDefault
+	case "events_default":
+		return pl.EventsDefault
+	case "state_default":
+		return pl.StateDefault()
+	case "invite":
+		return pl.Invite()
+	case "kick":
+		return pl.Kick()
+	case "ban":
+		return pl.Ban()
+	case "redact":
+		return pl.Redact()
+	}
+	return 0
+}
+
+func setDefaultLevel(pl *event.PowerLevelsEventContent, key string, level int) {
+	switch key {
+	case "users_default":
+		pl.UsersDefault = level
+	case "events_default":
+		pl.EventsDefault = level
+	case "state_default":
+		pl.StateDefaultPtr = &level
+	case "invite":
+		pl.InvitePtr = &level
+	case "kick":
+		pl.KickPtr = &level
+	case "ban":
+		pl.BanPtr = &level
+	case "redact":
+		pl.RedactPtr = &level
+	}
+}
+
+// powerLevelsDraft is a modifiable copy of the power levels of a room. Changes are validated against the
+// levels the room had when the draft was created, the same way the server validates them.
+type powerLevelsDraft struct {
+	// raw is the original content, which is sent back with the changes to keep fields gomuks doesn't know about.
+	raw    map[string]interface{}
+	orig   *event.PowerLevelsEventContent
+	levels *event.PowerLevelsEventContent
+
+	userID   id.UserID
+	own      int
+	required int
+}
+
+func parsePowerLevels(data []byte) (raw map[string]interface{}, levels *event.PowerLevelsEventContent, err error) {
+	levels = &event.PowerLevelsEventContent{}
+	if err = json.Unmarshal(data, &raw); err != nil {
+		return
+	} else if err = json.Unmarshal(data, levels); err != nil {
+		return
+	}
+	if levels.Users == nil {
+		levels.Users = make(map[id.UserID]int)
+	}
+	if levels.Events == nil {
+		levels.Events = make(map[string]int)
+	}
+	return
+}
+
+// loadPowerLevels creates a draft of the current power levels of the given room.
+func loadPowerLevels(room *rooms.Room, userID id.UserID) (*powerLevelsDraft, error) {
+	data := []byte("{}")
+	if evt := room.GetStateEvent(event.StatePowerLevels, ""); evt != nil {
+		var err error
+		data, err = json.Marshal(&evt.Content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	draft := &powerLevelsDraft{userID: userID}
+	var err error
+	if draft.raw, draft.orig, err = parsePowerLevels(data); err != nil {
+		return nil, err
+	} else if _, draft.levels, err = parsePowerLevels(data); err != nil {
+		return nil, err
+	}
+	draft.own = draft.orig.GetUserLevel(userID)
+	draft.required = draft.orig.GetEventLevel(event.StatePowerLevels)
+	return draft, nil
+}
+
+// check returns an error if the user isn't allowed to change a level from old to new.
+// A nil old or new level means that the level is being added or removed.
+func (draft *powerLevelsDraft) check(old, new *int) error {
+	if draft.own < draft.required {
+		return fmt.Errorf("changing power levels requires level %d, but you only have %d", draft.required, draft.own)
+	} else if old != nil && *old > draft.own {
+		return fmt.Errorf("the current level %d is higher than your level %d", *old, draft.own)
+	} else if new != nil && *new > draft.own {
+		return fmt.Errorf("you can't set a level higher than your own level %d", draft.own)
+	}
+	return nil
+}
+
+func (draft *powerLevelsDraft) SetDefault(key string, level int) error {
+	old := getDefaultLevel(draft.orig, key)
+	if err := draft.check(&old, &level); err != nil {
+		return err
+	}
+	setDefaultLevel(draft.levels, key, level)
+	return nil
+}
+
+// SetEvent changes the level required to send the given event type, or removes it if level is nil.
+func (draft *powerLevelsDraft) SetEvent(eventType string, level *int) error {
+	var old *int
+	if level, ok := draft.orig.Events[eventType]; ok {
+		old = &level
+	}
+	if err := draft.check(old, level); err != nil {
+		return err
+	}
+	if level == nil {
+		delete(draft.levels.Events, eventType)
+	} else {
+		draft.levels.Events[eventType] = *level
+	}
+	return nil
+}
+
+// SetUser changes the level of the given user, or resets it to the default if level is nil.
+func (draft *powerLevelsDraft) SetUser(userID id.UserID, level *int) error {
+	var old *int
+	if level, ok := draft.orig.Users[userID]; ok {
+		old = &level
+		if userID != draft.userID && level >= draft.own {
+			return fmt.Errorf("%s has the same or a higher level than you", userID)
+		}
+	}
+	if err := draft.check(old, level); err != nil {
+		return err
+	}
+	if level == nil {
+		delete(draft.levels.Users, userID)
+	} else {
+		draft.levels.Users[userID] = *level
+	}
+	return nil
+}
+
+// LowersOwnLevel returns the level the draft would give to the user editing it
+// and whether that's lower than their current level.
+func (draft *powerLevelsDraft) LowersOwnLevel() (int, bool) {
+	level := draft.levels.GetUserLevel(draft.userID)
+	return level, level < draft.own
+}
+
+// confirmOwnLevel asks the user whether they really want to lower their own level,
+// as they may not be able to get it back. Returns true if the draft doesn't lower it.
+func (draft *powerLevelsDraft) confirmOwnLevel(view *MainView) bool {
+	level, lowered := draft.LowersOwnLevel()
+	if !lowered {
+		return true
+	}
+	text := fmt.Sprintf("This will lower your own power level from %d to %d. "+
+		"You may not be able to raise it again.\n\nContinue?", draft.own, level)
+	return view.AskConfirmation("Lower your own power level", text, 0)
+}
+
+// Send sends the changed power levels to the room and makes them the base for further changes.
+func (draft *powerLevelsDraft) Send(client *mautrix.Client, roomID id.RoomID) error {
+	content := make(map[string]interface{}, len(draft.raw)+9)
+	for key, value := range draft.raw {
+		content[key] = value
+	}
+	content["users"] = draft.levels.Users
+	content["events"] = draft.levels.Events
+	for _, def := range powerLevelDefaults {
+		content[def.key] = getDefaultLevel(draft.levels, def.key)
+	}
+	_, err := client.SendStateEvent(roomID, event.StatePowerLevels, "", content)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	if draft.raw, draft.orig, err = parsePowerLevels(data); err != nil {
+		return err
+	}
+	draft.own = draft.orig.GetUserLevel(draft.userID)
+	draft.required = draft.orig.GetEventLevel(event.StatePowerLevels)
+	return nil
+}
+
+type powerLevelRowKind int
+
+const (
+	powerLevelHeader powerLevelRowKind = iota
+	powerLevelDefault
+	powerLevelEvent
+	powerLevelUser
+)
+
+type powerLevelRow struct {
+	kind  powerLevelRowKind
+	key   string
+	label string
+	level int
+}
+
+// PowerLevelEditor is a modal for changing the user levels, event type requirements
+// and default levels in the m.room.power_levels event of a room.
+type PowerLevelEditor struct {
+	mauview.FocusableComponent
+	parent *MainView
+	room   *RoomView
+	draft  *powerLevelsDraft
+
+	rows         []powerLevelRow
+	selected     int
+	scrollOffset int
+
+	input   *mauview.InputField
+	editing bool
+	adding  bool
+
+	changed        bool
+	confirmDiscard bool
+	status         string
+}
+
+func NewPowerLevelEditor(parent *MainView, room *RoomView, draft *powerLevelsDraft) *PowerLevelEditor {
+	ple := &PowerLevelEditor{
+		parent: parent,
+		room:   room,
+		draft:  draft,
+		input:  mauview.NewInputField(),
+	}
+
+	box := mauview.NewBox(&powerLevelContent{editor: ple}).
+		SetBorder(true).
+		SetTitle("Power levels").
+		SetBlurCaptureFunc(func() bool {
+			ple.parent.HideModal()
+			return true
+		})
+	box.Focus()
+
+	ple.FocusableComponent = mauview.FractionalCenter(box, 64, 24, 0.5, 0.4)
+	ple.updateRows()
+	ple.selectNext(1)
+
+	return ple
+}
+
+func (ple *PowerLevelEditor) updateRows() {
+	levels := ple.draft.levels
+	rows := []powerLevelRow{{kind: powerLevelHeader, label: "Defaults"}}
+	for _, def := range powerLevelDefaults {
+		rows = append(rows, powerLevelRow{powerLevelDefault, def.key, def.label, getDefaultLevel(levels, def.key)})
+	}
+
+	rows = append(rows, powerLevelRow{kind: powerLevelHeader, label: "Event types"})
+	eventTypes := make([]string, 0, len(levels.Events))
+	for eventType := range levels.Events {
+		eventTypes = append(eventTypes, eventType)
+	}
+	sort.Strings(eventTypes)
+	for _, eventType := range eventTypes {
+		rows = append(rows, powerLevelRow{powerLevelEvent, eventType, eventType, levels.Events[eventType]})
+	}
+
+	rows = append(rows, powerLevelRow{kind: powerLevelHeader, label: "Users"})
+	users := make([]id.UserID, 0, len(levels.Users))
+	for userID := range levels.Users {
+		users = append(users, userID)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if levels.Users[users[i]] != levels.Users[users[j]] {
+			return levels.Users[users[i]] > levels.Users[users[j]]
+		}
+		return users[i] < users[j]
+	})
+	for _, userID := range users {
+		label := string(userID)
+		if member := ple.room.Room.GetMember(userID); member != nil && len(member.Displayname) > 0 {
+			label = fmt.Sprintf("%s (%s)", member.Displayname, userID)
+		}
+		rows = append(rows, powerLevelRow{powerLevelUser, string(userID), label, levels.Users[userID]})
+	}
+
+	ple.rows = rows
+	if ple.selected >= len(rows) {
+		ple.selected = len(rows) - 1
+	}
+}
+
+// selectNext moves the selection in the given direction, skipping headers.
+func (ple *PowerLevelEditor) selectNext(direction int) {
+	for i := ple.selected + direction; i >= 0 && i < len(ple.rows); i += direction {
+		if ple.rows[i].kind != powerLevelHeader {
+			ple.selected = i
+			return
+		}
+	}
+}
+
+func (ple *PowerLevelEditor) selectedRow() powerLevelRow {
+	return ple.rows[ple.selected]
+}
+
+func (ple *PowerLevelEditor) markChanged() {
+	ple.changed = true
+	ple.confirmDiscard = false
+	ple.updateRows()
+}
+
+func (ple *PowerLevelEditor) startEditing(adding bool) {
+	ple.status = ""
+	ple.adding = adding
+	ple.editing = true
+	if adding {
+		ple.input.SetPlaceholder("user ID or event type, then level")
+		ple.input.SetTextAndMoveCursor("")
+	} else {
+		ple.input.SetPlaceholder("")
+		ple.input.SetTextAndMoveCursor(strconv.Itoa(ple.selectedRow().level))
+	}
+	ple.input.Focus()
+}
+
+func (ple *PowerLevelEditor) stopEditing() {
+	ple.input.Blur()
+	ple.editing = false
+	ple.adding = false
+}
+
+func (ple *PowerLevelEditor) submitEdit() {
+	text := strings.TrimSpace(ple.input.GetText())
+	adding := ple.adding
+	ple.stopEditing()
+	var err error
+	if adding {
+		err = ple.add(text)
+	} else {
+		err = ple.set(ple.selectedRow(), text)
+	}
+	if err != nil {
+		ple.status = err.Error()
+	} else {
+		ple.markChanged()
+	}
+}
+
+func (ple *PowerLevelEditor) set(row powerLevelRow, text string) error {
+	level, err := strconv.Atoi(text)
+	if err != nil {
+		return fmt.Errorf("%q isn't a valid power level", text)
+	}
+	switch row.kind {
+	case powerLevelDefault:
+		return ple.draft.SetDefault(row.key, level)
+	case powerLevelEvent:
+		return ple.draft.SetEvent(row.key, &level)
+	case powerLevelUser:
+		return ple.draft.SetUser(id.UserID(row.key), &level)
+	}
+	return nil
+}
+
+func (ple *PowerLevelEditor) add(text string) error {
+	parts := strings.Fields(text)
+	if len(parts) != 2 {
+		return fmt.Errorf("enter a user ID or event type and a level separated by a space")
+	}
+	level, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return fmt.Errorf("%q isn't a valid power level", parts[1])
+	}
+	if strings.HasPrefix(parts[0], "@") {
+		userID := id.UserID(parts[0])
+		if _, _, err = userID.Parse(); err != nil {
+			return fmt.Errorf("%s isn't a valid user ID", userID)
+		}
+		return ple.draft.SetUser(userID, &level)
+	}
+	return ple.draft.SetEvent(parts[0], &level)
+}
+
+func (ple *PowerLevelEditor) remove(row powerLevelRow) {
+	var err error
+	switch row.kind {
+	case powerLevelDefault:
+		err = fmt.Errorf("default levels can't be removed")
+	case powerLevelEvent:
+		err = ple.draft.SetEvent(row.key, nil)
+	case powerLevelUser:
+		err = ple.draft.SetUser(id.UserID(row.key), nil)
+	}
+	if err != nil {
+		ple.status = err.Error()
+	} else {
+		ple.markChanged()
+	}
+}
+
+func (ple *PowerLevelEditor) save() {
+	ple.status = "Saving..."
+	go func() {
+		defer debug.Recover()
+		if _, lowered := ple.draft.LowersOwnLevel(); lowered {
+			// The confirmation replaces the editor modal, so bring it back afterwards.
+			confirmed := ple.draft.confirmOwnLevel(ple.parent)
+			ple.parent.ShowModal(ple)
+			if !confirmed {
+				ple.status = "Cancelled saving"
+				ple.parent.parent.Render()
+				return
+			}
+		}
+		err := ple.draft.Send(ple.parent.matrix.Client(), ple.room.Room.ID)
+		if err != nil {
+			ple.status = fmt.Sprintf("Failed to save power levels: %s", niceError(err))
+		} else {
+			ple.status = "Saved"
+			ple.changed = false
+			ple.updateRows()
+		}
+		ple.parent.parent.Render()
+	}()
+}
+
+func (ple *PowerLevelEditor) close() {
+	if ple.changed && !ple.confirmDiscard {
+		ple.confirmDiscard = true
+		ple.status = "Unsaved changes, press s to save or Esc again to discard them"
+		return
+	}
+	ple.parent.HideModal()
+}
+
+func (ple *PowerLevelEditor) OnKeyEvent(event mauview.KeyEvent) bool {
+	if ple.editing {
+		switch event.Key() {
+		case tcell.KeyEscape:
+			ple.stopEditing()
+		case tcell.KeyEnter:
+			ple.submitEdit()
+		default:
+			return ple.input.OnKeyEvent(event)
+		}
+		return true
+	}
+	switch {
+	case event.Key() == tcell.KeyEscape || event.Rune() == 'q':
+		ple.close()
+	case event.Key() == tcell.KeyUp || event.Rune() == 'k':
+		ple.selectNext(-1)
+	case event.Key() == tcell.KeyDown || event.Rune() == 'j':
+		ple.selectNext(1)
+	case event.Key() == tcell.KeyEnter || event.Rune() == 'e':
+		ple.startEditing(false)
+	case event.Rune() == 'a':
+		ple.startEditing(true)
+	case event.Key() == tcell.KeyDelete || event.Rune() == 'd':
+		ple.remove(ple.selectedRow())
+	case event.Rune() == 's':
+		if ple.changed {
+			ple.save()
+		} else {
+			ple.status = "No changes to save"
+		}
+	default:
+		return ple.FocusableComponent.OnKeyEvent(event)
+	}
+	return true
+}
+
+func (ple *PowerLevelEditor) OnPasteEvent(event mauview.PasteEvent) bool {
+	if ple.editing {
+		return ple.input.OnPasteEvent(event)
+	}
+	return false
+}
+
+// powerLevelContent draws the inside of the power level editor box.
+type powerLevelContent struct {
+	mauview.NoopEventHandler
+	editor *PowerLevelEditor
+}
+
+func (content *powerLevelContent) Draw(screen mauview.Screen) {
+	ple := content.editor
+	width, height := screen.Size()
+	levelWidth := 8
+	labelWidth := width - levelWidth - 1
+
+	ownLevel := fmt.Sprintf("Your level: %d", ple.draft.own)
+	if ple.draft.own < ple.draft.required {
+		ownLevel += fmt.Sprintf(" (changing power levels requires %d)", ple.draft.required)
+	}
+	widget.WriteLineColor(screen, mauview.AlignLeft, ownLevel, 0, 0, width, tcell.ColorGray)
+
+	listHeight := height - 4
+	if ple.selected < ple.scrollOffset {
+		ple.scrollOffset = ple.selected
+		// Keep the header of the first section visible when scrolling back to the top.
+		if ple.scrollOffset == 1 {
+			ple.scrollOffset = 0
+		}
+	} else if ple.selected >= ple.scrollOffset+listHeight {
+		ple.scrollOffset = ple.selected - listHeight + 1
+	}
+	for y := 0; y < listHeight && y+ple.scrollOffset < len(ple.rows); y++ {
+		index := y + ple.scrollOffset
+		row := ple.rows[index]
+		rowY := y + 2
+		if row.kind == powerLevelHeader {
+			widget.WriteLine(screen, mauview.AlignLeft, row.label, 0, rowY, width, tcell.StyleDefault.Bold(true).Underline(true))
+			continue
+		}
+		style := tcell.StyleDefault
+		if index == ple.selected {
+			style = style.Reverse(true)
+		}
+		widget.WriteLine(screen, mauview.AlignLeft, row.label, 2, rowY, labelWidth-2, style)
+		if index == ple.selected && ple.editing && !ple.adding {
+			ple.input.Draw(mauview.NewProxyScreen(screen, labelWidth+1, rowY, levelWidth, 1))
+		} else {
+			widget.WriteLine(screen, mauview.AlignRight, strconv.Itoa(row.level), labelWidth+1, rowY, levelWidth, style)
+		}
+	}
+
+	if ple.adding {
+		ple.input.Draw(mauview.NewProxyScreen(screen, 0, height-2, width, 1))
+	} else if len(ple.status) > 0 {
+		widget.WriteLineColor(screen, mauview.AlignLeft, ple.status, 0, height-2, width, tcell.ColorYellow)
+	}
+	hint := "Enter: edit, a: add, d: remove, s: save, Esc: close"
+	if ple.editing {
+		hint = "Enter: apply, Esc: cancel"
+	}
+	if ple.changed {
+		hint = "[unsaved] " + hint
+	}
+	widget.WriteLineColor(screen, mauview.AlignLeft, hint, 0, height-1, width, tcell.ColorGray)
+}
+
+func cmdPowerLevels(cmd *Command) {
+	draft, err := loadPowerLevels(cmd.Room.Room, cmd.Config.UserID)
+	if err != nil {
+		cmd.Reply("Failed to parse power levels: %v", err)
+		return
+	}
+	cmd.MainView.ShowModal(NewPowerLevelEditor(cmd.MainView, cmd.Room, draft))
+}
+
+// setUserPowerLevel changes the power level of a single user, or resets it to the default if level is nil.
+func setUserPowerLevel(cmd *Command, userID id.UserID, level *int) {
+	if _, _, err := userID.Parse(); err != nil {
+		cmd.Reply("%s isn't a valid user ID", userID)
+		return
+	}
+	draft, err := loadPowerLevels(cmd.Room.Room, cmd.Config.UserID)
+	if err != nil {
+		cmd.Reply("Failed to parse power levels: %v", err)
+		return
+	} else if err = draft.SetUser(userID, level); err != nil {
+		cmd.Reply("Can't change the power level of %s: %v", userID, err)
+		return
+	} else if !draft.confirmOwnLevel(cmd.MainView) {
+		cmd.Reply("Cancelled changing your own power level")
+		return
+	} else if err = draft.Send(cmd.Matrix.Client(), cmd.Room.Room.ID); err != nil {
+		cmd.Reply("Failed to change the power level of %s: %v", userID, niceError(err))
+		return
+	}
+	if level == nil {
+		cmd.Reply("Reset the power level of %s to the default (%d)", userID, draft.levels.UsersDefault)
+	} else {
+		cmd.Reply("Set the power level of %s to %d", userID, *level)
+	}
+}
+
+func cmdOp(cmd *Command) {
+	if len(cmd.Args) < 1 || len(cmd.Args) > 2 {
+		cmd.Reply("Usage: /op <user ID> [level]")
+		return
+	}
+	level := defaultOpLevel
+	if len(cmd.Args) == 2 {
+		var err error
+		level, err = strconv.Atoi(cmd.Args[1])
+		if err != nil {
+			cmd.Reply("Usage: /op <user ID> [level]")
+			return
+		}
+	}
+	setUserPowerLevel(cmd, id.UserID(cmd.Args[0]), &level)
+}
+
+func cmdDeop(cmd *Command) {
+	if len(cmd.Args) != 1 {
+		cmd.Reply("Usage: /deop <user ID>")
+		return
+	}
+	setUserPowerLevel(cmd, id.UserID(cmd.Args[0]), nil)
+}
@@ -0,0 +1,362 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	sync "github.com/sasha-s/go-deadlock"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/rooms"
+	"maunium.net/go/gomuks/ui/messages/tstring"
+	"maunium.net/go/gomuks/ui/widget"
+)
+
+const (
+	profileWidth         = 60
+	profileHeight        = 20
+	profileAvatarWidth   = 10
+	profileAvatarHeight  = 5
+	profileActionsHeight = 2
+)
+
+// ProfileModal is a modal that shows info about a user and actions for interacting with them.
+type ProfileModal struct {
+	mauview.FocusableComponent
+	parent *MainView
+	room   *rooms.Room
+	userID id.UserID
+
+	displayname string
+	avatarURL   id.ContentURI
+	membership  event.Membership
+	powerLevel  *int
+
+	lock        sync.Mutex
+	loaded      bool
+	avatar      []tstring.TString
+	devices     string
+	sharedRooms []string
+	ignored     bool
+	status      string
+}
+
+func NewProfileModal(parent *MainView, room *rooms.Room, userID id.UserID) *ProfileModal {
+	pm := &ProfileModal{
+		parent:      parent,
+		room:        room,
+		userID:      userID,
+		displayname: string(userID),
+	}
+	if member := room.GetMember(userID); member != nil {
+		if len(member.Displayname) > 0 {
+			pm.displayname = member.Displayname
+		}
+		pm.membership = member.Membership
+		pm.avatarURL = member.AvatarURL.ParseOrIgnore()
+	}
+	if plEvent := room.GetStateEvent(event.StatePowerLevels, ""); plEvent != nil {
+		level := plEvent.Content.AsPowerLevels().GetUserLevel(userID)
+		pm.powerLevel = &level
+	}
+
+	box := mauview.NewBox(&profileContent{modal: pm}).
+		SetBorder(true).
+		SetTitle("Profile").
+		SetBlurCaptureFunc(func() bool {
+			pm.parent.HideModal()
+			return true
+		})
+	box.Focus()
+
+	pm.FocusableComponent = mauview.FractionalCenter(box, profileWidth, profileHeight, 0.5, 0.4)
+
+	go pm.load()
+
+	return pm
+}
+
+func (pm *ProfileModal) isSelf() bool {
+	return pm.userID == pm.parent.config.UserID
+}
+
+func (pm *ProfileModal) showAvatar() bool {
+	prefs := pm.parent.config.Preferences
+	return !pm.avatarURL.IsEmpty() && !prefs.DisableImages && !prefs.DisableDownloads
+}
+
+// load fetches the parts of the profile that may require network requests or loading other rooms.
+func (pm *ProfileModal) load() {
+	defer debug.Recover()
+	var avatar []tstring.TString
+	if pm.showAvatar() {
+		avatar = renderAvatar(pm.parent.matrix, pm.avatarURL, profileAvatarWidth, profileAvatarHeight)
+	}
+	devices := describeUserDevices(pm.parent.matrix, pm.userID)
+	var sharedRooms []string
+	if !pm.isSelf() {
+		for _, roomID := range pm.parent.config.Rooms.FindCommonRooms(pm.userID) {
+			if room := pm.parent.matrix.GetRoom(roomID); room != nil && !room.HasLeft {
+				sharedRooms = append(sharedRooms, room.GetTitle())
+			}
+		}
+		sort.Slice(sharedRooms, func(i, j int) bool {
+			return strings.ToLower(sharedRooms[i]) < strings.ToLower(sharedRooms[j])
+		})
+	}
+	var ignoredList event.IgnoredUserListEventContent
+	err := pm.parent.matrix.Client().GetAccountData(event.AccountDataIgnoredUserList.Type, &ignoredList)
+	if err != nil {
+		debug.Print("Failed to get ignored user list:", err)
+	}
+	_, ignored := ignoredList.IgnoredUsers[pm.userID]
+
+	pm.lock.Lock()
+	pm.loaded = true
+	pm.avatar = avatar
+	pm.devices = devices
+	pm.sharedRooms = sharedRooms
+	pm.ignored = ignored
+	pm.lock.Unlock()
+	pm.parent.parent.Render()
+}
+
+func (pm *ProfileModal) setStatus(status string) {
+	pm.lock.Lock()
+	pm.status = status
+	pm.lock.Unlock()
+	pm.parent.parent.Render()
+}
+
+// ToggleIgnore adds the user to or removes them from the ignored user list.
+func (pm *ProfileModal) ToggleIgnore() {
+	defer debug.Recover()
+	pm.lock.Lock()
+	ignore := !pm.ignored
+	pm.lock.Unlock()
+	var err error
+	if ignore {
+		_, err = addIgnoredUsers(pm.parent.matrix.Client(), []id.UserID{pm.userID})
+	} else {
+		err = removeIgnoredUser(pm.parent.matrix.Client(), pm.userID)
+	}
+	if err != nil {
+		pm.setStatus(fmt.Sprintf("Failed to update ignored users: %v", err))
+		return
+	}
+	pm.lock.Lock()
+	pm.ignored = ignore
+	pm.lock.Unlock()
+	if ignore {
+		pm.setStatus("User ignored")
+	} else {
+		pm.setStatus("User unignored")
+	}
+}
+
+// removeIgnoredUser removes the given user from the m.ignored_user_list account data.
+func removeIgnoredUser(client *mautrix.Client, userID id.UserID) error {
+	var content event.IgnoredUserListEventContent
+	err := client.GetAccountData(event.AccountDataIgnoredUserList.Type, &content)
+	if err != nil {
+		return err
+	} else if _, ok := content.IgnoredUsers[userID]; !ok {
+		return nil
+	}
+	delete(content.IgnoredUsers, userID)
+	return client.SetAccountData(event.AccountDataIgnoredUserList.Type, &content)
+}
+
+// Verify starts verifying the user in the direct chat with them, or in the current room if there isn't one.
+func (pm *ProfileModal) Verify() {
+	roomView := pm.parent.currentRoom
+	if dm := pm.parent.findDirectChat(pm.userID); dm != nil {
+		pm.parent.SwitchRoom("", dm)
+		roomView = pm.parent.currentRoom
+	}
+	if roomView == nil {
+		return
+	}
+	cmd := pm.parent.cmdProcessor.ParseCommand(roomView, fmt.Sprintf("/verify %s", pm.userID))
+	if cmd != nil {
+		go pm.parent.cmdProcessor.HandleCommand(cmd)
+	}
+}
+
+func (pm *ProfileModal) OnKeyEvent(event mauview.KeyEvent) bool {
+	if event.Key() == tcell.KeyEscape || event.Rune() == 'q' {
+		pm.parent.HideModal()
+		return true
+	} else if event.Key() != tcell.KeyRune {
+		return pm.FocusableComponent.OnKeyEvent(event)
+	}
+	switch event.Rune() {
+	case 'm':
+		pm.parent.HideModal()
+		if pm.parent.currentRoom != nil {
+			pm.parent.currentRoom.BlurMemberList()
+			pm.parent.currentRoom.InsertMention(pm.userID, pm.displayname)
+		}
+	case 'd':
+		if !pm.isSelf() {
+			pm.parent.HideModal()
+			go pm.parent.OpenDirectChat(pm.userID)
+		}
+	case 'i':
+		if !pm.isSelf() {
+			pm.setStatus("Updating ignored users...")
+			go pm.ToggleIgnore()
+		}
+	case 'v':
+		if !pm.isSelf() {
+			pm.parent.HideModal()
+			pm.Verify()
+		}
+	default:
+		return pm.FocusableComponent.OnKeyEvent(event)
+	}
+	return true
+}
+
+func describePresence(presence event.Presence, lastActive time.Time) string {
+	var text string
+	switch presence {
+	case event.PresenceOnline:
+		text = "Online"
+	case event.PresenceUnavailable:
+		text = "Away"
+	case event.PresenceOffline:
+		text = "Offline"
+	default:
+		return "Presence unknown"
+	}
+	if !lastActive.IsZero() && presence != event.PresenceOnline {
+		text += ", last active " + formatAgo(time.Since(lastActive))
+	}
+	return text
+}
+
+func formatAgo(duration time.Duration) string {
+	switch {
+	case duration < time.Minute:
+		return "just now"
+	case duration < time.Hour:
+		return fmt.Sprintf("%d minutes ago", int(duration.Minutes()))
+	case duration < 24*time.Hour:
+		return fmt.Sprintf("%d hours ago", int(duration.Hours()))
+	default:
+		return fmt.Sprintf("%d days ago", int(duration.Hours()/24))
+	}
+}
+
+// profileContent draws the inside of the profile modal box.
+type profileContent struct {
+	mauview.NoopEventHandler
+	modal *ProfileModal
+}
+
+func (content *profileContent) Draw(screen mauview.Screen) {
+	pm := content.modal
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	width, height := screen.Size()
+
+	textX := 0
+	if pm.showAvatar() {
+		textX = profileAvatarWidth + 2
+		for row, line := range pm.avatar {
+			line.Draw(screen, 0, row)
+		}
+	}
+	textWidth := width - textX
+	widget.WriteLine(screen, mauview.AlignLeft, pm.displayname, textX, 0, textWidth,
+		tcell.StyleDefault.Foreground(widget.GetHashColor(pm.userID)).Bold(true))
+	widget.WriteLineColor(screen, mauview.AlignLeft, string(pm.userID), textX, 1, textWidth, tcell.ColorGray)
+	widget.WriteLine(screen, mauview.AlignLeft, describePresence(pm.parent.matrix.GetPresence(pm.userID)),
+		textX, 2, textWidth, tcell.StyleDefault)
+	roomInfo := fmt.Sprintf("Membership: %s", pm.membership)
+	if len(pm.membership) == 0 {
+		roomInfo = "Not a member of this room"
+	} else if pm.powerLevel != nil {
+		roomInfo += fmt.Sprintf(", power level %d", *pm.powerLevel)
+	}
+	widget.WriteLine(screen, mauview.AlignLeft, roomInfo, textX, 3, textWidth, tcell.StyleDefault)
+
+	y := 4
+	if pm.showAvatar() && y < profileAvatarHeight {
+		y = profileAvatarHeight
+	}
+	y++
+	if !pm.loaded {
+		widget.WriteLineColor(screen, mauview.AlignLeft, "Loading...", 0, y, width, tcell.ColorGray)
+	} else {
+		widget.WriteLine(screen, mauview.AlignLeft, "Devices: "+pm.devices, 0, y, width, tcell.StyleDefault)
+		y++
+		if pm.ignored {
+			widget.WriteLineColor(screen, mauview.AlignLeft, "Ignored", 0, y, width, tcell.ColorRed)
+			y++
+		}
+		if !pm.isSelf() {
+			y = pm.drawSharedRooms(screen, y+1, height-profileActionsHeight-1)
+		}
+	}
+
+	if len(pm.status) > 0 {
+		widget.WriteLineColor(screen, mauview.AlignLeft, pm.status, 0, height-2, width, tcell.ColorYellow)
+	}
+	actions := "m: mention  Esc: close"
+	if !pm.isSelf() {
+		ignore := "ignore"
+		if pm.ignored {
+			ignore = "unignore"
+		}
+		actions = fmt.Sprintf("d: direct chat  m: mention  i: %s  v: verify  Esc: close", ignore)
+	}
+	widget.WriteLineColor(screen, mauview.AlignLeft, actions, 0, height-1, width, tcell.ColorGray)
+}
+
+// drawSharedRooms draws the list of rooms shared with the user starting at the given row
+// without going past maxY and returns the next free row.
+func (pm *ProfileModal) drawSharedRooms(screen mauview.Screen, y, maxY int) int {
+	width, _ := screen.Size()
+	widget.WriteLine(screen, mauview.AlignLeft, fmt.Sprintf("Shared rooms (%d):", len(pm.sharedRooms)),
+		0, y, width, tcell.StyleDefault.Bold(true))
+	y++
+	for i, name := range pm.sharedRooms {
+		if y >= maxY {
+			break
+		} else if y == maxY-1 && i < len(pm.sharedRooms)-1 {
+			widget.WriteLineColor(screen, mauview.AlignLeft, fmt.Sprintf("  … and %d more", len(pm.sharedRooms)-i),
+				0, y, width, tcell.ColorGray)
+			y++
+			break
+		}
+		widget.WriteLine(screen, mauview.AlignLeft, "• "+name, 2, y, width-2, tcell.StyleDefault)
+		y++
+	}
+	return y
+}
@@ -0,0 +1,436 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// +build cgo
+
+package ui
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/lib/qrcode"
+	"maunium.net/go/gomuks/ui/widget"
+)
+
+// The crypto module only supports SAS verification, so the to-device events of
+// QR code verifications are handled here instead.
+var (
+	qrEventRequest = event.Type{Type: "m.key.verification.request", Class: event.ToDeviceEventType}
+	qrEventReady   = event.Type{Type: "m.key.verification.ready", Class: event.ToDeviceEventType}
+	qrEventStart   = event.Type{Type: "m.key.verification.start", Class: event.ToDeviceEventType}
+	qrEventCancel  = event.Type{Type: "m.key.verification.cancel", Class: event.ToDeviceEventType}
+	qrEventDone    = event.Type{Type: "m.key.verification.done", Class: event.ToDeviceEventType}
+)
+
+const (
+	qrMethodShow        = "m.qr_code.show.v1"
+	qrMethodReciprocate = "m.reciprocate.v1"
+)
+
+const (
+	// The other device is verifying another user.
+	qrModeCrossUser = 0x00
+	// Self-verification where the device showing the code trusts the master key.
+	qrModeSelfTrusted = 0x01
+	// Self-verification where the device showing the code doesn't trust the master key yet.
+	qrModeSelfUntrusted = 0x02
+)
+
+const (
+	qrCancelUser        = "m.user"
+	qrCancelKeyMismatch = "m.key_mismatch"
+	qrCancelTimeout     = "m.timeout"
+)
+
+// QRVerificationTimeout is how long a shown QR code stays valid.
+const QRVerificationTimeout = 5 * time.Minute
+
+type qrVerificationContent struct {
+	FromDevice    id.DeviceID `json:"from_device,omitempty"`
+	Methods       []string    `json:"methods,omitempty"`
+	Timestamp     int64       `json:"timestamp,omitempty"`
+	TransactionID string      `json:"transaction_id"`
+	Method        string      `json:"method,omitempty"`
+	Secret        string      `json:"secret,omitempty"`
+	Code          string      `json:"code,omitempty"`
+	Reason        string      `json:"reason,omitempty"`
+}
+
+// qrPayload is the binary data encoded in verification QR codes.
+type qrPayload struct {
+	Mode          byte
+	TransactionID string
+	Key1          []byte
+	Key2          []byte
+	Secret        []byte
+}
+
+const qrPayloadHeader = "MATRIX\x02"
+
+func (payload *qrPayload) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(qrPayloadHeader)
+	buf.WriteByte(payload.Mode)
+	_ = binary.Write(&buf, binary.BigEndian, uint16(len(payload.TransactionID)))
+	buf.WriteString(payload.TransactionID)
+	buf.Write(payload.Key1)
+	buf.Write(payload.Key2)
+	buf.Write(payload.Secret)
+	return buf.Bytes()
+}
+
+func parseQRPayload(data []byte) (*qrPayload, error) {
+	if !bytes.HasPrefix(data, []byte(qrPayloadHeader)) || len(data) < len(qrPayloadHeader)+3 {
+		return nil, errors.New("not a Matrix verification code")
+	}
+	data = data[len(qrPayloadHeader):]
+	payload := &qrPayload{Mode: data[0]}
+	txnIDLength := int(binary.BigEndian.Uint16(data[1:3]))
+	data = data[3:]
+	if len(data) < txnIDLength+32+32+8 {
+		return nil, errors.New("verification code is too short")
+	}
+	payload.TransactionID = string(data[:txnIDLength])
+	payload.Key1 = data[txnIDLength : txnIDLength+32]
+	payload.Key2 = data[txnIDLength+32 : txnIDLength+64]
+	payload.Secret = data[txnIDLength+64:]
+	return payload, nil
+}
+
+func decodeKey(key id.Ed25519) []byte {
+	data, _ := base64.RawStdEncoding.DecodeString(string(key))
+	return data
+}
+
+func randomBytes(length int) []byte {
+	data := make([]byte, length)
+	_, _ = rand.Read(data)
+	return data
+}
+
+type QRVerificationModal struct {
+	mauview.Component
+
+	parent *MainView
+	device *crypto.DeviceIdentity
+
+	transactionID string
+	secret        []byte
+	mode          byte
+	done          bool
+
+	infoText *mauview.TextView
+}
+
+func NewQRVerificationModal(parent *MainView, device *crypto.DeviceIdentity, payload *qrPayload) (*QRVerificationModal, error) {
+	code, err := qrcode.Encode(payload.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	qm := &QRVerificationModal{
+		parent:        parent,
+		device:        device,
+		transactionID: payload.TransactionID,
+		secret:        payload.Secret,
+		mode:          payload.Mode,
+	}
+	qm.infoText = mauview.NewTextView().SetWordWrap(true)
+	qm.infoText.SetText(fmt.Sprintf("Waiting for %s (%s) to accept the request.\nPress Esc to cancel.", device.Name, device.DeviceID))
+
+	qrWidth, qrHeight := widget.QRCodeSize(code)
+	width := qrWidth + 2
+	if width < 45 {
+		width = 45
+	}
+	flex := mauview.NewFlex().
+		SetDirection(mauview.FlexRow).
+		AddFixedComponent(mauview.Center(&widget.QRCode{Code: code}, qrWidth, qrHeight), qrHeight).
+		AddFixedComponent(qm.infoText, 3)
+	box := mauview.NewBox(flex).
+		SetBorder(true).
+		SetTitle("QR code verification")
+	qm.Component = mauview.Center(box, width, qrHeight+5)
+	return qm, nil
+}
+
+func (qm *QRVerificationModal) setInfo(text string) {
+	qm.infoText.SetText(text)
+	qm.parent.parent.Render()
+}
+
+func (qm *QRVerificationModal) finish(text string) {
+	qm.done = true
+	qm.setInfo(text + "\nPress Enter to close the dialog.")
+	if qm.parent.qrVerification == qm {
+		qm.parent.qrVerification = nil
+	}
+}
+
+func (qm *QRVerificationModal) OnKeyEvent(event mauview.KeyEvent) bool {
+	if event.Key() == tcell.KeyEscape || (qm.done && event.Key() == tcell.KeyEnter) {
+		if !qm.done {
+			go qm.sendCancel(qrCancelUser, "The user cancelled the verification")
+			qm.parent.qrVerification = nil
+		}
+		qm.parent.HideModal()
+		return true
+	}
+	return false
+}
+
+func (qm *QRVerificationModal) send(evtType event.Type, content *qrVerificationContent) error {
+	content.TransactionID = qm.transactionID
+	return sendQRVerificationEvent(qm.parent.matrix.Client(), qm.device, evtType, content)
+}
+
+func (qm *QRVerificationModal) sendCancel(code, reason string) {
+	err := qm.send(qrEventCancel, &qrVerificationContent{Code: code, Reason: reason})
+	if err != nil {
+		debug.Printf("Failed to send QR verification cancellation to %s: %v", qm.device.DeviceID, err)
+	}
+}
+
+func sendQRVerificationEvent(client *mautrix.Client, device *crypto.DeviceIdentity, evtType event.Type, content *qrVerificationContent) error {
+	_, err := client.SendToDevice(evtType, &mautrix.ReqSendToDevice{
+		Messages: map[id.UserID]map[id.DeviceID]*event.Content{
+			device.UserID: {
+				device.DeviceID: {Parsed: content},
+			},
+		},
+	})
+	return err
+}
+
+// HandleQRVerificationEvent handles to-device verification events that belong to an ongoing QR code verification.
+//
+// This is called by the matrix package before passing to-device events to the crypto module.
+// If this returns true, the event won't be passed to the crypto module.
+func (view *MainView) HandleQRVerificationEvent(evt *event.Event) bool {
+	qm := view.qrVerification
+	if qm == nil || !strings.HasPrefix(evt.Type.Type, "m.key.verification.") {
+		return false
+	}
+	var content qrVerificationContent
+	if err := json.Unmarshal(evt.Content.VeryRaw, &content); err != nil || content.TransactionID != qm.transactionID {
+		return false
+	} else if evt.Sender != qm.device.UserID {
+		return true
+	}
+	switch evt.Type.Type {
+	case qrEventReady.Type:
+		qm.setInfo(fmt.Sprintf("Scan the code with %s (%s).\nPress Esc to cancel.", qm.device.Name, qm.device.DeviceID))
+	case qrEventStart.Type:
+		if content.Method != qrMethodReciprocate {
+			go qm.sendCancel("m.unknown_method", "Only QR code verification is supported for this request, use /verify for emoji verification")
+			qm.finish("The other device tried to use an unsupported verification method.")
+			return true
+		}
+		go qm.reciprocate(content.Secret)
+	case qrEventCancel.Type:
+		qm.finish(fmt.Sprintf("Verification cancelled by the other device: %s", content.Reason))
+	case qrEventDone.Type:
+	default:
+		return false
+	}
+	return true
+}
+
+func (qm *QRVerificationModal) reciprocate(secret string) {
+	defer debug.Recover()
+	if secret != base64.RawStdEncoding.EncodeToString(qm.secret) {
+		qm.sendCancel(qrCancelKeyMismatch, "The secret in the reciprocation didn't match the QR code")
+		qm.finish("Verification failed: the other device sent a wrong secret.")
+		return
+	}
+	qm.setInfo(fmt.Sprintf("%s (%s) scanned the code.\nConfirm on the other device too.", qm.device.Name, qm.device.DeviceID))
+	confirmed := qm.parent.AskConfirmation("QR code verification",
+		fmt.Sprintf("Did %s (%s) show that the code was scanned successfully?", qm.device.Name, qm.device.DeviceID),
+		QRVerificationTimeout)
+	qm.parent.ShowModal(qm)
+	if !confirmed {
+		qm.sendCancel(qrCancelUser, "The user didn't confirm the verification")
+		qm.finish("Verification cancelled.")
+		return
+	}
+	mach := qm.parent.matrix.Crypto().(*crypto.OlmMachine)
+	qm.device.Trust = crypto.TrustStateVerified
+	if err := mach.CryptoStore.PutDevice(qm.device.UserID, qm.device); err != nil {
+		debug.Printf("Failed to save verified device %s: %v", qm.device.DeviceID, err)
+	}
+	mach.OnDevicesChanged(qm.device.UserID)
+	result := fmt.Sprintf("Successfully verified %s (%s).", qm.device.Name, qm.device.DeviceID)
+	if mach.CrossSigningKeys != nil {
+		if err := mach.SignOwnDevice(qm.device); err != nil {
+			result = fmt.Sprintf("Verified %s (%s), but cross-signing failed: %v", qm.device.Name, qm.device.DeviceID, err)
+		}
+	}
+	if err := qm.send(qrEventDone, &qrVerificationContent{}); err != nil {
+		debug.Printf("Failed to send QR verification done event to %s: %v", qm.device.DeviceID, err)
+	}
+	qm.finish(result)
+}
+
+const verifyQRHelp = `Usage: /%s <device id> [code]
+
+Without a code, a QR code is shown for one of your other devices (e.g. a phone) to scan.
+
+If the other device is showing a QR code instead, enter the base64-encoded contents
+of the code to reciprocate. If the code can't be used, interactive emoji verification
+is started instead.`
+
+func cmdVerifyQR(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmd.Reply(verifyQRHelp, cmd.OrigCommand)
+		return
+	}
+	mach := cmd.Matrix.Crypto().(*crypto.OlmMachine)
+	device, err := mach.GetOrFetchDevice(cmd.Config.UserID, id.DeviceID(cmd.Args[0]))
+	if err != nil {
+		cmd.Reply("Failed to get device: %v", err)
+		return
+	} else if device.DeviceID == cmd.Config.DeviceID {
+		cmd.Reply("You can't verify the current device")
+		return
+	}
+	keys := mach.GetOwnCrossSigningPublicKeys()
+	if keys == nil {
+		cmd.Reply("QR code verification requires cross-signing, use /verify %s %s for emoji verification instead", device.UserID, device.DeviceID)
+		return
+	}
+	if len(cmd.Args) > 1 {
+		reciprocateQRCode(cmd, mach, device, keys.MasterKey, strings.Join(cmd.Args[1:], ""))
+	} else {
+		showQRCode(cmd, mach, device, keys.MasterKey)
+	}
+}
+
+func showQRCode(cmd *Command, mach *crypto.OlmMachine, device *crypto.DeviceIdentity, masterKey id.Ed25519) {
+	payload := &qrPayload{
+		TransactionID: hex.EncodeToString(randomBytes(12)),
+		Secret:        randomBytes(16),
+	}
+	if mach.CrossSigningKeys != nil {
+		payload.Mode = qrModeSelfTrusted
+		payload.Key1 = decodeKey(masterKey)
+		payload.Key2 = decodeKey(device.SigningKey)
+	} else {
+		payload.Mode = qrModeSelfUntrusted
+		payload.Key1 = decodeKey(mach.OwnIdentity().SigningKey)
+		payload.Key2 = decodeKey(masterKey)
+	}
+	modal, err := NewQRVerificationModal(cmd.MainView, device, payload)
+	if err != nil {
+		cmd.Reply("Failed to create QR code: %v", err)
+		return
+	}
+	err = modal.send(qrEventRequest, &qrVerificationContent{
+		FromDevice: cmd.Config.DeviceID,
+		Methods:    []string{qrMethodShow, qrMethodReciprocate},
+		Timestamp:  time.Now().Unix() * 1000,
+	})
+	if err != nil {
+		cmd.Reply("Failed to send verification request: %v", err)
+		return
+	}
+	cmd.MainView.qrVerification = modal
+	cmd.MainView.ShowModal(modal)
+	go func() {
+		time.Sleep(QRVerificationTimeout)
+		if cmd.MainView.qrVerification == modal {
+			modal.sendCancel(qrCancelTimeout, "The QR code expired")
+			modal.finish("The QR code expired.")
+		}
+	}()
+}
+
+func reciprocateQRCode(cmd *Command, mach *crypto.OlmMachine, device *crypto.DeviceIdentity, masterKey id.Ed25519, code string) {
+	data, err := base64.StdEncoding.DecodeString(code)
+	if err != nil {
+		data, err = base64.RawStdEncoding.DecodeString(code)
+	}
+	var payload *qrPayload
+	if err == nil {
+		payload, err = parseQRPayload(data)
+	}
+	if err == nil {
+		switch payload.Mode {
+		case qrModeSelfTrusted:
+			if !bytes.Equal(payload.Key1, decodeKey(masterKey)) || !bytes.Equal(payload.Key2, decodeKey(mach.OwnIdentity().SigningKey)) {
+				err = errors.New("the keys in the code don't match")
+			}
+		case qrModeSelfUntrusted:
+			if !bytes.Equal(payload.Key1, decodeKey(device.SigningKey)) || !bytes.Equal(payload.Key2, decodeKey(masterKey)) {
+				err = errors.New("the keys in the code don't match")
+			}
+		default:
+			err = errors.New("the code is not for verifying your own devices")
+		}
+	}
+	if err != nil {
+		cmd.Reply("Can't use QR code (%v), falling back to emoji verification", err)
+		mach.DefaultSASTimeout = 120 * time.Second
+		modal := NewVerificationModal(cmd.MainView, device, mach.DefaultSASTimeout)
+		cmd.MainView.ShowModal(modal)
+		_, err = mach.NewSimpleSASVerificationWith(device, modal)
+		if err != nil {
+			cmd.Reply("Failed to start interactive verification: %v", err)
+		}
+		return
+	}
+
+	content := &qrVerificationContent{
+		TransactionID: payload.TransactionID,
+		FromDevice:    cmd.Config.DeviceID,
+		Method:        qrMethodReciprocate,
+		Secret:        base64.RawStdEncoding.EncodeToString(payload.Secret),
+	}
+	if err = sendQRVerificationEvent(cmd.Matrix.Client(), device, qrEventStart, content); err != nil {
+		cmd.Reply("Failed to send reciprocation: %v", err)
+		return
+	}
+	if payload.Mode == qrModeSelfUntrusted {
+		// The other device doesn't trust the master key, so it's our job to cross-sign it.
+		device.Trust = crypto.TrustStateVerified
+		if mach.CrossSigningKeys != nil {
+			crossSignDevice(cmd, device)
+		}
+		putDevice(cmd, device, "verified")
+	} else {
+		cmd.Reply("Code matches, %s (%s) will now cross-sign this device", device.Name, device.DeviceID)
+	}
+	err = sendQRVerificationEvent(cmd.Matrix.Client(), device, qrEventDone, &qrVerificationContent{TransactionID: payload.TransactionID})
+	if err != nil {
+		cmd.Reply("Failed to send verification done event: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/ui/messages"
+)
+
+// The maximum number of history batches to load when looking for the fully read marker
+// or another message to jump to.
+const maxReadMarkerBackfill = 20
+
+// SetReadMarker moves the unread line separator to below the given message.
+func (view *MessageView) SetReadMarker(eventID id.EventID) {
+	if eventID == view.readMarker {
+		return
+	}
+	if prev := view.getMessageByID(view.readMarker); prev != nil {
+		prev.ReadMarker = false
+	}
+	view.readMarker = eventID
+	if msg := view.getMessageByID(eventID); msg != nil && msg != view.lastMessage() {
+		msg.ReadMarker = true
+	}
+	// Force the buffer to be recalculated, as the height of the messages changed.
+	view.prevMsgCount = -1
+}
+
+func (view *MessageView) lastMessage() *messages.UIMessage {
+	view.messagesLock.RLock()
+	defer view.messagesLock.RUnlock()
+	if len(view.messages) == 0 {
+		return nil
+	}
+	return view.messages[len(view.messages)-1]
+}
+
+// updateReadMarker decides whether a message that is being added should have the unread line separator,
+// and adds the separator to the previous last message if it's the fully read one.
+func (view *MessageView) updateReadMarker(message, oldMsg *messages.UIMessage, direction MessageDirection) {
+	if len(view.readMarker) == 0 {
+		return
+	}
+	switch {
+	case oldMsg != nil:
+		message.ReadMarker = oldMsg.ReadMarker
+	case direction == PrependMessage:
+		message.ReadMarker = message.EventID == view.readMarker && view.lastMessage() != nil
+	case direction == AppendMessage:
+		if last := view.lastMessage(); last != nil && last.EventID == view.readMarker && !last.ReadMarker {
+			last.ReadMarker = true
+			view.replaceBuffer(last, last)
+		}
+	}
+}
+
+// ScrollToMessage scrolls the view so that the given message is in the middle of the screen.
+func (view *MessageView) ScrollToMessage(msg *messages.UIMessage) bool {
+	view.msgBufferLock.RLock()
+	index := -1
+	for i, buffered := range view.msgBuffer {
+		if buffered == msg {
+			index = i
+		}
+	}
+	totalHeight := len(view.msgBuffer)
+	view.msgBufferLock.RUnlock()
+	if index == -1 {
+		return false
+	}
+	view.ScrollOffset = totalHeight - index - 1 - view.Height()/2
+	view.AddScrollOffset(0)
+	return true
+}
+
+// JumpToReadMarker scrolls to the first unread message, loading more history if the fully read
+// marker is further back than what has been loaded.
+func (view *RoomView) JumpToReadMarker() {
+	defer debug.Recover()
+	if len(view.content.readMarker) == 0 {
+		view.AddServiceMessage("There's no read marker in this room")
+		view.parent.parent.Render()
+		return
+	}
+	if !view.scrollToEvent(view.content.readMarker) {
+		view.AddServiceMessage("Couldn't find the last read message in the history")
+		view.parent.parent.Render()
+	}
+}
+
+// JumpToEvent scrolls to the given message, loading more history if it hasn't been loaded yet.
+func (view *RoomView) JumpToEvent(eventID id.EventID) {
+	defer debug.Recover()
+	if !view.scrollToEvent(eventID) {
+		view.AddServiceMessage("Couldn't find that message in the recent history of this room")
+		view.parent.parent.Render()
+	}
+}
+
+func (view *RoomView) scrollToEvent(eventID id.EventID) bool {
+	return view.scrollToMatch(func() (*messages.UIMessage, bool) {
+		msg := view.content.getMessageByID(eventID)
+		return msg, msg != nil
+	})
+}
+
+// scrollToMatch scrolls to the message returned by find, loading more history until find says that
+// the loaded messages are enough to decide, or until no more history can be loaded.
+// Returns false if no message was found.
+func (view *RoomView) scrollToMatch(find func() (msg *messages.UIMessage, done bool)) bool {
+	msgView := view.content
+	for i := 0; ; i++ {
+		msg, done := find()
+		if !done && i < maxReadMarkerBackfill && !view.Room.HasLeft {
+			prevCount := msgView.messageCount()
+			view.parent.loadHistory(view.Room.ID, true)
+			if msgView.messageCount() != prevCount {
+				continue
+			}
+		}
+		if msg == nil {
+			return false
+		}
+		msgView.ScrollToMessage(msg)
+		view.parent.parent.Render()
+		return true
+	}
+}
@@ -0,0 +1,505 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	sync "github.com/sasha-s/go-deadlock"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/ui/messages/tstring"
+	"maunium.net/go/gomuks/ui/widget"
+)
+
+const (
+	roomInfoWidth        = 72
+	roomInfoHeight       = 24
+	roomInfoAvatarWidth  = 8
+	roomInfoAvatarHeight = 4
+	roomInfoLabelWidth   = 18
+)
+
+// roomInfoField is a row in the room info panel.
+type roomInfoField struct {
+	label string
+	value string
+	// eventType is the state event the field is stored in. Fields without one can't be changed.
+	eventType event.Type
+	// edit changes the field to the given value. Fields without an edit function run action instead.
+	edit   func(value string) error
+	action func()
+	// editValue is the text the input field is filled with when editing.
+	editValue string
+}
+
+// RoomInfoModal is a modal that shows the settings of a room and allows changing the ones
+// the user has permission to change.
+type RoomInfoModal struct {
+	mauview.FocusableComponent
+	parent *MainView
+	room   *RoomView
+
+	fields   []roomInfoField
+	selected int
+	input    *mauview.InputField
+	editing  bool
+
+	lock      sync.Mutex
+	avatarURL id.ContentURI
+	avatar    []tstring.TString
+	status    string
+}
+
+func NewRoomInfoModal(parent *MainView, room *RoomView) *RoomInfoModal {
+	ri := &RoomInfoModal{
+		parent: parent,
+		room:   room,
+		input:  mauview.NewInputField(),
+	}
+
+	box := mauview.NewBox(&roomInfoContent{modal: ri}).
+		SetBorder(true).
+		SetTitle("Room info").
+		SetBlurCaptureFunc(func() bool {
+			ri.parent.HideModal()
+			return true
+		})
+	box.Focus()
+
+	ri.FocusableComponent = mauview.FractionalCenter(box, roomInfoWidth, roomInfoHeight, 0.5, 0.4)
+	ri.update()
+
+	return ri
+}
+
+func (ri *RoomInfoModal) powerLevels() *event.PowerLevelsEventContent {
+	if plEvent := ri.room.Room.GetStateEvent(event.StatePowerLevels, ""); plEvent != nil {
+		return plEvent.Content.AsPowerLevels()
+	}
+	return &event.PowerLevelsEventContent{}
+}
+
+func (ri *RoomInfoModal) sendState(eventType event.Type, content interface{}) error {
+	_, err := ri.parent.matrix.Client().SendStateEvent(ri.room.Room.ID, eventType, "", content)
+	return err
+}
+
+// update rebuilds the fields from the current state of the room.
+func (ri *RoomInfoModal) update() {
+	room := ri.room.Room
+	pls := ri.powerLevels()
+
+	var name string
+	if evt := room.GetStateEvent(event.StateRoomName, ""); evt != nil {
+		name = evt.Content.AsRoomName().Name
+	}
+	nameValue := name
+	if len(name) == 0 {
+		nameValue = fmt.Sprintf("(not set, shown as %s)", room.GetTitle())
+	}
+
+	topic := room.GetTopic()
+
+	var avatarURL id.ContentURI
+	if evt := room.GetStateEvent(event.StateRoomAvatar, ""); evt != nil {
+		avatarURL = evt.Content.AsRoomAvatar().URL
+	}
+	var avatarValue string
+	if !avatarURL.IsEmpty() {
+		avatarValue = avatarURL.String()
+	}
+	ri.loadAvatar(avatarURL)
+
+	var aliases event.CanonicalAliasEventContent
+	if evt := room.GetStateEvent(event.StateCanonicalAlias, ""); evt != nil {
+		aliases = *evt.Content.AsCanonicalAlias()
+	}
+	altAliases := make([]string, len(aliases.AltAliases))
+	for i, alias := range aliases.AltAliases {
+		altAliases[i] = string(alias)
+	}
+
+	encryption := roomInfoField{
+		label:     "Encryption",
+		value:     "Disabled",
+		eventType: event.StateEncryption,
+		action:    ri.enableEncryption,
+	}
+	if evt := room.GetStateEvent(event.StateEncryption, ""); evt != nil {
+		encryption = roomInfoField{label: "Encryption", value: fmt.Sprintf("Enabled (%s)", evt.Content.AsEncryption().Algorithm)}
+	} else if room.Encrypted {
+		encryption = roomInfoField{label: "Encryption", value: "Enabled"}
+	}
+
+	var joinRule string
+	if evt := room.GetStateEvent(event.StateJoinRules, ""); evt != nil {
+		joinRule = string(evt.Content.AsJoinRules().JoinRule)
+	}
+
+	members := fmt.Sprintf("%d joined", room.GetMemberCount())
+	if invited := room.Summary.InvitedMemberCount; invited != nil && *invited > 0 {
+		members += fmt.Sprintf(", %d invited", *invited)
+	}
+
+	ri.fields = []roomInfoField{{
+		label:     "Name",
+		value:     nameValue,
+		editValue: name,
+		eventType: event.StateRoomName,
+		edit: func(value string) error {
+			return ri.sendState(event.StateRoomName, &event.RoomNameEventContent{Name: value})
+		},
+	}, {
+		label:     "Topic",
+		value:     strings.Replace(topic, "\n", " ", -1),
+		editValue: topic,
+		eventType: event.StateTopic,
+		edit: func(value string) error {
+			return ri.sendState(event.StateTopic, &event.TopicEventContent{Topic: value})
+		},
+	}, {
+		label:     "Avatar",
+		value:     avatarValue,
+		editValue: avatarValue,
+		eventType: event.StateRoomAvatar,
+		edit:      ri.setAvatar,
+	}, {
+		label:     "Main address",
+		value:     string(aliases.Alias),
+		editValue: string(aliases.Alias),
+		eventType: event.StateCanonicalAlias,
+		edit: func(value string) error {
+			content := aliases
+			content.Alias = id.RoomAlias(strings.TrimSpace(value))
+			return ri.sendState(event.StateCanonicalAlias, &content)
+		},
+	}, {
+		label:     "Other addresses",
+		value:     strings.Join(altAliases, ", "),
+		editValue: strings.Join(altAliases, ", "),
+		eventType: event.StateCanonicalAlias,
+		edit: func(value string) error {
+			content := aliases
+			content.AltAliases = nil
+			for _, alias := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+				content.AltAliases = append(content.AltAliases, id.RoomAlias(alias))
+			}
+			return ri.sendState(event.StateCanonicalAlias, &content)
+		},
+	}, {
+		label: "Room ID",
+		value: string(room.ID),
+	}, encryption, {
+		label:     "Join rule",
+		value:     joinRule,
+		editValue: joinRule,
+		eventType: event.StateJoinRules,
+		edit:      ri.setJoinRule,
+	}, {
+		label: "Members",
+		value: members,
+	}, {
+		label: "Power level",
+		value: fmt.Sprintf("you %d, default %d", pls.GetUserLevel(ri.parent.config.UserID), pls.UsersDefault),
+	}, {
+		label: "Required levels",
+		value: fmt.Sprintf("message %d, settings %d, invite %d, kick %d, ban %d, redact %d",
+			pls.EventsDefault, pls.StateDefault(), pls.Invite(), pls.Kick(), pls.Ban(), pls.Redact()),
+	}, {
+		label: "Privileged users",
+		value: ri.privilegedUsers(pls),
+	}}
+	if ri.selected >= len(ri.fields) {
+		ri.selected = len(ri.fields) - 1
+	}
+}
+
+// privilegedUsers lists the users whose power level is above the default, highest first.
+func (ri *RoomInfoModal) privilegedUsers(pls *event.PowerLevelsEventContent) string {
+	type userLevel struct {
+		name  string
+		level int
+	}
+	var users []userLevel
+	for userID, level := range pls.Users {
+		if level <= pls.UsersDefault {
+			continue
+		}
+		name := string(userID)
+		if member := ri.room.Room.GetMember(userID); member != nil && len(member.Displayname) > 0 {
+			name = member.Displayname
+		}
+		users = append(users, userLevel{name, level})
+	}
+	if len(users) == 0 {
+		return "(none)"
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].level != users[j].level {
+			return users[i].level > users[j].level
+		}
+		return strings.ToLower(users[i].name) < strings.ToLower(users[j].name)
+	})
+	parts := make([]string, len(users))
+	for i, user := range users {
+		parts[i] = fmt.Sprintf("%s (%d)", user.name, user.level)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// setAvatar changes the room avatar to the given mxc URI, or uploads the file at the given path and uses it.
+func (ri *RoomInfoModal) setAvatar(value string) error {
+	value = strings.TrimSpace(value)
+	var uri id.ContentURI
+	if strings.HasPrefix(value, "mxc://") {
+		var err error
+		uri, err = id.ParseContentURI(value)
+		if err != nil {
+			return err
+		}
+	} else if len(value) > 0 {
+		if _, err := os.Stat(value); err != nil {
+			return err
+		}
+		ri.setStatus("Uploading avatar...")
+		resp, err := ri.parent.matrix.UploadMedia(context.Background(), value, false, nil)
+		if err != nil {
+			return err
+		}
+		uri = resp.ContentURI
+	}
+	return ri.sendState(event.StateRoomAvatar, &event.RoomAvatarEventContent{URL: uri})
+}
+
+func (ri *RoomInfoModal) setJoinRule(value string) error {
+	rule := event.JoinRule(strings.ToLower(strings.TrimSpace(value)))
+	switch rule {
+	case event.JoinRulePublic, event.JoinRuleInvite, event.JoinRuleKnock, event.JoinRulePrivate:
+		return ri.sendState(event.StateJoinRules, &event.JoinRulesEventContent{JoinRule: rule})
+	default:
+		return fmt.Errorf("unknown join rule %q (expected public, invite, knock or private)", rule)
+	}
+}
+
+func (ri *RoomInfoModal) enableEncryption() {
+	ri.parent.HideModal()
+	cmd := ri.parent.cmdProcessor.ParseCommand(ri.room, "/enable-encryption")
+	if cmd != nil {
+		go ri.parent.cmdProcessor.HandleCommand(cmd)
+	}
+}
+
+func (ri *RoomInfoModal) loadAvatar(uri id.ContentURI) {
+	prefs := ri.parent.config.Preferences
+	ri.lock.Lock()
+	defer ri.lock.Unlock()
+	if uri == ri.avatarURL {
+		return
+	}
+	ri.avatarURL = uri
+	ri.avatar = nil
+	if uri.IsEmpty() || prefs.DisableImages || prefs.DisableDownloads {
+		return
+	}
+	go func() {
+		defer debug.Recover()
+		avatar := renderAvatar(ri.parent.matrix, uri, roomInfoAvatarWidth, roomInfoAvatarHeight)
+		ri.lock.Lock()
+		if ri.avatarURL == uri {
+			ri.avatar = avatar
+		}
+		ri.lock.Unlock()
+		ri.parent.parent.Render()
+	}()
+}
+
+func (ri *RoomInfoModal) setStatus(status string) {
+	ri.lock.Lock()
+	ri.status = status
+	ri.lock.Unlock()
+	ri.parent.parent.Render()
+}
+
+// canChange returns whether the user has a high enough power level to change the given field.
+func (ri *RoomInfoModal) canChange(field roomInfoField) bool {
+	if len(field.eventType.Type) == 0 || (field.edit == nil && field.action == nil) {
+		return false
+	}
+	pls := ri.powerLevels()
+	return pls.GetUserLevel(ri.parent.config.UserID) >= pls.GetEventLevel(field.eventType)
+}
+
+func (ri *RoomInfoModal) startEditing() {
+	field := ri.fields[ri.selected]
+	if !ri.canChange(field) {
+		if len(field.eventType.Type) > 0 {
+			ri.setStatus(fmt.Sprintf("You don't have permission to change the %s", strings.ToLower(field.label)))
+		} else {
+			ri.setStatus(fmt.Sprintf("The %s can't be changed", strings.ToLower(field.label)))
+		}
+		return
+	} else if field.edit == nil {
+		field.action()
+		return
+	}
+	ri.setStatus("")
+	ri.input.SetTextAndMoveCursor(field.editValue)
+	ri.input.Focus()
+	ri.editing = true
+}
+
+func (ri *RoomInfoModal) stopEditing() {
+	ri.input.Blur()
+	ri.editing = false
+}
+
+func (ri *RoomInfoModal) save() {
+	field := ri.fields[ri.selected]
+	value := ri.input.GetText()
+	ri.stopEditing()
+	ri.setStatus(fmt.Sprintf("Changing the %s...", strings.ToLower(field.label)))
+	go func() {
+		defer debug.Recover()
+		err := field.edit(value)
+		if err != nil {
+			ri.setStatus(fmt.Sprintf("Failed to change the %s: %s", strings.ToLower(field.label), niceError(err)))
+		} else {
+			ri.setStatus(fmt.Sprintf("Changed the %s", strings.ToLower(field.label)))
+		}
+	}()
+}
+
+func (ri *RoomInfoModal) OnKeyEvent(event mauview.KeyEvent) bool {
+	if ri.editing {
+		switch event.Key() {
+		case tcell.KeyEscape:
+			ri.stopEditing()
+		case tcell.KeyEnter:
+			ri.save()
+		default:
+			return ri.input.OnKeyEvent(event)
+		}
+		return true
+	}
+	switch {
+	case event.Key() == tcell.KeyEscape || event.Rune() == 'q':
+		ri.parent.HideModal()
+	case event.Key() == tcell.KeyUp || event.Rune() == 'k':
+		if ri.selected > 0 {
+			ri.selected--
+		}
+	case event.Key() == tcell.KeyDown || event.Rune() == 'j':
+		if ri.selected < len(ri.fields)-1 {
+			ri.selected++
+		}
+	case event.Key() == tcell.KeyEnter || event.Rune() == 'e':
+		ri.startEditing()
+	default:
+		return ri.FocusableComponent.OnKeyEvent(event)
+	}
+	return true
+}
+
+func (ri *RoomInfoModal) OnPasteEvent(event mauview.PasteEvent) bool {
+	if ri.editing {
+		return ri.input.OnPasteEvent(event)
+	}
+	return false
+}
+
+// roomInfoContent draws the inside of the room info box.
+type roomInfoContent struct {
+	mauview.NoopEventHandler
+	modal *RoomInfoModal
+}
+
+func (content *roomInfoContent) Draw(screen mauview.Screen) {
+	ri := content.modal
+	if !ri.editing {
+		ri.update()
+	}
+	width, height := screen.Size()
+	room := ri.room.Room
+
+	ri.lock.Lock()
+	avatar := ri.avatar
+	status := ri.status
+	ri.lock.Unlock()
+
+	textX := 0
+	headerHeight := 2
+	if avatar != nil {
+		for row, line := range avatar {
+			line.Draw(screen, 0, row)
+		}
+		textX = roomInfoAvatarWidth + 2
+		headerHeight = roomInfoAvatarHeight
+	}
+	widget.WriteLine(screen, mauview.AlignLeft, room.GetTitle(), textX, 0, width-textX, tcell.StyleDefault.Bold(true))
+	if alias := room.GetCanonicalAlias(); len(alias) > 0 {
+		widget.WriteLineColor(screen, mauview.AlignLeft, string(alias), textX, 1, width-textX, tcell.ColorGray)
+	}
+
+	y := headerHeight + 1
+	valueX := roomInfoLabelWidth
+	valueWidth := width - valueX
+	for i, field := range ri.fields {
+		if y >= height-2 {
+			break
+		}
+		labelStyle := tcell.StyleDefault.Bold(true)
+		if i == ri.selected {
+			labelStyle = labelStyle.Reverse(true)
+		}
+		widget.WriteLine(screen, mauview.AlignLeft, field.label, 0, y, roomInfoLabelWidth-2, labelStyle)
+		if i == ri.selected && ri.editing {
+			ri.input.Draw(mauview.NewProxyScreen(screen, valueX, y, valueWidth, 1))
+		} else if len(field.value) == 0 {
+			widget.WriteLineColor(screen, mauview.AlignLeft, "(not set)", valueX, y, valueWidth, tcell.ColorGray)
+		} else {
+			widget.WriteLine(screen, mauview.AlignLeft, field.value, valueX, y, valueWidth, tcell.StyleDefault)
+		}
+		y++
+	}
+
+	if len(status) > 0 {
+		widget.WriteLineColor(screen, mauview.AlignLeft, status, 0, height-2, width, tcell.ColorYellow)
+	}
+	hint := "Up/Down: select, Esc: close"
+	if ri.editing {
+		hint = "Enter: save, Esc: cancel"
+	} else if field := ri.fields[ri.selected]; ri.canChange(field) && field.edit != nil {
+		hint = "Up/Down: select, Enter: edit, Esc: close"
+	} else if ri.canChange(field) {
+		hint = "Up/Down: select, Enter: enable, Esc: close"
+	}
+	widget.WriteLineColor(screen, mauview.AlignLeft, hint, 0, height-1, width, tcell.ColorGray)
+}
+
+func cmdRoomInfo(cmd *Command) {
+	cmd.MainView.ShowModal(NewRoomInfoModal(cmd.MainView, cmd.Room))
+}
@@ -28,8 +28,10 @@ import (
 	"maunium.net/go/mauview"
 	"maunium.net/go/tcell"
 
+	"maunium.net/go/gomuks/config"
 	"maunium.net/go/gomuks/debug"
 	"maunium.net/go/gomuks/matrix/rooms"
+	"maunium.net/go/gomuks/ui/widget"
 )
 
 var tagOrder = map[string]int{
@@ -79,6 +81,15 @@ type RoomList struct {
 	height       int
 	width        int
 
+	focused bool
+	// Whether the user is currently typing a search query.
+	searching bool
+	// The current search query.
+	searchQuery string
+	// The selection before the search was started, used to restore it if the search is cancelled.
+	searchOrigTag  string
+	searchOrigRoom *rooms.Room
+
 	// The item main text color.
 	mainTextColor tcell.Color
 	// The text color for selected items.
@@ -212,9 +223,13 @@ func (list *RoomList) Bump(room *rooms.Room) {
 	for _, tag := range room.Tags() {
 		trl, ok := list.items[tag.Tag]
 		if !ok {
-			return
+			continue
+		}
+		if trl.SortMode() == config.RoomSortActivity {
+			trl.Bump(room)
+		} else {
+			trl.Reposition(room)
 		}
-		trl.Bump(room)
 	}
 }
 
@@ -243,6 +258,7 @@ func (list *RoomList) SetSelected(tag string, room *rooms.Room) {
 		list.scrollOffset = 0
 	}
 	debug.Print("Selecting", room.GetTitle(), "in", list.GetTagDisplayName(tag))
+	list.parent.roomPreview.Open()
 }
 
 func (list *RoomList) HasSelected() bool {
@@ -443,8 +459,185 @@ func (list *RoomList) ContentHeight() (height int) {
 	return
 }
 
-func (list *RoomList) OnKeyEvent(_ mauview.KeyEvent) bool {
-	return false
+// MatchesSearch returns whether the given room matches the current search query.
+func (list *RoomList) MatchesSearch(room *rooms.Room) bool {
+	return len(list.searchQuery) > 0 && strings.Contains(strings.ToLower(room.GetTitle()), strings.ToLower(list.searchQuery))
+}
+
+// IsSearchActive returns whether a search query should currently be applied to the list.
+func (list *RoomList) IsSearchActive() bool {
+	return list.focused && len(list.searchQuery) > 0
+}
+
+// findMatch finds the next room that matches the current search query,
+// starting from the selected room and wrapping around at the end of the list.
+func (list *RoomList) findMatch(forward, includeSelected bool) (string, *rooms.Room) {
+	list.RLock()
+	defer list.RUnlock()
+	if len(list.searchQuery) == 0 {
+		return "", nil
+	}
+
+	var tags []string
+	var all []*rooms.Room
+	selectedIndex := -1
+	for _, tag := range list.tags {
+		trl := list.items[tag]
+		if trl == nil || len(list.GetTagDisplayName(tag)) == 0 {
+			continue
+		}
+		// The rooms are stored in reverse order.
+		items := trl.All()
+		for i := len(items) - 1; i >= 0; i-- {
+			if tag == list.selectedTag && items[i].Room == list.selected {
+				selectedIndex = len(all)
+			}
+			tags = append(tags, tag)
+			all = append(all, items[i].Room)
+		}
+	}
+	if len(all) == 0 {
+		return "", nil
+	}
+
+	step := 1
+	if !forward {
+		step = -1
+	}
+	index := selectedIndex
+	if index == -1 {
+		index = 0
+		if !forward {
+			index = len(all) - 1
+		}
+		includeSelected = true
+	}
+	if !includeSelected {
+		index += step
+	}
+	for i := 0; i < len(all); i++ {
+		index = (index + len(all)) % len(all)
+		if list.MatchesSearch(all[index]) {
+			return tags[index], all[index]
+		}
+		index += step
+	}
+	return "", nil
+}
+
+// selectMatch selects the given room without switching to it, expanding the tag if the room is hidden.
+func (list *RoomList) selectMatch(tag string, room *rooms.Room) {
+	if room == nil {
+		return
+	}
+	list.Lock()
+	trl, ok := list.items[tag]
+	if ok && trl.IndexVisible(room) == -1 {
+		if index := trl.Index(room); index >= 0 {
+			num := trl.TotalLength() - index
+			trl.maxShown = int(math.Ceil(float64(num)/10.0) * 10.0)
+		}
+	}
+	list.Unlock()
+	list.SetSelected(tag, room)
+}
+
+func (list *RoomList) startSearch() {
+	list.searching = true
+	list.searchQuery = ""
+	list.searchOrigTag, list.searchOrigRoom = list.selectedTag, list.selected
+}
+
+func (list *RoomList) cancelSearch() {
+	list.searching = false
+	list.searchQuery = ""
+	if list.searchOrigRoom != nil {
+		list.SetSelected(list.searchOrigTag, list.searchOrigRoom)
+	}
+}
+
+func (list *RoomList) updateSearch(query string) {
+	list.searchQuery = query
+	if list.searchOrigRoom != nil {
+		list.SetSelected(list.searchOrigTag, list.searchOrigRoom)
+	}
+	list.selectMatch(list.findMatch(true, true))
+}
+
+func (list *RoomList) onSearchKeyEvent(event mauview.KeyEvent) bool {
+	switch event.Key() {
+	case tcell.KeyEscape:
+		list.cancelSearch()
+	case tcell.KeyEnter:
+		list.searching = false
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		query := []rune(list.searchQuery)
+		if len(query) == 0 {
+			list.cancelSearch()
+		} else {
+			list.updateSearch(string(query[:len(query)-1]))
+		}
+	case tcell.KeyRune:
+		list.updateSearch(list.searchQuery + string(event.Rune()))
+	default:
+		return false
+	}
+	return true
+}
+
+func (list *RoomList) OnKeyEvent(event mauview.KeyEvent) bool {
+	if list.searching {
+		return list.onSearchKeyEvent(event)
+	}
+	switch event.Key() {
+	case tcell.KeyUp:
+		if event.Modifiers() == tcell.ModShift {
+			list.MoveSelected(true)
+		} else {
+			list.SetSelected(list.Previous())
+		}
+	case tcell.KeyDown:
+		if event.Modifiers() == tcell.ModShift {
+			list.MoveSelected(false)
+		} else {
+			list.SetSelected(list.Next())
+		}
+	case tcell.KeyEnter:
+		if list.selected != nil {
+			list.parent.SwitchRoom(list.selectedTag, list.selected)
+		}
+	case tcell.KeyEscape:
+		list.searchQuery = ""
+		list.parent.FocusRoomView()
+	case tcell.KeyRune:
+		switch event.Rune() {
+		case '/':
+			list.startSearch()
+		case 'n':
+			list.selectMatch(list.findMatch(true, false))
+		case 'N':
+			list.selectMatch(list.findMatch(false, false))
+		case 'k':
+			list.SetSelected(list.Previous())
+		case 'j':
+			list.SetSelected(list.Next())
+		case 'K':
+			list.MoveSelected(true)
+		case 'J':
+			list.MoveSelected(false)
+		case 's':
+			list.CycleSectionSort()
+		case 'l':
+			if list.selected != nil {
+				list.parent.SwitchRoom(list.selectedTag, list.selected)
+			}
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+	return true
 }
 
 func (list *RoomList) OnPasteEvent(_ mauview.PasteEvent) bool {
@@ -470,11 +663,12 @@ func (list *RoomList) OnMouseEvent(event mauview.MouseEvent) bool {
 }
 
 func (list *RoomList) Focus() {
-
+	list.focused = true
 }
 
 func (list *RoomList) Blur() {
-
+	list.focused = false
+	list.searching = false
 }
 
 func (list *RoomList) clickRoom(line, column int, mod bool) bool {
@@ -564,6 +758,15 @@ func (list *RoomList) GetTagDisplayName(tag string) string {
 // Draw draws this primitive onto the screen.
 func (list *RoomList) Draw(screen mauview.Screen) {
 	list.width, list.height = screen.Size()
+	if list.focused && (list.searching || len(list.searchQuery) > 0) {
+		list.height--
+		searchStyle := tcell.StyleDefault
+		if list.searching {
+			searchStyle = searchStyle.Bold(true)
+		}
+		widget.WriteLinePadded(screen, mauview.AlignLeft, "/"+list.searchQuery, 0, list.height, list.width, searchStyle)
+		screen = mauview.NewProxyScreen(screen, 0, 0, list.width, list.height)
+	}
 	y := 0
 	yLimit := y + list.height
 	y -= list.scrollOffset
@@ -0,0 +1,132 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"strings"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/ui/widget"
+)
+
+const (
+	previewPaneOffset   = 26
+	previewPaneMinWidth = 20
+	previewPaneMaxWidth = 60
+)
+
+// RoomPreview is a pane next to the room list that shows the recent messages of the
+// highlighted room while the room list is focused. Previewing a room doesn't mark it as read.
+type RoomPreview struct {
+	parent *MainView
+}
+
+func NewRoomPreview(parent *MainView) *RoomPreview {
+	return &RoomPreview{parent: parent}
+}
+
+// RoomView returns the view of the room that should be previewed, or nil if the preview pane shouldn't be shown.
+func (preview *RoomPreview) RoomView() *RoomView {
+	main := preview.parent
+	if !main.config.Preferences.ShowRoomPreview || main.config.Preferences.HideRoomList || main.focused != main.roomList {
+		return nil
+	}
+	room := main.roomList.SelectedRoom()
+	if room == nil || (main.currentRoom != nil && main.currentRoom.Room == room) {
+		return nil
+	}
+	roomView, ok := main.getRoomView(room.ID, true)
+	if !ok {
+		return nil
+	}
+	return roomView
+}
+
+// Width returns the width of the preview pane on a screen of the given width, or 0 if the pane isn't shown.
+func (preview *RoomPreview) Width(totalWidth int) int {
+	if preview.RoomView() == nil {
+		return 0
+	}
+	width := (totalWidth - previewPaneOffset) / 2
+	if width > previewPaneMaxWidth {
+		width = previewPaneMaxWidth
+	} else if width < previewPaneMinWidth {
+		return 0
+	}
+	return width
+}
+
+// Open loads the history of the previewed room in the background if it hasn't been loaded yet.
+// It's called whenever the previewed room may have changed, so that drawing the preview doesn't block on the network.
+func (preview *RoomPreview) Open() {
+	roomView := preview.RoomView()
+	if roomView == nil {
+		return
+	}
+	msgView := roomView.MessageView()
+	if len(msgView.messages) < 20 && !msgView.initialHistoryLoaded {
+		msgView.initialHistoryLoaded = true
+		go preview.parent.LoadHistory(roomView.Room.ID)
+	}
+}
+
+func (preview *RoomPreview) Draw(screen mauview.Screen) {
+	totalWidth, height := screen.Size()
+	width := preview.Width(totalWidth)
+	if width == 0 {
+		return
+	}
+	roomView := preview.RoomView()
+	paneScreen := mauview.NewProxyScreen(screen, previewPaneOffset, 0, width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			paneScreen.SetContent(x, y, ' ', nil, tcell.StyleDefault)
+		}
+	}
+	widget.NewBorder().Draw(mauview.NewProxyScreen(screen, previewPaneOffset+width, 0, 1, height))
+
+	widget.WriteLine(paneScreen, mauview.AlignLeft, roomView.Room.GetTitle(), 0, 0, width, tcell.StyleDefault.Bold(true))
+	widget.WriteLineColor(paneScreen, mauview.AlignLeft, strings.Repeat("─", width), 0, 1, width, mauview.Styles.BorderColor)
+
+	msgView := roomView.MessageView()
+	msgView.messagesLock.RLock()
+	defer msgView.messagesLock.RUnlock()
+	if len(msgView.messages) == 0 {
+		widget.WriteLineColor(paneScreen, mauview.AlignLeft, "No messages loaded", 0, 2, width, tcell.ColorGray)
+		return
+	}
+	y := height - 1
+	for index := len(msgView.messages) - 1; index >= 0 && y >= 2; index-- {
+		msg := msgView.messages[index]
+		text := strings.SplitN(msg.PlainText(), "\n", 2)[0]
+		x := 0
+		timestamp := msg.FormatTime() + " "
+		widget.WriteLineColor(paneScreen, mauview.AlignLeft, timestamp, x, y, width, msg.TimestampColor())
+		x += mauview.StringWidth(timestamp)
+		if sender := msg.Sender(); len(sender) > 0 {
+			sender += ": "
+			widget.WriteLineColor(paneScreen, mauview.AlignLeft, sender, x, y, width-x, msg.SenderColor())
+			x += mauview.StringWidth(sender)
+		}
+		if x < width {
+			widget.WriteLineColor(paneScreen, mauview.AlignLeft, text, x, y, width-x, msg.TextColor())
+		}
+		y--
+	}
+}
@@ -0,0 +1,230 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/config"
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/rooms"
+)
+
+// nextRoomSort returns the sort mode after the given one in config.RoomSortModes.
+func nextRoomSort(mode string) string {
+	for i, sortMode := range config.RoomSortModes {
+		if sortMode == mode {
+			return config.RoomSortModes[(i+1)%len(config.RoomSortModes)]
+		}
+	}
+	return config.RoomSortModes[0]
+}
+
+// isPersistentTag returns whether the order of rooms in the given tag can be stored in the room tags.
+// The sections of untagged rooms, direct chats, invites and left rooms aren't real tags.
+func isPersistentTag(tag string) bool {
+	return len(tag) > 0 && !strings.HasPrefix(tag, "net.maunium.gomuks.fake.")
+}
+
+// Move swaps the given room with the room above or below it by changing its order. If there's no
+// space between the orders of its new neighbours, the whole list is renumbered evenly between 0 and 1.
+// Returns the rooms whose order changed.
+func (trl *TagRoomList) Move(mxRoom *rooms.Room, up bool) []*OrderedRoom {
+	index := trl.Index(mxRoom)
+	// The list is in reverse order, so moving up means moving to a higher index.
+	target := index - 1
+	if up {
+		target = index + 1
+	}
+	if index == -1 || target < 0 || target >= len(trl.rooms) {
+		return nil
+	}
+	trl.rooms[index], trl.rooms[target] = trl.rooms[target], trl.rooms[index]
+	room := trl.rooms[target]
+
+	lower, upper := 0.0, 1.0
+	if target+1 < len(trl.rooms) {
+		lower = trl.rooms[target+1].order
+	}
+	if target > 0 {
+		upper = trl.rooms[target-1].order
+	}
+	if upper-lower > 2*equalityThreshold {
+		room.order = (lower + upper) / 2
+		return []*OrderedRoom{room}
+	}
+
+	var changed []*OrderedRoom
+	for i, entry := range trl.rooms {
+		order := float64(len(trl.rooms)-i) / float64(len(trl.rooms)+1)
+		if !almostEqual(entry.order, order) {
+			entry.order = order
+			changed = append(changed, entry)
+		}
+	}
+	return changed
+}
+
+// Resort re-sorts every section of the room list, e.g. after the sort mode was changed.
+func (list *RoomList) Resort() {
+	list.Lock()
+	for _, trl := range list.items {
+		trl.Sort()
+	}
+	list.Unlock()
+	if list.selected != nil {
+		list.SetSelected(list.selectedTag, list.selected)
+	}
+}
+
+// SetSectionSort overrides the sort mode of the section of the given tag, or removes the override if the mode is empty.
+func (list *RoomList) SetSectionSort(tag, mode string) {
+	cfg := list.parent.config
+	if len(mode) == 0 {
+		delete(cfg.RoomSortOverrides, tag)
+	} else {
+		if cfg.RoomSortOverrides == nil {
+			cfg.RoomSortOverrides = make(map[string]string)
+		}
+		cfg.RoomSortOverrides[tag] = mode
+	}
+	cfg.Save()
+	list.Resort()
+}
+
+// CycleSectionSort switches the section of the selected room to the next sort mode. The override
+// is removed when cycling back to the default sort mode.
+func (list *RoomList) CycleSectionSort() {
+	if list.selected == nil {
+		return
+	}
+	mode := nextRoomSort(list.parent.config.GetRoomSort(list.selectedTag))
+	if mode == list.parent.config.Preferences.GetRoomSort() {
+		mode = ""
+	}
+	list.SetSectionSort(list.selectedTag, mode)
+}
+
+// MoveSelected moves the selected room up or down in a manually sorted section. The new order is
+// saved in the room tags, so it's kept across sessions and clients.
+func (list *RoomList) MoveSelected(up bool) {
+	tag, room := list.selectedTag, list.selected
+	if room == nil {
+		return
+	} else if !isPersistentTag(tag) {
+		list.parent.ShowServiceMessage(fmt.Sprintf("Rooms in %s can't be reordered, as it isn't a real tag.", list.GetTagDisplayName(tag)))
+		return
+	} else if list.parent.config.GetRoomSort(tag) != config.RoomSortManual {
+		list.parent.ShowServiceMessage("Rooms can only be moved in sections sorted manually. Press s in the room list to change the sort mode of the section.")
+		return
+	}
+
+	list.Lock()
+	trl, ok := list.items[tag]
+	if !ok {
+		list.Unlock()
+		return
+	}
+	orders := make(map[id.RoomID]float64)
+	for _, entry := range trl.Move(room, up) {
+		orders[entry.ID] = entry.order
+	}
+	list.Unlock()
+	list.SetSelected(tag, room)
+
+	go func() {
+		for roomID, order := range orders {
+			err := list.parent.matrix.Client().AddTag(roomID, tag, order)
+			if err != nil {
+				debug.Printf("Failed to save order of %s in %s: %v", roomID, tag, err)
+				list.parent.ShowServiceMessage(fmt.Sprintf("Failed to save the room order: %v", err))
+				return
+			}
+		}
+	}()
+}
+
+// findTag finds a section of the room list by its tag or display name.
+func (list *RoomList) findTag(name string) (string, bool) {
+	list.RLock()
+	defer list.RUnlock()
+	for _, tag := range list.tags {
+		if tag == name || strings.EqualFold(list.GetTagDisplayName(tag), name) {
+			return tag, true
+		}
+	}
+	return "", false
+}
+
+// CycleRoomSort switches the default sort mode of the room list to the next one.
+func (view *MainView) CycleRoomSort() {
+	prefs := &view.config.Preferences
+	prefs.RoomSort = nextRoomSort(prefs.GetRoomSort())
+	go view.matrix.SendPreferencesToMatrix()
+	view.roomList.Resort()
+	view.ShowServiceMessage(fmt.Sprintf("Room list sorted by %s", prefs.RoomSort))
+}
+
+const roomSortHelp = `Usage: /%s <activity|unread|alphabetical|manual> - Set how the room list is sorted.
+       /%[1]s <activity|unread|alphabetical|manual|default> <section> - Override the sorting of one section.`
+
+func cmdRoomSort(cmd *Command) {
+	list := cmd.MainView.roomList
+	if len(cmd.Args) == 0 {
+		var buf strings.Builder
+		_, _ = fmt.Fprintf(&buf, "Room list sorted by %s", cmd.Config.Preferences.GetRoomSort())
+		list.RLock()
+		for _, tag := range list.tags {
+			if mode, ok := cmd.Config.RoomSortOverrides[tag]; ok {
+				_, _ = fmt.Fprintf(&buf, "\n%s: %s", list.GetTagDisplayName(tag), mode)
+			}
+		}
+		list.RUnlock()
+		cmd.Reply("%s", buf.String())
+		return
+	}
+	mode := strings.ToLower(cmd.Args[0])
+	if len(cmd.Args) == 1 {
+		if !config.IsRoomSortMode(mode) {
+			cmd.Reply(roomSortHelp, cmd.OrigCommand)
+			return
+		}
+		cmd.Config.Preferences.RoomSort = mode
+		go cmd.Matrix.SendPreferencesToMatrix()
+		list.Resort()
+		cmd.Reply("Room list sorted by %s", mode)
+		return
+	}
+	section := strings.Join(cmd.Args[1:], " ")
+	tag, ok := list.findTag(section)
+	if !ok {
+		cmd.Reply("No room list section called %s", section)
+		return
+	}
+	if mode == "default" {
+		list.SetSectionSort(tag, "")
+		cmd.Reply("%s now uses the default sort mode", list.GetTagDisplayName(tag))
+	} else if config.IsRoomSortMode(mode) {
+		list.SetSectionSort(tag, mode)
+		cmd.Reply("%s sorted by %s", list.GetTagDisplayName(tag), mode)
+	} else {
+		cmd.Reply(roomSortHelp, cmd.OrigCommand)
+	}
+}
@@ -0,0 +1,311 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"sort"
+	"strings"
+
+	sync "github.com/sasha-s/go-deadlock"
+
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mautrix/pushrules"
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/debug"
+	ifc "maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/lib/ansimage"
+	"maunium.net/go/gomuks/matrix/rooms"
+	"maunium.net/go/gomuks/ui/messages/tstring"
+	"maunium.net/go/gomuks/ui/widget"
+)
+
+const (
+	summaryMaxWidth     = 50
+	summaryMinWidth     = 24
+	summaryMaxHeroes    = 5
+	summaryMaxTopic     = 3
+	summaryAvatarWidth  = 4
+	summaryAvatarHeight = 2
+)
+
+// RoomSummary is a tooltip next to the highlighted room list entry that shows the topic, member count,
+// heroes and notification setting of the room while the room list is focused.
+type RoomSummary struct {
+	parent *MainView
+	box    *mauview.Box
+	room   *rooms.Room
+
+	avatars     map[id.ContentURI][]tstring.TString
+	avatarsLock sync.Mutex
+
+	// The heroes of the room the tooltip was last shown for. They're only computed once when the
+	// selected room changes, as the member list may have to be loaded from disk.
+	heroRoom   *rooms.Room
+	heroList   []summaryHero
+	heroesLock sync.Mutex
+}
+
+type summaryHero struct {
+	UserID    id.UserID
+	Name      string
+	AvatarURL id.ContentURI
+}
+
+func NewRoomSummary(parent *MainView) *RoomSummary {
+	summary := &RoomSummary{
+		parent:  parent,
+		avatars: make(map[id.ContentURI][]tstring.TString),
+	}
+	summary.box = mauview.NewBox(&roomSummaryContent{RoomSummary: summary}).SetBorderStyle(tcell.StyleDefault.Foreground(mauview.Styles.BorderColor))
+	return summary
+}
+
+// Room returns the room whose summary should be shown, or nil if the tooltip shouldn't be shown.
+func (summary *RoomSummary) Room() *rooms.Room {
+	main := summary.parent
+	if !main.config.Preferences.ShowRoomSummary || main.config.Preferences.HideRoomList || main.focused != main.roomList {
+		return nil
+	}
+	return main.roomList.SelectedRoom()
+}
+
+// roomNotificationSetting describes the push rules that apply to all messages in the given room.
+func roomNotificationSetting(rs *pushrules.PushRuleset, roomID id.RoomID) string {
+	if rs == nil {
+		return "unknown"
+	}
+	for _, rule := range rs.Override {
+		if rule.Enabled && rule.RuleID == string(roomID) && !rule.Actions.Should().Notify {
+			return "muted"
+		}
+	}
+	if rule, ok := rs.Room.Map[string(roomID)]; ok && rule.Enabled {
+		if rule.Actions.Should().Notify {
+			return "all messages"
+		}
+		return "mentions and keywords"
+	}
+	return "default"
+}
+
+// heroes returns the members that represent the room. They're loaded in the background when the room is
+// different from the previous call, and an empty list is returned until they've been loaded.
+func (summary *RoomSummary) heroes(room *rooms.Room) []summaryHero {
+	summary.heroesLock.Lock()
+	defer summary.heroesLock.Unlock()
+	if summary.heroRoom != room {
+		summary.heroRoom = room
+		summary.heroList = nil
+		go summary.loadHeroes(room)
+	}
+	return summary.heroList
+}
+
+func (summary *RoomSummary) loadHeroes(room *rooms.Room) {
+	defer debug.Recover()
+	userIDs := findHeroes(room)
+	heroes := make([]summaryHero, len(userIDs))
+	for i, userID := range userIDs {
+		heroes[i] = summaryHero{UserID: userID, Name: string(userID)}
+		if member := room.GetMember(userID); member != nil {
+			if len(member.Displayname) > 0 {
+				heroes[i].Name = member.Displayname
+			}
+			heroes[i].AvatarURL = member.AvatarURL.ParseOrIgnore()
+		}
+	}
+	summary.heroesLock.Lock()
+	if summary.heroRoom == room {
+		summary.heroList = heroes
+	}
+	summary.heroesLock.Unlock()
+	summary.parent.parent.Render()
+}
+
+// findHeroes returns the members that represent the room, either from the room summary or from the member list.
+func findHeroes(room *rooms.Room) []id.UserID {
+	heroes := room.Summary.Heroes
+	if len(heroes) == 0 {
+		for _, userID := range room.GetMemberList() {
+			if userID != room.SessionUserID {
+				heroes = append(heroes, userID)
+			}
+		}
+		// The member list is in random order, so sort it to keep the tooltip stable between renders.
+		sort.Slice(heroes, func(i, j int) bool {
+			return heroes[i] < heroes[j]
+		})
+	}
+	if len(heroes) > summaryMaxHeroes {
+		heroes = heroes[:summaryMaxHeroes]
+	}
+	return heroes
+}
+
+func (summary *RoomSummary) showAvatars() bool {
+	prefs := summary.parent.config.Preferences
+	return !prefs.DisableImages && !prefs.DisableDownloads
+}
+
+// avatar returns the rendered avatar from the given URL, or nil and starts loading it if it hasn't been loaded yet.
+func (summary *RoomSummary) avatar(uri id.ContentURI) []tstring.TString {
+	summary.avatarsLock.Lock()
+	defer summary.avatarsLock.Unlock()
+	avatar, ok := summary.avatars[uri]
+	if !ok {
+		summary.avatars[uri] = nil
+		go summary.loadAvatar(uri)
+	}
+	return avatar
+}
+
+func (summary *RoomSummary) loadAvatar(uri id.ContentURI) {
+	defer debug.Recover()
+	avatar := renderAvatar(summary.parent.matrix, uri, summaryAvatarWidth, summaryAvatarHeight)
+	if avatar == nil {
+		return
+	}
+	summary.avatarsLock.Lock()
+	summary.avatars[uri] = avatar
+	summary.avatarsLock.Unlock()
+	summary.parent.parent.Render()
+}
+
+// renderAvatar downloads the avatar from the given URL and renders it into the given number of cells.
+// It returns nil if the avatar couldn't be downloaded or rendered.
+func renderAvatar(container ifc.MatrixContainer, uri id.ContentURI, width, height int) []tstring.TString {
+	data, err := container.Download(uri, nil)
+	if err != nil {
+		debug.Printf("Failed to download avatar %s: %v", uri, err)
+		return nil
+	}
+	img, err := ansimage.NewScaledFromReader(bytes.NewReader(data), height*2, width, color.Black)
+	if err != nil {
+		debug.Printf("Failed to render avatar %s: %v", uri, err)
+		return nil
+	}
+	return img.Render()
+}
+
+func (summary *RoomSummary) topicLines(room *rooms.Room) []string {
+	topic := strings.TrimSpace(room.GetTopic())
+	if len(topic) == 0 {
+		return nil
+	}
+	lines := strings.Split(topic, "\n")
+	if len(lines) > summaryMaxTopic {
+		lines = lines[:summaryMaxTopic]
+		lines[summaryMaxTopic-1] += " …"
+	}
+	return lines
+}
+
+func (summary *RoomSummary) memberLine(room *rooms.Room) string {
+	line := fmt.Sprintf("%d members", room.GetMemberCount())
+	if invited := room.Summary.InvitedMemberCount; invited != nil && *invited > 0 {
+		line += fmt.Sprintf(", %d invited", *invited)
+	}
+	return line
+}
+
+// contentHeight returns the number of rows needed for the summary of the given room without the border.
+func (summary *RoomSummary) contentHeight(room *rooms.Room) int {
+	heroHeight := 1
+	if summary.showAvatars() {
+		heroHeight = summaryAvatarHeight
+	}
+	// The topic, member count, notification setting and heroes.
+	return len(summary.topicLines(room)) + 2 + len(summary.heroes(room))*heroHeight
+}
+
+func (summary *RoomSummary) Draw(screen mauview.Screen) {
+	room := summary.Room()
+	if room == nil {
+		return
+	}
+	summary.room = room
+	list := summary.parent.roomList
+	totalWidth, totalHeight := screen.Size()
+	x := previewPaneOffset
+	if previewWidth := summary.parent.roomPreview.Width(totalWidth); previewWidth > 0 {
+		x += previewWidth + 1
+	}
+	width := totalWidth - x
+	if width > summaryMaxWidth {
+		width = summaryMaxWidth
+	} else if width < summaryMinWidth {
+		return
+	}
+	height := summary.contentHeight(room) + 2
+	y := list.index(list.selectedTag, room) - list.scrollOffset
+	if y+height > totalHeight {
+		y = totalHeight - height
+	}
+	if y < 0 {
+		y = 0
+	}
+	summary.box.SetTitle(room.GetTitle())
+	summary.box.Draw(mauview.NewProxyScreen(screen, x, y, width, height))
+}
+
+// roomSummaryContent draws the inside of the room summary box.
+type roomSummaryContent struct {
+	mauview.NoopEventHandler
+	*RoomSummary
+}
+
+func (content *roomSummaryContent) Draw(screen mauview.Screen) {
+	room := content.room
+	width, _ := screen.Size()
+	y := 0
+	for _, line := range content.topicLines(room) {
+		widget.WriteLine(screen, mauview.AlignLeft, line, 0, y, width, tcell.StyleDefault)
+		y++
+	}
+	widget.WriteLineColor(screen, mauview.AlignLeft, content.memberLine(room), 0, y, width, tcell.ColorGray)
+	y++
+	notifications := "Notifications: " + roomNotificationSetting(content.parent.config.PushRules, room.ID)
+	widget.WriteLineColor(screen, mauview.AlignLeft, notifications, 0, y, width, tcell.ColorGray)
+	y++
+
+	showAvatars := content.showAvatars()
+	for _, hero := range content.heroes(room) {
+		userID, name, avatarURL := hero.UserID, hero.Name, hero.AvatarURL
+		nameColor := widget.GetHashColor(userID)
+		if !showAvatars {
+			widget.WriteLineColor(screen, mauview.AlignLeft, "• "+name, 0, y, width, nameColor)
+			y++
+			continue
+		}
+		if !avatarURL.IsEmpty() {
+			for row, line := range content.avatar(avatarURL) {
+				line.Draw(screen, 0, y+row)
+			}
+		}
+		textX := summaryAvatarWidth + 1
+		widget.WriteLineColor(screen, mauview.AlignLeft, name, textX, y, width-textX, nameColor)
+		if name != string(userID) {
+			widget.WriteLineColor(screen, mauview.AlignLeft, string(userID), textX, y+1, width-textX, tcell.ColorGray)
+		}
+		y += summaryAvatarHeight
+	}
+}
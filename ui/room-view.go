@@ -17,7 +17,11 @@
 package ui
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"mime"
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -26,7 +30,7 @@ import (
 
 	"github.com/kyokomi/emoji/v2"
 	"github.com/mattn/go-runewidth"
-	"github.com/zyedidia/clipboard"
+	sync "github.com/sasha-s/go-deadlock"
 
 	"maunium.net/go/mauview"
 	"maunium.net/go/tcell"
@@ -56,6 +60,24 @@ type RoomView struct {
 	input    *mauview.InputArea
 	Room     *rooms.Room
 
+	predecessor *Breadcrumb
+	successor   *Breadcrumb
+	pinBanner   *PinBanner
+	sticky      *StickyMessage
+	// The message where the visual selection range in yank mode starts.
+	selectAnchor *messages.UIMessage
+	// The last mention that was jumped to with JumpToMention.
+	mentionCursor *messages.UIMessage
+
+	// The open thread, which replaces the main timeline while it's shown.
+	threadView    *MessageView
+	threadRootMsg *messages.UIMessage
+	// The messages around an opened permalink, which replace the main timeline while they're shown.
+	contextView *MessageView
+	// Extra copies of the main timeline shown in other split panes, which scroll independently.
+	mirrors     []*MessageView
+	mirrorsLock sync.RWMutex
+
 	topicScreen    *mauview.ProxyScreen
 	contentScreen  *mauview.ProxyScreen
 	statusScreen   *mauview.ProxyScreen
@@ -63,7 +85,13 @@ type RoomView struct {
 	ulBorderScreen *mauview.ProxyScreen
 	ulScreen       *mauview.ProxyScreen
 
-	userListLoaded bool
+	predecessorScreen *mauview.ProxyScreen
+	successorScreen   *mauview.ProxyScreen
+	pinBannerScreen   *mauview.ProxyScreen
+	stickyScreen      *mauview.ProxyScreen
+
+	userListLoaded     bool
+	createEventFetched bool
 
 	prevScreen mauview.Screen
 
@@ -76,11 +104,38 @@ type RoomView struct {
 	selectReason  SelectReason
 	selectContent string
 
+	// The digits typed so far while the links in the timeline are numbered.
+	linkHintInput string
+	// Whether the vim-style modal input is in insert mode rather than normal mode.
+	vimInsert bool
+
 	replying *muksevt.Event
 
 	editing      *muksevt.Event
 	editMoveText string
 
+	// Whether the saved draft of the room has been loaded into the input area.
+	draftRestored bool
+
+	// The most recently sent message while it's held back for the undo send grace period.
+	undoSend struct {
+		lock    sync.Mutex
+		txnID   string
+		text    string
+		expires time.Time
+	}
+
+	// Uploads started with /upload that haven't finished yet.
+	uploads     []*mediaUpload
+	uploadsLock sync.Mutex
+
+	// Progress of a bulk redaction started with /redact --mine, --sender or --match.
+	redacting struct {
+		lock  sync.Mutex
+		done  int
+		total int
+	}
+
 	completions struct {
 		list      []string
 		textCache string
@@ -92,7 +147,6 @@ func NewRoomView(parent *MainView, room *rooms.Room) *RoomView {
 	view := &RoomView{
 		topic:    mauview.NewTextView(),
 		status:   mauview.NewTextField(),
-		userList: NewMemberList(),
 		ulBorder: widget.NewBorder(),
 		input:    mauview.NewInputArea(),
 		Room:     room,
@@ -104,30 +158,42 @@ func NewRoomView(parent *MainView, room *rooms.Room) *RoomView {
 		ulBorderScreen: &mauview.ProxyScreen{OffsetY: StatusBarHeight, Width: UserListBorderWidth},
 		ulScreen:       &mauview.ProxyScreen{OffsetY: StatusBarHeight, Width: UserListWidth},
 
+		predecessorScreen: &mauview.ProxyScreen{OffsetX: 0, OffsetY: TopicBarHeight, Height: BreadcrumbHeight},
+		successorScreen:   &mauview.ProxyScreen{OffsetX: 0, Height: BreadcrumbHeight},
+		pinBannerScreen:   &mauview.ProxyScreen{OffsetX: 0, Height: BreadcrumbHeight},
+		stickyScreen:      &mauview.ProxyScreen{OffsetX: 0},
+
 		parent: parent,
 		config: parent.config,
 	}
 	view.content = NewMessageView(view)
+	view.userList = NewMemberList(view)
+	view.predecessor = NewBreadcrumb(view, false)
+	view.successor = NewBreadcrumb(view, true)
+	view.pinBanner = NewPinBanner(view)
+	view.sticky = NewStickyMessage(view)
 	view.Room.SetPreUnload(func() bool {
-		if view.parent.currentRoom == view {
+		if view.parent.panes.Contains(view) {
 			return false
 		}
+		view.threadView = nil
+		view.threadRootMsg = nil
+		view.contextView = nil
 		view.content.Unload()
+		view.mirrorsLock.Lock()
+		view.mirrors = nil
+		view.mirrorsLock.Unlock()
 		return true
 	})
 	view.Room.SetPostLoad(view.loadTyping)
 
 	view.input.
 		SetBackgroundColor(tcell.ColorDefault).
-		SetPlaceholder("Send a message...").
 		SetPlaceholderTextColor(tcell.ColorGray).
 		SetTabCompleteFunc(view.InputTabComplete).
 		SetPressKeyUpAtStartFunc(view.EditPrevious).
 		SetPressKeyDownAtEndFunc(view.EditNext)
-
-	if room.Encrypted {
-		view.input.SetPlaceholder("Send an encrypted message...")
-	}
+	view.updatePlaceholder()
 
 	view.topic.
 		SetTextColor(tcell.ColorWhite).
@@ -183,6 +249,7 @@ func (view *RoomView) StartSelecting(reason SelectReason, content string) {
 func (view *RoomView) StopSelecting() {
 	view.selecting = false
 	view.selectContent = ""
+	view.clearSelectionRange()
 	view.MessageView().SetSelected(nil)
 }
 
@@ -199,7 +266,13 @@ func (view *RoomView) OnSelect(message *messages.UIMessage) {
 	case SelectEdit:
 		view.SetEditing(message.Event)
 	case SelectReact:
-		go view.SendReaction(message.EventID, view.selectContent)
+		if len(view.selectContent) == 0 {
+			view.parent.ShowModal(NewEmojiPickerModal(view.parent, func(emoji string) {
+				view.ToggleReaction(message, emoji)
+			}))
+		} else {
+			go view.ToggleReaction(message, view.selectContent)
+		}
 	case SelectRedact:
 		go view.Redact(message.EventID, view.selectContent)
 	case SelectDownload, SelectOpen:
@@ -209,37 +282,149 @@ func (view *RoomView) OnSelect(message *messages.UIMessage) {
 			if len(view.selectContent) > 0 {
 				path = view.selectContent
 			} else if view.selectReason == SelectDownload {
-				path = msg.Body
+				path = mediaFileName(msg)
+			}
+			if view.selectReason == SelectOpen {
+				go view.OpenMedia(msg, path)
+			} else {
+				go view.Download(msg.URL, msg.File, path)
 			}
-			go view.Download(msg.URL, msg.File, path, view.selectReason == SelectOpen)
+		}
+	case SelectPlay:
+		if msg, ok := message.Renderer.(*messages.FileMessage); ok {
+			go view.PlayAudio(msg)
+		}
+	case SelectVideoFrame:
+		if msg, ok := message.Renderer.(*messages.FileMessage); ok {
+			go view.extractVideoFrame(message, msg)
 		}
 	case SelectCopy:
 		msg, ok := message.Renderer.(*messages.TextMessage)
 		if ok {
 			go view.CopyToClipboard(msg.PlainText(), view.selectContent)
 		}
+	case SelectInspect:
+		view.parent.ShowModal(NewEventInspector(view.parent, message))
+	case SelectProfile:
+		if len(message.SenderID) > 0 {
+			view.parent.ShowModal(NewProfileModal(view.parent, view.Room, message.SenderID))
+		}
+	case SelectThread:
+		view.OpenThread(message)
+	case SelectEditHistory:
+		view.parent.ShowModal(NewEditHistoryModal(view.parent, view, message))
+	case SelectPermalink:
+		go view.CopyPermalink(message, view.selectContent)
+	case SelectGoto:
+		go view.FollowPermalink(message)
+	case SelectPin, SelectUnpin:
+		go view.SetPinned(message.EventID, view.selectReason == SelectPin)
+	case SelectRequestKeys:
+		go requestRoomKeys(view, message.Event)
+	case SelectVote:
+		go view.Vote(message, view.selectContent)
+	case SelectEndPoll:
+		go view.EndPoll(message)
+	case SelectSticky:
+		view.sticky.Set(message)
+	case SelectYank:
+		view.Yank(message, view.selectContent)
+	case SelectExpand:
+		if !view.MessageView().ToggleDetails(message) {
+			view.AddServiceMessage("That message doesn't have any collapsible sections.")
+		}
 	}
 	view.selecting = false
 	view.selectContent = ""
+	view.clearSelectionRange()
 	view.MessageView().SetSelected(nil)
 	view.input.Focus()
 }
 
+// sendsTyping returns whether typing notifications should be sent in this room, based on the
+// per-room override, or the global preference and the public room size limit.
+func (view *RoomView) sendsTyping() bool {
+	switch view.Room.TypingNotifs {
+	case rooms.TypingNotifsOn:
+		return true
+	case rooms.TypingNotifsOff:
+		return false
+	}
+	if view.config.Preferences.DisableTypingNotifs {
+		return false
+	}
+	if limit := view.config.TypingMaxMembers; limit > 0 && view.Room.GetMemberCount() > limit {
+		joinRules := view.Room.GetStateEvent(event.StateJoinRules, "")
+		if joinRules != nil && joinRules.Content.AsJoinRules().JoinRule == event.JoinRulePublic {
+			return false
+		}
+	}
+	return true
+}
+
 func (view *RoomView) GetStatus() string {
 	var buf strings.Builder
 
+	if mode := view.VimMode(); len(mode) > 0 {
+		buf.WriteString("-- ")
+		buf.WriteString(string(mode))
+		buf.WriteString(" -- ")
+	}
+
+	if view.Room.HasLeft {
+		buf.WriteString("Archived room, no longer synced - ")
+	}
+
+	if view.userList.IsFocused() {
+		buf.WriteString("Enter: profile, Tab: mention, Ctrl+D: DM, Ctrl+K: kick, Ctrl+B: ban - ")
+	}
+
 	if view.editing != nil {
 		buf.WriteString("Editing message - ")
 	} else if view.replying != nil {
 		buf.WriteString("Replying to ")
 		buf.WriteString(string(view.replying.Sender))
 		buf.WriteString(" - ")
+	} else if view.selecting && view.selectAnchor != nil {
+		buf.WriteString(fmt.Sprintf("Selecting %d messages to %s - ",
+			len(view.MessageView().selectionRange), view.selectReason))
 	} else if view.selecting {
 		buf.WriteString("Selecting message to ")
 		buf.WriteString(string(view.selectReason))
 		buf.WriteString(" - ")
 	}
 
+	if queued := view.parent.matrix.QueuedEvents(view.Room.ID); queued > 0 {
+		buf.WriteString(fmt.Sprintf("%d messages waiting to be sent", queued))
+		buf.WriteString(" - ")
+	}
+
+	if done, total := view.redactingProgress(); total > 0 {
+		buf.WriteString(fmt.Sprintf("Redacting messages (%d/%d)", done, total))
+		buf.WriteString(" - ")
+	}
+
+	if recording := view.parent.VoiceRecordingStatus(view); len(recording) > 0 {
+		buf.WriteString(recording)
+		buf.WriteString(" - ")
+	}
+
+	if playing := view.parent.audio.Status(); len(playing) > 0 {
+		buf.WriteString(playing)
+		buf.WriteString(" - ")
+	}
+
+	if upload := view.uploadStatus(); len(upload) > 0 {
+		buf.WriteString(upload)
+		buf.WriteString(" - ")
+	}
+
+	if remaining, ok := view.undoSendRemaining(""); ok {
+		remaining = remaining.Round(time.Second)
+		buf.WriteString(fmt.Sprintf("Alt+Z to undo send (%s)", remaining))
+		buf.WriteString(" - ")
+	}
+
 	if len(view.completions.list) > 0 {
 		if view.completions.textCache != view.input.GetText() || view.completions.time.Add(10*time.Second).Before(time.Now()) {
 			view.completions.list = []string{}
@@ -274,8 +459,9 @@ const (
 	UserListWidth         = 20
 	StaticHorizontalSpace = UserListBorderWidth + UserListWidth
 
-	TopicBarHeight  = 1
-	StatusBarHeight = 1
+	TopicBarHeight   = 1
+	StatusBarHeight  = 1
+	BreadcrumbHeight = 1
 
 	MaxInputHeight = 5
 )
@@ -293,6 +479,10 @@ func (view *RoomView) Draw(screen mauview.Screen) {
 		view.inputScreen.Parent = screen
 		view.ulBorderScreen.Parent = screen
 		view.ulScreen.Parent = screen
+		view.predecessorScreen.Parent = screen
+		view.successorScreen.Parent = screen
+		view.pinBannerScreen.Parent = screen
+		view.stickyScreen.Parent = screen
 		view.prevScreen = screen
 	}
 
@@ -308,23 +498,68 @@ func (view *RoomView) Draw(screen mauview.Screen) {
 	if view.config.Preferences.HideUserList {
 		contentWidth = width
 	}
+	hasPredecessor := len(view.predecessor.Target()) > 0
+	hasSuccessor := len(view.successor.Target()) > 0
+	contentOffset := TopicBarHeight
+	if hasPredecessor {
+		contentHeight -= BreadcrumbHeight
+		contentOffset += BreadcrumbHeight
+	}
+	if hasSuccessor {
+		contentHeight -= BreadcrumbHeight
+	}
+	hasPinBanner := view.pinBanner.Visible()
+	view.pinBannerScreen.OffsetY = contentOffset
+	if hasPinBanner {
+		contentHeight -= BreadcrumbHeight
+		contentOffset += BreadcrumbHeight
+	}
+	// The sticky message can take up to a third of the timeline.
+	stickyHeight := view.sticky.Height(contentWidth, contentHeight/3)
+	view.stickyScreen.OffsetY = contentOffset
+	view.stickyScreen.Width = contentWidth
+	view.stickyScreen.Height = stickyHeight
+	contentHeight -= stickyHeight
+	contentOffset += stickyHeight
 
 	view.topicScreen.Width = width
+	view.predecessorScreen.Width = width
+	view.pinBannerScreen.Width = width
+	view.contentScreen.OffsetY = contentOffset
 	view.contentScreen.Width = contentWidth
 	view.contentScreen.Height = contentHeight
+	view.successorScreen.OffsetY = view.contentScreen.YEnd()
+	view.successorScreen.Width = width
 	view.statusScreen.OffsetY = view.contentScreen.YEnd()
+	if hasSuccessor {
+		view.statusScreen.OffsetY = view.successorScreen.YEnd()
+	}
 	view.statusScreen.Width = width
 	view.inputScreen.Width = width
 	view.inputScreen.OffsetY = view.statusScreen.YEnd()
 	view.inputScreen.Height = inputHeight
 	view.ulBorderScreen.OffsetX = view.contentScreen.XEnd()
+	view.ulBorderScreen.OffsetY = contentOffset
 	view.ulBorderScreen.Height = contentHeight
 	view.ulScreen.OffsetX = view.ulBorderScreen.XEnd()
+	view.ulScreen.OffsetY = contentOffset
 	view.ulScreen.Height = contentHeight
 
 	// Draw everything
 	view.topic.Draw(view.topicScreen)
-	view.content.Draw(view.contentScreen)
+	if hasPredecessor {
+		view.predecessor.Draw(view.predecessorScreen)
+	}
+	if hasPinBanner {
+		view.pinBanner.Draw(view.pinBannerScreen)
+	}
+	if stickyHeight > 0 {
+		view.sticky.Draw(view.stickyScreen)
+	}
+	view.MessageView().Draw(view.contentScreen)
+	if hasSuccessor {
+		view.successor.Draw(view.successorScreen)
+	}
 	view.status.SetText(view.GetStatus())
 	view.status.Draw(view.statusScreen)
 	view.input.Draw(view.inputScreen)
@@ -341,8 +576,32 @@ func (view *RoomView) ClearAllContext() {
 	view.input.Focus()
 }
 
+// FocusMemberList moves keyboard focus to the member list, where typing filters members by name.
+func (view *RoomView) FocusMemberList() {
+	if view.config.Preferences.HideUserList {
+		return
+	}
+	view.input.Blur()
+	view.userList.Focus()
+}
+
+// BlurMemberList returns keyboard focus from the member list to the input field.
+func (view *RoomView) BlurMemberList() {
+	view.userList.Blur()
+	view.input.Focus()
+}
+
 func (view *RoomView) OnKeyEvent(event mauview.KeyEvent) bool {
 	msgView := view.MessageView()
+	if view.userList.IsFocused() {
+		return view.userList.OnKeyEvent(event)
+	}
+	if msgView.LinkHints && view.onLinkHintKey(event) {
+		return true
+	}
+	if !view.selecting && view.onVimKey(event) {
+		return true
+	}
 	if view.selecting {
 		k := event.Key()
 		c := event.Rune()
@@ -355,6 +614,20 @@ func (view *RoomView) OnKeyEvent(event mauview.KeyEvent) bool {
 			view.SelectNext()
 		case k == tcell.KeyEnter || c == 'l':
 			view.OnSelect(msgView.selected)
+		case c == 'e' && msgView.selected != nil:
+			view.selectReason = SelectEditHistory
+			view.OnSelect(msgView.selected)
+		case c == 's' && msgView.selected != nil:
+			if !msgView.ToggleSpoilers(msgView.selected) {
+				view.AddServiceMessage("That message doesn't have any spoilers.")
+			}
+		case c == 'v' && msgView.selected != nil:
+			view.selectReason = SelectInspect
+			view.OnSelect(msgView.selected)
+		case c == 'V' && view.selectReason == SelectYank:
+			view.ToggleVisualSelection()
+		case c == 'y' && view.selectReason == SelectYank:
+			view.OnSelect(msgView.selected)
 		default:
 			return false
 		}
@@ -362,7 +635,13 @@ func (view *RoomView) OnKeyEvent(event mauview.KeyEvent) bool {
 	}
 	switch event.Key() {
 	case tcell.KeyEscape:
-		view.ClearAllContext()
+		if view.contextView != nil && view.editing == nil && view.replying == nil {
+			view.CloseContext()
+		} else if view.threadView != nil && view.editing == nil && view.replying == nil {
+			view.CloseThread()
+		} else {
+			view.ClearAllContext()
+		}
 		return true
 	case tcell.KeyPgUp:
 		if msgView.IsAtTop() {
@@ -383,15 +662,28 @@ func (view *RoomView) OnKeyEvent(event mauview.KeyEvent) bool {
 }
 
 func (view *RoomView) OnPasteEvent(event mauview.PasteEvent) bool {
+	// Terminals paste nothing when the clipboard only contains an image, so try to upload the image instead.
+	if len(event.Text()) == 0 {
+		go view.PasteClipboardImage()
+		return true
+	}
 	return view.input.OnPasteEvent(event)
 }
 
 func (view *RoomView) OnMouseEvent(event mauview.MouseEvent) bool {
 	switch {
 	case view.contentScreen.IsInArea(event.Position()):
-		return view.content.OnMouseEvent(view.contentScreen.OffsetMouseEvent(event))
+		return view.MessageView().OnMouseEvent(view.contentScreen.OffsetMouseEvent(event))
 	case view.topicScreen.IsInArea(event.Position()):
 		return view.topic.OnMouseEvent(view.topicScreen.OffsetMouseEvent(event))
+	case len(view.predecessor.Target()) > 0 && view.predecessorScreen.IsInArea(event.Position()):
+		return view.predecessor.OnMouseEvent(view.predecessorScreen.OffsetMouseEvent(event))
+	case len(view.successor.Target()) > 0 && view.successorScreen.IsInArea(event.Position()):
+		return view.successor.OnMouseEvent(view.successorScreen.OffsetMouseEvent(event))
+	case view.pinBanner.Visible() && view.pinBannerScreen.IsInArea(event.Position()):
+		return view.pinBanner.OnMouseEvent(view.pinBannerScreen.OffsetMouseEvent(event))
+	case view.stickyScreen.Height > 0 && view.stickyScreen.IsInArea(event.Position()):
+		return view.sticky.OnMouseEvent(view.stickyScreen.OffsetMouseEvent(event))
 	case view.inputScreen.IsInArea(event.Position()):
 		return view.input.OnMouseEvent(view.inputScreen.OffsetMouseEvent(event))
 	}
@@ -465,6 +757,21 @@ func (view *RoomView) filterMediaOnly(evt *muksevt.Event) bool {
 		content.MsgType == event.MsgVideo)
 }
 
+func (view *RoomView) filterAudioOnly(evt *muksevt.Event) bool {
+	content, ok := evt.Content.Parsed.(*event.MessageEventContent)
+	return ok && content.MsgType == event.MsgAudio
+}
+
+func (view *RoomView) filterVideoOnly(evt *muksevt.Event) bool {
+	content, ok := evt.Content.Parsed.(*event.MessageEventContent)
+	return ok && content.MsgType == event.MsgVideo
+}
+
+func (view *RoomView) filterUndecryptable(evt *muksevt.Event) bool {
+	_, ok := evt.Content.Parsed.(*muksevt.BadEncryptedContent)
+	return ok
+}
+
 func (view *RoomView) findMessage(current *muksevt.Event, forward bool, allow findFilter) *messages.UIMessage {
 	currentFound := current == nil
 	msgs := view.MessageView().messages
@@ -513,10 +820,17 @@ func (view *RoomView) SelectNext() {
 	var filter findFilter
 	if view.selectReason == SelectDownload || view.selectReason == SelectOpen {
 		filter = view.filterMediaOnly
+	} else if view.selectReason == SelectPlay {
+		filter = view.filterAudioOnly
+	} else if view.selectReason == SelectVideoFrame {
+		filter = view.filterVideoOnly
+	} else if view.selectReason == SelectRequestKeys {
+		filter = view.filterUndecryptable
 	}
 	foundMsg := view.findMessage(msgView.selected.GetEvent(), true, filter)
 	if foundMsg != nil {
 		msgView.SetSelected(foundMsg)
+		view.updateSelectionRange()
 		// TODO scroll selected message into view
 	}
 }
@@ -526,10 +840,17 @@ func (view *RoomView) SelectPrevious() {
 	var filter findFilter
 	if view.selectReason == SelectDownload || view.selectReason == SelectOpen {
 		filter = view.filterMediaOnly
+	} else if view.selectReason == SelectPlay {
+		filter = view.filterAudioOnly
+	} else if view.selectReason == SelectVideoFrame {
+		filter = view.filterVideoOnly
+	} else if view.selectReason == SelectRequestKeys {
+		filter = view.filterUndecryptable
 	}
 	foundMsg := view.findMessage(msgView.selected.GetEvent(), false, filter)
 	if foundMsg != nil {
 		msgView.SetSelected(foundMsg)
+		view.updateSelectionRange()
 		// TODO scroll selected message into view
 	}
 }
@@ -541,16 +862,23 @@ type completion struct {
 
 func (view *RoomView) AutocompleteUser(existingText string) (completions []completion) {
 	textWithoutPrefix := strings.TrimPrefix(existingText, "@")
+	var exactMatches []completion
 	for userID, user := range view.Room.GetMembers() {
-		if user.Displayname == textWithoutPrefix || string(userID) == existingText {
-			// Exact match, return that.
+		if string(userID) == existingText {
+			// Exact user ID match, return that.
 			return []completion{{user.Displayname, string(userID)}}
+		} else if user.Displayname == textWithoutPrefix {
+			exactMatches = append(exactMatches, completion{user.Displayname, string(userID)})
 		}
 
 		if strings.HasPrefix(user.Displayname, textWithoutPrefix) || strings.HasPrefix(string(userID), existingText) {
 			completions = append(completions, completion{user.Displayname, string(userID)})
 		}
 	}
+	if len(exactMatches) > 0 {
+		// Exact displayname match, return that (or all of them if the displayname is ambiguous).
+		return exactMatches
+	}
 	return
 }
 
@@ -587,9 +915,26 @@ func (view *RoomView) AutocompleteEmoji(word string) (completions []string) {
 			}
 		}
 	}
-	if !manyValues && len(completions) > 0 {
+	custom := view.autocompleteCustomEmote(word)
+	if !manyValues && len(completions) > 0 && len(custom) == 0 {
 		return []string{emoji.CodeMap()[completions[0]]}
 	}
+	return append(completions, custom...)
+}
+
+// autocompleteCustomEmote completes the shortcodes of the custom emoticons available in the room.
+// The shortcodes are replaced with the images when sending.
+func (view *RoomView) autocompleteCustomEmote(word string) (completions []string) {
+	seen := make(map[string]bool)
+	for _, pack := range view.parent.matrix.GetEmotePacks(view.Room.ID) {
+		for _, emote := range pack.Emotes {
+			name := ":" + emote.Shortcode + ":"
+			if emote.Emoticon && !seen[name] && strings.HasPrefix(name, word) {
+				seen[name] = true
+				completions = append(completions, name)
+			}
+		}
+	}
 	return
 }
 
@@ -611,6 +956,29 @@ var (
 	mentionPlaintext = "%[1]s"
 )
 
+// InsertMention adds a mention of the given user to the end of the input field.
+func (view *RoomView) InsertMention(userID id.UserID, displayname string) {
+	template := mentionMarkdown
+	if view.config.Preferences.DisableMarkdown {
+		if view.config.Preferences.DisableHTML {
+			template = mentionPlaintext
+		} else {
+			template = mentionHTML
+		}
+	}
+	mention := fmt.Sprintf(template, displayname, userID)
+	text := view.input.GetText()
+	if len(text) == 0 {
+		mention += ": "
+	} else {
+		if !strings.HasSuffix(text, " ") {
+			text += " "
+		}
+		mention += " "
+	}
+	view.SetInputText(text + mention)
+}
+
 func (view *RoomView) defaultAutocomplete(word string, startIndex int) (strCompletions []string, strCompletion string) {
 	if len(word) == 0 {
 		return []string{}, ""
@@ -634,8 +1002,18 @@ func (view *RoomView) defaultAutocomplete(word string, startIndex int) (strCompl
 			strCompletion = strCompletion + ":"
 		}
 	} else if len(completions) > 1 {
+		displaynameCounts := make(map[string]int, len(completions))
 		for _, completion := range completions {
-			strCompletions = append(strCompletions, completion.displayName)
+			displaynameCounts[completion.displayName]++
+		}
+		for _, completion := range completions {
+			if displaynameCounts[completion.displayName] > 1 || view.Room.IsAmbiguousDisplayname(completion.displayName) {
+				// Multiple members share this displayname, so offer their user IDs instead
+				// to let the user pick the correct one.
+				strCompletions = append(strCompletions, completion.id)
+			} else {
+				strCompletions = append(strCompletions, completion.displayName)
+			}
 		}
 	}
 
@@ -683,6 +1061,10 @@ func (view *RoomView) InputSubmit(text string) {
 		return
 	} else if cmd := view.parent.cmdProcessor.ParseCommand(view, text); cmd != nil {
 		go view.parent.cmdProcessor.HandleCommand(cmd)
+	} else if view.Room.HasLeft {
+		view.AddServiceMessage("This room is archived and read-only")
+		view.parent.parent.Render()
+		return
 	} else {
 		go view.SendMessage(event.MsgText, text)
 	}
@@ -692,7 +1074,7 @@ func (view *RoomView) InputSubmit(text string) {
 
 func (view *RoomView) CopyToClipboard(text string, register string) {
 	if register == "clipboard" || register == "primary" {
-		err := clipboard.WriteAll(text, register)
+		err := writeClipboard(text, register)
 		if err != nil {
 			view.AddServiceMessage(fmt.Sprintf("Clipboard unsupported: %v", err))
 			view.parent.parent.Render()
@@ -703,18 +1085,59 @@ func (view *RoomView) CopyToClipboard(text string, register string) {
 	}
 }
 
-func (view *RoomView) Download(url id.ContentURI, file *attachment.EncryptedFile, filename string, openFile bool) {
+func (view *RoomView) Download(url id.ContentURI, file *attachment.EncryptedFile, filename string) {
 	path, err := view.parent.matrix.DownloadToDisk(url, file, filename)
-	if err != nil {
+	if errors.Is(err, context.Canceled) {
+		view.AddServiceMessage("Download cancelled. Retry it in /downloads to continue where it stopped.")
+		view.parent.parent.Render()
+		return
+	} else if err != nil {
 		view.AddServiceMessage(fmt.Sprintf("Failed to download media: %v", err))
 		view.parent.parent.Render()
 		return
 	}
 	view.AddServiceMessage(fmt.Sprintf("File downloaded to %s", path))
 	view.parent.parent.Render()
-	if openFile {
-		debug.Print("Opening file", path)
-		open.Open(path)
+}
+
+// mediaFileName returns a file name for saving the file in the given message. The name in the message
+// is chosen by the sender, so only its last element is used, which can't point outside the target directory.
+func mediaFileName(msg *messages.FileMessage) string {
+	name := filepath.Base(msg.Body)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		name = msg.URL.FileID
+	}
+	return name
+}
+
+// mediaTempPath returns the path in the temporary directory where the file in the given message is saved
+// for opening or playing it.
+func mediaTempPath(msg *messages.FileMessage) string {
+	return filepath.Join(os.TempDir(), "gomuks-media", msg.URL.FileID, mediaFileName(msg))
+}
+
+// OpenMedia downloads and decrypts the file in the given message and opens it with the program configured
+// for its MIME type. If no path is given, the file is saved in a temporary directory with its original name.
+func (view *RoomView) OpenMedia(msg *messages.FileMessage, filename string) {
+	defer debug.Recover()
+	if len(filename) == 0 {
+		filename = mediaTempPath(msg)
+	}
+	path, err := view.parent.matrix.DownloadToDisk(msg.URL, msg.File, filename)
+	if err != nil {
+		view.AddServiceMessage(fmt.Sprintf("Failed to download media: %v", err))
+		view.parent.parent.Render()
+		return
+	}
+	mimetype := msg.MimeType
+	if len(mimetype) == 0 {
+		mimetype = mime.TypeByExtension(filepath.Ext(path))
+	}
+	command := view.config.MediaViewers.GetCommand(mimetype)
+	debug.Printf("Opening %s (%s) with %v", path, mimetype, command)
+	if err = open.OpenWith(command, path); err != nil {
+		view.AddServiceMessage(fmt.Sprintf("Failed to open %s: %v", path, err))
+		view.parent.parent.Render()
 	}
 }
 
@@ -733,6 +1156,22 @@ func (view *RoomView) Redact(eventID id.EventID, reason string) {
 	}
 }
 
+// ToggleReaction removes the user's reaction with the given key from the message
+// if there is one, and sends a new reaction otherwise.
+func (view *RoomView) ToggleReaction(message *messages.UIMessage, reaction string) {
+	defer debug.Recover()
+	if _, ok := message.Event.Gomuks.OwnReactions[reaction]; !ok {
+		view.SendReaction(message.EventID, reaction)
+		return
+	}
+	debug.Print("Removing reaction", reaction, "from", message.EventID, "in", view.Room.ID)
+	err := view.parent.matrix.RedactReaction(view.Room.ID, message.EventID, reaction)
+	if err != nil {
+		view.AddServiceMessage(fmt.Sprintf("Failed to remove reaction: %v", err))
+		view.parent.parent.Render()
+	}
+}
+
 func (view *RoomView) SendReaction(eventID id.EventID, reaction string) {
 	defer debug.Recover()
 	debug.Print("Reacting to", eventID, "in", view.Room.ID, "with", reaction)
@@ -769,6 +1208,19 @@ func (view *RoomView) getRelationForNewEvent() *ifc.Relation {
 			Type:  event.RelReplace,
 			Event: view.editing,
 		}
+	} else if view.threadView != nil && view.replying != nil {
+		return &ifc.Relation{
+			Type:      muksevt.RelThread,
+			Event:     view.threadRootEvent(),
+			InReplyTo: view.replying,
+		}
+	} else if view.threadView != nil {
+		return &ifc.Relation{
+			Type:          muksevt.RelThread,
+			Event:         view.threadRootEvent(),
+			InReplyTo:     view.threadFallbackEvent(),
+			FallbackReply: true,
+		}
 	} else if view.replying != nil {
 		return &ifc.Relation{
 			Type:  event.RelReply,
@@ -785,50 +1237,231 @@ func (view *RoomView) SendMessageHTML(msgtype event.MessageType, text, html stri
 		text = emoji.Sprint(text)
 	}
 	rel := view.getRelationForNewEvent()
-	evt := view.parent.matrix.PrepareMarkdownMessage(view.Room.ID, msgtype, text, html, rel)
-	view.addLocalEcho(evt)
+	var evt *muksevt.Event
+	if len(view.Room.TranslateTo) > 0 && len(html) == 0 && (msgtype == event.MsgText || msgtype == event.MsgEmote) {
+		var err error
+		evt, err = view.parent.matrix.PrepareTranslatedMessage(view.Room, msgtype, text, rel)
+		if err != nil {
+			view.AddServiceMessage(fmt.Sprintf("Failed to translate message, it was not sent: %v", err))
+			if len(view.input.GetText()) == 0 {
+				view.SetInputText(text)
+			}
+			view.parent.parent.Render()
+			return
+		}
+	} else {
+		evt = view.parent.matrix.PrepareMarkdownMessage(view.Room.ID, msgtype, text, html, rel)
+	}
+	restoreText := ""
+	if msgtype == event.MsgText && rel == nil {
+		restoreText = text
+	}
+	view.addLocalEcho(evt, restoreText)
+}
+
+// ScheduleMessage prepares a text message like SendMessage, but sends it at the given time instead of right away.
+func (view *RoomView) ScheduleMessage(text string, sendAt time.Time) {
+	if !view.config.Preferences.DisableEmojis {
+		text = emoji.Sprint(text)
+	}
+	evt := view.parent.matrix.PrepareMarkdownMessage(view.Room.ID, event.MsgText, text, "", nil)
+	view.parent.matrix.ScheduleEvent(evt, sendAt, false)
 }
 
 func (view *RoomView) SendMessageMedia(path string) {
 	defer debug.Recover()
 	debug.Print("Sending media at", path, "to", view.Room.ID)
 	rel := view.getRelationForNewEvent()
-	evt, err := view.parent.matrix.PrepareMediaMessage(view.Room, path, rel)
-	if err != nil {
+	upload, ctx := view.startUpload(filepath.Base(path))
+	evt, err := view.parent.matrix.PrepareMediaMessage(ctx, view.Room, path, rel, func(sent, total int64) {
+		view.setUploadProgress(upload, sent, total)
+	})
+	view.finishUpload(upload)
+	if errors.Is(err, context.Canceled) {
+		view.AddServiceMessage(fmt.Sprintf("Upload of %s cancelled", upload.name))
+		view.parent.parent.Render()
+		return
+	} else if err != nil {
 		view.AddServiceMessage(fmt.Sprintf("Failed to upload media: %v", err))
 		view.parent.parent.Render()
 		return
 	}
-	view.addLocalEcho(evt)
+	view.addLocalEcho(evt, "")
+}
+
+func (view *RoomView) SendLocation(geoURI, description string) {
+	defer debug.Recover()
+	debug.Print("Sending location", geoURI, "to", view.Room.ID)
+	evt := view.parent.matrix.PrepareLocationMessage(view.Room.ID, geoURI, description, view.getRelationForNewEvent())
+	view.addLocalEcho(evt, "")
 }
 
-func (view *RoomView) addLocalEcho(evt *muksevt.Event) {
+// addLocalEcho shows the event in the timeline and sends it, or holds it back for the undo send grace period
+// if one is configured. restoreText is put back into the input area if the send is undone.
+func (view *RoomView) addLocalEcho(evt *muksevt.Event, restoreText string) {
+	view.CloseContext()
 	msg := view.parseEvent(evt.SomewhatDangerousCopy())
 	view.content.AddMessage(msg, AppendMessage)
+	view.addToMirrors(msg, AppendMessage)
 	view.ClearAllContext()
+	if view.config.UndoSendSeconds > 0 {
+		window := time.Duration(view.config.UndoSendSeconds) * time.Second
+		view.parent.matrix.ScheduleEvent(evt, time.Now().Add(window), true)
+		view.startUndoSendWindow(evt.Unsigned.TransactionID, restoreText, window)
+		view.status.SetText(view.GetStatus())
+		view.parent.parent.Render()
+		return
+	}
 	view.status.SetText(view.GetStatus())
 	eventID, err := view.parent.matrix.SendEvent(evt)
+	if errors.Is(err, ifc.ErrEventQueued) {
+		// The local echo stays pending until the send queue reports back through QueuedEventDone.
+		if view.parent.matrix.RemoteServerUnreachable(view.Room.ID) {
+			view.setDeliveryDelayed(evt.Unsigned.TransactionID, true)
+		}
+		view.status.SetText(view.GetStatus())
+		view.parent.parent.Render()
+		return
+	}
+	view.finishLocalEcho(msg, eventID, err)
+	view.parent.parent.Render()
+}
+
+func (view *RoomView) finishLocalEcho(msg *messages.UIMessage, eventID id.EventID, err error) {
+	view.setDeliveryDelayed(msg.TxnID, false)
 	if err != nil {
 		msg.State = muksevt.StateSendFail
+		echoes, _ := view.messageInTimelines(id.EventID(msg.TxnID))
+		for _, echo := range echoes {
+			echo.State = muksevt.StateSendFail
+		}
 		// Show shorter version if available
-		if httpErr, ok := err.(mautrix.HTTPError); ok {
+		var httpErr mautrix.HTTPError
+		if errors.As(err, &httpErr) {
 			err = httpErr
 			if respErr := httpErr.RespError; respErr != nil {
 				err = respErr
 			}
 		}
 		view.AddServiceMessage(fmt.Sprintf("Failed to send message: %v", err))
-		view.parent.parent.Render()
 	} else {
 		debug.Print("Event ID received:", eventID)
 		msg.EventID = eventID
 		msg.State = muksevt.StateDefault
-		view.MessageView().setMessageID(msg)
-		view.parent.parent.Render()
+		for _, msgView := range view.timelines() {
+			if echo := msgView.getMessageByID(id.EventID(msg.TxnID)); echo != nil {
+				echo.EventID = eventID
+				echo.State = muksevt.StateDefault
+				msgView.setMessageID(echo)
+			}
+		}
+	}
+}
+
+// QueuedEventDone is called when an event from the offline send queue has been sent or has failed permanently.
+func (view *RoomView) QueuedEventDone(txnID string, eventID id.EventID, err error) {
+	msgs, _ := view.messageInTimelines(id.EventID(txnID))
+	if len(msgs) == 0 {
+		// The remote echo may have already replaced the local echo.
+		return
+	}
+	view.finishLocalEcho(msgs[0], eventID, err)
+	view.status.SetText(view.GetStatus())
+}
+
+// QueuedEventDelayed is called when an event from the offline send queue failed to send because
+// the other server of the DM seems to be unreachable.
+func (view *RoomView) QueuedEventDelayed(txnID string) {
+	view.setDeliveryDelayed(txnID, true)
+}
+
+// setDeliveryDelayed shows or hides the delayed delivery warning under the local echo with the given transaction ID.
+func (view *RoomView) setDeliveryDelayed(txnID string, delayed bool) {
+	echoes, _ := view.messageInTimelines(id.EventID(txnID))
+	for _, echo := range echoes {
+		if echo.DeliveryDelayed != delayed {
+			echo.DeliveryDelayed = delayed
+			view.recalculateMessage(echo)
+		}
+	}
+}
+
+func (view *RoomView) startUndoSendWindow(txnID, restoreText string, window time.Duration) {
+	view.undoSend.lock.Lock()
+	view.undoSend.txnID = txnID
+	view.undoSend.text = restoreText
+	view.undoSend.expires = time.Now().Add(window)
+	view.undoSend.lock.Unlock()
+	// Re-render every second so the countdown in the status bar stays up to date.
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			view.parent.parent.Render()
+			if _, ok := view.undoSendRemaining(txnID); !ok {
+				return
+			}
+		}
+	}()
+}
+
+// undoSendRemaining returns how much of the undo send grace period is left. If txnID is set,
+// the grace period must also be for that message.
+func (view *RoomView) undoSendRemaining(txnID string) (time.Duration, bool) {
+	view.undoSend.lock.Lock()
+	defer view.undoSend.lock.Unlock()
+	remaining := time.Until(view.undoSend.expires)
+	if len(view.undoSend.txnID) == 0 || remaining <= 0 || (len(txnID) > 0 && view.undoSend.txnID != txnID) {
+		return 0, false
+	}
+	return remaining, true
+}
+
+// UndoSend cancels the most recently sent message if it's still being held back for the undo send grace period.
+// The local echo is removed and the text is put back into the input area if the input is empty.
+func (view *RoomView) UndoSend() bool {
+	view.undoSend.lock.Lock()
+	txnID, text := view.undoSend.txnID, view.undoSend.text
+	active := len(txnID) > 0 && time.Now().Before(view.undoSend.expires)
+	view.undoSend.txnID = ""
+	view.undoSend.lock.Unlock()
+	if !active || !view.parent.matrix.CancelScheduledEvent(txnID) {
+		return false
 	}
+	view.removeLocalEcho(txnID)
+	if len(text) > 0 && len(view.input.GetText()) == 0 {
+		view.input.SetText(text)
+	}
+	view.status.SetText(view.GetStatus())
+	return true
+}
+
+// DiscardQueued removes a message that is waiting in the offline send queue without sending it.
+func (view *RoomView) DiscardQueued(txnID string) bool {
+	if !view.parent.matrix.DiscardQueuedEvent(txnID) {
+		return false
+	}
+	view.removeLocalEcho(txnID)
+	view.status.SetText(view.GetStatus())
+	return true
 }
 
+func (view *RoomView) removeLocalEcho(txnID string) {
+	for _, msgView := range view.timelines() {
+		if msg := msgView.getMessageByID(id.EventID(txnID)); msg != nil {
+			msgView.removeMessage(msg)
+		}
+	}
+}
+
+// MessageView returns the message view that is currently shown, which is the permalink context
+// or the open thread if there is one.
 func (view *RoomView) MessageView() *MessageView {
+	if view.contextView != nil {
+		return view.contextView
+	} else if view.threadView != nil {
+		return view.threadView
+	}
 	return view.content
 }
 
@@ -837,10 +1470,21 @@ func (view *RoomView) MxRoom() *rooms.Room {
 }
 
 func (view *RoomView) Update() {
-	view.topic.SetText(strings.Replace(view.Room.GetTopic(), "\n", " ", -1))
+	if view.contextView != nil {
+		view.topic.SetText("Viewing messages around a link (Esc to return to the timeline)")
+	} else if view.threadView != nil {
+		view.topic.SetText(view.threadTopic())
+	} else {
+		view.topic.SetText(strings.Replace(view.Room.GetTopic(), "\n", " ", -1))
+	}
 	if !view.userListLoaded {
 		view.UpdateUserList()
 	}
+	view.updatePlaceholder()
+	if !view.createEventFetched && view.Room.GetStateEvent(event.StateCreate, "") == nil {
+		view.createEventFetched = true
+		go view.fetchCreateEvent()
+	}
 }
 
 func (view *RoomView) UpdateUserList() {
@@ -853,22 +1497,145 @@ func (view *RoomView) UpdateUserList() {
 }
 
 func (view *RoomView) AddServiceMessage(text string) {
-	view.content.AddMessage(messages.NewServiceMessage(text), AppendMessage)
+	view.MessageView().AddMessage(messages.NewServiceMessage(text), AppendMessage)
+}
+
+// imageSize returns the size limits of inline images in this room.
+func (view *RoomView) imageSize() messages.ImageSize {
+	return messages.ImageSize{Scale: view.Room.ImageScale, MaxRows: view.Room.MaxImageRows}
+}
+
+func hasImage(msg *messages.UIMessage) bool {
+	switch msg.Renderer.(type) {
+	case *messages.FileMessage, *messages.LocationMessage:
+		return true
+	default:
+		return false
+	}
+}
+
+// applyImageSize re-renders the loaded messages with inline images after the image size limits of the room have changed.
+func (view *RoomView) applyImageSize() {
+	size := view.imageSize()
+	for _, msgView := range view.timelines() {
+		msgView.messagesLock.RLock()
+		msgs := make([]*messages.UIMessage, len(msgView.messages))
+		copy(msgs, msgView.messages)
+		msgView.messagesLock.RUnlock()
+		for _, msg := range msgs {
+			msg := msg
+			setSize := func() {
+				msg.ImageSize = size
+				if msg.ReplyTo != nil {
+					msg.ReplyTo.ImageSize = size
+				}
+			}
+			if hasImage(msg) || (msg.ReplyTo != nil && hasImage(msg.ReplyTo)) {
+				msgView.updateMessage(msg, setSize)
+			} else {
+				setSize()
+			}
+		}
+	}
 }
 
 func (view *RoomView) parseEvent(evt *muksevt.Event) *messages.UIMessage {
-	return messages.ParseEvent(view.parent.matrix, view.parent, view.Room, evt)
+	msg := messages.ParseEvent(view.parent.matrix, view.parent, view.Room, evt)
+	if msg != nil {
+		msg.ImageSize = view.imageSize()
+		if msg.ReplyTo != nil {
+			msg.ReplyTo.ImageSize = msg.ImageSize
+		}
+	}
+	if msg != nil && evt.Gomuks.Encryption != nil && view.config.TrustShields != config.TrustShieldsHidden {
+		msg.Trust = getTrustLevel(view.parent.matrix, evt)
+	}
+	if msg != nil {
+		msg.SenderName = view.config.StripBridgeName(msg.SenderName)
+		if msg.ReplyTo != nil {
+			msg.ReplyTo.SenderName = view.config.StripBridgeName(msg.ReplyTo.SenderName)
+		}
+		if file, ok := msg.Renderer.(*messages.FileMessage); ok && file.IsPlaceholder() {
+			go view.loadPreview(msg, file)
+		}
+		if view.showsURLPreviews() {
+			if link := findPreviewableURL(evt); len(link) > 0 {
+				if preview := view.parent.matrix.CachedURLPreview(link); preview != nil {
+					// Cached previews are shown right away instead of changing the message after it's been added.
+					msg.URLPreview = newURLPreviewCard(preview)
+				} else {
+					go view.loadURLPreview(msg, link)
+				}
+			}
+		}
+	}
+	return msg
+}
+
+// loadPreview downloads the real preview of a message that is showing a blurhash placeholder.
+func (view *RoomView) loadPreview(msg *messages.UIMessage, file *messages.FileMessage) {
+	defer debug.Recover()
+	file.DownloadPreview()
+	if file.IsPlaceholder() {
+		return
+	}
+	view.recalculateMessage(msg)
+	view.parent.parent.Render()
+}
+
+// extractVideoFrame shows a preview frame extracted with ffmpeg in a video message that has no thumbnail.
+func (view *RoomView) extractVideoFrame(msg *messages.UIMessage, file *messages.FileMessage) {
+	defer debug.Recover()
+	view.AddServiceMessage("Extracting preview frame...")
+	view.parent.parent.Render()
+	if err := file.ExtractVideoFrame(); err != nil {
+		view.AddServiceMessage(fmt.Sprintf("Failed to extract preview frame: %v", err))
+	} else {
+		view.recalculateMessage(msg)
+	}
+	view.parent.parent.Render()
+}
+
+// recalculateMessage updates the buffer of a message after its content has changed in the background.
+func (view *RoomView) recalculateMessage(msg *messages.UIMessage) {
+	for _, msgView := range view.timelines() {
+		if msgView.getMessageByID(msg.ID()) == msg {
+			msg.CalculateBuffer(msgView.prevPrefs, msgView.prevWidth())
+			msgView.replaceBuffer(msg, msg)
+		}
+	}
+}
+
+// updateMessage changes a message in the background and updates its buffer in the timeline it's in.
+func (view *RoomView) updateMessage(msg *messages.UIMessage, update func()) {
+	for _, msgView := range view.timelines() {
+		if msgView.getMessageByID(msg.ID()) == msg {
+			msgView.updateMessage(msg, update)
+			return
+		}
+	}
+	// The message hasn't been added to a timeline yet, so the buffer will be calculated when it is.
+	update()
 }
 
 func (view *RoomView) AddHistoryEvent(evt *muksevt.Event) {
 	if msg := view.parseEvent(evt); msg != nil {
 		view.content.AddMessage(msg, PrependMessage)
+		view.addToMirrors(msg, PrependMessage)
 	}
 }
 
 func (view *RoomView) AddEvent(evt *muksevt.Event) ifc.Message {
 	if msg := view.parseEvent(evt); msg != nil {
 		view.content.AddMessage(msg, AppendMessage)
+		view.addToMirrors(msg, AppendMessage)
+		if view.userListLoaded {
+			if evt.Type == event.StateMember || evt.Type == event.StatePowerLevels {
+				view.UpdateUserList()
+			} else {
+				view.userList.UpdateActivity(evt.Sender, msg.Time())
+			}
+		}
 		return msg
 	}
 	return nil
@@ -876,27 +1643,30 @@ func (view *RoomView) AddEvent(evt *muksevt.Event) ifc.Message {
 
 func (view *RoomView) AddRedaction(redactedEvt *muksevt.Event) {
 	view.AddEvent(redactedEvt)
+	view.updateOtherTimelines(redactedEvt)
 }
 
 func (view *RoomView) AddEdit(evt *muksevt.Event) {
 	if msg := view.parseEvent(evt); msg != nil {
 		view.content.AddMessage(msg, IgnoreMessage)
 	}
+	view.updateOtherTimelines(evt)
 }
 
 func (view *RoomView) AddReaction(evt *muksevt.Event, key string) {
-	msgView := view.MessageView()
-	msg := msgView.getMessageByID(evt.ID)
-	if msg == nil {
-		// Message not in view, nothing to do
-		return
-	}
-	recalculate := len(msg.Reactions) == 0
-	msg.AddReaction(key)
-	if recalculate {
-		// Recalculate height for message
-		msg.CalculateBuffer(msgView.prevPrefs, msgView.prevWidth())
-		msgView.replaceBuffer(msg, msg)
+	// Message not in view if there are no matches, nothing to do
+	msgs, msgViews := view.messageInTimelines(evt.ID)
+	_, mine := evt.Gomuks.OwnReactions[key]
+	for i, msg := range msgs {
+		msgView := msgViews[i]
+		recalculate := len(msg.Reactions) == 0
+		msg.Event.Gomuks.OwnReactions = evt.Gomuks.OwnReactions
+		msg.AddReaction(key, mine)
+		if recalculate {
+			// Recalculate height for message
+			msg.CalculateBuffer(msgView.prevPrefs, msgView.prevWidth())
+			msgView.replaceBuffer(msg, msg)
+		}
 	}
 }
 
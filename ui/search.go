@@ -0,0 +1,247 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/matrix/rooms"
+)
+
+// The maximum length of a message body shown in the search results.
+const maxSearchSnippetLength = 200
+
+// SearchModal shows the results of a server-side message search, grouped by room.
+type SearchModal struct {
+	mauview.FocusableComponent
+	parent *MainView
+
+	box  *mauview.Box
+	text *mauview.TextView
+
+	term      string
+	roomID    id.RoomID
+	results   []ifc.SearchResult
+	count     int
+	nextBatch string
+	loading   bool
+	selected  int
+}
+
+func NewSearchModal(parent *MainView, term string, roomID id.RoomID) *SearchModal {
+	sm := &SearchModal{parent: parent, term: term, roomID: roomID}
+
+	sm.text = mauview.NewTextView().
+		SetScrollable(true).
+		SetWrap(true).
+		SetRegions(true)
+	sm.text.SetText("Searching...")
+
+	sm.box = mauview.NewBox(sm.text).
+		SetBorder(true).
+		SetTitle(fmt.Sprintf("Search results for \"%s\"", term)).
+		SetBlurCaptureFunc(func() bool {
+			sm.parent.HideModal()
+			return true
+		})
+	sm.box.Focus()
+
+	sm.FocusableComponent = mauview.FractionalCenter(sm.box, 60, 20, 0.8, 0.8)
+
+	sm.loading = true
+	go sm.load()
+
+	return sm
+}
+
+func (sm *SearchModal) load() {
+	defer debug.Recover()
+	resp, err := sm.parent.matrix.Search(sm.term, sm.roomID, sm.nextBatch)
+	sm.loading = false
+	if err != nil {
+		sm.text.SetText(fmt.Sprintf("Search failed: %v", err))
+		sm.parent.parent.Render()
+		return
+	}
+	sm.results = groupSearchResults(append(sm.results, resp.Results...))
+	sm.count = resp.Count
+	sm.nextBatch = resp.NextBatch
+	sm.render()
+	sm.parent.parent.Render()
+}
+
+// groupSearchResults sorts the results so that results from the same room are next to each other,
+// keeping the rooms in the order of their first result.
+func groupSearchResults(results []ifc.SearchResult) []ifc.SearchResult {
+	var roomOrder []id.RoomID
+	byRoom := make(map[id.RoomID][]ifc.SearchResult)
+	for _, result := range results {
+		roomID := result.Event.RoomID
+		if _, ok := byRoom[roomID]; !ok {
+			roomOrder = append(roomOrder, roomID)
+		}
+		byRoom[roomID] = append(byRoom[roomID], result)
+	}
+	grouped := make([]ifc.SearchResult, 0, len(results))
+	for _, roomID := range roomOrder {
+		grouped = append(grouped, byRoom[roomID]...)
+	}
+	return grouped
+}
+
+func searchSnippet(evt *muksevt.Event) string {
+	body := evt.Content.AsMessage().Body
+	if len(body) == 0 {
+		return fmt.Sprintf("(%s event)", evt.Type.Type)
+	}
+	body = strings.Join(strings.Fields(body), " ")
+	if len(body) > maxSearchSnippetLength {
+		body = body[:maxSearchSnippetLength] + "…"
+	}
+	return body
+}
+
+func (sm *SearchModal) formatLine(room *rooms.Room, evt *muksevt.Event, prefix string) string {
+	sender := string(evt.Sender)
+	if room != nil {
+		sender = room.GetDisambiguatedDisplayname(evt.Sender)
+	}
+	sender = sm.parent.config.StripBridgeName(sender)
+	return fmt.Sprintf("%s%s <%s>: %s\n", prefix, formatEventTime(evt.Timestamp), sender, searchSnippet(evt))
+}
+
+func (sm *SearchModal) render() {
+	if len(sm.results) == 0 {
+		sm.text.SetText("No results. Messages in encrypted rooms can't be searched on the server.")
+		return
+	}
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "About %d results. Press Enter to jump to the selected message", sm.count)
+	if len(sm.nextBatch) > 0 {
+		buf.WriteString(", n to load more")
+	}
+	buf.WriteString(".\n")
+	var prevRoom id.RoomID
+	for i, result := range sm.results {
+		room := sm.parent.matrix.GetRoom(result.Event.RoomID)
+		if result.Event.RoomID != prevRoom {
+			prevRoom = result.Event.RoomID
+			title := string(prevRoom)
+			if room != nil {
+				title = room.GetTitle()
+			}
+			_, _ = fmt.Fprintf(&buf, "\n# %s\n", title)
+		}
+		for _, evt := range result.Before {
+			buf.WriteString(sm.formatLine(room, evt, "    "))
+		}
+		_, _ = fmt.Fprintf(&buf, `["%d"]%s[""]`, i, strings.TrimSuffix(sm.formatLine(room, result.Event, "  > "), "\n"))
+		buf.WriteString("\n")
+		for _, evt := range result.After {
+			buf.WriteString(sm.formatLine(room, evt, "    "))
+		}
+		buf.WriteString("\n")
+	}
+	sm.text.SetText(buf.String())
+	sm.text.Highlight(strconv.Itoa(sm.selected))
+	sm.text.ScrollToHighlight()
+}
+
+func (sm *SearchModal) move(diff int) {
+	if len(sm.results) == 0 {
+		return
+	}
+	sm.selected += diff
+	if sm.selected < 0 {
+		sm.selected = 0
+	} else if sm.selected >= len(sm.results) {
+		sm.selected = len(sm.results) - 1
+	}
+	sm.text.Highlight(strconv.Itoa(sm.selected))
+	sm.text.ScrollToHighlight()
+}
+
+// jump switches to the room of the selected result and scrolls to the message.
+func (sm *SearchModal) jump() {
+	if sm.selected >= len(sm.results) {
+		return
+	}
+	evt := sm.results[sm.selected].Event
+	room := sm.parent.matrix.GetRoom(evt.RoomID)
+	if room == nil || room.HasLeft {
+		sm.text.SetText("You're no longer in the room of that message.")
+		return
+	}
+	sm.parent.HideModal()
+	sm.parent.SwitchRoom("", room)
+	if roomView, ok := sm.parent.getRoomView(room.ID, true); ok {
+		go roomView.JumpToEvent(evt.ID)
+	}
+}
+
+func (sm *SearchModal) OnKeyEvent(event mauview.KeyEvent) bool {
+	switch event.Key() {
+	case tcell.KeyEscape:
+		sm.parent.HideModal()
+		return true
+	case tcell.KeyDown, tcell.KeyTab:
+		sm.move(1)
+		return true
+	case tcell.KeyUp, tcell.KeyBacktab:
+		sm.move(-1)
+		return true
+	case tcell.KeyEnter:
+		sm.jump()
+		return true
+	}
+	switch event.Rune() {
+	case 'q':
+		sm.parent.HideModal()
+		return true
+	case 'n':
+		if len(sm.nextBatch) > 0 && !sm.loading {
+			sm.loading = true
+			go sm.load()
+		}
+		return true
+	}
+	return sm.FocusableComponent.OnKeyEvent(event)
+}
+
+func cmdSearch(cmd *Command) {
+	var roomID id.RoomID
+	args := cmd.Args
+	if len(args) > 0 && (args[0] == "--room" || args[0] == "-r") {
+		roomID = cmd.Room.MxRoom().ID
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		cmd.Reply("Usage: /search [--room] <text>")
+		return
+	}
+	cmd.MainView.ShowModal(NewSearchModal(cmd.MainView, strings.Join(args, " "), roomID))
+}
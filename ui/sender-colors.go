@@ -0,0 +1,146 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/config"
+	"maunium.net/go/gomuks/ui/widget"
+)
+
+const (
+	SenderPaletteDefault  = "default"
+	SenderPaletteTerminal = "terminal"
+	SenderPaletteCustom   = "custom"
+)
+
+func isValidColor(color string) bool {
+	return tcell.GetColor(color) != tcell.ColorDefault
+}
+
+// applySenderColors passes the configured sender color palette and overrides to the color hashing.
+func applySenderColors(senderColors *config.SenderColors) {
+	if senderColors == nil {
+		widget.SetSenderColors(nil, nil)
+		return
+	}
+	var palette []string
+	switch senderColors.GetPalette() {
+	case SenderPaletteCustom:
+		for _, color := range senderColors.Custom {
+			if isValidColor(color) {
+				palette = append(palette, color)
+			}
+		}
+	case SenderPaletteTerminal:
+		palette = widget.SenderPalettes[SenderPaletteTerminal]
+	}
+	widget.SetSenderColors(palette, senderColors.Overrides)
+}
+
+func describeSenderColors(senderColors *config.SenderColors) string {
+	var buf strings.Builder
+	palette := senderColors.GetPalette()
+	buf.WriteString("Sender color palette: ")
+	buf.WriteString(palette)
+	if palette == SenderPaletteCustom {
+		_, _ = fmt.Fprintf(&buf, " (%s)", strings.Join(senderColors.Custom, ", "))
+	}
+	if senderColors != nil && len(senderColors.Overrides) > 0 {
+		userIDs := make([]string, 0, len(senderColors.Overrides))
+		for userID := range senderColors.Overrides {
+			userIDs = append(userIDs, string(userID))
+		}
+		sort.Strings(userIDs)
+		buf.WriteString("\nUser color overrides:")
+		for _, userID := range userIDs {
+			_, _ = fmt.Fprintf(&buf, "\n* %s - %s", userID, senderColors.Overrides[id.UserID(userID)])
+		}
+	}
+	return buf.String()
+}
+
+func cmdSenderColor(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmd.Reply("%s", describeSenderColors(cmd.Config.SenderColors))
+		return
+	} else if len(cmd.Args) < 2 {
+		cmd.Reply("Usage: /%s [palette <default|terminal|custom> [colors...]] or /%s <user ID> <color|reset>",
+			cmd.OrigCommand, cmd.OrigCommand)
+		return
+	}
+	if cmd.Config.SenderColors == nil {
+		cmd.Config.SenderColors = &config.SenderColors{}
+	}
+	senderColors := cmd.Config.SenderColors
+
+	if cmd.Args[0] == "palette" {
+		palette := strings.ToLower(cmd.Args[1])
+		switch palette {
+		case SenderPaletteDefault, SenderPaletteTerminal:
+		case SenderPaletteCustom:
+			colors := cmd.Args[2:]
+			if len(colors) == 0 {
+				colors = senderColors.Custom
+			}
+			if len(colors) == 0 {
+				cmd.Reply("Please list the colors of the custom palette, e.g. /%s palette custom red #ff8800 teal",
+					cmd.OrigCommand)
+				return
+			}
+			for _, color := range colors {
+				if !isValidColor(color) {
+					cmd.Reply("Invalid color %s. Use color names or #rrggbb hex codes.", color)
+					return
+				}
+			}
+			senderColors.Custom = colors
+		default:
+			cmd.Reply("Unknown palette %s. The available palettes are default, terminal and custom.", palette)
+			return
+		}
+		senderColors.Palette = palette
+	} else {
+		userID := id.UserID(cmd.Args[0])
+		if _, _, err := userID.Parse(); err != nil {
+			cmd.Reply("Invalid user ID %s", userID)
+			return
+		}
+		color := cmd.Args[1]
+		if color == "reset" {
+			delete(senderColors.Overrides, userID)
+		} else if !isValidColor(color) {
+			cmd.Reply("Invalid color %s. Use color names or #rrggbb hex codes.", color)
+			return
+		} else {
+			if senderColors.Overrides == nil {
+				senderColors.Overrides = make(map[id.UserID]string)
+			}
+			senderColors.Overrides[userID] = color
+		}
+	}
+	applySenderColors(senderColors)
+	cmd.Config.Save()
+	cmd.Reply("%s", describeSenderColors(senderColors))
+	cmd.UI.Render()
+}
@@ -0,0 +1,86 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"maunium.net/go/mautrix/id"
+)
+
+const sentHelp = `Usage: /%s [all] [count] - Show the events gomuks sent to this room (or all rooms).
+       /%[1]s clear             - Delete the sent event log.`
+
+func cmdSent(cmd *Command) {
+	args := cmd.Args
+	if len(args) > 0 && strings.ToLower(args[0]) == "clear" {
+		if err := cmd.Matrix.ClearSentEvents(); err != nil {
+			cmd.Reply("Failed to delete sent event log: %v", err)
+		} else {
+			cmd.Reply("Sent event log cleared")
+		}
+		return
+	}
+	roomID := cmd.Room.MxRoom().ID
+	if len(args) > 0 && strings.ToLower(args[0]) == "all" {
+		roomID = ""
+		args = args[1:]
+	}
+	count := 20
+	if len(args) > 0 {
+		var err error
+		count, err = strconv.Atoi(args[0])
+		if err != nil || count <= 0 {
+			cmd.Reply(sentHelp, cmd.OrigCommand)
+			return
+		}
+	}
+	entries, err := cmd.Matrix.SentEvents(roomID, count)
+	if err != nil {
+		cmd.Reply("Failed to read sent event log: %v", err)
+		return
+	} else if len(entries) == 0 {
+		cmd.Reply("No sent events logged")
+		return
+	}
+	var buf strings.Builder
+	for _, entry := range entries {
+		_, _ = fmt.Fprintf(&buf, "%s %s txn %s", entry.Time.Format("2006-01-02 15:04:05"), entry.Type.Type, entry.TransactionID)
+		if len(roomID) == 0 {
+			_, _ = fmt.Fprintf(&buf, " in %s", roomName(cmd, entry.RoomID))
+		}
+		switch entry.Status {
+		case "sent":
+			_, _ = fmt.Fprintf(&buf, " -> %s", entry.EventID)
+		case "failed":
+			_, _ = fmt.Fprintf(&buf, " failed: %s", entry.Error)
+		default:
+			_, _ = fmt.Fprintf(&buf, " (%s)", entry.Status)
+		}
+		buf.WriteRune('\n')
+	}
+	cmd.Reply("%s", strings.TrimSuffix(buf.String(), "\n"))
+}
+
+func roomName(cmd *Command, roomID id.RoomID) string {
+	if room := cmd.Matrix.GetRoom(roomID); room != nil {
+		return room.GetTitle()
+	}
+	return string(roomID)
+}
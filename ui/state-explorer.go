@@ -0,0 +1,69 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+
+	"maunium.net/go/gomuks/matrix/rooms"
+)
+
+// NewStateExplorer creates an inspector modal that lists all cached state events of the given room.
+func NewStateExplorer(parent *MainView, room *rooms.Room) *EventInspector {
+	events := room.GetAllStateEvents()
+	var list, summary strings.Builder
+	var prevType event.Type
+	typeCount := 0
+	flushType := func() {
+		if typeCount > 0 {
+			_, _ = fmt.Fprintf(&summary, "%s: %d\n", escapeColorTags(prevType.Type, "-"), typeCount)
+		}
+	}
+	for _, evt := range events {
+		if evt.Type != prevType {
+			flushType()
+			prevType = evt.Type
+			typeCount = 0
+		}
+		typeCount++
+		_, _ = fmt.Fprintf(&list, "[::b]%s[::-] %s\n", escapeColorTags(evt.Type.Type, "::b"), escapeColorTags(fmt.Sprintf("%q", evt.GetStateKey()), "-"))
+		_, _ = fmt.Fprintf(&list, "Sent by %s (%s)\n", escapeColorTags(evt.Sender.String(), "-"), escapeColorTags(evt.ID.String(), "-"))
+		data, err := json.MarshalIndent(&evt.Content, "", "  ")
+		if err != nil {
+			_, _ = fmt.Fprintf(&list, "Failed to marshal content: %s\n\n", escapeColorTags(err.Error(), "-"))
+			continue
+		}
+		list.WriteString(highlightJSON(data))
+		list.WriteString("\n\n")
+	}
+	flushType()
+	if len(events) == 0 {
+		list.WriteString("No state events cached")
+	}
+	return newInspectorModal(parent, []inspectorTab{
+		{name: fmt.Sprintf("State (%d events)", len(events)), text: list.String()},
+		{name: "Types", text: summary.String()},
+	})
+}
+
+func cmdRoomState(cmd *Command) {
+	cmd.MainView.ShowModal(NewStateExplorer(cmd.MainView, cmd.Room.Room))
+}
@@ -0,0 +1,276 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	sync "github.com/sasha-s/go-deadlock"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/lib/ansimage"
+	"maunium.net/go/gomuks/ui/messages/tstring"
+)
+
+const (
+	stickerPreviewWidth  = 16
+	stickerPreviewHeight = 8
+)
+
+type stickerOption struct {
+	pack    string
+	sticker ifc.Emote
+}
+
+type StickerPickerModal struct {
+	mauview.Component
+
+	container *mauview.Box
+
+	search  *mauview.InputArea
+	results *mauview.TextView
+	preview *stickerPreview
+
+	stickers []stickerOption
+	matches  []stickerOption
+	selected int
+
+	onPick func(sticker ifc.Emote)
+	parent *MainView
+}
+
+func NewStickerPickerModal(mainView *MainView, packs []*ifc.EmotePack, onPick func(sticker ifc.Emote)) *StickerPickerModal {
+	sp := &StickerPickerModal{
+		parent: mainView,
+		onPick: onPick,
+	}
+	for _, pack := range packs {
+		for _, emote := range pack.Emotes {
+			if emote.Sticker {
+				sp.stickers = append(sp.stickers, stickerOption{pack: pack.DisplayName, sticker: emote})
+			}
+		}
+	}
+
+	sp.preview = &stickerPreview{
+		parent: mainView,
+		images: make(map[id.ContentURI][]tstring.TString),
+	}
+	sp.results = mauview.NewTextView().SetRegions(true)
+	sp.search = mauview.NewInputArea().
+		SetChangedFunc(sp.changeHandler).
+		SetPlaceholder("Search by shortcode or pack...").
+		SetTextColor(tcell.ColorWhite).
+		SetBackgroundColor(tcell.ColorDarkCyan)
+	sp.search.Focus()
+
+	body := mauview.NewFlex().
+		SetDirection(mauview.FlexColumn).
+		AddProportionalComponent(sp.results, 1).
+		AddFixedComponent(sp.preview, stickerPreviewWidth)
+
+	flex := mauview.NewFlex().
+		SetDirection(mauview.FlexRow).
+		AddFixedComponent(sp.search, 1).
+		AddProportionalComponent(body, 1)
+
+	sp.container = mauview.NewBox(flex).
+		SetBorder(true).
+		SetTitle("Send sticker").
+		SetBlurCaptureFunc(func() bool {
+			sp.parent.HideModal()
+			return true
+		})
+
+	sp.Component = mauview.Center(sp.container, 60, stickerPreviewHeight+4).SetAlwaysFocusChild(true)
+	sp.changeHandler("")
+
+	return sp
+}
+
+func (sp *StickerPickerModal) Focus() {
+	sp.container.Focus()
+}
+
+func (sp *StickerPickerModal) Blur() {
+	sp.container.Blur()
+}
+
+func (sp *StickerPickerModal) changeHandler(str string) {
+	str = strings.ToLower(strings.Trim(strings.TrimSpace(str), ":"))
+	sp.matches = nil
+	for _, option := range sp.stickers {
+		if strings.Contains(strings.ToLower(option.sticker.Shortcode), str) || strings.Contains(strings.ToLower(option.pack), str) {
+			sp.matches = append(sp.matches, option)
+		}
+	}
+	sp.selected = 0
+	sp.results.Clear()
+	if len(sp.stickers) == 0 {
+		fmt.Fprint(sp.results, "No stickers available in this room")
+	}
+	for i, option := range sp.matches {
+		fmt.Fprintf(sp.results, `["%d"]:%s: (%s)[""]%s`, i, option.sticker.Shortcode, option.pack, "\n")
+	}
+	if len(sp.matches) > 0 {
+		sp.results.Highlight("0")
+	} else {
+		sp.results.Highlight()
+	}
+	sp.results.ScrollToBeginning()
+	sp.updatePreview()
+}
+
+func (sp *StickerPickerModal) updatePreview() {
+	if len(sp.matches) == 0 {
+		sp.preview.SetImage(id.ContentURI{})
+	} else {
+		sp.preview.SetImage(sp.matches[sp.selected].sticker.URL)
+	}
+}
+
+func (sp *StickerPickerModal) move(diff int) {
+	if len(sp.matches) == 0 {
+		return
+	}
+	sp.selected = (sp.selected + diff) % len(sp.matches)
+	if sp.selected < 0 {
+		sp.selected += len(sp.matches)
+	}
+	sp.results.Highlight(strconv.Itoa(sp.selected))
+	sp.results.ScrollToHighlight()
+	sp.updatePreview()
+}
+
+func (sp *StickerPickerModal) OnKeyEvent(event mauview.KeyEvent) bool {
+	switch event.Key() {
+	case tcell.KeyEsc:
+		sp.parent.HideModal()
+		return true
+	case tcell.KeyTab, tcell.KeyDown:
+		sp.move(1)
+		return true
+	case tcell.KeyBacktab, tcell.KeyUp:
+		sp.move(-1)
+		return true
+	case tcell.KeyEnter:
+		sp.parent.HideModal()
+		if len(sp.matches) > 0 {
+			go sp.onPick(sp.matches[sp.selected].sticker)
+		}
+		return true
+	}
+	return sp.search.OnKeyEvent(event)
+}
+
+// stickerPreview draws the currently selected sticker, downloading and rendering it in the background if needed.
+type stickerPreview struct {
+	parent *MainView
+
+	current id.ContentURI
+	images  map[id.ContentURI][]tstring.TString
+	lock    sync.Mutex
+}
+
+func (preview *stickerPreview) SetImage(uri id.ContentURI) {
+	preview.lock.Lock()
+	defer preview.lock.Unlock()
+	preview.current = uri
+	if _, ok := preview.images[uri]; !ok && !uri.IsEmpty() {
+		preview.images[uri] = nil
+		go preview.load(uri)
+	}
+}
+
+func (preview *stickerPreview) load(uri id.ContentURI) {
+	defer debug.Recover()
+	data, err := preview.parent.matrix.Download(uri, nil)
+	if err != nil {
+		debug.Printf("Failed to download sticker %s: %v", uri, err)
+		return
+	}
+	img, err := ansimage.NewScaledFromReader(bytes.NewReader(data), stickerPreviewHeight*2, stickerPreviewWidth, color.Black)
+	if err != nil {
+		debug.Printf("Failed to render sticker %s: %v", uri, err)
+		return
+	}
+	preview.lock.Lock()
+	preview.images[uri] = img.Render()
+	preview.lock.Unlock()
+	preview.parent.parent.Render()
+}
+
+func (preview *stickerPreview) Draw(screen mauview.Screen) {
+	preview.lock.Lock()
+	image := preview.images[preview.current]
+	preview.lock.Unlock()
+	for y, line := range image {
+		line.Draw(screen, 0, y)
+	}
+}
+
+func (preview *stickerPreview) OnKeyEvent(_ mauview.KeyEvent) bool {
+	return false
+}
+
+func (preview *stickerPreview) OnPasteEvent(_ mauview.PasteEvent) bool {
+	return false
+}
+
+func (preview *stickerPreview) OnMouseEvent(_ mauview.MouseEvent) bool {
+	return false
+}
+
+func (view *RoomView) SendSticker(sticker ifc.Emote) {
+	defer debug.Recover()
+	debug.Print("Sending sticker", sticker.URL, "to", view.Room.ID)
+	rel := view.getRelationForNewEvent()
+	if rel != nil && rel.Type == event.RelReplace {
+		// Messages can't be edited into stickers, so send a new event instead.
+		rel = nil
+	}
+	evt := view.parent.matrix.PrepareSticker(view.Room.ID, sticker, rel)
+	view.addLocalEcho(evt, "")
+}
+
+func cmdSticker(cmd *Command) {
+	packs := cmd.Matrix.GetEmotePacks(cmd.Room.Room.ID)
+	if len(cmd.Args) > 0 {
+		shortcode := strings.Trim(cmd.Args[0], ":")
+		for _, pack := range packs {
+			for _, emote := range pack.Emotes {
+				if emote.Sticker && emote.Shortcode == shortcode {
+					go cmd.Room.SendSticker(emote)
+					return
+				}
+			}
+		}
+		cmd.Reply("No sticker with shortcode :%s: found", shortcode)
+		return
+	}
+	cmd.MainView.ShowModal(NewStickerPickerModal(cmd.MainView, packs, cmd.Room.SendSticker))
+}
@@ -0,0 +1,132 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/config"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/ui/messages"
+	"maunium.net/go/gomuks/ui/widget"
+)
+
+// StickyMessage keeps a message visible above the timeline of a room view while the rest of the timeline
+// scrolls. Sticky messages are local to this client and aren't saved when gomuks exits.
+type StickyMessage struct {
+	parent *RoomView
+	msg    *messages.UIMessage
+
+	prevWidth int
+	prevPrefs config.UserPreferences
+}
+
+func NewStickyMessage(parent *RoomView) *StickyMessage {
+	return &StickyMessage{parent: parent}
+}
+
+// Visible returns true if there's a message stuck to the top of the room view.
+func (sticky *StickyMessage) Visible() bool {
+	return sticky.msg != nil
+}
+
+// Set sticks a copy of the given message to the top of the room view, or removes the sticky message if nil.
+func (sticky *StickyMessage) Set(msg *messages.UIMessage) {
+	if msg == nil {
+		sticky.msg = nil
+		return
+	}
+	sticky.msg = msg.Clone()
+	sticky.msg.IsSelected = false
+	sticky.msg.ThreadReplies = 0
+	sticky.prevWidth = -1
+}
+
+// Update replaces the sticky message with a freshly parsed one if the given event is the sticky message.
+func (sticky *StickyMessage) Update(evt *muksevt.Event) {
+	if sticky.msg == nil || sticky.msg.EventID != evt.ID {
+		return
+	}
+	if msg := sticky.parent.parseEvent(evt); msg != nil {
+		sticky.Set(msg)
+	}
+}
+
+// Height returns the number of rows the sticky message takes, including the header,
+// but at most maxHeight rows.
+func (sticky *StickyMessage) Height(width, maxHeight int) int {
+	if sticky.msg == nil || maxHeight < 2 {
+		return 0
+	}
+	prefs := sticky.parent.config.Preferences
+	if width != sticky.prevWidth || prefs != sticky.prevPrefs {
+		sticky.msg.CalculateBuffer(prefs, width-1)
+		sticky.prevWidth = width
+		sticky.prevPrefs = prefs
+	}
+	height := 1 + sticky.msg.Height()
+	if height > maxHeight {
+		height = maxHeight
+	}
+	return height
+}
+
+func (sticky *StickyMessage) Draw(screen mauview.Screen) {
+	if sticky.msg == nil {
+		return
+	}
+	width, height := screen.Size()
+	header := fmt.Sprintf("📌 %s, %s (click to jump, /unsticky to remove)", sticky.msg.SenderName, sticky.msg.FormatTime())
+	style := tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorYellow)
+	widget.WriteLinePadded(screen, mauview.AlignLeft, header, 0, 0, width, style)
+	for y := 1; y < height; y++ {
+		screen.SetCell(0, y, tcell.StyleDefault.Foreground(tcell.ColorYellow), '▊')
+	}
+	sticky.msg.Draw(mauview.NewProxyScreen(screen, 1, 1, width-1, height-1))
+}
+
+func (sticky *StickyMessage) OnKeyEvent(event mauview.KeyEvent) bool {
+	return false
+}
+
+func (sticky *StickyMessage) OnPasteEvent(event mauview.PasteEvent) bool {
+	return false
+}
+
+func (sticky *StickyMessage) OnMouseEvent(event mauview.MouseEvent) bool {
+	if sticky.msg == nil || event.Buttons() != tcell.Button1 || event.HasMotion() {
+		return false
+	}
+	go sticky.parent.GoToEvent(sticky.msg.EventID)
+	return true
+}
+
+func cmdSticky(cmd *Command) {
+	cmd.Room.StartSelecting(SelectSticky, "")
+}
+
+func cmdUnsticky(cmd *Command) {
+	if !cmd.Room.sticky.Visible() {
+		cmd.Reply("There's no sticky message in this room")
+		return
+	}
+	cmd.Room.sticky.Set(nil)
+	cmd.UI.Render()
+}
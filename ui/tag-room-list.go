@@ -20,9 +20,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"maunium.net/go/gomuks/config"
 	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/mautrix/id"
 	"maunium.net/go/mauview"
 	"maunium.net/go/tcell"
 
@@ -58,6 +63,13 @@ func (or *OrderedRoom) Draw(roomList *RoomList, screen mauview.Screen, x, y, lin
 		style = style.
 			Foreground(roomList.selectedTextColor).
 			Background(roomList.selectedBackgroundColor)
+	} else if roomList.IsSearchActive() && !roomList.MatchesSearch(or.Room) {
+		style = style.Dim(true)
+	}
+
+	if inviter := or.Inviter(); len(inviter) > 0 {
+		or.drawInvite(roomList, screen, inviter, x, y, lineWidth, style, isSelected)
+		return
 	}
 
 	unreadCount := or.UnreadCount()
@@ -78,6 +90,47 @@ func (or *OrderedRoom) Draw(roomList *RoomList, screen mauview.Screen, x, y, lin
 	}
 }
 
+// Invites older than this are highlighted in the room list.
+const staleInviteAge = 3 * 24 * time.Hour
+
+func formatInviteAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return "now"
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", age/time.Minute)
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh", age/time.Hour)
+	case age < 7*24*time.Hour:
+		return fmt.Sprintf("%dd", age/(24*time.Hour))
+	default:
+		return fmt.Sprintf("%dw", age/(7*24*time.Hour))
+	}
+}
+
+// drawInvite draws a room the user has been invited to, along with who sent the invite and how long ago.
+func (or *OrderedRoom) drawInvite(roomList *RoomList, screen mauview.Screen, inviter id.UserID, x, y, lineWidth int, style tcell.Style, isSelected bool) {
+	inviterName := string(inviter)
+	if member := or.GetMember(inviter); member != nil && len(member.Displayname) > 0 {
+		inviterName = member.Displayname
+	}
+	var age string
+	if !or.InvitedAt.IsZero() {
+		invitedFor := time.Since(or.InvitedAt)
+		age = formatInviteAge(invitedFor)
+		if invitedFor > staleInviteAge && !isSelected {
+			style = style.Foreground(tcell.ColorYellow)
+		}
+	}
+	if len(age) > 0 {
+		ageWidth := len(age) + 1
+		widget.WriteLinePadded(screen, mauview.AlignRight, age, x+lineWidth-ageWidth, y, ageWidth, style)
+		lineWidth -= ageWidth
+	}
+	title := fmt.Sprintf("%s (from %s)", or.GetTitle(), inviterName)
+	widget.WriteLinePadded(screen, mauview.AlignLeft, title, x, y, lineWidth, style)
+}
+
 type TagRoomList struct {
 	mauview.NoopEventHandler
 	// The list of rooms in the list, in reverse order
@@ -167,26 +220,66 @@ func almostEqual(a, b float64) bool {
 	return math.Abs(a-b) <= equalityThreshold
 }
 
+// SortMode returns how the rooms in this list are ordered, see config.Config.GetRoomSort.
+func (trl *TagRoomList) SortMode() string {
+	return trl.parent.parent.config.GetRoomSort(trl.name)
+}
+
+// unreadRank returns how high the given room should be in a list sorted by unread status.
+func unreadRank(room *rooms.Room) int {
+	if room.Highlighted() {
+		return 2
+	} else if room.HasNewMessages() {
+		return 1
+	}
+	return 0
+}
+
 // ShouldBeAfter returns if the first room should be after the second room in the room list.
-// The manual order and last received message timestamp are considered.
+// The manual order and last received message timestamp are considered by default, the sort
+// mode of the list can also put unread rooms first or ignore activity altogether.
 func (trl *TagRoomList) ShouldBeAfter(room1 *OrderedRoom, room2 *OrderedRoom) bool {
+	switch trl.SortMode() {
+	case config.RoomSortAlphabetical:
+		return strings.ToLower(room1.GetTitle()) > strings.ToLower(room2.GetTitle())
+	case config.RoomSortManual:
+		return room1.order > room2.order ||
+			// Equal order value and alphabetically first = higher in the list
+			(almostEqual(room1.order, room2.order) && strings.ToLower(room1.GetTitle()) > strings.ToLower(room2.GetTitle()))
+	case config.RoomSortUnread:
+		// Highlighted rooms first, then other unread rooms, then the rest by activity
+		if rank1, rank2 := unreadRank(room1.Room), unreadRank(room2.Room); rank1 != rank2 {
+			return rank1 < rank2
+		}
+	}
 	// Lower order value = higher in list
 	return room1.order > room2.order ||
 		// Equal order value and more recent message = higher in the list
 		(almostEqual(room1.order, room2.order) && room2.LastReceivedMessage.After(room1.LastReceivedMessage))
 }
 
+// Sort re-sorts the whole list, e.g. after its sort mode was changed.
+func (trl *TagRoomList) Sort() {
+	sort.SliceStable(trl.rooms, func(i, j int) bool {
+		return trl.ShouldBeAfter(trl.rooms[i], trl.rooms[j])
+	})
+}
+
 func (trl *TagRoomList) Insert(order json.Number, mxRoom *rooms.Room) {
-	room := NewOrderedRoom(order, mxRoom)
+	if trl.Index(mxRoom) != -1 {
+		debug.Printf("Warning: tried to re-insert room %s into tag %s", mxRoom.ID, trl.name)
+		return
+	}
+	trl.insert(NewOrderedRoom(order, mxRoom))
+}
+
+func (trl *TagRoomList) insert(room *OrderedRoom) {
 	// The default insert index is the newly added slot.
 	// That index will be used if all other rooms in the list have the same LastReceivedMessage timestamp.
 	insertAt := len(trl.rooms)
 	// Find the spot where the new room should be put according to the last received message timestamps.
 	for i := 0; i < len(trl.rooms); i++ {
-		if trl.rooms[i].Room == mxRoom {
-			debug.Printf("Warning: tried to re-insert room %s into tag %s", mxRoom.ID, trl.name)
-			return
-		} else if trl.ShouldBeAfter(room, trl.rooms[i]) {
+		if trl.ShouldBeAfter(room, trl.rooms[i]) {
 			insertAt = i
 			break
 		}
@@ -221,6 +314,19 @@ func (trl *TagRoomList) Bump(mxRoom *rooms.Room) {
 	trl.rooms[len(trl.rooms)-1] = roomBeingBumped
 }
 
+// Reposition moves the given room to the spot where it should be now. Unlike Bump, it can also
+// move rooms down, which is needed when the sort mode considers more than the last message.
+func (trl *TagRoomList) Reposition(mxRoom *rooms.Room) {
+	index := trl.Index(mxRoom)
+	if index == -1 {
+		debug.Print("Warning: couldn't find room", mxRoom.ID, mxRoom.NameCache, "to reposition in tag", trl.name)
+		return
+	}
+	room := trl.rooms[index]
+	trl.RemoveIndex(index)
+	trl.insert(room)
+}
+
 func (trl *TagRoomList) Remove(room *rooms.Room) {
 	trl.RemoveIndex(trl.Index(room))
 }
@@ -284,6 +390,14 @@ func (trl *TagRoomList) DrawHeader(screen mauview.Screen) {
 	roomCountX := len(trl.displayname) + 1
 	roomCountWidth := width - 2 - len(trl.displayname)
 	widget.WriteLine(screen, mauview.AlignLeft, roomCount, roomCountX, 0, roomCountWidth, TagRoomCountStyle)
+
+	// Draw sort mode if it isn't the default activity sort and there's room for it
+	if sortMode := trl.SortMode(); sortMode != config.RoomSortActivity {
+		sortModeX := width - 2 - len(sortMode)
+		if sortModeX > roomCountX+len(roomCount) {
+			widget.WriteLine(screen, mauview.AlignLeft, sortMode, sortModeX, 0, len(sortMode), TagRoomCountStyle.Dim(true))
+		}
+	}
 }
 
 func (trl *TagRoomList) Draw(screen mauview.Screen) {
@@ -0,0 +1,148 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var templatePlaceholderRegex = regexp.MustCompile(`{{\s*([a-z]+)\s*}}`)
+
+// expandTemplate replaces the built-in placeholders in a message template.
+// Unknown placeholders are left as-is so that they can be filled in manually before sending.
+func expandTemplate(cmd *Command, template string) string {
+	now := time.Now()
+	room := cmd.Room.MxRoom()
+	return templatePlaceholderRegex.ReplaceAllStringFunc(template, func(placeholder string) string {
+		switch templatePlaceholderRegex.FindStringSubmatch(placeholder)[1] {
+		case "date":
+			return now.Format("2006-01-02")
+		case "time":
+			return now.Format("15:04")
+		case "weekday":
+			return now.Weekday().String()
+		case "room":
+			return room.GetTitle()
+		case "user":
+			return string(cmd.Config.UserID)
+		case "name":
+			if member := room.GetMember(room.SessionUserID); member != nil && len(member.Displayname) > 0 {
+				return member.Displayname
+			}
+			return string(cmd.Config.UserID)
+		default:
+			return placeholder
+		}
+	})
+}
+
+const templateHelp = `Usage: /%s <subcommand>
+
+Subcommands:
+* list                - List saved templates.
+* use <name>          - Put the template into the message composer for editing.
+* save <name> <text>  - Save a template. Placeholders: {{date}}, {{time}}, {{weekday}},
+                        {{room}}, {{user}} and {{name}}. Other {{placeholders}} are kept
+                        as-is to be filled in by hand.
+* delete <name>       - Delete a template.`
+
+func cmdTemplate(cmd *Command) {
+	if len(cmd.Args) == 0 {
+		cmd.Reply(templateHelp, cmd.OrigCommand)
+		return
+	}
+	switch strings.ToLower(cmd.Args[0]) {
+	case "list":
+		if len(cmd.Config.Templates) == 0 {
+			cmd.Reply("No templates saved. Use /%s save <name> <text> to create one.", cmd.OrigCommand)
+			return
+		}
+		names := make([]string, 0, len(cmd.Config.Templates))
+		for name := range cmd.Config.Templates {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var buf strings.Builder
+		buf.WriteString("Saved templates:\n")
+		for _, name := range names {
+			preview := strings.SplitN(cmd.Config.Templates[name], "\n", 2)[0]
+			_, _ = fmt.Fprintf(&buf, "* %s: %s\n", name, preview)
+		}
+		cmd.Reply("%s", strings.TrimSuffix(buf.String(), "\n"))
+	case "use":
+		if len(cmd.Args) < 2 {
+			cmd.Reply(templateHelp, cmd.OrigCommand)
+			return
+		}
+		template, ok := cmd.Config.Templates[cmd.Args[1]]
+		if !ok {
+			cmd.Reply("Template %s not found", cmd.Args[1])
+			return
+		}
+		cmd.Room.SetInputText(expandTemplate(cmd, template))
+		cmd.UI.Render()
+	case "save":
+		rawArgs := strings.TrimLeftFunc(cmd.RawArgs, unicode.IsSpace)
+		rawArgs = strings.TrimLeftFunc(rawArgs[len(cmd.Args[0]):], unicode.IsSpace)
+		nameEnd := strings.IndexFunc(rawArgs, unicode.IsSpace)
+		if nameEnd <= 0 || len(strings.TrimSpace(rawArgs[nameEnd:])) == 0 {
+			cmd.Reply(templateHelp, cmd.OrigCommand)
+			return
+		}
+		name, text := rawArgs[:nameEnd], strings.TrimLeftFunc(rawArgs[nameEnd:], unicode.IsSpace)
+		if cmd.Config.Templates == nil {
+			cmd.Config.Templates = make(map[string]string)
+		}
+		cmd.Config.Templates[name] = text
+		cmd.Config.Save()
+		cmd.Reply("Saved template %s", name)
+	case "delete":
+		if len(cmd.Args) < 2 {
+			cmd.Reply(templateHelp, cmd.OrigCommand)
+			return
+		} else if _, ok := cmd.Config.Templates[cmd.Args[1]]; !ok {
+			cmd.Reply("Template %s not found", cmd.Args[1])
+			return
+		}
+		delete(cmd.Config.Templates, cmd.Args[1])
+		cmd.Config.Save()
+		cmd.Reply("Deleted template %s", cmd.Args[1])
+	default:
+		cmd.Reply(templateHelp, cmd.OrigCommand)
+	}
+}
+
+func autocompleteTemplate(cmd *CommandAutocomplete) (completions []string, newText string) {
+	if len(cmd.Args) != 2 || (cmd.Args[0] != "use" && cmd.Args[0] != "delete") {
+		return
+	}
+	for name := range cmd.Config.Templates {
+		if strings.HasPrefix(name, cmd.Args[1]) {
+			completions = append(completions, name)
+		}
+	}
+	sort.Strings(completions)
+	if len(completions) == 1 {
+		newText = fmt.Sprintf("/%s %s %s", cmd.OrigCommand, cmd.Args[0], completions[0])
+	}
+	return
+}
@@ -0,0 +1,149 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/ui/messages"
+)
+
+// OpenThread replaces the timeline of the room view with the thread that the given message starts or is part of.
+// Messages sent while the thread is open are sent into the thread.
+//
+// The replies that have been loaded are shown immediately, and the rest of the thread is fetched from the server.
+func (view *RoomView) OpenThread(message *messages.UIMessage) {
+	rootID := message.EventID
+	if threadRoot := message.ThreadRoot(); len(threadRoot) > 0 {
+		rootID = threadRoot
+	}
+	view.showThread(rootID)
+	go view.loadThreadReplies(rootID)
+}
+
+// loadThreadReplies fetches the replies in the given thread from the server
+// and shows the ones that weren't loaded yet if the thread is still open.
+func (view *RoomView) loadThreadReplies(rootID id.EventID) {
+	defer debug.Recover()
+	evts, err := view.parent.matrix.GetThreadReplies(view.Room, rootID)
+	if err != nil {
+		debug.Printf("Failed to fetch replies in thread %s: %v", rootID, err)
+		if view.threadView != nil && view.threadView.threadRoot == rootID {
+			view.AddServiceMessage(fmt.Sprintf("Failed to load the rest of the thread: %v", err))
+			view.parent.parent.Render()
+		}
+		return
+	}
+	fetched := make([]*messages.UIMessage, 0, len(evts))
+	for _, evt := range evts {
+		if msg := view.parseEvent(evt); msg != nil && msg.ThreadRoot() == rootID {
+			fetched = append(fetched, msg)
+		}
+	}
+	view.content.mergeThreadReplies(rootID, fetched)
+	if view.threadView != nil && view.threadView.threadRoot == rootID {
+		view.showThread(rootID)
+	}
+	view.parent.parent.Render()
+}
+
+// showThread builds the thread view for the given thread root from the replies loaded in the main timeline.
+func (view *RoomView) showThread(rootID id.EventID) {
+	root := view.content.getMessageByID(rootID)
+
+	threadView := NewMessageView(view)
+	threadView.threadRoot = rootID
+	if root != nil {
+		rootClone := root.Clone()
+		rootClone.ReplyTo = root.ReplyTo
+		rootClone.Reactions = root.Reactions
+		rootClone.ThreadReplies = 0
+		rootClone.ThreadUnread = 0
+		threadView.AddMessage(rootClone, AppendMessage)
+	} else {
+		threadView.AddMessage(messages.NewServiceMessage("The thread root hasn't been loaded"), AppendMessage)
+	}
+	replies := view.content.threadReplies(rootID)
+	for _, reply := range replies {
+		threadView.AddMessage(reply, AppendMessage)
+	}
+
+	view.StopSelecting()
+	view.threadView = threadView
+	view.threadRootMsg = root
+	if len(replies) > 0 {
+		view.Room.MarkThreadRead(rootID, replies[len(replies)-1].Timestamp)
+	}
+	if root != nil {
+		view.content.updateThreadSummary(root)
+	}
+	view.Update()
+}
+
+// CloseThread returns from the thread view to the main timeline.
+func (view *RoomView) CloseThread() {
+	if view.threadView == nil {
+		return
+	}
+	view.StopSelecting()
+	view.threadView = nil
+	if view.threadRootMsg != nil {
+		view.content.updateThreadSummary(view.threadRootMsg)
+		view.threadRootMsg = nil
+	}
+	view.Update()
+}
+
+func (view *RoomView) threadTopic() string {
+	if view.threadRootMsg == nil {
+		return fmt.Sprintf("Thread %s (Esc to close)", view.threadView.threadRoot)
+	}
+	preview := strings.SplitN(view.threadRootMsg.PlainText(), "\n", 2)[0]
+	return fmt.Sprintf("Thread by %s: %s (Esc to close)", view.threadRootMsg.SenderName, preview)
+}
+
+// threadFallbackEvent returns the newest event in the open thread, which new messages that aren't
+// explicit replies are marked as replying to for clients that don't support threads.
+func (view *RoomView) threadFallbackEvent() *muksevt.Event {
+	view.threadView.messagesLock.RLock()
+	defer view.threadView.messagesLock.RUnlock()
+	for i := len(view.threadView.messages) - 1; i >= 0; i-- {
+		msg := view.threadView.messages[i]
+		if !msg.IsService && msg.Event != nil && msg.State == muksevt.StateDefault {
+			return msg.Event
+		}
+	}
+	return view.threadRootEvent()
+}
+
+// threadRootEvent returns the root event of the open thread, which new messages are sent as replies to.
+func (view *RoomView) threadRootEvent() *muksevt.Event {
+	if view.threadRootMsg != nil && view.threadRootMsg.Event != nil {
+		return view.threadRootMsg.Event
+	}
+	return muksevt.Wrap(&event.Event{ID: view.threadView.threadRoot, RoomID: view.Room.ID})
+}
+
+func cmdThread(cmd *Command) {
+	cmd.Room.StartSelecting(SelectThread, "")
+}
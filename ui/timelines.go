@@ -0,0 +1,158 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"maunium.net/go/mautrix/id"
+
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/ui/messages"
+)
+
+// timelines returns every message view that shows messages of this room: the main timeline first,
+// followed by the open thread or permalink context and the mirrors in other panes, if any.
+// Each timeline keeps its own scroll position, but they are all fed from the same events,
+// so updates must be applied to each of them.
+func (view *RoomView) timelines() []*MessageView {
+	timelines := []*MessageView{view.content}
+	if view.threadView != nil {
+		timelines = append(timelines, view.threadView)
+	}
+	if view.contextView != nil {
+		timelines = append(timelines, view.contextView)
+	}
+	view.mirrorsLock.RLock()
+	timelines = append(timelines, view.mirrors...)
+	view.mirrorsLock.RUnlock()
+	return timelines
+}
+
+// openMirror creates a copy of the main timeline for showing the room in a second split pane.
+// The mirror has its own copies of the messages, so it can be laid out and scrolled independently.
+func (view *RoomView) openMirror() *MessageView {
+	mirror := NewMessageView(view)
+	mirror.initialHistoryLoaded = true
+
+	view.content.messagesLock.RLock()
+	msgs := make([]*messages.UIMessage, 0, len(view.content.messages))
+	for _, msg := range view.content.messages {
+		if !msg.IsService {
+			msgs = append(msgs, msg)
+		}
+	}
+	view.content.messagesLock.RUnlock()
+	view.content.threadsLock.RLock()
+	for _, replies := range view.content.threads {
+		msgs = append(msgs, replies...)
+	}
+	view.content.threadsLock.RUnlock()
+	for _, msg := range msgs {
+		if msgCopy := view.mirrorCopy(msg); msgCopy != nil {
+			mirror.AddMessage(msgCopy, AppendMessage)
+		}
+	}
+
+	view.mirrorsLock.Lock()
+	view.mirrors = append(view.mirrors, mirror)
+	view.mirrorsLock.Unlock()
+	return mirror
+}
+
+// closeMirror stops updating a mirror after the pane showing it was closed.
+func (view *RoomView) closeMirror(mirror *MessageView) {
+	view.mirrorsLock.Lock()
+	defer view.mirrorsLock.Unlock()
+	// Build a new slice, as addToMirrors may be iterating over the old one.
+	mirrors := make([]*MessageView, 0, len(view.mirrors))
+	for _, existing := range view.mirrors {
+		if existing != mirror {
+			mirrors = append(mirrors, existing)
+		}
+	}
+	view.mirrors = mirrors
+}
+
+// addToMirrors adds a copy of a new message in the main timeline to each mirror.
+func (view *RoomView) addToMirrors(msg *messages.UIMessage, direction MessageDirection) {
+	view.mirrorsLock.RLock()
+	mirrors := view.mirrors
+	view.mirrorsLock.RUnlock()
+	for _, mirror := range mirrors {
+		if msgCopy := view.mirrorCopy(msg); msgCopy != nil {
+			mirror.AddMessage(msgCopy, direction)
+		}
+	}
+}
+
+// mirrorCopy parses the event of a message again for a mirror, as the rendered buffers depend on the timeline width.
+func (view *RoomView) mirrorCopy(msg *messages.UIMessage) *messages.UIMessage {
+	if msg.Event == nil {
+		return nil
+	}
+	msgCopy := view.parseEvent(msg.Event)
+	if msgCopy != nil {
+		msgCopy.State = msg.State
+		msgCopy.Reactions = append(messages.ReactionSlice(nil), msg.Reactions...)
+	}
+	return msgCopy
+}
+
+// updateOtherTimelines applies an updated event to the timelines other than the main one.
+//
+// Timelines that share the message object with the main timeline (like thread replies, which
+// the main timeline forwards to the thread view) are already up to date. Others have their own
+// copy of the message, which is replaced with a freshly parsed one.
+func (view *RoomView) updateOtherTimelines(evt *muksevt.Event) {
+	timelines := view.timelines()
+	shared := view.content.getMessageByID(evt.ID)
+	for _, msgView := range timelines[1:] {
+		old := msgView.getMessageByID(evt.ID)
+		if old == nil || old == shared {
+			continue
+		}
+		if msg := view.parseEvent(evt); msg != nil {
+			// Copy the UI state that isn't stored in the event.
+			msg.ReplyTo = old.ReplyTo
+			msg.ThreadReplies = old.ThreadReplies
+			msg.ThreadUnread = old.ThreadUnread
+			msgView.AddMessage(msg, IgnoreMessage)
+		}
+	}
+	view.sticky.Update(evt)
+}
+
+// messageInTimelines returns each distinct copy of the given message along with the timeline it's in.
+func (view *RoomView) messageInTimelines(eventID id.EventID) (msgs []*messages.UIMessage, msgViews []*MessageView) {
+	for _, msgView := range view.timelines() {
+		msg := msgView.getMessageByID(eventID)
+		if msg == nil {
+			continue
+		}
+		duplicate := false
+		for _, existing := range msgs {
+			if existing == msg {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			msgs = append(msgs, msg)
+			msgViews = append(msgViews, msgView)
+		}
+	}
+	return
+}
@@ -0,0 +1,50 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// +build cgo
+
+package ui
+
+import (
+	"maunium.net/go/mautrix/crypto"
+
+	ifc "maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/ui/messages"
+)
+
+// getTrustLevel computes the trust level of an encrypted event from the olm machine's trust data.
+func getTrustLevel(container ifc.MatrixContainer, evt *muksevt.Event) messages.TrustLevel {
+	info := evt.Gomuks.Encryption
+	if info == nil {
+		return messages.TrustNone
+	}
+	mach, ok := container.Crypto().(*crypto.OlmMachine)
+	if !ok {
+		return messages.TrustNone
+	}
+	session, err := mach.CryptoStore.GetGroupSession(evt.RoomID, info.SenderKey, info.SessionID)
+	if err == nil && session != nil && len(session.ForwardingChains) > 0 {
+		return messages.TrustForwardedKey
+	}
+	device, err := mach.CryptoStore.GetDevice(evt.Sender, info.DeviceID)
+	if err != nil || device == nil || device.Deleted || string(device.IdentityKey) != string(info.SenderKey) {
+		return messages.TrustUnknownDevice
+	} else if mach.IsDeviceTrusted(device) {
+		return messages.TrustVerified
+	}
+	return messages.TrustUnverified
+}
@@ -76,6 +76,9 @@ func (ui *GomuksUI) Start() error {
 }
 
 func (ui *GomuksUI) Stop() {
+	if ui.mainView != nil {
+		ui.mainView.saveDrafts()
+	}
 	ui.app.Stop()
 }
 
@@ -90,6 +93,7 @@ func (ui *GomuksUI) Render() {
 }
 
 func (ui *GomuksUI) OnLogin() {
+	applySenderColors(ui.gmx.Config().SenderColors)
 	ui.SetView(ViewMain)
 }
 
@@ -0,0 +1,118 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// Upload speed and remaining time are only shown for files at least this large.
+	largeUploadSize     = 1024 * 1024
+	uploadProgressWidth = 10
+)
+
+// mediaUpload is a file upload that is shown in the status bar of the room until it's done.
+type mediaUpload struct {
+	name    string
+	sent    int64
+	total   int64
+	started time.Time
+	cancel  context.CancelFunc
+}
+
+func (upload *mediaUpload) String() string {
+	var buf strings.Builder
+	buf.WriteString("Uploading ")
+	buf.WriteString(upload.name)
+	if upload.total <= 0 {
+		return buf.String()
+	}
+	filled := int(upload.sent * uploadProgressWidth / upload.total)
+	_, _ = fmt.Fprintf(&buf, " [%s%s] %d%%", strings.Repeat("#", filled), strings.Repeat("-", uploadProgressWidth-filled),
+		upload.sent*100/upload.total)
+	elapsed := time.Since(upload.started)
+	if upload.total >= largeUploadSize && upload.sent > 0 && elapsed >= time.Second {
+		speed := float64(upload.sent) / elapsed.Seconds()
+		remaining := time.Duration(float64(upload.total-upload.sent) / speed * float64(time.Second))
+		_, _ = fmt.Fprintf(&buf, " %s/s, %s left", formatSize(int64(speed)), remaining.Round(time.Second))
+	}
+	return buf.String()
+}
+
+// startUpload adds an upload to the status bar. The returned context is cancelled by CancelUploads.
+func (view *RoomView) startUpload(name string) (*mediaUpload, context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	upload := &mediaUpload{name: name, started: time.Now(), cancel: cancel}
+	view.uploadsLock.Lock()
+	view.uploads = append(view.uploads, upload)
+	view.uploadsLock.Unlock()
+	view.updateUploadStatus()
+	return upload, ctx
+}
+
+func (view *RoomView) finishUpload(upload *mediaUpload) {
+	upload.cancel()
+	view.uploadsLock.Lock()
+	for i, existing := range view.uploads {
+		if existing == upload {
+			view.uploads = append(view.uploads[:i], view.uploads[i+1:]...)
+			break
+		}
+	}
+	view.uploadsLock.Unlock()
+	view.updateUploadStatus()
+}
+
+func (view *RoomView) setUploadProgress(upload *mediaUpload, sent, total int64) {
+	view.uploadsLock.Lock()
+	upload.sent, upload.total = sent, total
+	view.uploadsLock.Unlock()
+	view.updateUploadStatus()
+}
+
+func (view *RoomView) updateUploadStatus() {
+	view.status.SetText(view.GetStatus())
+	view.parent.parent.Render()
+}
+
+// uploadStatus returns the progress of the most recent upload for the status bar.
+func (view *RoomView) uploadStatus() string {
+	view.uploadsLock.Lock()
+	defer view.uploadsLock.Unlock()
+	if len(view.uploads) == 0 {
+		return ""
+	}
+	status := view.uploads[len(view.uploads)-1].String()
+	if len(view.uploads) > 1 {
+		status += fmt.Sprintf(" (+%d more)", len(view.uploads)-1)
+	}
+	return status
+}
+
+// CancelUploads aborts all uploads in progress in the room and returns the number of cancelled uploads.
+func (view *RoomView) CancelUploads() int {
+	view.uploadsLock.Lock()
+	defer view.uploadsLock.Unlock()
+	for _, upload := range view.uploads {
+		upload.cancel()
+	}
+	return len(view.uploads)
+}
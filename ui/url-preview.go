@@ -0,0 +1,129 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+
+	"maunium.net/go/gomuks/debug"
+	"maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/matrix/muksevt"
+	"maunium.net/go/gomuks/matrix/rooms"
+	"maunium.net/go/gomuks/ui/messages"
+)
+
+var previewableURLRegex = regexp.MustCompile(`https?://[^\s"'<>]+[^\s"'<>.,;:!?)\]]`)
+
+// showsURLPreviews returns whether link previews are fetched for messages in the room. Previews are
+// off in encrypted rooms unless enabled for the room explicitly, as fetching them leaks the links to the server.
+func (view *RoomView) showsURLPreviews() bool {
+	switch view.Room.URLPreviews {
+	case rooms.URLPreviewsOn:
+		return true
+	case rooms.URLPreviewsOff:
+		return false
+	}
+	return view.config.Preferences.ShowURLPreviews && !view.Room.Encrypted
+}
+
+// findPreviewableURL returns the first link in the message that should get a preview card.
+func findPreviewableURL(evt *muksevt.Event) string {
+	content, ok := evt.Content.Parsed.(*event.MessageEventContent)
+	if !ok || (content.MsgType != event.MsgText && content.MsgType != event.MsgNotice && content.MsgType != event.MsgEmote) {
+		return ""
+	}
+	if len(evt.Gomuks.Edits) > 0 {
+		if newContent := evt.Gomuks.Edits[len(evt.Gomuks.Edits)-1].Content.AsMessage().NewContent; newContent != nil {
+			content = newContent
+		}
+	}
+	for _, link := range previewableURLRegex.FindAllString(event.TrimReplyFallbackText(content.Body), -1) {
+		// Matrix permalinks are handled by /goto instead.
+		if !permalinkRegex.MatchString(link) {
+			return link
+		}
+	}
+	return ""
+}
+
+// newURLPreviewCard returns the card shown under a message for the given preview, or nil if the page
+// didn't have anything to show.
+func newURLPreviewCard(preview *ifc.URLPreview) *messages.URLPreviewCard {
+	if len(preview.Title) == 0 && len(preview.Description) == 0 {
+		return nil
+	}
+	return &messages.URLPreviewCard{Preview: preview}
+}
+
+// loadURLPreview fetches the preview of the given link and shows it under the message.
+func (view *RoomView) loadURLPreview(msg *messages.UIMessage, link string) {
+	defer debug.Recover()
+	preview, err := view.parent.matrix.GetURLPreview(link)
+	if err != nil {
+		debug.Printf("Failed to get preview of %s: %v", link, err)
+		return
+	}
+	card := newURLPreviewCard(preview)
+	if card == nil {
+		return
+	}
+	view.updateMessage(msg, func() {
+		msg.URLPreview = card
+	})
+	view.parent.parent.Render()
+}
+
+func urlPreviewMode(room *rooms.Room) string {
+	switch room.URLPreviews {
+	case rooms.URLPreviewsOn, rooms.URLPreviewsOff:
+		return room.URLPreviews
+	default:
+		return "default"
+	}
+}
+
+func cmdURLPreviews(cmd *Command) {
+	room := cmd.Room.MxRoom()
+	if len(cmd.Args) == 0 {
+		current := "shown"
+		if !cmd.Room.showsURLPreviews() {
+			current = "not shown"
+		}
+		cmd.Reply("Link previews in this room: %s (currently %s)", urlPreviewMode(room), current)
+		return
+	}
+	switch strings.ToLower(cmd.Args[0]) {
+	case "on":
+		room.URLPreviews = rooms.URLPreviewsOn
+	case "off":
+		room.URLPreviews = rooms.URLPreviewsOff
+	case "default":
+		room.URLPreviews = ""
+	default:
+		cmd.Reply("Usage: /%s <on|off|default>", cmd.OrigCommand)
+		return
+	}
+	msg := fmt.Sprintf("Link previews in this room are now %s", urlPreviewMode(room))
+	if room.URLPreviews == rooms.URLPreviewsOn && room.Encrypted {
+		msg += ". Note that the links in this encrypted room will be sent to your homeserver to fetch the previews."
+	}
+	cmd.Reply(msg)
+}
@@ -30,6 +30,7 @@ import (
 	"maunium.net/go/gomuks/debug"
 	"maunium.net/go/mautrix/crypto"
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
 type EmojiView struct {
@@ -78,7 +79,10 @@ type VerificationModal struct {
 	progressMax int
 	stopWaiting chan struct{}
 	confirmChan chan bool
+	requestChan chan bool
 	done        bool
+	// Whether the modal is asking the user to accept an incoming verification request.
+	awaitingRequest bool
 
 	parent *MainView
 }
@@ -89,6 +93,7 @@ func NewVerificationModal(mainView *MainView, device *crypto.DeviceIdentity, tim
 		device:      device,
 		stopWaiting: make(chan struct{}),
 		confirmChan: make(chan bool),
+		requestChan: make(chan bool, 1),
 		done:        false,
 	}
 
@@ -126,6 +131,55 @@ func NewVerificationModal(mainView *MainView, device *crypto.DeviceIdentity, tim
 	return vm
 }
 
+// IncomingVerificationTimeout is how long the user has to accept an incoming verification request.
+const IncomingVerificationTimeout = 60 * time.Second
+
+// AcceptVerificationFrom asks the user whether or not to accept an incoming interactive verification request.
+//
+// This is called by the crypto module when a m.key.verification.request or m.key.verification.start is received.
+// The matrix package passes those events to the crypto module on a separate goroutine, so waiting for the
+// answer here doesn't block syncing.
+func (view *MainView) AcceptVerificationFrom(_ string, device *crypto.DeviceIdentity, roomID id.RoomID) (crypto.VerificationRequestResponse, crypto.VerificationHooks) {
+	vm := NewVerificationModal(view, device, IncomingVerificationTimeout)
+	location := "to-device"
+	if len(roomID) > 0 {
+		location = fmt.Sprintf("in %s", roomID)
+	}
+	vm.awaitingRequest = true
+	vm.infoText.SetText(fmt.Sprintf(
+		"%s (%s) of %s\n"+
+			"requested verification (%s).\n"+
+			"Type \"yes\" to accept or \"no\" to reject", device.Name, device.DeviceID, device.UserID, location))
+	vm.inputBar.
+		SetTextColor(tcell.ColorWhite).
+		SetBackgroundColor(tcell.ColorDarkCyan).
+		SetPlaceholder("Type \"yes\" or \"no\"").
+		Focus()
+	view.ShowModal(vm)
+	view.parent.Render()
+
+	var accept bool
+	select {
+	case accept = <-vm.requestChan:
+	case <-time.After(IncomingVerificationTimeout):
+		debug.Print("Incoming verification request from", device.UserID, device.DeviceID, "timed out")
+	}
+	vm.awaitingRequest = false
+	if !accept {
+		select {
+		case vm.stopWaiting <- struct{}{}:
+		default:
+		}
+		view.HideModal()
+		view.parent.Render()
+		return crypto.RejectRequest, nil
+	}
+	vm.progress = vm.progressMax
+	vm.infoText.SetText(fmt.Sprintf("Waiting for %s\nto start verification", device.UserID))
+	view.parent.Render()
+	return crypto.AcceptRequest, vm
+}
+
 func (vm *VerificationModal) decrementWaitingBar() {
 	for {
 		select {
@@ -213,6 +267,25 @@ func (vm *VerificationModal) OnKeyEvent(event mauview.KeyEvent) bool {
 			return true
 		}
 		return false
+	} else if vm.awaitingRequest {
+		if event.Key() != tcell.KeyEnter {
+			return vm.inputBar.OnKeyEvent(event)
+		}
+		text := strings.ToLower(strings.TrimSpace(vm.inputBar.GetText()))
+		if text == "yes" || text == "no" {
+			debug.Print("Answering incoming verification request:", text)
+			select {
+			case vm.requestChan <- text == "yes":
+			default:
+				// The request already timed out or was answered
+			}
+			vm.inputBar.
+				SetPlaceholder("").
+				SetTextAndMoveCursor("").
+				SetBackgroundColor(tcell.ColorDefault).
+				SetTextColor(tcell.ColorDefault)
+		}
+		return true
 	} else if vm.emojiText.Data == nil {
 		debug.Print("Ignoring pre-emoji key event")
 		return false
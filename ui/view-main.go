@@ -20,7 +20,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
-	"sync/atomic"
+	"path/filepath"
 	"time"
 
 	sync "github.com/sasha-s/go-deadlock"
@@ -34,6 +34,7 @@ import (
 	"maunium.net/go/gomuks/config"
 	"maunium.net/go/gomuks/debug"
 	"maunium.net/go/gomuks/interface"
+	"maunium.net/go/gomuks/lib/appendlog"
 	"maunium.net/go/gomuks/lib/notification"
 	"maunium.net/go/gomuks/matrix/rooms"
 	"maunium.net/go/gomuks/ui/widget"
@@ -44,7 +45,10 @@ type MainView struct {
 	flex *mauview.Flex
 
 	roomList     *RoomList
+	roomPreview  *RoomPreview
+	roomSummary  *RoomSummary
 	roomView     *mauview.Box
+	panes        *PaneLayout
 	currentRoom  *RoomView
 	rooms        map[id.RoomID]*RoomView
 	roomsLock    sync.RWMutex
@@ -53,6 +57,20 @@ type MainView struct {
 
 	modal mauview.Component
 
+	modQueue []ModQueueEntry
+	archive  []*rooms.Room
+
+	qrVerification *QRVerificationModal
+
+	audio        *audioPlayer
+	recorder     *voiceRecorder
+	recorderLock sync.Mutex
+
+	notificationLog *appendlog.Log
+
+	// Questions caused by incoming events, like key requests, which are asked one at a time.
+	prompts chan func()
+
 	lastFocusTime time.Time
 
 	matrix ifc.MatrixContainer
@@ -71,9 +89,20 @@ func (ui *GomuksUI) NewMainView() mauview.Component {
 		gmx:    ui.gmx,
 		config: ui.gmx.Config(),
 		parent: ui,
+
+		prompts: make(chan func(), maxQueuedPrompts),
 	}
+	mainView.notificationLog = appendlog.New(filepath.Join(mainView.config.CacheDir, "notifications.log"),
+		mainView.config.CacheCipher, NotificationLogMaxAge, NotificationLogMaxEntries)
+	mainView.audio = &audioPlayer{parent: mainView}
+	go mainView.animate()
+	go mainView.processPrompts()
 	mainView.roomList = NewRoomList(mainView)
+	mainView.roomPreview = NewRoomPreview(mainView)
+	mainView.roomSummary = NewRoomSummary(mainView)
 	mainView.cmdProcessor = NewCommandProcessor(mainView)
+	mainView.panes = NewPaneLayout(mainView)
+	mainView.roomView.SetInnerComponent(mainView.panes)
 
 	mainView.flex.
 		AddFixedComponent(mainView.roomList, 25).
@@ -103,14 +132,21 @@ func (view *MainView) HideModal() {
 }
 
 func (view *MainView) Draw(screen mauview.Screen) {
+	width, _ := screen.Size()
+	messages.SetTerminalSize(width)
+	widget.ResetHyperlinks()
 	if view.config.Preferences.HideRoomList {
 		view.roomView.Draw(screen)
 	} else {
 		view.flex.Draw(screen)
+		view.roomPreview.Draw(screen)
+		view.roomSummary.Draw(screen)
 	}
 
 	if view.modal != nil {
 		view.modal.Draw(screen)
+	} else if !view.config.Preferences.DisableHyperlinks {
+		view.parent.queueHyperlinks()
 	}
 }
 
@@ -128,13 +164,14 @@ func (view *MainView) MarkRead(roomView *RoomView) {
 			msg := msgList[len(msgList)-1]
 			if roomView.Room.MarkRead(msg.ID()) {
 				view.matrix.MarkRead(roomView.Room.ID, msg.ID())
+				view.roomList.Bump(roomView.Room)
 			}
 		}
 	}
 }
 
 func (view *MainView) InputChanged(roomView *RoomView, text string) {
-	if !roomView.config.Preferences.DisableTypingNotifs {
+	if roomView.sendsTyping() {
 		view.matrix.SendTyping(roomView.Room.ID, len(text) > 0 && text[0] != '/')
 	}
 }
@@ -163,6 +200,23 @@ func (view *MainView) OpenSyncingModal() ifc.SyncingModal {
 	return modal
 }
 
+// animate redraws the screen regularly while an animated image is visible in the current room.
+// The redraw rate is capped by the max_animation_fps preference to avoid hammering slow terminals.
+func (view *MainView) animate() {
+	for {
+		time.Sleep(view.config.Preferences.GetAnimationInterval())
+		if view.modal != nil || view.config.Preferences.DisableAnimations {
+			continue
+		}
+		for _, msgView := range view.panes.Timelines() {
+			if msgView.HasVisibleAnimations() {
+				view.parent.Render()
+				break
+			}
+		}
+	}
+}
+
 func (view *MainView) OnKeyEvent(event mauview.KeyEvent) bool {
 	view.BumpFocus(view.currentRoom)
 
@@ -172,8 +226,16 @@ func (view *MainView) OnKeyEvent(event mauview.KeyEvent) bool {
 
 	k := event.Key()
 	c := event.Rune()
+	if k == tcell.KeyF1 {
+		view.ShowModal(NewCommandPalette(view, 80, 20))
+		return true
+	}
 	if event.Modifiers() == tcell.ModCtrl || event.Modifiers() == tcell.ModAlt {
 		switch {
+		case c == 'P' && event.Modifiers() == tcell.ModAlt:
+			view.ShowModal(NewCommandPalette(view, 80, 20))
+		case c == 'S' && event.Modifiers() == tcell.ModAlt:
+			view.CycleRoomSort()
 		case k == tcell.KeyDown:
 			view.SwitchRoom(view.roomList.Next())
 		case k == tcell.KeyUp:
@@ -181,17 +243,89 @@ func (view *MainView) OnKeyEvent(event mauview.KeyEvent) bool {
 		case c == 'k' || k == tcell.KeyCtrlK:
 			view.ShowModal(NewFuzzySearchModal(view, 42, 12))
 		case k == tcell.KeyHome:
+			if view.currentRoom == nil {
+				goto defaultHandler
+			}
 			msgView := view.currentRoom.MessageView()
 			msgView.AddScrollOffset(msgView.TotalHeight())
 		case k == tcell.KeyEnd:
+			if view.currentRoom == nil {
+				goto defaultHandler
+			}
 			msgView := view.currentRoom.MessageView()
 			msgView.AddScrollOffset(-msgView.TotalHeight())
 		case k == tcell.KeyEnter:
 			return view.flex.OnKeyEvent(tcell.NewEventKey(tcell.KeyEnter, '\n', event.Modifiers()|tcell.ModShift, ""))
 		case c == 'a':
 			view.SwitchRoom(view.roomList.NextWithActivity())
+		case c == 'r' || k == tcell.KeyCtrlR:
+			view.FocusRoomList()
+		case c == 'z':
+			if view.currentRoom == nil || !view.currentRoom.UndoSend() {
+				goto defaultHandler
+			}
 		case c == 'l' || k == tcell.KeyCtrlL:
 			view.ShowBare(view.currentRoom)
+		case c == 'u' && event.Modifiers() == tcell.ModAlt:
+			if view.currentRoom == nil {
+				goto defaultHandler
+			}
+			view.currentRoom.FocusMemberList()
+		case c == 'f' || k == tcell.KeyCtrlF, c == 'g':
+			if view.currentRoom == nil {
+				goto defaultHandler
+			}
+			go view.currentRoom.JumpToReadMarker()
+		case c == 't':
+			if view.currentRoom == nil {
+				goto defaultHandler
+			}
+			go view.currentRoom.JumpToToday()
+		case c == 'p':
+			if view.currentRoom == nil {
+				goto defaultHandler
+			}
+			go view.currentRoom.JumpToMention(true)
+		case c == 'n':
+			if view.currentRoom == nil {
+				goto defaultHandler
+			}
+			go view.currentRoom.JumpToMention(false)
+		case c == ' ' && event.Modifiers() == tcell.ModAlt:
+			if !view.audio.TogglePause() {
+				goto defaultHandler
+			}
+		case c == ',' && event.Modifiers() == tcell.ModAlt:
+			if !view.audio.Seek(-view.config.Audio.GetSeekDuration()) {
+				goto defaultHandler
+			}
+		case c == '.' && event.Modifiers() == tcell.ModAlt:
+			if !view.audio.Seek(view.config.Audio.GetSeekDuration()) {
+				goto defaultHandler
+			}
+		case c == 's' && event.Modifiers() == tcell.ModAlt:
+			if !view.audio.Stop() {
+				goto defaultHandler
+			}
+		case c == 'o' && event.Modifiers() == tcell.ModAlt:
+			if view.currentRoom == nil || !view.currentRoom.ShowLinkHints() {
+				goto defaultHandler
+			}
+		case c == 'v' && event.Modifiers() == tcell.ModAlt:
+			view.SplitPane(true)
+		case c == 'b' && event.Modifiers() == tcell.ModAlt:
+			view.SplitPane(false)
+		case c == 'x' && event.Modifiers() == tcell.ModAlt:
+			if !view.ClosePane() {
+				goto defaultHandler
+			}
+		case c == 'w' && event.Modifiers() == tcell.ModAlt:
+			view.CyclePane(true)
+		case c == 'm' && event.Modifiers() == tcell.ModAlt:
+			if view.currentRoom == nil || len(view.currentRoom.input.GetText()) == 0 {
+				goto defaultHandler
+			}
+			view.ShowModal(NewMarkdownPreviewModal(view, view.currentRoom))
 		default:
 			goto defaultHandler
 		}
@@ -237,6 +371,66 @@ func (view *MainView) Blur() {
 	}
 }
 
+// FocusRoomList moves keyboard focus to the room list.
+func (view *MainView) FocusRoomList() {
+	if view.config.Preferences.HideRoomList {
+		return
+	}
+	view.flex.SetFocused(view.roomList)
+	view.focused = view.roomList
+	view.roomPreview.Open()
+	view.parent.Render()
+}
+
+// FocusRoomView moves keyboard focus back to the current room view.
+func (view *MainView) FocusRoomView() {
+	view.flex.SetFocused(view.roomView)
+	view.focused = view.roomView
+	view.parent.Render()
+}
+
+// SplitPane splits the focused pane in two, either side by side or on top of each other,
+// and focuses the new pane so that a room can be picked for it.
+func (view *MainView) SplitPane(sideBySide bool) {
+	view.panes.Split(sideBySide)
+	view.updateFocusedPane()
+}
+
+// ClosePane closes the focused pane. Returns false if it's the only pane.
+func (view *MainView) ClosePane() bool {
+	if !view.panes.Close() {
+		return false
+	}
+	view.updateFocusedPane()
+	return true
+}
+
+// CyclePane moves focus to the next or previous pane.
+func (view *MainView) CyclePane(forward bool) {
+	view.panes.Cycle(forward)
+	view.updateFocusedPane()
+}
+
+// FocusPane moves focus to the given pane.
+func (view *MainView) FocusPane(pane *paneNode) {
+	view.panes.focused = pane
+	view.updateFocusedPane()
+}
+
+// updateFocusedPane makes the room in the focused pane the current room after the focused pane changed.
+func (view *MainView) updateFocusedPane() {
+	if room := view.panes.FocusedRoom(); room != nil {
+		view.SwitchRoom("", room.Room)
+		return
+	}
+	if view.currentRoom != nil {
+		view.currentRoom.saveDraft()
+		view.currentRoom = nil
+	}
+	view.FocusRoomView()
+	view.roomView.Focus()
+}
+
 func (view *MainView) SwitchRoom(tag string, room *rooms.Room) {
 	view.switchRoom(tag, room, true)
 }
@@ -254,10 +448,18 @@ func (view *MainView) switchRoom(tag string, room *rooms.Room, lock bool) {
 		return
 	}
 	roomView.Update()
-	view.roomView.SetInnerComponent(roomView)
+	if view.currentRoom != roomView {
+		if view.currentRoom != nil {
+			view.currentRoom.saveDraft()
+		}
+		roomView.restoreDraft()
+		roomView.content.SetReadMarker(room.FullyRead)
+	}
+	view.panes.Show(roomView)
 	view.currentRoom = roomView
 	view.MarkRead(roomView)
 	view.roomList.SetSelected(tag, room)
+	view.config.AuthCache.LastRoom = room.ID
 	view.flex.SetFocused(view.roomView)
 	view.focused = view.roomView
 	view.roomView.Focus()
@@ -267,7 +469,7 @@ func (view *MainView) switchRoom(tag string, room *rooms.Room, lock bool) {
 		msgView.initialHistoryLoaded = true
 		go view.LoadHistory(room.ID)
 	}
-	if !room.MembersFetched {
+	if !room.MembersFetched && !room.HasLeft {
 		go func() {
 			err := view.matrix.FetchMembers(room)
 			if err != nil {
@@ -324,6 +526,9 @@ func (view *MainView) RemoveRoom(room *rooms.Room) {
 	debug.Print("Removing", room.ID, room.GetTitle())
 
 	view.roomList.Remove(room)
+	if roomView, ok := view.getRoomView(room.ID, false); ok {
+		view.panes.Remove(roomView)
+	}
 	t, r := view.roomList.Selected()
 	view.switchRoom(t, r, false)
 	delete(view.rooms, room.ID)
@@ -360,20 +565,61 @@ func (view *MainView) SetRooms(rooms *rooms.RoomCache) {
 		view.roomList.Add(room)
 		view.addRoomPage(room)
 	}
-	t, r := view.roomList.First()
+	t, r := view.startupRoom(rooms)
 	view.switchRoom(t, r, false)
 	view.roomsLock.Unlock()
 }
 
+// startupRoom finds the room to open first based on the startup_room config option or the --room flag.
+func (view *MainView) startupRoom(cache *rooms.RoomCache) (string, *rooms.Room) {
+	var room *rooms.Room
+	switch target := view.config.GetStartupRoom(); target {
+	case "", "first":
+	case "last":
+		room = cache.Map[view.config.AuthCache.LastRoom]
+	case "notification":
+		room = cache.Map[view.config.AuthCache.LastNotificationRoom]
+	default:
+		room = cache.Map[id.RoomID(target)]
+		if room == nil {
+			for _, candidate := range cache.Map {
+				if string(candidate.GetCanonicalAlias()) == target {
+					room = candidate
+					break
+				}
+			}
+		}
+		if room == nil {
+			debug.Print("Startup room", target, "not found")
+		}
+	}
+	if room == nil || room.HasLeft {
+		return view.roomList.First()
+	}
+	var tag string
+	if tags := room.Tags(); len(tags) > 0 {
+		tag = tags[0].Tag
+	}
+	return tag, room
+}
+
 func (view *MainView) UpdateTags(room *rooms.Room) {
 	if !view.roomList.Contains(room.ID) {
 		return
 	}
 	reselect := view.roomList.selected == room
+	selectedTag := view.roomList.selectedTag
 	view.roomList.Remove(room)
 	view.roomList.Add(room)
 	if reselect {
-		view.roomList.SetSelected(room.Tags()[0].Tag, room)
+		// Stay in the same section if the room is still in it, e.g. after moving it in a manually sorted section
+		tag := room.Tags()[0].Tag
+		for _, newTag := range room.Tags() {
+			if newTag.Tag == selectedTag {
+				tag = selectedTag
+			}
+		}
+		view.roomList.SetSelected(tag, room)
 	}
 	view.parent.Render()
 }
@@ -386,12 +632,98 @@ func (view *MainView) SetTyping(roomID id.RoomID, users []id.UserID) {
 	}
 }
 
-func sendNotification(room *rooms.Room, sender, text string, critical, sound bool) {
+// UpdatePresence moves the given user up in the member lists of open rooms when their presence shows new activity.
+func (view *MainView) UpdatePresence(userID id.UserID, lastActive time.Time) {
+	view.roomsLock.RLock()
+	for _, roomView := range view.rooms {
+		if roomView.userListLoaded {
+			roomView.userList.UpdateActivity(userID, lastActive)
+		}
+	}
+	view.roomsLock.RUnlock()
+	view.parent.Render()
+}
+
+// findDirectChat returns a direct chat with the given user that hasn't been left, or nil if there is none.
+func (view *MainView) findDirectChat(userID id.UserID) *rooms.Room {
+	view.roomsLock.RLock()
+	defer view.roomsLock.RUnlock()
+	for _, roomView := range view.rooms {
+		if room := roomView.Room; room.IsDirect && room.OtherUser == userID && !room.HasLeft {
+			return room
+		}
+	}
+	return nil
+}
+
+// OpenDirectChat switches to an existing direct chat with the given user or creates a new one.
+func (view *MainView) OpenDirectChat(userID id.UserID) {
+	defer debug.Recover()
+	if existing := view.findDirectChat(userID); existing != nil {
+		view.SwitchRoom("", existing)
+		view.parent.Render()
+		return
+	}
+	if view.currentRoom == nil {
+		return
+	}
+	cmd := view.cmdProcessor.ParseCommand(view.currentRoom, fmt.Sprintf("/pm %s", userID))
+	if cmd != nil {
+		view.cmdProcessor.HandleCommand(cmd)
+	}
+}
+
+// ShowServiceMessage shows a message from gomuks itself in the currently open room.
+func (view *MainView) ShowServiceMessage(message string) {
+	if view.currentRoom != nil {
+		view.currentRoom.AddServiceMessage(message)
+		view.parent.Render()
+	}
+}
+
+const (
+	notificationActionOpen     = "open"
+	notificationActionMarkRead = "markread"
+)
+
+var notificationActions = []notification.Action{
+	{Key: notificationActionOpen, Label: "Open"},
+	{Key: notificationActionMarkRead, Label: "Mark as read"},
+}
+
+func (view *MainView) sendNotification(room *rooms.Room, sender, text string, critical, sound bool) {
 	if room.GetTitle() != sender {
 		sender = fmt.Sprintf("%s (%s)", sender, room.GetTitle())
 	}
 	debug.Printf("Sending notification with body \"%s\" from %s in room ID %s (critical=%v, sound=%v)", text, sender, room.ID, critical, sound)
-	notification.Send(sender, text, critical, sound)
+	go func() {
+		defer debug.Recover()
+		action, err := notification.SendWithActions(sender, text, critical, sound, notificationActions...)
+		if err != nil {
+			debug.Print("Failed to send notification:", err)
+			return
+		}
+		switch action {
+		case notificationActionOpen:
+			view.config.AuthCache.LastNotificationRoom = room.ID
+			view.SwitchRoom("", room)
+		case notificationActionMarkRead:
+			view.MarkRoomRead(room)
+		}
+	}()
+}
+
+// MarkRoomRead marks all messages in the given room as read without switching to it.
+func (view *MainView) MarkRoomRead(room *rooms.Room) {
+	eventID := room.LatestUnread()
+	if len(eventID) == 0 {
+		return
+	}
+	if room.MarkRead(eventID) {
+		view.matrix.MarkRead(room.ID, eventID)
+		view.roomList.Bump(room)
+	}
+	view.parent.Render()
 }
 
 func (view *MainView) Bump(room *rooms.Room) {
@@ -416,6 +748,8 @@ func (view *MainView) NotifyMessage(room *rooms.Room, message ifc.Message, shoul
 	if !isCurrent || !isFocused {
 		// The message is not in the current room, show new message status in room list.
 		room.AddUnread(message.ID(), shouldNotify, should.Highlight)
+		// Move the room again in case the room list is sorted by unread status
+		view.Bump(room)
 	} else {
 		view.matrix.MarkRead(room.ID, message.ID())
 	}
@@ -425,7 +759,27 @@ func (view *MainView) NotifyMessage(room *rooms.Room, message ifc.Message, shoul
 		shouldPlaySound := should.PlaySound &&
 			should.SoundName == "default" &&
 			view.config.NotifySound
-		sendNotification(room, message.NotificationSenderName(), message.NotificationContent(), should.Highlight, shouldPlaySound)
+		view.sendNotification(room, message.NotificationSenderName(), message.NotificationContent(), should.Highlight, shouldPlaySound)
+	}
+
+	if ok && uiMsg.Event != nil {
+		entry := NotificationLogEntry{
+			Time:     time.Now(),
+			RoomID:   room.ID,
+			RoomName: room.GetTitle(),
+			Sender:   uiMsg.SenderID,
+			EventID:  uiMsg.EventID,
+			Rule:     matchingPushRule(view.config.PushRules, room, uiMsg.Event.Event),
+			Notified: shouldNotify && !recentlyFocused && !view.config.Preferences.DisableNotifications,
+		}
+		if !shouldNotify {
+			entry.Reason = "suppressed by push rule"
+		} else if view.config.Preferences.DisableNotifications {
+			entry.Reason = "desktop notifications are disabled"
+		} else if recentlyFocused {
+			entry.Reason = "terminal was focused recently"
+		}
+		view.notificationLog.Append(entry)
 	}
 
 	// TODO this should probably happen somewhere else
@@ -434,18 +788,32 @@ func (view *MainView) NotifyMessage(room *rooms.Room, message ifc.Message, shoul
 }
 
 func (view *MainView) LoadHistory(roomID id.RoomID) {
+	view.loadHistory(roomID, false)
+}
+
+// loadHistory loads more history into the main timeline of the room. If history is already being loaded,
+// it either returns right away or, if wait is true, waits for the other load to finish instead.
+func (view *MainView) loadHistory(roomID id.RoomID, wait bool) {
 	defer debug.Recover()
 	roomView, ok := view.getRoomView(roomID, true)
 	if !ok {
 		return
 	}
-	msgView := roomView.MessageView()
+	// History is always loaded into the main timeline, even if a thread or permalink context is open.
+	msgView := roomView.content
 
-	if !atomic.CompareAndSwapInt32(&msgView.loadingMessages, 0, 1) {
-		// Locked
+	select {
+	case msgView.historyLoad <- struct{}{}:
+	default:
+		if wait {
+			msgView.historyLoad <- struct{}{}
+			<-msgView.historyLoad
+		}
 		return
 	}
-	defer atomic.StoreInt32(&msgView.loadingMessages, 0)
+	defer func() {
+		<-msgView.historyLoad
+	}()
 	// Update the "Loading more messages..." text
 	view.parent.Render()
 
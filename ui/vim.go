@@ -0,0 +1,121 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+)
+
+type vimMode string
+
+const (
+	VimNormal vimMode = "NORMAL"
+	VimInsert vimMode = "INSERT"
+	VimVisual vimMode = "VISUAL"
+)
+
+// VimMode returns the current mode of the vim-style modal input, or an empty string if it's disabled.
+func (view *RoomView) VimMode() vimMode {
+	switch {
+	case !view.config.Preferences.VimMode:
+		return ""
+	case view.selecting:
+		return VimVisual
+	case view.vimInsert:
+		return VimInsert
+	default:
+		return VimNormal
+	}
+}
+
+// SetVimInsert switches between the insert and normal modes of the vim-style modal input.
+func (view *RoomView) SetVimInsert(insert bool) {
+	view.vimInsert = insert
+	view.status.SetText(view.GetStatus())
+}
+
+// onVimKey handles key events in the normal mode of the vim-style modal input, and the escape key
+// in the insert mode. Returns false if the event should be handled normally.
+func (view *RoomView) onVimKey(event mauview.KeyEvent) bool {
+	switch view.VimMode() {
+	case VimInsert:
+		if event.Key() == tcell.KeyEscape {
+			view.SetVimInsert(false)
+			return true
+		}
+		return false
+	case VimNormal:
+	default:
+		return false
+	}
+	switch event.Key() {
+	case tcell.KeyEnter, tcell.KeyBackspace, tcell.KeyBackspace2, tcell.KeyDelete:
+		// Don't send or edit the text in the input field outside insert mode.
+		return true
+	case tcell.KeyRune:
+		if event.Modifiers()&(tcell.ModCtrl|tcell.ModAlt) != 0 {
+			return false
+		}
+	default:
+		return false
+	}
+	msgView := view.MessageView()
+	switch event.Rune() {
+	case 'i', 'a':
+		view.SetVimInsert(true)
+	case ':':
+		view.SetVimInsert(true)
+		view.input.SetText("/")
+	case 'j':
+		msgView.AddScrollOffset(-1)
+	case 'k':
+		msgView.AddScrollOffset(+1)
+	case 'd':
+		msgView.AddScrollOffset(-msgView.Height() / 2)
+	case 'u':
+		if msgView.IsAtTop() {
+			go view.parent.LoadHistory(view.Room.ID)
+		}
+		msgView.AddScrollOffset(+msgView.Height() / 2)
+	case 'g':
+		msgView.AddScrollOffset(msgView.TotalHeight())
+	case 'G':
+		msgView.AddScrollOffset(-msgView.TotalHeight())
+	case 'J':
+		view.parent.SwitchRoom(view.parent.roomList.Next())
+	case 'K':
+		view.parent.SwitchRoom(view.parent.roomList.Previous())
+	case 'h':
+		view.parent.FocusRoomList()
+	case 'v', 'V':
+		view.StartSelecting(SelectYank, "")
+		view.ToggleVisualSelection()
+	case 'r':
+		view.SetVimInsert(true)
+		view.StartSelecting(SelectReply, "")
+	case 'e':
+		view.SetVimInsert(true)
+		view.StartSelecting(SelectEdit, "")
+	case 'o':
+		if !view.ShowLinkHints() {
+			view.AddServiceMessage("There are no links on the screen.")
+		}
+	}
+	// Unbound keys are swallowed too so that they don't end up in the input field.
+	return true
+}
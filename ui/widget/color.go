@@ -20,6 +20,8 @@ import (
 	"fmt"
 	"hash/fnv"
 
+	sync "github.com/sasha-s/go-deadlock"
+
 	"maunium.net/go/tcell"
 
 	"maunium.net/go/mautrix/id"
@@ -173,13 +175,59 @@ var colorNames = []string{
 	"slategrey",
 }
 
+// SenderPalettes are the built-in palettes that hash-based colors can be picked from.
+//
+// The default palette contains all the color names specified in tcell.ColorNames. The terminal palette
+// only contains the basic ANSI colors, which are defined by the color theme of the terminal.
+var SenderPalettes = map[string][]string{
+	"default": colorNames,
+	"terminal": {
+		"maroon", "green", "olive", "navy", "purple", "teal",
+		"red", "lime", "yellow", "blue", "fuchsia", "aqua",
+	},
+}
+
+var senderColors = struct {
+	sync.RWMutex
+	palette   []string
+	overrides map[string]tcell.Color
+	version   uint32
+}{palette: colorNames}
+
+// SetSenderColors changes the palette used for hash-based colors and the fixed colors of specific users.
+// The palette and the overrides can contain color names or #rrggbb hex codes.
+func SetSenderColors(palette []string, overrides map[id.UserID]string) {
+	if len(palette) == 0 {
+		palette = colorNames
+	}
+	colorOverrides := make(map[string]tcell.Color, len(overrides))
+	for userID, color := range overrides {
+		if parsed := tcell.GetColor(color); parsed != tcell.ColorDefault {
+			colorOverrides[string(userID)] = parsed
+		}
+	}
+	senderColors.Lock()
+	senderColors.palette = palette
+	senderColors.overrides = colorOverrides
+	senderColors.version++
+	senderColors.Unlock()
+}
+
+// SenderColorsVersion returns a number that changes every time SetSenderColors is called,
+// so that cached renders using the old colors can be invalidated.
+func SenderColorsVersion() uint32 {
+	senderColors.RLock()
+	defer senderColors.RUnlock()
+	return senderColors.version
+}
+
 // GetHashColorName gets a color name for the given string based on its FNV-1 hash.
 //
-// The array of possible color names are the alphabetically ordered color
-// names specified in tcell.ColorNames.
+// The array of possible color names is the palette set with SetSenderColors, by default
+// the alphabetically ordered color names specified in tcell.ColorNames.
 //
 // The algorithm to get the color is as follows:
-//  colorNames[ FNV1(string) % len(colorNames) ]
+//  palette[ FNV1(string) % len(palette) ]
 //
 // With the exception of the three special cases:
 //  --> = green
@@ -196,24 +244,35 @@ func GetHashColorName(s string) string {
 	default:
 		h := fnv.New32a()
 		_, _ = h.Write([]byte(s))
-		return colorNames[h.Sum32()%uint32(len(colorNames))]
+		senderColors.RLock()
+		defer senderColors.RUnlock()
+		return senderColors.palette[h.Sum32()%uint32(len(senderColors.palette))]
 	}
 }
 
 // GetHashColor gets the tcell Color value for the given string.
 //
-// GetHashColor calls GetHashColorName() and gets the Color value from the tcell.ColorNames map.
+// If the string has a color override set with SetSenderColors, that color is used.
+// Otherwise, GetHashColor calls GetHashColorName() and parses the color name.
 func GetHashColor(val interface{}) tcell.Color {
-	switch str := val.(type) {
+	var str string
+	switch typed := val.(type) {
 	case string:
-		return tcell.ColorNames[GetHashColorName(str)]
+		str = typed
 	case *string:
-		return tcell.ColorNames[GetHashColorName(*str)]
+		str = *typed
 	case id.UserID:
-		return tcell.ColorNames[GetHashColorName(string(str))]
+		str = string(typed)
 	default:
 		return tcell.ColorNames["red"]
 	}
+	senderColors.RLock()
+	color, ok := senderColors.overrides[str]
+	senderColors.RUnlock()
+	if ok {
+		return color
+	}
+	return tcell.GetColor(GetHashColorName(str))
 }
 
 // AddColor adds tview color tags to the given string.
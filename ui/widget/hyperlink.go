@@ -0,0 +1,156 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package widget
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	sync "github.com/sasha-s/go-deadlock"
+
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+)
+
+// URLRegex matches http(s) URLs in plain text, leaving out trailing punctuation.
+var URLRegex = regexp.MustCompile(`https?://[^\s"'<>]+[^\s"'<>.,;:!?)\]]`)
+
+// Hyperlink is an area on a single row of the terminal that links to an URL.
+type Hyperlink struct {
+	X, Y, Width int
+	URL         string
+}
+
+var (
+	hyperlinks     []Hyperlink
+	hyperlinksLock sync.Mutex
+)
+
+// ResetHyperlinks forgets the links registered while drawing the previous frame.
+func ResetHyperlinks() {
+	hyperlinksLock.Lock()
+	hyperlinks = hyperlinks[:0]
+	hyperlinksLock.Unlock()
+}
+
+// Hyperlinks returns the links registered while drawing the current frame, in the order they were drawn.
+func Hyperlinks() []Hyperlink {
+	hyperlinksLock.Lock()
+	defer hyperlinksLock.Unlock()
+	links := make([]Hyperlink, len(hyperlinks))
+	copy(links, hyperlinks)
+	return links
+}
+
+// HyperlinkCount returns the number of links registered in the current frame so far.
+func HyperlinkCount() int {
+	hyperlinksLock.Lock()
+	defer hyperlinksLock.Unlock()
+	return len(hyperlinks)
+}
+
+// ScreenOffset returns the position of the top left corner of the screen on the terminal.
+func ScreenOffset(screen mauview.Screen) (x, y int) {
+	for {
+		proxy, ok := screen.(*mauview.ProxyScreen)
+		if !ok {
+			return
+		}
+		x += proxy.OffsetX
+		y += proxy.OffsetY
+		screen = proxy.Parent
+	}
+}
+
+// AddHyperlink registers the given area of the screen as a link to the given URL. The coordinates are
+// translated to terminal coordinates and clipped to the visible area of the screen. Links drawn on screens
+// that don't end up on the terminal (e.g. hidden spoilers) are ignored.
+func AddHyperlink(screen mauview.Screen, x, y, width int, url string) {
+	if len(url) == 0 {
+		return
+	}
+	for {
+		proxy, ok := screen.(*mauview.ProxyScreen)
+		if !ok {
+			break
+		}
+		if y < 0 || (proxy.Height >= 0 && y >= proxy.Height) {
+			return
+		}
+		if x < 0 {
+			width += x
+			x = 0
+		}
+		if proxy.Width >= 0 && x+width > proxy.Width {
+			width = proxy.Width - x
+		}
+		x += proxy.OffsetX
+		y += proxy.OffsetY
+		screen = proxy.Parent
+	}
+	root, ok := screen.(tcell.Screen)
+	if !ok {
+		return
+	}
+	screenWidth, screenHeight := root.Size()
+	if x+width > screenWidth {
+		width = screenWidth - x
+	}
+	if width <= 0 || x < 0 || y < 0 || y >= screenHeight {
+		return
+	}
+	hyperlinksLock.Lock()
+	hyperlinks = append(hyperlinks, Hyperlink{X: x, Y: y, Width: width, URL: url})
+	hyperlinksLock.Unlock()
+}
+
+// AddTextHyperlinks finds URLs in the given lines of text drawn at the top left corner of the screen and
+// registers them as links. URLs that were wrapped onto the following lines are joined back together.
+func AddTextHyperlinks(screen mauview.Screen, lines []string) {
+	width, _ := screen.Size()
+	for y, line := range lines {
+		for _, match := range URLRegex.FindAllStringIndex(line, -1) {
+			url := line[match[0]:match[1]]
+			x := runewidth.StringWidth(line[:match[0]])
+			segments := []Hyperlink{{X: x, Y: y, Width: runewidth.StringWidth(url)}}
+			// A URL that runs up to the right edge was probably cut off and continues on the next line.
+			wrapped := match[1] == len(line) && x+segments[0].Width >= width-1
+			for next := y + 1; wrapped && next < len(lines); next++ {
+				continuation := lines[next]
+				if end := strings.IndexFunc(continuation, isURLTerminator); end >= 0 {
+					continuation = continuation[:end]
+				}
+				continuation = strings.TrimRight(continuation, ".,;:!?)]")
+				if len(continuation) == 0 {
+					break
+				}
+				url += continuation
+				segment := Hyperlink{Y: next, Width: runewidth.StringWidth(continuation)}
+				segments = append(segments, segment)
+				wrapped = len(continuation) == len(lines[next]) && segment.Width >= width-1
+			}
+			for _, segment := range segments {
+				AddHyperlink(screen, segment.X, segment.Y, segment.Width, url)
+			}
+		}
+	}
+}
+
+func isURLTerminator(r rune) bool {
+	return r == ' ' || r == '\t' || r == '"' || r == '\'' || r == '<' || r == '>'
+}
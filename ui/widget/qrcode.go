@@ -0,0 +1,61 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package widget
+
+import (
+	"maunium.net/go/mauview"
+	"maunium.net/go/tcell"
+
+	"maunium.net/go/gomuks/lib/qrcode"
+)
+
+// QRQuietZone is the number of light modules drawn around QR codes.
+const QRQuietZone = 2
+
+// QRCode is a mauview component that renders a QR code using half block characters,
+// so that each terminal cell contains two vertically stacked modules.
+type QRCode struct {
+	mauview.SimpleEventHandler
+	Code *qrcode.Code
+}
+
+// QRCodeSize returns the width and height in terminal cells needed to draw the given code.
+func QRCodeSize(code *qrcode.Code) (width, height int) {
+	modules := code.Size + QRQuietZone*2
+	return modules, (modules + 1) / 2
+}
+
+func (qr *QRCode) Draw(screen mauview.Screen) {
+	if qr.Code == nil {
+		return
+	}
+	moduleColor := func(x, y int) tcell.Color {
+		if qr.Code.Get(x-QRQuietZone, y-QRQuietZone) {
+			return tcell.ColorBlack
+		}
+		return tcell.ColorWhite
+	}
+	width, height := QRCodeSize(qr.Code)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			style := tcell.StyleDefault.
+				Foreground(moduleColor(x, y*2)).
+				Background(moduleColor(x, y*2+1))
+			screen.SetContent(x, y, '▀', nil, style)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// gomuks - A terminal Matrix client written in Go.
+// Copyright (C) 2020 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"maunium.net/go/mautrix/event"
+
+	"maunium.net/go/gomuks/ui/messages"
+)
+
+type yankFormat string
+
+const (
+	YankPlain     yankFormat = "text"
+	YankFormatted yankFormat = "formatted"
+	YankSender    yankFormat = "sender"
+	YankEventID   yankFormat = "id"
+)
+
+var yankFormats = []yankFormat{YankPlain, YankFormatted, YankSender, YankEventID}
+
+func (format yankFormat) format(msg *messages.UIMessage) string {
+	switch format {
+	case YankFormatted:
+		if msg.Event != nil {
+			if content, ok := msg.Event.Content.Parsed.(*event.MessageEventContent); ok && len(content.FormattedBody) > 0 {
+				return content.FormattedBody
+			}
+		}
+		return msg.PlainText()
+	case YankSender:
+		return fmt.Sprintf("[%s %s] %s: %s", msg.FormatDate(), msg.FormatTime(), msg.SenderName, msg.PlainText())
+	case YankEventID:
+		return string(msg.EventID)
+	default:
+		return msg.PlainText()
+	}
+}
+
+// messagesBetween returns the non-service messages between the two given messages (inclusive) in chronological order.
+func (view *MessageView) messagesBetween(a, b *messages.UIMessage) []*messages.UIMessage {
+	view.messagesLock.RLock()
+	defer view.messagesLock.RUnlock()
+	start, end := -1, -1
+	for i, msg := range view.messages {
+		if msg == a || msg == b {
+			if start == -1 {
+				start = i
+			}
+			end = i
+		}
+	}
+	if start == -1 {
+		return nil
+	}
+	var between []*messages.UIMessage
+	for _, msg := range view.messages[start : end+1] {
+		if !msg.IsService {
+			between = append(between, msg)
+		}
+	}
+	return between
+}
+
+// SetSelectionRange highlights the given messages in addition to the selected message.
+func (view *MessageView) SetSelectionRange(msgs []*messages.UIMessage) {
+	for _, msg := range view.selectionRange {
+		if msg != view.selected {
+			msg.IsSelected = false
+		}
+	}
+	view.selectionRange = msgs
+	for _, msg := range msgs {
+		msg.IsSelected = true
+	}
+}
+
+// ToggleVisualSelection starts or stops selecting a range of messages from the currently selected one.
+func (view *RoomView) ToggleVisualSelection() {
+	msgView := view.MessageView()
+	if view.selectAnchor != nil {
+		view.selectAnchor = nil
+		msgView.SetSelectionRange(nil)
+	} else if msgView.selected != nil {
+		view.selectAnchor = msgView.selected
+		view.updateSelectionRange()
+	}
+	view.status.SetText(view.GetStatus())
+}
+
+func (view *RoomView) updateSelectionRange() {
+	if view.selectAnchor == nil {
+		return
+	}
+	msgView := view.MessageView()
+	if msgView.selected == nil {
+		msgView.SetSelectionRange(nil)
+	} else {
+		msgView.SetSelectionRange(msgView.messagesBetween(view.selectAnchor, msgView.selected))
+	}
+	view.status.SetText(view.GetStatus())
+}
+
+func (view *RoomView) clearSelectionRange() {
+	view.selectAnchor = nil
+	view.MessageView().SetSelectionRange(nil)
+}
+
+// Yank copies the given message, or the whole visual selection if there is one, to the clipboard.
+func (view *RoomView) Yank(message *messages.UIMessage, content string) {
+	format, register := YankPlain, "clipboard"
+	if parts := strings.Fields(content); len(parts) == 2 {
+		format, register = yankFormat(parts[0]), parts[1]
+	}
+	msgs := []*messages.UIMessage{message}
+	if view.selectAnchor != nil {
+		msgs = view.MessageView().messagesBetween(view.selectAnchor, message)
+	}
+	lines := make([]string, len(msgs))
+	for i, msg := range msgs {
+		lines[i] = format.format(msg)
+	}
+	go func() {
+		view.CopyToClipboard(strings.Join(lines, "\n"), register)
+		if len(msgs) > 1 {
+			view.AddServiceMessage(fmt.Sprintf("Copied %d messages", len(msgs)))
+			view.parent.parent.Render()
+		}
+	}()
+}
+
+func cmdYank(cmd *Command) {
+	format, register := YankPlain, "clipboard"
+	for _, arg := range cmd.Args {
+		if arg == "clipboard" || arg == "primary" {
+			register = arg
+			continue
+		}
+		found := false
+		for _, f := range yankFormats {
+			if yankFormat(arg) == f {
+				format, found = f, true
+			}
+		}
+		if !found {
+			cmd.Reply("Usage: /yank [text|formatted|sender|id] [clipboard|primary]")
+			return
+		}
+	}
+	cmd.Room.StartSelecting(SelectYank, fmt.Sprintf("%s %s", format, register))
+}